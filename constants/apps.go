@@ -382,3 +382,41 @@ var APP_PACKAGES_ANDROID = map[string]string{
 	"Whatsapp":                 "com.whatsapp",
 	"WhatsApp":                 "com.whatsapp",
 }
+
+// APP_PACKAGES_HARMONY are the bundles of the HarmonyOS NEXT apps, which
+// differ from their Android packages.
+var APP_PACKAGES_HARMONY = map[string]string{
+	// Social & Messaging
+	"微信": "com.tencent.wechat",
+	"QQ": "com.tencent.mqq",
+	"微博": "com.sina.weibo.stage",
+	// E-commerce
+	"淘宝":  "com.taobao.taobao4hmos",
+	"京东":  "com.jd.hm.mall",
+	"拼多多": "com.xunmeng.pinduoduo.hos",
+	// Lifestyle & Social
+	"小红书": "com.xingin.xhs_hos",
+	"知乎":  "com.zhihu.hmos",
+	// Maps & Navigation
+	"高德地图": "com.amap.hmapp",
+	"百度地图": "com.baidu.hmmap",
+	// Food & Services
+	"美团":  "com.sankuai.hmeituan",
+	"支付宝": "com.alipay.mobile.client",
+	// Video & Music
+	"抖音":       "com.ss.hm.ugc.aweme",
+	"bilibili": "yylx.danmaku.bili",
+	"哔哩哔哩":     "yylx.danmaku.bili",
+	"网易云音乐":    "com.netease.cloudmusic.hm",
+	// System
+	"设置":       "com.huawei.hmos.settings",
+	"浏览器":      "com.huawei.hmos.browser",
+	"相机":       "com.huawei.hmos.camera",
+	"图库":       "com.huawei.hmos.photos",
+	"文件管理":     "com.huawei.hmos.filemanager",
+	"日历":       "com.huawei.hmos.calendar",
+	"时钟":       "com.huawei.hmos.clock",
+	"备忘录":      "com.huawei.hmos.notepad",
+	"应用市场":     "com.huawei.hmsapp.appgallery",
+	"Settings": "com.huawei.hmos.settings",
+}
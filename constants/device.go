@@ -3,4 +3,7 @@ package constants
 const (
 	ADB = "adb" // Android Debug Bridge
 	IOS = "ios" // iOS WebDriverAgent
+	HDC = "hdc" // HarmonyOS Device Connector
+
+	APPIUM = "appium" // Appium / W3C WebDriver server
 )
@@ -23,6 +23,13 @@ var (
 		"time_to_first_token":       "首 Token 延迟 (TTFT)",
 		"time_to_thinking_end":      "思考完成延迟",
 		"total_inference_time":      "总推理时间",
+		"thinking_folded":           "%s…（共 %d 字，--verbose 查看全部）",
+		"thinking_limit":            "思考已超出长度限制。不要继续思考，根据以上思考立即输出下一步动作。",
+		"device_reconnected":        "** 设备 **\n\n设备曾断开连接并已重新连接，上一步动作可能未执行，屏幕可能已变化。请根据当前截图重新确认状态后继续任务。",
+		"session_resumed":           "** 会话 **\n\n任务曾中断，现已从上次完成的步骤恢复，之前的截图未保留，屏幕可能已变化。请根据当前截图确认状态后继续任务。",
+		"follow_up":                 "** 新指令 **\n\n上一条指令已结束。请在当前会话中继续执行用户的新指令，可参考之前的步骤：%s",
+		"action_undone":             "** 撤销 **\n\n用户撤销了上一步操作（已按返回键），屏幕可能已变化。请根据当前截图确认状态，不要重复被撤销的操作。",
+		"macro_actions":             "** 脚本操作 **\n\n以下操作由脚本直接执行，未经过你的判断，屏幕可能已变化。请根据当前截图确认状态：\n%s",
 	}
 
 	MESSAGES_EN_MAP = map[string]string{
@@ -47,5 +54,105 @@ var (
 		"time_to_first_token":       "Time to First Token (TTFT)",
 		"time_to_thinking_end":      "Time to Thinking End",
 		"total_inference_time":      "Total Inference Time",
+		"thinking_folded":           "%s… (%d chars, --verbose to show all)",
+		"thinking_limit":            "Your thinking exceeded the length limit. Do not think further; output the next action now based on the thinking above.",
+		"device_reconnected":        "** Device **\n\nThe device was disconnected and has reconnected. The previous action may not have been performed and the screen may have changed. Check the current screenshot before continuing the task.",
+		"session_resumed":           "** Session **\n\nThe task was interrupted and has been resumed from its last completed step. Earlier screenshots were not kept and the screen may have changed. Check the current screenshot before continuing the task.",
+		"follow_up":                 "** New instruction **\n\nThe previous instruction has ended. Carry out the user's new instruction in this session, building on the earlier steps: %s",
+		"action_undone":             "** Undo **\n\nThe user undid the last action by pressing Back, and the screen may have changed. Check the current screenshot and do not repeat the undone action.",
+		"macro_actions":             "** Script actions **\n\nThe script ran these actions itself, without you, and the screen may have changed. Check the current screenshot:\n%s",
+	}
+
+	MESSAGES_JA_MAP = map[string]string{
+		"thinking":                  "思考過程",
+		"action":                    "アクション",
+		"task_completed":            "タスク完了",
+		"done":                      "完了",
+		"starting_task":             "タスクを開始します",
+		"final_result":              "最終結果",
+		"task_result":               "タスク結果",
+		"confirmation_required":     "確認が必要です",
+		"continue_prompt":           "続行しますか？(y/n)",
+		"manual_operation_required": "手動操作が必要です",
+		"manual_operation_hint":     "操作を手動で完了してください...",
+		"press_enter_when_done":     "完了したら Enter を押してください",
+		"connection_failed":         "接続に失敗しました",
+		"connection_successful":     "接続に成功しました",
+		"step":                      "ステップ",
+		"task":                      "タスク",
+		"result":                    "結果",
+		"performance_metrics":       "パフォーマンス指標",
+		"time_to_first_token":       "最初のトークンまでの時間 (TTFT)",
+		"time_to_thinking_end":      "思考完了までの時間",
+		"total_inference_time":      "推論時間の合計",
+		"thinking_folded":           "%s…（全 %d 文字、--verbose ですべて表示）",
+		"thinking_limit":            "思考が長さの上限を超えました。これ以上考えず、上記の思考に基づいて次のアクションを直ちに出力してください。",
+		"device_reconnected":        "** デバイス **\n\nデバイスの接続が切れ、再接続されました。前のアクションは実行されていない可能性があり、画面が変わっている可能性があります。現在のスクリーンショットで状態を確認してからタスクを続けてください。",
+		"session_resumed":           "** セッション **\n\nタスクが中断され、最後に完了したステップから再開されました。以前のスクリーンショットは保持されておらず、画面が変わっている可能性があります。現在のスクリーンショットで状態を確認してからタスクを続けてください。",
+		"follow_up":                 "** 新しい指示 **\n\n前の指示は終了しました。これまでのステップを踏まえ、このセッションでユーザーの新しい指示を実行してください：%s",
+		"action_undone":             "** 取り消し **\n\nユーザーが戻るキーで前のアクションを取り消しました。画面が変わっている可能性があります。現在のスクリーンショットで状態を確認し、取り消されたアクションを繰り返さないでください。",
+		"macro_actions":             "** スクリプトの操作 **\n\n以下の操作はスクリプトが直接実行したもので、画面が変わっている可能性があります。現在のスクリーンショットで状態を確認してください：\n%s",
+	}
+
+	MESSAGES_KO_MAP = map[string]string{
+		"thinking":                  "사고 과정",
+		"action":                    "동작",
+		"task_completed":            "작업 완료",
+		"done":                      "완료",
+		"starting_task":             "작업 시작",
+		"final_result":              "최종 결과",
+		"task_result":               "작업 결과",
+		"confirmation_required":     "확인 필요",
+		"continue_prompt":           "계속하시겠습니까? (y/n)",
+		"manual_operation_required": "수동 조작 필요",
+		"manual_operation_hint":     "조작을 직접 완료해 주세요...",
+		"press_enter_when_done":     "완료되면 Enter 키를 누르세요",
+		"connection_failed":         "연결 실패",
+		"connection_successful":     "연결 성공",
+		"step":                      "단계",
+		"task":                      "작업",
+		"result":                    "결과",
+		"performance_metrics":       "성능 지표",
+		"time_to_first_token":       "첫 토큰까지의 시간 (TTFT)",
+		"time_to_thinking_end":      "사고 완료까지의 시간",
+		"total_inference_time":      "총 추론 시간",
+		"thinking_folded":           "%s… (총 %d자, --verbose로 전체 보기)",
+		"thinking_limit":            "사고가 길이 제한을 초과했습니다. 더 이상 생각하지 말고 위의 사고를 바탕으로 다음 동작을 즉시 출력하세요.",
+		"device_reconnected":        "** 기기 **\n\n기기의 연결이 끊겼다가 다시 연결되었습니다. 이전 동작이 실행되지 않았을 수 있고 화면이 바뀌었을 수 있습니다. 현재 스크린샷으로 상태를 확인한 뒤 작업을 계속하세요.",
+		"session_resumed":           "** 세션 **\n\n작업이 중단되었다가 마지막으로 완료된 단계부터 재개되었습니다. 이전 스크린샷은 보관되지 않았으며 화면이 바뀌었을 수 있습니다. 현재 스크린샷으로 상태를 확인한 뒤 작업을 계속하세요.",
+		"follow_up":                 "** 새 지시 **\n\n이전 지시가 끝났습니다. 지금까지의 단계를 바탕으로 이 세션에서 사용자의 새 지시를 수행하세요: %s",
+		"action_undone":             "** 실행 취소 **\n\n사용자가 뒤로 키를 눌러 이전 동작을 취소했습니다. 화면이 바뀌었을 수 있습니다. 현재 스크린샷으로 상태를 확인하고 취소된 동작을 반복하지 마세요.",
+		"macro_actions":             "** 스크립트 동작 **\n\n다음 동작은 스크립트가 직접 실행했으며 화면이 바뀌었을 수 있습니다. 현재 스크린샷으로 상태를 확인하세요:\n%s",
+	}
+
+	MESSAGES_ES_MAP = map[string]string{
+		"thinking":                  "Razonamiento",
+		"action":                    "Acción",
+		"task_completed":            "Tarea completada",
+		"done":                      "Hecho",
+		"starting_task":             "Iniciando la tarea",
+		"final_result":              "Resultado final",
+		"task_result":               "Resultado de la tarea",
+		"confirmation_required":     "Se requiere confirmación",
+		"continue_prompt":           "¿Continuar? (s/n)",
+		"manual_operation_required": "Se requiere una operación manual",
+		"manual_operation_hint":     "Complete la operación manualmente...",
+		"press_enter_when_done":     "Pulse Intro al terminar",
+		"connection_failed":         "Error de conexión",
+		"connection_successful":     "Conexión establecida",
+		"step":                      "Paso",
+		"task":                      "Tarea",
+		"result":                    "Resultado",
+		"performance_metrics":       "Métricas de rendimiento",
+		"time_to_first_token":       "Tiempo hasta el primer token (TTFT)",
+		"time_to_thinking_end":      "Tiempo hasta el fin del razonamiento",
+		"total_inference_time":      "Tiempo total de inferencia",
+		"thinking_folded":           "%s… (%d caracteres, --verbose para verlo todo)",
+		"thinking_limit":            "Tu razonamiento superó el límite de longitud. No sigas razonando; emite ya la siguiente acción a partir del razonamiento anterior.",
+		"device_reconnected":        "** Dispositivo **\n\nEl dispositivo se desconectó y se ha vuelto a conectar. Es posible que la acción anterior no se haya realizado y que la pantalla haya cambiado. Comprueba la captura de pantalla actual antes de continuar con la tarea.",
+		"session_resumed":           "** Sesión **\n\nLa tarea se interrumpió y se ha reanudado desde su último paso completado. No se conservaron las capturas anteriores y la pantalla puede haber cambiado. Comprueba la captura de pantalla actual antes de continuar con la tarea.",
+		"follow_up":                 "** Nueva instrucción **\n\nLa instrucción anterior ha terminado. Realiza la nueva instrucción del usuario en esta sesión, partiendo de los pasos anteriores: %s",
+		"action_undone":             "** Deshacer **\n\nEl usuario deshizo la última acción pulsando Atrás y la pantalla puede haber cambiado. Comprueba la captura de pantalla actual y no repitas la acción deshecha.",
+		"macro_actions":             "** Acciones del script **\n\nEl script ejecutó estas acciones por sí mismo y la pantalla puede haber cambiado. Comprueba la captura de pantalla actual:\n%s",
 	}
 )
@@ -14,7 +14,11 @@ const (
 
 操作指令及其作用如下：
 - do(action="Launch", app="xxx")  
-    Launch是启动目标app的操作，这比通过主屏幕导航更快。此操作完成后，您将自动收到结果状态的截图。
+    Launch是启动目标app的操作，这比通过主屏幕导航更快。此操作完成后，您将自动收到结果状态的截图。也可用 package="包名" 代替app，加 activity="xxx" 打开指定页面，或用 intent="android.intent.action.VIEW", uri="xxx" 打开链接。
+- do(action="Force_Stop", app="xxx")  
+    Force_Stop是强制停止app的操作，app卡住或需要重新启动时使用；也可用 package="包名"。
+- do(action="Clear_Data", app="xxx")  
+    Clear_Data是清除app全部数据的操作，app恢复到刚安装的状态，登录信息等也会丢失，仅在任务要求从初始状态开始时使用；也可用 package="包名"。
 - do(action="Tap", element=[x,y])  
     Tap是点击操作，点击屏幕上的特定点。可用此操作点击按钮、选择项目、从主屏幕打开应用程序，或与任何可点击的用户界面元素进行交互。坐标系统从左上角 (0,0) 开始到右下角（999,999)结束。此操作完成后，您将自动收到结果状态的截图。
 - do(action="Tap", element=[x,y], message="重要操作")  
@@ -32,7 +36,11 @@ const (
 - do(action="Call_API", instruction="xxx")  
     总结或评论当前页面或已记录的内容。
 - do(action="Long Press", element=[x,y])  
-    Long Pres是长按操作，在屏幕上的特定点长按指定时间。可用于触发上下文菜单、选择文本或激活长按交互。坐标系统从左上角 (0,0) 开始到右下角（999,999)结束。此操作完成后，您将自动收到结果状态的屏幕截图。
+    Long Pres是长按操作，在屏幕上的特定点长按指定时间。可用于触发上下文菜单、选择文本或激活长按交互。坐标系统从左上角 (0,0) 开始到右下角（999,999)结束。可加 duration=x 指定长按x秒。此操作完成后，您将自动收到结果状态的屏幕截图。
+- do(action="Drag", path=[[x1,y1],[x2,y2],...], hold=x)  
+    Drag是拖动操作，按住第一个点后依次经过路径上的各点，在最后一个点松开。先按住x秒（hold，可省略）可拖动需要长按才能移动的项目，如整理桌面图标、调整列表顺序；可加 duration=x 指定移动用时。此操作完成后，您将自动收到结果状态的截图。
+- do(action="Pinch", element=[x,y], scale=x)  
+    Pinch是双指缩放操作，以element为中心，scale大于1时双指张开放大（如2为放大两倍），小于1时双指捏合缩小。可用于放大地图、图片。此操作完成后，您将自动收到结果状态的截图。
 - do(action="Double Tap", element=[x,y])  
     Double Tap在屏幕上的特定点快速连续点按两次。使用此操作可以激活双击交互，如缩放、选择文本或打开项目。坐标系统从左上角 (0,0) 开始到右下角（999,999)结束。此操作完成后，您将自动收到结果状态的截图。
 - do(action="Take_over", message="xxx")  
@@ -41,8 +49,16 @@ const (
     导航返回到上一个屏幕或关闭当前对话框。相当于按下 Android 的返回按钮。使用此操作可以从更深的屏幕返回、关闭弹出窗口或退出当前上下文。此操作完成后，您将自动收到结果状态的截图。
 - do(action="Home") 
     Home是回到系统桌面的操作，相当于按下 Android 主屏幕按钮。使用此操作可退出当前应用并返回启动器，或从已知状态启动新任务。此操作完成后，您将自动收到结果状态的截图。
-- do(action="Wait", duration="x seconds")  
+- do(action="Wait", seconds=x)  
     等待页面加载，x为需要等待多少秒。
+- do(action="Wait_Until", text_appears="xxx", timeout=x)  
+    等待直到屏幕上出现文字xxx，最多等待x秒（默认10秒）。适用于加载页、转圈等待等场景，文字出现后立即继续，比反复 Wait 更可靠。
+- do(action="Dismiss_Overlay", index=x)  
+    关闭观察中“Overlays”部分列出的第x个悬浮窗（悬浮球、聊天头像、画中画等，默认第1个）。悬浮窗会挡住下层应用，点击落在悬浮窗区域时不会作用于下层应用，需要点击被遮挡的位置前先关闭它。
+- do(action="Open_Notification", index=x)  
+    点击观察中“Notifications”部分列出的第x条通知（默认第1条），打开对应的应用页面。查看消息、快递、验证码等通知时使用。
+- do(action="Dismiss_Notification", index=x)  
+    清除观察中“Notifications”部分列出的第x条通知（默认第1条）。
 - finish(message="xxx")  
     finish是结束任务的操作，表示准确完整完成任务，message是终止信息。 
 
@@ -110,18 +126,74 @@ Your output should STRICTLY follow the format:
   <answer>
   do(action="Long Press", element=[x,y])
   </answer>
+  Add duration=x to press for x seconds.
+- **Drag**
+  Press the first point of the path, move through the others and release on the last one. hold=x keeps the first point pressed for x seconds before moving, for items that move after a long press such as home screen icons; duration=x is how long the move takes.
+  **Example**:
+  <answer>
+  do(action="Drag", path=[[200,800],[500,600],[800,800]], hold=1)
+  </answer>
+- **Pinch**
+  Zoom with two fingers around the element: scale above 1 spreads them to zoom in (2 zooms in twice), below 1 pinches them to zoom out. Use it on maps and photos.
+  **Example**:
+  <answer>
+  do(action="Pinch", element=[500,500], scale=2)
+  </answer>
 - **Launch**
   Launch an app. Try to use launch action when you need to launch an app. Check the instruction to choose the right app before you use this action.
   **Example**:
   <answer>
   do(action="Launch", app="Settings")
   </answer>
+  Use package="..." instead of app for apps by package, add activity="..." to open a given screen, or intent="android.intent.action.VIEW", uri="..." to open a link.
+- **Force_Stop**
+  Force stop an app that is stuck or has to be restarted, by app or package.
+  **Example**:
+  <answer>
+  do(action="Force_Stop", app="Settings")
+  </answer>
+- **Clear_Data**
+  Delete all the data of an app, which starts again as freshly installed and signed out. Only use it when the task asks to start from a clean state.
+  **Example**:
+  <answer>
+  do(action="Clear_Data", package="com.android.settings")
+  </answer>
 - **Back**
   Press the Back button to navigate to the previous screen.
   **Example**:
   <answer>
   do(action="Back")
   </answer>
+- **Wait**
+  Wait for the page to load, for the given number of seconds.
+  **Example**:
+  <answer>
+  do(action="Wait", seconds=2)
+  </answer>
+- **Wait_Until**
+  Wait until the text appears on the screen, for at most timeout seconds (10 by default). Use it on loading screens instead of repeated Wait.
+  **Example**:
+  <answer>
+  do(action="Wait_Until", text_appears="Order placed", timeout=15)
+  </answer>
+- **Dismiss_Overlay**
+  Close overlay x listed in the Overlays section of the observation (floating windows, chat heads, picture-in-picture; 1 by default). Taps on the area of an overlay hit the overlay, not the app below it, so close it before tapping what it covers.
+  **Example**:
+  <answer>
+  do(action="Dismiss_Overlay", index=1)
+  </answer>
+- **Open_Notification**
+  Tap notification x listed in the Notifications section of the observation (1 by default), which opens the app where it came from. Use it to read messages, deliveries or codes learned from notifications.
+  **Example**:
+  <answer>
+  do(action="Open_Notification", index=1)
+  </answer>
+- **Dismiss_Notification**
+  Clear notification x listed in the Notifications section of the observation (1 by default).
+  **Example**:
+  <answer>
+  do(action="Dismiss_Notification", index=2)
+  </answer>
 - **Finish**
   Terminate the program and optionally print a message.
   **Example**:
@@ -2,19 +2,66 @@ package main
 
 import (
 	"bufio"
+	"cmp"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"autoglm-go/constants"
 	"autoglm-go/phoneagent"
+	"autoglm-go/phoneagent/android"
+	"autoglm-go/phoneagent/artifact"
+	"autoglm-go/phoneagent/calibration"
+	"autoglm-go/phoneagent/captcha"
+	"autoglm-go/phoneagent/configfile"
 	"autoglm-go/phoneagent/definitions"
+	"autoglm-go/phoneagent/dialog"
+	"autoglm-go/phoneagent/emulator"
+	"autoglm-go/phoneagent/fixture"
+	"autoglm-go/phoneagent/group"
+	"autoglm-go/phoneagent/health"
 	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/imaging"
+	"autoglm-go/phoneagent/ios"
+	"autoglm-go/phoneagent/labels"
+	"autoglm-go/phoneagent/llm"
+	"autoglm-go/phoneagent/logging"
+	"autoglm-go/phoneagent/memory"
+	"autoglm-go/phoneagent/metrics"
+	"autoglm-go/phoneagent/ocr"
+	"autoglm-go/phoneagent/policy"
+	"autoglm-go/phoneagent/pricing"
+	"autoglm-go/phoneagent/recorder"
+	"autoglm-go/phoneagent/redact"
+	"autoglm-go/phoneagent/respcache"
+	"autoglm-go/phoneagent/script"
+	"autoglm-go/phoneagent/seal"
+	"autoglm-go/phoneagent/server"
+	"autoglm-go/phoneagent/session"
+	"autoglm-go/phoneagent/setup"
+	"autoglm-go/phoneagent/suite"
+	"autoglm-go/phoneagent/tracing"
+	"autoglm-go/phoneagent/trajectory"
+	"autoglm-go/phoneagent/trigger"
+	"autoglm-go/phoneagent/uilang"
+	"autoglm-go/phoneagent/vault"
+	"autoglm-go/phoneagent/voice"
+	"autoglm-go/phoneagent/webhook"
 	"autoglm-go/utils"
 	"github.com/samber/lo"
 	"github.com/sashabaranov/go-openai"
@@ -24,26 +71,197 @@ import (
 
 // Config holds all the configuration values from command line arguments
 type Config struct {
+	Provider    string `json:"provider"`
 	BaseURL     string `json:"base_url"`
 	Model       string `json:"model"`
 	APIKey      string `json:"api_key"`
 	MaxSteps    int    `json:"max_steps"`
 	DeviceID    string `json:"device_id"`
+	AndroidUser string `json:"android_user"`
 	Connect     string `json:"connect"`
 	Disconnect  string `json:"disconnect"`
 	ListDevices bool   `json:"list_devices"`
 	EnableTCPIP int    `json:"enable_tcpip"`
 	GetDeviceIP string `json:"get_device_ip"`
+	ADBPair     string `json:"adb_pair"`
+	PairCode    string `json:"pair_code"`
+	Discover    bool   `json:"discover"`
+	PairedFile  string `json:"paired_file"`
+	Unpair      string `json:"unpair"`
 
 	WdaUrl     string `json:"wda_url"`
+	AppiumURL  string `json:"appium_url"`
+	AppiumCaps string `json:"appium_caps"`
 	Pair       bool   `json:"pair"`
 	WdaStatus  bool   `json:"wda_status"`
 	Quiet      bool   `json:"quiet"`
+	Verbose    bool   `json:"verbose"`
 	ListApps   bool   `json:"list_apps"`
 	Lang       string `json:"lang"`
+	UILang     string `json:"ui_lang"`
+	ReplyLang  string `json:"reply_lang"`
+	OutputLang string `json:"output_lang"`
+	I18nFiles  string `json:"i18n_files"`
 	DeviceType string `json:"device_type"`
 	Task       string `json:"task"`
 	Debug      bool   `json:"debug"`
+	LogFormat  string `json:"log_format"`
+	LogLevel   string `json:"log_level"`
+	UIDump     bool   `json:"ui_dump"`
+	WebCDP     bool   `json:"web_cdp"`
+	Grounding  bool   `json:"grounding"`
+	OCR        string `json:"ocr"`
+
+	Notifications bool `json:"notifications"`
+
+	KeyboardAPK string `json:"keyboard_apk"`
+
+	Observation string `json:"observation"`
+	InputLocale string `json:"input_locale"`
+
+	ResponseParser string `json:"response_parser"`
+	ActionMarkers  string `json:"action_markers"`
+
+	Plugins []string `json:"plugins"`
+	Script  string   `json:"script"`
+
+	ActionsFile string `json:"actions_file"`
+
+	ExportScript string `json:"export_script"`
+	ExportFormat string `json:"export_format"`
+
+	Voice        string `json:"voice"`
+	VoiceSeconds int    `json:"voice_seconds"`
+	TTS          string `json:"tts"`
+
+	TaskFile string `json:"task_file"`
+
+	PlannerModel   string `json:"planner_model"`
+	PlannerBaseURL string `json:"planner_base_url"`
+	PlannerAPIKey  string `json:"planner_apikey"`
+	Plan           bool   `json:"plan"`
+	JudgeModel     string `json:"judge_model"`
+	JudgeBaseURL   string `json:"judge_base_url"`
+	JudgeAPIKey    string `json:"judge_apikey"`
+	WarmModels     bool   `json:"warm_models"`
+	TriggersFile   string `json:"triggers_file"`
+	TriggerPort    int    `json:"trigger_port"`
+	GroupsFile     string `json:"groups_file"`
+	GroupsAddr     string `json:"groups_addr"`
+	ShowSettings   bool   `json:"show_settings"`
+	BatchWorkers   int    `json:"batch_workers"`
+	ServeAddr      string `json:"serve_addr"`
+	GRPCAddr       string `json:"grpc_addr"`
+	ServeWorkers   int    `json:"serve_workers"`
+	TenantsFile    string `json:"tenants_file"`
+	SetupProfiles  string `json:"setup_profiles"`
+	SchedulesFile  string `json:"schedules_file"`
+	Devices        string `json:"devices"`
+	TaskList       string `json:"task_list"`
+	Workers        int    `json:"workers"`
+	MaxInFlight    int    `json:"max_in_flight"`
+	RoutesFile     string `json:"routes_file"`
+	FallbacksFile  string `json:"fallbacks_file"`
+	PricingFile    string `json:"pricing_file"`
+
+	MaxQPS float64 `json:"max_qps"`
+	MaxTPM int     `json:"max_tpm"`
+
+	ResponseCacheTTL float64 `json:"response_cache_ttl"`
+
+	ModelProfile      string `json:"model_profile"`
+	ModelProfilesFile string `json:"model_profiles_file"`
+
+	Captcha         bool   `json:"captcha"`
+	CaptchaSolver   string `json:"captcha_solver"`
+	CaptchaLiveAddr string `json:"captcha_live_addr"`
+
+	DialogPolicy string `json:"dialog_policy"`
+	DialogRules  string `json:"dialog_rules"`
+
+	AutoUnlock bool   `json:"auto_unlock"`
+	DryRun     bool   `json:"dry_run"`
+	ReadOnly   bool   `json:"read_only"`
+	PolicyFile string `json:"policy_file"`
+	Redact     string `json:"redact"`
+	RedactFile string `json:"redact_file"`
+	VaultFile  string `json:"vault_file"`
+
+	MaxPolicyBlocks int `json:"max_policy_blocks"`
+	MaxRepeats      int `json:"max_repeats"`
+	MaxActionErrors int `json:"max_action_errors"`
+
+	UnchangedScreen string `json:"unchanged_screen"`
+
+	MaxTaskTokens int     `json:"max_task_tokens"`
+	MaxCost       float64 `json:"max_cost"`
+
+	Pacing string `json:"pacing"`
+
+	ArtifactKey   string `json:"artifact_key"`
+	ArtifactStore string `json:"artifact_store"`
+
+	Suite         string `json:"suite"`
+	SuiteParallel int    `json:"suite_parallel"`
+	ReportDir     string `json:"report_dir"`
+	EvalProfiles  string `json:"eval_profiles"`
+	Emulators     int    `json:"emulators"`
+	EmulatorAVD   string `json:"emulator_avd"`
+	EmulatorImage string `json:"emulator_image"`
+
+	SessionDir string `json:"session_dir"`
+	Resume     string `json:"resume"`
+	RecordDir  string `json:"record_dir"`
+
+	RecordSnapshots   bool   `json:"record_snapshots"`
+	RecordVideo       bool   `json:"record_video"`
+	Rewind            string `json:"rewind"`
+	RewindStep        int    `json:"rewind_step"`
+	RewindTask        string `json:"rewind_task"`
+	RewindObservation string `json:"rewind_observation"`
+
+	Replay       string `json:"replay"`
+	ReplayStrict bool   `json:"replay_strict"`
+	Compare      string `json:"compare"`
+	Macro        string `json:"macro"`
+
+	ExportDataset      string `json:"export_dataset"`
+	DatasetFrom        string `json:"dataset_from"`
+	DatasetOutcome     string `json:"dataset_outcome"`
+	DatasetImagePrefix string `json:"dataset_image_prefix"`
+
+	MemoryFile    string `json:"memory_file"`
+	ListMemories  bool   `json:"list_memories"`
+	PruneMemories string `json:"prune_memories"`
+	MemoryApp     string `json:"memory_app"`
+
+	DuplicateWindow int  `json:"duplicate_window"`
+	Force           bool `json:"force"`
+
+	Chaos string `json:"chaos"`
+
+	OutputSchema string `json:"output_schema"`
+
+	OutcomeTemplates string `json:"outcome_templates"`
+
+	Demonstrate bool   `json:"demonstrate"`
+	Skill       string `json:"skill"`
+
+	Calibrate       bool   `json:"calibrate"`
+	CalibrationFile string `json:"calibration_file"`
+
+	Watch         string `json:"watch"`
+	WatchInterval int    `json:"watch_interval"`
+
+	Webhooks      string `json:"webhooks"`
+	WebhookEvents string `json:"webhook_events"`
+
+	Labels string `json:"labels"`
+
+	OTLPEndpoint string `json:"otlp_endpoint"`
+	MetricsAddr  string `json:"metrics_addr"`
+
+	ConfigFile string `json:"config_file"`
 }
 
 var rootCmd = &cobra.Command{
@@ -131,8 +349,32 @@ func getEnvFloat32(key string, defaultValue float32) float32 {
 	return defaultValue
 }
 
+// Helper function to get environment variable as float64 with default value
+func getEnvFloat64(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
+// Helper function to get environment variable as bool with default value
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
 func init() {
 	// Model options
+	rootCmd.PersistentFlags().StringVar(&config.Provider, "provider",
+		getEnv("PHONE_AGENT_PROVIDER", llm.ProviderOpenAI),
+		"Model API kind: openai (also vLLM and other compatible servers), anthropic, gemini or ollama")
+
 	rootCmd.PersistentFlags().StringVar(&config.BaseURL, "base-url",
 		getEnv("PHONE_AGENT_BASE_URL", "https://open.bigmodel.cn/api/paas/v4"),
 		"Model API base URL")
@@ -145,6 +387,161 @@ func init() {
 		getEnv("PHONE_AGENT_API_KEY", "EMPTY"),
 		"API key for model authentication")
 
+	rootCmd.PersistentFlags().StringVar(&config.PlannerModel, "planner-model",
+		getEnv("PHONE_AGENT_PLANNER_MODEL", ""),
+		"Strong model that plans the task and takes over after repeated failures (default: off)")
+
+	rootCmd.PersistentFlags().StringVar(&config.PlannerBaseURL, "planner-base-url",
+		getEnv("PHONE_AGENT_PLANNER_BASE_URL", ""),
+		"Planner model API base URL (default: --base-url)")
+
+	rootCmd.PersistentFlags().StringVar(&config.PlannerAPIKey, "planner-apikey",
+		getEnv("PHONE_AGENT_PLANNER_API_KEY", ""),
+		"API key for the planner model (default: --apikey)")
+
+	rootCmd.PersistentFlags().BoolVar(&config.Plan, "plan",
+		getEnvBool("PHONE_AGENT_PLAN", false),
+		"Plan the task into subgoals with the main model when no --planner-model is set")
+
+	rootCmd.PersistentFlags().StringVar(&config.JudgeModel, "judge-model",
+		getEnv("PHONE_AGENT_JUDGE_MODEL", ""),
+		"Independent model that reviews the final screens and returns pass/fail with a reason (default: off)")
+
+	rootCmd.PersistentFlags().StringVar(&config.JudgeBaseURL, "judge-base-url",
+		getEnv("PHONE_AGENT_JUDGE_BASE_URL", ""),
+		"Judge model API base URL (default: --base-url)")
+
+	rootCmd.PersistentFlags().StringVar(&config.JudgeAPIKey, "judge-apikey",
+		getEnv("PHONE_AGENT_JUDGE_API_KEY", ""),
+		"API key for the judge model (default: --apikey)")
+
+	rootCmd.PersistentFlags().BoolVar(&config.WarmModels, "warm-models",
+		getEnvBool("PHONE_AGENT_WARM_MODELS", true),
+		"Connect to every model endpoint at startup and keep the connections warm, failing at once on a rejected API key")
+
+	rootCmd.PersistentFlags().StringVar(&config.TriggersFile, "triggers-file",
+		getEnv("PHONE_AGENT_TRIGGERS_FILE", ""),
+		"JSON file of named tasks the phone can start on itself through a home-screen page or widget URLs")
+
+	rootCmd.PersistentFlags().IntVar(&config.TriggerPort, "trigger-port",
+		getEnvInt("PHONE_AGENT_TRIGGER_PORT", 18765),
+		"Port of the trigger server, reversed to the same port on the device")
+
+	rootCmd.PersistentFlags().StringVar(&config.GroupsFile, "groups-file",
+		getEnv("PHONE_AGENT_GROUPS_FILE", ""),
+		"JSON file of hierarchical device groups; the groups of --device-id provide default settings")
+
+	rootCmd.PersistentFlags().StringVar(&config.GroupsAddr, "groups-addr",
+		getEnv("PHONE_AGENT_GROUPS_ADDR", ""),
+		"Serve the device group API and dashboard at this address and exit when interrupted (requires --groups-file)")
+
+	rootCmd.PersistentFlags().BoolVar(&config.ShowSettings, "show-settings", false,
+		"Print the effective settings of --device-id and where each comes from: flag, environment, device group or default, and exit")
+
+	rootCmd.PersistentFlags().IntVar(&config.BatchWorkers, "batch-workers",
+		getEnvInt("PHONE_AGENT_BATCH_WORKERS", 4),
+		"Max tasks batches of the group server run at the same time across devices")
+
+	rootCmd.PersistentFlags().StringVar(&config.ServeAddr, "serve-addr",
+		getEnv("PHONE_AGENT_SERVE_ADDR", ""),
+		"Serve the task API at this address, to submit, follow and cancel tasks on any device, and exit when interrupted")
+
+	rootCmd.PersistentFlags().StringVar(&config.GRPCAddr, "grpc-addr",
+		getEnv("PHONE_AGENT_GRPC_ADDR", ""),
+		"Serve the task API over gRPC at this address too, with the api keys of the task API (requires --serve-addr)")
+
+	rootCmd.PersistentFlags().IntVar(&config.ServeWorkers, "serve-workers",
+		getEnvInt("PHONE_AGENT_SERVE_WORKERS", 4),
+		"Max tasks of the task API running at the same time across devices")
+
+	rootCmd.PersistentFlags().StringVar(&config.TenantsFile, "tenants-file",
+		getEnv("PHONE_AGENT_TENANTS_FILE", ""),
+		"JSON file of the tenants of the task API: device pool and groups, weight, max concurrent tasks and daily token quota of each; tasks must be labeled tenant=<name>")
+
+	rootCmd.PersistentFlags().StringVar(&config.SetupProfiles, "setup-profiles",
+		getEnv("PHONE_AGENT_SETUP_PROFILES", ""),
+		"JSON file of the setup profiles of the task API devices: required apps, IME, animations and stay-awake, verified and applied as devices join")
+
+	rootCmd.PersistentFlags().StringVar(&config.SchedulesFile, "schedules-file",
+		getEnv("PHONE_AGENT_SCHEDULES_FILE", ""),
+		"File keeping the cron schedules of the task API and their runs across restarts (default: in memory only)")
+
+	rootCmd.PersistentFlags().StringVar(&config.Devices, "devices",
+		getEnv("PHONE_AGENT_DEVICES", ""),
+		"Run the task, or those of --task-list, on these devices at once: ids separated by commas, or all for every connected device")
+
+	rootCmd.PersistentFlags().StringVar(&config.TaskList, "task-list",
+		getEnv("PHONE_AGENT_TASK_LIST", ""),
+		"Text file of instructions, one per line, run in order on each device of --devices")
+
+	rootCmd.PersistentFlags().IntVar(&config.Workers, "workers",
+		getEnvInt("PHONE_AGENT_WORKERS", 0),
+		"Max devices of --devices running a task at the same time (default: all of them)")
+
+	rootCmd.PersistentFlags().StringVar(&config.Suite, "suite",
+		getEnv("PHONE_AGENT_SUITE", ""),
+		"JSON file of regression cases, each an instruction, a device or group and the expected outcome, run and reported to --report-dir")
+
+	rootCmd.PersistentFlags().IntVar(&config.SuiteParallel, "suite-parallel",
+		getEnvInt("PHONE_AGENT_SUITE_PARALLEL", 1),
+		"Cases of --suite running at the same time, on different devices")
+
+	rootCmd.PersistentFlags().StringVar(&config.ReportDir, "report-dir",
+		getEnv("PHONE_AGENT_REPORT_DIR", "report"),
+		"Directory where --suite writes report.json and report.html")
+
+	rootCmd.PersistentFlags().StringVar(&config.EvalProfiles, "eval-profiles",
+		getEnv("PHONE_AGENT_EVAL_PROFILES", ""),
+		"Model profiles of --model-profiles-file, separated by commas, main for the main model: --suite runs once per profile and compares their success rate, average steps and cost")
+
+	rootCmd.PersistentFlags().IntVar(&config.Emulators, "emulators",
+		getEnvInt("PHONE_AGENT_EMULATORS", 0),
+		"Headless Android emulators to start before the run, of --emulator-avd or --emulator-image, and to tear down after it; the first is the default device and --suite spreads its cases over them")
+
+	rootCmd.PersistentFlags().StringVar(&config.EmulatorAVD, "emulator-avd",
+		getEnv("PHONE_AGENT_EMULATOR_AVD", ""),
+		"AVD of the --emulators, started read-only with the emulator of the Android SDK")
+
+	rootCmd.PersistentFlags().StringVar(&config.EmulatorImage, "emulator-image",
+		getEnv("PHONE_AGENT_EMULATOR_IMAGE", ""),
+		"docker-android image of the --emulators instead of an AVD, e.g. budtmo/docker-android:emulator_14.0 (needs /dev/kvm)")
+
+	rootCmd.PersistentFlags().IntVar(&config.MaxInFlight, "max-inflight",
+		getEnvInt("PHONE_AGENT_MAX_INFLIGHT", 0),
+		"Max model requests at the same time, of all the models and devices of the process (default: --workers or --serve-workers for --devices and --serve-addr, otherwise unlimited)")
+
+	rootCmd.PersistentFlags().Float64Var(&config.MaxQPS, "max-qps",
+		getEnvFloat64("PHONE_AGENT_MAX_QPS", 0),
+		"Max model requests started per second, of all the models and devices of the process (default: unlimited)")
+
+	rootCmd.PersistentFlags().IntVar(&config.MaxTPM, "max-tpm",
+		getEnvInt("PHONE_AGENT_MAX_TPM", 0),
+		"Max prompt and completion tokens per minute, of all the models and devices of the process, as reported by their usage (default: unlimited)")
+
+	rootCmd.PersistentFlags().Float64Var(&config.ResponseCacheTTL, "response-cache-ttl",
+		getEnvFloat64("PHONE_AGENT_RESPONSE_CACHE_TTL", 0),
+		"Seconds the action the model repeatedly chose on a screen is reused for the same task, app and recent actions without asking the model (default: off)")
+
+	rootCmd.PersistentFlags().StringVar(&config.RoutesFile, "routes-file",
+		getEnv("PHONE_AGENT_ROUTES_FILE", ""),
+		"JSON list of cheaper models for easy steps, see definitions.RouteConfig")
+
+	rootCmd.PersistentFlags().StringVar(&config.FallbacksFile, "fallbacks-file",
+		getEnv("PHONE_AGENT_FALLBACKS_FILE", ""),
+		"JSON list of models tried in order when the main model keeps failing, see definitions.FallbackModel")
+
+	rootCmd.PersistentFlags().StringVar(&config.ModelProfilesFile, "model-profiles-file",
+		getEnv("PHONE_AGENT_MODEL_PROFILES_FILE", ""),
+		"JSON list of named models a task may run with instead of the main one, see definitions.ModelProfile")
+
+	rootCmd.PersistentFlags().StringVar(&config.ModelProfile, "model-profile",
+		getEnv("PHONE_AGENT_MODEL_PROFILE", ""),
+		"Model profile of --model-profiles-file the tasks run with unless the task API selects another (default: the main model)")
+
+	rootCmd.PersistentFlags().StringVar(&config.PricingFile, "pricing-file",
+		getEnv("PHONE_AGENT_PRICING_FILE", ""),
+		"File or http(s) URL of the pricing catalog, a JSON object of model name, or provider then model name, to price per 1000 prompt and completion tokens, see pricing.Catalog")
+
 	rootCmd.PersistentFlags().IntVar(&config.MaxSteps, "max-steps",
 		getEnvInt("PHONE_AGENT_MAX_STEPS", 100),
 		"Maximum steps per task")
@@ -154,6 +551,10 @@ func init() {
 		getEnv("PHONE_AGENT_DEVICE_ID", ""),
 		"ADB device ID")
 
+	rootCmd.PersistentFlags().StringVar(&config.AndroidUser, "android-user",
+		getEnv("PHONE_AGENT_ANDROID_USER", ""),
+		"Android user to launch apps as and install and push files to: a user id, or work for the work profile (adb only)")
+
 	rootCmd.PersistentFlags().StringVarP(&config.Connect, "connect", "c", "",
 		"Connect to remote device (e.g., 192.168.1.100:5555)")
 
@@ -170,11 +571,40 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&config.GetDeviceIP, "get-device-ip", "",
 		"Get device IP ")
 
+	rootCmd.PersistentFlags().StringVar(&config.ADBPair, "adb-pair", "",
+		"Pair with an Android 11+ device over wireless debugging at this pairing address, with --pair-code, connect it and keep it in --paired-file")
+
+	rootCmd.PersistentFlags().StringVar(&config.PairCode, "pair-code", "",
+		"Pairing code shown by the device in its wireless debugging settings; alone it pairs the only device waiting to be paired on the LAN")
+
+	rootCmd.PersistentFlags().BoolVar(&config.Discover, "discover", false,
+		"List the devices advertising wireless debugging or adb tcpip on the LAN (mDNS) and exit")
+
+	rootCmd.PersistentFlags().StringVar(&config.PairedFile, "paired-file",
+		getEnv("PHONE_AGENT_PAIRED_FILE", ""),
+		"JSON file of the devices paired with --adb-pair, connected again at startup at the address they advertise")
+
+	rootCmd.PersistentFlags().StringVar(&config.Unpair, "unpair", "",
+		"Remove a device, by mDNS name, serial or address, from --paired-file and disconnect it")
+
 	// iOS specific options
 	rootCmd.PersistentFlags().StringVar(&config.WdaUrl, "wda-url",
 		getEnv("PHONE_AGENT_WDA_URL", "http://localhost:8100"),
 		"WebDriverAgent URL for iOS (default: http://localhost:8100)")
 
+	// Appium specific options
+	rootCmd.PersistentFlags().StringVar(&config.AppiumURL, "appium-url",
+		getEnv("PHONE_AGENT_APPIUM_URL", "http://127.0.0.1:4723"),
+		"Appium server URL (default: http://127.0.0.1:4723)")
+
+	rootCmd.PersistentFlags().StringVar(&config.AppiumCaps, "appium-caps",
+		getEnv("PHONE_AGENT_APPIUM_CAPS", ""),
+		`Appium capabilities as JSON, e.g. {"platformName":"Android","appium:automationName":"UiAutomator2"}`)
+
+	rootCmd.PersistentFlags().StringVar(&config.KeyboardAPK, "keyboard-apk",
+		getEnv("PHONE_AGENT_KEYBOARD_APK", ""),
+		"ADB Keyboard APK installed and enabled on adb devices without it the first time they type; without it they type ASCII only")
+
 	rootCmd.PersistentFlags().BoolVar(&config.Pair, "pair", false,
 		"Pair with iOS device (required for some operations)")
 
@@ -182,9 +612,16 @@ func init() {
 		"Show WebDriverAgent status and exit (iOS only)")
 
 	// Other options
+	rootCmd.PersistentFlags().StringVar(&config.ConfigFile, "config",
+		getEnv("PHONE_AGENT_CONFIG", ""),
+		"YAML or TOML config file of flags by name and PHONE_AGENT_* variables without the prefix, below the command line and the environment; max-steps, temperature and log-level are reloaded when it changes")
+
 	rootCmd.PersistentFlags().BoolVarP(&config.Quiet, "quiet", "q", false,
 		"Suppress verbose output")
 
+	rootCmd.PersistentFlags().BoolVar(&config.Verbose, "verbose", false,
+		"Stream the model's whole thinking instead of a one-line summary")
+
 	rootCmd.PersistentFlags().BoolVar(&config.ListApps, "list-apps", false,
 		"List supported apps and exit")
 
@@ -193,35 +630,390 @@ func init() {
 		getEnv("PHONE_AGENT_LANG", "cn"),
 		"Language for system prompt (cn or en, default: cn)")
 
+	rootCmd.PersistentFlags().StringVar(&config.UILang, "ui-lang",
+		getEnv("PHONE_AGENT_UI_LANG", ""),
+		"Language of the device UI as a BCP 47 tag, e.g. ja, de or pt-BR (default: --lang)")
+
+	rootCmd.PersistentFlags().StringVar(&config.ReplyLang, "reply-lang",
+		getEnv("PHONE_AGENT_REPLY_LANG", ""),
+		"Language of finish messages, Take_over requests and confirmations as a BCP 47 tag, messages in another script are translated (default: as written by the model)")
+
+	rootCmd.PersistentFlags().StringVar(&config.InputLocale, "input-locale",
+		getEnv("PHONE_AGENT_INPUT_LOCALE", ""),
+		"Locale of the dates, decimal numbers and phone numbers typed from placeholders such as {{date:+1}}, as a BCP 47 tag like de-DE or auto for the device's (default: off, text is typed as written)")
+
+	rootCmd.PersistentFlags().StringVar(&config.OutputLang, "output-lang",
+		getEnv("PHONE_AGENT_OUTPUT_LANG", ""),
+		"Language of the terminal output, such as thinking, results and performance metrics: cn, en, ja, ko, es or one of --i18n-files (default: --lang)")
+
+	rootCmd.PersistentFlags().StringVar(&config.I18nFiles, "i18n-files",
+		getEnv("PHONE_AGENT_I18N_FILES", ""),
+		"Message bundles to load, JSON or TOML files or directories of them separated by commas, adding languages or overriding messages")
+
 	rootCmd.PersistentFlags().StringVar(
 		&config.DeviceType,
 		"device-type",
 		"adb",
-		"Device type: adb for Android, ios for iPhone (default: adb)",
+		"Device type: adb for Android, ios for iPhone, hdc for HarmonyOS NEXT, appium for an Appium server (default: adb)",
 	)
 
 	rootCmd.PersistentFlags().BoolVar(&config.Debug, "debug", false,
 		"Enable debug mode (default: false)")
 
-}
+	rootCmd.PersistentFlags().StringVar(&config.LogFormat, "log-format",
+		getEnv("PHONE_AGENT_LOG_FORMAT", logging.FormatText),
+		"Log format: text, or json for one record per line with session_id, step, device_id and event_type")
+
+	rootCmd.PersistentFlags().StringVar(&config.LogLevel, "log-level",
+		getEnv("PHONE_AGENT_LOG_LEVEL", ""),
+		"Log levels, a default and component=level pairs, e.g. info,llm=debug,android=warn (default: info, debug with --debug)")
+
+	rootCmd.PersistentFlags().BoolVar(&config.UIDump, "ui-dump", false,
+		"Send the UI hierarchy (only changes after the first step) along with screenshots")
+
+	rootCmd.PersistentFlags().BoolVar(&config.Notifications, "notifications",
+		getEnvBool("PHONE_AGENT_NOTIFICATIONS", false),
+		"List the notifications of the device (adb only) in each observation, to open or dismiss them with Open_Notification and Dismiss_Notification")
+
+	rootCmd.PersistentFlags().StringVar(&config.Observation, "observation",
+		getEnv("PHONE_AGENT_OBSERVATION", phoneagent.ObservationImage),
+		"What the model sees each step: image, image+tree or tree (UI hierarchy only, for models without vision)")
+	rootCmd.PersistentFlags().StringVar(&config.ResponseParser, "response-parser",
+		getEnv("PHONE_AGENT_RESPONSE_PARSER", llm.ParserText),
+		"Grammar of the model responses: text for do(...) and finish(...) calls, or json for an action object such as {\"action\": \"Tap\", \"element\": [500, 100]}")
+	rootCmd.PersistentFlags().StringVar(&config.ActionMarkers, "action-markers",
+		getEnv("PHONE_AGENT_ACTION_MARKERS", ""),
+		"Comma separated extra texts introducing the action in the responses, e.g. Action:, not part of the action")
+
+	rootCmd.PersistentFlags().BoolVar(&config.WebCDP, "web-cdp", false,
+		"Control Chrome tabs and debuggable WebViews through the DevTools protocol (adb only)")
+
+	rootCmd.PersistentFlags().BoolVar(&config.Grounding, "grounding", false,
+		"Retry taps that change nothing on the element the model named, using the UI hierarchy")
+
+	rootCmd.PersistentFlags().StringVar(&config.OCR, "ocr",
+		getEnv("PHONE_AGENT_OCR", ""),
+		"Local OCR engine locating taps given by label or off the screen when the UI hierarchy lacks them: tesseract")
+
+	rootCmd.PersistentFlags().StringArrayVar(&config.Plugins, "plugin", nil,
+		"Command line of an external action plugin, can be repeated")
+	rootCmd.PersistentFlags().StringVar(&config.ActionsFile, "actions-file",
+		getEnv("PHONE_AGENT_ACTIONS_FILE", ""),
+		"JSON list of custom actions run as a shell command or plugin program on the devices or groups they are rolled out to, see phoneagent.ConfigAction")
+
+	rootCmd.PersistentFlags().StringVar(&config.Script, "script",
+		getEnv("PHONE_AGENT_SCRIPT", ""),
+		"Lua script run after every step, see phoneagent/script")
+
+	rootCmd.PersistentFlags().StringVar(&config.ExportScript, "export-script", "",
+		"Write successful task runs as a replay script to this file")
+
+	rootCmd.PersistentFlags().StringVar(&config.ExportFormat, "export-format", trajectory.FormatADB,
+		"Replay script format: adb, appium-python or json (default: adb)")
+
+	rootCmd.PersistentFlags().StringVar(&config.Voice, "voice", "",
+		"Audio file with the spoken task, or 'mic' to record it from the microphone")
+
+	rootCmd.PersistentFlags().IntVar(&config.VoiceSeconds, "voice-seconds", 5,
+		"Recording length in seconds for --voice mic and the interactive 'voice' command (default: 5)")
+
+	rootCmd.PersistentFlags().StringVar(&config.TTS, "tts",
+		getEnv("PHONE_AGENT_TTS", ""),
+		"Read finish messages and confirmation prompts aloud: system or openai (default: off)")
+
+	rootCmd.PersistentFlags().StringVar(&config.TaskFile, "task-file", "",
+		"JSON task file with the task and the fixtures to provision before it, see phoneagent/fixture")
+
+	rootCmd.PersistentFlags().BoolVar(&config.Captcha, "captcha", false,
+		"Detect captcha screens and hand them to the solver, then to a human via a live view")
+
+	rootCmd.PersistentFlags().StringVar(&config.CaptchaSolver, "captcha-solver",
+		getEnv("PHONE_AGENT_CAPTCHA_SOLVER", ""),
+		"Command line of an external captcha solver, see phoneagent/captcha/command.go")
+
+	rootCmd.PersistentFlags().StringVar(&config.CaptchaLiveAddr, "captcha-live-addr",
+		getEnv("PHONE_AGENT_CAPTCHA_LIVE_ADDR", "127.0.0.1:0"),
+		"Listen address of the captcha live view (default: a free local port)")
+
+	rootCmd.PersistentFlags().StringVar(&config.DialogPolicy, "dialog-policy",
+		getEnv("PHONE_AGENT_DIALOG_POLICY", ""),
+		"Close system dialogs before the model sees them: dismiss, accept or ignore, per kind as update=accept,rating=dismiss (default: off)")
+
+	rootCmd.PersistentFlags().StringVar(&config.DialogRules, "dialog-rules",
+		getEnv("PHONE_AGENT_DIALOG_RULES", ""),
+		"JSON file of extra dialog rules, checked before the built-in ones, see phoneagent/dialog")
+
+	rootCmd.PersistentFlags().BoolVar(&config.DryRun, "dry-run",
+		getEnvBool("PHONE_AGENT_DRY_RUN", false),
+		"Observe the device and ask the model as usual, but only log the actions instead of performing them")
+
+	rootCmd.PersistentFlags().BoolVar(&config.ReadOnly, "read-only",
+		getEnvBool("PHONE_AGENT_READ_ONLY", false),
+		"Only observe the device and answer questions about it, refusing every action that would operate it")
+
+	rootCmd.PersistentFlags().StringVar(&config.PolicyFile, "policy-file",
+		getEnv("PHONE_AGENT_POLICY_FILE", ""),
+		"YAML safety policy that denies actions or asks to confirm them before they run, see phoneagent/policy")
+	rootCmd.PersistentFlags().IntVar(&config.MaxPolicyBlocks, "max-policy-blocks",
+		getEnvInt("PHONE_AGENT_MAX_POLICY_BLOCKS", 3),
+		"Stop the task when the safety policy denies this many actions in a row, 0 never stops it")
+	rootCmd.PersistentFlags().IntVar(&config.MaxRepeats, "max-repeats",
+		getEnvInt("PHONE_AGENT_MAX_REPEATS", 5),
+		"Stop the task when the model takes the same action on an unchanged screen this many times in a row, 0 never stops it")
+	rootCmd.PersistentFlags().StringVar(&config.UnchangedScreen, "unchanged-screen",
+		getEnv("PHONE_AGENT_UNCHANGED_SCREEN", ""),
+		"When the screen did not change since the last step: annotate tells the model, reuse also repeats a Wait without asking the model")
+	rootCmd.PersistentFlags().IntVar(&config.MaxActionErrors, "max-action-errors",
+		getEnvInt("PHONE_AGENT_MAX_ACTION_ERRORS", 3),
+		"Report failed actions to the model to recover from and stop the task when this many fail in a row, 0 stops at the first action the device fails to run")
+	rootCmd.PersistentFlags().IntVar(&config.MaxTaskTokens, "max-task-tokens",
+		getEnvInt("PHONE_AGENT_MAX_TASK_TOKENS", 0),
+		"Stop the task once its model requests used this many tokens, 0 is unlimited")
+	rootCmd.PersistentFlags().Float64Var(&config.MaxCost, "max-cost",
+		getEnvFloat64("PHONE_AGENT_MAX_COST", 0),
+		"Stop the task once the estimated cost of its model requests reaches this, 0 is unlimited")
+
+	rootCmd.PersistentFlags().StringVar(&config.Pacing, "pacing",
+		getEnv("PHONE_AGENT_PACING", ""),
+		"Pause after every step for apps that flag fast automation: fast (no pause), normal (1s), careful (3s) or a duration")
+
+	rootCmd.PersistentFlags().StringVar(&config.Redact, "redact",
+		getEnv("PHONE_AGENT_REDACT", ""),
+		"Comma-separated sensitive texts masked on the screens before the model sees them: phone, bank_card, id_card, email")
+
+	rootCmd.PersistentFlags().StringVar(&config.RedactFile, "redact-file",
+		getEnv("PHONE_AGENT_REDACT_FILE", ""),
+		"JSON file of the texts, apps and screen regions to mask before the model sees the screens, see definitions.RedactConfig")
+
+	rootCmd.PersistentFlags().BoolVar(&config.AutoUnlock, "auto-unlock",
+		getEnvBool("PHONE_AGENT_AUTO_UNLOCK", false),
+		"Unlock a locked device at task start with the credential of the vault, otherwise such tasks fail")
+
+	rootCmd.PersistentFlags().StringVar(&config.VaultFile, "vault-file",
+		getEnv("PHONE_AGENT_VAULT_FILE", ""),
+		"JSON file of secrets such as unlock credentials, e.g. {\"unlock\": \"pin:1234\"}, never sent to the model")
+
+	rootCmd.PersistentFlags().StringVar(&config.ArtifactKey, "artifact-key",
+		getEnv("PHONE_AGENT_ARTIFACT_KEY", ""),
+		"Encrypt the stored screenshots, recordings, sessions and trajectories with this 32 byte key: file:PATH, env:NAME or cmd:LINE, e.g. a KMS decrypting a data key")
+	rootCmd.PersistentFlags().StringVar(&config.ArtifactStore, "artifact-store",
+		getEnv("PHONE_AGENT_ARTIFACT_STORE", ""),
+		"Store the screenshots and videos of the records under content-addressed keys in a directory or bucket: /path, s3://bucket/prefix?region=... or oss://bucket/prefix?endpoint=...")
+
+	rootCmd.PersistentFlags().StringVar(&config.SessionDir, "session-dir",
+		getEnv("PHONE_AGENT_SESSION_DIR", ""),
+		"Directory where tasks are saved after every step so that they can be resumed (default: not saved)")
+
+	rootCmd.PersistentFlags().StringVar(&config.Resume, "resume", "",
+		"Resume the session with this id from its last completed step, requires --session-dir")
+
+	rootCmd.PersistentFlags().StringVar(&config.RecordDir, "record-dir",
+		getEnv("PHONE_AGENT_RECORD_DIR", ""),
+		"Directory where every step is recorded as JSONL with its screenshot, for fine-tuning and evaluation datasets (default: off)")
+
+	rootCmd.PersistentFlags().BoolVar(&config.RecordSnapshots, "record-snapshots",
+		getEnvBool("PHONE_AGENT_RECORD_SNAPSHOTS", false),
+		"Also record the state of the agent at every step, its conversation, plan and policy decisions, for --rewind")
+
+	rootCmd.PersistentFlags().BoolVar(&config.RecordVideo, "record-video",
+		getEnvBool("PHONE_AGENT_RECORD_VIDEO", false),
+		"Also record an mp4 of each task in --record-dir with its steps as .vtt subtitles, with screenrecord on adb devices or stitched from the screenshots with ffmpeg")
+
+	rootCmd.PersistentFlags().StringVar(&config.Replay, "replay", "",
+		"Run the actions of a recorded session (.jsonl of --record-dir) or exported trajectory on the device, without the model")
+
+	rootCmd.PersistentFlags().BoolVar(&config.ReplayStrict, "replay-strict",
+		getEnvBool("PHONE_AGENT_REPLAY_STRICT", false),
+		"Stop the replay when the foreground app is not the recorded one")
+
+	rootCmd.PersistentFlags().StringVar(&config.Macro, "macro", "",
+		"Run a macro instead of --task: fixed actions such as do(action=\"Tap\", element=[500, 300]) and \"agent: instruction\" lines for the model, one per line")
+
+	rootCmd.PersistentFlags().StringVar(&config.Compare, "compare", "",
+		"Show the screens of a recorded session (.jsonl of --record-dir) to the model and report how many recorded actions it picks")
+
+	rootCmd.PersistentFlags().StringVar(&config.Rewind, "rewind", "",
+		"Rewind a recorded session (.jsonl of --record-dir) to --rewind-step and run the model forward from there on the recorded screens")
+
+	rootCmd.PersistentFlags().IntVar(&config.RewindStep, "rewind-step", 1,
+		"Step of --rewind to start from")
+
+	rootCmd.PersistentFlags().StringVar(&config.RewindTask, "rewind-task", "",
+		"Instruction replacing the recorded task in --rewind")
+
+	rootCmd.PersistentFlags().StringVar(&config.RewindObservation, "rewind-observation", "",
+		"Text replacing the observation of --rewind-step in --rewind, @FILE reads it from a file")
+
+	rootCmd.PersistentFlags().StringVar(&config.ExportDataset, "export-dataset", "",
+		"Write the steps of recorded sessions as chat-format fine-tuning samples to this JSONL file, without the device or the model")
+
+	rootCmd.PersistentFlags().StringVar(&config.DatasetFrom, "dataset-from", "",
+		"Comma-separated session files (.jsonl) or record dirs to export with --export-dataset (default: --record-dir)")
+
+	rootCmd.PersistentFlags().StringVar(&config.DatasetOutcome, "dataset-outcome", recorder.OutcomeSuccess,
+		"Tasks exported by --export-dataset: success, failed or all")
+
+	rootCmd.PersistentFlags().StringVar(&config.MemoryFile, "memory-file",
+		getEnv("PHONE_AGENT_MEMORY_FILE", ""),
+		"JSON file of the facts learned about apps at the end of tasks, given to later tasks in the same apps (default: off)")
 
-type MessageOnlyFormatter struct{}
+	rootCmd.PersistentFlags().BoolVar(&config.ListMemories, "list-memories", false,
+		"Print the facts of --memory-file and exit")
+
+	rootCmd.PersistentFlags().StringVar(&config.PruneMemories, "prune-memories", "",
+		"Remove facts of --memory-file and exit: comma-separated fact ids, unused:DURATION for the ones not used since, or all")
+
+	rootCmd.PersistentFlags().StringVar(&config.MemoryApp, "memory-app", "",
+		"Only the facts of this app for --list-memories and --prune-memories")
+
+	rootCmd.PersistentFlags().StringVar(&config.DatasetImagePrefix, "dataset-image-prefix", "",
+		"Prefix of the screenshot references of --export-dataset instead of the record dir, e.g. https://bucket/records/")
+
+	rootCmd.PersistentFlags().IntVar(&config.DuplicateWindow, "duplicate-window",
+		getEnvInt("PHONE_AGENT_DUPLICATE_WINDOW", 300),
+		"Seconds after it succeeded during which the same task submitted again for a device returns the first one instead of running twice, 0 disables the check")
+
+	rootCmd.PersistentFlags().BoolVar(&config.Force, "force", false,
+		"Run the tasks of --devices even when the same task is running or has just run on the device")
+
+	rootCmd.PersistentFlags().StringVar(&config.Webhooks, "webhooks",
+		getEnv("PHONE_AGENT_WEBHOOKS", ""),
+		"URLs, separated by commas, to POST task events to as JSON; Slack and Feishu incoming webhooks get chat messages")
+
+	rootCmd.PersistentFlags().StringVar(&config.WebhookEvents, "webhook-events",
+		getEnv("PHONE_AGENT_WEBHOOK_EVENTS", ""),
+		"Events posted to --webhooks, separated by commas: finished, failed, confirmation, takeover, watch, progress (default: all)")
+
+	rootCmd.PersistentFlags().StringVar(&config.Chaos, "chaos",
+		getEnv("PHONE_AGENT_CHAOS", ""),
+		"Resilience testing: inject faults at these rates, e.g. disconnect=0.05,slow_model=0.1,malformed_action=0.05,screenshot=0.05")
+
+	rootCmd.PersistentFlags().StringVar(&config.OutputSchema, "output-schema",
+		getEnv("PHONE_AGENT_OUTPUT_SCHEMA", ""),
+		"Fields to extract from the result of the task once it finished and print as JSON, e.g. \"price: number, eta: string\"")
+
+	rootCmd.PersistentFlags().StringVar(&config.OutcomeTemplates, "outcome-templates",
+		getEnv("PHONE_AGENT_OUTCOME_TEMPLATES", ""),
+		"JSON file of text/template templates formatting the outcome of finished tasks, by channel: cli, chat (Slack and Feishu webhooks) or webhook (summary field)")
+
+	rootCmd.PersistentFlags().BoolVar(&config.Demonstrate, "demonstrate", false,
+		"Record yourself doing --task on the device (adb only) into --record-dir until Ctrl-C, to replay it or use it as a --skill")
+
+	rootCmd.PersistentFlags().StringVar(&config.Skill, "skill",
+		getEnv("PHONE_AGENT_SKILL", ""),
+		"Recorded demonstration (.jsonl of --record-dir) or trajectory shown to the model as an example of the task")
+
+	rootCmd.PersistentFlags().BoolVar(&config.Calibrate, "calibrate", false,
+		"Measure where taps land on the device (adb only) and store the correction in --calibration-file")
+
+	rootCmd.PersistentFlags().StringVar(&config.CalibrationFile, "calibration-file",
+		getEnv("PHONE_AGENT_CALIBRATION_FILE", ""),
+		"JSON file of per-device tap corrections written by --calibrate and applied to the taps of the model")
+
+	rootCmd.PersistentFlags().StringVar(&config.Watch, "watch",
+		getEnv("PHONE_AGENT_WATCH", ""),
+		"Condition to check on the device every --watch-interval, e.g. \"is the item back in stock\"; once it is met the watch webhook event is posted and --task, if set, runs")
+
+	rootCmd.PersistentFlags().IntVar(&config.WatchInterval, "watch-interval",
+		getEnvInt("PHONE_AGENT_WATCH_INTERVAL", 300),
+		"Seconds between the starts of two --watch checks")
+
+	rootCmd.PersistentFlags().StringVar(&config.Labels, "labels",
+		getEnv("PHONE_AGENT_LABELS", ""),
+		"Task labels as key=value pairs separated by commas, added to logs and sent to the model API as X-Label-* headers")
+
+	rootCmd.PersistentFlags().StringVar(&config.OTLPEndpoint, "otlp-endpoint",
+		getEnv("PHONE_AGENT_OTLP_ENDPOINT", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")),
+		"OTLP/HTTP collector to export traces of tasks, steps, model calls and device operations to, e.g. http://localhost:4318")
+
+	rootCmd.PersistentFlags().StringVar(&config.MetricsAddr, "metrics-addr",
+		getEnv("PHONE_AGENT_METRICS_ADDR", ""),
+		"Serve Prometheus metrics at /metrics on this address, e.g. :9090 (--serve-addr serves them too)")
 
-func (f *MessageOnlyFormatter) Format(entry *logs.Entry) ([]byte, error) {
-	return []byte(entry.Message + "\n"), nil // 只返回消息 + 换行符
 }
 
+// exitCode is the exit status of the process once main returns, 1 when a
+// --suite case failed.
+var exitCode int
+
 func main() {
 	parseArgs()
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
 
-	logs.SetFormatter(&MessageOnlyFormatter{})
-	logs.SetOutput(os.Stdout)
-
+	levels := config.LogLevel
 	if config.Debug {
-		logs.SetLevel(logs.DebugLevel)
+		// a default level of --log-level still wins
+		levels = "debug," + levels
+	}
+	if err := logging.Setup(os.Stdout, config.LogFormat, levels); err != nil {
+		logs.Errorf("❌ invalid logging options, err: %v", err)
+		return
+	}
+
+	if config.OutputLang != "" {
+		defer helper.UseOutputLang(config.OutputLang)()
+	}
+	if config.ConfigFile != "" {
+		if interval := getEnvFloat64("PHONE_AGENT_CONFIG_RELOAD", 2); interval > 0 {
+			go configfile.Watch(context.Background(), config.ConfigFile, time.Duration(interval*float64(time.Second)), reloadConfigFile)
+		}
+	}
+
+	taskLabels, err := labels.Parse(config.Labels)
+	if err != nil {
+		logs.Errorf("❌ invalid --labels, err: %v", err)
+		return
 	}
+	ctx := labels.With(context.Background(), taskLabels)
 
-	ctx := context.Background()
+	if config.OTLPEndpoint != "" {
+		stop, err := startTracing()
+		if err != nil {
+			logs.Errorf("❌ starting tracing failed, err: %v", err)
+			return
+		}
+		defer stop(context.Background())
+	}
+	if config.MetricsAddr != "" {
+		if err := serveMetrics(ctx); err != nil {
+			logs.Errorf("❌ serving metrics failed, err: %v", err)
+			return
+		}
+	}
+
+	if config.ArtifactKey != "" {
+		key, err := seal.LoadKey(ctx, config.ArtifactKey)
+		if err == nil {
+			var sealer *seal.Sealer
+			if sealer, err = seal.New(key); err == nil {
+				defer seal.Use(sealer)()
+			}
+		}
+		if err != nil {
+			logs.Errorf("❌ loading artifact key failed, err: %v", err)
+			return
+		}
+		logs.Infof("🔒 stored artifacts are encrypted")
+	}
+	if config.ArtifactStore != "" {
+		storage, err := artifact.Open(config.ArtifactStore, artifact.Options{
+			Secret:  []byte(getEnv("PHONE_AGENT_ARTIFACT_SECRET", "")),
+			BaseURL: getEnv("PHONE_AGENT_ARTIFACT_BASE_URL", ""),
+		})
+		if err != nil {
+			logs.Errorf("❌ opening artifact store failed, err: %v", err)
+			return
+		}
+		defer artifact.Use(storage)()
+		if days := getEnvInt("PHONE_AGENT_ARTIFACT_RETENTION_DAYS", 0); days > 0 {
+			go artifact.KeepFor(ctx, storage, time.Duration(days)*24*time.Hour, time.Hour)
+		}
+		logs.Infof("🗄️ artifacts are stored in %s", storage)
+	}
 
 	// Handle --list-apps (no system check needed)
 	if config.ListApps {
@@ -230,6 +1022,9 @@ func main() {
 			logs.Info("Note: For iOS apps, Bundle IDs are configured in: constants/apps.go")
 			logs.Info("Supported iOS apps:")
 			supportedApps = lo.Keys(constants.APP_PACKAGES_IOS)
+		} else if config.DeviceType == constants.HDC {
+			logs.Info("Supported HarmonyOS apps:")
+			supportedApps = lo.Keys(constants.APP_PACKAGES_HARMONY)
 		} else {
 			logs.Info("Supported Android apps:")
 			supportedApps = lo.Keys(constants.APP_PACKAGES_ANDROID)
@@ -242,31 +1037,174 @@ func main() {
 		return
 	}
 
-	device, err := phoneagent.CreateDevice(config.DeviceType)
+	// Handle --list-memories and --prune-memories (no device or model needed)
+	if config.ListMemories || config.PruneMemories != "" {
+		if err := manageMemories(); err != nil {
+			logs.Errorf("❌ managing app memory failed, err: %v", err)
+		}
+		return
+	}
+
+	// Handle --export-dataset (no device or model needed)
+	if config.ExportDataset != "" {
+		if err := exportDataset(); err != nil {
+			logs.Errorf("❌ exporting dataset failed, err: %v", err)
+		}
+		return
+	}
+
+	deviceOptions := &definitions.DeviceOptions{
+		AppiumURL:   config.AppiumURL,
+		KeyboardAPK: config.KeyboardAPK,
+		WdaURL:      config.WdaUrl,
+	}
+	if config.AppiumCaps != "" {
+		_ = json.Unmarshal([]byte(config.AppiumCaps), &deviceOptions.AppiumCapabilities)
+	}
+	device, err := phoneagent.CreateDevice(config.DeviceType, deviceOptions)
 	if err != nil {
 		logs.Errorf("creating device failed, err: %v", err)
 		return
 	}
+	// release persistent device connections on exit
+	if closer, ok := device.(io.Closer); ok {
+		defer closer.Close()
+	}
 
 	// Handle device commands (these may need partial system checks)
 	if hitCmd := handleDeviceCommands(ctx, device); hitCmd {
 		return
 	}
 
-	if passed := checkSystemRequirements(ctx, config.DeviceType, config.WdaUrl); !passed {
-		logs.Info(strings.Repeat("-", 50))
+	var emulators []string
+	if config.Emulators > 0 {
+		farm, err := newEmulatorFarm()
+		if err != nil {
+			logs.Errorf("❌ invalid emulators, err: %v", err)
+			return
+		}
+		logs.Infof("📱 provisioning %d emulator(s)...", config.Emulators)
+		if emulators, err = farm.Provision(ctx, config.Emulators); err != nil {
+			logs.Errorf("❌ provisioning emulators failed, err: %v", err)
+			return
+		}
+		defer farm.Teardown(context.Background())
+		if config.DeviceID == "" {
+			config.DeviceID = emulators[0]
+		}
+	}
+
+	var groups *group.Tree
+	if config.GroupsFile != "" {
+		groups, err = group.Load(config.GroupsFile)
+		if err != nil {
+			logs.Errorf("❌ loading device groups failed, err: %v", err)
+			return
+		}
+		// the group server runs tasks on many devices, not only --device-id
+		if config.GroupsAddr == "" {
+			applyGroupDefaults(groups)
+		}
+	}
+	if config.ShowSettings {
+		showSettings(groups)
+		return
+	}
+
+	var passed bool
+	if config.DeviceType == constants.APPIUM {
+		passed = checkAppiumServer(ctx, device)
+	} else if config.DeviceType == constants.HDC {
+		passed = checkHDC(ctx, device)
+	} else {
+		passed = checkSystemRequirements(ctx, config.DeviceType, config.WdaUrl)
+	}
+	if !passed {
+		logging.Rule("-")
 		logs.Error("❌ System check failed. Please fix the issues above.")
 		logs.Error("❌ check system requirements failed")
 		return
 	}
 
-	if passed := checkModelAPI(ctx, config.BaseURL, config.Model, config.APIKey); !passed {
-		logs.Error("❌ Model API check failed. Please fix the issues above.")
-		logs.Error("❌ check model api failed")
-		return
+	cassetteMode := llm.CassetteMode(getEnv("PHONE_AGENT_CASSETTE_MODE", string(llm.CassetteReplay)))
+	cassettePath := getEnv("PHONE_AGENT_MODEL_CASSETTE", "")
+	// a replayed run sends no request, the recorded one had its API checked;
+	// nor does a demonstration or a calibration
+	if (cassettePath == "" || cassetteMode != llm.CassetteReplay) && !config.Demonstrate && !config.Calibrate {
+		httpConfig, err := modelHTTPConfig()
+		if err != nil {
+			logs.Errorf("❌ %v", err)
+			return
+		}
+		if passed := checkModelAPI(ctx, &definitions.ModelConfig{
+			Provider:  config.Provider,
+			BaseURL:   config.BaseURL,
+			ModelName: config.Model,
+			APIKey:    config.APIKey,
+			HTTP:      httpConfig,
+		}); !passed {
+			logs.Error("❌ Model API check failed. Please fix the issues above.")
+			logs.Error("❌ check model api failed")
+			return
+		}
+	}
+	if cassettePath != "" {
+		cassette, err := llm.OpenCassette(cassettePath, cassetteMode)
+		if err != nil {
+			logs.Errorf("❌ loading model cassette failed, err: %v", err)
+			return
+		}
+		defer llm.UseCassette(cassette)()
+		logs.Infof("📼 model requests %s %s", cassetteMode, cassettePath)
+	}
+
+	for _, command := range config.Plugins {
+		fields := strings.Fields(command)
+		if len(fields) == 0 {
+			continue
+		}
+		plugin, err := phoneagent.StartProcessPlugin(ctx, fields[0], fields[1:]...)
+		if err != nil {
+			logs.Errorf("❌ loading plugin failed, err: %v", err)
+			return
+		}
+		defer plugin.Close()
+		if err := phoneagent.RegisterActionPlugin(plugin); err != nil {
+			logs.Errorf("❌ registering plugin failed, err: %v", err)
+			return
+		}
+	}
+	if config.ActionsFile != "" {
+		actions, err := phoneagent.ReadConfigActions(config.ActionsFile)
+		if err != nil {
+			logs.Errorf("❌ loading custom actions failed, err: %v", err)
+			return
+		}
+		for _, action := range actions {
+			plugin, err := phoneagent.NewConfigPlugin(ctx, action)
+			if err != nil {
+				logs.Errorf("❌ loading custom actions failed, err: %v", err)
+				return
+			}
+			defer plugin.Close()
+			if err := phoneagent.RegisterActionPlugin(plugin); err != nil {
+				logs.Errorf("❌ registering custom action failed, err: %v", err)
+				return
+			}
+		}
+		logs.Infof("🧩 %d custom actions from %s", len(actions), config.ActionsFile)
+	}
+	if groups != nil {
+		defer phoneagent.UseDeviceGroups(func(deviceID string) []string {
+			return groups.Path(groups.GroupOf(deviceID))
+		})()
+		defer phoneagent.UseGroupDefaults(func(deviceID string) definitions.GroupDefaults {
+			return withoutExplicit(groups.Effective(deviceID))
+		})()
 	}
 
 	modelConfig := &definitions.ModelConfig{
+		Provider:         config.Provider,
 		BaseURL:          config.BaseURL,
 		ModelName:        config.Model,
 		APIKey:           config.APIKey,
@@ -275,68 +1213,1774 @@ func main() {
 		Temperature:      getEnvFloat32("PHONE_AGENT_TEMPERATURE", 0.0),
 		TopP:             getEnvFloat32("PHONE_AGENT_TOP_P", 0.85),
 		FrequencyPenalty: getEnvFloat32("PHONE_AGENT_FREQUENCY_PENALTY", 0.2),
+		MaxImageBytes:    getEnvInt("PHONE_AGENT_MAX_IMAGE_BYTES", 0),
+		Image: definitions.ImageConfig{
+			MaxEdge:   getEnvInt("PHONE_AGENT_IMAGE_MAX_EDGE", 0),
+			Format:    getEnv("PHONE_AGENT_IMAGE_FORMAT", "png"),
+			Quality:   getEnvInt("PHONE_AGENT_IMAGE_QUALITY", 0),
+			Grayscale: getEnvBool("PHONE_AGENT_IMAGE_GRAYSCALE", false),
+			Transport: getEnv("PHONE_AGENT_IMAGE_TRANSPORT", ""),
+			Detail:    getEnv("PHONE_AGENT_IMAGE_DETAIL", ""),
+		},
+		AdaptiveImage: getEnvBool("PHONE_AGENT_ADAPTIVE_IMAGE", false),
+		EarlyAction:   getEnvBool("PHONE_AGENT_EARLY_ACTION", false),
+		ToolCalls:     getEnvBool("PHONE_AGENT_TOOL_CALLS", false),
+		Observation:   config.Observation,
+
+		ResponseParser: config.ResponseParser,
+		ActionMarkers:  splitList(config.ActionMarkers),
+
+		MaxThinkingTokens: getEnvInt("PHONE_AGENT_MAX_THINKING_TOKENS", 0),
+		ShowThinking:      config.Verbose,
+
+		CostPer1K: getEnvFloat64("PHONE_AGENT_MODEL_COST", 0),
+
+		Retry: definitions.RetryPolicy{
+			MaxAttempts:    getEnvInt("PHONE_AGENT_RETRY_ATTEMPTS", 3),
+			InitialBackoff: time.Duration(getEnvFloat64("PHONE_AGENT_RETRY_BACKOFF", 1) * float64(time.Second)),
+			MaxBackoff:     time.Duration(getEnvFloat64("PHONE_AGENT_RETRY_MAX_BACKOFF", 30) * float64(time.Second)),
+			Jitter:         getEnvFloat64("PHONE_AGENT_RETRY_JITTER", 0.2),
+		},
+	}
+	if modelConfig.HTTP, err = modelHTTPConfig(); err != nil {
+		logs.Errorf("❌ %v", err)
+		return
+	}
+	fallbacks, err := loadFallbacks()
+	if err != nil {
+		logs.Errorf("❌ loading fallback models failed, err: %v", err)
+		return
+	}
+	modelConfig.Fallbacks = fallbacks
+	routes, err := loadRoutes()
+	if err != nil {
+		logs.Errorf("❌ loading routes failed, err: %v", err)
+		return
+	}
+	profiles, err := loadModelProfiles()
+	if err != nil {
+		logs.Errorf("❌ loading model profiles failed, err: %v", err)
+		return
+	}
+	phoneagent.UseModelProfiles(profiles)
+	// a cassette records or replays the model requests only
+	if cassettePath == "" && !config.Demonstrate && !config.Calibrate && config.WarmModels {
+		endpoints := modelConfigs(modelConfig, routes, profiles)
+		if err := llm.Warm(ctx, endpoints...); err != nil {
+			logs.Errorf("❌ model credentials check failed, err: %v", err)
+			return
+		}
+		if interval := getEnvFloat64("PHONE_AGENT_KEEP_WARM_INTERVAL", 60); interval > 0 {
+			go llm.KeepWarm(ctx, time.Duration(interval*float64(time.Second)), endpoints...)
+		}
+	}
+	if config.MaxInFlight > 0 || config.MaxQPS > 0 || config.MaxTPM > 0 {
+		llm.UseLimiter(llm.NewLimiter(llm.LimiterOptions{
+			MaxInFlight:     config.MaxInFlight,
+			QPS:             config.MaxQPS,
+			TokensPerMinute: config.MaxTPM,
+		}))
+	}
+	if config.ResponseCacheTTL > 0 {
+		phoneagent.UseResponseCache(respcache.New(respcache.Options{
+			TTL:         time.Duration(config.ResponseCacheTTL * float64(time.Second)),
+			MaxDistance: getEnvInt("PHONE_AGENT_RESPONSE_CACHE_DISTANCE", 4),
+			MinHits:     getEnvInt("PHONE_AGENT_RESPONSE_CACHE_MIN_HITS", 2),
+		}))
+	}
+	modelConfig.TrackUsage = getEnvBool("PHONE_AGENT_TRACK_USAGE", true) || len(routes) > 0
+	if config.PricingFile != "" {
+		catalog, err := pricing.Load(ctx, config.PricingFile)
+		if err != nil {
+			logs.Errorf("❌ loading model pricing failed, err: %v", err)
+			return
+		}
+		modelConfig.PriceCatalog = catalog
+		if refresh := getEnvInt("PHONE_AGENT_PRICING_REFRESH", 60); refresh > 0 {
+			go catalog.Run(ctx, time.Duration(refresh)*time.Minute)
+		}
 	}
 	agentConfig := &definitions.AgentConfig{
-		MaxSteps: config.MaxSteps,
-		DeviceID: config.DeviceID,
-		Lang:     config.Lang,
-		WdaUrl:   config.WdaUrl,
+		MaxSteps:   config.MaxSteps,
+		DeviceID:   config.DeviceID,
+		Lang:       config.Lang,
+		UILanguage: config.UILang,
+		WdaUrl:     config.WdaUrl,
+		UIDump:     config.UIDump,
+		WebCDP:     config.WebCDP,
+		Grounding:  config.Grounding,
+		OCR:        config.OCR,
+
+		Notifications: config.Notifications,
+
+		OCRLanguages:    getEnv("PHONE_AGENT_OCR_LANG", "chi_sim+eng"),
+		CalibrationFile: config.CalibrationFile,
+		InstallDir:      getEnv("PHONE_AGENT_INSTALL_DIR", ""),
+
+		ReplyLanguage: config.ReplyLang,
+		InputLocale:   config.InputLocale,
+		ModelProfile:  config.ModelProfile,
+
+		SpeculationThreshold: getEnvFloat64("PHONE_AGENT_SPECULATION_THRESHOLD", 0),
+		HistoryKeepSteps:     getEnvInt("PHONE_AGENT_HISTORY_KEEP_STEPS", 0),
+		HistoryMaxSteps:      getEnvInt("PHONE_AGENT_HISTORY_MAX_STEPS", 0),
+		HistoryDir:           getEnv("PHONE_AGENT_HISTORY_DIR", ""),
+		HistoryImages:        getEnvInt("PHONE_AGENT_HISTORY_IMAGES", 1),
+		HistoryTokenBudget:   getEnvInt("PHONE_AGENT_HISTORY_TOKEN_BUDGET", 0),
+		PlannerReviewSteps:   getEnvInt("PHONE_AGENT_PLANNER_REVIEW_STEPS", 5),
+		ReconnectTimeout:     time.Duration(getEnvInt("PHONE_AGENT_RECONNECT_TIMEOUT", 60)) * time.Second,
+
+		ActionTimeout: time.Duration(getEnvFloat64("PHONE_AGENT_ACTION_TIMEOUT", 0) * float64(time.Second)),
+		StepTimeout:   time.Duration(getEnvFloat64("PHONE_AGENT_STEP_TIMEOUT", 0) * float64(time.Second)),
+		TaskTimeout:   time.Duration(getEnvFloat64("PHONE_AGENT_TASK_TIMEOUT", 0) * float64(time.Second)),
+		SoftDeadline:  time.Duration(getEnvFloat64("PHONE_AGENT_SOFT_DEADLINE", 0) * float64(time.Second)),
+
+		AnomalyFactor:    getEnvFloat64("PHONE_AGENT_ANOMALY_FACTOR", 0),
+		AnomalyBaselines: getEnv("PHONE_AGENT_ANOMALY_BASELINES", ""),
+
+		MemoryFile: config.MemoryFile,
+
+		ConfirmTimeout: time.Duration(getEnvFloat64("PHONE_AGENT_CONFIRM_TIMEOUT", 0) * float64(time.Second)),
+
+		AutoUnlock:  config.AutoUnlock,
+		DryRun:      config.DryRun,
+		ReadOnly:    config.ReadOnly,
+		AndroidUser: config.AndroidUser,
+		VaultFile:   config.VaultFile,
+		SessionDir:  config.SessionDir,
+		RecordDir:   config.RecordDir,
+
+		RecordSnapshots: config.RecordSnapshots,
+		RecordVideo:     config.RecordVideo,
+	}
+	// checked by validateArgs
+	agentConfig.StepPause, _ = definitions.ParsePacing(config.Pacing)
+	if config.OutcomeTemplates != "" {
+		// checked by validateArgs
+		agentConfig.OutcomeTemplates, _ = phoneagent.LoadOutcomeTemplates(config.OutcomeTemplates)
+	}
+	if config.PolicyFile != "" {
+		// checked by validateArgs
+		agentConfig.Policy, _ = policy.Load(config.PolicyFile)
+	}
+	agentConfig.MaxPolicyBlocks = config.MaxPolicyBlocks
+	agentConfig.MaxRepeats = config.MaxRepeats
+	agentConfig.UnchangedScreen = config.UnchangedScreen
+	agentConfig.UnchangedDistance = getEnvInt("PHONE_AGENT_UNCHANGED_DISTANCE", 0)
+	agentConfig.MaxActionErrors = config.MaxActionErrors
+	agentConfig.MaxTaskTokens = config.MaxTaskTokens
+	agentConfig.MaxTaskCost = config.MaxCost
+	// checked by validateArgs
+	agentConfig.Redact, _ = loadRedact()
+	if err := agentConfig.ValidateTimeouts(); err != nil {
+		logs.Errorf("❌ invalid timeouts, err: %v", err)
+		return
+	}
+	agentConfig.Webhooks = definitions.WebhookConfig{
+		URLs:   splitList(config.Webhooks),
+		Events: splitList(config.WebhookEvents),
+		Secret: getEnv("PHONE_AGENT_WEBHOOK_SECRET", ""),
+	}
+	agentConfig.Chaos = definitions.ChaosConfig{
+		SlowModelDelay: time.Duration(getEnvFloat64("PHONE_AGENT_CHAOS_DELAY", 10) * float64(time.Second)),
+		DisconnectFor:  time.Duration(getEnvFloat64("PHONE_AGENT_CHAOS_OFFLINE", 5) * float64(time.Second)),
+		Seed:           int64(getEnvInt("PHONE_AGENT_CHAOS_SEED", 0)),
+	}
+	if err := agentConfig.Chaos.ParseChaosRates(config.Chaos); err != nil {
+		logs.Errorf("❌ invalid chaos rates, err: %v", err)
+		return
+	}
+	if config.VaultFile != "" {
+		// read again at each unlock, loaded here to fail early
+		if _, err := vault.Load(config.VaultFile); err != nil {
+			logs.Errorf("❌ loading vault failed, err: %v", err)
+			return
+		}
 	}
 
-	phoneAgent := phoneagent.NewPhoneAgent(device, modelConfig, agentConfig)
-
-	// Print configuration information
-	printConfiguration(ctx, phoneAgent)
+	pool, err := newImagePool()
+	if err != nil {
+		logs.Errorf("❌ image pipeline failed, err: %v", err)
+		return
+	}
+	if pool != nil {
+		imaging.UsePool(pool)
+		defer pool.Close()
+	}
 
-	// Run with provided task or enter interactive mode
-	if config.Task != "" {
-		logs.Infof("Task: %s", config.Task)
-		result, err := phoneAgent.Run(ctx, config.Task)
+	phoneAgent := phoneagent.NewPhoneAgent(device, modelConfig, agentConfig)
+	if len(routes) > 0 {
+		var list []phoneagent.Route
+		for i := range routes {
+			list = append(list, phoneagent.NewRoute(&routes[i], modelConfig))
+		}
+		phoneAgent.Router = phoneagent.NewRouter(list...)
+	}
+	if plannerConfig := plannerModelConfig(modelConfig); plannerConfig != nil {
+		phoneAgent.Planner = llm.NewModelClient(plannerConfig)
+	} else if config.Plan {
+		phoneAgent.Planner = phoneAgent.ModelClient
+	}
+	if judgeConfig := judgeModelConfig(modelConfig); judgeConfig != nil {
+		phoneAgent.Judge = llm.NewModelClient(judgeConfig)
+	}
+	if config.Captcha {
+		if config.CaptchaSolver != "" {
+			phoneAgent.Captcha = append(phoneAgent.Captcha, &captcha.SolverHandler{
+				Solver:   captcha.NewCommandSolver(strings.Fields(config.CaptchaSolver)...),
+				Attempts: 2,
+			})
+		}
+		phoneAgent.Captcha = append(phoneAgent.Captcha, &captcha.HumanHandler{Addr: config.CaptchaLiveAddr})
+	}
+	if config.DialogPolicy != "" {
+		handler, err := dialog.NewHandler(config.DialogPolicy, config.DialogRules)
+		if err != nil {
+			logs.Errorf("❌ invalid dialog policy, err: %v", err)
+			return
+		}
+		phoneAgent.Dialogs = handler
+	}
+	if config.Script != "" {
+		runner, err := script.NewRunner(config.Script)
+		if err != nil {
+			logs.Errorf("❌ loading script failed, err: %v", err)
+			return
+		}
+		defer runner.Close()
+		phoneAgent.StepHooks = append(phoneAgent.StepHooks, runner)
+	}
+
+	// Print configuration information
+	printConfiguration(ctx, phoneAgent)
+
+	if config.ServeAddr != "" {
+		if err := serveTasks(ctx, device, phoneAgent, groups); err != nil {
+			logs.Errorf("❌ task server failed, err: %v", err)
+		}
+		return
+	}
+
+	if config.GroupsAddr != "" {
+		if err := serveGroups(ctx, groups, device, phoneAgent); err != nil {
+			logs.Errorf("❌ group server failed, err: %v", err)
+		}
+		return
+	}
+
+	if config.Devices != "" {
+		if err := runOnDevices(ctx, device, phoneAgent); err != nil {
+			logs.Errorf("❌ multi-device run failed, err: %v", err)
+		}
+		return
+	}
+
+	if config.Suite != "" {
+		if err := runSuite(ctx, device, phoneAgent, groups, emulators); err != nil {
+			logs.Errorf("❌ suite failed, err: %v", err)
+			exitCode = 1
+		}
+		return
+	}
+
+	if config.OutputSchema != "" {
+		// checked by validateArgs
+		schema, _ := phoneagent.ParseOutputSchema(config.OutputSchema)
+		ctx = phoneagent.WithOutputSchema(ctx, schema)
+	}
+	if config.Skill != "" {
+		// checked by validateArgs
+		skill, _ := phoneagent.LoadSkill(config.Skill)
+		ctx = phoneagent.WithSkill(ctx, skill)
+	}
+
+	voiceConfig := newVoiceConfig()
+	transcriber := voice.NewTranscriber(voiceConfig)
+	phoneAgent.Speaker, err = voice.NewSpeaker(voiceConfig)
+	if err != nil {
+		logs.Errorf("❌ speech output unavailable, err: %v", err)
+		return
+	}
+	if config.Task == "" && config.Voice != "" {
+		task, err := voiceTask(ctx, transcriber, config.Voice)
+		if err != nil {
+			logs.Errorf("❌ voice input failed, err: %v", err)
+			return
+		}
+		config.Task = task
+	}
+
+	if config.TaskFile != "" {
+		cleanup, err := loadTaskFile(ctx, device)
+		if err != nil {
+			logs.Errorf("❌ task file failed, err: %v", err)
+			return
+		}
+		defer cleanup()
+	}
+
+	if config.TriggersFile != "" {
+		if err := serveTriggers(ctx, device, phoneAgent); err != nil {
+			logs.Errorf("❌ trigger server failed, err: %v", err)
+		}
+		return
+	}
+
+	// Run with provided task or enter interactive mode
+	if config.Rewind != "" {
+		if err := rewind(ctx, phoneAgent); err != nil {
+			logs.Errorf("Error rewinding session: %v", err)
+		}
+	} else if config.Compare != "" {
+		records, err := recorder.Load(config.Compare)
+		if err != nil {
+			logs.Errorf("❌ loading records failed, err: %v", err)
+			return
+		}
+		comparison, err := recorder.Compare(ctx, phoneAgent.ModelClient, filepath.Dir(config.Compare), records, recorder.CompareOptions{})
+		if err != nil {
+			logs.Errorf("Error comparing model: %v", err)
+			return
+		}
+		fmt.Println(comparison.String())
+	} else if config.Macro != "" {
+		// checked by validateArgs
+		macro, _ := phoneagent.LoadMacro(config.Macro)
+		logs.Infof("📜 running %d steps of %s", len(macro.Steps), config.Macro)
+		result, err := phoneAgent.RunMacro(ctx, macro)
+		if err != nil {
+			logs.Errorf("Error running macro: %v", err)
+			exitCode = 1
+			return
+		}
+		logs.Infof("🎉 %s: %s", helper.GetOutputMessage("result", config.Lang), result)
+	} else if config.Replay != "" {
+		replay, err := phoneagent.LoadReplay(config.Replay)
+		if err != nil {
+			logs.Errorf("❌ loading replay failed, err: %v", err)
+			return
+		}
+		replay.Strict = config.ReplayStrict
+		task := config.Task
+		if task == "" {
+			task = replay.Task
+		}
+		phoneAgent.Replay = replay
+		logs.Infof("🔁 replaying %d steps of %s", len(replay.Steps), config.Replay)
+		result, err := phoneAgent.Run(ctx, task)
+		if err != nil {
+			logs.Errorf("Error replaying task: %v", err)
+			return
+		}
+		logs.Infof("🎉 %s: %s", helper.GetOutputMessage("result", config.Lang), result)
+		exportTrajectory(phoneAgent)
+	} else if config.Calibrate {
+		matrix, err := phoneAgent.Calibrate(ctx)
+		if err != nil {
+			logs.Errorf("Error calibrating: %v", err)
+			return
+		}
+		logs.Infof("📐 calibration saved to %s: %v", config.CalibrationFile, matrix)
+	} else if config.Demonstrate {
+		demoCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		session, err := phoneAgent.Demonstrate(demoCtx, config.Task)
+		stop()
+		if err != nil {
+			logs.Errorf("Error recording demonstration: %v", err)
+			return
+		}
+		path := filepath.Join(config.RecordDir, session+".jsonl")
+		logs.Infof("🎬 replay it with --replay %s, or guide similar tasks with --skill %s", path, path)
+	} else if config.Watch != "" {
+		logs.Infof("👀 watching every %ds: %s", config.WatchInterval, config.Watch)
+		result, err := phoneAgent.Watch(ctx, phoneagent.Watch{
+			Check:    config.Watch,
+			Action:   config.Task,
+			Interval: time.Duration(config.WatchInterval) * time.Second,
+			MaxSteps: getEnvInt("PHONE_AGENT_WATCH_STEPS", 10),
+		})
+		if err != nil {
+			logs.Errorf("Error watching: %v", err)
+			return
+		}
+		logs.Infof("🎉 %s: %s", helper.GetOutputMessage("result", config.Lang), result)
+		if config.Task != "" {
+			logVerdict(phoneAgent)
+			logOutcome(ctx, phoneAgent)
+			logOutput(phoneAgent)
+			exportTrajectory(phoneAgent)
+		}
+	} else if config.Resume != "" {
+		result, err := phoneAgent.Resume(ctx, config.Resume)
+		if err != nil {
+			logs.Errorf("Error resuming session: %v", err)
+			return
+		}
+		logs.Infof("🎉 %s: %s", helper.GetOutputMessage("result", config.Lang), result)
+		logVerdict(phoneAgent)
+		logOutcome(ctx, phoneAgent)
+		logOutput(phoneAgent)
+		exportTrajectory(phoneAgent)
+	} else if config.Task != "" {
+		logs.Infof("Task: %s", config.Task)
+		result, err := phoneAgent.Run(ctx, config.Task)
+		if err != nil {
+			logs.Errorf("Error running task: %v", err)
+			return
+		}
+		logs.Infof("🎉 %s: %s", helper.GetOutputMessage("result", config.Lang), result)
+		logVerdict(phoneAgent)
+		logOutcome(ctx, phoneAgent)
+		logOutput(phoneAgent)
+		exportTrajectory(phoneAgent)
+	} else {
+		interactive(ctx, phoneAgent, transcriber)
+	}
+
+}
+
+func loadRoutes() ([]definitions.RouteConfig, error) {
+	if config.RoutesFile == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(config.RoutesFile)
+	if err != nil {
+		return nil, err
+	}
+	var routes []definitions.RouteConfig
+	if err := json.Unmarshal(data, &routes); err != nil {
+		return nil, fmt.Errorf("invalid routes file %s: %w", config.RoutesFile, err)
+	}
+	for _, route := range routes {
+		if route.Name == "" || route.Model == "" {
+			return nil, fmt.Errorf("route needs a name and a model: %+v", route)
+		}
+		if _, err := phoneagent.ObservationBuilderFor(route.Observation); err != nil {
+			return nil, fmt.Errorf("route %s: %w", route.Name, err)
+		}
+		for _, h := range route.Handles {
+			switch phoneagent.Difficulty(h) {
+			case phoneagent.Navigation, phoneagent.Reasoning, phoneagent.Reading:
+			default:
+				return nil, fmt.Errorf("route %s: unknown difficulty %q", route.Name, h)
+			}
+		}
+	}
+	return routes, nil
+}
+
+// modelHTTPConfig reads how the model requests are sent from the
+// environment.
+func modelHTTPConfig() (definitions.HTTPConfig, error) {
+	httpConfig := definitions.HTTPConfig{
+		Proxy:              getEnv("PHONE_AGENT_MODEL_PROXY", ""),
+		CAFile:             getEnv("PHONE_AGENT_MODEL_CA_FILE", ""),
+		InsecureSkipVerify: getEnvBool("PHONE_AGENT_MODEL_INSECURE_TLS", false),
+		Timeout:            time.Duration(getEnvFloat64("PHONE_AGENT_MODEL_TIMEOUT", 0) * float64(time.Second)),
+	}
+	for _, header := range splitList(getEnv("PHONE_AGENT_MODEL_HEADERS", "")) {
+		key, value, ok := strings.Cut(header, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return httpConfig, fmt.Errorf("invalid model header %q in PHONE_AGENT_MODEL_HEADERS, want name=value", header)
+		}
+		if httpConfig.Headers == nil {
+			httpConfig.Headers = map[string]string{}
+		}
+		httpConfig.Headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return httpConfig, nil
+}
+
+func loadFallbacks() ([]definitions.FallbackModel, error) {
+	if config.FallbacksFile == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(config.FallbacksFile)
+	if err != nil {
+		return nil, err
+	}
+	var fallbacks []definitions.FallbackModel
+	if err := json.Unmarshal(data, &fallbacks); err != nil {
+		return nil, fmt.Errorf("invalid fallbacks file %s: %w", config.FallbacksFile, err)
+	}
+	for _, fallback := range fallbacks {
+		if fallback.Model == "" {
+			return nil, fmt.Errorf("fallback needs a model: %+v", fallback)
+		}
+		provider := fallback.Provider
+		if provider == "" {
+			provider = config.Provider
+		}
+		if _, err := llm.NewProvider(&definitions.ModelConfig{Provider: provider}); err != nil {
+			return nil, fmt.Errorf("fallback %s: %w", fallback.Model, err)
+		}
+	}
+	return fallbacks, nil
+}
+
+// plannerModelConfig returns the config of the --planner-model, nil without
+// one.
+func plannerModelConfig(base *definitions.ModelConfig) *definitions.ModelConfig {
+	if config.PlannerModel == "" {
+		return nil
+	}
+	plannerConfig := *base
+	plannerConfig.ModelName = config.PlannerModel
+	if config.PlannerBaseURL != "" {
+		plannerConfig.BaseURL = config.PlannerBaseURL
+	}
+	if config.PlannerAPIKey != "" {
+		plannerConfig.APIKey = config.PlannerAPIKey
+	}
+	return &plannerConfig
+}
+
+// judgeModelConfig returns the config of the --judge-model, nil without one.
+func judgeModelConfig(base *definitions.ModelConfig) *definitions.ModelConfig {
+	if config.JudgeModel == "" {
+		return nil
+	}
+	judgeConfig := *base
+	judgeConfig.ModelName = config.JudgeModel
+	if config.JudgeBaseURL != "" {
+		judgeConfig.BaseURL = config.JudgeBaseURL
+	}
+	if config.JudgeAPIKey != "" {
+		judgeConfig.APIKey = config.JudgeAPIKey
+	}
+	return &judgeConfig
+}
+
+// modelConfigs returns the configs of every model tasks may call: the main
+// one, the planner, the judge, the routes and the model profiles.
+func modelConfigs(base *definitions.ModelConfig, routes []definitions.RouteConfig, profiles []definitions.ModelProfile) []*definitions.ModelConfig {
+	configs := []*definitions.ModelConfig{base}
+	for _, extra := range []*definitions.ModelConfig{plannerModelConfig(base), judgeModelConfig(base)} {
+		if extra != nil {
+			configs = append(configs, extra)
+		}
+	}
+	for i := range routes {
+		configs = append(configs, routes[i].Apply(base))
+	}
+	for i := range profiles {
+		configs = append(configs, profiles[i].Apply(base))
+	}
+	return configs
+}
+
+func loadModelProfiles() ([]definitions.ModelProfile, error) {
+	if config.ModelProfilesFile == "" {
+		if config.ModelProfile != "" {
+			return nil, fmt.Errorf("--model-profile requires --model-profiles-file")
+		}
+		return nil, nil
+	}
+	data, err := os.ReadFile(config.ModelProfilesFile)
+	if err != nil {
+		return nil, err
+	}
+	var profiles []definitions.ModelProfile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("invalid model profiles file %s: %w", config.ModelProfilesFile, err)
+	}
+	names := map[string]bool{}
+	for _, profile := range profiles {
+		if profile.Name == "" {
+			return nil, fmt.Errorf("model profile needs a name: %+v", profile)
+		}
+		if names[profile.Name] {
+			return nil, fmt.Errorf("model profile %s is defined twice", profile.Name)
+		}
+		names[profile.Name] = true
+		provider := profile.Provider
+		if provider == "" {
+			provider = config.Provider
+		}
+		if _, err := llm.NewProvider(&definitions.ModelConfig{Provider: provider}); err != nil {
+			return nil, fmt.Errorf("model profile %s: %w", profile.Name, err)
+		}
+		if _, err := phoneagent.ObservationBuilderFor(profile.Observation); err != nil {
+			return nil, fmt.Errorf("model profile %s: %w", profile.Name, err)
+		}
+	}
+	if config.ModelProfile != "" && !names[config.ModelProfile] {
+		return nil, fmt.Errorf("--model-profile %s is not in %s", config.ModelProfile, config.ModelProfilesFile)
+	}
+	return profiles, nil
+}
+
+// loadTaskFile reads --task-file and provisions its fixtures. The returned
+// function removes them again.
+func loadTaskFile(ctx context.Context, device phoneagent.Device) (func(), error) {
+	spec, err := fixture.LoadSpec(config.TaskFile)
+	if err != nil {
+		return nil, err
+	}
+	if config.Task == "" {
+		config.Task = spec.Task
+	}
+	if len(spec.Fixtures) == 0 {
+		return func() {}, nil
+	}
+
+	fixtureDevice, ok := device.(fixture.Device)
+	if !ok {
+		return nil, fmt.Errorf("fixtures are not supported by the %s device", config.DeviceType)
+	}
+	provisioned, err := fixture.Provision(ctx, fixtureDevice, config.DeviceID, spec.Fixtures)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		if err := provisioned.Cleanup(context.Background()); err != nil {
+			logs.Warnf("failed to clean up fixtures, err: %v", err)
+		}
+	}, nil
+}
+
+func newVoiceConfig() *definitions.VoiceConfig {
+	language := "zh"
+	if config.Lang == "en" {
+		language = "en"
+	}
+	return &definitions.VoiceConfig{
+		BaseURL:     getEnv("PHONE_AGENT_VOICE_BASE_URL", config.BaseURL),
+		APIKey:      getEnv("PHONE_AGENT_VOICE_API_KEY", config.APIKey),
+		ASRModel:    getEnv("PHONE_AGENT_ASR_MODEL", "whisper-1"),
+		ASRLanguage: getEnv("PHONE_AGENT_ASR_LANGUAGE", language),
+		TTSBackend:  config.TTS,
+		TTSModel:    getEnv("PHONE_AGENT_TTS_MODEL", "tts-1"),
+		TTSVoice:    getEnv("PHONE_AGENT_TTS_VOICE", ""),
+	}
+}
+
+// serveGroups serves the device group API and dashboard until interrupted.
+// Batch tasks run in sessions with the settings of phoneAgent, one per device.
+func serveGroups(ctx context.Context, tree *group.Tree, device phoneagent.Device, phoneAgent *phoneagent.PhoneAgent) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	listener, err := net.Listen("tcp", config.GroupsAddr)
+	if err != nil {
+		return err
+	}
+
+	manager := session.NewManager(device, phoneAgent.ModelConfig, phoneAgent.AgentConfig, session.Options{
+		MaxWorkers:      config.BatchWorkers,
+		DuplicateWindow: duplicateWindow(),
+	})
+	defer func() {
+		// the batches end with ctx, their tasks stop after the current step
+		drainCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		_ = manager.Shutdown(drainCtx)
+	}()
+	runner := group.RunnerFunc(func(ctx context.Context, deviceID, instruction string, force bool) (string, error) {
+		if force {
+			ctx = session.WithForce(ctx)
+		}
+		_, results, err := manager.Submit(ctx, deviceID, instruction)
+		if err != nil {
+			return "", err
+		}
+		result := <-results
+		return result.Message, result.Err
+	})
+	batches := group.NewBatches(ctx, tree, device, runner)
+
+	server := &http.Server{Handler: group.Handler(tree, device, batches)}
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+
+	logs.Infof("🗂️ device groups at http://%s/", listener.Addr())
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// serveTasks serves the task API until interrupted. Tasks run in sessions
+// with the settings of phoneAgent, one per device.
+func serveTasks(ctx context.Context, device phoneagent.Device, phoneAgent *phoneagent.PhoneAgent, groups *group.Tree) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var tenants []session.Tenant
+	if config.TenantsFile != "" {
+		var err error
+		if tenants, err = session.LoadTenants(config.TenantsFile); err != nil {
+			return err
+		}
+		logs.Infof("🛰️ %d tenant(s) share %d worker(s)", len(tenants), config.ServeWorkers)
+	}
+
+	listener, err := net.Listen("tcp", config.ServeAddr)
+	if err != nil {
+		return err
+	}
+
+	// the heartbeat keeps the adb and hdc connections, the scheduler learns
+	// from it which devices are online
+	var monitor *health.Monitor
+	if interval := getEnvFloat64("PHONE_AGENT_DEVICE_HEARTBEAT", 10); interval > 0 && (config.DeviceType == constants.ADB || config.DeviceType == constants.HDC) {
+		monitor = health.NewMonitor(device)
+		monitor.Watch(phoneAgent.AgentConfig.DeviceID)
+		if paired, err := android.LoadPairedDevices(config.PairedFile); config.PairedFile != "" && err == nil {
+			for _, d := range paired.List() {
+				monitor.Watch(d.Address)
+			}
+		}
+		for _, tenant := range tenants {
+			monitor.Watch(tenant.Devices...)
+		}
+		go monitor.Run(ctx, time.Duration(interval*float64(time.Second)))
+	}
+
+	// tasks outlive ctx, Shutdown interrupts them after the current step
+	tasks := server.NewTasks(context.WithoutCancel(ctx))
+	var tenantGroups session.DeviceGroups
+	if groups != nil {
+		tenantGroups = groups
+	}
+	manager := session.NewManager(device, phoneAgent.ModelConfig, phoneAgent.AgentConfig, session.Options{
+		MaxWorkers:          config.ServeWorkers,
+		MaxInFlightRequests: config.MaxInFlight,
+		DuplicateWindow:     duplicateWindow(),
+		Notify:              tasks.Notify,
+		OnStep:              tasks.OnStep,
+		OnEvent:             tasks.OnEvent,
+		Confirmer:           tasks,
+		Tenants:             tenants,
+		Groups:              tenantGroups,
+		Health:              monitor,
+	})
+	defer func() {
+		drainCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		_ = manager.Shutdown(drainCtx)
+	}()
+
+	var grouper server.Grouper
+	if groups != nil {
+		grouper = groups
+	}
+	pipelines := server.NewPipelines(tasks, manager)
+	if phoneAgent.Planner != nil {
+		// the planner splits the instructions of parallel pipelines too
+		pipelines.UseSplitter(&phoneagent.TaskSplitter{Planner: phoneAgent.Planner, Lang: phoneAgent.AgentConfig.Lang}, grouper)
+	}
+	schedules, err := server.LoadSchedules(config.SchedulesFile, tasks, manager, grouper)
+	if err != nil {
+		return err
+	}
+	go schedules.Run(ctx)
+
+	var setups *setup.Watcher
+	if config.SetupProfiles != "" {
+		setupDevice, ok := device.(setup.Device)
+		if !ok {
+			return fmt.Errorf("setup profiles are not supported by the %s device", config.DeviceType)
+		}
+		profiles, err := setup.LoadProfiles(config.SetupProfiles)
+		if err != nil {
+			return err
+		}
+		setups = setup.NewWatcher(setupDevice, device, profiles)
+		go setups.Run(ctx, time.Duration(getEnvFloat64("PHONE_AGENT_SETUP_INTERVAL", 10)*float64(time.Second)))
+		logs.Infof("🧰 %d setup profile(s) applied to the devices as they join", len(profiles))
+	}
+
+	var auth *server.Auth
+	if keysFile := getEnv("PHONE_AGENT_API_KEYS_FILE", ""); keysFile != "" {
+		var audit *server.Audit
+		if auditFile := getEnv("PHONE_AGENT_AUDIT_FILE", ""); auditFile != "" {
+			opened, err := server.OpenAudit(auditFile)
+			if err != nil {
+				return err
+			}
+			defer opened.Close()
+			audit = opened
+		}
+		loaded, err := server.LoadAuth(keysFile, audit)
+		if err != nil {
+			return err
+		}
+		auth = loaded
+		auth.UsePools(manager)
+		logs.Infof("🔑 the task API needs the api keys of %s", keysFile)
+	}
+
+	shares := server.NewShares(getEnv("PHONE_AGENT_SHARE_SECRET", ""))
+	httpServer := &http.Server{Handler: auth.Wrap(server.Handler(tasks, pipelines, schedules, shares, manager, device, setups, monitor))}
+	go func() {
+		<-ctx.Done()
+		_ = httpServer.Close()
+	}()
+
+	if config.GRPCAddr != "" {
+		grpcListener, err := net.Listen("tcp", config.GRPCAddr)
+		if err != nil {
+			return err
+		}
+		grpcServer := server.GRPCServer(tasks, manager, device, monitor, auth)
+		go func() {
+			<-ctx.Done()
+			grpcServer.Stop()
+		}()
+		go func() {
+			if err := grpcServer.Serve(grpcListener); err != nil {
+				logs.Errorf("gRPC server stopped, err: %v", err)
+			}
+		}()
+		logs.Infof("🛰️ gRPC task API at %s", grpcListener.Addr())
+	}
+
+	logs.Infof("🛰️ task API at http://%s/api/tasks, pipelines at /api/pipelines", listener.Addr())
+	if err := httpServer.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// runOnDevices runs the task and the instructions of --task-list on every
+// device of --devices at once, in sessions with the settings of phoneAgent,
+// and prints the report.
+func runOnDevices(ctx context.Context, device phoneagent.Device, phoneAgent *phoneagent.PhoneAgent) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var deviceIDs []string
+	if config.Devices == "all" {
+		devices, err := device.ListDevices(ctx)
+		if err != nil {
+			return err
+		}
+		for _, d := range devices {
+			if d.Status == "device" {
+				deviceIDs = append(deviceIDs, d.DeviceID)
+			}
+		}
+	} else {
+		for _, id := range strings.Split(config.Devices, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				deviceIDs = append(deviceIDs, id)
+			}
+		}
+	}
+	if len(deviceIDs) == 0 {
+		return fmt.Errorf("no devices to run on")
+	}
+
+	var instructions []string
+	if config.Task != "" {
+		instructions = append(instructions, config.Task)
+	}
+	if config.TaskList != "" {
+		data, err := os.ReadFile(config.TaskList)
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				instructions = append(instructions, line)
+			}
+		}
+	}
+	if len(instructions) == 0 {
+		return fmt.Errorf("--devices needs a task or --task-list")
+	}
+
+	workers := config.Workers
+	if workers <= 0 {
+		workers = len(deviceIDs)
+	}
+	manager := session.NewManager(device, phoneAgent.ModelConfig, phoneAgent.AgentConfig, session.Options{
+		MaxWorkers:          workers,
+		MaxInFlightRequests: config.MaxInFlight,
+		QueueSize:           len(instructions),
+		DuplicateWindow:     duplicateWindow(),
+	})
+	defer func() {
+		drainCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		_ = manager.Shutdown(drainCtx)
+	}()
+
+	if config.Force {
+		ctx = session.WithForce(ctx)
+	}
+	logs.Infof("📱 %d task(s) on %d device(s), %d at a time", len(instructions), len(deviceIDs), workers)
+	report := manager.RunAll(ctx, deviceIDs, instructions)
+	logging.Rule("=")
+	logs.Info(report.String())
+	if report.Failed > 0 {
+		return fmt.Errorf("%d of %d task(s) failed", report.Failed, len(report.Results))
+	}
+	return nil
+}
+
+// runSuite runs the cases of --suite, in sessions with the settings of
+// phoneAgent, prints the report and writes it to --report-dir. The cases
+// without a device are spread over the emulators when there are some.
+func runSuite(ctx context.Context, device phoneagent.Device, phoneAgent *phoneagent.PhoneAgent, groups *group.Tree, emulators []string) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// checked by validateArgs
+	s, _ := suite.Load(config.Suite)
+	manager := session.NewManager(device, phoneAgent.ModelConfig, phoneAgent.AgentConfig, session.Options{
+		MaxWorkers:          config.SuiteParallel,
+		MaxInFlightRequests: config.MaxInFlight,
+		QueueSize:           len(s.Cases),
+	})
+	defer func() {
+		drainCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		_ = manager.Shutdown(drainCtx)
+	}()
+
+	opts := suite.Options{Device: phoneAgent.AgentConfig.DeviceID, Devices: emulators, Parallel: config.SuiteParallel, Driver: device}
+	if groups != nil {
+		opts.DevicesOf = groups.Devices
+	}
+	if config.EvalProfiles == "" {
+		logs.Infof("🧪 suite %s: %d case(s), %d at a time", s.Name, len(s.Cases), config.SuiteParallel)
+		report, err := runSuiteOnce(ctx, manager, s, opts, config.ReportDir)
+		if err != nil {
+			return err
+		}
+		if report.Failed > 0 {
+			return fmt.Errorf("%d of %d case(s) failed", report.Failed, len(report.Results))
+		}
+		return nil
+	}
+
+	// every profile runs the same cases on the same devices, one profile
+	// after another
+	var reports []*suite.Report
+	for _, profile := range strings.Split(config.EvalProfiles, ",") {
+		opts.Profile = strings.TrimSpace(profile)
+		model := phoneAgent.ModelConfig.ModelName
+		if opts.Profile == "main" {
+			opts.Profile = ""
+		} else if p, ok := phoneagent.LookupModelProfile(opts.Profile); !ok {
+			return fmt.Errorf("unknown model profile %q, see --model-profiles-file", opts.Profile)
+		} else if p.Model != "" {
+			model = p.Model
+		}
+		dir := filepath.Join(config.ReportDir, cmp.Or(opts.Profile, "main"))
+		logs.Infof("🧪 suite %s with %s (%s): %d case(s), %d at a time", s.Name, cmp.Or(opts.Profile, "main"), model, len(s.Cases), config.SuiteParallel)
+		report, err := runSuiteOnce(ctx, manager, s, opts, dir)
+		if err != nil {
+			return err
+		}
+		report.Model = model
+		reports = append(reports, report)
+	}
+	comparison := suite.Compare(reports)
+	logging.Rule("=")
+	logs.Info(comparison.String())
+	if err := comparison.Write(config.ReportDir); err != nil {
+		return fmt.Errorf("failed to write the comparison: %w", err)
+	}
+	logs.Infof("📊 comparison written to %s", filepath.Join(config.ReportDir, "comparison.json"))
+	return nil
+}
+
+// runSuiteOnce runs the cases of s, prints the report and writes it to dir.
+func runSuiteOnce(ctx context.Context, manager *session.Manager, s *suite.Suite, opts suite.Options, dir string) (*suite.Report, error) {
+	report, err := suite.Run(ctx, manager, s, opts)
+	if err != nil {
+		return nil, err
+	}
+	logging.Rule("=")
+	logs.Info(report.String())
+	if err := report.Write(dir); err != nil {
+		return nil, fmt.Errorf("failed to write the report: %w", err)
+	}
+	logs.Infof("📊 report written to %s", filepath.Join(dir, "report.html"))
+	return report, nil
+}
+
+// newEmulatorFarm creates the farm of --emulators, docker containers of
+// --emulator-image or instances of --emulator-avd.
+func newEmulatorFarm() (*emulator.Farm, error) {
+	spec := emulator.Spec{
+		Backend:     emulator.AVD,
+		AVD:         config.EmulatorAVD,
+		SystemImage: getEnv("PHONE_AGENT_EMULATOR_SYSTEM_IMAGE", ""),
+		BootTimeout: time.Duration(getEnvFloat64("PHONE_AGENT_EMULATOR_BOOT_TIMEOUT", 300) * float64(time.Second)),
+	}
+	if config.EmulatorImage != "" {
+		spec.Backend, spec.Image = emulator.Docker, config.EmulatorImage
+	}
+	return emulator.NewFarm(spec)
+}
+
+// newImagePool starts the workers screenshots of every session are encoded
+// on, nil when PHONE_AGENT_IMAGE_WORKERS is 0.
+func newImagePool() (*imaging.Pool, error) {
+	workers := getEnvInt("PHONE_AGENT_IMAGE_WORKERS", runtime.NumCPU())
+	if workers <= 0 {
+		return nil, nil
+	}
+	var accelerator imaging.Accelerator
+	if accel := getEnv("PHONE_AGENT_IMAGE_ACCEL", ""); accel != "" {
+		name, hwaccel, _ := strings.Cut(accel, ":")
+		if name != "ffmpeg" {
+			return nil, fmt.Errorf("unknown image accelerator %q, want ffmpeg or ffmpeg:<hwaccel>", accel)
+		}
+		ffmpeg, err := imaging.NewFFmpeg(hwaccel, getEnv("PHONE_AGENT_IMAGE_JPEG_ENCODER", ""))
+		if err != nil {
+			return nil, err
+		}
+		accelerator = ffmpeg
+	}
+	return imaging.NewPool(workers, getEnvInt("PHONE_AGENT_IMAGE_QUEUE", 2*workers), accelerator), nil
+}
+
+// splitList splits a comma separated flag, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// duplicateWindow converts --duplicate-window to session.Options, where 0
+// means the default.
+func duplicateWindow() time.Duration {
+	if config.DuplicateWindow == 0 {
+		return -1
+	}
+	return time.Duration(config.DuplicateWindow) * time.Second
+}
+
+// applyGroupDefaults fills the settings not given by flag or environment
+// from the device groups of --device-id. The agents apply the defaults of
+// their groups at every task too, see phoneagent.UseGroupDefaults.
+func applyGroupDefaults(tree *group.Tree) {
+	if config.DeviceID == "" {
+		return
+	}
+	defaults := withoutExplicit(tree.Effective(config.DeviceID))
+	if defaults.Model != "" {
+		config.Model = defaults.Model
+	}
+	if defaults.BaseURL != "" {
+		config.BaseURL = defaults.BaseURL
+	}
+	if defaults.MaxSteps != 0 {
+		config.MaxSteps = defaults.MaxSteps
+	}
+	if defaults.Lang != "" {
+		config.Lang = defaults.Lang
+	}
+	if defaults.UILanguage != "" {
+		config.UILang = defaults.UILanguage
+	}
+	if defaults.Provider != "" {
+		config.Provider = defaults.Provider
+	}
+	if defaults.PolicyFile != "" {
+		config.PolicyFile = defaults.PolicyFile
+	}
+	if defaults.Pacing != "" {
+		config.Pacing = defaults.Pacing
+	}
+	if path := tree.Path(tree.GroupOf(config.DeviceID)); len(path) > 0 {
+		logs.Infof("🗂️ device group: %s", strings.Join(path, " / "))
+	}
+}
+
+// groupSettings are the group defaults by JSON field name, with the flag and
+// the environment variable that win over them.
+var groupSettings = []struct{ field, flag, env string }{
+	{"model", "model", "PHONE_AGENT_MODEL"},
+	{"base_url", "base-url", "PHONE_AGENT_BASE_URL"},
+	{"provider", "provider", "PHONE_AGENT_PROVIDER"},
+	{"model_profile", "model-profile", "PHONE_AGENT_MODEL_PROFILE"},
+	{"temperature", "", "PHONE_AGENT_TEMPERATURE"},
+	{"max_steps", "max-steps", "PHONE_AGENT_MAX_STEPS"},
+	{"lang", "lang", "PHONE_AGENT_LANG"},
+	{"ui_lang", "ui-lang", "PHONE_AGENT_UI_LANG"},
+	{"policy_file", "policy-file", "PHONE_AGENT_POLICY_FILE"},
+	{"pacing", "pacing", "PHONE_AGENT_PACING"},
+}
+
+// explicitSetting returns how a setting was given, empty when neither by
+// flag nor by environment.
+func explicitSetting(flag, env string) string {
+	if flag != "" && rootCmd.PersistentFlags().Changed(flag) {
+		return "flag --" + flag
+	}
+	if os.Getenv(env) != "" {
+		return "env " + env
+	}
+	return ""
+}
+
+// withoutExplicit returns defaults without the settings given by flag or
+// environment, so that those win.
+func withoutExplicit(defaults definitions.GroupDefaults) definitions.GroupDefaults {
+	fields := map[string]json.RawMessage{}
+	_ = json.Unmarshal([]byte(utils.JsonString(defaults)), &fields)
+	for _, setting := range groupSettings {
+		if explicitSetting(setting.flag, setting.env) != "" {
+			delete(fields, setting.field)
+		}
+	}
+	var kept definitions.GroupDefaults
+	data, _ := json.Marshal(fields)
+	_ = json.Unmarshal(data, &kept)
+	return kept
+}
+
+// showSettings prints the settings a device group may give and which one
+// --device-id runs with.
+func showSettings(tree *group.Tree) {
+	current := map[string]any{
+		"model":         config.Model,
+		"base_url":      config.BaseURL,
+		"provider":      config.Provider,
+		"model_profile": config.ModelProfile,
+		"temperature":   getEnvFloat32("PHONE_AGENT_TEMPERATURE", 0.0),
+		"max_steps":     config.MaxSteps,
+		"lang":          config.Lang,
+		"ui_lang":       config.UILang,
+		"policy_file":   config.PolicyFile,
+		"pacing":        config.Pacing,
+	}
+	fromGroups := map[string]any{}
+	sources := map[string]string{}
+	if tree != nil {
+		_ = json.Unmarshal([]byte(utils.JsonString(tree.Effective(config.DeviceID))), &fromGroups)
+		sources = tree.Sources(config.DeviceID)
+		if path := tree.Path(tree.GroupOf(config.DeviceID)); len(path) > 0 {
+			fmt.Printf("Device %s in group %s\n", config.DeviceID, strings.Join(path, " / "))
+		} else {
+			fmt.Printf("Device %s in no group\n", config.DeviceID)
+		}
+	}
+	for _, setting := range groupSettings {
+		value, source := current[setting.field], explicitSetting(setting.flag, setting.env)
+		if grouped, ok := fromGroups[setting.field]; ok && source == "" {
+			value, source = grouped, "group "+strings.Join(tree.Path(sources[setting.field]), " / ")
+		}
+		if source == "" {
+			source = "default"
+		}
+		if value == "" {
+			value = "-"
+		}
+		fmt.Printf("  %-14s %-36v %s\n", setting.field, value, source)
+	}
+}
+
+// serveTriggers lets the phone start the tasks of --triggers-file on itself
+// until interrupted.
+func serveTriggers(ctx context.Context, device phoneagent.Device, phoneAgent *phoneagent.PhoneAgent) error {
+	tasks, err := trigger.LoadTasks(config.TriggersFile)
+	if err != nil {
+		return err
+	}
+	triggerDevice, ok := device.(trigger.Device)
+	if !ok {
+		return fmt.Errorf("triggers are not supported by the %s device", config.DeviceType)
+	}
+
+	token := getEnv("PHONE_AGENT_TRIGGER_TOKEN", "")
+	if token == "" {
+		token = trigger.NewToken()
+	}
+	server := trigger.NewServer(tasks, func(ctx context.Context, task string) (string, error) {
+		defer phoneAgent.Reset(ctx)
+		result, err := phoneAgent.Run(ctx, task)
+		if err != nil {
+			logs.Errorf("Error running task: %v", err)
+			return "", err
+		}
+		logs.Infof("🎉 %s: %s", helper.GetOutputMessage("result", config.Lang), result)
+		logVerdict(phoneAgent)
+		logOutcome(ctx, phoneAgent)
+		logOutput(phoneAgent)
+		exportTrajectory(phoneAgent)
+		return result, nil
+	}, token)
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", config.TriggerPort))
+	if err != nil {
+		return err
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	cleanup, err := server.Install(ctx, triggerDevice, config.DeviceID, port)
+	if err != nil {
+		listener.Close()
+		return err
+	}
+	defer cleanup()
+
+	logs.Infof("📲 trigger page on the device: %s", server.URL(port, "/"))
+	names := lo.Keys(tasks)
+	sort.Strings(names)
+	for _, name := range names {
+		logs.Infof("   %s: %s", name, server.URL(port, "/run/"+url.PathEscape(name)))
+	}
+	logs.Info("Press Ctrl+C to stop.")
+	return server.Serve(ctx, listener)
+}
+
+// voiceTask transcribes the task from an audio file, or from the microphone
+// when source is "mic".
+func voiceTask(ctx context.Context, transcriber *voice.Transcriber, source string) (string, error) {
+	if source != "mic" {
+		return transcriber.Transcribe(ctx, source)
+	}
+	logs.Infof("🎙️ Listening for %d seconds...", config.VoiceSeconds)
+	return voice.RecordTask(ctx, transcriber, config.VoiceSeconds)
+}
+
+// rewind runs the model forward from --rewind-step of the recorded session
+// --rewind, with the task and observation changed as asked, and prints how
+// its actions compare to the recorded ones.
+func rewind(ctx context.Context, phoneAgent *phoneagent.PhoneAgent) error {
+	records, err := recorder.Load(config.Rewind)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(config.Rewind)
+	for _, record := range records {
+		if record.Step != config.RewindStep || record.Snapshot == "" {
+			continue
+		}
+		snapshot, err := recorder.LoadSnapshot(dir, record)
+		if err != nil {
+			return err
+		}
+		logs.Infof("⏪ step %d of %s: %d message(s), %d dropped by the history caps", snapshot.Step, snapshot.Task, len(snapshot.Messages), snapshot.DroppedMessages)
+		if snapshot.Plan != "" {
+			logs.Infof("   plan:\n%s", snapshot.Plan)
+		}
+		if snapshot.Notes != "" {
+			logs.Infof("   notes: %s", snapshot.Notes)
+		}
+		for _, decision := range snapshot.Policy {
+			logs.Infof("   policy: %s %s by %s (%s)", decision.Decision, helper.FormatAction(decision.Action), decision.Rule, decision.Reason)
+		}
+	}
+
+	observation := config.RewindObservation
+	if path, ok := strings.CutPrefix(observation, "@"); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		observation = string(data)
+	}
+	comparison, err := recorder.Rewind(ctx, phoneAgent.ModelClient, dir, records, recorder.RewindOptions{
+		Step:        config.RewindStep,
+		Instruction: config.RewindTask,
+		Observation: observation,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println(comparison.String())
+	return nil
+}
+
+// interactive reads instructions from the terminal and runs each one as a
+// follow-up of the previous one on the same device, see
+// PhoneAgent.Continue, until /quit. Ctrl+C aborts the running instruction
+// only.
+func interactive(ctx context.Context, phoneAgent *phoneagent.PhoneAgent, transcriber *voice.Transcriber) {
+	logs.Info("Entering interactive mode. Instructions continue the session, type /help for commands, /quit to exit.")
+
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Print("> ")
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				logs.Info("Goodbye!")
+				return
+			}
+			logs.Errorf("Error reading input: %v", err)
+			continue
+		}
+
+		task := strings.TrimSpace(line)
+		if task == "" {
+			continue
+		}
+		command, arg, _ := strings.Cut(task, " ")
+		switch strings.ToLower(command) {
+		case "/quit", "/exit", "quit", "exit", "q":
+			logs.Info("Goodbye!")
+			return
+		case "/help":
+			fmt.Println(interactiveHelp)
+			continue
+		case "/new":
+			phoneAgent.Reset(ctx)
+			logs.Info("🆕 new session, the next instruction starts from scratch")
+			continue
+		case "/screen":
+			saveLastScreenshot(phoneAgent, strings.TrimSpace(arg))
+			continue
+		case "/action":
+			logLastAction(phoneAgent)
+			continue
+		case "/undo":
+			if err := phoneAgent.Undo(ctx); err != nil {
+				logs.Errorf("❌ undo failed, err: %v", err)
+			} else {
+				logs.Info("↩️ pressed Back, the model is told in the next step")
+			}
+			continue
+		case "/voice", "voice":
+			task, err = voiceTask(ctx, transcriber, "mic")
+			if err != nil {
+				logs.Errorf("❌ voice input failed, err: %v", err)
+				continue
+			}
+			logs.Infof("Task: %s", task)
+		default:
+			if strings.HasPrefix(command, "/") {
+				logs.Warnf("unknown command %s, type /help for commands", command)
+				continue
+			}
+		}
+
+		fmt.Println()
+		runCtx, stop := signal.NotifyContext(ctx, os.Interrupt)
+		result, err := phoneAgent.Continue(runCtx, task)
+		aborted := runCtx.Err() != nil && ctx.Err() == nil
+		stop()
+		if aborted {
+			logs.Warn("⏹️ instruction aborted, type the next one or /new to start over")
+			continue
+		}
+		if err != nil {
+			logs.Errorf("Error: %v", err)
+			continue
+		}
+
+		logs.Infof("🎉 %s: %s", helper.GetOutputMessage("result", config.Lang), result)
+		logVerdict(phoneAgent)
+		logOutcome(ctx, phoneAgent)
+		logOutput(phoneAgent)
+		exportTrajectory(phoneAgent)
+	}
+}
+
+const interactiveHelp = `  <instruction>   run it in the current session, e.g. "now turn on dark mode"
+  /new            start a new session
+  /screen [path]  save the last screenshot, to screen.png by default
+  /action         show the last parsed action and its result
+  /undo           press Back to revert the last action
+  /voice          speak the instruction
+  /quit           exit
+  Ctrl+C aborts the running instruction.`
+
+// saveLastScreenshot writes the screenshot of the last step to path.
+func saveLastScreenshot(phoneAgent *phoneagent.PhoneAgent, path string) {
+	screenshot := phoneAgent.LastScreenshot()
+	if screenshot == nil || len(screenshot.Data) == 0 {
+		logs.Warn("no screenshot yet")
+		return
+	}
+	if path == "" {
+		path = "screen.png"
+	}
+	if err := os.WriteFile(path, screenshot.Data, 0o600); err != nil {
+		logs.Errorf("❌ saving screenshot failed, err: %v", err)
+		return
+	}
+	logs.Infof("📸 %dx%d screenshot saved to %s", screenshot.Width, screenshot.Height, path)
+}
+
+// logLastAction prints the last action of the session as parsed, with its
+// result and the reasoning behind it.
+func logLastAction(phoneAgent *phoneagent.PhoneAgent) {
+	t := phoneAgent.Trajectory
+	if t == nil || len(t.Steps) == 0 {
+		logs.Warn("no action yet")
+		return
+	}
+	step := t.Steps[len(t.Steps)-1]
+	status := "ok"
+	if !step.Success {
+		status = "failed"
+	}
+	logs.Infof("🎯 step %d on %s: %s (%s)", step.Index, step.App, helper.FormatAction(step.Action), status)
+	if step.Message != "" {
+		logs.Infof("   message: %s", step.Message)
+	}
+	if step.Thinking != "" {
+		logs.Infof("   thinking: %s", step.Thinking)
+	}
+}
+
+// logOutcome prints how the finished task ended, and its summary when
+// --outcome-templates has a cli template.
+func logOutcome(ctx context.Context, phoneAgent *phoneagent.PhoneAgent) {
+	outcome := phoneAgent.Outcome
+	if outcome == nil {
+		return
+	}
+	if outcome.Detail != "" {
+		logs.Infof("🏁 outcome: %s (%s), %s", outcome.Level, outcome.Reason, outcome.Detail)
+	} else {
+		logs.Infof("🏁 outcome: %s (%s)", outcome.Level, outcome.Reason)
+	}
+	if summary := phoneAgent.Summary(ctx, phoneagent.ChannelCLI); summary != "" {
+		fmt.Println(summary)
+	}
+}
+
+// logVerdict prints the judge's verdict of the finished task, if any. It is
+// also saved in the trajectory, see --export-format json.
+func logVerdict(phoneAgent *phoneagent.PhoneAgent) {
+	if t := phoneAgent.Trajectory; t != nil && t.Verdict != nil {
+		status := "FAIL"
+		if t.Verdict.Pass {
+			status = "PASS"
+		}
+		logs.Infof("⚖️ judge (%s): %s, %s", t.Verdict.Model, status, t.Verdict.Reason)
+	}
+}
+
+// logOutput prints the fields of --output-schema extracted from the result of
+// the finished task, if any.
+func logOutput(phoneAgent *phoneagent.PhoneAgent) {
+	if phoneAgent.Output == nil {
+		return
+	}
+	data, err := json.Marshal(phoneAgent.Output)
+	if err != nil {
+		logs.Warnf("failed to encode the output, err: %v", err)
+		return
+	}
+	logs.Infof("📦 output: %s", data)
+}
+
+// exportTrajectory writes the finished task as a replay script when
+// --export-script is set. Failed runs are not exported.
+func exportTrajectory(phoneAgent *phoneagent.PhoneAgent) {
+	t := phoneAgent.Trajectory
+	if config.ExportScript == "" || t == nil {
+		return
+	}
+	if !t.Succeeded() {
+		logs.Warn("task did not finish successfully, replay script not exported")
+		return
+	}
+
+	file, err := os.Create(config.ExportScript)
+	if err != nil {
+		logs.Errorf("❌ exporting replay script failed, err: %v", err)
+		return
+	}
+	defer file.Close()
+
+	if err := trajectory.Export(file, t, config.ExportFormat); err != nil {
+		logs.Errorf("❌ exporting replay script failed, err: %v", err)
+		return
+	}
+	logs.Infof("📼 replay script written to %s", config.ExportScript)
+}
+
+// loadRedact reads the --redact-file, if any, with the patterns of --redact.
+func loadRedact() (definitions.RedactConfig, error) {
+	var redactConfig definitions.RedactConfig
+	if config.RedactFile != "" {
+		data, err := os.ReadFile(config.RedactFile)
+		if err != nil {
+			return redactConfig, err
+		}
+		if err := json.Unmarshal(data, &redactConfig); err != nil {
+			return redactConfig, fmt.Errorf("invalid redact file %s: %w", config.RedactFile, err)
+		}
+	}
+	for _, name := range strings.Split(config.Redact, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if _, ok := redact.Patterns[name]; !ok {
+			return redactConfig, fmt.Errorf("invalid --redact %s, want phone, bank_card, id_card or email", name)
+		}
+		redactConfig.Patterns = append(redactConfig.Patterns, name)
+	}
+	if _, err := redact.New(redactConfig); err != nil {
+		return redactConfig, err
+	}
+	return redactConfig, nil
+}
+
+// startTracing exports the spans of the run to --otlp-endpoint, with the
+// headers and service name of the standard OTEL_* variables.
+func startTracing() (stop func(ctx context.Context), err error) {
+	headers, err := tracing.ParseHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"))
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := tracing.NewExporter(config.OTLPEndpoint, headers, getEnv("OTEL_SERVICE_NAME", "autoglm-go"))
+	if err != nil {
+		return nil, err
+	}
+	logs.Infof("🔭 exporting traces to %s", config.OTLPEndpoint)
+	return tracing.Use(exporter), nil
+}
+
+// serveMetrics serves the metrics at --metrics-addr until ctx is done.
+func serveMetrics(ctx context.Context) error {
+	listener, err := net.Listen("tcp", config.MetricsAddr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics.Handler())
+	httpServer := &http.Server{Handler: mux}
+	go func() {
+		<-ctx.Done()
+		_ = httpServer.Close()
+	}()
+	go func() {
+		if err := httpServer.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			logs.Errorf("metrics server stopped, err: %v", err)
+		}
+	}()
+	logs.Infof("📈 metrics at http://%s/metrics", listener.Addr())
+	return nil
+}
+
+// exportDataset converts the sessions of --dataset-from into the fine-tuning
+// samples of --export-dataset.
+// manageMemories prints the facts of --memory-file with --list-memories, or
+// removes the ones of --prune-memories.
+func manageMemories() error {
+	store, err := memory.Shared(config.MemoryFile)
+	if err != nil {
+		return err
+	}
+	if config.PruneMemories == "" {
+		facts := store.List(config.MemoryApp)
+		logs.Infof("📚 %d fact(s) in %s", len(facts), config.MemoryFile)
+		for _, f := range facts {
+			used := "never used"
+			if f.UsedAt != nil {
+				used = "last used " + f.UsedAt.Format(time.DateOnly)
+			}
+			logs.Infof(" - [%s] %s: %s (%d use(s), %s)", f.ID, f.App, f.Text, f.Uses, used)
+		}
+		return nil
+	}
+
+	var match func(memory.Fact) bool
+	switch spec := config.PruneMemories; {
+	case spec == "all":
+		match = func(memory.Fact) bool { return true }
+	case strings.HasPrefix(spec, "unused:"):
+		unused, _ := time.ParseDuration(strings.TrimPrefix(spec, "unused:"))
+		since := time.Now().Add(-unused)
+		match = func(f memory.Fact) bool {
+			return f.CreatedAt.Before(since) && (f.UsedAt == nil || f.UsedAt.Before(since))
+		}
+	default:
+		ids := map[string]bool{}
+		for _, id := range strings.Split(spec, ",") {
+			ids[strings.TrimSpace(id)] = true
+		}
+		match = func(f memory.Fact) bool { return ids[f.ID] }
+	}
+	removed, err := store.Remove(func(f memory.Fact) bool {
+		return !match(f) || (config.MemoryApp != "" && f.App != config.MemoryApp)
+	})
+	if err != nil {
+		return err
+	}
+	logs.Infof("📚 removed %d fact(s) from %s", removed, config.MemoryFile)
+	return nil
+}
+
+func exportDataset() error {
+	from := config.DatasetFrom
+	if from == "" {
+		from = config.RecordDir
+	}
+	var sessions []string
+	for _, source := range strings.Split(from, ",") {
+		source = strings.TrimSpace(source)
+		if info, err := os.Stat(source); err == nil && info.IsDir() {
+			files, err := filepath.Glob(filepath.Join(source, "*.jsonl"))
+			if err != nil {
+				return err
+			}
+			sessions = append(sessions, files...)
+		} else if source != "" {
+			sessions = append(sessions, source)
+		}
+	}
+	if len(sessions) == 0 {
+		return fmt.Errorf("no recorded sessions in %s", from)
+	}
+
+	file, err := os.Create(config.ExportDataset)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	dataset, err := recorder.NewDataset(w, recorder.DatasetOptions{Outcome: config.DatasetOutcome, ImagePrefix: config.DatasetImagePrefix})
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		records, err := recorder.Load(session)
 		if err != nil {
-			logs.Errorf("Error running task: %v", err)
-			return
+			return err
 		}
-		logs.Infof("🎉 %s: %s", helper.GetMessage("result", config.Lang), result)
-	} else {
-		// Interactive mode
-		logs.Info("Entering interactive mode. Type 'quit' to exit.")
+		if err := dataset.Add(filepath.Dir(session), records); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	stats := dataset.Stats
+	logs.Infof("🎓 %d sample(s) of %d task(s) written to %s; left out %d task(s) of another outcome, %d failed step(s), %d duplicate(s)",
+		stats.Samples, stats.Tasks, config.ExportDataset, stats.Filtered, stats.Failed, stats.Duplicates)
+	return nil
+}
 
-		reader := bufio.NewReader(os.Stdin)
-		for {
-			fmt.Print("Enter your task: ")
-			task, err := reader.ReadString('\n')
-			if err != nil {
-				logs.Errorf("Error reading input: %v", err)
-				continue
-			}
+// envOfFlag is the environment variable of a flag when it is not named after
+// it, see flagEnv.
+var envOfFlag = map[string]string{
+	"apikey":         "PHONE_AGENT_API_KEY",
+	"planner-apikey": "PHONE_AGENT_PLANNER_API_KEY",
+	"judge-apikey":   "PHONE_AGENT_JUDGE_API_KEY",
+}
 
-			task = strings.TrimSpace(task)
+// flagEnv is the PHONE_AGENT_* variable that sets the default of a flag.
+func flagEnv(name string) string {
+	if env, ok := envOfFlag[name]; ok {
+		return env
+	}
+	return "PHONE_AGENT_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
 
-			// Check for quit commands
-			if strings.ToLower(task) == "quit" || strings.ToLower(task) == "exit" || strings.ToLower(task) == "q" {
-				logs.Info("Goodbye!")
-				break
-			}
+var (
+	// configValues are the values of --config at startup
+	configValues configfile.Values
+	// configPinned are the keys of --config set by the command line or the
+	// environment, which win over the file and are not reloaded
+	configPinned = map[string]bool{}
+	// reloaded are the settings of the reloads so far
+	reloaded definitions.Settings
+)
 
-			// Skip empty tasks
-			if task == "" {
+// applyConfigFile sets the flags of cmd, and the PHONE_AGENT_* variables of
+// the other keys, from the config file at path, unless the command line or
+// the environment already sets them.
+func applyConfigFile(cmd *cobra.Command, path string) error {
+	values, err := configfile.Load(path)
+	if err != nil {
+		return err
+	}
+	for _, key := range values.Keys() {
+		value, _ := values.Get(key)
+		flag := cmd.Flags().Lookup(key)
+		if flag == nil {
+			// an environment variable, read when the configs are built
+			env := flagEnv(key)
+			if os.Getenv(env) != "" {
+				configPinned[key] = true
 				continue
 			}
+			if err := os.Setenv(env, value); err != nil {
+				return fmt.Errorf("config file %s: %s: %w", path, key, err)
+			}
+			continue
+		}
+		if flag.Changed || os.Getenv(flagEnv(key)) != "" {
+			configPinned[key] = true
+			continue
+		}
+		// repeatable flags take the items of a list one by one
+		items := []string{value}
+		if strings.Contains(flag.Value.Type(), "Array") || strings.Contains(flag.Value.Type(), "Slice") {
+			items = values[key]
+		}
+		for _, item := range items {
+			if err := flag.Value.Set(item); err != nil {
+				return fmt.Errorf("config file %s: invalid %s %q: %w", path, key, item, err)
+			}
+		}
+	}
+	configValues = values
+	logs.Debugf("🔧 %d setting(s) read from %s", len(values), path)
+	return nil
+}
 
-			fmt.Println()
-			result, err := phoneAgent.Run(ctx, task)
-			if err != nil {
-				logs.Errorf("Error: %v", err)
+// reloadConfigFile applies the settings of the config file that change
+// without a restart: log-level at once, max-steps and temperature from the
+// next task on. The others are reported as needing a restart.
+func reloadConfigFile(values configfile.Values) {
+	settings := reloaded
+	var applied []string
+	for _, key := range values.Keys() {
+		value, _ := values.Get(key)
+		if previous, _ := configValues.Get(key); previous == value && configValues[key] != nil || configPinned[key] {
+			continue
+		}
+		switch key {
+		case "log-level":
+			levels := value
+			if config.Debug {
+				levels = "debug," + levels
+			}
+			if err := logging.SetLevels(levels); err != nil {
+				logs.Warnf("🔧 log-level not reloaded, err: %v", err)
 				continue
 			}
-
-			logs.Infof("🎉 %s: %s", helper.GetMessage("result", config.Lang), result)
-
-			// Reset agent for next task
-			phoneAgent.Reset(ctx)
+		case "max-steps":
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				logs.Warnf("🔧 max-steps not reloaded, invalid value %q", value)
+				continue
+			}
+			settings.MaxSteps = n
+		case "temperature":
+			t, err := strconv.ParseFloat(value, 32)
+			if err != nil || t < 0 || t > 2 {
+				logs.Warnf("🔧 temperature not reloaded, invalid value %q", value)
+				continue
+			}
+			temperature := float32(t)
+			settings.Temperature = &temperature
+		default:
+			logs.Warnf("🔧 %s changed in %s, restart to apply it", key, config.ConfigFile)
+			continue
 		}
+		applied = append(applied, key+"="+value)
 	}
-
+	configValues = values
+	if len(applied) == 0 {
+		return
+	}
+	reloaded = settings
+	phoneagent.ReloadSettings(settings)
+	logs.Infof("🔧 config file reloaded: %s", strings.Join(applied, ", "))
 }
 
 func parseArgs() *Config {
@@ -350,14 +2994,257 @@ func parseArgs() *Config {
 }
 
 func validateArgs(cmd *cobra.Command, args []string) error {
+	if config.ConfigFile != "" {
+		if err := applyConfigFile(cmd, config.ConfigFile); err != nil {
+			return err
+		}
+	}
+
 	// Validate lang and device-type choices
 	if config.Lang != "cn" && config.Lang != "en" {
 		return fmt.Errorf("invalid language option: %s. Must be 'cn' or 'en'", config.Lang)
 	}
 
-	// only allow adb for now
-	if config.DeviceType != "adb" {
-		return fmt.Errorf("invalid device type: %s. Must be 'adb'", config.DeviceType)
+	switch config.DeviceType {
+	case constants.ADB, constants.IOS, constants.HDC, constants.APPIUM:
+	default:
+		return fmt.Errorf("invalid device type: %s. Must be 'adb', 'ios', 'hdc' or 'appium'", config.DeviceType)
+	}
+	switch config.ExportFormat {
+	case trajectory.FormatADB, trajectory.FormatAppiumPython, trajectory.FormatJSON:
+	default:
+		return fmt.Errorf("invalid export format: %s", config.ExportFormat)
+	}
+	switch config.OCR {
+	case "":
+	case "tesseract":
+		if _, err := ocr.NewTesseract(""); err != nil {
+			return fmt.Errorf("--ocr tesseract: %w", err)
+		}
+	default:
+		return fmt.Errorf("invalid ocr engine: %s. Must be tesseract", config.OCR)
+	}
+	if _, err := imaging.ParseFormat(getEnv("PHONE_AGENT_IMAGE_FORMAT", "png")); err != nil {
+		return fmt.Errorf("invalid PHONE_AGENT_IMAGE_FORMAT: %w", err)
+	}
+	if quality := getEnvInt("PHONE_AGENT_IMAGE_QUALITY", 0); quality < 0 || quality > 100 {
+		return fmt.Errorf("invalid PHONE_AGENT_IMAGE_QUALITY: %d. Must be 1-100, or 0 for the default", quality)
+	}
+	if config.ExportDataset != "" {
+		if config.DatasetFrom == "" && config.RecordDir == "" {
+			return fmt.Errorf("--export-dataset requires --dataset-from or --record-dir")
+		}
+		if _, err := recorder.NewDataset(io.Discard, recorder.DatasetOptions{Outcome: config.DatasetOutcome}); err != nil {
+			return fmt.Errorf("invalid --dataset-outcome: %w", err)
+		}
+	}
+	switch config.TTS {
+	case "", voice.TTSSystem, voice.TTSOpenAI:
+	default:
+		return fmt.Errorf("invalid tts backend: %s. Must be 'system' or 'openai'", config.TTS)
+	}
+	if _, err := llm.NewProvider(&definitions.ModelConfig{
+		Provider: config.Provider,
+		Image: definitions.ImageConfig{
+			Transport: getEnv("PHONE_AGENT_IMAGE_TRANSPORT", ""),
+			Detail:    getEnv("PHONE_AGENT_IMAGE_DETAIL", ""),
+		},
+	}); err != nil {
+		return err
+	}
+	// the default base URL is an OpenAI-compatible endpoint
+	if config.Provider != llm.ProviderOpenAI && !rootCmd.PersistentFlags().Changed("base-url") && os.Getenv("PHONE_AGENT_BASE_URL") == "" {
+		config.BaseURL = ""
+	}
+	if _, err := phoneagent.ObservationBuilderFor(config.Observation); err != nil {
+		return err
+	}
+	if _, err := llm.ResponseParserFor(config.ResponseParser); err != nil {
+		return err
+	}
+	if config.GroupsAddr != "" && config.GroupsFile == "" {
+		return fmt.Errorf("--groups-addr requires --groups-file")
+	}
+	if config.ShowSettings && config.DeviceID == "" {
+		return fmt.Errorf("--show-settings requires --device-id")
+	}
+	if _, err := definitions.ParsePacing(config.Pacing); err != nil {
+		return err
+	}
+	if config.ServeAddr != "" && (config.GroupsAddr != "" || config.Devices != "") {
+		return fmt.Errorf("--serve-addr cannot be combined with --groups-addr or --devices")
+	}
+	for _, event := range splitList(config.WebhookEvents) {
+		switch webhook.Event(event) {
+		case webhook.EventFinished, webhook.EventFailed, webhook.EventConfirmation, webhook.EventTakeover, webhook.EventWatch, webhook.EventProgress, webhook.EventAnomaly:
+		default:
+			return fmt.Errorf("invalid webhook event: %s. Must be finished, failed, confirmation, takeover, watch, progress or anomaly", event)
+		}
+	}
+	if (config.ListMemories || config.PruneMemories != "") && config.MemoryFile == "" {
+		return fmt.Errorf("--list-memories and --prune-memories require --memory-file")
+	}
+	if spec, ok := strings.CutPrefix(config.PruneMemories, "unused:"); ok {
+		if _, err := time.ParseDuration(spec); err != nil {
+			return fmt.Errorf("invalid --prune-memories duration %q, e.g. unused:720h", spec)
+		}
+	}
+	if config.GRPCAddr != "" && config.ServeAddr == "" {
+		return fmt.Errorf("--grpc-addr requires --serve-addr")
+	}
+	if config.TenantsFile != "" && config.ServeAddr == "" {
+		return fmt.Errorf("--tenants-file requires --serve-addr")
+	}
+	if config.SetupProfiles != "" && config.ServeAddr == "" {
+		return fmt.Errorf("--setup-profiles requires --serve-addr")
+	}
+	if config.OutputSchema != "" {
+		if _, err := phoneagent.ParseOutputSchema(config.OutputSchema); err != nil {
+			return err
+		}
+	}
+	if config.OutcomeTemplates != "" {
+		if _, err := phoneagent.LoadOutcomeTemplates(config.OutcomeTemplates); err != nil {
+			return err
+		}
+	}
+	if config.PolicyFile != "" {
+		if _, err := policy.Load(config.PolicyFile); err != nil {
+			return err
+		}
+	}
+	if _, err := loadRedact(); err != nil {
+		return err
+	}
+	if config.Demonstrate {
+		if config.Task == "" || config.RecordDir == "" {
+			return fmt.Errorf("--demonstrate requires --task and --record-dir")
+		}
+		if config.DeviceType != constants.ADB {
+			return fmt.Errorf("--demonstrate requires an adb device")
+		}
+	}
+	if config.AndroidUser != "" {
+		if config.DeviceType != constants.ADB {
+			return fmt.Errorf("--android-user requires an adb device")
+		}
+		if id, err := strconv.Atoi(config.AndroidUser); config.AndroidUser != phoneagent.AndroidUserWork && (err != nil || id < 0) {
+			return fmt.Errorf("invalid --android-user %s, want a user id or %s", config.AndroidUser, phoneagent.AndroidUserWork)
+		}
+	}
+	if config.Calibrate {
+		if config.CalibrationFile == "" {
+			return fmt.Errorf("--calibrate requires --calibration-file")
+		}
+		if config.DeviceType != constants.ADB {
+			return fmt.Errorf("--calibrate requires an adb device")
+		}
+	}
+	if config.CalibrationFile != "" {
+		if _, err := calibration.Load(config.CalibrationFile); err != nil {
+			return err
+		}
+	}
+	if config.Skill != "" {
+		if _, err := phoneagent.LoadSkill(config.Skill); err != nil {
+			return err
+		}
+	}
+	if config.Watch != "" {
+		if config.ServeAddr != "" || config.GroupsAddr != "" || config.Devices != "" {
+			return fmt.Errorf("--watch cannot be combined with --serve-addr, --groups-addr or --devices")
+		}
+		if config.WatchInterval <= 0 {
+			return fmt.Errorf("--watch-interval must be positive")
+		}
+	}
+	if config.MaxQPS < 0 || config.MaxTPM < 0 {
+		return fmt.Errorf("--max-qps and --max-tpm must not be negative")
+	}
+	if config.Suite != "" {
+		if config.ServeAddr != "" || config.GroupsAddr != "" || config.Devices != "" {
+			return fmt.Errorf("--suite cannot be combined with --serve-addr, --groups-addr or --devices")
+		}
+		if config.SuiteParallel <= 0 {
+			return fmt.Errorf("--suite-parallel must be positive")
+		}
+		if _, err := suite.Load(config.Suite); err != nil {
+			return err
+		}
+	}
+	if config.EvalProfiles != "" && config.Suite == "" {
+		return fmt.Errorf("--eval-profiles requires --suite")
+	}
+	if config.Emulators < 0 {
+		return fmt.Errorf("--emulators must not be negative")
+	}
+	if config.Emulators > 0 {
+		if config.DeviceType != constants.ADB {
+			return fmt.Errorf("--emulators requires --device-type adb")
+		}
+		if (config.EmulatorAVD == "") == (config.EmulatorImage == "") {
+			return fmt.Errorf("--emulators needs either --emulator-avd or --emulator-image")
+		}
+	}
+	if config.Macro != "" {
+		if config.Replay != "" || config.ServeAddr != "" || config.GroupsAddr != "" || config.Devices != "" {
+			return fmt.Errorf("--macro cannot be combined with --replay, --serve-addr, --groups-addr or --devices")
+		}
+		if _, err := phoneagent.LoadMacro(config.Macro); err != nil {
+			return err
+		}
+	}
+	if config.MaxPolicyBlocks < 0 {
+		return fmt.Errorf("--max-policy-blocks must not be negative")
+	}
+	if config.MaxRepeats < 0 {
+		return fmt.Errorf("--max-repeats must not be negative")
+	}
+	if config.MaxActionErrors < 0 {
+		return fmt.Errorf("--max-action-errors must not be negative")
+	}
+	switch config.UnchangedScreen {
+	case phoneagent.UnchangedOff, phoneagent.UnchangedAnnotate, phoneagent.UnchangedReuse:
+	default:
+		return fmt.Errorf("invalid --unchanged-screen: %s. Must be 'annotate' or 'reuse'", config.UnchangedScreen)
+	}
+	if config.MaxTaskTokens < 0 || config.MaxCost < 0 {
+		return fmt.Errorf("--max-task-tokens and --max-cost must not be negative")
+	}
+	if config.ResponseCacheTTL < 0 {
+		return fmt.Errorf("--response-cache-ttl must not be negative")
+	}
+	if config.SchedulesFile != "" && config.ServeAddr == "" {
+		return fmt.Errorf("--schedules-file requires --serve-addr")
+	}
+	if config.UILang != "" {
+		if _, err := uilang.Parse(config.UILang); err != nil {
+			return err
+		}
+	}
+	if config.ReplyLang != "" {
+		if _, err := uilang.Parse(config.ReplyLang); err != nil {
+			return err
+		}
+	}
+	if config.InputLocale != "" && config.InputLocale != definitions.InputLocaleAuto {
+		if _, err := uilang.Parse(config.InputLocale); err != nil {
+			return err
+		}
+	}
+	if config.I18nFiles != "" {
+		if err := helper.LoadBundles(strings.Split(config.I18nFiles, ",")...); err != nil {
+			return err
+		}
+	}
+	if config.OutputLang != "" && !helper.HasBundle(config.OutputLang) {
+		return fmt.Errorf("no message bundle for output language %s, add one with --i18n-files", config.OutputLang)
+	}
+	if config.VoiceSeconds <= 0 {
+		return fmt.Errorf("invalid voice recording length: %d", config.VoiceSeconds)
+	}
+	if config.AppiumCaps != "" && !json.Valid([]byte(config.AppiumCaps)) {
+		return fmt.Errorf("invalid appium capabilities: %s", config.AppiumCaps)
 	}
 
 	return nil
@@ -368,7 +3255,11 @@ func handleDeviceCommands(ctx context.Context, device phoneagent.Device) bool {
 
 	// 处理iOS特定命令
 	if deviceType == constants.IOS {
-		return handleIOSDeviceCommands(ctx)
+		return handleIOSDeviceCommands(ctx, device)
+	}
+
+	if adb, ok := device.(*android.ADBDevice); ok && handleWirelessCommands(ctx, adb) {
+		return true
 	}
 
 	// 处理 --list-devices
@@ -389,6 +3280,9 @@ func handleDeviceCommands(ctx context.Context, device phoneagent.Device) bool {
 				if d.Model != "" {
 					modelInfo = fmt.Sprintf(" (%s)", d.Model)
 				}
+				if d.OS != "" {
+					modelInfo += fmt.Sprintf(" %s", d.OS)
+				}
 				logs.Infof("  %s %-30s [%s]%s", statusIcon, d.DeviceID, connType, modelInfo)
 			}
 		}
@@ -461,14 +3355,234 @@ func handleDeviceCommands(ctx context.Context, device phoneagent.Device) bool {
 	return false
 }
 
-func handleIOSDeviceCommands(ctx context.Context) bool {
-	// todo
+// handleWirelessCommands handles the wireless debugging flags of adb
+// devices, and connects the devices of --paired-file otherwise.
+func handleWirelessCommands(ctx context.Context, device *android.ADBDevice) bool {
+	var paired *android.PairedDevices
+	if config.PairedFile != "" {
+		var err error
+		if paired, err = android.LoadPairedDevices(config.PairedFile); err != nil {
+			logs.Errorf("❌ %v", err)
+			return true
+		}
+	}
+
+	switch {
+	case config.Discover:
+		services, err := device.Discover(ctx)
+		if err != nil {
+			logs.Errorf("❌ %v", err)
+			return true
+		}
+		if len(services) == 0 {
+			logs.Info("No device found on the LAN.")
+			return true
+		}
+		logs.Info("Devices on the LAN:")
+		logs.Info(strings.Repeat("-", 60))
+		for _, s := range services {
+			logs.Infof("  %-36s %-24s %s", s.Name, s.Type, s.Address)
+		}
+		return true
+
+	case config.ADBPair != "" || config.PairCode != "":
+		if config.PairCode == "" {
+			logs.Error("❌ --pair-code is required to pair")
+			return true
+		}
+		logs.Infof("Pairing with %s...", lo.Ternary(config.ADBPair != "", config.ADBPair, "the device waiting on the LAN"))
+		name, err := device.Pair(ctx, config.ADBPair, config.PairCode)
+		if err != nil {
+			logs.Errorf("❌ %v", err)
+			return true
+		}
+		logs.Infof("✅ paired with %s", lo.Ternary(name != "", name, config.ADBPair))
+		if name == "" {
+			logs.Warn("adb did not name the device, connect it with --connect <ip>:<port of wireless debugging>")
+			return true
+		}
+		// the device advertises its connect service shortly after pairing
+		var address string
+		for attempt := 0; attempt < 5; attempt++ {
+			if address, err = device.ConnectWireless(ctx, name, ""); err == nil {
+				break
+			}
+			time.Sleep(2 * time.Second)
+		}
+		if err != nil {
+			logs.Errorf("❌ failed to connect %s, err: %v", name, err)
+		} else {
+			logs.Infof("✅ connected to %s", address)
+		}
+		if paired == nil {
+			logs.Info("set --paired-file to connect the device again in later runs")
+			return true
+		}
+		entry := android.PairedDevice{Name: name, Address: address, PairedAt: time.Now()}
+		if address != "" {
+			entry.LastConnected = entry.PairedAt
+		}
+		if err := paired.Remember(entry); err != nil {
+			logs.Errorf("❌ failed to save %s, err: %v", config.PairedFile, err)
+		}
+		return true
+
+	case config.Unpair != "":
+		if paired == nil {
+			logs.Error("❌ --unpair needs --paired-file")
+			return true
+		}
+		address := config.Unpair
+		removed, ok, err := paired.Forget(config.Unpair)
+		if err != nil {
+			logs.Errorf("❌ failed to save %s, err: %v", config.PairedFile, err)
+			return true
+		}
+		if ok {
+			address = removed.Address
+			logs.Infof("✅ %s removed from %s", removed.Name, config.PairedFile)
+		} else {
+			logs.Warnf("%s is not in %s", config.Unpair, config.PairedFile)
+		}
+		if strings.Contains(address, ":") {
+			_, _ = device.Disconnect(ctx, address)
+		}
+		return true
+	}
+
+	if paired != nil {
+		device.ConnectPaired(ctx, paired)
+	}
+	return false
+}
+
+func handleIOSDeviceCommands(ctx context.Context, device phoneagent.Device) bool {
+	iosDevice, ok := device.(*ios.IOSDevice)
+	if !ok {
+		return false
+	}
+
+	// 处理 --pair
+	if config.Pair {
+		logs.Info("🔗 Pairing with iOS device, tap 'Trust This Computer' on it if asked...")
+		message, err := iosDevice.Pair(ctx, config.DeviceID)
+		if err != nil {
+			logs.Errorf("❌ Pairing failed, err: %v", err)
+		} else {
+			logs.Infof("✅ %s", message)
+		}
+		return true
+	}
+
+	// 处理 --wda-status
+	if config.WdaStatus {
+		logs.Infof("Checking WebDriverAgent at %s...", iosDevice.WDAURL())
+		status, err := iosDevice.Status(ctx)
+		if err != nil {
+			logs.Errorf("❌ WebDriverAgent is not reachable, err: %v", err)
+			return true
+		}
+		if !status.Ready {
+			logs.Warnf("⚠️ WebDriverAgent is not ready: %s", status.Message)
+			return true
+		}
+		logs.Info("✅ WebDriverAgent is ready")
+		if status.Device != "" {
+			logs.Infof("  Device: %s", status.Device)
+		}
+		if status.OS.Version != "" {
+			logs.Infof("  iOS: %s", status.OS.Version)
+		}
+		if status.Build.Version != "" {
+			logs.Infof("  WDA build: %s", status.Build.Version)
+		}
+		if bundleID, err := iosDevice.ActiveApp(ctx); err == nil {
+			logs.Infof("  Current app: %s", bundleID)
+		}
+		return true
+	}
+
+	// 处理 --list-devices
+	if config.ListDevices {
+		devices, err := iosDevice.ListDevices(ctx)
+		if err != nil || len(devices) == 0 {
+			logs.Info("No iOS devices found.")
+			return true
+		}
+		logs.Info("Connected iOS devices:")
+		logging.Rule("-")
+		for _, d := range devices {
+			line := fmt.Sprintf("  ✓ %-40s %-8s %s", d.DeviceID, d.ConnectionType, d.Status)
+			if d.Model != "" {
+				line += " " + d.Model
+			}
+			if d.AndroidVersion != "" {
+				line += " iOS " + d.AndroidVersion
+			}
+			logs.Info(line)
+		}
+		return true
+	}
+
 	return false
 }
 
+func checkAppiumServer(ctx context.Context, device phoneagent.Device) bool {
+	logs.Info("🔍 Checking Appium server...")
+	logging.Rule("-")
+
+	if !device.IsConnected(ctx, config.DeviceID) {
+		logs.Errorf("❌ FAILED")
+		logs.Infof("   Error: Appium server at %s is not reachable or not ready.", config.AppiumURL)
+		logs.Infof("   Solution: Start it with: appium --address 127.0.0.1 --port 4723")
+		return false
+	}
+
+	logs.Infof("✅ OK (%s)", config.AppiumURL)
+	logging.Rule("-")
+	return true
+}
+
+func checkHDC(ctx context.Context, device phoneagent.Device) bool {
+	logs.Info("🔍 Checking system requirements...")
+	logging.Rule("-")
+
+	logs.Info("1. Checking hdc installation... ")
+	if _, err := exec.LookPath("hdc"); err != nil {
+		logs.Errorf("❌ FAILED")
+		logs.Infof("   Error: hdc is not installed or not in PATH.")
+		logs.Infof("   Solution: Install the toolchains of the HarmonyOS command line tools or DevEco Studio and add them to PATH")
+		return false
+	}
+	logs.Info("✅ OK")
+
+	logs.Info("2. Checking connected devices... ")
+	devices, err := device.ListDevices(ctx)
+	var deviceIDs []string
+	for _, d := range devices {
+		if d.Status == "device" {
+			deviceIDs = append(deviceIDs, d.DeviceID)
+		}
+	}
+	if err != nil || len(deviceIDs) == 0 {
+		logs.Errorf("❌ FAILED")
+		logs.Infof("   Error: No devices connected.")
+		logs.Infof("   Solution:")
+		logs.Infof("     1. Enable USB debugging in the developer options of your HarmonyOS device")
+		logs.Infof("     2. Connect via USB and authorize the connection")
+		logs.Infof("     3. Or connect remotely: go run main.go --device-type hdc --connect <ip>:<port>")
+		return false
+	}
+	logs.Infof("✅ OK (%d device(s): %s)", len(deviceIDs), strings.Join(deviceIDs, ", "))
+
+	logging.Rule("-")
+	logs.Info("✅ All system checks passed!")
+	return true
+}
+
 func checkSystemRequirements(ctx context.Context, deviceType string, wdaURL string) bool {
 	logs.Info("🔍 Checking system requirements...")
-	logs.Info(strings.Repeat("-", 50))
+	logging.Rule("-")
 
 	// Determine tool name and command
 	var toolName, toolCmd string
@@ -546,7 +3660,16 @@ func checkSystemRequirements(ctx context.Context, deviceType string, wdaURL stri
 			}
 		}
 	} else { // IOS
-		// todo
+		output, err := exec.Command("idevice_id", "-l").CombinedOutput()
+		if err != nil {
+			logs.Errorf("❌ FAILED")
+			logs.Infof("   Error: %s command failed: %v", toolName, err)
+			return false
+		}
+		for _, udid := range strings.Fields(string(output)) {
+			devices = append(devices, udid)
+			deviceIDs = append(deviceIDs, udid)
+		}
 	}
 
 	if len(devices) == 0 {
@@ -592,16 +3715,27 @@ func checkSystemRequirements(ctx context.Context, deviceType string, wdaURL stri
 			logs.Infof("   Solution:")
 			logs.Infof("     1. Download ADB Keyboard APK from:")
 			logs.Infof("        https://github.com/senzhk/ADBKeyBoard/blob/master/ADBKeyboard.apk")
-			logs.Infof("     2. Install it on your device: adb install ADBKeyboard.apk")
+			logs.Infof("     2. Install it on your device: adb install ADBKeyboard.apk, or pass it as --keyboard-apk")
 			logs.Infof("     3. Enable it in Settings > System > Languages & Input > Virtual Keyboard")
 			return false
 		}
 
 	} else { // IOS
-		// todo
+		logs.Infof("3. Checking WebDriverAgent (%s)... ", wdaURL)
+		status, err := ios.NewIOSDevice(wdaURL).Status(context.Background())
+		if err != nil || !status.Ready {
+			logs.Error("❌ FAILED")
+			logs.Infof("   Error: WebDriverAgent is not running or not reachable at %s.", wdaURL)
+			logs.Infof("   Solution:")
+			logs.Infof("     1. Build and run WebDriverAgentRunner on the device with Xcode")
+			logs.Infof("     2. Forward its port over USB: iproxy 8100 8100")
+			logs.Infof("     3. Or pass the address of the device: --wda-url http://<device-ip>:8100")
+			return false
+		}
+		logs.Info("✅ OK")
 	}
 
-	logs.Info(strings.Repeat("-", 50))
+	logging.Rule("-")
 	logs.Info("✅ All system checks passed!")
 
 	return true
@@ -614,14 +3748,14 @@ func printConfiguration(ctx context.Context, phoneAgent *phoneagent.PhoneAgent)
 	agentConfig := phoneAgent.AgentConfig
 	device := phoneAgent.Device
 
-	logs.Info(strings.Repeat("=", 50))
-	if config.DeviceType == constants.ADB {
-		logs.Info("Phone Agent - AI-powered phone automation")
-	} else {
+	logging.Rule("=")
+	if config.DeviceType == constants.IOS {
 		logs.Info("Phone Agent iOS - AI-powered iOS automation")
+	} else {
+		logs.Info("Phone Agent - AI-powered phone automation")
 	}
 
-	logs.Info(strings.Repeat("=", 50))
+	logging.Rule("=")
 	logs.Infof("Model: %s", modelConfig.ModelName)
 	logs.Infof("Base URL: %s", modelConfig.BaseURL)
 	logs.Infof("Max Steps: %d", agentConfig.MaxSteps)
@@ -630,6 +3764,12 @@ func printConfiguration(ctx context.Context, phoneAgent *phoneagent.PhoneAgent)
 	if agentConfig.DeviceID != "" {
 		logs.Infof("Device: %s", agentConfig.DeviceID)
 	}
+	if agentConfig.DryRun {
+		logs.Info("🧪 Dry run: actions are logged, not performed")
+	}
+	if agentConfig.ReadOnly {
+		logs.Info("👁️ Read-only: actions operating the device are refused")
+	}
 
 	devices, err := device.ListDevices(ctx)
 	if err != nil {
@@ -654,41 +3794,64 @@ func printConfiguration(ctx context.Context, phoneAgent *phoneagent.PhoneAgent)
 			logs.Infof("Device: %s (auto-detected)", d.DeviceID)
 		}
 	}
-	logs.Info(strings.Repeat("=", 50))
+	logging.Rule("=")
 }
 
-func checkModelAPI(ctx context.Context, baseURL, modelName, apiKey string) bool {
+func checkModelAPI(ctx context.Context, cfg *definitions.ModelConfig) bool {
 	logs.Info("🔍 Checking model API...")
-	logs.Info(strings.Repeat("-", 50))
+	logging.Rule("-")
+
+	baseURL := cfg.BaseURL
+	if baseURL == "" {
+		baseURL = cfg.Provider + " default endpoint"
+	}
 
 	// Check 1: Network connectivity using chat API
 	logs.Infof("1. Checking API connectivity (%s)... ", baseURL)
 
-	// Create OpenAI client
-	config := openai.DefaultConfig(apiKey)
-	config.BaseURL = baseURL
-	client := openai.NewClientWithConfig(config)
+	provider, err := llm.NewProvider(cfg)
+	if err != nil {
+		logs.Errorf("❌ FAILED, err: %v", err)
+		return false
+	}
 
 	// Set timeout context
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	// Use chat completion to test connectivity
-	resp, err := client.CreateChatCompletion(
-		ctx,
-		openai.ChatCompletionRequest{
-			Model: modelName,
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role:    openai.ChatMessageRoleUser,
-					Content: "please return hello world",
-				},
+	var (
+		reply  strings.Builder
+		chunks int
+	)
+	stream, err := provider.Stream(ctx, openai.ChatCompletionRequest{
+		Model: cfg.ModelName,
+		Messages: []openai.ChatCompletionMessage{
+			{
+				Role:    openai.ChatMessageRoleUser,
+				Content: "please return hello world",
 			},
-			MaxCompletionTokens: 5,
-			Temperature:         0,
-			Stream:              false,
 		},
-	)
+		MaxCompletionTokens: 5,
+		Temperature:         0,
+		Stream:              true,
+	})
+	if err == nil {
+		for {
+			chunk, recvErr := stream.Recv()
+			if recvErr != nil {
+				if !errors.Is(recvErr, io.EOF) {
+					err = recvErr
+				}
+				break
+			}
+			for _, choice := range chunk.Choices {
+				chunks++
+				reply.WriteString(choice.Delta.Content)
+			}
+		}
+		_ = stream.Close()
+	}
 	// Check response
 	if err != nil {
 		logs.Error("❌ FAILED")
@@ -717,15 +3880,15 @@ func checkModelAPI(ctx context.Context, baseURL, modelName, apiKey string) bool
 		return false
 	}
 
-	if len(resp.Choices) == 0 {
+	if chunks == 0 {
 		logs.Error("❌ FAILED")
 		logs.Error("   Error: Received empty response from API")
 		return false
 	}
 
-	logs.Infof("✅ OK, Response: %s", utils.JsonString(resp))
+	logs.Infof("✅ OK, Response: %s", reply.String())
 
-	logs.Info(strings.Repeat("-", 50))
+	logging.Rule("-")
 	logs.Info("✅ Model API checks passed!")
 
 	return true
@@ -3,15 +3,39 @@ package phoneagent
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"hash/maphash"
+	"maps"
 	"os"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"autoglm-go/phoneagent/anomaly"
+	"autoglm-go/phoneagent/calibration"
+	"autoglm-go/phoneagent/captcha"
 	"autoglm-go/phoneagent/definitions"
+	"autoglm-go/phoneagent/dialog"
+	"autoglm-go/phoneagent/executor"
 	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/history"
+	"autoglm-go/phoneagent/imaging"
+	"autoglm-go/phoneagent/labels"
 	"autoglm-go/phoneagent/llm"
+	"autoglm-go/phoneagent/logging"
+	"autoglm-go/phoneagent/memory"
+	"autoglm-go/phoneagent/metrics"
+	"autoglm-go/phoneagent/ocr"
+	"autoglm-go/phoneagent/policy"
+	"autoglm-go/phoneagent/recorder"
+	"autoglm-go/phoneagent/redact"
+	"autoglm-go/phoneagent/store"
+	"autoglm-go/phoneagent/tracing"
+	"autoglm-go/phoneagent/trajectory"
+	"autoglm-go/phoneagent/uilang"
+	"autoglm-go/phoneagent/voice"
+	"autoglm-go/phoneagent/webhook"
 	"autoglm-go/utils"
 	"github.com/sashabaranov/go-openai"
 	logs "github.com/sirupsen/logrus"
@@ -24,16 +48,134 @@ type PhoneAgent struct {
 	State       []openai.ChatCompletionMessage
 	StepCount   int
 	ModelClient *llm.ModelClient
+	Navigation  *NavigationMap // may be shared between agents
+	StepHooks   []StepHook
+	Middleware  []Middleware           // hooks into the model calls and actions of the steps, see Middleware
+	Trajectory  *trajectory.Trajectory // actions of the current task
+	Speaker     voice.Speaker          // reads finish messages and prompts aloud, optional
+	Captcha     captcha.Chain          // handlers for captcha screens, none disables detection
+	Dialogs     *dialog.Handler        // closes update, rating and similar dialogs, optional
+	Planner     *llm.ModelClient       // strong model for planning and escalation, optional, may be the ModelClient
+	Router      *Router                // sends easy steps to cheaper models, optional
+	Judge       *llm.ModelClient       // reviews finished tasks independently, optional
+	Replay      *Replay                // takes the actions from a recording instead of the model, optional
+	OCR         ocr.Engine             // finds the labels the model taps by text, of AgentConfig.OCR, optional
+	Usage       *llm.UsageMeter        // tokens and cost of the current task
+	SessionID   string                 // id of the current task in AgentConfig.SessionDir
+	Output      map[string]any         // fields of the finished task, see WithOutputSchema
+	Outcome     *Outcome               // how the last task ended, see Summary
+	// OnEvent is told of the progress of the running task as it happens,
+	// optional. It is called from the agent and streaming goroutines and
+	// must not block.
+	OnEvent func(Event)
+	// Confirmer answers sensitive actions and takeovers, the terminal when
+	// nil.
+	Confirmer Confirmer
+
+	imageEncoder     *imaging.AdaptiveEncoder
+	nextObservation  *prefetch // captured right after the previous action
+	lastUIElements   []definitions.UIElement
+	imageSeed        maphash.Seed
+	imageCache       *cachedImage
+	speculation      *speculation // of the previous step, see speculate
+	history          *history.Spill
+	droppedMessages  int      // messages removed from State by the history caps
+	droppedSteps     int      // user messages among them, see dropSteps
+	recap            []string // a line per step removed from State, see dropSteps
+	task             string
+	hookObservations []string
+	web              *webPage // attached devtools page, see AgentConfig.WebCDP
+	stepObservation  *observation
+	stepThinking     string // reasoning behind the current action, empty for early actions
+	plan             *plan
+	stepRoute        *Route // nil for the main model
+	lastStepOK       bool
+	judgeFrames      []string // data URLs of the last screenshots
+	finalFrame       string   // data URL of the last screenshot, for extractOutput
+	uiLanguage       *uilang.Language
+	inputLocale      *inputLocale
+	deviceNote       string      // told to the model in the next step after a reconnect
+	keptImages       []keptImage // screenshots still in State, oldest first
+	sessions         *store.Store
+	sessionCreatedAt time.Time
+	storedSteps      int // trajectory steps already in the step log of the session
+	recorder         *recorder.Recorder
+	record           *pendingRecord // of the running step
+	video            *sessionVideo  // of AgentConfig.RecordVideo, nil when off
+	chaos            *chaos         // faults of AgentConfig.Chaos, nil when off
+	webhooks         *webhook.Notifier
+	policy           *policy.Engine            // of AgentConfig.Policy, nil without rules
+	redactor         *redact.Redactor          // of AgentConfig.Redact, nil when nothing is masked
+	calibration      *calibration.Matrix       // of the device in AgentConfig.CalibrationFile, nil without one
+	profiles         []definitions.UserProfile // users of the device, listed at the first task
+	anomalies        *anomaly.Detector         // baselines of the steps, nil unless AgentConfig.AnomalyFactor is set
+	taskID           string                    // of the running task, for the webhooks
+	taskCtx          context.Context           // of the running task, bounds the waits for the user
+	humanWaited      bool                      // the current step waited for the user
+	outcomeMessage   string                    // finish message or error of the last task
+	policyBlocks     []string                  // denials of the policy in a row, see AgentConfig.MaxPolicyBlocks
+	control          taskControl               // pauses and cancellations from other goroutines
+	repeated         repeatedStep              // see AgentConfig.MaxRepeats
+	lastScreen       *lastScreen               // see AgentConfig.UnchangedScreen
+	actionErrors     []string                  // failed actions in a row, see AgentConfig.MaxActionErrors
+	memory           *memory.Store             // of AgentConfig.MemoryFile, see appMemory
+	memoryApps       map[string]bool           // whose facts the running task was given
+	usageBase        llm.ModelUsage            // Usage when the running task started, see Budget
+	stepBase         int                       // StepCount when the running task started, see Continue
+}
+
+// cachedImage keeps the last encoded screenshot so an unchanged screen is not
+// re-encoded (and its data URL not rebuilt) on the next step.
+type cachedImage struct {
+	hash    uint64
+	level   imaging.Level
+	encoded *imaging.Encoded
+}
+
+// observation is what the agent sees of the device before a step.
+type observation struct {
+	screenshot *definitions.Screenshot
+	currentApp string
+	uiElements []definitions.UIElement
+	overlays   []definitions.Overlay    // windows of other apps above the foreground one
+	profile    *definitions.UserProfile // user of the foreground app, nil with a single user
+
+	notifications []definitions.Notification // with AgentConfig.Notifications
+}
+
+// earlyAction is an action that started executing while the rest of the
+// model response was still streaming. It runs on the agent, so the step
+// touches no state of the agent until done is closed.
+type earlyAction struct {
+	raw     string
+	action  helper.Action
+	done    chan struct{}
+	result  helper.ActionResult
+	err     error
+	elapsed time.Duration // how long it ran
 }
 
 func NewPhoneAgent(device Device, modelConfig *definitions.ModelConfig, agentConfig *definitions.AgentConfig) *PhoneAgent {
 	result := &PhoneAgent{
 		ModelConfig: modelConfig,
 		AgentConfig: agentConfig,
-		State:       []openai.ChatCompletionMessage{},
+		State:       helper.GetMessageSlice(),
 		StepCount:   0,
 		Device:      device,
 		ModelClient: llm.NewModelClient(modelConfig),
+		Navigation:  NewNavigationMap(),
+		Usage:       llm.NewUsageMeter(),
+
+		imageEncoder: imaging.NewAdaptiveEncoder(imageLevels(modelConfig.Image), modelConfig.MaxImageBytes, 0),
+		imageSeed:    maphash.MakeSeed(),
+		uiLanguage:   uilang.New(agentConfig.GetUILanguage()),
+		chaos:        newChaos(agentConfig.Chaos),
+		webhooks:     webhook.New(agentConfig.Webhooks),
+		policy:       newPolicy(agentConfig.Policy),
+		redactor:     newRedactor(agentConfig.Redact),
+		OCR:          newOCR(agentConfig),
+		calibration:  newCalibration(agentConfig),
+		anomalies:    newAnomalies(agentConfig),
 	}
 	return result
 }
@@ -47,114 +189,481 @@ type StepResult struct {
 }
 
 func (r *PhoneAgent) Run(ctx context.Context, task string) (string, error) {
-	result, err := r.ExecuteStep(ctx, task, true)
+	return r.run(ctx, task, false)
+}
+
+// run executes steps until the task finishes, starting with the first step of
+// task unless the agent was restored by Resume.
+func (r *PhoneAgent) run(ctx context.Context, task string, resumed bool) (message string, err error) {
+	r.taskID = taskIDOf(ctx)
+	ctx = llm.WithLimiterKey(ctx, r.AgentConfig.DeviceID)
+	r.Output = nil
+	r.Outcome = nil
+	r.policyBlocks = nil
+	r.repeated = repeatedStep{}
+	r.actionErrors = nil
+	r.memoryApps = nil
+	r.usageBase = r.Usage.Total()
+	// the defaults of a device group win over the reloaded settings
+	r.applySettings(ctx)
+	restoreDefaults, err := r.useGroupDefaults(ctx)
 	if err != nil {
-		logs.Errorf("Failed to execute step: %v", err)
+		r.logFor(ctx).Errorf("Failed to start task: %v", err)
 		return "", err
 	}
-	if result.Finished {
-		return result.Message, nil
+	defer restoreDefaults()
+	restoreLimits, err := r.useStepLimits(ctx)
+	if err != nil {
+		r.logFor(ctx).Errorf("Failed to start task: %v", err)
+		return "", err
+	}
+	defer restoreLimits()
+	defer r.useReadOnly(ctx)()
+	r.startSession()
+	ctx, span := r.startTask(ctx, task)
+	defer func() { r.endTask(span, err) }()
+	if traceID := span.TraceID(); traceID != "" {
+		r.logFor(ctx).Infof("🔭 trace %s", traceID)
 	}
+	var last *StepResult
+	defer func() {
+		outcome := r.classifyOutcome(last, err)
+		r.Outcome = &outcome
+		metrics.Tasks.Inc(string(outcome.Level), outcome.Reason)
+		r.outcomeMessage = message
+		if err != nil {
+			r.outcomeMessage = err.Error()
+		}
+		r.notifyEnd(ctx, task, last, message, err)
+		r.learnFacts(ctx, task, message, err)
+	}()
+	if r.Router != nil {
+		defer func() {
+			r.logFor(ctx).Infof("🧮 model routing: %s", r.Router.Summary())
+		}()
+	}
+	defer func() {
+		r.Usage.Wait()
+		if r.Usage.Total().Requests > 0 {
+			r.logFor(ctx).Infof("🪙 model usage: %s", r.Usage.Summary())
+		}
+	}()
+	ctx, cancel := withTimeout(ctx, r.AgentConfig.TaskTimeout, ErrTaskTimeout)
+	defer cancel()
+	ctx, cancelTask := context.WithCancelCause(ctx)
+	defer cancelTask(nil)
+	r.control.start(cancelTask)
+	defer r.control.stop()
+	r.taskCtx = ctx
+	defer func() { r.taskCtx = nil }()
+
+	if err := r.ensureUnlocked(ctx); err != nil {
+		r.logFor(ctx).Errorf("Failed to start task: %v", err)
+		return "", err
+	}
+	if err := r.selectProfile(ctx); err != nil {
+		r.logFor(ctx).Errorf("Failed to start task: %v", err)
+		return "", err
+	}
+	restoreModel, err := r.useModelProfile(ctx)
+	if err != nil {
+		r.logFor(ctx).Errorf("Failed to start task: %v", err)
+		return "", err
+	}
+	defer restoreModel()
+	defer r.startVideo(ctx)()
+	started, reported := time.Now(), false
 	// Continue until finished or max steps reached
-	for r.StepCount < r.AgentConfig.MaxSteps {
-		result, err = r.ExecuteStep(ctx, "", false)
+	for first := !resumed; first || r.StepCount-r.stepBase < r.AgentConfig.MaxSteps; first = false {
+		prompt := ""
+		if first {
+			prompt = task
+		}
+		result, err := r.ExecuteStep(ctx, prompt, first)
+		last = result
+		if timeoutErr := r.taskTimeoutError(ctx); timeoutErr != nil {
+			r.saveSession(ctx, result, timeoutErr)
+			return "", timeoutErr
+		}
+		if cancelErr := r.cancelled(ctx, result); cancelErr != nil {
+			return "", cancelErr
+		}
 		if err != nil {
-			logs.Errorf("Failed to execute step: %v", err)
+			r.logFor(ctx).Errorf("Failed to execute step: %v", err)
+			r.saveSession(ctx, result, err)
 			return "", err
 		}
+		if deadlock := r.policyDeadlock(); deadlock != nil {
+			r.logFor(ctx).Errorf("🛡️ %v", deadlock)
+			r.saveSession(ctx, result, deadlock)
+			return "", deadlock
+		}
+		if loop := r.stuckLoop(); loop != nil {
+			r.logFor(ctx).Errorf("🔁 %v", loop)
+			r.saveSession(ctx, result, loop)
+			return "", loop
+		}
+		if exhausted := r.actionErrorsExhausted(); exhausted != nil {
+			r.logFor(ctx).Errorf("🩹 %v", exhausted)
+			r.saveSession(ctx, result, exhausted)
+			return "", exhausted
+		}
+		r.saveSession(ctx, result, nil)
 		if result.Finished {
+			if result.Success && utils.AnyToString(result.Action["_metadata"]) == "finish" {
+				r.extractOutput(ctx, result.Message)
+			}
 			return result.Message, nil
 		}
+		if over := r.overBudget(ctx); over != nil {
+			r.logFor(ctx).Errorf("💸 %v", over)
+			r.saveSession(ctx, result, over)
+			return "", over
+		}
+		if !reported {
+			reported = r.checkSoftDeadline(ctx, started, result)
+		}
+		if pause := r.AgentConfig.StepPause; pause > 0 {
+			// an end of the task is noticed by the next step
+			select {
+			case <-ctx.Done():
+			case <-time.After(pause):
+			}
+		}
+		r.waitWhilePaused(ctx, result)
+		if cancelErr := r.cancelled(ctx, result); cancelErr != nil {
+			return "", cancelErr
+		}
 	}
+	r.saveSession(ctx, nil, fmt.Errorf("max steps reached"))
 	return "Max steps reached", nil
 }
 
 func (r *PhoneAgent) Step(ctx context.Context, task string) (*StepResult, error) {
 	isFirst := len(r.State) == 0
 	if isFirst && len(task) == 0 {
-		logs.Errorf("task is required for the first step")
+		r.log().Errorf("task is required for the first step")
 		return nil, fmt.Errorf("task is required for the first step")
 	}
 	return r.ExecuteStep(ctx, task, isFirst)
 }
 
-func (r *PhoneAgent) ExecuteStep(ctx context.Context, userPrompt string, isFirstStep bool) (*StepResult, error) {
+func (r *PhoneAgent) executeStep(ctx context.Context, userPrompt string, isFirstStep bool) (*StepResult, error) {
 	r.StepCount += 1
+	ctx = logging.With(ctx, r.logFields(ctx))
+	started := time.Now()
 
-	device := r.Device
-	screenshot, _ := device.GetScreenshot(ctx, r.AgentConfig.DeviceID)
-	currentApp, _ := device.GetCurrentApp(ctx, r.AgentConfig.DeviceID)
+	obs := r.takeObservation(ctx)
+	if (obs.screenshot == nil || len(obs.screenshot.Data) == 0) && r.deviceLost(ctx) {
+		if err := r.reconnect(ctx); err != nil {
+			r.log().Errorf("device lost, err: %v", err)
+			return &StepResult{
+				Success:  false,
+				Finished: true,
+				Message:  fmt.Sprintf("device lost, err: %v", err),
+			}, nil
+		}
+		obs = r.captureObservation(ctx)
+	}
+	obs = r.checkDialogs(ctx, obs)
+	obs, err := r.checkCaptcha(ctx, obs)
+	if err != nil {
+		r.log().Errorf("captcha not solved, err: %v", err)
+		return &StepResult{
+			Success:  false,
+			Finished: true,
+			Message:  fmt.Sprintf("captcha not solved, err: %v", err),
+		}, nil
+	}
+	obs = r.redactObservation(obs)
+	screenshot, currentApp := obs.screenshot, obs.currentApp
+	r.stepObservation, r.stepThinking = obs, ""
+	r.startRecord(ctx, obs, started)
 
 	if isFirstStep {
+		r.task = userPrompt
+		r.Trajectory = trajectory.New(userPrompt, r.AgentConfig.DeviceID)
+		r.Trajectory.Labels = labels.From(ctx)
 		// system prompt
 		r.State = append(r.State,
-			helper.CreateSystemMessage(r.AgentConfig.GetSystemPrompt()),
+			helper.CreateSystemMessage(r.AgentConfig.GetSystemPrompt()+pluginPromptDocs(r.AgentConfig.Lang, r.AgentConfig.DeviceID)+r.readOnlyPrompt()),
 		)
+	}
 
-		screenInfo := helper.BuildScreenInfo(currentApp)
-		textContent := fmt.Sprintf("%s\n\n%s", userPrompt, screenInfo)
+	encoded := r.encodeScreenshot(screenshot)
+	r.keepJudgeFrame(encoded.DataURL())
+	r.finalFrame = encoded.DataURL()
+	r.recordImage(encoded)
+	r.emit(Event{Type: EventScreenshot, App: currentApp, Width: screenshot.Width, Height: screenshot.Height, Image: encoded.DataURL(),
+		Bytes: len(screenshot.Data), SentBytes: encoded.Size})
+	if r.Planner != nil && r.Replay == nil {
+		if isFirstStep {
+			r.makePlan(ctx, userPrompt, encoded.DataURL())
+		}
+		r.judgeLastStep(screenshot.Data)
+		if !isFirstStep {
+			r.reviewPlan(ctx, encoded.DataURL())
+		}
+	}
 
-		// user prompt
-		r.State = append(r.State,
-			helper.CreateUserMessage(textContent, &screenshot.Base64Data),
-		)
-	} else {
-		screenInfo := helper.BuildScreenInfo(currentApp)
-		textContent := fmt.Sprintf("** Screen Info **\n\n%s", screenInfo)
+	screen := r.compareScreen(screenshot)
 
-		// user prompt
-		r.State = append(r.State,
-			helper.CreateUserMessage(textContent, &screenshot.Base64Data),
-		)
+	// the route decides which model, and so which observation builder
+	r.routeStep(obs, isFirstStep)
+	builder := observationBuilder(r.stepClient())
+
+	r.resolveSpeculation(currentApp)
+	sections := &ObservationSections{
+		FirstStep:  isFirstStep,
+		ScreenInfo: helper.BuildScreenInfo(currentApp),
+		Profile:    r.profileContext(obs),
+		Web:        r.refreshWeb(ctx, obs),
+		Overlays:   r.overlayContext(obs),
+		Script:     r.takeHookObservations(),
+		Plan:       r.planContext(),
+		Memory:     r.memoryContext(currentApp),
+		Device:     r.deviceNote,
+		Unchanged:  r.unchangedContext(screen),
+		ImageURL:   encoded.DataURL(),
+
+		Notifications: r.notificationContext(obs),
+	}
+	if isFirstStep {
+		sections.Task = r.skillPrompt(ctx, userPrompt)
+	}
+	if uiContext := r.buildUIContext(obs); r.AgentConfig.UIDump || builder.WantsTree() {
+		sections.UIElements = uiContext
 	}
+	r.deviceNote = ""
+
+	// user prompt
+	r.State = append(r.State, builder.Build(sections))
+	r.fitTokenBudget()
+
+	r.recordPrompt(isFirstStep)
 
 	// print user message
 	helper.PrintChatMessage(&r.State[len(r.State)-1])
 
-	logs.Info(strings.Repeat("=", 50))
-	logs.Infof("💭 %s:", helper.GetMessage("thinking", r.AgentConfig.Lang))
-	logs.Info(strings.Repeat("-", 50))
+	logging.Rule("=")
+	r.log().Infof("💭 %s:", helper.GetOutputMessage("thinking", r.AgentConfig.Lang))
+	logging.Rule("-")
+
+	var (
+		early         *earlyAction
+		earlyRejected error // by the middleware, which saw the action once
+		opts          llm.RequestOptions
+		policyBlocks  = len(r.policyBlocks) // before an early action may add to them
+	)
+	if r.ModelConfig.EarlyAction && r.Replay == nil {
+		opts.OnAction = func(raw string) {
+			early, earlyRejected = r.startEarlyAction(ctx, raw, screenshot)
+		}
+		// the step goes on without waiting for the end of the response, its
+		// usage is recorded once the rest has streamed
+		opts.ReturnOnAction = true
+		opts.Drained = r.Usage
+	}
+	if r.stepClient().Config().ToolCalls {
+		opts.Tools = actionTools(r.AgentConfig.DeviceID)
+	}
 
-	response, err := r.ModelClient.Request(ctx, r.State)
+	cached, response := r.lookupResponse(currentApp, screenshot)
+	reused := false
+	if response == nil && r.Replay == nil {
+		response = r.reuseWait(screen)
+		reused = response != nil
+	}
+	if r.Replay != nil {
+		if response, err = r.Replay.response(currentApp); err != nil {
+			return nil, err
+		}
+	} else if response == nil {
+		response, err = r.requestModel(ctx, screenshot, builder, sections, r.streamThinking(ctx, opts))
+	}
+	if early != nil {
+		// nothing of the agent is touched while it runs, see earlyAction
+		<-early.done
+	}
 	if err != nil {
-		logs.Errorf("failed to get model response, err: %v", err)
-		return &StepResult{
+		r.log().Errorf("failed to get model response, err: %v", err)
+		r.lastStepOK = false
+		if errors.Is(err, llm.ErrContentFiltered) {
+			// the same answer would be blocked again
+			r.hookObservations = append(r.hookObservations, "previous answer was blocked by the content filter of the model provider, answer differently")
+		}
+		result := &StepResult{
 			Success:  false,
 			Finished: false,
 			Message:  fmt.Sprintf("failed to get model response, err: %v", err),
-		}, nil
+		}
+		if early != nil {
+			result.Action = early.action
+			r.keepEarlyAction(obs, screenshot, early)
+		}
+		return result, nil
 	}
 
-	logs.Debugf("💭 model response: %s", utils.JsonString(response))
+	r.log().Debugf("💭 model response: %s", utils.JsonString(response))
+	if r.Replay == nil && !reused && (cached == nil || !cached.served) {
+		r.recordRoute(response)
+	}
+	r.recordResponse(response)
+	if early == nil {
+		r.chaos.malformAction(response)
+	}
 
-	action, err := helper.ParseAction(response.Action)
-	if err != nil {
-		logs.Errorf("failed to parse action, err: %v", err)
-		return &StepResult{
-			Success:  false,
-			Finished: false,
-			Message:  fmt.Sprintf("failed to parse action, err: %v", err),
-		}, nil
+	var action helper.Action
+	if early != nil {
+		// the action already runs, keep the history consistent with it
+		response.Action = early.raw
+		action = early.action
+	} else {
+		if response.ToolAction != nil {
+			action = response.ToolAction
+		} else {
+			_, span := tracing.Start(ctx, "agent.parse_action")
+			action, err = parseAction(response.Action)
+			span.RecordError(err)
+			span.End()
+			if err != nil {
+				metrics.ParseFailures.Inc()
+			}
+		}
+		switch {
+		case err == nil && earlyRejected != nil:
+			err = earlyRejected
+		case err == nil:
+			action, err = r.afterParse(ctx, response.Thinking, response.Action, action)
+		}
+		if err != nil {
+			r.log().WithField(logging.FieldEvent, EventActionResult).Errorf("failed to parse action, err: %v", err)
+			r.lastStepOK = false
+			// keep the answer and tell the model what was wrong with it
+			r.keepScreenshot(obs, response.Action)
+			thinkingContent := fmt.Sprintf("<think>%s</think><answer>%s</answer>", response.Thinking, response.Action)
+			r.State = append(r.State, helper.CreateAssistantMessage(thinkingContent))
+			if r.AgentConfig.MaxActionErrors > 0 {
+				r.reportActionError(ActionErrorParse, response.Action, err.Error())
+			} else {
+				r.hookObservations = append(r.hookObservations, "previous action is invalid: "+err.Error())
+			}
+			r.emit(Event{Type: EventActionResult, Message: fmt.Sprintf("failed to parse action, err: %v", err)})
+			return &StepResult{
+				Success:  false,
+				Finished: false,
+				Message:  fmt.Sprintf("failed to parse action, err: %v", err),
+			}, nil
+		}
 	}
 
 	// Print thinking process
-	logs.Info(strings.Repeat("-", 50))
-	logs.Infof("🎯 %s", response.Action)
-	logs.Debugf("resp action: %s \nparsed action:%s", utils.JsonString(response.Action), utils.JsonString(action))
-	logs.Info(strings.Repeat("=", 50))
+	logging.Rule("-")
+	r.log().WithField(logging.FieldEvent, EventAction).Infof("🎯 %s", response.Action)
+	r.emit(Event{Type: EventAction, Action: maps.Clone(action)})
+	r.log().Debugf("resp action: %s \nparsed action:%s", utils.JsonString(response.Action), utils.JsonString(action))
+	logging.Rule("=")
 
-	// Remove image from context to save space
-	r.State[len(r.State)-1] = helper.RemoveImagesFromMessage(r.State[len(r.State)-1])
+	// Remove old images from context to save space
+	r.keepScreenshot(obs, response.Action)
 
 	// Execute action
-	actionResult, err := r.ExecuteAction(ctx, action, screenshot.Width, screenshot.Height)
-	if err != nil {
-		logs.Errorf("failed to execute action, err: %v", err)
+	var actionResult helper.ActionResult
+	actionStarted := time.Now()
+	if early != nil {
+		actionResult, err = early.result, early.err
+		actionStarted = actionStarted.Add(-early.elapsed)
+	} else {
+		r.stepThinking = response.Thinking
+		r.enforceReplyLanguage(ctx, action)
+		if err = r.chaos.disconnect(); err == nil {
+			actionResult, err = r.ExecuteAction(ctx, action, screenshot.Width, screenshot.Height)
+		}
+	}
+	if r.record != nil {
+		r.record.Timings.Action = time.Since(actionStarted).Seconds()
+	}
+	if err != nil && r.deviceLost(ctx) {
+		if reconnectErr := r.reconnect(ctx); reconnectErr == nil {
+			// resume from whatever the screen shows now
+			actionResult = helper.ActionResult{
+				Success: false,
+				Message: fmt.Sprintf("device disconnected during the action: %v", err),
+			}
+			err = nil
+		} else {
+			err = fmt.Errorf("%w, %w", err, reconnectErr)
+		}
+	}
+	switch {
+	case err != nil && r.AgentConfig.MaxActionErrors > 0 && ctx.Err() == nil:
+		// the model may get around it, see AgentConfig.MaxActionErrors
+		r.log().WithField(logging.FieldEvent, EventActionResult).Errorf("failed to execute action, err: %v", err)
+		r.reportActionError(ActionErrorExecution, helper.FormatAction(action), err.Error())
+		actionResult = helper.ActionResult{Success: false, Message: fmt.Sprintf("Failed to execute action: %v", err)}
+		err = nil
+	case err != nil:
+		r.log().WithField(logging.FieldEvent, EventActionResult).Errorf("failed to execute action, err: %v", err)
 		actionResult = helper.ActionResult{
 			Success:      true,
 			ShouldFinish: true,
 			Message:      fmt.Sprintf("Failed to execute action: %v", err),
 		}
+	case actionResult.Success:
+		r.actionErrors = nil
+	// denials count towards AgentConfig.MaxPolicyBlocks
+	case !actionResult.ShouldFinish && r.AgentConfig.MaxActionErrors > 0 && len(r.policyBlocks) == policyBlocks:
+		r.reportActionError(ActionErrorFailed, helper.FormatAction(action), actionResult.Message)
+	}
+
+	r.emit(Event{Type: EventActionResult, Success: actionResult.Success && err == nil, Message: actionResult.Message})
+	r.recordStep(action, actionResult.Success && err == nil)
+	r.trackRepeat(screenshot.Data, action)
+	r.rememberScreen(screen, action, reused)
+	r.storeResponse(cached, response, action, actionResult.Success && err == nil)
+	metrics.Actions.Inc(actionType(action), metrics.Result(actionResult.Success && err == nil))
+	r.lastStepOK = actionResult.Success && err == nil
+	if r.Trajectory != nil {
+		r.Trajectory.Add(trajectory.Step{
+			App:          currentApp,
+			Action:       action,
+			ScreenWidth:  screenshot.Width,
+			ScreenHeight: screenshot.Height,
+			Success:      actionResult.Success && err == nil,
+			Message:      actionResult.Message,
+			Thinking:     response.Thinking,
+		})
+		if utils.AnyToString(action["_metadata"]) == "finish" {
+			r.Trajectory.Finished = true
+			r.Trajectory.Message = actionResult.Message
+			if r.Judge != nil && r.Replay == nil {
+				r.Trajectory.Verdict = r.judgeResult(ctx, actionResult.Message)
+			}
+		}
+	}
+
+	// capture the next observation while the rest of this step is processed,
+	// the capture outlives the step and its timeout
+	if !actionResult.ShouldFinish {
+		r.startObservation(context.WithoutCancel(ctx))
+		r.speculate(context.WithoutCancel(ctx), currentApp, action)
+	}
+
+	if !actionResult.ShouldFinish && len(r.StepHooks) > 0 {
+		info := &StepInfo{
+			Task:       r.task,
+			Step:       r.StepCount,
+			CurrentApp: currentApp,
+			Action:     action,
+			Success:    actionResult.Success,
+			Message:    actionResult.Message,
+		}
+		info.Subgoal, info.Subgoals = r.planProgress()
+		stop := r.runStepHooks(ctx, info)
+		if stop != nil {
+			actionResult.ShouldFinish = true
+			actionResult.Message = stop.Message
+		}
 	}
 
 	thinkingContent := fmt.Sprintf("<think>%s</think><answer>%s</answer>", response.Thinking, response.Action)
@@ -163,21 +672,24 @@ func (r *PhoneAgent) ExecuteStep(ctx context.Context, userPrompt string, isFirst
 	// print assistant message
 	helper.PrintChatMessage(&r.State[len(r.State)-1])
 
+	r.compactHistory()
+
 	if actionResult.ShouldFinish {
 		var displayMsg string
 		if actionResult.Message != "" {
 			displayMsg = actionResult.Message
 		} else {
-			displayMsg = helper.GetMessage("done", r.AgentConfig.Lang)
+			displayMsg = helper.GetOutputMessage("done", r.AgentConfig.Lang)
 		}
 
-		logs.Info(strings.Repeat("=", 50))
-		logs.Infof(
+		logging.Rule("=")
+		r.log().WithField(logging.FieldEvent, "done").Infof(
 			"✅ %s: %s\n",
-			helper.GetMessage("task_completed", r.AgentConfig.Lang),
+			helper.GetOutputMessage("task_completed", r.AgentConfig.Lang),
 			displayMsg,
 		)
-		logs.Info(strings.Repeat("=", 50))
+		logging.Rule("=")
+		r.speak(ctx, displayMsg)
 	}
 
 	stepResult := &StepResult{
@@ -195,7 +707,149 @@ func (r *PhoneAgent) ExecuteStep(ctx context.Context, userPrompt string, isFirst
 	return stepResult, nil
 }
 
-func (r *PhoneAgent) ExecuteAction(ctx context.Context, action helper.Action, screenWidth, screenHeight int) (helper.ActionResult, error) {
+// encodeScreenshot prepares the screenshot for the model, re-encoding it when
+// the current encoder level or the provider size limit requires it.
+func (r *PhoneAgent) encodeScreenshot(screenshot *definitions.Screenshot) *imaging.Encoded {
+	original := &imaging.Encoded{
+		Base64Data: screenshot.Base64Data,
+		MimeType:   "image/png",
+		Width:      screenshot.Width,
+		Height:     screenshot.Height,
+		Size:       len(screenshot.Data),
+	}
+	if len(screenshot.Data) == 0 {
+		return original
+	}
+
+	level := r.imageEncoder.Level()
+	hash := maphash.Bytes(r.imageSeed, screenshot.Data)
+	if c := r.imageCache; c != nil && c.hash == hash && c.level == level {
+		r.log().Debugf("screen unchanged, reusing encoded screenshot")
+		return c.encoded
+	}
+
+	encoded := original
+	maxBytes := r.ModelConfig.MaxImageBytes
+	if !level.Original() || (maxBytes > 0 && len(screenshot.Data) > maxBytes) {
+		reencoded, err := r.imageEncoder.Encode(screenshot.Data)
+		if err != nil {
+			r.log().Errorf("failed to re-encode screenshot, err: %v", err)
+			return original
+		}
+		r.log().Debugf("screenshot re-encoded: %dx%d %s, %d -> %d bytes",
+			reencoded.Width, reencoded.Height, reencoded.MimeType, len(screenshot.Data), reencoded.Size)
+		encoded = reencoded
+	}
+
+	r.imageCache = &cachedImage{
+		hash:    hash,
+		level:   r.imageEncoder.Level(),
+		encoded: encoded,
+	}
+	return encoded
+}
+
+// imageLevels turns the configured encoding into the levels of the adaptive
+// encoder.
+func imageLevels(config definitions.ImageConfig) []imaging.Level {
+	format, err := imaging.ParseFormat(config.Format)
+	if err != nil {
+		logs.Errorf("%v, sending png", err)
+	}
+	return imaging.Levels(imaging.Level{MaxEdge: config.MaxEdge, Format: format, Quality: config.Quality, Grayscale: config.Grayscale})
+}
+
+// requestModel sends the current state to the model. When the provider
+// rejects the screenshot as too large, the last user message is rebuilt with
+// a smaller encoding and the request is retried. When the history exceeds the
+// context of the model, the earlier steps are dropped and it is retried once.
+func (r *PhoneAgent) requestModel(ctx context.Context, screenshot *definitions.Screenshot, builder ObservationBuilder, sections *ObservationSections, opts llm.RequestOptions) (*llm.ModelResponse, error) {
+	if err := r.chaos.slowModel(ctx); err != nil {
+		return nil, err
+	}
+	dropped := false
+	for {
+		messages, err := r.beforeModelCall(ctx)
+		if err != nil {
+			return nil, err
+		}
+		response, err := r.stepClient().RequestWithOptions(ctx, messages, opts)
+		if err == nil {
+			if !response.Early {
+				r.Usage.Add(response)
+			}
+			if r.ModelConfig.AdaptiveImage {
+				r.imageEncoder.Observe(time.Duration(response.TimeToStreamOpen * float64(time.Second)))
+			}
+			return response, nil
+		}
+
+		if errors.Is(err, llm.ErrContextLengthExceeded) && !dropped {
+			if dropped = r.dropHistory(); dropped {
+				r.log().Warnf("context length exceeded, retrying without the earlier steps, err: %v", err)
+				continue
+			}
+		}
+		if !llm.IsImageTooLarge(err) || len(screenshot.Data) == 0 || !r.imageEncoder.Downgrade() {
+			return nil, err
+		}
+		r.log().Warnf("screenshot rejected as too large, retrying with %+v", r.imageEncoder.Level())
+
+		encoded := r.encodeScreenshot(screenshot)
+		r.recordImage(encoded)
+		sections.ImageURL = encoded.DataURL()
+		r.State[len(r.State)-1] = builder.Build(sections)
+	}
+}
+
+// startEarlyAction parses an action streamed ahead of the full response and
+// starts executing it. It returns nil when the action cannot be parsed, in
+// which case the step falls back to the complete response, with the error
+// of the middleware when it rejected the action.
+func (r *PhoneAgent) startEarlyAction(ctx context.Context, raw string, screenshot *definitions.Screenshot) (*earlyAction, error) {
+	action, err := parseAction(raw)
+	if err != nil {
+		r.log().Debugf("streamed action not parsable yet, waiting for the full response: %v", err)
+		return nil, nil
+	}
+	if action, err = r.afterParse(ctx, "", raw, action); err != nil {
+		r.log().Debugf("streamed action %v, waiting for the full response", err)
+		return nil, err
+	}
+
+	r.log().Debugf("executing streamed action early: %s", raw)
+	e := &earlyAction{
+		raw:    raw,
+		action: action,
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(e.done)
+		started := time.Now()
+		e.result, e.err = r.ExecuteAction(ctx, action, screenshot.Width, screenshot.Height)
+		e.elapsed = time.Since(started)
+	}()
+	return e, nil
+}
+
+// keepEarlyAction records an action executed early whose response then
+// failed: it ran on the device, so the history and the repeat checks have it
+// as the action of the step.
+func (r *PhoneAgent) keepEarlyAction(obs *observation, screenshot *definitions.Screenshot, early *earlyAction) {
+	success := early.err == nil && early.result.Success
+	message := early.result.Message
+	if early.err != nil {
+		message = fmt.Sprintf("Failed to execute action: %v", early.err)
+	}
+	r.emit(Event{Type: EventAction, Action: maps.Clone(early.action)})
+	r.emit(Event{Type: EventActionResult, Success: success, Message: message})
+	r.recordStep(early.action, success)
+	r.trackRepeat(screenshot.Data, early.action)
+	r.keepScreenshot(obs, early.raw)
+	r.State = append(r.State, helper.CreateAssistantMessage(fmt.Sprintf("<think></think><answer>%s</answer>", early.raw)))
+}
+
+func (r *PhoneAgent) executeAction(ctx context.Context, action helper.Action, screenWidth, screenHeight int) (helper.ActionResult, error) {
 	actionType := utils.AnyToString(action["_metadata"])
 
 	if actionType == "finish" {
@@ -215,26 +869,12 @@ func (r *PhoneAgent) ExecuteAction(ctx context.Context, action helper.Action, sc
 
 	actionName := utils.AnyToString(action["action"])
 	switch actionName {
-	case "Launch":
-		return r.handleLaunch(ctx, action, screenWidth, screenHeight)
 	case "Tap":
 		return r.handleTap(ctx, action, screenWidth, screenHeight)
 	case "Type":
 		return r.handleType(ctx, action, screenWidth, screenHeight)
 	case "Type_Name":
 		return r.handleType(ctx, action, screenWidth, screenHeight)
-	case "Swipe":
-		return r.handleSwipe(ctx, action, screenWidth, screenHeight)
-	case "Back":
-		return r.handleBack(ctx, action, screenWidth, screenHeight)
-	case "Home":
-		return r.handleHome(ctx, action, screenWidth, screenHeight)
-	case "Double Tap":
-		return r.handleDoubleTap(ctx, action, screenWidth, screenHeight)
-	case "Long Press":
-		return r.handleLongPress(ctx, action, screenWidth, screenHeight)
-	case "Wait":
-		return r.handleWait(ctx, action, screenWidth, screenHeight)
 	case "Take_over":
 		return r.handleTakeover(ctx, action, screenWidth, screenHeight)
 	case "Note":
@@ -243,7 +883,19 @@ func (r *PhoneAgent) ExecuteAction(ctx context.Context, action helper.Action, sc
 		return r.handleCallAPI(ctx, action, screenWidth, screenHeight)
 	case "Interact":
 		return r.handleInteract(ctx, action, screenWidth, screenHeight)
+	case "Dismiss_Overlay":
+		return r.handleDismissOverlay(ctx, action, screenWidth, screenHeight)
+	case "Open_Notification":
+		return r.handleOpenNotification(ctx, action, screenWidth, screenHeight)
+	case "Dismiss_Notification":
+		return r.handleDismissNotification(ctx, action, screenWidth, screenHeight)
 	default:
+		if executor.Supports(actionName) {
+			return executor.Execute(ctx, r.Device, action, r.executorOptions(screenWidth, screenHeight))
+		}
+		if plugin, ok := LookupActionPlugin(actionName); ok && pluginEnabled(plugin, r.AgentConfig.DeviceID) {
+			return r.executePlugin(ctx, plugin, action, screenWidth, screenHeight)
+		}
 		return helper.ActionResult{
 			Success:      false,
 			ShouldFinish: false,
@@ -252,40 +904,46 @@ func (r *PhoneAgent) ExecuteAction(ctx context.Context, action helper.Action, sc
 	}
 }
 
-func (r *PhoneAgent) handleLaunch(ctx context.Context, action helper.Action, screenWidth, screenHeight int) (helper.ActionResult, error) {
-	appName := utils.AnyToString(action["app"])
-	if len(appName) == 0 {
-		return helper.ActionResult{
-			Success:      false,
-			ShouldFinish: false,
-			Message:      "No app name specified",
-		}, nil
+// executorOptions are the options of the actions run on the device, on a
+// screen of screenWidth by screenHeight pixels.
+func (r *PhoneAgent) executorOptions(screenWidth, screenHeight int) executor.Options {
+	return executor.Options{
+		DeviceID:     r.AgentConfig.DeviceID,
+		ScreenWidth:  screenWidth,
+		ScreenHeight: screenHeight,
+		Calibration:  r.calibration,
+		InstallDir:   r.AgentConfig.InstallDir,
 	}
-	_, err := r.Device.LaunchApp(ctx, appName, r.AgentConfig.DeviceID)
-	if err != nil {
-		logs.Errorf("failed to launch app, err: %v", err)
-		return helper.ActionResult{
-			Success:      false,
-			ShouldFinish: false,
-			Message:      fmt.Sprintf("failed to launch app, err: %v", err),
-		}, nil
-	}
-
-	return helper.ActionResult{
-		Success:      true,
-		ShouldFinish: false,
-	}, nil
 }
 
 func (r *PhoneAgent) convertRelativeToAbsolute(element []int, screenWidth, screenHeight int) (int, int) {
-	x := int(float64(element[0]) / float64(1000) * float64(screenWidth))
-	y := int(float64(element[1]) / float64(1000) * float64(screenHeight))
-	return x, y
+	return r.executorOptions(screenWidth, screenHeight).Point(element)
+}
+
+// stdinMu serializes terminal prompts when several agents share one process.
+var stdinMu sync.Mutex
+
+// speakTimeout bounds how long a spoken message may hold up the agent.
+const speakTimeout = time.Minute
+
+func (r *PhoneAgent) speak(ctx context.Context, text string) {
+	if r.Speaker == nil || text == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, speakTimeout)
+	defer cancel()
+	if err := r.Speaker.Speak(ctx, text); err != nil {
+		r.log().Warnf("failed to speak message, err: %v", err)
+	}
 }
 
 func (r *PhoneAgent) DefaultConfirmation(message string) bool {
+	stdinMu.Lock()
+	defer stdinMu.Unlock()
+
 	reader := bufio.NewReader(os.Stdin)
 	fmt.Printf("Sensitive operation: %s\nConfirm? (Y/N): ", message)
+	r.speak(context.Background(), message)
 
 	response, _ := reader.ReadString('\n')
 	response = strings.TrimSpace(response)
@@ -295,8 +953,12 @@ func (r *PhoneAgent) DefaultConfirmation(message string) bool {
 }
 
 func (r *PhoneAgent) DefaultTakeover(message string) {
+	stdinMu.Lock()
+	defer stdinMu.Unlock()
+
 	reader := bufio.NewReader(os.Stdin)
 	fmt.Printf("%s\nPress Enter after completing manual operation...", message)
+	r.speak(context.Background(), message)
 	_, _ = reader.ReadString('\n')
 }
 
@@ -311,119 +973,263 @@ func (r *PhoneAgent) handleTap(ctx context.Context, action helper.Action, screen
 	}
 
 	x, y := r.convertRelativeToAbsolute(element, screenWidth, screenHeight)
-	if msg, ok := action["message"]; ok {
-		if !r.DefaultConfirmation(utils.AnyToString(msg)) {
-			return helper.ActionResult{
-				Success:      false,
-				ShouldFinish: true,
-				Message:      "User cancelled sensitive operation",
-			}, nil
-		}
+	// a sensitive tap was confirmed by ExecuteAction
+	if r.webTap(ctx, x, y) {
+		return helper.ActionResult{Success: true, ShouldFinish: false}, nil
+	}
+	r.noteOverlayTap(x, y)
+	if result, err := executor.Execute(ctx, r.Device, action, r.executorOptions(screenWidth, screenHeight)); err != nil || !result.Success {
+		return result, err
 	}
-	_ = r.Device.Tap(ctx, x, y, r.AgentConfig.DeviceID)
 
+	if label := r.groundTap(ctx, x, y); label != "" {
+		return helper.ActionResult{
+			Success:      true,
+			ShouldFinish: false,
+			Message:      fmt.Sprintf("Tap missed, retried on %q", label),
+		}, nil
+	}
 	return helper.ActionResult{Success: true, ShouldFinish: false}, nil
 }
 
 func (r *PhoneAgent) Reset(ctx context.Context) {
-	r.State = []openai.ChatCompletionMessage{}
+	helper.PutMessageSlice(r.State)
+	r.State = helper.GetMessageSlice()
 	r.StepCount = 0
+	r.stepBase = 0
+	r.dropObservation()
+	r.dropSpeculation()
+	r.lastUIElements = nil
+	r.droppedMessages = 0
+	r.droppedSteps = 0
+	r.recap = nil
+	r.task = ""
+	r.Trajectory = nil
+	r.hookObservations = nil
+	r.closeWeb()
+	r.stepObservation = nil
+	r.stepThinking = ""
+	r.plan = nil
+	r.stepRoute = nil
+	r.judgeFrames = nil
+	r.finalFrame = ""
+	r.Output = nil
+	r.Outcome = nil
+	r.outcomeMessage = ""
+	r.deviceNote = ""
+	r.keptImages = nil
+	r.lastScreen = nil
+	r.SessionID = ""
+	r.sessionCreatedAt = time.Time{}
+	r.storedSteps = 0
+	r.record = nil
+	r.Usage.Reset()
+	if r.Router != nil {
+		r.Router.reset()
+	}
+	if r.history != nil {
+		if err := r.history.Close(); err != nil {
+			r.log().Warnf("failed to close history file, err: %v", err)
+		}
+		r.history = nil
+	}
 }
 
-func (r *PhoneAgent) handleType(ctx context.Context, action helper.Action, width int, height int) (helper.ActionResult, error) {
-	text := utils.AnyToString(action["text"])
-	device := r.Device
-	deviceID := r.AgentConfig.DeviceID
+// compactHistory keeps State within the configured history caps. The system
+// prompt and the first step are always kept. Everything removed is written
+// to the history file first.
+func (r *PhoneAgent) compactHistory() {
+	keepSteps, maxSteps := r.AgentConfig.HistoryKeepSteps, r.AgentConfig.HistoryMaxSteps
+	if keepSteps <= 0 && maxSteps <= 0 {
+		return
+	}
+	var records []history.Record
+	now := time.Now()
 
-	// Switch to ADB keyboard
-	originalIME, _ := device.DetectAndSetADBKeyboard(ctx, deviceID)
-	time.Sleep(time.Second * 1)
+	if keepSteps > 0 {
+		seen := 0
+		for i := len(r.State) - 1; i > 0; i-- {
+			if r.State[i].Role != openai.ChatMessageRoleAssistant {
+				continue
+			}
+			if seen++; seen <= keepSteps {
+				continue
+			}
+			msg, thinking := helper.RemoveThinkingFromMessage(r.State[i])
+			if thinking == "" {
+				// older messages were trimmed by earlier steps
+				break
+			}
+			r.State[i] = msg
+			records = append(records, history.Record{
+				Index:   r.droppedMessages + i,
+				Role:    "thinking",
+				Content: thinking,
+				Time:    now,
+			})
+		}
+	}
 
-	// Clear existing text and type new text
-	_ = device.ClearText(ctx, deviceID)
-	time.Sleep(time.Second * 1)
+	r.spill(records)
 
-	// Handle multiline text by splitting on newlines
-	_ = device.TypeText(ctx, text, deviceID)
-	time.Sleep(time.Second * 1)
+	// the steps past the first one are kept from the user message of each
+	if starts := r.stepStarts(); maxSteps > 0 && len(starts) > maxSteps-1 {
+		end := len(r.State)
+		if maxSteps > 1 {
+			end = starts[len(starts)-(maxSteps-1)]
+		}
+		if head := r.historyHead(); end > head {
+			r.dropSteps(end - head)
+		}
+	}
+}
 
-	// Restore original keyboard
-	_ = device.RestoreKeyboard(ctx, originalIME, deviceID)
-	time.Sleep(time.Second * 1)
+// dropHistory removes the steps between the first one and the current
+// prompt, for a model whose context they no longer fit in. It reports false
+// when there is none to remove.
+func (r *PhoneAgent) dropHistory() bool {
+	n := len(r.State) - r.historyHead() - 1
+	if n <= 0 {
+		return false
+	}
+	r.dropSteps(n)
+	return true
+}
 
-	return helper.ActionResult{Success: true, ShouldFinish: false}, nil
+// spill writes removed messages to the history file.
+func (r *PhoneAgent) spill(records []history.Record) {
+	if r.history == nil {
+		name := strings.NewReplacer(":", "_", "/", "_").Replace(r.AgentConfig.DeviceID)
+		if name == "" {
+			name = "session"
+		}
+		r.history = history.NewSpill(r.AgentConfig.HistoryDir, name)
+	}
+	if err := r.history.Write(records...); err != nil {
+		r.log().Warnf("failed to spill history, err: %v", err)
+	}
 }
 
-func (r *PhoneAgent) handleSwipe(ctx context.Context, action helper.Action, screenWidth, screenHeight int) (helper.ActionResult, error) {
-	start := utils.AnyToIntSlice(action["start"])
-	end := utils.AnyToIntSlice(action["end"])
-	if len(start) != 2 || len(end) != 2 {
-		return helper.ActionResult{
-			Success:      false,
-			ShouldFinish: false,
-			Message:      "Invalid swipe coordinates",
-		}, nil
+func (r *PhoneAgent) captureObservation(ctx context.Context) *observation {
+	var (
+		obs = &observation{}
+		wg  sync.WaitGroup
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		appCtx, span := tracing.Start(ctx, "device.current_app")
+		var err error
+		obs.currentApp, err = r.Device.GetCurrentApp(appCtx, r.AgentConfig.DeviceID)
+		span.RecordError(err)
+		span.End()
+		obs.profile = r.focusedProfile()
+		if r.needsUIDump() {
+			dumpCtx, span := tracing.Start(ctx, "device.dump_ui")
+			obs.uiElements, err = r.Device.DumpUI(dumpCtx, r.AgentConfig.DeviceID)
+			span.SetAttributes(tracing.Int("ui.elements", len(obs.uiElements)))
+			span.RecordError(err)
+			span.End()
+		}
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		obs.overlays = r.listOverlays(ctx)
+		if r.AgentConfig.Notifications {
+			obs.notifications = r.listNotifications(ctx)
+		}
+	}()
+	shotCtx, span := tracing.Start(ctx, "device.screenshot")
+	screenshot, err := r.Device.GetScreenshot(shotCtx, r.AgentConfig.DeviceID)
+	if screenshot != nil {
+		span.SetAttributes(tracing.Int("screenshot.bytes", len(screenshot.Data)))
 	}
-	startX, startY := r.convertRelativeToAbsolute(start, screenWidth, screenHeight)
-	endX, endY := r.convertRelativeToAbsolute(end, screenWidth, screenHeight)
-	_ = r.Device.Swipe(ctx, startX, startY, endX, endY, r.AgentConfig.DeviceID)
-	return helper.ActionResult{Success: true, ShouldFinish: false}, nil
+	span.RecordError(err)
+	span.End()
+	obs.screenshot = r.chaos.failScreenshot(screenshot)
+	wg.Wait()
+	return obs
 }
 
-func (r *PhoneAgent) handleBack(ctx context.Context, action helper.Action, screenWidth, screenHeight int) (helper.ActionResult, error) {
-	_ = r.Device.Back(ctx, r.AgentConfig.DeviceID)
-	return helper.ActionResult{Success: true, ShouldFinish: false}, nil
+// buildUIContext describes the UI dump of obs. After the first step only the
+// changes against the previous dump are sent, unless most of the screen changed.
+func (r *PhoneAgent) buildUIContext(obs *observation) string {
+	prev := r.lastUIElements
+	r.lastUIElements = obs.uiElements
+	if obs.uiElements == nil {
+		return ""
+	}
+
+	width, height := obs.screenshot.Width, obs.screenshot.Height
+	if prev == nil {
+		return helper.FormatUIElements(obs.uiElements, width, height)
+	}
+
+	diff := helper.DiffUIElements(prev, obs.uiElements)
+	if diff.Size()*2 > len(obs.uiElements) {
+		return helper.FormatUIElements(obs.uiElements, width, height)
+	}
+	return helper.FormatUIDiff(diff, width, height)
 }
 
-func (r *PhoneAgent) handleHome(ctx context.Context, action helper.Action, screenWidth, screenHeight int) (helper.ActionResult, error) {
-	_ = r.Device.Home(ctx, r.AgentConfig.DeviceID)
-	return helper.ActionResult{Success: true, ShouldFinish: false}, nil
+// prefetch is an observation captured in the background.
+type prefetch struct {
+	result chan *observation
+	cancel context.CancelFunc
 }
 
-func (r *PhoneAgent) handleDoubleTap(ctx context.Context, action helper.Action, screenWidth, screenHeight int) (helper.ActionResult, error) {
-	element := utils.AnyToIntSlice(action["element"])
-	if len(element) != 2 {
-		return helper.ActionResult{
-			Success:      false,
-			ShouldFinish: true,
-			Message:      "Invalid element coordinates",
-		}, nil
+// startObservation captures the next observation in the background.
+func (r *PhoneAgent) startObservation(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	p := &prefetch{result: make(chan *observation, 1), cancel: cancel}
+	go func() {
+		p.result <- r.captureObservation(ctx)
+	}()
+	r.nextObservation = p
+}
+
+// takeObservation returns the prefetched observation if any, otherwise
+// captures a new one.
+func (r *PhoneAgent) takeObservation(ctx context.Context) *observation {
+	if p := r.nextObservation; p != nil {
+		r.nextObservation = nil
+		defer p.cancel()
+		return <-p.result
 	}
-	x, y := r.convertRelativeToAbsolute(element, screenWidth, screenHeight)
-	_ = r.Device.DoubleTap(ctx, x, y, r.AgentConfig.DeviceID)
-	return helper.ActionResult{Success: true, ShouldFinish: false}, nil
+	return r.captureObservation(ctx)
 }
 
-func (r *PhoneAgent) handleLongPress(ctx context.Context, action helper.Action, screenWidth, screenHeight int) (helper.ActionResult, error) {
-	element := utils.AnyToIntSlice(action["element"])
-	if len(element) != 2 {
-		return helper.ActionResult{
-			Success:      false,
-			ShouldFinish: true,
-			Message:      "Invalid element coordinates",
-		}, nil
+// dropObservation cancels the prefetched observation, if any, and waits for
+// it: the screen it captured is no longer the next one, and a capture still
+// running would race the next on the device.
+func (r *PhoneAgent) dropObservation() {
+	if p := r.nextObservation; p != nil {
+		r.nextObservation = nil
+		p.cancel()
+		<-p.result
 	}
-	x, y := r.convertRelativeToAbsolute(element, screenWidth, screenHeight)
-	_ = r.Device.LongPress(ctx, x, y, r.AgentConfig.DeviceID)
-	return helper.ActionResult{Success: true, ShouldFinish: false}, nil
 }
 
-func (r *PhoneAgent) handleWait(ctx context.Context, action helper.Action, screenWidth, screenHeight int) (helper.ActionResult, error) {
-	durationStr := utils.AnyToString(action["duration"])
-	duration, err := strconv.ParseFloat(strings.ReplaceAll(durationStr, "seconds", ""), 64)
+func (r *PhoneAgent) handleType(ctx context.Context, action helper.Action, width int, height int) (helper.ActionResult, error) {
+	text, err := r.expandInput(ctx, utils.AnyToString(action["text"]))
 	if err != nil {
-		duration = 1.0
+		return helper.ActionResult{
+			Success:      false,
+			ShouldFinish: false,
+			Message:      fmt.Sprintf("invalid placeholder in text, %v", err),
+		}, nil
 	}
-	time.Sleep(time.Duration(duration) * time.Second)
-	return helper.ActionResult{Success: true, ShouldFinish: false}, nil
+	if r.webType(ctx, text) {
+		return helper.ActionResult{Success: true, ShouldFinish: false}, nil
+	}
+	typed := maps.Clone(action)
+	typed["text"] = text
+	return executor.Execute(ctx, r.Device, typed, r.executorOptions(width, height))
 }
 
 func (r *PhoneAgent) handleTakeover(ctx context.Context, action helper.Action, screenWidth, screenHeight int) (helper.ActionResult, error) {
-	message := utils.AnyToString(action["message"])
-	if message == "" {
-		message = "User intervention required"
-	}
-	r.DefaultTakeover(message)
+	// the user handed the device back in ExecuteAction
 	return helper.ActionResult{Success: true, ShouldFinish: false}, nil
 }
 
@@ -0,0 +1,87 @@
+package android
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"autoglm-go/phoneagent/definitions"
+	logs "github.com/sirupsen/logrus"
+)
+
+// StartIntent starts an activity with am start, as the target user when one
+// is set. Without an activity the launcher activity of the package is
+// started, or the app the system picks for the action and URI.
+func (r *ADBDevice) StartIntent(ctx context.Context, intent definitions.Intent, deviceID string) error {
+	args := []string{"am", "start"}
+	userID, hasUser := r.targetUser(deviceID)
+	if hasUser {
+		args = append(args, "--user", strconv.Itoa(userID))
+	}
+	if intent.Action != "" {
+		args = append(args, "-a", shellQuote(intent.Action))
+	}
+	if intent.URI != "" {
+		args = append(args, "-d", shellQuote(intent.URI))
+	}
+	switch {
+	case intent.Activity != "":
+		args = append(args, "-n", shellQuote(intent.Package+"/"+intent.Activity))
+	case intent.Package != "" && intent.Action == "" && intent.URI == "":
+		if !hasUser {
+			userID = -1
+		}
+		component, err := r.launcherActivity(ctx, deviceID, intent.Package, userID)
+		if err != nil {
+			return err
+		}
+		args = append(args, "-n", shellQuote(component))
+	case intent.Package != "":
+		args = append(args, "-p", shellQuote(intent.Package))
+	}
+
+	logs.Debugf("[StartIntent] run shell: %s", strings.Join(args, " "))
+	output, err := r.Shell(ctx, deviceID, args...)
+	if err != nil {
+		return err
+	}
+	if strings.Contains(output, "Error") {
+		return fmt.Errorf("am start failed: %s", strings.TrimSpace(output))
+	}
+	time.Sleep(time.Second * 1)
+	return nil
+}
+
+// ForceStop stops every process of packageName.
+func (r *ADBDevice) ForceStop(ctx context.Context, packageName, deviceID string) error {
+	args := []string{"am", "force-stop"}
+	if userID, ok := r.targetUser(deviceID); ok {
+		args = append(args, "--user", strconv.Itoa(userID))
+	}
+	args = append(args, shellQuote(packageName))
+	logs.Debugf("[ForceStop] run shell: %s", strings.Join(args, " "))
+	_, err := r.Shell(ctx, deviceID, args...)
+	return err
+}
+
+// ClearData deletes the data and cache of packageName with pm clear, which
+// also stops it.
+func (r *ADBDevice) ClearData(ctx context.Context, packageName, deviceID string) error {
+	args := []string{"pm", "clear"}
+	if userID, ok := r.targetUser(deviceID); ok {
+		args = append(args, "--user", strconv.Itoa(userID))
+	}
+	args = append(args, shellQuote(packageName))
+	logs.Debugf("[ClearData] run shell: %s", strings.Join(args, " "))
+	output, err := r.Shell(ctx, deviceID, args...)
+	if err != nil {
+		return err
+	}
+	// pm clear prints Success, or Failed for a package that is not installed
+	if !strings.Contains(output, "Success") {
+		return fmt.Errorf("pm clear %s failed: %s", packageName, strings.TrimSpace(output))
+	}
+	return nil
+}
@@ -131,20 +131,61 @@ func (r *ADBDevice) ListDevices(ctx context.Context) ([]definitions.DeviceInfo,
 			Status:         status,
 			ConnectionType: connType,
 			Model:          model,
+			OS:             definitions.Android,
 		})
 	}
 
 	return devices, nil
 }
 
+// GetDeviceInfo returns deviceID from `adb devices`, or the only device when
+// deviceID is empty, like adb itself.
 func (r *ADBDevice) GetDeviceInfo(ctx context.Context, deviceID string) (*definitions.DeviceInfo, error) {
-	// TODO implement me
-	panic("implement me")
+	devices, err := r.ListDevices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if deviceID == "" {
+		if len(devices) != 1 {
+			return nil, fmt.Errorf("%d devices found, a device id is required", len(devices))
+		}
+		return &devices[0], nil
+	}
+	for i := range devices {
+		if devices[i].DeviceID == deviceID {
+			return &devices[i], nil
+		}
+	}
+	return nil, fmt.Errorf("device %s not found", deviceID)
 }
 
+// IsConnected reports whether the device is online, not offline or
+// unauthorized.
 func (r *ADBDevice) IsConnected(ctx context.Context, deviceID string) bool {
-	// TODO implement me
-	panic("implement me")
+	info, err := r.GetDeviceInfo(ctx, deviceID)
+	return err == nil && info.Status == "device"
+}
+
+// Ping runs a one-off shell command on the device, the persistent shell of a
+// device that does not answer is dropped for a new one.
+func (r *ADBDevice) Ping(ctx context.Context, deviceID string) error {
+	output, err := r.execShell(ctx, deviceID, "echo", "ok")
+	if err == nil && strings.TrimSpace(output) == "ok" {
+		return nil
+	}
+	r.mu.Lock()
+	session := r.shells[deviceID]
+	r.mu.Unlock()
+	if session != nil {
+		r.dropShell(deviceID, session)
+	}
+	if err == nil {
+		return fmt.Errorf("unexpected output: %s", strings.TrimSpace(output))
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("no answer: %w", ctx.Err())
+	}
+	return fmt.Errorf("%v: %s", err, strings.TrimSpace(output))
 }
 
 func (r *ADBDevice) EnableTCPIP(ctx context.Context, port int, deviceID string) error {
@@ -180,24 +221,18 @@ func (r *ADBDevice) GetDeviceIP(ctx context.Context, deviceID string) (string, e
 	defer cancel()
 
 	// ---------- 1. adb shell ip route ----------
-	var cmdArgs []string
-	if len(deviceID) > 0 {
-		cmdArgs = append(cmdArgs, "-s", deviceID)
-	}
-	cmdArgs = append(cmdArgs, "shell", "ip", "route")
-
-	cmd := exec.CommandContext(ctx, adbPath, cmdArgs...)
-	logs.Debugf("[GetDeviceIP] run cmd1: %s %s\n", adbPath, strings.Join(cmdArgs, " "))
+	cmdArgs := []string{"ip", "route"}
+	logs.Debugf("[GetDeviceIP] run shell1: %s\n", strings.Join(cmdArgs, " "))
 
-	output, err := cmd.CombinedOutput()
+	output, err := r.Shell(ctx, deviceID, cmdArgs...)
 	if err != nil {
 		logs.Errorf("[GetDeviceIP] run cmd1 failed, err: %v\n", err)
 		return "", err
 	}
 
-	logs.Debugf("[GetDeviceIP] output1: %s\n", string(output))
+	logs.Debugf("[GetDeviceIP] output1: %s\n", output)
 
-	lines := strings.Split(string(output), "\n")
+	lines := strings.Split(output, "\n")
 	for _, line := range lines {
 		if strings.Contains(line, "src") {
 			parts := strings.Fields(line)
@@ -211,22 +246,17 @@ func (r *ADBDevice) GetDeviceIP(ctx context.Context, deviceID string) (string, e
 	}
 
 	// ---------- 2. adb shell ip addr show wlan0 ----------
-	cmdArgs = []string{}
-	if len(deviceID) > 0 {
-		cmdArgs = append(cmdArgs, "-s", deviceID)
-	}
-	cmdArgs = append(cmdArgs, "shell", "ip", "addr", "show", "wlan0")
-	logs.Debugf("[GetDeviceIP] run cmd2: %s %s\n", adbPath, strings.Join(cmdArgs, " "))
+	cmdArgs = []string{"ip", "addr", "show", "wlan0"}
+	logs.Debugf("[GetDeviceIP] run shell2: %s\n", strings.Join(cmdArgs, " "))
 
-	cmd = exec.CommandContext(ctx, adbPath, cmdArgs...)
-	output, err = cmd.CombinedOutput()
+	output, err = r.Shell(ctx, deviceID, cmdArgs...)
 	if err != nil {
 		logs.Errorf("[GetDeviceIP] run cmd2 failed, err: %v\n", err)
 		return "", err
 	}
-	logs.Debugf("[GetDeviceIP] output2: %s\n", string(output))
+	logs.Debugf("[GetDeviceIP] output2: %s\n", output)
 
-	lines = strings.Split(string(output), "\n")
+	lines = strings.Split(output, "\n")
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
 		if strings.Contains(line, "inet ") {
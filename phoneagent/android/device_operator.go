@@ -12,6 +12,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"autoglm-go/constants"
@@ -21,6 +22,15 @@ import (
 )
 
 type ADBDevice struct {
+	mu     sync.Mutex
+	shells map[string]*shellSession // persistent adb shell per device id
+	users  map[string]int           // target user per device id, see SetUser
+	focus  map[string]int           // user of the focused window per device id
+
+	// KeyboardAPK is the ADB keyboard APK installed on the devices without
+	// it the first time they type, see DetectAndSetADBKeyboard.
+	KeyboardAPK string
+	keyboard    map[string]bool // whether the ADB keyboard types on a device id
 }
 
 // createFallbackScreenshot creates a black fallback image when screenshot fails.
@@ -65,10 +75,10 @@ func (r *ADBDevice) GetScreenshot(ctx context.Context, deviceID string) (*defini
 	}
 
 	// 截屏
-	screenshotArgs := append(cmdArgs, "shell", "screencap", "-p", "/sdcard/tmp.png")
-	logs.Debugf("[GetScreenshot] run cmd1: %s %s", adbPath, strings.Join(screenshotArgs, " "))
+	screenshotArgs := []string{"screencap", "-p", "/sdcard/tmp.png"}
+	logs.Debugf("[GetScreenshot] run shell1: %s", strings.Join(screenshotArgs, " "))
 
-	output, err := exec.CommandContext(ctx, adbPath, screenshotArgs...).CombinedOutput()
+	output, err := r.Shell(ctx, deviceID, screenshotArgs...)
 	if err != nil {
 		logs.Errorf("Screenshot command error: %v, output: %s", err, output)
 		return createFallbackScreenshot(false), nil
@@ -76,7 +86,7 @@ func (r *ADBDevice) GetScreenshot(ctx context.Context, deviceID string) (*defini
 
 	logs.Debugf("[GetScreenshot] cmd1 output: %s", output)
 
-	outputStr := string(output)
+	outputStr := output
 	if strings.Contains(outputStr, "Status: -1") || strings.Contains(outputStr, "Failed") {
 		logs.Errorf("Screenshot failed with status: -1 or Failed, output: %s", outputStr)
 		return createFallbackScreenshot(true), nil
@@ -86,13 +96,13 @@ func (r *ADBDevice) GetScreenshot(ctx context.Context, deviceID string) (*defini
 	pullArgs := append(cmdArgs, "pull", "/sdcard/tmp.png", tempPath)
 	logs.Debugf("[GetScreenshot] run cmd2: %s %s", adbPath, strings.Join(pullArgs, " "))
 
-	output, err = exec.CommandContext(ctx, adbPath, pullArgs...).CombinedOutput()
+	pullOutput, err := exec.CommandContext(ctx, adbPath, pullArgs...).CombinedOutput()
 	if err != nil {
-		logs.Errorf("Pull command error: %v, output: %s", err, output)
+		logs.Errorf("Pull command error: %v, output: %s", err, pullOutput)
 		return createFallbackScreenshot(false), nil
 	}
 
-	logs.Debugf("[GetScreenshot] cmd2 output: %s", output)
+	logs.Debugf("[GetScreenshot] cmd2 output: %s", pullOutput)
 
 	// 读取文件并 Base64 编码
 	data, err := os.ReadFile(tempPath)
@@ -116,35 +126,35 @@ func (r *ADBDevice) GetScreenshot(ctx context.Context, deviceID string) (*defini
 		Width:       width,
 		Height:      height,
 		IsSensitive: false,
+		Data:        data,
 	}, nil
 }
 
 func (r *ADBDevice) GetCurrentApp(ctx context.Context, deviceID string) (string, error) {
+	args := []string{"dumpsys", "window"}
+	logs.Debugf("[GetCurrentApp] run shell: %s", strings.Join(args, " "))
 
-	var cmdArgs []string
-	if deviceID != "" {
-		cmdArgs = append(cmdArgs, "-s", deviceID)
-	}
-
-	args := append(cmdArgs, "shell", "dumpsys", "window")
-	logs.Debugf("[GetCurrentApp] run cmd: %s %s", adbPath, strings.Join(args, " "))
-
-	output, err := exec.CommandContext(ctx, adbPath, args...).CombinedOutput()
+	output, err := r.Shell(ctx, deviceID, args...)
 	if err != nil {
 		logs.Errorf("Error running dumpsys window: %v, output: %s", err, output)
 		return "", fmt.Errorf("failed to run dumpsys window: %w", err)
 	}
 
-	outputStr := string(output)
+	outputStr := output
 	if outputStr == "" {
 		logs.Errorf("dumpsys window output is empty")
 		return "", fmt.Errorf("no output from dumpsys window")
 	}
 
 	// 遍历每行查找焦点窗口信息
+	focusNoted := false
 	for _, line := range strings.Split(outputStr, "\n") {
 		line = strings.TrimSpace(line)
 		if strings.Contains(line, "mCurrentFocus") || strings.Contains(line, "mFocusedApp") {
+			if !focusNoted {
+				r.noteFocus(deviceID, line)
+				focusNoted = true
+			}
 			for appName, packageName := range constants.APP_PACKAGES_ANDROID {
 				if strings.Contains(line, packageName) {
 					return appName, nil
@@ -158,12 +168,10 @@ func (r *ADBDevice) GetCurrentApp(ctx context.Context, deviceID string) (string,
 }
 
 func (r *ADBDevice) Tap(ctx context.Context, x, y int, deviceID string) error {
-	adbPrefix := r.GetADBPrefix(deviceID)
+	args := []string{"input", "tap", strconv.Itoa(x), strconv.Itoa(y)}
+	logs.Debugf("[Tap] run shell: %s", strings.Join(args, " "))
 
-	args := append(adbPrefix, "shell", "input", "tap", strconv.Itoa(x), strconv.Itoa(y))
-	logs.Debugf("[Tap] run cmd: %s %s", adbPath, strings.Join(args, " "))
-
-	_, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	_, err := r.Shell(ctx, deviceID, args...)
 	time.Sleep(time.Second * 1)
 	return err
 }
@@ -186,16 +194,14 @@ func (r *ADBDevice) DoubleTap(ctx context.Context, x, y int, deviceID string) er
 }
 
 func (r *ADBDevice) LongPress(ctx context.Context, x, y int, deviceID string) error {
-	adbPrefix := r.GetADBPrefix(deviceID)
-
-	args := append(adbPrefix,
-		"shell", "input", "swipe",
+	args := []string{
+		"input", "swipe",
 		strconv.Itoa(x), strconv.Itoa(y),
 		strconv.Itoa(x), strconv.Itoa(y),
 		strconv.Itoa(3000),
-	)
-	logs.Debugf("[LongPress] run cmd: %s %s", adbPath, strings.Join(args, " "))
-	_, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	}
+	logs.Debugf("[LongPress] run shell: %s", strings.Join(args, " "))
+	_, err := r.Shell(ctx, deviceID, args...)
 	time.Sleep(time.Second * 1)
 	return err
 }
@@ -204,38 +210,35 @@ func (r *ADBDevice) Swipe(ctx context.Context, startX, startY, endX, endY int, d
 	distSq := (startX-endX)*(startX-endX) + (startY-endY)*(startY-endY)
 	durationMs := int(float64(distSq) / 1000)
 	durationMs = max(1000, min(durationMs, 2000)) // Clamp between 1000-2000ms
-	adbPrefix := r.GetADBPrefix(deviceID)
 
-	args := append(adbPrefix,
-		"shell", "input", "swipe",
+	args := []string{
+		"input", "swipe",
 		strconv.Itoa(startX), strconv.Itoa(startY),
 		strconv.Itoa(endX), strconv.Itoa(endY),
 		strconv.Itoa(durationMs),
-	)
+	}
 
-	logs.Debugf("[Swipe] run cmd: %s %s", adbPath, strings.Join(args, " "))
+	logs.Debugf("[Swipe] run shell: %s", strings.Join(args, " "))
 
-	_, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	_, err := r.Shell(ctx, deviceID, args...)
 	time.Sleep(time.Second * 1)
 	return err
 }
 
 func (r *ADBDevice) Back(ctx context.Context, deviceID string) error {
-	adbPrefix := r.GetADBPrefix(deviceID)
-	args := append(adbPrefix, "shell", "input", "keyevent", "4")
+	args := []string{"input", "keyevent", "4"}
 
-	logs.Debugf("[Back] run cmd: %s %s", adbPath, strings.Join(args, " "))
-	_, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	logs.Debugf("[Back] run shell: %s", strings.Join(args, " "))
+	_, err := r.Shell(ctx, deviceID, args...)
 	time.Sleep(time.Second * 1)
 	return err
 }
 
 func (r *ADBDevice) Home(ctx context.Context, deviceID string) error {
-	adbPrefix := r.GetADBPrefix(deviceID)
-	args := append(adbPrefix, "shell", "input", "keyevent", "KEYCODE_HOME")
+	args := []string{"input", "keyevent", "KEYCODE_HOME"}
 
-	logs.Debugf("[Home] run cmd: %s %s", adbPath, strings.Join(args, " "))
-	_, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	logs.Debugf("[Home] run shell: %s", strings.Join(args, " "))
+	_, err := r.Shell(ctx, deviceID, args...)
 	time.Sleep(time.Second * 1)
 	return err
 }
@@ -244,21 +247,27 @@ func (r *ADBDevice) LaunchApp(ctx context.Context, appName, deviceID string) (bo
 	if _, ok := constants.APP_PACKAGES_ANDROID[appName]; !ok {
 		return false, fmt.Errorf("app name %s not found in APP_PACKAGES", appName)
 	}
-	adbPrefix := r.GetADBPrefix(deviceID)
 	packageName := constants.APP_PACKAGES_ANDROID[appName]
+	if userID, ok := r.targetUser(deviceID); ok {
+		if err := r.launchAsUser(ctx, deviceID, packageName, userID); err != nil {
+			logs.Errorf("failed to launch app, err: %v", err)
+			return false, err
+		}
+		time.Sleep(time.Second * 1)
+		return true, nil
+	}
 
-	args := append(adbPrefix,
-		"shell",
+	args := []string{
 		"monkey",
 		"-p",
 		packageName,
 		"-c", "android.intent.category.LAUNCHER",
 		"1",
-	)
+	}
 
-	logs.Debugf("[LaunchApp] run cmd: %s %s", adbPath, strings.Join(args, " "))
+	logs.Debugf("[LaunchApp] run shell: %s", strings.Join(args, " "))
 
-	_, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	_, err := r.Shell(ctx, deviceID, args...)
 	if err != nil {
 		logs.Errorf("failed to launch app, err: %v", err)
 		return false, err
@@ -267,57 +276,67 @@ func (r *ADBDevice) LaunchApp(ctx context.Context, appName, deviceID string) (bo
 	return true, nil
 }
 
+// TypeText types text with the ADB keyboard, or with key events on devices
+// without it, which only type ASCII.
 func (r *ADBDevice) TypeText(ctx context.Context, text, deviceID string) error {
-	adbPrefix := r.GetADBPrefix(deviceID)
-
+	if !r.hasKeyboard(deviceID) {
+		return r.typeKeys(ctx, text, deviceID)
+	}
 	encoded := base64.StdEncoding.EncodeToString([]byte(text))
 
-	args := append(adbPrefix,
-		"shell", "am", "broadcast",
+	args := []string{
+		"am", "broadcast",
 		"-a", "ADB_INPUT_B64",
 		"--es", "msg", encoded,
-	)
-	logs.Debugf("[TypeText] run cmd: %s %s", adbPath, strings.Join(args, " "))
+	}
+	logs.Debugf("[TypeText] run shell: %s", strings.Join(args, " "))
 
-	_, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	_, err := r.Shell(ctx, deviceID, args...)
 	return err
 }
 
 func (r *ADBDevice) ClearText(ctx context.Context, deviceID string) error {
-	adbPrefix := r.GetADBPrefix(deviceID)
-
-	args := append(adbPrefix, "shell", "am", "broadcast", "-a", "ADB_CLEAR_TEXT")
-	logs.Debugf("[ClearText] run cmd: %s %s", adbPath, strings.Join(args, " "))
+	args := []string{"am", "broadcast", "-a", "ADB_CLEAR_TEXT"}
+	if !r.hasKeyboard(deviceID) {
+		args = clearKeys()
+	}
+	logs.Debugf("[ClearText] run shell: %s", strings.Join(args, " "))
 
-	_, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	_, err := r.Shell(ctx, deviceID, args...)
 	return err
 }
 
+// DetectAndSetADBKeyboard switches to the ADB keyboard and returns the
+// keyboard to restore. A device without it gets KeyboardAPK installed when
+// set, or types with key events.
 func (r *ADBDevice) DetectAndSetADBKeyboard(ctx context.Context, deviceID string) (string, error) {
-	adbPrefix := r.GetADBPrefix(deviceID)
-
 	// 获取当前输入法
-	getArgs := append(adbPrefix, "shell", "settings", "get", "secure", "default_input_method")
-	logs.Debugf("[DetectAndSetADBKeyboard] run cmd1: %s %s", adbPath, strings.Join(getArgs, " "))
+	getArgs := []string{"settings", "get", "secure", "default_input_method"}
+	logs.Debugf("[DetectAndSetADBKeyboard] run shell1: %s", strings.Join(getArgs, " "))
 
-	out, err := exec.CommandContext(ctx, getArgs[0], getArgs[1:]...).CombinedOutput()
+	out, err := r.Shell(ctx, deviceID, getArgs...)
 	if err != nil {
 		return "", err
 	}
 
-	currentIME := strings.TrimSpace(string(out))
+	currentIME := strings.TrimSpace(out)
 
 	// 如未启用 ADB Keyboard，则切换
-	if !strings.Contains(currentIME, "com.android.adbkeyboard/.AdbIME") {
+	if !strings.Contains(currentIME, adbKeyboardIME) {
+		if err := r.ensureKeyboard(ctx, deviceID); err != nil {
+			r.setKeyboard(deviceID, false)
+			return "", err
+		}
 
-		setArgs := append(adbPrefix, "shell", "ime", "set", "com.android.adbkeyboard/.AdbIME")
-		logs.Debugf("[DetectAndSetADBKeyboard] run cmd2: %s %s", adbPath, strings.Join(setArgs, " "))
+		setArgs := []string{"ime", "set", adbKeyboardIME}
+		logs.Debugf("[DetectAndSetADBKeyboard] run shell2: %s", strings.Join(setArgs, " "))
 
-		_, err := exec.CommandContext(ctx, setArgs[0], setArgs[1:]...).CombinedOutput()
+		_, err := r.Shell(ctx, deviceID, setArgs...)
 		if err != nil {
 			return "", err
 		}
 	}
+	r.setKeyboard(deviceID, true)
 
 	// 预热键盘（与 Python 逻辑一致）
 	_ = r.TypeText(ctx, "", deviceID)
@@ -330,12 +349,11 @@ func (r *ADBDevice) RestoreKeyboard(ctx context.Context, ime, deviceID string) e
 		return fmt.Errorf("IME cannot be empty")
 	}
 
-	adbPrefix := r.GetADBPrefix(deviceID)
-	args := append(adbPrefix, "shell", "ime", "set", ime)
+	args := []string{"ime", "set", ime}
 
-	logs.Debugf("[RestoreKeyboard] run cmd: %s %s", adbPath, strings.Join(args, " "))
+	logs.Debugf("[RestoreKeyboard] run shell: %s", strings.Join(args, " "))
 
-	_, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	_, err := r.Shell(ctx, deviceID, args...)
 	return err
 }
 
@@ -0,0 +1,63 @@
+package android
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	logs "github.com/sirupsen/logrus"
+)
+
+// Forward forwards a free local TCP port to remote on the device, e.g.
+// localabstract:chrome_devtools_remote, and returns the local port.
+func (r *ADBDevice) Forward(ctx context.Context, deviceID, remote string) (int, error) {
+	cmdArgs := append(r.GetADBPrefix(deviceID), "forward", "tcp:0", remote)
+	logs.Debugf("[Forward] run cmd: %s", strings.Join(cmdArgs, " "))
+
+	output, err := exec.CommandContext(ctx, cmdArgs[0], cmdArgs[1:]...).CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("adb forward failed: %w, output: %s", err, output)
+	}
+	port, err := strconv.Atoi(strings.TrimSpace(string(output)))
+	if err != nil {
+		return 0, fmt.Errorf("unexpected adb forward output: %s", output)
+	}
+	return port, nil
+}
+
+func (r *ADBDevice) RemoveForward(ctx context.Context, deviceID string, port int) error {
+	cmdArgs := append(r.GetADBPrefix(deviceID), "forward", "--remove", "tcp:"+strconv.Itoa(port))
+	logs.Debugf("[RemoveForward] run cmd: %s", strings.Join(cmdArgs, " "))
+
+	output, err := exec.CommandContext(ctx, cmdArgs[0], cmdArgs[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("adb forward --remove failed: %w, output: %s", err, output)
+	}
+	return nil
+}
+
+// Reverse makes port on the device reach port on this host, so the device
+// can call a server listening on 127.0.0.1 here.
+func (r *ADBDevice) Reverse(ctx context.Context, deviceID string, port int) error {
+	cmdArgs := append(r.GetADBPrefix(deviceID), "reverse", "tcp:"+strconv.Itoa(port), "tcp:"+strconv.Itoa(port))
+	logs.Debugf("[Reverse] run cmd: %s", strings.Join(cmdArgs, " "))
+
+	output, err := exec.CommandContext(ctx, cmdArgs[0], cmdArgs[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("adb reverse failed: %w, output: %s", err, output)
+	}
+	return nil
+}
+
+func (r *ADBDevice) RemoveReverse(ctx context.Context, deviceID string, port int) error {
+	cmdArgs := append(r.GetADBPrefix(deviceID), "reverse", "--remove", "tcp:"+strconv.Itoa(port))
+	logs.Debugf("[RemoveReverse] run cmd: %s", strings.Join(cmdArgs, " "))
+
+	output, err := exec.CommandContext(ctx, cmdArgs[0], cmdArgs[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("adb reverse --remove failed: %w, output: %s", err, output)
+	}
+	return nil
+}
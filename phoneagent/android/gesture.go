@@ -0,0 +1,138 @@
+package android
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	logs "github.com/sirupsen/logrus"
+)
+
+// Input event codes of the multi-touch protocol, for sendevent.
+const (
+	evSyn           = 0
+	evKey           = 1
+	evAbs           = 3
+	synReport       = 0
+	btnTouch        = 0x14a
+	absMTSlot       = 0x2f
+	absMTTrackingID = 0x39
+	absMTPositionX  = 0x35
+	absMTPositionY  = 0x36
+	// releasedTrackingID is -1 as the unsigned value older sendevents take
+	releasedTrackingID = 4294967295
+)
+
+const (
+	// dragSteps are the moves between two points of a drag path.
+	dragSteps = 3
+	// pinchFrames are the moves of the fingers of a pinch.
+	pinchFrames = 10
+)
+
+// Press touches x, y for duration.
+func (r *ADBDevice) Press(ctx context.Context, x, y int, duration time.Duration, deviceID string) error {
+	args := []string{
+		"input", "swipe",
+		strconv.Itoa(x), strconv.Itoa(y),
+		strconv.Itoa(x), strconv.Itoa(y),
+		strconv.FormatInt(duration.Milliseconds(), 10),
+	}
+	logs.Debugf("[Press] run shell: %s", strings.Join(args, " "))
+	_, err := r.Shell(ctx, deviceID, args...)
+	time.Sleep(time.Second * 1)
+	return err
+}
+
+// Drag moves one finger along path with input motionevent, Android 10 and
+// later.
+func (r *ADBDevice) Drag(ctx context.Context, path [][2]int, hold, duration time.Duration, deviceID string) error {
+	if len(path) < 2 {
+		return fmt.Errorf("a drag needs at least 2 points")
+	}
+	moves := make([][2]int, 0, (len(path)-1)*dragSteps)
+	for i := 1; i < len(path); i++ {
+		from, to := path[i-1], path[i]
+		for step := 1; step <= dragSteps; step++ {
+			moves = append(moves, [2]int{
+				from[0] + (to[0]-from[0])*step/dragSteps,
+				from[1] + (to[1]-from[1])*step/dragSteps,
+			})
+		}
+	}
+	pause := seconds(duration / time.Duration(len(moves)))
+
+	commands := []string{motionEvent("DOWN", path[0])}
+	if hold > 0 {
+		commands = append(commands, "sleep "+seconds(hold))
+	}
+	for _, point := range moves {
+		commands = append(commands, motionEvent("MOVE", point), "sleep "+pause)
+	}
+	commands = append(commands, motionEvent("UP", path[len(path)-1]))
+
+	script := strings.Join(commands, "; ")
+	logs.Debugf("[Drag] run shell: %s", script)
+	output, err := r.Shell(ctx, deviceID, script)
+	if err == nil && strings.Contains(output, "Error") {
+		err = fmt.Errorf("input motionevent failed: %s", strings.TrimSpace(output))
+	}
+	time.Sleep(time.Second * 1)
+	return err
+}
+
+// Pinch moves two fingers apart or together around x, y with sendevent on
+// the touchscreen, which is found with getevent as for Gestures.
+func (r *ADBDevice) Pinch(ctx context.Context, x, y, fromSpan, toSpan int, duration time.Duration, deviceID string) error {
+	screen, err := r.newGestureParser(ctx, deviceID)
+	if err != nil {
+		return err
+	}
+	event := func(typ, code, value int) string {
+		return fmt.Sprintf("sendevent %s %d %d %d", screen.touchscreen, typ, code, value)
+	}
+	fingers := func(span int) [2][2]int {
+		return [2][2]int{screen.raw(x-span/2, y), screen.raw(x+span/2, y)}
+	}
+	position := func(commands []string, points [2][2]int) []string {
+		for slot, point := range points {
+			commands = append(commands,
+				event(evAbs, absMTSlot, slot),
+				event(evAbs, absMTPositionX, point[0]),
+				event(evAbs, absMTPositionY, point[1]))
+		}
+		return append(commands, event(evSyn, synReport, 0))
+	}
+
+	var commands []string
+	for slot := range 2 {
+		commands = append(commands, event(evAbs, absMTSlot, slot), event(evAbs, absMTTrackingID, slot+1))
+	}
+	commands = append(commands, event(evKey, btnTouch, 1))
+	commands = position(commands, fingers(fromSpan))
+	pause := "sleep " + seconds(duration/pinchFrames)
+	for frame := 1; frame <= pinchFrames; frame++ {
+		commands = append(commands, pause)
+		commands = position(commands, fingers(fromSpan+(toSpan-fromSpan)*frame/pinchFrames))
+	}
+	for slot := range 2 {
+		commands = append(commands, event(evAbs, absMTSlot, slot), event(evAbs, absMTTrackingID, releasedTrackingID))
+	}
+	commands = append(commands, event(evKey, btnTouch, 0), event(evSyn, synReport, 0))
+
+	logs.Debugf("[Pinch] %d,%d from %d to %d pixels apart on %s", x, y, fromSpan, toSpan, screen.touchscreen)
+	_, err = r.Shell(ctx, deviceID, strings.Join(commands, "; "))
+	time.Sleep(time.Second * 1)
+	return err
+}
+
+func motionEvent(kind string, point [2]int) string {
+	return fmt.Sprintf("input motionevent %s %d %d", kind, point[0], point[1])
+}
+
+// seconds formats d for sleep.
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
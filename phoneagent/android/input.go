@@ -0,0 +1,207 @@
+package android
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"autoglm-go/phoneagent/definitions"
+	logs "github.com/sirupsen/logrus"
+)
+
+const (
+	// swipeDistance is how far, in pixels, a touch moves to be a swipe.
+	swipeDistance = 40
+	longPressTime = 500 * time.Millisecond
+)
+
+var (
+	inputDeviceRe = regexp.MustCompile(`^add device \d+: (\S+)`)
+	absMaxRe      = regexp.MustCompile(`(ABS_MT_POSITION_[XY])\s*:.*\bmax (\d+)`)
+	screenSizeRe  = regexp.MustCompile(`(Physical|Override) size: (\d+)x(\d+)`)
+	// [   12345.678901] /dev/input/event2: EV_ABS       ABS_MT_POSITION_X    0000021c
+	inputEventRe = regexp.MustCompile(`^\[\s*([\d.]+)\]\s+(\S+):\s+(\S+)\s+(\S+)\s+(\S+)`)
+)
+
+// inputKeys are the keys recorded, by their getevent name.
+var inputKeys = map[string]string{
+	"KEY_BACK":     "back",
+	"KEY_HOME":     "home",
+	"KEY_HOMEPAGE": "home",
+	"KEY_ENTER":    "enter",
+}
+
+// Gestures streams the touches and keys of the user, read from getevent,
+// until ctx ends. Only the first finger of the touchscreen is followed, in
+// the portrait orientation of the screen.
+func (r *ADBDevice) Gestures(ctx context.Context, deviceID string) (<-chan definitions.Gesture, error) {
+	parser, err := r.newGestureParser(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	cmdArgs := append(r.GetADBPrefix(deviceID), "shell", "getevent", "-lt")
+	cmd := exec.CommandContext(ctx, cmdArgs[0], cmdArgs[1:]...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start getevent: %w", err)
+	}
+	logs.Debugf("[Gestures] run cmd: %s", strings.Join(cmdArgs, " "))
+
+	gestures := make(chan definitions.Gesture, 16)
+	go func() {
+		defer close(gestures)
+		defer func() { _ = cmd.Wait() }()
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			gesture, ok := parser.feed(scanner.Text())
+			if !ok {
+				continue
+			}
+			select {
+			case gestures <- gesture:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return gestures, nil
+}
+
+// gestureParser turns getevent lines of the touchscreen into gestures.
+type gestureParser struct {
+	touchscreen    string
+	maxX, maxY     int
+	width, height  int
+	touching       bool
+	x, y           int // raw position of the finger
+	started        float64
+	startX, startY int
+	hasStart       bool
+}
+
+// newGestureParser finds the touchscreen, the input device reporting
+// multi-touch positions, and the size of the screen.
+func (r *ADBDevice) newGestureParser(ctx context.Context, deviceID string) (*gestureParser, error) {
+	output, err := r.Shell(ctx, deviceID, "getevent", "-lp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list input devices: %w", err)
+	}
+	p := &gestureParser{}
+	device := ""
+	for _, line := range strings.Split(output, "\n") {
+		if m := inputDeviceRe.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			device = m[1]
+			continue
+		}
+		m := absMaxRe.FindStringSubmatch(line)
+		if m == nil || (p.touchscreen != "" && p.touchscreen != device) {
+			continue
+		}
+		p.touchscreen = device
+		value, _ := strconv.Atoi(m[2])
+		if m[1] == "ABS_MT_POSITION_X" {
+			p.maxX = value
+		} else {
+			p.maxY = value
+		}
+	}
+	if p.touchscreen == "" || p.maxX == 0 || p.maxY == 0 {
+		return nil, fmt.Errorf("no touchscreen found by getevent")
+	}
+
+	output, err = r.Shell(ctx, deviceID, "wm", "size")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the screen size: %w", err)
+	}
+	// the override size, listed last, wins
+	for _, m := range screenSizeRe.FindAllStringSubmatch(output, -1) {
+		p.width, _ = strconv.Atoi(m[2])
+		p.height, _ = strconv.Atoi(m[3])
+	}
+	if p.width == 0 || p.height == 0 {
+		return nil, fmt.Errorf("unknown screen size: %s", strings.TrimSpace(output))
+	}
+	logs.Debugf("[Gestures] touchscreen %s, %dx%d raw, screen %dx%d", p.touchscreen, p.maxX, p.maxY, p.width, p.height)
+	return p, nil
+}
+
+// feed reads a line of getevent -lt, and returns the gesture it ends.
+func (p *gestureParser) feed(line string) (definitions.Gesture, bool) {
+	m := inputEventRe.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		return definitions.Gesture{}, false
+	}
+	at, _ := strconv.ParseFloat(m[1], 64)
+	device, typ, code, value := m[2], m[3], m[4], m[5]
+
+	if key, ok := inputKeys[code]; ok && typ == "EV_KEY" {
+		return definitions.Gesture{Kind: definitions.GestureKey, Key: key, At: time.Now()}, value == "UP"
+	}
+	if device != p.touchscreen {
+		return definitions.Gesture{}, false
+	}
+
+	switch {
+	case (code == "BTN_TOUCH" && value == "DOWN") || (code == "ABS_MT_TRACKING_ID" && value != "ffffffff"):
+		// the first finger, later ones are ignored
+		if !p.touching {
+			p.touching, p.hasStart, p.started = true, false, at
+		}
+	case code == "BTN_TOUCH" && value == "UP", code == "ABS_MT_TRACKING_ID" && value == "ffffffff":
+		if !p.touching {
+			return definitions.Gesture{}, false
+		}
+		p.touching = false
+		return p.gesture(at), p.hasStart
+	case code == "ABS_MT_POSITION_X", code == "ABS_MT_POSITION_Y":
+		raw, err := strconv.ParseInt(value, 16, 64)
+		if err != nil {
+			return definitions.Gesture{}, false
+		}
+		if code == "ABS_MT_POSITION_X" {
+			p.x = int(raw)
+		} else {
+			p.y = int(raw)
+		}
+	case code == "SYN_REPORT" && p.touching && !p.hasStart:
+		p.startX, p.startY, p.hasStart = p.x, p.y, true
+	}
+	return definitions.Gesture{}, false
+}
+
+func (p *gestureParser) gesture(at float64) definitions.Gesture {
+	g := definitions.Gesture{
+		Start:    p.pixels(p.startX, p.startY),
+		End:      p.pixels(p.x, p.y),
+		Duration: time.Duration((at - p.started) * float64(time.Second)),
+		At:       time.Now(),
+	}
+	switch {
+	case math.Hypot(float64(g.End[0]-g.Start[0]), float64(g.End[1]-g.Start[1])) >= swipeDistance:
+		g.Kind = definitions.GestureSwipe
+	case g.Duration >= longPressTime:
+		g.Kind = definitions.GestureLongPress
+	default:
+		g.Kind = definitions.GestureTap
+	}
+	return g
+}
+
+func (p *gestureParser) pixels(x, y int) [2]int {
+	return [2]int{x * p.width / (p.maxX + 1), y * p.height / (p.maxY + 1)}
+}
+
+// raw converts pixels to a position of the touchscreen, the reverse of pixels.
+func (p *gestureParser) raw(x, y int) [2]int {
+	x, y = min(max(x, 0), p.width-1), min(max(y, 0), p.height-1)
+	return [2]int{x * (p.maxX + 1) / p.width, y * (p.maxY + 1) / p.height}
+}
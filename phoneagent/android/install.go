@@ -0,0 +1,198 @@
+package android
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	logs "github.com/sirupsen/logrus"
+)
+
+// obbRoot is where apps look for their expansion files.
+const obbRoot = "/sdcard/Android/obb"
+
+// InstallOptions are the flags of an app install. Existing installs are
+// always replaced.
+type InstallOptions struct {
+	Downgrade        bool // allow a lower version code than the installed one
+	GrantPermissions bool // grant all runtime permissions
+	AllowTest        bool // allow test-only packages
+}
+
+// Install installs one app from a single .apk, from the split .apk files of
+// one app, or from a bundle (.xapk, .apks, .apkm) with the splits and OBB
+// files inside. OBB files, given or bundled, are pushed to the OBB folder of
+// the package after the install.
+func (r *ADBDevice) Install(ctx context.Context, deviceID string, paths []string, opts InstallOptions) error {
+	var apks []string
+	var obbs []obbFile
+	for _, p := range paths {
+		switch strings.ToLower(filepath.Ext(p)) {
+		case ".apk":
+			apks = append(apks, p)
+		case ".obb":
+			obbs = append(obbs, obbFile{local: p, name: filepath.Base(p)})
+		case ".xapk", ".apks", ".apkm":
+			dir, err := os.MkdirTemp("", "autoglm-bundle-")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(dir)
+			bundled, bundledObbs, err := extractBundle(p, dir)
+			if err != nil {
+				return fmt.Errorf("failed to read bundle %s: %w", p, err)
+			}
+			if len(bundled) == 0 {
+				return fmt.Errorf("bundle %s has no APK inside, encrypted bundles are not supported", p)
+			}
+			apks = append(apks, bundled...)
+			obbs = append(obbs, bundledObbs...)
+		default:
+			return fmt.Errorf("cannot install %s, want .apk, .obb, .xapk, .apks or .apkm", p)
+		}
+	}
+	if len(apks) == 0 {
+		return fmt.Errorf("nothing to install")
+	}
+
+	cmdArgs := r.GetADBPrefix(deviceID)
+	if len(apks) == 1 {
+		cmdArgs = append(cmdArgs, "install")
+	} else {
+		// splits must be installed in one session
+		cmdArgs = append(cmdArgs, "install-multiple")
+	}
+	cmdArgs = append(cmdArgs, "-r")
+	if userID, ok := r.targetUser(deviceID); ok {
+		cmdArgs = append(cmdArgs, "--user", strconv.Itoa(userID))
+	}
+	if opts.Downgrade {
+		cmdArgs = append(cmdArgs, "-d")
+	}
+	if opts.GrantPermissions {
+		cmdArgs = append(cmdArgs, "-g")
+	}
+	if opts.AllowTest {
+		cmdArgs = append(cmdArgs, "-t")
+	}
+	cmdArgs = append(cmdArgs, apks...)
+	logs.Debugf("[Install] run cmd: %s", strings.Join(cmdArgs, " "))
+
+	output, err := exec.CommandContext(ctx, cmdArgs[0], cmdArgs[1:]...).CombinedOutput()
+	// older adb versions exit with 0 on failures
+	if err != nil || !strings.Contains(string(output), "Success") {
+		return fmt.Errorf("adb install failed: %v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	for _, obb := range obbs {
+		pkg := obb.pkg
+		if pkg == "" {
+			pkg = obbPackage(obb.name)
+		}
+		if pkg == "" {
+			return fmt.Errorf("cannot tell the package of %s, want main.<version>.<package>.obb", obb.name)
+		}
+		dir := r.userPath(deviceID, path.Join(obbRoot, pkg))
+		if _, err := r.Shell(ctx, deviceID, "mkdir", "-p", dir); err != nil {
+			return err
+		}
+		if err := r.Push(ctx, deviceID, obb.local, path.Join(dir, obb.name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type obbFile struct {
+	local string
+	name  string
+	pkg   string // empty when it has to be read from the name
+}
+
+// obbPackage reads the package from an OBB file name, as in
+// main.42.com.example.game.obb.
+func obbPackage(name string) string {
+	parts := strings.SplitN(strings.TrimSuffix(name, ".obb"), ".", 3)
+	if len(parts) < 3 || (parts[0] != "main" && parts[0] != "patch") {
+		return ""
+	}
+	return parts[2]
+}
+
+// extractBundle unpacks the APKs and OBB files of a bundle into dir.
+func extractBundle(bundle, dir string) ([]string, []obbFile, error) {
+	zr, err := zip.OpenReader(bundle)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer zr.Close()
+
+	var manifest struct {
+		PackageName string `json:"package_name"`
+	}
+	var apks []string
+	var obbs []obbFile
+	for i, f := range zr.File {
+		name := path.Base(f.Name)
+		switch {
+		case strings.HasPrefix(f.Name, "standalones/"):
+			// full APKs of bundletool for devices without split support
+		case f.Name == "manifest.json":
+			rc, err := f.Open()
+			if err != nil {
+				return nil, nil, err
+			}
+			_ = json.NewDecoder(rc).Decode(&manifest)
+			rc.Close()
+		case strings.HasSuffix(strings.ToLower(name), ".apk"):
+			// the index keeps splits with the same name in different folders apart
+			local := filepath.Join(dir, fmt.Sprintf("%03d-%s", i, name))
+			if err := extractFile(f, local); err != nil {
+				return nil, nil, err
+			}
+			apks = append(apks, local)
+		case strings.HasSuffix(strings.ToLower(name), ".obb"):
+			local := filepath.Join(dir, name)
+			if err := extractFile(f, local); err != nil {
+				return nil, nil, err
+			}
+			// Android/obb/<package>/main.<version>.<package>.obb
+			obb := obbFile{local: local, name: name}
+			if parts := strings.Split(f.Name, "/"); len(parts) >= 3 && parts[len(parts)-3] == "obb" {
+				obb.pkg = parts[len(parts)-2]
+			}
+			obbs = append(obbs, obb)
+		}
+	}
+	for i := range obbs {
+		if obbs[i].pkg == "" {
+			obbs[i].pkg = manifest.PackageName
+		}
+	}
+	return apks, obbs, nil
+}
+
+func extractFile(f *zip.File, local string) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	out, err := os.Create(local)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, rc); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
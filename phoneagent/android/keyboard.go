@@ -0,0 +1,100 @@
+package android
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	logs "github.com/sirupsen/logrus"
+)
+
+const (
+	adbKeyboardPackage = "com.android.adbkeyboard"
+	adbKeyboardIME     = adbKeyboardPackage + "/.AdbIME"
+	// clearDeletes are the deletes of ClearText without the ADB keyboard,
+	// backward from the end of the field.
+	clearDeletes = 100
+)
+
+// ErrNoADBKeyboard is returned by DetectAndSetADBKeyboard for a device
+// without the ADB keyboard and no KeyboardAPK to install.
+var ErrNoADBKeyboard = errors.New("ADB Keyboard is not installed, typing ASCII only")
+
+// ensureKeyboard installs the ADB keyboard from KeyboardAPK when it is
+// missing and enables it.
+func (r *ADBDevice) ensureKeyboard(ctx context.Context, deviceID string) error {
+	output, err := r.Shell(ctx, deviceID, "pm", "path", adbKeyboardPackage)
+	if err != nil || !strings.Contains(output, "package:") {
+		if r.KeyboardAPK == "" {
+			return ErrNoADBKeyboard
+		}
+		logs.Infof("⌨️ installing ADB Keyboard on %s from %s", deviceID, r.KeyboardAPK)
+		if err := r.Install(ctx, deviceID, []string{r.KeyboardAPK}, InstallOptions{}); err != nil {
+			return fmt.Errorf("failed to install ADB Keyboard: %w", err)
+		}
+	}
+	if _, err := r.Shell(ctx, deviceID, "ime", "enable", adbKeyboardIME); err != nil {
+		return fmt.Errorf("failed to enable ADB Keyboard: %w", err)
+	}
+	return nil
+}
+
+func (r *ADBDevice) setKeyboard(deviceID string, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.keyboard == nil {
+		r.keyboard = map[string]bool{}
+	}
+	if was, known := r.keyboard[deviceID]; !ok && (was || !known) {
+		logs.Warnf("⌨️ ADB Keyboard unavailable on %s, typing ASCII with key events", deviceID)
+	}
+	r.keyboard[deviceID] = ok
+}
+
+// hasKeyboard reports whether the ADB keyboard types on deviceID, yes until
+// DetectAndSetADBKeyboard found otherwise.
+func (r *ADBDevice) hasKeyboard(deviceID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ok, known := r.keyboard[deviceID]
+	return ok || !known
+}
+
+// typeKeys types ASCII text with input text, one key event per character.
+func (r *ADBDevice) typeKeys(ctx context.Context, text, deviceID string) error {
+	for _, c := range text {
+		if c > unicode.MaxASCII {
+			return fmt.Errorf("cannot type %q without ADB Keyboard, only ASCII", text)
+		}
+	}
+	// lines are typed apart, with an enter between them
+	for i, line := range strings.Split(text, "\n") {
+		if i > 0 {
+			if _, err := r.Shell(ctx, deviceID, "input", "keyevent", "KEYCODE_ENTER"); err != nil {
+				return err
+			}
+		}
+		if line == "" {
+			continue
+		}
+		// input text reads %s as a space
+		args := []string{"input", "text", shellQuote(strings.ReplaceAll(line, " ", "%s"))}
+		logs.Debugf("[TypeText] run shell: %s", strings.Join(args, " "))
+		if _, err := r.Shell(ctx, deviceID, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clearKeys deletes the text of the focused field with key events, from its
+// end.
+func clearKeys() []string {
+	args := []string{"input", "keyevent", "KEYCODE_MOVE_END"}
+	for range clearDeletes {
+		args = append(args, "KEYCODE_DEL")
+	}
+	return args
+}
@@ -0,0 +1,148 @@
+package android
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"autoglm-go/phoneagent/definitions"
+	logs "github.com/sirupsen/logrus"
+)
+
+// keyguardMarkers are the lines of dumpsys window and dumpsys activity that
+// show the lock screen, they differ between Android versions.
+var keyguardMarkers = []string{
+	"mShowingLockscreen=true",
+	"mDreamingLockscreen=true",
+	"isStatusBarKeyguard=true",
+	"mKeyguardShowing=true",
+	"KeyguardShowing=true",
+}
+
+var wmSizeRe = regexp.MustCompile(`(\d+)x(\d+)`)
+
+// ScreenState reads whether the screen is on and whether the lock screen
+// shows.
+func (r *ADBDevice) ScreenState(ctx context.Context, deviceID string) (definitions.ScreenState, error) {
+	var state definitions.ScreenState
+	power, err := r.Shell(ctx, deviceID, "dumpsys", "power")
+	if err != nil {
+		return state, fmt.Errorf("failed to read the power state: %w", err)
+	}
+	state.Awake = strings.Contains(power, "mWakefulness=Awake") ||
+		strings.Contains(power, "Display Power: state=ON")
+
+	for _, service := range []string{"window", "activity activities"} {
+		output, err := r.Shell(ctx, deviceID, "dumpsys", service)
+		if err != nil {
+			return state, fmt.Errorf("failed to read the lock screen state: %w", err)
+		}
+		for _, marker := range keyguardMarkers {
+			if strings.Contains(output, marker) {
+				state.Locked = true
+				return state, nil
+			}
+		}
+	}
+	return state, nil
+}
+
+// WakeScreen turns the screen on, it does nothing when it is on already.
+func (r *ADBDevice) WakeScreen(ctx context.Context, deviceID string) error {
+	_, err := r.Shell(ctx, deviceID, "input", "keyevent", "KEYCODE_WAKEUP")
+	time.Sleep(500 * time.Millisecond)
+	return err
+}
+
+// Unlock dismisses the lock screen and enters the credential when the lock is
+// secure. A zero credential only dismisses a swipe lock.
+func (r *ADBDevice) Unlock(ctx context.Context, deviceID string, credential definitions.UnlockCredential) error {
+	width, height, err := r.screenSize(ctx, deviceID)
+	if err != nil {
+		return err
+	}
+	// brings up the bouncer of a secure lock, or unlocks a swipe lock
+	if _, err := r.Shell(ctx, deviceID, "wm", "dismiss-keyguard"); err != nil {
+		logs.Debugf("[Unlock] dismiss-keyguard failed, swiping up, err: %v", err)
+		if err := r.Swipe(ctx, width/2, height*4/5, width/2, height/5, deviceID); err != nil {
+			return err
+		}
+	}
+	time.Sleep(time.Second)
+
+	switch credential.Kind {
+	case "":
+		return nil
+	case definitions.UnlockPIN, definitions.UnlockPassword:
+		if _, err := r.shellSecret(ctx, deviceID, "input text <secret>", "input", "text", shellQuote(credential.Secret)); err != nil {
+			return err
+		}
+		_, err = r.Shell(ctx, deviceID, "input", "keyevent", "KEYCODE_ENTER")
+	case definitions.UnlockPattern:
+		err = r.drawPattern(ctx, deviceID, credential.Secret, width, height)
+	default:
+		return fmt.Errorf("unknown unlock kind %q", credential.Kind)
+	}
+	time.Sleep(time.Second)
+	return err
+}
+
+// drawPattern draws the dots of a pattern in one stroke. The grid is assumed
+// at the usual place of the pattern bouncer in portrait, from 20% to 80% of
+// the width and 55% to 85% of the height.
+func (r *ADBDevice) drawPattern(ctx context.Context, deviceID, pattern string, width, height int) error {
+	var points [][2]int
+	for _, dot := range strings.Split(pattern, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(dot))
+		if err != nil || n < 1 || n > 9 {
+			return fmt.Errorf("invalid pattern dot %q, want 1 to 9", dot)
+		}
+		col, row := (n-1)%3, (n-1)/3
+		points = append(points, [2]int{
+			width * (20 + col*30) / 100,
+			height * (55 + row*15) / 100,
+		})
+	}
+	if len(points) < 4 {
+		return fmt.Errorf("a pattern has at least 4 dots, got %d", len(points))
+	}
+
+	event := func(action string, p [2]int) error {
+		_, err := r.shellSecret(ctx, deviceID, "input motionevent "+action+" <secret>", "input", "motionevent", action, strconv.Itoa(p[0]), strconv.Itoa(p[1]))
+		return err
+	}
+	if err := event("DOWN", points[0]); err != nil {
+		return err
+	}
+	for _, p := range points[1:] {
+		if err := event("MOVE", p); err != nil {
+			return err
+		}
+	}
+	return event("UP", points[len(points)-1])
+}
+
+// screenSize returns the size wm reports, the override when there is one.
+func (r *ADBDevice) screenSize(ctx context.Context, deviceID string) (int, int, error) {
+	output, err := r.Shell(ctx, deviceID, "wm", "size")
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read the screen size: %w", err)
+	}
+	matches := wmSizeRe.FindAllStringSubmatch(output, -1)
+	if len(matches) == 0 {
+		return 0, 0, fmt.Errorf("unexpected wm size output: %s", strings.TrimSpace(output))
+	}
+	last := matches[len(matches)-1]
+	width, _ := strconv.Atoi(last[1])
+	height, _ := strconv.Atoi(last[2])
+	return width, height, nil
+}
+
+// shellQuote quotes s for the device shell, which gets the command as one
+// line.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,125 @@
+package android
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"autoglm-go/phoneagent/definitions"
+	logs "github.com/sirupsen/logrus"
+)
+
+var (
+	notificationRecordRe = regexp.MustCompile(`(?m)^\s*NotificationRecord\(\S+ pkg=(\S+) .*?\bkey=(\S+): Notification\(.*?\bflags=0x([0-9a-fA-F]+)`)
+	notificationTitleRe  = regexp.MustCompile(`(?m)^\s*android\.title=\w+ \((.*)\)\s*$`)
+	notificationTextRe   = regexp.MustCompile(`(?m)^\s*android\.(?:text|bigText)=\w+ \((.*)\)\s*$`)
+)
+
+// Notification flags that mark notifications of services and summaries of
+// groups rather than something posted for the user.
+const (
+	flagOngoingEvent      = 0x2
+	flagForegroundService = 0x40
+	flagGroupSummary      = 0x200
+)
+
+// ListNotifications returns the notifications of the shade in the order it
+// shows them, read from dumpsys notification. Ongoing ones, such as those of
+// music players and downloads, are left out.
+func (r *ADBDevice) ListNotifications(ctx context.Context, deviceID string) ([]definitions.Notification, error) {
+	output, err := r.Shell(ctx, deviceID, "dumpsys", "notification", "--noredact")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list notifications: %w", err)
+	}
+	return parseNotifications(output), nil
+}
+
+func parseNotifications(output string) []definitions.Notification {
+	var notifications []definitions.Notification
+	records := notificationRecordRe.FindAllStringSubmatchIndex(output, -1)
+	for i, m := range records {
+		end := len(output)
+		if i+1 < len(records) {
+			end = records[i+1][0]
+		}
+		block := output[m[0]:end]
+
+		pkg := output[m[2]:m[3]]
+		flags, _ := strconv.ParseInt(output[m[6]:m[7]], 16, 64)
+		if overlayExcluded[pkg] || flags&(flagOngoingEvent|flagForegroundService|flagGroupSummary) != 0 {
+			continue
+		}
+		n := definitions.Notification{Key: output[m[4]:m[5]], Package: pkg}
+		if t := notificationTitleRe.FindStringSubmatch(block); t != nil {
+			n.Title = strings.TrimSpace(t[1])
+		}
+		if t := notificationTextRe.FindStringSubmatch(block); t != nil {
+			n.Text = strings.TrimSpace(t[1])
+		}
+		if n.Title == "" && n.Text == "" {
+			continue
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications
+}
+
+// OpenNotification taps the notification in the expanded shade.
+func (r *ADBDevice) OpenNotification(ctx context.Context, deviceID string, notification definitions.Notification) error {
+	element, err := r.findNotification(ctx, deviceID, notification)
+	if err != nil {
+		return err
+	}
+	x, y := element.Center()
+	return r.Tap(ctx, x, y, deviceID)
+}
+
+// DismissNotification swipes the notification out of the expanded shade.
+func (r *ADBDevice) DismissNotification(ctx context.Context, deviceID string, notification definitions.Notification) error {
+	element, err := r.findNotification(ctx, deviceID, notification)
+	if err != nil {
+		return err
+	}
+	width, _, err := r.screenSize(ctx, deviceID)
+	if err != nil {
+		return err
+	}
+	x, y := element.Center()
+	err = r.Swipe(ctx, x, y, width-1, y, deviceID)
+	r.collapseShade(ctx, deviceID)
+	return err
+}
+
+// findNotification expands the shade and finds the title or text of the
+// notification in it, the shade is collapsed again when it is not there.
+func (r *ADBDevice) findNotification(ctx context.Context, deviceID string, notification definitions.Notification) (*definitions.UIElement, error) {
+	logs.Debugf("[Notification] run shell: cmd statusbar expand-notifications")
+	if _, err := r.Shell(ctx, deviceID, "cmd", "statusbar", "expand-notifications"); err != nil {
+		return nil, err
+	}
+	time.Sleep(time.Second)
+	elements, err := r.DumpUI(ctx, deviceID)
+	if err != nil {
+		r.collapseShade(ctx, deviceID)
+		return nil, err
+	}
+	for _, want := range []string{notification.Title, notification.Text} {
+		if want == "" {
+			continue
+		}
+		for i := range elements {
+			if elements[i].Text == want || strings.Contains(elements[i].ContentDesc, want) {
+				return &elements[i], nil
+			}
+		}
+	}
+	r.collapseShade(ctx, deviceID)
+	return nil, fmt.Errorf("the notification of %s is not in the shade", notification.Package)
+}
+
+func (r *ADBDevice) collapseShade(ctx context.Context, deviceID string) {
+	_, _ = r.Shell(ctx, deviceID, "cmd", "statusbar", "collapse")
+}
@@ -0,0 +1,122 @@
+package android
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"autoglm-go/phoneagent/definitions"
+	logs "github.com/sirupsen/logrus"
+)
+
+var (
+	windowHeaderRe  = regexp.MustCompile(`(?m)^\s*Window #\d+ Window\{\S+ \S+ ([^}/\s]+)`)
+	windowPackageRe = regexp.MustCompile(`\bpackage=(\S+)`)
+	windowTypeRe    = regexp.MustCompile(`\bty=(\w+)`)
+	windowFrameRe   = regexp.MustCompile(`(?:mFrame=|\bframe=)\[(-?\d+),(-?\d+)\]\[(-?\d+),(-?\d+)\]`)
+)
+
+// overlayTypes are the window types apps draw over other apps with.
+var overlayTypes = map[string]bool{
+	"APPLICATION_OVERLAY": true,
+	"SYSTEM_ALERT":        true,
+	"PHONE":               true,
+	"SYSTEM_OVERLAY":      true,
+	"PRIORITY_PHONE":      true,
+}
+
+// overlayExcluded are the packages whose overlays are part of the system UI.
+var overlayExcluded = map[string]bool{
+	"android":              true,
+	"com.android.systemui": true,
+}
+
+// ListOverlays returns the visible floating and picture-in-picture windows
+// that take taps, read from dumpsys window.
+func (r *ADBDevice) ListOverlays(ctx context.Context, deviceID string) ([]definitions.Overlay, error) {
+	output, err := r.Shell(ctx, deviceID, "dumpsys", "window", "windows")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list windows: %w", err)
+	}
+	return parseOverlays(output), nil
+}
+
+func parseOverlays(output string) []definitions.Overlay {
+	var overlays []definitions.Overlay
+	headers := windowHeaderRe.FindAllStringSubmatchIndex(output, -1)
+	for i, h := range headers {
+		end := len(output)
+		if i+1 < len(headers) {
+			end = headers[i+1][0]
+		}
+		block := output[h[0]:end]
+
+		var kind definitions.OverlayKind
+		ty := windowTypeRe.FindStringSubmatch(block)
+		switch {
+		case strings.Contains(block, "mWindowingMode=pinned") || strings.Contains(block, "windowingMode=pinned"):
+			kind = definitions.OverlayPiP
+		case ty != nil && overlayTypes[ty[1]]:
+			kind = definitions.OverlayFloating
+		default:
+			continue
+		}
+		// taps pass through screen filters and similar non-touchable windows
+		if strings.Contains(block, "NOT_TOUCHABLE") {
+			continue
+		}
+		if strings.Contains(block, "isVisible=false") || strings.Contains(block, "isOnScreen=false") ||
+			strings.Contains(block, "mHasSurface=false") {
+			continue
+		}
+
+		pkg := output[h[2]:h[3]]
+		if m := windowPackageRe.FindStringSubmatch(block); m != nil {
+			pkg = m[1]
+		}
+		if overlayExcluded[pkg] {
+			continue
+		}
+		overlay := definitions.Overlay{Kind: kind, Package: pkg}
+		m := windowFrameRe.FindStringSubmatch(block)
+		if m == nil {
+			continue
+		}
+		for j := 0; j < 4; j++ {
+			overlay.Bounds[j], _ = strconv.Atoi(m[j+1])
+		}
+		if overlay.Bounds[2] <= overlay.Bounds[0] || overlay.Bounds[3] <= overlay.Bounds[1] {
+			continue
+		}
+		overlays = append(overlays, overlay)
+	}
+	return overlays
+}
+
+// DismissOverlay drags the window to the bottom center of the screen, the
+// dismiss target of chat heads and picture-in-picture on most devices.
+func (r *ADBDevice) DismissOverlay(ctx context.Context, deviceID string, overlay definitions.Overlay) error {
+	width, height, err := r.screenSize(ctx, deviceID)
+	if err != nil {
+		return err
+	}
+	x, y := (overlay.Bounds[0]+overlay.Bounds[2])/2, (overlay.Bounds[1]+overlay.Bounds[3])/2
+	// a held press first, a quick swipe only moves the window
+	args := []string{
+		"input", "draganddrop",
+		strconv.Itoa(x), strconv.Itoa(y),
+		strconv.Itoa(width / 2), strconv.Itoa(height * 92 / 100),
+		"1500",
+	}
+	logs.Debugf("[DismissOverlay] run shell: %s", strings.Join(args, " "))
+	output, err := r.Shell(ctx, deviceID, args...)
+	if err != nil || strings.Contains(output, "Error") || strings.Contains(output, "Unknown command") {
+		// input draganddrop needs Android 8
+		err = r.Swipe(ctx, x, y, width/2, height*92/100, deviceID)
+	}
+	time.Sleep(time.Second)
+	return err
+}
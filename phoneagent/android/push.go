@@ -0,0 +1,23 @@
+package android
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	logs "github.com/sirupsen/logrus"
+)
+
+// Push copies a local file to remote on the device. Paths under /sdcard are
+// those of the target user, see SetUser.
+func (r *ADBDevice) Push(ctx context.Context, deviceID, local, remote string) error {
+	cmdArgs := append(r.GetADBPrefix(deviceID), "push", local, r.userPath(deviceID, remote))
+	logs.Debugf("[Push] run cmd: %s", strings.Join(cmdArgs, " "))
+
+	output, err := exec.CommandContext(ctx, cmdArgs[0], cmdArgs[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("adb push failed: %w, output: %s", err, output)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package android
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"autoglm-go/phoneagent/definitions"
+	logs "github.com/sirupsen/logrus"
+)
+
+const (
+	// screenRecordLimit is the longest screenrecord records, longer sessions
+	// are recorded in chunks of it.
+	screenRecordLimit = 180
+	// screenRecordStop bounds how long screenrecord takes to finish its file
+	// once interrupted.
+	screenRecordStop = 10 * time.Second
+)
+
+// screenRecording is a screenrecord running on the device, restarted every
+// screenRecordLimit seconds.
+type screenRecording struct {
+	device   *ADBDevice
+	deviceID string
+	name     string // of the chunks on the device, also matched to stop them
+	cancel   context.CancelFunc
+	done     chan struct{}
+
+	mu       sync.Mutex
+	stopping bool
+	chunks   []string // device paths
+	err      error
+}
+
+// RecordScreen starts recording the screen with screenrecord until Stop.
+// The chunks run outside the persistent shell, which stays free for the
+// actions.
+func (r *ADBDevice) RecordScreen(ctx context.Context, deviceID string) (definitions.ScreenRecording, error) {
+	output, err := r.Shell(ctx, deviceID, "command", "-v", "screenrecord")
+	if err != nil || !strings.Contains(output, "screenrecord") {
+		return nil, fmt.Errorf("screenrecord is not available on the device")
+	}
+	recordCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	s := &screenRecording{
+		device:   r,
+		deviceID: deviceID,
+		name:     fmt.Sprintf("autoglm-screen-%d", time.Now().UnixNano()),
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	go s.run(recordCtx)
+	return s, nil
+}
+
+func (s *screenRecording) run(ctx context.Context) {
+	defer close(s.done)
+	for i := 1; ; i++ {
+		s.mu.Lock()
+		if s.stopping {
+			s.mu.Unlock()
+			return
+		}
+		remote := fmt.Sprintf("/data/local/tmp/%s-%03d.mp4", s.name, i)
+		s.chunks = append(s.chunks, remote)
+		s.mu.Unlock()
+
+		started := time.Now()
+		output, err := s.device.execShell(ctx, s.deviceID, "screenrecord", "--time-limit", fmt.Sprint(screenRecordLimit), remote)
+		// a chunk that ends at once will not record the next time either
+		if err != nil || time.Since(started) < time.Second {
+			s.mu.Lock()
+			if !s.stopping {
+				s.err = fmt.Errorf("screenrecord failed: %v, output: %s", err, strings.TrimSpace(output))
+				logs.Warnf("🎥 %v", s.err)
+				s.stopping = true
+			}
+			s.mu.Unlock()
+			return
+		}
+	}
+}
+
+// Stop interrupts screenrecord, pulls the chunks into dir and deletes them
+// from the device.
+func (s *screenRecording) Stop(ctx context.Context, dir string) ([]string, error) {
+	s.mu.Lock()
+	s.stopping = true
+	s.mu.Unlock()
+	// SIGINT lets screenrecord finish its file
+	_, _ = s.device.Shell(ctx, s.deviceID, "pkill", "-INT", "-f", s.name)
+	select {
+	case <-s.done:
+	case <-time.After(screenRecordStop):
+		s.cancel()
+		<-s.done
+	}
+	s.cancel()
+
+	s.mu.Lock()
+	chunks, recordErr := s.chunks, s.err
+	s.mu.Unlock()
+	var local []string
+	for _, remote := range chunks {
+		path := filepath.Join(dir, filepath.Base(remote))
+		cmdArgs := append(s.device.GetADBPrefix(s.deviceID), "pull", remote, path)
+		logs.Debugf("[RecordScreen] run cmd: %s", strings.Join(cmdArgs, " "))
+		// a chunk interrupted before it started has no file
+		if output, err := exec.CommandContext(ctx, cmdArgs[0], cmdArgs[1:]...).CombinedOutput(); err != nil {
+			logs.Debugf("[RecordScreen] no chunk %s: %s", remote, strings.TrimSpace(string(output)))
+			continue
+		}
+		local = append(local, path)
+	}
+	_, _ = s.device.Shell(ctx, s.deviceID, "rm", "-f", fmt.Sprintf("/data/local/tmp/%s-*.mp4", s.name))
+	if len(local) == 0 {
+		if recordErr == nil {
+			recordErr = fmt.Errorf("screenrecord wrote nothing")
+		}
+		return nil, recordErr
+	}
+	return local, nil
+}
@@ -0,0 +1,263 @@
+package android
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	logs "github.com/sirupsen/logrus"
+)
+
+const shellMarker = "__AUTOGLM_END__"
+
+var (
+	errShellClosed = errors.New("adb shell session closed")
+	// errShellLost is a session closing once the command was sent, which
+	// may have run on the device then.
+	errShellLost = fmt.Errorf("%w while running the command", errShellClosed)
+)
+
+// shellSession is a long-lived `adb shell` process bound to one device.
+// Commands are written to its stdin and their output is read back up to a
+// marker line, so each command costs a round trip instead of a new adb
+// process plus transport handshake.
+type shellSession struct {
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	seq    int
+	closed bool
+}
+
+func newShellSession(deviceID string) (*shellSession, error) {
+	var cmdArgs []string
+	if len(deviceID) > 0 {
+		cmdArgs = append(cmdArgs, "-s", deviceID)
+	}
+	cmdArgs = append(cmdArgs, "shell")
+
+	cmd := exec.Command(adbPath, cmdArgs...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	logs.Debugf("[ShellSession] started persistent shell: %s %s", adbPath, strings.Join(cmdArgs, " "))
+
+	return &shellSession{
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: bufio.NewReader(stdout),
+	}, nil
+}
+
+type shellOutput struct {
+	output   string
+	exitCode int
+	err      error
+}
+
+// Run executes command in the session and returns its combined output.
+func (r *shellSession) Run(ctx context.Context, command string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return "", errShellClosed
+	}
+
+	r.seq++
+	marker := fmt.Sprintf("%s%d", shellMarker, r.seq)
+
+	// the leading newline guarantees the marker starts on its own line
+	line := fmt.Sprintf("%s 2>&1; printf '\\n%s %%s\\n' \"$?\"\n", command, marker)
+	if _, err := io.WriteString(r.stdin, line); err != nil {
+		r.closeLocked()
+		return "", errShellClosed
+	}
+
+	resultCh := make(chan shellOutput, 1)
+	go func() {
+		resultCh <- r.readUntil(marker)
+	}()
+
+	select {
+	case res := <-resultCh:
+		if res.err != nil {
+			r.closeLocked()
+			return res.output, errShellLost
+		}
+		if res.exitCode != 0 {
+			return res.output, fmt.Errorf("shell command exited with status %d", res.exitCode)
+		}
+		return res.output, nil
+	case <-ctx.Done():
+		// the session is out of sync once a command is abandoned
+		r.closeLocked()
+		return "", ctx.Err()
+	}
+}
+
+func (r *shellSession) readUntil(marker string) shellOutput {
+	var sb strings.Builder
+	for {
+		line, err := r.stdout.ReadString('\n')
+		if strings.HasPrefix(line, marker) {
+			code, _ := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, marker)))
+			// drop the newline printed in front of the marker
+			output := strings.TrimSuffix(sb.String(), "\n")
+			return shellOutput{output: output, exitCode: code}
+		}
+		sb.WriteString(line)
+		if err != nil {
+			return shellOutput{output: sb.String(), err: err}
+		}
+	}
+}
+
+func (r *shellSession) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closeLocked()
+}
+
+func (r *shellSession) closeLocked() {
+	if r.closed {
+		return
+	}
+	r.closed = true
+	_ = r.stdin.Close()
+	if r.cmd.Process != nil {
+		_ = r.cmd.Process.Kill()
+	}
+	go func() {
+		_ = r.cmd.Wait()
+	}()
+}
+
+// Shell runs a shell command on the device through its persistent session,
+// falling back to a one-off `adb shell` when the session is unavailable. A
+// command the session closed under is only run again when read-only.
+func (r *ADBDevice) Shell(ctx context.Context, deviceID string, args ...string) (string, error) {
+	return r.shell(ctx, deviceID, strings.Join(args, " "), args)
+}
+
+// shellSecret runs a command as Shell whose arguments carry a secret, e.g.
+// the PIN typed on the lock screen, logged as shown instead.
+func (r *ADBDevice) shellSecret(ctx context.Context, deviceID, shown string, args ...string) (string, error) {
+	return r.shell(ctx, deviceID, shown, args)
+}
+
+// shell runs args, logged as shown when it falls back to `adb shell`.
+func (r *ADBDevice) shell(ctx context.Context, deviceID, shown string, args []string) (string, error) {
+	command := strings.Join(args, " ")
+
+	for attempt := 0; attempt < 2; attempt++ {
+		session, err := r.getShell(deviceID)
+		if err != nil {
+			logs.Errorf("[Shell] failed to start persistent shell, err: %v", err)
+			break
+		}
+
+		output, err := session.Run(ctx, command)
+		if !errors.Is(err, errShellClosed) {
+			return output, err
+		}
+		r.dropShell(deviceID, session)
+		if errors.Is(err, errShellLost) && !readOnly(args) {
+			// a tap or a text would be input twice
+			return output, err
+		}
+	}
+
+	return r.execShellAs(ctx, deviceID, shown, args...)
+}
+
+// readOnly reports whether running args again changes nothing on the device.
+// Screenshots and UI dumps only overwrite their own file.
+func readOnly(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	switch args[0] {
+	case "dumpsys", "getprop", "getevent", "cat", "ls", "echo", "command", "screencap", "uiautomator":
+		return true
+	case "wm":
+		// wm size and wm density without a value to set
+		return len(args) == 2 && (args[1] == "size" || args[1] == "density")
+	case "pm":
+		return len(args) > 1 && (args[1] == "list" || args[1] == "path")
+	case "settings":
+		return len(args) > 1 && (args[1] == "get" || args[1] == "list")
+	}
+	return false
+}
+
+func (r *ADBDevice) execShell(ctx context.Context, deviceID string, args ...string) (string, error) {
+	return r.execShellAs(ctx, deviceID, strings.Join(args, " "), args...)
+}
+
+// execShellAs runs a one-off `adb shell` of args, logged as shown.
+func (r *ADBDevice) execShellAs(ctx context.Context, deviceID, shown string, args ...string) (string, error) {
+	cmdArgs := append(r.GetADBPrefix(deviceID), "shell")
+	logs.Debugf("[Shell] run cmd: %s %s", strings.Join(cmdArgs, " "), shown)
+	cmdArgs = append(cmdArgs, args...)
+
+	output, err := exec.CommandContext(ctx, cmdArgs[0], cmdArgs[1:]...).CombinedOutput()
+	return string(output), err
+}
+
+func (r *ADBDevice) getShell(deviceID string) (*shellSession, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.shells == nil {
+		r.shells = map[string]*shellSession{}
+	}
+	if session, ok := r.shells[deviceID]; ok {
+		return session, nil
+	}
+
+	session, err := newShellSession(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	r.shells[deviceID] = session
+	return session, nil
+}
+
+func (r *ADBDevice) dropShell(deviceID string, session *shellSession) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.shells[deviceID] == session {
+		delete(r.shells, deviceID)
+	}
+	session.Close()
+}
+
+// Close terminates all persistent shell sessions.
+func (r *ADBDevice) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for deviceID, session := range r.shells {
+		session.Close()
+		delete(r.shells, deviceID)
+	}
+	return nil
+}
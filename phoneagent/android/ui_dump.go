@@ -0,0 +1,101 @@
+package android
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"autoglm-go/phoneagent/definitions"
+	logs "github.com/sirupsen/logrus"
+)
+
+const uiDumpPath = "/sdcard/window_dump.xml"
+
+type uiNode struct {
+	Index       string   `xml:"index,attr"`
+	Text        string   `xml:"text,attr"`
+	ResourceID  string   `xml:"resource-id,attr"`
+	Class       string   `xml:"class,attr"`
+	Package     string   `xml:"package,attr"`
+	ContentDesc string   `xml:"content-desc,attr"`
+	Clickable   string   `xml:"clickable,attr"`
+	Bounds      string   `xml:"bounds,attr"`
+	Nodes       []uiNode `xml:"node"`
+}
+
+type uiHierarchy struct {
+	Nodes []uiNode `xml:"node"`
+}
+
+var boundsRe = regexp.MustCompile(`\[(-?\d+),(-?\d+)\]\[(-?\d+),(-?\d+)\]`)
+
+// DumpUI dumps the current view hierarchy with uiautomator and returns the
+// elements that carry text, a description or are clickable.
+func (r *ADBDevice) DumpUI(ctx context.Context, deviceID string) ([]definitions.UIElement, error) {
+	dumpArgs := []string{"uiautomator", "dump", uiDumpPath}
+	logs.Debugf("[DumpUI] run shell1: %s", strings.Join(dumpArgs, " "))
+
+	output, err := r.Shell(ctx, deviceID, dumpArgs...)
+	if err != nil {
+		logs.Errorf("[DumpUI] uiautomator dump failed, err: %v, output: %s", err, output)
+		return nil, fmt.Errorf("uiautomator dump failed: %w", err)
+	}
+
+	catArgs := []string{"cat", uiDumpPath}
+	logs.Debugf("[DumpUI] run shell2: %s", strings.Join(catArgs, " "))
+
+	output, err = r.Shell(ctx, deviceID, catArgs...)
+	if err != nil {
+		logs.Errorf("[DumpUI] read dump failed, err: %v", err)
+		return nil, fmt.Errorf("failed to read ui dump: %w", err)
+	}
+
+	return parseUIDump(output)
+}
+
+func parseUIDump(output string) ([]definitions.UIElement, error) {
+	start := strings.Index(output, "<?xml")
+	if start < 0 {
+		start = strings.Index(output, "<hierarchy")
+	}
+	if start < 0 {
+		return nil, fmt.Errorf("no ui hierarchy in dump output")
+	}
+
+	var hierarchy uiHierarchy
+	if err := xml.Unmarshal([]byte(output[start:]), &hierarchy); err != nil {
+		return nil, fmt.Errorf("failed to parse ui dump: %w", err)
+	}
+
+	var elements []definitions.UIElement
+	var walk func(nodes []uiNode)
+	walk = func(nodes []uiNode) {
+		for _, node := range nodes {
+			clickable := node.Clickable == "true"
+			if node.Text != "" || node.ContentDesc != "" || clickable {
+				element := definitions.UIElement{
+					Index:       len(elements),
+					Text:        node.Text,
+					ContentDesc: node.ContentDesc,
+					ResourceID:  node.ResourceID,
+					Class:       node.Class,
+					Package:     node.Package,
+					Clickable:   clickable,
+				}
+				if m := boundsRe.FindStringSubmatch(node.Bounds); m != nil {
+					for i := 0; i < 4; i++ {
+						element.Bounds[i], _ = strconv.Atoi(m[i+1])
+					}
+				}
+				elements = append(elements, element)
+			}
+			walk(node.Nodes)
+		}
+	}
+	walk(hierarchy.Nodes)
+
+	return elements, nil
+}
@@ -0,0 +1,145 @@
+package android
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"autoglm-go/phoneagent/definitions"
+	logs "github.com/sirupsen/logrus"
+)
+
+// flagManagedProfile is UserInfo.FLAG_MANAGED_PROFILE, set on work profiles.
+const flagManagedProfile = 0x20
+
+var (
+	// UserInfo{10:Work profile:1030} running
+	userInfoRe = regexp.MustCompile(`UserInfo\{(\d+):([^:}]*):([0-9a-fA-F]+)\}(\s+running)?`)
+	// the user of a window or activity record, as in Window{1a2b u10 com.tencent.mm/...}
+	windowUserRe = regexp.MustCompile(`\su(\d+)\s`)
+)
+
+// ListUsers returns the users and profiles of the device.
+func (r *ADBDevice) ListUsers(ctx context.Context, deviceID string) ([]definitions.UserProfile, error) {
+	output, err := r.Shell(ctx, deviceID, "pm", "list", "users")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w, output: %s", err, strings.TrimSpace(output))
+	}
+	var users []definitions.UserProfile
+	for _, m := range userInfoRe.FindAllStringSubmatch(output, -1) {
+		id, _ := strconv.Atoi(m[1])
+		flags, _ := strconv.ParseInt(m[3], 16, 64)
+		users = append(users, definitions.UserProfile{
+			ID:      id,
+			Name:    m[2],
+			Managed: flags&flagManagedProfile != 0,
+			Running: m[4] != "",
+		})
+	}
+	if len(users) == 0 {
+		return nil, fmt.Errorf("no user in the output of pm list users: %s", strings.TrimSpace(output))
+	}
+	return users, nil
+}
+
+// SetUser makes the launches, installs and pushes of the device target the
+// user userID rather than the current one. A negative userID goes back to
+// the current user.
+func (r *ADBDevice) SetUser(deviceID string, userID int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if userID < 0 {
+		delete(r.users, deviceID)
+		return
+	}
+	if r.users == nil {
+		r.users = map[string]int{}
+	}
+	r.users[deviceID] = userID
+}
+
+func (r *ADBDevice) targetUser(deviceID string) (int, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	userID, ok := r.users[deviceID]
+	return userID, ok
+}
+
+// FocusedUser returns the user of the window focused at the last
+// GetCurrentApp, false when it could not tell.
+func (r *ADBDevice) FocusedUser(deviceID string) (int, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	userID, ok := r.focus[deviceID]
+	return userID, ok
+}
+
+// noteFocus records the user of the focused window from its dumpsys line.
+func (r *ADBDevice) noteFocus(deviceID, line string) {
+	m := windowUserRe.FindStringSubmatch(line)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m == nil {
+		delete(r.focus, deviceID)
+		return
+	}
+	if r.focus == nil {
+		r.focus = map[string]int{}
+	}
+	r.focus[deviceID], _ = strconv.Atoi(m[1])
+}
+
+// userPath moves a path of the shared storage of the current user, /sdcard,
+// to that of the target user.
+func (r *ADBDevice) userPath(deviceID, p string) string {
+	userID, ok := r.targetUser(deviceID)
+	if !ok {
+		return p
+	}
+	if p == "/sdcard" || strings.HasPrefix(p, "/sdcard/") {
+		return fmt.Sprintf("/storage/emulated/%d%s", userID, strings.TrimPrefix(p, "/sdcard"))
+	}
+	return p
+}
+
+// launchAsUser starts the launcher activity of packageName as userID, monkey
+// always starts it as the current user.
+func (r *ADBDevice) launchAsUser(ctx context.Context, deviceID, packageName string, userID int) error {
+	component, err := r.launcherActivity(ctx, deviceID, packageName, userID)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"am", "start", "--user", strconv.Itoa(userID), "-n", component}
+	logs.Debugf("[LaunchApp] run shell: %s", strings.Join(args, " "))
+	output, err := r.Shell(ctx, deviceID, args...)
+	if err != nil {
+		return err
+	}
+	if strings.Contains(output, "Error") {
+		return fmt.Errorf("failed to start %s as user %d: %s", component, userID, strings.TrimSpace(output))
+	}
+	return nil
+}
+
+// launcherActivity returns the launcher activity of packageName as
+// package/activity, for userID or the current user when it is negative.
+func (r *ADBDevice) launcherActivity(ctx context.Context, deviceID, packageName string, userID int) (string, error) {
+	user := "current"
+	if userID >= 0 {
+		user = strconv.Itoa(userID)
+	}
+	output, err := r.Shell(ctx, deviceID, "cmd", "package", "resolve-activity", "--brief", "--user", user,
+		"-a", "android.intent.action.MAIN", "-c", "android.intent.category.LAUNCHER", shellQuote(packageName))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve the launcher activity of %s: %w", packageName, err)
+	}
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	component := strings.TrimSpace(lines[len(lines)-1])
+	if !strings.Contains(component, "/") {
+		return "", fmt.Errorf("%s is not installed for user %s", packageName, user)
+	}
+	return component, nil
+}
@@ -0,0 +1,267 @@
+package android
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	logs "github.com/sirupsen/logrus"
+)
+
+// The mDNS services of the wireless debugging of Android 11+, and of the
+// devices switched to adb tcpip.
+const (
+	ServicePairing = "_adb-tls-pairing._tcp"
+	ServiceConnect = "_adb-tls-connect._tcp"
+	ServiceTCPIP   = "_adb._tcp"
+)
+
+// pairedGUID is the device of the output of a successful `adb pair`, e.g.
+// "Successfully paired to 192.168.1.5:37123 [guid=adb-R58M12ABCDE-x7Yz1Q]".
+var pairedGUID = regexp.MustCompile(`\[guid=([^\]\s]+)\]`)
+
+// MDNSService is a device adb found on the LAN.
+type MDNSService struct {
+	Name    string `json:"name"` // the instance, "adb-<serial>-<random>"
+	Type    string `json:"type"` // ServicePairing, ServiceConnect or ServiceTCPIP
+	Address string `json:"address"`
+}
+
+// Discover lists the devices advertising adb over mDNS, with `adb mdns
+// services`.
+func (r *ADBDevice) Discover(ctx context.Context) ([]MDNSService, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	cmdArgs := []string{"mdns", "services"}
+	logs.Debugf("[Discover] run cmd: %s %s", adbPath, strings.Join(cmdArgs, " "))
+	output, err := exec.CommandContext(ctx, adbPath, cmdArgs...).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("adb mdns services failed: %v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	return parseMDNSServices(string(output)), nil
+}
+
+// parseMDNSServices parses lines such as
+// "adb-R58M12ABCDE-x7Yz1Q	_adb-tls-connect._tcp.	192.168.1.5:40123".
+func parseMDNSServices(output string) []MDNSService {
+	var services []MDNSService
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 || !strings.HasPrefix(fields[1], "_adb") {
+			continue
+		}
+		services = append(services, MDNSService{
+			Name:    fields[0],
+			Type:    strings.TrimSuffix(fields[1], "."),
+			Address: fields[2],
+		})
+	}
+	return services
+}
+
+// Pair pairs with a device showing a pairing code in its wireless debugging
+// settings, and returns the mDNS instance name of the device. Without an
+// address the only device advertising pairing on the LAN is paired.
+func (r *ADBDevice) Pair(ctx context.Context, address, code string) (string, error) {
+	if address == "" {
+		services, err := r.Discover(ctx)
+		if err != nil {
+			return "", err
+		}
+		var pairing []MDNSService
+		for _, s := range services {
+			if s.Type == ServicePairing {
+				pairing = append(pairing, s)
+			}
+		}
+		if len(pairing) != 1 {
+			return "", fmt.Errorf("%d devices are waiting to be paired, an address is required", len(pairing))
+		}
+		address = pairing[0].Address
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	logs.Debugf("[Pair] run cmd: %s pair %s ******", adbPath, address)
+	rawOutput, err := exec.CommandContext(ctx, adbPath, "pair", address, code).CombinedOutput()
+	output := strings.TrimSpace(string(rawOutput))
+	if err != nil {
+		return "", fmt.Errorf("adb pair failed: %v, output: %s", err, output)
+	}
+	if !strings.Contains(strings.ToLower(output), "successfully paired") {
+		return "", fmt.Errorf("adb pair failed: %s", output)
+	}
+	if m := pairedGUID.FindStringSubmatch(output); m != nil {
+		return m[1], nil
+	}
+	return "", nil
+}
+
+// ConnectWireless connects the paired device of the mDNS instance name at the
+// address it advertises now, which changes every time wireless debugging is
+// turned on, and returns the address. It falls back to last when the device
+// is not advertised.
+func (r *ADBDevice) ConnectWireless(ctx context.Context, name, last string) (string, error) {
+	address := last
+	if services, err := r.Discover(ctx); err != nil {
+		logs.Debugf("[ConnectWireless] %v", err)
+	} else {
+		for _, s := range services {
+			if s.Type != ServicePairing && serialOf(s.Name) == serialOf(name) {
+				address = s.Address
+				// the connect service of wireless debugging wins over tcpip
+				if s.Type == ServiceConnect {
+					break
+				}
+			}
+		}
+	}
+	if address == "" {
+		return "", fmt.Errorf("device %s is not advertised on the LAN, is wireless debugging on?", name)
+	}
+	message, err := r.Connect(ctx, address)
+	if err != nil {
+		return "", err
+	}
+	if !strings.Contains(strings.ToLower(message), "connected") {
+		return "", errors.New(message)
+	}
+	return address, nil
+}
+
+// serialOf strips the "adb-" prefix and the random suffix of an mDNS
+// instance name, which differ between the pairing and connect services of a
+// device.
+func serialOf(name string) string {
+	serial := strings.TrimPrefix(name, "adb-")
+	if i := strings.LastIndex(serial, "-"); i > 0 && serial != name {
+		serial = serial[:i]
+	}
+	return serial
+}
+
+// PairedDevice is a device paired for wireless debugging.
+type PairedDevice struct {
+	Name          string    `json:"name"`    // mDNS instance name
+	Address       string    `json:"address"` // last connected at
+	PairedAt      time.Time `json:"paired_at"`
+	LastConnected time.Time `json:"last_connected,omitempty"`
+}
+
+// PairedDevices are the paired devices kept in a file, to connect them again
+// in later runs.
+type PairedDevices struct {
+	path string
+
+	mu      sync.Mutex
+	devices []PairedDevice
+}
+
+// LoadPairedDevices reads the paired devices of path, none when it does not
+// exist yet.
+func LoadPairedDevices(path string) (*PairedDevices, error) {
+	paired := &PairedDevices{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return paired, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &paired.devices); err != nil {
+		return nil, fmt.Errorf("invalid paired devices file %s: %w", path, err)
+	}
+	return paired, nil
+}
+
+// List returns the paired devices.
+func (p *PairedDevices) List() []PairedDevice {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]PairedDevice(nil), p.devices...)
+}
+
+// Remember adds or updates a device and saves the file.
+func (p *PairedDevices) Remember(device PairedDevice) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	replaced := false
+	for i := range p.devices {
+		if serialOf(p.devices[i].Name) == serialOf(device.Name) {
+			if device.PairedAt.IsZero() {
+				device.PairedAt = p.devices[i].PairedAt
+			}
+			p.devices[i], replaced = device, true
+			break
+		}
+	}
+	if !replaced {
+		p.devices = append(p.devices, device)
+	}
+	return p.save()
+}
+
+// Forget removes the device of an mDNS name, serial or address and saves
+// the file.
+func (p *PairedDevices) Forget(key string) (PairedDevice, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, device := range p.devices {
+		if serialOf(device.Name) == serialOf(key) || device.Address == key {
+			p.devices = append(p.devices[:i], p.devices[i+1:]...)
+			return device, true, p.save()
+		}
+	}
+	return PairedDevice{}, false, nil
+}
+
+func (p *PairedDevices) save() error {
+	data, err := json.MarshalIndent(p.devices, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(p.path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	tmp := p.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.path)
+}
+
+// ConnectPaired connects the paired devices not connected yet, updating their
+// addresses, and returns the addresses of those connected.
+func (r *ADBDevice) ConnectPaired(ctx context.Context, paired *PairedDevices) []string {
+	var connected []string
+	for _, device := range paired.List() {
+		if device.Address != "" && r.IsConnected(ctx, device.Address) {
+			connected = append(connected, device.Address)
+			continue
+		}
+		address, err := r.ConnectWireless(ctx, device.Name, device.Address)
+		if err != nil {
+			logs.Warnf("📡 failed to connect the paired device %s, err: %v", device.Name, err)
+			continue
+		}
+		logs.Infof("📡 paired device %s connected at %s", device.Name, address)
+		device.Address, device.LastConnected = address, time.Now()
+		if err := paired.Remember(device); err != nil {
+			logs.Warnf("📡 failed to save the paired devices, err: %v", err)
+		}
+		connected = append(connected, address)
+	}
+	return connected
+}
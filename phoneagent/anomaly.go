@@ -0,0 +1,68 @@
+package phoneagent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"autoglm-go/phoneagent/anomaly"
+	"autoglm-go/phoneagent/definitions"
+	"autoglm-go/phoneagent/llm"
+	"autoglm-go/phoneagent/logging"
+	"autoglm-go/phoneagent/metrics"
+	"autoglm-go/phoneagent/webhook"
+	logs "github.com/sirupsen/logrus"
+)
+
+// newAnomalies returns the baselines the steps are compared with, nil
+// unless AgentConfig.AnomalyFactor is set.
+func newAnomalies(config *definitions.AgentConfig) *anomaly.Detector {
+	if config.AnomalyFactor <= 0 {
+		return nil
+	}
+	detector, err := anomaly.Shared(config.AnomalyBaselines)
+	if err != nil {
+		logs.Errorf("failed to load anomaly baselines, steps are not checked, err: %v", err)
+		return nil
+	}
+	return detector
+}
+
+// checkAnomalies compares the latency and tokens of the step that started at
+// started, with the usage before it, to the baselines of its model and app.
+// A step far above them is reported as an EventAnomaly and to the webhooks,
+// and the trajectory is marked for review.
+func (r *PhoneAgent) checkAnomalies(ctx context.Context, started time.Time, before map[string]llm.ModelUsage) {
+	// a step waiting for the user or replayed measures nothing of the model
+	if r.anomalies == nil || r.humanWaited || r.Replay != nil {
+		return
+	}
+	// the step is the model's that used the most tokens in it
+	var model string
+	tokens, requests := -1, 0
+	for name, usage := range r.Usage.Models() {
+		added := usage.TotalTokens() - before[name].TotalTokens()
+		requests += usage.Requests - before[name].Requests
+		if usage.Requests > before[name].Requests && added > tokens {
+			model, tokens = name, added
+		}
+	}
+	if requests == 0 {
+		return
+	}
+	app := ""
+	if obs := r.stepObservation; obs != nil {
+		app = obs.currentApp
+	}
+	sample := anomaly.Sample{Model: model, App: app, Latency: time.Since(started), Tokens: tokens}
+	for _, deviation := range r.anomalies.Observe(sample, r.AgentConfig.AnomalyFactor) {
+		message := fmt.Sprintf("step %d of %s in %s: %s", r.StepCount, model, app, deviation)
+		r.log().WithField(logging.FieldEvent, EventAnomaly).Warnf("🚨 anomaly at %s", message)
+		metrics.Anomalies.Inc(deviation.Metric, model)
+		r.emit(Event{Type: EventAnomaly, Message: message})
+		r.notify(ctx, webhook.EventAnomaly, message, "")
+		if r.Trajectory != nil {
+			r.Trajectory.Review = append(r.Trajectory.Review, message)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package anomaly
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	logs "github.com/sirupsen/logrus"
+)
+
+// Metrics of a step compared with their baseline.
+const (
+	MetricLatency = "latency" // seconds from the observation to the end of the action
+	MetricTokens  = "tokens"  // prompt and completion tokens of the step's model calls
+)
+
+const (
+	// minSamples is how many steps a baseline needs before it is trusted.
+	minSamples = 10
+	// alpha weighs the latest step in the moving average, about the last 20
+	// steps count.
+	alpha = 0.1
+)
+
+// Baseline is the exponentially weighted moving average of a metric.
+type Baseline struct {
+	Mean    float64 `json:"mean"`
+	Samples int     `json:"samples"`
+}
+
+func (b *Baseline) add(v float64) {
+	if b.Samples == 0 {
+		b.Mean = v
+	} else {
+		b.Mean += alpha * (v - b.Mean)
+	}
+	b.Samples++
+}
+
+// Sample is what a step measured, by the model that answered it and the app
+// it started in.
+type Sample struct {
+	Model   string
+	App     string
+	Latency time.Duration
+	Tokens  int // 0 when the provider reported no usage
+}
+
+// Deviation is a metric of a step far above its baseline.
+type Deviation struct {
+	Metric   string
+	Value    float64
+	Baseline float64
+	Ratio    float64
+}
+
+func (d Deviation) String() string {
+	unit := ""
+	if d.Metric == MetricLatency {
+		unit = "s"
+	}
+	return fmt.Sprintf("%s %.4g%s is %.1fx the baseline of %.4g%s", d.Metric, d.Value, unit, d.Ratio, d.Baseline, unit)
+}
+
+// Detector keeps the baselines of the steps per model and app.
+type Detector struct {
+	mu        sync.Mutex
+	path      string
+	baselines map[string]*Baseline // model|app|metric
+	saveErr   bool
+}
+
+var (
+	sharedMu sync.Mutex
+	shared   = map[string]*Detector{}
+)
+
+// Shared returns the detector kept in path, loaded once and shared by the
+// agents of the process; an empty path keeps the baselines in memory only.
+func Shared(path string) (*Detector, error) {
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	if d, ok := shared[path]; ok {
+		return d, nil
+	}
+	d := &Detector{path: path, baselines: map[string]*Baseline{}}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read anomaly baselines: %w", err)
+		}
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &d.baselines); err != nil {
+				return nil, fmt.Errorf("invalid anomaly baselines %s: %w", path, err)
+			}
+		}
+	}
+	shared[path] = d
+	return d, nil
+}
+
+// Observe compares s with the baselines of its model and app, returning the
+// metrics at least factor times above theirs, then adds s to them.
+func (d *Detector) Observe(s Sample, factor float64) []Deviation {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	values := map[string]float64{MetricLatency: s.Latency.Seconds()}
+	if s.Tokens > 0 {
+		values[MetricTokens] = float64(s.Tokens)
+	}
+	var deviations []Deviation
+	for _, metric := range []string{MetricLatency, MetricTokens} {
+		v, ok := values[metric]
+		if !ok {
+			continue
+		}
+		key := s.Model + "|" + s.App + "|" + metric
+		b, ok := d.baselines[key]
+		if !ok {
+			b = &Baseline{}
+			d.baselines[key] = b
+		}
+		if b.Samples >= minSamples && b.Mean > 0 && v >= factor*b.Mean {
+			deviations = append(deviations, Deviation{Metric: metric, Value: v, Baseline: b.Mean, Ratio: v / b.Mean})
+		}
+		b.add(v)
+	}
+	d.save()
+	return deviations
+}
+
+// save writes the baselines to the file of the detector, d.mu must be held.
+func (d *Detector) save() {
+	if d.path == "" {
+		return
+	}
+	data, err := json.MarshalIndent(d.baselines, "", "  ")
+	if err == nil {
+		tmp := filepath.Join(filepath.Dir(d.path), "."+filepath.Base(d.path)+".tmp")
+		if err = os.WriteFile(tmp, data, 0o644); err == nil {
+			err = os.Rename(tmp, d.path)
+		}
+	}
+	// once, the baselines are still kept in memory
+	if err != nil && !d.saveErr {
+		d.saveErr = true
+		logs.Warnf("failed to save anomaly baselines to %s, err: %v", d.path, err)
+	}
+}
@@ -0,0 +1,60 @@
+package appium
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"autoglm-go/phoneagent/definitions"
+)
+
+// StartIntent starts an activity with mobile: startActivity of UiAutomator2,
+// on iOS only a bundle id can be activated.
+func (r *AppiumDevice) StartIntent(ctx context.Context, intent definitions.Intent, deviceID string) error {
+	var err error
+	switch {
+	case r.platform(ctx, deviceID) == "ios":
+		if intent.Package == "" || intent.Activity != "" || intent.Action != "" || intent.URI != "" {
+			return fmt.Errorf("iOS apps can only be started by their bundle id")
+		}
+		err = r.execute(ctx, deviceID, "mobile: activateApp", map[string]any{"bundleId": intent.Package}, nil)
+	case intent.Activity == "" && intent.Action == "" && intent.URI == "":
+		err = r.execute(ctx, deviceID, "mobile: activateApp", map[string]any{"appId": intent.Package}, nil)
+	default:
+		args := map[string]any{}
+		if intent.Activity != "" {
+			args["intent"] = intent.Package + "/" + intent.Activity
+		} else if intent.Package != "" {
+			args["package"] = intent.Package
+		}
+		if intent.Action != "" {
+			args["action"] = intent.Action
+		}
+		if intent.URI != "" {
+			args["uri"] = intent.URI
+		}
+		err = r.execute(ctx, deviceID, "mobile: startActivity", args, nil)
+	}
+	if err != nil {
+		return err
+	}
+	time.Sleep(time.Second * 1)
+	return nil
+}
+
+// ForceStop terminates the app.
+func (r *AppiumDevice) ForceStop(ctx context.Context, packageName, deviceID string) error {
+	// UiAutomator2 takes appId, XCUITest takes bundleId
+	return r.execute(ctx, deviceID, "mobile: terminateApp", map[string]any{
+		"appId":    packageName,
+		"bundleId": packageName,
+	}, nil)
+}
+
+// ClearData deletes the data of the app, on iOS only in simulators.
+func (r *AppiumDevice) ClearData(ctx context.Context, packageName, deviceID string) error {
+	return r.execute(ctx, deviceID, "mobile: clearApp", map[string]any{
+		"appId":    packageName,
+		"bundleId": packageName,
+	}, nil)
+}
@@ -0,0 +1,205 @@
+package appium
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	logs "github.com/sirupsen/logrus"
+)
+
+// AppiumDevice drives devices through an Appium server (or any W3C WebDriver
+// endpoint speaking the mobile: extensions), so no local adb is needed. One
+// WebDriver session is opened per device id on first use.
+type AppiumDevice struct {
+	serverURL    string
+	capabilities map[string]any
+	client       *http.Client
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+type session struct {
+	id       string
+	platform string // android or ios
+
+	// screenshots are in pixels, W3C actions in window points
+	scale         float64
+	scaleMeasured bool
+}
+
+type webDriverError struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+// NewAppiumDevice creates a device for the Appium server at serverURL. The
+// capabilities are sent with every new session; the device id, when given,
+// is added as appium:udid.
+func NewAppiumDevice(serverURL string, capabilities map[string]any) *AppiumDevice {
+	if capabilities == nil {
+		capabilities = map[string]any{}
+	}
+	return &AppiumDevice{
+		serverURL:    strings.TrimSuffix(serverURL, "/"),
+		capabilities: capabilities,
+		client:       &http.Client{Timeout: 2 * time.Minute},
+		sessions:     map[string]*session{},
+	}
+}
+
+// do sends a WebDriver command and decodes the "value" field of the response
+// into out when it is not nil.
+func (r *AppiumDevice) do(ctx context.Context, method, path string, body any, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, r.serverURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("appium request %s %s failed: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var envelope struct {
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return fmt.Errorf("invalid appium response (%d): %s", resp.StatusCode, data)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		var wdErr webDriverError
+		_ = json.Unmarshal(envelope.Value, &wdErr)
+		return &Error{StatusCode: resp.StatusCode, Code: wdErr.Error, Message: wdErr.Message}
+	}
+	if out != nil && len(envelope.Value) > 0 {
+		return json.Unmarshal(envelope.Value, out)
+	}
+	return nil
+}
+
+// Error is an error returned by the WebDriver server.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("appium %s (%d): %s", e.Code, e.StatusCode, e.Message)
+}
+
+func (r *AppiumDevice) getSession(ctx context.Context, deviceID string) (*session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if s, ok := r.sessions[deviceID]; ok {
+		return s, nil
+	}
+
+	capabilities := make(map[string]any, len(r.capabilities)+1)
+	for k, v := range r.capabilities {
+		capabilities[k] = v
+	}
+	if deviceID != "" {
+		capabilities["appium:udid"] = deviceID
+	}
+
+	var created struct {
+		SessionID    string         `json:"sessionId"`
+		Capabilities map[string]any `json:"capabilities"`
+	}
+	err := r.do(ctx, http.MethodPost, "/session", map[string]any{
+		"capabilities": map[string]any{"alwaysMatch": capabilities},
+	}, &created)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create appium session: %w", err)
+	}
+
+	platform, _ := created.Capabilities["platformName"].(string)
+	s := &session{
+		id:       created.SessionID,
+		platform: strings.ToLower(platform),
+		scale:    1,
+	}
+	logs.Infof("appium session %s created for %s (%s)", s.id, deviceID, s.platform)
+	r.sessions[deviceID] = s
+	return s, nil
+}
+
+// command runs a command on the session of deviceID. A session that the
+// server no longer knows is recreated once.
+func (r *AppiumDevice) command(ctx context.Context, deviceID, method, path string, body any, out any) error {
+	for attempt := 0; ; attempt++ {
+		s, err := r.getSession(ctx, deviceID)
+		if err != nil {
+			return err
+		}
+
+		err = r.do(ctx, method, "/session/"+s.id+path, body, out)
+		if wdErr, ok := err.(*Error); ok && wdErr.Code == "invalid session id" && attempt == 0 {
+			logs.Warnf("appium session %s expired, creating a new one", s.id)
+			r.dropSession(deviceID, s)
+			continue
+		}
+		return err
+	}
+}
+
+// execute runs a mobile: extension command.
+func (r *AppiumDevice) execute(ctx context.Context, deviceID, script string, args map[string]any, out any) error {
+	return r.command(ctx, deviceID, http.MethodPost, "/execute/sync", map[string]any{
+		"script": script,
+		"args":   []any{args},
+	}, out)
+}
+
+func (r *AppiumDevice) dropSession(deviceID string, s *session) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.sessions[deviceID] == s {
+		delete(r.sessions, deviceID)
+	}
+}
+
+// Close ends all WebDriver sessions.
+func (r *AppiumDevice) Close() error {
+	r.mu.Lock()
+	sessions := r.sessions
+	r.sessions = map[string]*session{}
+	r.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, s := range sessions {
+		if err := r.do(ctx, http.MethodDelete, "/session/"+s.id, nil, nil); err != nil {
+			logs.Warnf("failed to delete appium session %s: %v", s.id, err)
+		}
+	}
+	return nil
+}
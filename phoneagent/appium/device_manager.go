@@ -0,0 +1,90 @@
+package appium
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"autoglm-go/phoneagent/definitions"
+)
+
+var ErrNotSupported = errors.New("not supported by the appium backend")
+
+func (r *AppiumDevice) Connect(ctx context.Context, address string) (string, error) {
+	return fmt.Sprintf("connect is %v", ErrNotSupported), ErrNotSupported
+}
+
+func (r *AppiumDevice) Disconnect(ctx context.Context, address string) (string, error) {
+	return fmt.Sprintf("disconnect is %v", ErrNotSupported), ErrNotSupported
+}
+
+// ListDevices reports the configured device, Appium has no portable way to
+// enumerate the devices behind a server.
+func (r *AppiumDevice) ListDevices(ctx context.Context) ([]definitions.DeviceInfo, error) {
+	udid, _ := r.capabilities["appium:udid"].(string)
+	info, err := r.GetDeviceInfo(ctx, udid)
+	if err != nil {
+		return nil, err
+	}
+	return []definitions.DeviceInfo{*info}, nil
+}
+
+func (r *AppiumDevice) GetDeviceInfo(ctx context.Context, deviceID string) (*definitions.DeviceInfo, error) {
+	status := "device"
+	if !r.IsConnected(ctx, deviceID) {
+		status = "offline"
+	}
+	model, _ := r.capabilities["appium:deviceName"].(string)
+	version, _ := r.capabilities["appium:platformVersion"].(string)
+
+	if deviceID == "" {
+		deviceID = r.serverURL
+	}
+	return &definitions.DeviceInfo{
+		DeviceID:       deviceID,
+		Status:         status,
+		ConnectionType: definitions.Remote,
+		Model:          model,
+		AndroidVersion: version,
+		OS:             platformOS(r.capabilities),
+	}, nil
+}
+
+// IsConnected reports whether the Appium server is ready for new sessions.
+func (r *AppiumDevice) IsConnected(ctx context.Context, deviceID string) bool {
+	var status struct {
+		Ready bool `json:"ready"`
+	}
+	if err := r.do(ctx, http.MethodGet, "/status", nil, &status); err != nil {
+		return false
+	}
+	return status.Ready
+}
+
+func (r *AppiumDevice) EnableTCPIP(ctx context.Context, port int, deviceID string) error {
+	return ErrNotSupported
+}
+
+func (r *AppiumDevice) GetDeviceIP(ctx context.Context, deviceID string) (string, error) {
+	return "", ErrNotSupported
+}
+
+func (r *AppiumDevice) RestartServer(ctx context.Context) (string, error) {
+	return "", ErrNotSupported
+}
+
+// platformOS is the system of the platformName capability, empty when unknown.
+func platformOS(capabilities map[string]any) definitions.OSType {
+	platform, _ := capabilities["platformName"].(string)
+	switch strings.ToLower(platform) {
+	case "android":
+		return definitions.Android
+	case "ios":
+		return definitions.IOS
+	case "harmonyos", "openharmony":
+		return definitions.HarmonyOS
+	}
+	return ""
+}
@@ -0,0 +1,310 @@
+package appium
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"image"
+	_ "image/png"
+	"math"
+	"net/http"
+	"time"
+
+	"autoglm-go/constants"
+	"autoglm-go/phoneagent/definitions"
+	logs "github.com/sirupsen/logrus"
+)
+
+// W3C element reference key
+const elementKey = "element-6066-11e4-a52e-4f735466cecf"
+
+// GetScreenshot takes a screenshot, a fallback one when it fails as on the
+// other backends.
+func (r *AppiumDevice) GetScreenshot(ctx context.Context, deviceID string) (*definitions.Screenshot, error) {
+	var b64 string
+	if err := r.command(ctx, deviceID, http.MethodGet, "/screenshot", nil, &b64); err != nil {
+		logs.Errorf("appium screenshot failed: %v", err)
+		return definitions.FallbackScreenshot(), nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		logs.Errorf("invalid screenshot data: %v", err)
+		return definitions.FallbackScreenshot(), nil
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		logs.Errorf("failed to decode screenshot: %v", err)
+		return definitions.FallbackScreenshot(), nil
+	}
+
+	r.updateScale(ctx, deviceID, cfg.Width)
+
+	return &definitions.Screenshot{
+		Base64Data: b64,
+		Width:      cfg.Width,
+		Height:     cfg.Height,
+		Data:       data,
+	}, nil
+}
+
+// updateScale records the ratio between window points and screenshot pixels,
+// which differ on iOS retina screens.
+func (r *AppiumDevice) updateScale(ctx context.Context, deviceID string, screenshotWidth int) {
+	s, err := r.getSession(ctx, deviceID)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	measured := s.scaleMeasured
+	r.mu.Unlock()
+	if measured {
+		return
+	}
+
+	var rect struct {
+		Width float64 `json:"width"`
+	}
+	if err := r.command(ctx, deviceID, http.MethodGet, "/window/rect", nil, &rect); err != nil || rect.Width <= 0 || screenshotWidth <= 0 {
+		return
+	}
+
+	r.mu.Lock()
+	s.scale = rect.Width / float64(screenshotWidth)
+	s.scaleMeasured = true
+	r.mu.Unlock()
+}
+
+// scale returns window points per screenshot pixel.
+func (r *AppiumDevice) scale(ctx context.Context, deviceID string) float64 {
+	s, err := r.getSession(ctx, deviceID)
+	if err != nil {
+		return 1
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return s.scale
+}
+
+// point converts screenshot pixels to window points.
+func (r *AppiumDevice) point(ctx context.Context, deviceID string, x, y int) (int, int) {
+	scale := r.scale(ctx, deviceID)
+	return int(float64(x) * scale), int(float64(y) * scale)
+}
+
+func (r *AppiumDevice) platform(ctx context.Context, deviceID string) string {
+	s, err := r.getSession(ctx, deviceID)
+	if err != nil {
+		return ""
+	}
+	return s.platform
+}
+
+func (r *AppiumDevice) GetCurrentApp(ctx context.Context, deviceID string) (string, error) {
+	if r.platform(ctx, deviceID) == "ios" {
+		var info struct {
+			BundleID string `json:"bundleId"`
+		}
+		if err := r.execute(ctx, deviceID, "mobile: activeAppInfo", map[string]any{}, &info); err != nil {
+			return "", fmt.Errorf("failed to get active app: %w", err)
+		}
+		for appName, bundleID := range constants.APP_PACKAGES_IOS {
+			if bundleID == info.BundleID {
+				return appName, nil
+			}
+		}
+		return "System Home", nil
+	}
+
+	var packageName string
+	if err := r.execute(ctx, deviceID, "mobile: getCurrentPackage", map[string]any{}, &packageName); err != nil {
+		return "", fmt.Errorf("failed to get current package: %w", err)
+	}
+	for appName, pkg := range constants.APP_PACKAGES_ANDROID {
+		if pkg == packageName {
+			return appName, nil
+		}
+	}
+	return "System Home", nil
+}
+
+// pointerActions performs a single touch gesture made of the given steps.
+func (r *AppiumDevice) pointerActions(ctx context.Context, deviceID string, steps ...map[string]any) error {
+	return r.fingerActions(ctx, deviceID, steps)
+}
+
+// fingerActions performs a gesture of one finger by steps, at the same time.
+func (r *AppiumDevice) fingerActions(ctx context.Context, deviceID string, fingers ...[]map[string]any) error {
+	actions := make([]any, 0, len(fingers))
+	for i, steps := range fingers {
+		actions = append(actions, map[string]any{
+			"type":       "pointer",
+			"id":         fmt.Sprintf("finger%d", i+1),
+			"parameters": map[string]any{"pointerType": "touch"},
+			"actions":    steps,
+		})
+	}
+	return r.command(ctx, deviceID, http.MethodPost, "/actions", map[string]any{"actions": actions}, nil)
+}
+
+func move(x, y, durationMs int) map[string]any {
+	return map[string]any{"type": "pointerMove", "duration": durationMs, "x": x, "y": y}
+}
+
+func pause(durationMs int) map[string]any {
+	return map[string]any{"type": "pause", "duration": durationMs}
+}
+
+var (
+	down = map[string]any{"type": "pointerDown", "button": 0}
+	up   = map[string]any{"type": "pointerUp", "button": 0}
+)
+
+func (r *AppiumDevice) Tap(ctx context.Context, x, y int, deviceID string) error {
+	x, y = r.point(ctx, deviceID, x, y)
+	logs.Debugf("[Tap] appium tap: %d,%d", x, y)
+
+	err := r.pointerActions(ctx, deviceID, move(x, y, 0), down, pause(100), up)
+	time.Sleep(time.Second * 1)
+	return err
+}
+
+func (r *AppiumDevice) DoubleTap(ctx context.Context, x, y int, deviceID string) error {
+	x, y = r.point(ctx, deviceID, x, y)
+	logs.Debugf("[DoubleTap] appium double tap: %d,%d", x, y)
+
+	err := r.pointerActions(ctx, deviceID, move(x, y, 0), down, pause(50), up, pause(100), down, pause(50), up)
+	time.Sleep(time.Second * 1)
+	return err
+}
+
+func (r *AppiumDevice) LongPress(ctx context.Context, x, y int, deviceID string) error {
+	x, y = r.point(ctx, deviceID, x, y)
+	logs.Debugf("[LongPress] appium long press: %d,%d", x, y)
+
+	err := r.pointerActions(ctx, deviceID, move(x, y, 0), down, pause(3000), up)
+	time.Sleep(time.Second * 1)
+	return err
+}
+
+func (r *AppiumDevice) Swipe(ctx context.Context, startX, startY, endX, endY int, deviceID string) error {
+	distSq := (startX-endX)*(startX-endX) + (startY-endY)*(startY-endY)
+	durationMs := int(float64(distSq) / 1000)
+	durationMs = max(1000, min(durationMs, 2000)) // Clamp between 1000-2000ms
+
+	startX, startY = r.point(ctx, deviceID, startX, startY)
+	endX, endY = r.point(ctx, deviceID, endX, endY)
+	logs.Debugf("[Swipe] appium swipe: %d,%d -> %d,%d in %dms", startX, startY, endX, endY, durationMs)
+
+	err := r.pointerActions(ctx, deviceID, move(startX, startY, 0), down, move(endX, endY, durationMs), up)
+	time.Sleep(time.Second * 1)
+	return err
+}
+
+func (r *AppiumDevice) Back(ctx context.Context, deviceID string) error {
+	err := r.command(ctx, deviceID, http.MethodPost, "/back", map[string]any{}, nil)
+	time.Sleep(time.Second * 1)
+	return err
+}
+
+func (r *AppiumDevice) Home(ctx context.Context, deviceID string) error {
+	var err error
+	if r.platform(ctx, deviceID) == "ios" {
+		err = r.execute(ctx, deviceID, "mobile: pressButton", map[string]any{"name": "home"}, nil)
+	} else {
+		err = r.execute(ctx, deviceID, "mobile: pressKey", map[string]any{"keycode": 3}, nil)
+	}
+	time.Sleep(time.Second * 1)
+	return err
+}
+
+func (r *AppiumDevice) LaunchApp(ctx context.Context, appName, deviceID string) (bool, error) {
+	packages := constants.APP_PACKAGES_ANDROID
+	if r.platform(ctx, deviceID) == "ios" {
+		packages = constants.APP_PACKAGES_IOS
+	}
+	appID, ok := packages[appName]
+	if !ok {
+		return false, nil
+	}
+
+	// UiAutomator2 takes appId, XCUITest takes bundleId
+	err := r.execute(ctx, deviceID, "mobile: activateApp", map[string]any{
+		"appId":    appID,
+		"bundleId": appID,
+	}, nil)
+	if err != nil {
+		return false, err
+	}
+	time.Sleep(time.Second * 1)
+	return true, nil
+}
+
+// activeElement returns the id of the focused element, empty when there is
+// none.
+func (r *AppiumDevice) activeElement(ctx context.Context, deviceID string) string {
+	var element map[string]string
+	if err := r.command(ctx, deviceID, http.MethodGet, "/element/active", nil, &element); err != nil {
+		return ""
+	}
+	return element[elementKey]
+}
+
+func (r *AppiumDevice) TypeText(ctx context.Context, text, deviceID string) error {
+	if id := r.activeElement(ctx, deviceID); id != "" {
+		return r.command(ctx, deviceID, http.MethodPost, "/element/"+id+"/value", map[string]any{"text": text}, nil)
+	}
+
+	// no focused element reported, type through key actions
+	keys := make([]map[string]any, 0, 2*len(text))
+	for _, c := range text {
+		keys = append(keys,
+			map[string]any{"type": "keyDown", "value": string(c)},
+			map[string]any{"type": "keyUp", "value": string(c)},
+		)
+	}
+	return r.command(ctx, deviceID, http.MethodPost, "/actions", map[string]any{
+		"actions": []any{
+			map[string]any{"type": "key", "id": "keyboard", "actions": keys},
+		},
+	}, nil)
+}
+
+func (r *AppiumDevice) ClearText(ctx context.Context, deviceID string) error {
+	id := r.activeElement(ctx, deviceID)
+	if id == "" {
+		return nil
+	}
+	return r.command(ctx, deviceID, http.MethodPost, "/element/"+id+"/clear", map[string]any{}, nil)
+}
+
+// DetectAndSetADBKeyboard is a no-op, Appium types through the driver.
+func (r *AppiumDevice) DetectAndSetADBKeyboard(ctx context.Context, deviceID string) (string, error) {
+	return "", nil
+}
+
+func (r *AppiumDevice) RestoreKeyboard(ctx context.Context, ime, deviceID string) error {
+	return nil
+}
+
+func (r *AppiumDevice) DumpUI(ctx context.Context, deviceID string) ([]definitions.UIElement, error) {
+	var source string
+	if err := r.command(ctx, deviceID, http.MethodGet, "/source", nil, &source); err != nil {
+		return nil, fmt.Errorf("failed to get page source: %w", err)
+	}
+	elements, err := parseSource(source)
+	if err != nil {
+		return nil, err
+	}
+
+	// XCUITest reports points, convert them to screenshot pixels
+	if scale := r.scale(ctx, deviceID); r.platform(ctx, deviceID) == "ios" && scale > 0 && scale != 1 {
+		for i := range elements {
+			for j := range elements[i].Bounds {
+				elements[i].Bounds[j] = int(math.Round(float64(elements[i].Bounds[j]) / scale))
+			}
+		}
+	}
+	return elements, nil
+}
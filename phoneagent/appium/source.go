@@ -0,0 +1,84 @@
+package appium
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"autoglm-go/phoneagent/definitions"
+)
+
+var boundsRe = regexp.MustCompile(`\[(-?\d+),(-?\d+)\]\[(-?\d+),(-?\d+)\]`)
+
+// parseSource extracts elements from an Appium page source. UiAutomator2
+// sources carry bounds="[l,t][r,b]" like uiautomator dumps, XCUITest sources
+// carry x, y, width and height in points.
+func parseSource(source string) ([]definitions.UIElement, error) {
+	decoder := xml.NewDecoder(strings.NewReader(source))
+
+	var elements []definitions.UIElement
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse page source: %w", err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		attrs := make(map[string]string, len(start.Attr))
+		for _, attr := range start.Attr {
+			attrs[attr.Name.Local] = attr.Value
+		}
+
+		element := definitions.UIElement{
+			Text:        attrs["text"],
+			ContentDesc: attrs["content-desc"],
+			ResourceID:  attrs["resource-id"],
+			Class:       attrs["class"],
+			Package:     attrs["package"],
+			Clickable:   attrs["clickable"] == "true",
+		}
+		if element.Class == "" {
+			element.Class = attrs["type"]
+		}
+		if element.Text == "" {
+			element.Text = attrs["label"]
+			if element.Text == "" {
+				element.Text = attrs["value"]
+			}
+		}
+		if element.ResourceID == "" {
+			element.ResourceID = attrs["name"]
+		}
+		if _, ok := attrs["accessible"]; ok {
+			element.Clickable = attrs["accessible"] == "true" && attrs["enabled"] != "false"
+		}
+
+		if m := boundsRe.FindStringSubmatch(attrs["bounds"]); m != nil {
+			for i := 0; i < 4; i++ {
+				element.Bounds[i], _ = strconv.Atoi(m[i+1])
+			}
+		} else if attrs["width"] != "" {
+			x, _ := strconv.Atoi(attrs["x"])
+			y, _ := strconv.Atoi(attrs["y"])
+			w, _ := strconv.Atoi(attrs["width"])
+			h, _ := strconv.Atoi(attrs["height"])
+			element.Bounds = [4]int{x, y, x + w, y + h}
+		}
+
+		if element.Text == "" && element.ContentDesc == "" && !element.Clickable {
+			continue
+		}
+		element.Index = len(elements)
+		elements = append(elements, element)
+	}
+	return elements, nil
+}
@@ -0,0 +1,136 @@
+package phoneagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/memory"
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	// memoryFacts is how many facts of an app are given to a task.
+	memoryFacts = 5
+	// learnTimeout bounds the summary of what a task learned.
+	learnTimeout = time.Minute
+)
+
+const (
+	learnPromptCn = `你在手机操作任务结束后总结关于应用的经验。根据操作记录，写出以后在同一应用中执行其他任务也有用、且与本次任务的具体内容无关的事实，例如某个按钮的位置、登录需要验证码、某个弹窗的关闭方式。每行一条，格式为"应用: 事实"，应用名与记录中的一致，每个应用最多 3 条，不要重复已知的事实；没有值得记住的内容时只输出 NONE。`
+	learnPromptEn = `You sum up what a finished phone automation task taught about its apps. From the action log, write facts that help other tasks in the same app later and do not depend on this task, e.g. where a button is, that the login needs a one-time code, how a popup is dismissed. One per line as "app: fact", with the app named as in the log, at most 3 per app, none that is already known; output only NONE when nothing is worth remembering.`
+
+	learnTaskCn = "任务：%s\n结果：%s\n\n已知的事实：\n%s\n\n操作记录：\n%s"
+	learnTaskEn = "Task: %s\nResult: %s\n\nKnown facts:\n%s\n\nAction log:\n%s"
+)
+
+var factLineRe = regexp.MustCompile(`^\s*(?:[-*]\s*)?([^:：]+?)\s*[:：]\s*(.+?)\s*$`)
+
+// appMemory opens the store of AgentConfig.MemoryFile on first use, nil
+// without one.
+func (r *PhoneAgent) appMemory() *memory.Store {
+	if r.memory == nil && r.AgentConfig.MemoryFile != "" {
+		store, err := memory.Shared(r.AgentConfig.MemoryFile)
+		if err != nil {
+			r.log().Warnf("📚 failed to open app memory, err: %v", err)
+			return nil
+		}
+		r.memory = store
+	}
+	return r.memory
+}
+
+// memoryContext is the memory section of the observation: the facts known
+// about app, once per task.
+func (r *PhoneAgent) memoryContext(app string) string {
+	store := r.appMemory()
+	if store == nil || app == "" || r.memoryApps[app] {
+		return ""
+	}
+	if r.memoryApps == nil {
+		r.memoryApps = map[string]bool{}
+	}
+	r.memoryApps[app] = true
+	facts := store.Use(app, memoryFacts)
+	if len(facts) == 0 {
+		return ""
+	}
+	lines := []string{app + ":"}
+	for _, f := range facts {
+		lines = append(lines, "- "+f.Text)
+	}
+	r.log().Debugf("📚 %d fact(s) about %s given to the model", len(facts), app)
+	return strings.Join(lines, "\n")
+}
+
+// learnFacts asks the model what the task taught about the apps it went
+// through and keeps it in the app memory. A task cancelled by the user or
+// replayed teaches nothing, nor is a task over its budget given more tokens.
+func (r *PhoneAgent) learnFacts(ctx context.Context, task, message string, taskErr error) {
+	store := r.appMemory()
+	if store == nil || r.Replay != nil || r.Trajectory == nil || len(r.Trajectory.Steps) == 0 || errors.Is(taskErr, ErrTaskCancelled) || errors.Is(taskErr, ErrBudgetExceeded) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), learnTimeout)
+	defer cancel()
+
+	apps := map[string]bool{}
+	var log, known []string
+	for _, step := range r.Trajectory.Steps {
+		result := "ok"
+		if !step.Success {
+			result = "failed"
+		}
+		if step.Message != "" {
+			result += ": " + step.Message
+		}
+		log = append(log, fmt.Sprintf("%d. [%s] %s -> %s", step.Index, step.App, helper.FormatAction(step.Action), result))
+		if step.App != "" && !apps[step.App] {
+			apps[step.App] = true
+			for _, f := range store.List(step.App) {
+				known = append(known, step.App+": "+f.Text)
+			}
+		}
+	}
+	if len(apps) == 0 {
+		return
+	}
+	if taskErr != nil {
+		message = taskErr.Error()
+	}
+
+	system, format := learnPromptCn, learnTaskCn
+	if r.AgentConfig.Lang == "en" {
+		system, format = learnPromptEn, learnTaskEn
+	}
+	text := fmt.Sprintf(format, task, message, strings.Join(known, "\n"), strings.Join(log, "\n"))
+	response, err := r.ModelClient.Request(ctx, []openai.ChatCompletionMessage{
+		helper.CreateSystemMessage(system),
+		{Role: openai.ChatMessageRoleUser, Content: text},
+	})
+	if err != nil {
+		r.log().Warnf("📚 failed to sum up what the task learned, err: %v", err)
+		return
+	}
+	r.Usage.Add(response)
+
+	learned := map[string][]string{}
+	for _, line := range strings.Split(response.RawContent, "\n") {
+		if m := factLineRe.FindStringSubmatch(line); m != nil && apps[m[1]] {
+			learned[m[1]] = append(learned[m[1]], m[2])
+		}
+	}
+	source := r.SessionID
+	if source == "" {
+		source = r.taskID
+	}
+	for app, facts := range learned {
+		if added := store.Add(app, source, facts); added > 0 {
+			r.log().Infof("📚 learned %d fact(s) about %s", added, app)
+		}
+	}
+}
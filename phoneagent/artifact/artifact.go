@@ -0,0 +1,186 @@
+// Package artifact keeps the screenshots and videos of the runs in a local
+// directory or an S3 compatible bucket (AWS S3, Aliyun OSS, MinIO...), under
+// content-addressed keys, and hands out signed URLs reading them.
+package artifact
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned for a key that is not stored.
+var ErrNotFound = errors.New("artifact not found")
+
+// Object is a stored artifact.
+type Object struct {
+	Key      string
+	Size     int64
+	Modified time.Time
+}
+
+// Storage keeps artifacts by key, a slash separated relative path.
+type Storage interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+	// List returns the artifacts whose key starts with prefix.
+	List(ctx context.Context, prefix string) ([]Object, error)
+	// URL returns a URL reading the artifact without other credentials until
+	// ttl is over.
+	URL(ctx context.Context, key string, ttl time.Duration) (string, error)
+	// String tells where the artifacts are, for the logs.
+	String() string
+}
+
+// ServesSealed reports whether the URLs of s serve the artifacts sealed with
+// seal.Data decrypted: those of the Handler of a Local directory do, those of
+// a bucket hand out the ciphertext.
+func ServesSealed(s Storage) bool {
+	_, ok := s.(*Local)
+	return ok
+}
+
+// ContentKey is the key of data under dir: the hex SHA-256 of data, with ext,
+// in a subdirectory of its first two characters so that none gets too many.
+// The same screen taken twice is stored once.
+func ContentKey(dir string, data []byte, ext string) string {
+	sum := sha256.Sum256(data)
+	name := hex.EncodeToString(sum[:])
+	return path.Join(dir, name[:2], name+ext)
+}
+
+// checkKey rejects the keys that would leave the storage.
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || path.Clean(key) != key || key == ".." || strings.HasPrefix(key, "../") {
+		return fmt.Errorf("invalid artifact key %q", key)
+	}
+	return nil
+}
+
+var (
+	mu      sync.RWMutex
+	current Storage
+)
+
+// Use makes s keep the artifacts written from now on, nil keeps them next to
+// the records, and returns a function restoring the previous one.
+func Use(s Storage) func() {
+	mu.Lock()
+	defer mu.Unlock()
+	previous := current
+	current = s
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		current = previous
+	}
+}
+
+// Current returns the Storage of Use, nil without one.
+func Current() Storage {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// Options are those of the storage of Open.
+type Options struct {
+	// Secret signs the URLs of a local directory, random when empty: the URLs
+	// handed out do not survive a restart then.
+	Secret []byte
+	// BaseURL is where the Handler of a local directory is served, "/artifacts"
+	// by default.
+	BaseURL string
+}
+
+// Open returns the storage of spec:
+//
+//	/var/lib/autoglm or file:///var/lib/autoglm                a local directory
+//	s3://bucket/prefix?region=us-east-1                          an S3 bucket, endpoint= and path_style=true for MinIO and the like
+//	oss://bucket/prefix?endpoint=oss-cn-hangzhou.aliyuncs.com    an Aliyun OSS bucket, through its S3 compatible API
+//
+// Buckets are accessed with AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN, or OSS_ACCESS_KEY_ID and OSS_ACCESS_KEY_SECRET for OSS.
+func Open(spec string, opts Options) (Storage, error) {
+	scheme, rest, ok := strings.Cut(spec, "://")
+	if !ok {
+		return NewLocal(spec, opts)
+	}
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid artifact store %q: %w", spec, err)
+	}
+	query := u.Query()
+	switch scheme {
+	case "file":
+		return NewLocal(rest, opts)
+	case "s3":
+		endpoint := query.Get("endpoint")
+		region := query.Get("region")
+		if region == "" {
+			region = os.Getenv("AWS_REGION")
+		}
+		if region == "" {
+			region = "us-east-1"
+		}
+		if endpoint == "" {
+			endpoint = "https://s3." + region + ".amazonaws.com"
+		}
+		return NewS3(S3Config{
+			Endpoint:     endpoint,
+			Region:       region,
+			Bucket:       u.Host,
+			Prefix:       strings.TrimPrefix(u.Path, "/"),
+			PathStyle:    query.Get("path_style") == "true",
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		})
+	case "oss":
+		endpoint := query.Get("endpoint")
+		if endpoint == "" {
+			return nil, fmt.Errorf("invalid artifact store %q: endpoint= is required, e.g. oss-cn-hangzhou.aliyuncs.com", spec)
+		}
+		host := strings.TrimPrefix(strings.TrimPrefix(endpoint, "https://"), "http://")
+		region := query.Get("region")
+		if region == "" {
+			// oss-cn-hangzhou.aliyuncs.com is in oss-cn-hangzhou
+			region, _, _ = strings.Cut(host, ".")
+		}
+		return NewS3(S3Config{
+			Endpoint:  "https://" + host,
+			Region:    region,
+			Bucket:    u.Host,
+			Prefix:    strings.TrimPrefix(u.Path, "/"),
+			AccessKey: firstEnv("OSS_ACCESS_KEY_ID", "AWS_ACCESS_KEY_ID"),
+			SecretKey: firstEnv("OSS_ACCESS_KEY_SECRET", "AWS_SECRET_ACCESS_KEY"),
+		})
+	default:
+		return nil, fmt.Errorf("invalid artifact store %q: unknown scheme %s, want file, s3 or oss", spec, scheme)
+	}
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func randomSecret() []byte {
+	secret := make([]byte, 32)
+	_, _ = rand.Read(secret)
+	return secret
+}
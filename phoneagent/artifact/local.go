@@ -0,0 +1,214 @@
+package artifact
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"autoglm-go/phoneagent/seal"
+)
+
+// Local keeps the artifacts in a directory. Its URLs are those of its
+// Handler, signed with its secret.
+type Local struct {
+	dir     string
+	secret  []byte
+	baseURL string
+}
+
+// NewLocal returns the storage of dir, created if needed.
+func NewLocal(dir string, opts Options) (*Local, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("artifact dir is required")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create artifact dir: %w", err)
+	}
+	local := &Local{dir: dir, secret: opts.Secret, baseURL: strings.TrimSuffix(opts.BaseURL, "/")}
+	if len(local.secret) == 0 {
+		local.secret = randomSecret()
+	}
+	if local.baseURL == "" {
+		local.baseURL = "/artifacts"
+	}
+	return local, nil
+}
+
+func (r *Local) String() string {
+	return r.dir
+}
+
+func (r *Local) path(key string) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(r.dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes data at key, replacing it at once. A key already stored only
+// gets a new modification time, its content is the same: the retention
+// counts from the last time it was stored.
+func (r *Local) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	file, err := r.path(key)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := os.Chtimes(file, now, now); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+func (r *Local) Get(ctx context.Context, key string) ([]byte, error) {
+	file, err := r.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return data, err
+}
+
+func (r *Local) Delete(ctx context.Context, key string) error {
+	file, err := r.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (r *Local) List(ctx context.Context, prefix string) ([]Object, error) {
+	// only the directory of prefix needs a walk
+	root := r.dir
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir, err := r.path(prefix[:i])
+		if err != nil {
+			return nil, err
+		}
+		root = dir
+	}
+	var objects []Object
+	err := filepath.WalkDir(root, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".tmp-") {
+			return nil
+		}
+		rel, err := filepath.Rel(r.dir, file)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		objects = append(objects, Object{Key: key, Size: info.Size(), Modified: info.ModTime()})
+		return ctx.Err()
+	})
+	return objects, err
+}
+
+// URL returns the URL of key on the Handler, valid for ttl.
+func (r *Local) URL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	escaped := (&url.URL{Path: key}).EscapedPath()
+	return r.baseURL + "/" + escaped + "?expires=" + expires + "&sig=" + r.sign(key, expires), nil
+}
+
+func (r *Local) sign(key, expires string) string {
+	mac := hmac.New(sha256.New, r.secret)
+	mac.Write([]byte(key + "\n" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Path is the path of the base URL, where the Handler is to be served.
+func (r *Local) Path() string {
+	u, err := url.Parse(r.baseURL)
+	if err != nil {
+		return "/artifacts"
+	}
+	return u.Path
+}
+
+// Handler serves the artifacts of the URLs of r under their base URL, 410
+// once they expired. Sealed artifacts are served decrypted.
+func (r *Local) Handler() http.Handler {
+	prefix := r.Path()
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		key, ok := strings.CutPrefix(req.URL.Path, prefix+"/")
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		key = path.Clean(key)
+		query := req.URL.Query()
+		expires := query.Get("expires")
+		if !hmac.Equal([]byte(query.Get("sig")), []byte(r.sign(key, expires))) {
+			http.Error(w, "invalid signature", http.StatusForbidden)
+			return
+		}
+		if unix, err := strconv.ParseInt(expires, 10, 64); err != nil || time.Now().Unix() > unix {
+			http.Error(w, "link expired", http.StatusGone)
+			return
+		}
+		data, err := r.Get(req.Context(), key)
+		if err == nil {
+			data, err = seal.Open(data)
+		}
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				http.NotFound(w, req)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", http.DetectContentType(data))
+		w.Header().Set("Cache-Control", "private, max-age=3600")
+		_, _ = w.Write(data)
+	})
+}
@@ -0,0 +1,48 @@
+package artifact
+
+import (
+	"context"
+	"time"
+
+	logs "github.com/sirupsen/logrus"
+)
+
+// Prune deletes the artifacts under prefix stored more than maxAge ago and
+// returns how many.
+func Prune(ctx context.Context, s Storage, prefix string, maxAge time.Duration) (int, error) {
+	objects, err := s.List(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+	cutoff := time.Now().Add(-maxAge)
+	deleted := 0
+	for _, object := range objects {
+		if !object.Modified.Before(cutoff) {
+			continue
+		}
+		if err := s.Delete(ctx, object.Key); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// KeepFor prunes the artifacts of s stored more than maxAge ago now and then
+// every interval, until ctx is done.
+func KeepFor(ctx context.Context, s Storage, maxAge, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if deleted, err := Prune(ctx, s, "", maxAge); err != nil {
+			logs.Warnf("🗄️ pruning the artifacts of %s failed, err: %v", s, err)
+		} else if deleted > 0 {
+			logs.Infof("🗄️ %d artifact(s) older than %s deleted from %s", deleted, maxAge, s)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
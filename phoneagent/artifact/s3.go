@@ -0,0 +1,293 @@
+package artifact
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	s3Algorithm      = "AWS4-HMAC-SHA256"
+	s3UnsignedBody   = "UNSIGNED-PAYLOAD"
+	s3MaxPresign     = 7 * 24 * time.Hour
+	s3RequestTimeout = 2 * time.Minute
+)
+
+// S3Config is an S3 compatible bucket.
+type S3Config struct {
+	Endpoint string // e.g. https://s3.us-east-1.amazonaws.com
+	Region   string
+	Bucket   string
+	Prefix   string // of every key in the bucket
+	// PathStyle puts the bucket in the path instead of the host, for MinIO
+	// and the like.
+	PathStyle    bool
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+}
+
+// S3 keeps the artifacts in a bucket, signing its requests with AWS
+// Signature Version 4. Its URLs are presigned.
+type S3 struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+func NewS3(config S3Config) (*S3, error) {
+	if config.Bucket == "" {
+		return nil, fmt.Errorf("artifact bucket is required")
+	}
+	if config.AccessKey == "" || config.SecretKey == "" {
+		return nil, fmt.Errorf("credentials of the artifact bucket %s are missing", config.Bucket)
+	}
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint %q of the artifact bucket", config.Endpoint)
+	}
+	if config.Prefix != "" && !strings.HasSuffix(config.Prefix, "/") {
+		config.Prefix += "/"
+	}
+	return &S3{config: config, endpoint: endpoint, client: &http.Client{Timeout: s3RequestTimeout}}, nil
+}
+
+func (r *S3) String() string {
+	return fmt.Sprintf("s3://%s/%s at %s", r.config.Bucket, r.config.Prefix, r.endpoint.Host)
+}
+
+// objectURL is the URL of key, or of the bucket when empty.
+func (r *S3) objectURL(key string) *url.URL {
+	u := *r.endpoint
+	object := ""
+	if key != "" {
+		object = r.config.Prefix + key
+	}
+	if r.config.PathStyle {
+		u.Path = "/" + r.config.Bucket + "/" + object
+	} else {
+		u.Host = r.config.Bucket + "." + u.Host
+		u.Path = "/" + object
+	}
+	// sent as signed
+	u.RawPath = uriEncode(u.Path, false)
+	return &u
+}
+
+func (r *S3) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	_, err := r.do(ctx, http.MethodPut, key, nil, header, data)
+	return err
+}
+
+func (r *S3) Get(ctx context.Context, key string) ([]byte, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	return r.do(ctx, http.MethodGet, key, nil, nil, nil)
+}
+
+func (r *S3) Delete(ctx context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	_, err := r.do(ctx, http.MethodDelete, key, nil, nil, nil)
+	return err
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (r *S3) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	query := url.Values{"list-type": {"2"}, "prefix": {r.config.Prefix + prefix}}
+	for {
+		data, err := r.do(ctx, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result listBucketResult
+		if err := xml.Unmarshal(data, &result); err != nil {
+			return nil, fmt.Errorf("invalid object list of %s: %w", r.config.Bucket, err)
+		}
+		for _, content := range result.Contents {
+			objects = append(objects, Object{
+				Key:      strings.TrimPrefix(content.Key, r.config.Prefix),
+				Size:     content.Size,
+				Modified: content.LastModified,
+			})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+// URL presigns a GET of key, valid for ttl up to the 7 days S3 allows.
+func (r *S3) URL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	ttl = min(max(ttl, time.Second), s3MaxPresign)
+	now := time.Now().UTC()
+	u := r.objectURL(key)
+	query := url.Values{
+		"X-Amz-Algorithm":     {s3Algorithm},
+		"X-Amz-Credential":    {r.config.AccessKey + "/" + r.scope(now)},
+		"X-Amz-Date":          {now.Format("20060102T150405Z")},
+		"X-Amz-Expires":       {strconv.Itoa(int(ttl.Seconds()))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	if r.config.SessionToken != "" {
+		query.Set("X-Amz-Security-Token", r.config.SessionToken)
+	}
+	canonicalQuery := canonicalQuery(query)
+	request := strings.Join([]string{http.MethodGet, uriEncode(u.Path, false), canonicalQuery, "host:" + u.Host + "\n", "host", s3UnsignedBody}, "\n")
+	u.RawQuery = canonicalQuery + "&X-Amz-Signature=" + r.signature(now, request)
+	return u.String(), nil
+}
+
+// do sends a signed request and returns the body of its response.
+func (r *S3) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) ([]byte, error) {
+	u := r.objectURL(key)
+	u.RawQuery = canonicalQuery(query)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	r.sign(req, body)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound && key != "":
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	case resp.StatusCode >= 300:
+		message := strings.TrimSpace(string(data))
+		if len(message) > 300 {
+			message = message[:300]
+		}
+		return nil, fmt.Errorf("%s %s of bucket %s: %s: %s", method, key, r.config.Bucket, resp.Status, message)
+	}
+	return data, nil
+}
+
+// sign adds the Authorization header of req with its body.
+func (r *S3) sign(req *http.Request, body []byte) {
+	now := time.Now().UTC()
+	sum := sha256.Sum256(body)
+	payload := hex.EncodeToString(sum[:])
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	if r.config.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", r.config.SessionToken)
+	}
+
+	names := []string{"host"}
+	values := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "content-type" {
+			names = append(names, lower)
+			values[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	sort.Strings(names)
+	var headers strings.Builder
+	for _, name := range names {
+		headers.WriteString(name + ":" + values[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+	request := strings.Join([]string{req.Method, uriEncode(req.URL.Path, false), req.URL.RawQuery, headers.String(), signed, payload}, "\n")
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, r.config.AccessKey, r.scope(now), signed, r.signature(now, request)))
+}
+
+func (r *S3) scope(t time.Time) string {
+	return t.Format("20060102") + "/" + r.config.Region + "/s3/aws4_request"
+}
+
+// signature signs the canonical request made at t.
+func (r *S3) signature(t time.Time, request string) string {
+	sum := sha256.Sum256([]byte(request))
+	toSign := strings.Join([]string{s3Algorithm, t.Format("20060102T150405Z"), r.scope(t), hex.EncodeToString(sum[:])}, "\n")
+	key := []byte("AWS4" + r.config.SecretKey)
+	for _, part := range []string{t.Format("20060102"), r.config.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery encodes query sorted by name, as signed.
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes s as Signature Version 4 does: all but the
+// unreserved characters, and the slashes when encodeSlash.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,60 @@
+package phoneagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrBudgetExceeded is returned when a task used the tokens or the cost of
+// its Budget.
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+type budgetKey struct{}
+
+// Budget bounds the model usage of a task, zero fields keep those of the
+// agent's config.
+type Budget struct {
+	MaxTokens int     // AgentConfig.MaxTaskTokens
+	MaxCost   float64 // AgentConfig.MaxTaskCost
+}
+
+// WithBudget returns ctx whose tasks run within budget.
+func WithBudget(ctx context.Context, budget Budget) context.Context {
+	if budget == (Budget{}) {
+		return ctx
+	}
+	return context.WithValue(ctx, budgetKey{}, budget)
+}
+
+// budgetOf returns the budget of the task of ctx.
+func (r *PhoneAgent) budgetOf(ctx context.Context) Budget {
+	budget, _ := ctx.Value(budgetKey{}).(Budget)
+	if budget.MaxTokens <= 0 {
+		budget.MaxTokens = r.AgentConfig.MaxTaskTokens
+	}
+	if budget.MaxCost <= 0 {
+		budget.MaxCost = r.AgentConfig.MaxTaskCost
+	}
+	return budget
+}
+
+// overBudget returns an ErrBudgetExceeded once the model usage of the task
+// reached its budget. It is checked between steps, so the last step may
+// overdraw it; responses without usage count nothing.
+func (r *PhoneAgent) overBudget(ctx context.Context) error {
+	budget := r.budgetOf(ctx)
+	if budget == (Budget{}) {
+		return nil
+	}
+	used := r.Usage.Total()
+	tokens := used.TotalTokens() - r.usageBase.TotalTokens()
+	cost := used.Cost - r.usageBase.Cost
+	switch {
+	case budget.MaxTokens > 0 && tokens >= budget.MaxTokens:
+		return fmt.Errorf("%w: %d tokens used of %d", ErrBudgetExceeded, tokens, budget.MaxTokens)
+	case budget.MaxCost > 0 && cost >= budget.MaxCost:
+		return fmt.Errorf("%w: cost %.4f of %.4f", ErrBudgetExceeded, cost, budget.MaxCost)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package phoneagent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"autoglm-go/phoneagent/calibration"
+	"autoglm-go/phoneagent/definitions"
+	logs "github.com/sirupsen/logrus"
+)
+
+// ShellDevice runs shell commands on the device, to switch the pointer
+// location overlay on while calibrating.
+type ShellDevice interface {
+	Shell(ctx context.Context, deviceID string, args ...string) (string, error)
+}
+
+const (
+	// calibrationCapture is waited into a long press before the screenshot
+	// showing its crosshair is taken.
+	calibrationCapture = 800 * time.Millisecond
+	// calibrationSettle is waited after Home for the launcher to settle.
+	calibrationSettle = time.Second
+	// calibrationMaxResidual is the fit error, in pixels, above which the
+	// measurements are likely wrong.
+	calibrationMaxResidual = 12
+)
+
+// calibrationTargets are the points pressed, as shares of the screen: near
+// the corners, where insets and cutouts show, and the center.
+var calibrationTargets = [][2]float64{{0.15, 0.15}, {0.85, 0.15}, {0.5, 0.5}, {0.15, 0.85}, {0.85, 0.85}}
+
+// Calibrate measures where taps land on the device and stores the correction
+// in AgentConfig.CalibrationFile, under its device id. With the pointer
+// location overlay on, it long-presses known points of the home screen and
+// reads where the crosshair is drawn; the matrix taking those points back to
+// the ones sent is applied to the taps of the model from then on.
+func (r *PhoneAgent) Calibrate(ctx context.Context) (calibration.Matrix, error) {
+	shell, ok := r.Device.(ShellDevice)
+	if !ok {
+		return calibration.Matrix{}, fmt.Errorf("devices of type %T cannot be calibrated", r.Device)
+	}
+	if r.AgentConfig.CalibrationFile == "" {
+		return calibration.Matrix{}, fmt.Errorf("calibrating requires a calibration file")
+	}
+	deviceID := r.AgentConfig.DeviceID
+
+	previous, err := shell.Shell(ctx, deviceID, "settings", "get", "system", "pointer_location")
+	if err != nil {
+		return calibration.Matrix{}, fmt.Errorf("failed to read the pointer location setting: %w", err)
+	}
+	if _, err := shell.Shell(ctx, deviceID, "settings", "put", "system", "pointer_location", "1"); err != nil {
+		return calibration.Matrix{}, fmt.Errorf("failed to show the pointer location: %w", err)
+	}
+	defer func() {
+		previous = strings.TrimSpace(previous)
+		if previous != "1" {
+			previous = "0"
+		}
+		_, _ = shell.Shell(context.WithoutCancel(ctx), deviceID, "settings", "put", "system", "pointer_location", previous)
+	}()
+
+	var samples []calibration.Sample
+	for _, target := range calibrationTargets {
+		sample, err := r.calibrationSample(ctx, target)
+		if err != nil {
+			return calibration.Matrix{}, err
+		}
+		r.log().Infof("📐 sent (%.0f, %.0f), landed at (%.0f, %.0f)", sample.Sent[0], sample.Sent[1], sample.Landed[0], sample.Landed[1])
+		samples = append(samples, sample)
+	}
+	_ = r.Device.Home(ctx, deviceID)
+
+	matrix, err := calibration.Fit(samples)
+	if err != nil {
+		return calibration.Matrix{}, err
+	}
+	if residual := matrix.Residual(samples); residual > calibrationMaxResidual {
+		return calibration.Matrix{}, fmt.Errorf("taps landed too irregularly to correct, off by up to %.0f pixels, keep the home screen still and try again", residual)
+	}
+	if err := calibration.Save(r.AgentConfig.CalibrationFile, deviceID, matrix); err != nil {
+		return calibration.Matrix{}, fmt.Errorf("failed to save the calibration: %w", err)
+	}
+	r.calibration = &matrix
+	return matrix, nil
+}
+
+// calibrationSample long-presses target on the home screen and finds where
+// it landed.
+func (r *PhoneAgent) calibrationSample(ctx context.Context, target [2]float64) (calibration.Sample, error) {
+	deviceID := r.AgentConfig.DeviceID
+	if err := r.Device.Home(ctx, deviceID); err != nil {
+		return calibration.Sample{}, fmt.Errorf("failed to go home: %w", err)
+	}
+	time.Sleep(calibrationSettle)
+	before, err := r.Device.GetScreenshot(ctx, deviceID)
+	if err != nil {
+		return calibration.Sample{}, fmt.Errorf("failed to take a screenshot: %w", err)
+	}
+	x, y := int(target[0]*float64(before.Width)), int(target[1]*float64(before.Height))
+
+	pressed := make(chan error, 1)
+	go func() { pressed <- r.Device.LongPress(ctx, x, y, deviceID) }()
+	time.Sleep(calibrationCapture)
+	during, shotErr := r.Device.GetScreenshot(ctx, deviceID)
+	if err := <-pressed; err != nil {
+		return calibration.Sample{}, fmt.Errorf("failed to press (%d, %d): %w", x, y, err)
+	}
+	if shotErr != nil {
+		return calibration.Sample{}, fmt.Errorf("failed to take a screenshot: %w", shotErr)
+	}
+	lx, ly, err := calibration.FindCrosshair(before.Data, during.Data)
+	if err != nil {
+		return calibration.Sample{}, fmt.Errorf("press at (%d, %d): %w", x, y, err)
+	}
+	return calibration.Sample{Sent: [2]float64{float64(x), float64(y)}, Landed: [2]float64{float64(lx), float64(ly)}}, nil
+}
+
+// newCalibration loads the correction of the device from
+// AgentConfig.CalibrationFile, nil when it has none.
+func newCalibration(config *definitions.AgentConfig) *calibration.Matrix {
+	if config.CalibrationFile == "" {
+		return nil
+	}
+	file, err := calibration.Load(config.CalibrationFile)
+	if err != nil {
+		logs.Errorf("failed to load the calibration, taps are not corrected, err: %v", err)
+		return nil
+	}
+	matrix, ok := file[config.DeviceID]
+	if !ok {
+		return nil
+	}
+	logs.Infof("📐 taps corrected by calibration %v", matrix)
+	return &matrix
+}
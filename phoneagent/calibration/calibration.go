@@ -0,0 +1,205 @@
+package calibration
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"os"
+	"sync"
+)
+
+// Matrix is an affine correction of tap points, in screenshot pixels:
+// x' = a*x + b*y + c and y' = d*x + e*y + f for [a, b, c, d, e, f].
+type Matrix [6]float64
+
+var Identity = Matrix{1, 0, 0, 0, 1, 0}
+
+// Apply returns where to send a tap meant for x, y.
+func (m Matrix) Apply(x, y int) (int, int) {
+	fx, fy := float64(x), float64(y)
+	return int(math.Round(m[0]*fx + m[1]*fy + m[2])), int(math.Round(m[3]*fx + m[4]*fy + m[5]))
+}
+
+// Sample is a calibration tap: the point sent to the device and where it
+// landed on the screen.
+type Sample struct {
+	Sent   [2]float64 `json:"sent"`
+	Landed [2]float64 `json:"landed"`
+}
+
+// Fit finds the matrix taking where taps landed to where they were sent, by
+// least squares, so that applied to a target it gives the point landing on
+// it. It needs three samples not on a line.
+func Fit(samples []Sample) (Matrix, error) {
+	if len(samples) < 3 {
+		return Matrix{}, fmt.Errorf("calibration needs 3 samples, got %d", len(samples))
+	}
+	// normal equations of [x y 1] . coefficients = sent, shared by both axes
+	var ata [3][3]float64
+	var atx, aty [3]float64
+	for _, s := range samples {
+		row := [3]float64{s.Landed[0], s.Landed[1], 1}
+		for i := range row {
+			for j := range row {
+				ata[i][j] += row[i] * row[j]
+			}
+			atx[i] += row[i] * s.Sent[0]
+			aty[i] += row[i] * s.Sent[1]
+		}
+	}
+	cx, okX := solve3(ata, atx)
+	cy, okY := solve3(ata, aty)
+	if !okX || !okY {
+		return Matrix{}, errors.New("calibration samples are on a line")
+	}
+	return Matrix{cx[0], cx[1], cx[2], cy[0], cy[1], cy[2]}, nil
+}
+
+// solve3 solves a 3x3 system by Cramer's rule.
+func solve3(a [3][3]float64, b [3]float64) ([3]float64, bool) {
+	det := func(m [3][3]float64) float64 {
+		return m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+			m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+			m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	}
+	d := det(a)
+	if math.Abs(d) < 1e-9 {
+		return [3]float64{}, false
+	}
+	var x [3]float64
+	for col := range x {
+		m := a
+		for row := range m {
+			m[row][col] = b[row]
+		}
+		x[col] = det(m) / d
+	}
+	return x, true
+}
+
+// Residual is the largest distance, in pixels, between a sample sent and
+// where m puts its landing point.
+func (m Matrix) Residual(samples []Sample) float64 {
+	worst := 0.0
+	for _, s := range samples {
+		x := m[0]*s.Landed[0] + m[1]*s.Landed[1] + m[2]
+		y := m[3]*s.Landed[0] + m[4]*s.Landed[1] + m[5]
+		worst = max(worst, math.Hypot(x-s.Sent[0], y-s.Sent[1]))
+	}
+	return worst
+}
+
+// File holds the matrices of the calibrated devices, by device id.
+type File map[string]Matrix
+
+// fileMu serializes the updates of the calibration file within a process.
+var fileMu sync.Mutex
+
+// Load reads a calibration file, empty when it does not exist yet.
+func Load(path string) (File, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return File{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	file := File{}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid calibration file %s: %w", path, err)
+	}
+	return file, nil
+}
+
+// Save stores the matrix of deviceID in the calibration file, keeping the
+// other devices.
+func Save(path, deviceID string, m Matrix) error {
+	fileMu.Lock()
+	defer fileMu.Unlock()
+	file, err := Load(path)
+	if err != nil {
+		return err
+	}
+	file[deviceID] = m
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+const (
+	// changeThreshold is the color difference, summed over the channels,
+	// of a pixel the pointer trace drew on.
+	changeThreshold = 96
+	// lineCoverage is the share of a row or column the crosshair covers.
+	lineCoverage = 0.6
+)
+
+// FindCrosshair returns where the crosshair of the pointer location overlay
+// is on after but not on before, both screenshots of the same size: the
+// center of the thinnest run of rows, and of columns, changed almost across
+// the whole screen. Thicker runs are other changes of the screen, e.g. a
+// popup or the overlay header.
+func FindCrosshair(before, after []byte) (x, y int, err error) {
+	a, _, err := image.Decode(bytes.NewReader(before))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to decode screenshot: %w", err)
+	}
+	b, _, err := image.Decode(bytes.NewReader(after))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to decode screenshot: %w", err)
+	}
+	minA, minB := a.Bounds().Min, b.Bounds().Min
+	if a.Bounds().Size() != b.Bounds().Size() {
+		return 0, 0, errors.New("screenshots differ in size")
+	}
+	width, height := b.Bounds().Dx(), b.Bounds().Dy()
+	rows, cols := make([]int, height), make([]int, width)
+	for py := 0; py < height; py++ {
+		for px := 0; px < width; px++ {
+			r1, g1, b1, _ := a.At(minA.X+px, minA.Y+py).RGBA()
+			r2, g2, b2, _ := b.At(minB.X+px, minB.Y+py).RGBA()
+			if diff(r1, r2)+diff(g1, g2)+diff(b1, b2) > changeThreshold {
+				rows[py]++
+				cols[px]++
+			}
+		}
+	}
+	var okX, okY bool
+	x, okX = thinnestRun(cols, int(lineCoverage*float64(height)))
+	y, okY = thinnestRun(rows, int(lineCoverage*float64(width)))
+	if !okX || !okY {
+		return 0, 0, errors.New("no pointer crosshair on the screen")
+	}
+	return x, y, nil
+}
+
+func diff(a, b uint32) int {
+	return int(math.Abs(float64(a>>8) - float64(b>>8)))
+}
+
+// thinnestRun returns the center of the thinnest run of counts of at least
+// threshold.
+func thinnestRun(counts []int, threshold int) (int, bool) {
+	best, bestWidth := 0, math.MaxInt
+	for i := 0; i < len(counts); {
+		if counts[i] < threshold {
+			i++
+			continue
+		}
+		start := i
+		for i < len(counts) && counts[i] >= threshold {
+			i++
+		}
+		if i-start < bestWidth {
+			best, bestWidth = (start+i-1)/2, i-start
+		}
+	}
+	return best, bestWidth != math.MaxInt
+}
@@ -0,0 +1,44 @@
+package phoneagent
+
+import (
+	"context"
+
+	"autoglm-go/phoneagent/captcha"
+)
+
+// checkCaptcha hands a captcha screen to the captcha handlers before the
+// model sees it, and returns the observation to continue with. An error means
+// the captcha could not be solved and the task should stop.
+func (r *PhoneAgent) checkCaptcha(ctx context.Context, obs *observation) (*observation, error) {
+	if len(r.Captcha) == 0 || obs.screenshot == nil || r.AgentConfig.DryRun || r.AgentConfig.ReadOnly {
+		return obs, nil
+	}
+	deviceID := r.AgentConfig.DeviceID
+
+	elements := obs.uiElements
+	if elements == nil {
+		var err error
+		if elements, err = r.Device.DumpUI(ctx, deviceID); err != nil {
+			return obs, nil
+		}
+	}
+	detection := captcha.Detect(elements, r.uiLanguage)
+	if detection == nil {
+		return obs, nil
+	}
+	r.log().Warnf("🧩 %s captcha detected on %s: %q", detection.Kind, obs.currentApp, detection.Matched)
+
+	err := r.Captcha.Handle(ctx, &captcha.Context{
+		Device:     r.Device,
+		DeviceID:   deviceID,
+		Detection:  detection,
+		Screenshot: obs.screenshot,
+		Elements:   elements,
+		Language:   r.uiLanguage,
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.lastUIElements = nil
+	return r.captureObservation(ctx), nil
+}
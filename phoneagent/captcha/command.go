@@ -0,0 +1,71 @@
+package captcha
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+
+	logs "github.com/sirupsen/logrus"
+)
+
+// CommandSolver runs an external program as solver. The program gets the
+// kind and the path of the screenshot as arguments, the detection and UI
+// dump as JSON on stdin, and prints a Solution as JSON on stdout.
+type CommandSolver struct {
+	Command []string
+}
+
+func NewCommandSolver(command ...string) *CommandSolver {
+	return &CommandSolver{Command: command}
+}
+
+func (r *CommandSolver) Solve(ctx context.Context, c *Context) (*Solution, error) {
+	if len(r.Command) == 0 {
+		return nil, fmt.Errorf("no solver command")
+	}
+
+	file, err := os.CreateTemp("", "autoglm-captcha-*.png")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(c.Screenshot.Data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	input, err := json.Marshal(map[string]any{
+		"kind":     c.Detection.Kind,
+		"matched":  c.Detection.Matched,
+		"element":  c.Detection.Element,
+		"elements": c.Elements,
+		"width":    c.Screenshot.Width,
+		"height":   c.Screenshot.Height,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	args := append(append([]string{}, r.Command[1:]...), string(c.Detection.Kind), file.Name())
+	cmd := exec.CommandContext(ctx, r.Command[0], args...)
+	cmd.Stdin = bytes.NewReader(input)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	logs.Debugf("[CaptchaSolver] run cmd: %v", cmd.Args)
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("captcha solver failed: %w, stderr: %s", err, stderr.String())
+	}
+	var solution Solution
+	if err := json.Unmarshal(output, &solution); err != nil {
+		return nil, fmt.Errorf("invalid captcha solver output: %w", err)
+	}
+	return &solution, nil
+}
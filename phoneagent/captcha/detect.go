@@ -0,0 +1,114 @@
+package captcha
+
+import (
+	"strings"
+
+	"autoglm-go/phoneagent/definitions"
+	"autoglm-go/phoneagent/uilang"
+)
+
+type Kind string
+
+const (
+	Slider      Kind = "slider"       // drag a piece or a slider
+	ImageSelect Kind = "image_select" // tap images or characters in order
+	TextCode    Kind = "text_code"    // type the characters of an image
+	Generic     Kind = "generic"      // risk control, human check of unknown type
+)
+
+// Detection is a captcha found on the screen.
+type Detection struct {
+	Kind    Kind
+	Matched string // the text that gave it away
+	Element *definitions.UIElement
+}
+
+type kindWords struct {
+	kind  Kind
+	words []string
+}
+
+// keywords per kind, matched case-insensitively against texts and content
+// descriptions of the UI dump. Specific kinds are checked first.
+var keywords = []kindWords{
+	{Slider, []string{"滑动验证", "拖动滑块", "向右滑动", "拖动下方滑块", "按住滑块", "请拖动", "slide to verify", "drag the slider", "slide to complete"}},
+	{ImageSelect, []string{"依次点击", "请点击图中", "点击下图", "选出所有", "请选择所有", "select all images", "click on all", "tap all"}},
+	{TextCode, []string{"图形验证码", "请输入图中", "输入图片中", "看不清", "enter the characters", "type the text"}},
+	{Generic, []string{"安全验证", "人机验证", "请完成验证", "环境异常", "访问异常", "操作频繁", "captcha", "verify you are human", "i'm not a robot", "are you a robot", "unusual traffic"}},
+}
+
+// localKeywords are checked on top of keywords when the UI is in that
+// language, keyed by ISO 639 code.
+var localKeywords = map[string][]kindWords{
+	"ja": {
+		{Slider, []string{"スライドして", "スライダーを", "右にスライド"}},
+		{ImageSelect, []string{"画像をすべて選択", "順番にクリック", "順番にタップ"}},
+		{TextCode, []string{"画像の文字を入力", "表示されている文字"}},
+		{Generic, []string{"ロボットではありません", "認証してください", "セキュリティ認証"}},
+	},
+	"ko": {
+		{Slider, []string{"슬라이드하여", "슬라이더를"}},
+		{ImageSelect, []string{"이미지를 모두 선택", "순서대로 클릭"}},
+		{TextCode, []string{"보이는 문자를 입력", "자동입력 방지"}},
+		{Generic, []string{"로봇이 아닙니다", "보안 인증"}},
+	},
+	"de": {
+		{Slider, []string{"schieberegler", "zum verifizieren schieben"}},
+		{ImageSelect, []string{"wählen sie alle bilder", "alle bilder mit"}},
+		{TextCode, []string{"zeichen eingeben", "geben sie die zeichen"}},
+		{Generic, []string{"ich bin kein roboter", "sicherheitsüberprüfung", "bestätigen sie, dass sie ein mensch sind"}},
+	},
+	"fr": {
+		{Slider, []string{"faites glisser", "glissez pour vérifier"}},
+		{ImageSelect, []string{"sélectionnez toutes les images", "cliquez sur toutes"}},
+		{TextCode, []string{"saisissez les caractères", "entrez les caractères"}},
+		{Generic, []string{"je ne suis pas un robot", "vérification de sécurité", "êtes-vous un robot"}},
+	},
+	"es": {
+		{Slider, []string{"desliza para verificar", "arrastra el control"}},
+		{ImageSelect, []string{"selecciona todas las imágenes", "haz clic en todas"}},
+		{TextCode, []string{"escribe los caracteres", "introduce los caracteres"}},
+		{Generic, []string{"no soy un robot", "verificación de seguridad"}},
+	},
+	"pt": {
+		{Slider, []string{"deslize para verificar", "arraste o controle"}},
+		{ImageSelect, []string{"selecione todas as imagens"}},
+		{TextCode, []string{"digite os caracteres"}},
+		{Generic, []string{"não sou um robô", "verificação de segurança"}},
+	},
+	"ru": {
+		{Slider, []string{"передвиньте ползунок", "сдвиньте ползунок"}},
+		{ImageSelect, []string{"выберите все изображения"}},
+		{TextCode, []string{"введите символы", "введите текст с картинки"}},
+		{Generic, []string{"я не робот", "проверка безопасности", "подтвердите, что вы не робот"}},
+	},
+}
+
+// Detect looks for a captcha or risk verification screen in the UI dump.
+// lang is the UI language, nil matches the built-in Chinese and English
+// keywords only.
+func Detect(elements []definitions.UIElement, lang *uilang.Language) *Detection {
+	if lang == nil {
+		lang = uilang.New("")
+	}
+	local := localKeywords[lang.Base()]
+
+	for _, group := range keywords {
+		words := group.words
+		for _, l := range local {
+			if l.kind == group.kind {
+				words = append(l.words[:len(l.words):len(l.words)], words...)
+			}
+		}
+		for i := range elements {
+			e := &elements[i]
+			text := lang.Fold(e.Text + " " + e.ContentDesc)
+			for _, word := range words {
+				if strings.Contains(text, lang.Fold(word)) {
+					return &Detection{Kind: group.kind, Matched: word, Element: e}
+				}
+			}
+		}
+	}
+	return nil
+}
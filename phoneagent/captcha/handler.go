@@ -0,0 +1,133 @@
+package captcha
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"autoglm-go/phoneagent/definitions"
+	"autoglm-go/phoneagent/uilang"
+	logs "github.com/sirupsen/logrus"
+)
+
+var ErrUnsolved = errors.New("captcha not solved")
+
+// Device is the part of the device driver the handlers use.
+type Device interface {
+	GetScreenshot(ctx context.Context, deviceID string) (*definitions.Screenshot, error)
+	Tap(ctx context.Context, x, y int, deviceID string) error
+	Swipe(ctx context.Context, startX, startY, endX, endY int, deviceID string) error
+	TypeText(ctx context.Context, text, deviceID string) error
+	DumpUI(ctx context.Context, deviceID string) ([]definitions.UIElement, error)
+}
+
+// Context is a detected captcha and the screen it is on.
+type Context struct {
+	Device     Device
+	DeviceID   string
+	Detection  *Detection
+	Screenshot *definitions.Screenshot
+	Elements   []definitions.UIElement
+	Language   *uilang.Language // UI language for detection, optional
+}
+
+// Resolved reports whether the captcha has gone from the screen.
+func (c *Context) Resolved(ctx context.Context) bool {
+	elements, err := c.Device.DumpUI(ctx, c.DeviceID)
+	return err == nil && Detect(elements, c.Language) == nil
+}
+
+// Handler tries to get past a captcha. It returns true when the captcha is
+// gone, false to let the next handler of the chain try.
+type Handler interface {
+	Handle(ctx context.Context, c *Context) (bool, error)
+}
+
+// Chain runs handlers in order until one resolves the captcha.
+type Chain []Handler
+
+func (r Chain) Handle(ctx context.Context, c *Context) error {
+	for _, handler := range r {
+		ok, err := handler.Handle(ctx, c)
+		if err != nil {
+			logs.Warnf("captcha handler %T failed, err: %v", handler, err)
+		}
+		if ok {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return ErrUnsolved
+}
+
+// Solution is what a solver wants done, in pixels of the screenshot: taps in
+// order, then swipes, then text typed into the focused input.
+type Solution struct {
+	Taps   [][2]int `json:"taps,omitempty"`
+	Swipes [][4]int `json:"swipes,omitempty"`
+	Text   string   `json:"text,omitempty"`
+}
+
+// Solver computes a solution from the captcha screen.
+type Solver interface {
+	Solve(ctx context.Context, c *Context) (*Solution, error)
+}
+
+// SolverHandler applies the solution of a solver and retries up to Attempts
+// times while the captcha stays on the screen.
+type SolverHandler struct {
+	Solver   Solver
+	Attempts int
+}
+
+func (r *SolverHandler) Handle(ctx context.Context, c *Context) (bool, error) {
+	attempts := max(r.Attempts, 1)
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			screenshot, err := c.Device.GetScreenshot(ctx, c.DeviceID)
+			if err != nil {
+				return false, err
+			}
+			c.Screenshot = screenshot
+		}
+
+		solution, err := r.Solver.Solve(ctx, c)
+		if err != nil {
+			return false, err
+		}
+		if err := apply(ctx, c, solution); err != nil {
+			return false, err
+		}
+
+		time.Sleep(2 * time.Second)
+		if c.Resolved(ctx) {
+			logs.Infof("🧩 %s captcha solved", c.Detection.Kind)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func apply(ctx context.Context, c *Context, solution *Solution) error {
+	if solution == nil {
+		return fmt.Errorf("empty solution")
+	}
+	for _, p := range solution.Taps {
+		if err := c.Device.Tap(ctx, p[0], p[1], c.DeviceID); err != nil {
+			return err
+		}
+		time.Sleep(300 * time.Millisecond)
+	}
+	for _, s := range solution.Swipes {
+		if err := c.Device.Swipe(ctx, s[0], s[1], s[2], s[3], c.DeviceID); err != nil {
+			return err
+		}
+	}
+	if solution.Text != "" {
+		return c.Device.TypeText(ctx, solution.Text, c.DeviceID)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package captcha
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	logs "github.com/sirupsen/logrus"
+)
+
+// HumanHandler escalates to an operator. It serves a live view of the device
+// at Addr where clicks on the screen are taps and drags are swipes, and
+// waits until the operator marks the captcha done or gives up.
+type HumanHandler struct {
+	Addr    string        // listen address, port 0 picks a free one
+	Timeout time.Duration // how long to wait for the operator, 0 means 5 minutes
+
+	// Notify is called with the live view URL, e.g. to send it to a chat.
+	Notify func(url string, c *Context)
+}
+
+func (r *HumanHandler) Handle(ctx context.Context, c *Context) (bool, error) {
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Minute
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	addr := r.Addr
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return false, err
+	}
+
+	result := make(chan bool, 1)
+	server := &http.Server{Handler: liveView(c, result)}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Close()
+
+	url := fmt.Sprintf("http://%s/", listener.Addr())
+	logs.Warnf("🧩 %s captcha (%q), solve it at %s", c.Detection.Kind, c.Detection.Matched, url)
+	if r.Notify != nil {
+		r.Notify(url, c)
+	}
+
+	select {
+	case done := <-result:
+		if !done {
+			return false, nil
+		}
+		return c.Resolved(ctx), nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+func liveView(c *Context, result chan<- bool) http.Handler {
+	intParam := func(req *http.Request, name string) int {
+		v, _ := strconv.Atoi(req.FormValue(name))
+		return v
+	}
+	finish := func(done bool) {
+		select {
+		case result <- done:
+		default:
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = fmt.Fprintf(w, liveViewPage, c.Detection.Kind, c.Detection.Matched)
+	})
+	mux.HandleFunc("/screen.png", func(w http.ResponseWriter, req *http.Request) {
+		screenshot, err := c.Device.GetScreenshot(req.Context(), c.DeviceID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "no-store")
+		_, _ = w.Write(screenshot.Data)
+	})
+	mux.HandleFunc("POST /tap", func(w http.ResponseWriter, req *http.Request) {
+		if err := c.Device.Tap(req.Context(), intParam(req, "x"), intParam(req, "y"), c.DeviceID); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		}
+	})
+	mux.HandleFunc("POST /swipe", func(w http.ResponseWriter, req *http.Request) {
+		err := c.Device.Swipe(req.Context(), intParam(req, "x1"), intParam(req, "y1"),
+			intParam(req, "x2"), intParam(req, "y2"), c.DeviceID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		}
+	})
+	mux.HandleFunc("POST /done", func(w http.ResponseWriter, req *http.Request) {
+		finish(true)
+	})
+	mux.HandleFunc("POST /give-up", func(w http.ResponseWriter, req *http.Request) {
+		finish(false)
+	})
+	return mux
+}
+
+const liveViewPage = `<!doctype html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width">
+<title>captcha</title>
+<style>body{font-family:sans-serif;margin:12px}img{max-height:85vh;border:1px solid #888;cursor:crosshair;user-select:none}</style>
+</head><body>
+<p>%s captcha: %q. Click to tap, drag to swipe, then press Done.
+<button onclick="post('/done')">Done</button> <button onclick="post('/give-up')">Give up</button></p>
+<img id="screen" src="/screen.png" draggable="false">
+<script>
+const img = document.getElementById('screen');
+let start = null;
+function post(path, params) {
+  return fetch(path, {method: 'POST', body: new URLSearchParams(params || {})});
+}
+function point(e) {
+  const r = img.getBoundingClientRect();
+  return [Math.round((e.clientX - r.left) * img.naturalWidth / r.width),
+          Math.round((e.clientY - r.top) * img.naturalHeight / r.height)];
+}
+img.addEventListener('mousedown', e => { start = point(e); e.preventDefault(); });
+img.addEventListener('mouseup', e => {
+  if (!start) return;
+  const end = point(e);
+  if (Math.hypot(end[0] - start[0], end[1] - start[1]) < 10) post('/tap', {x: start[0], y: start[1]});
+  else post('/swipe', {x1: start[0], y1: start[1], x2: end[0], y2: end[1]});
+  start = null;
+});
+setInterval(() => { img.src = '/screen.png?t=' + Date.now(); }, 800);
+</script>
+</body></html>`
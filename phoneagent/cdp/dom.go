@@ -0,0 +1,117 @@
+package cdp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// Element is an interactive DOM element, Rect is left, top, right, bottom in
+// CSS pixels of the viewport.
+type Element struct {
+	Tag  string     `json:"tag"`
+	Type string     `json:"type,omitempty"`
+	Text string     `json:"text"`
+	Rect [4]float64 `json:"rect"`
+}
+
+// Snapshot is the visible part of a page.
+type Snapshot struct {
+	URL              string    `json:"url"`
+	Title            string    `json:"title"`
+	DevicePixelRatio float64   `json:"dpr"`
+	Width            float64   `json:"width"`  // viewport in CSS pixels
+	Height           float64   `json:"height"` // viewport in CSS pixels
+	Elements         []Element `json:"elements"`
+}
+
+const interactiveSelector = `a[href],button,input:not([type=hidden]),textarea,select,summary,label,` +
+	`[role=button],[role=link],[role=tab],[role=menuitem],[role=checkbox],[onclick],[contenteditable=""],[contenteditable=true]`
+
+const snapshotScript = `((limit) => {
+  const sel = %s;
+  const out = [];
+  for (const el of document.querySelectorAll(sel)) {
+    const r = el.getBoundingClientRect();
+    if (r.width < 1 || r.height < 1 || r.bottom <= 0 || r.right <= 0 || r.top >= innerHeight || r.left >= innerWidth) continue;
+    const s = getComputedStyle(el);
+    if (s.visibility === 'hidden' || s.display === 'none' || s.opacity === '0') continue;
+    const text = (el.innerText || el.value || el.placeholder || el.getAttribute('aria-label') || el.title || '')
+      .trim().replace(/\s+/g, ' ').slice(0, 60);
+    out.push({tag: el.tagName.toLowerCase(), type: el.type || '', text,
+      rect: [Math.max(r.left, 0), Math.max(r.top, 0), Math.min(r.right, innerWidth), Math.min(r.bottom, innerHeight)]});
+    if (out.length >= limit) break;
+  }
+  return {url: location.href, title: document.title, dpr: devicePixelRatio,
+    width: innerWidth, height: innerHeight, elements: out};
+})(%d)`
+
+// Snapshot lists up to limit visible interactive elements.
+func (p *Page) Snapshot(ctx context.Context, limit int) (*Snapshot, error) {
+	var snapshot Snapshot
+	if err := p.Evaluate(ctx, fmt.Sprintf(snapshotScript, jsString(interactiveSelector), limit), &snapshot); err != nil {
+		return nil, err
+	}
+	p.URL, p.Title = snapshot.URL, snapshot.Title
+	return &snapshot, nil
+}
+
+const clickScript = `((x, y, radius) => {
+  const sel = %s;
+  let el = document.elementFromPoint(x, y);
+  el = el && el.closest(sel);
+  if (!el) {
+    let best = null, bestDist = radius;
+    for (const c of document.querySelectorAll(sel)) {
+      const r = c.getBoundingClientRect();
+      if (r.width < 1 || r.height < 1) continue;
+      const d = Math.hypot(Math.max(r.left - x, 0, x - r.right), Math.max(r.top - y, 0, y - r.bottom));
+      if (d <= bestDist) { best = c; bestDist = d; }
+    }
+    el = best;
+  }
+  if (!el) return '';
+  if (el.focus) el.focus();
+  el.click();
+  return (el.tagName.toLowerCase() + ' ' + (el.innerText || el.value || el.getAttribute('aria-label') || '').trim().slice(0, 40)).trim();
+})(%g, %g, %g)`
+
+// ClickAt clicks the interactive element at x, y in CSS pixels, or the
+// nearest one within radius. It returns a short description of the element,
+// empty when there was nothing to click.
+func (p *Page) ClickAt(ctx context.Context, x, y, radius float64) (string, error) {
+	var clicked string
+	if err := p.Evaluate(ctx, fmt.Sprintf(clickScript, jsString(interactiveSelector), x, y, radius), &clicked); err != nil {
+		return "", err
+	}
+	return clicked, nil
+}
+
+const selectEditableScript = `(() => {
+  const el = document.activeElement;
+  if (!el) return false;
+  const noText = ['button', 'submit', 'reset', 'checkbox', 'radio', 'file', 'range', 'color', 'image'];
+  const editable = el.isContentEditable || el.tagName === 'TEXTAREA' ||
+    (el.tagName === 'INPUT' && !noText.includes(el.type));
+  if (!editable) return false;
+  if (el.select) el.select(); else document.execCommand('selectAll');
+  return true;
+})()`
+
+// InsertText replaces the content of the focused editable element with text.
+// It reports false when no editable element has focus.
+func (p *Page) InsertText(ctx context.Context, text string) (bool, error) {
+	var editable bool
+	if err := p.Evaluate(ctx, selectEditableScript, &editable); err != nil || !editable {
+		return false, err
+	}
+	if err := p.conn.Call(ctx, "Input.insertText", map[string]any{"text": text}, nil); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func jsString(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
@@ -0,0 +1,194 @@
+package cdp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	logs "github.com/sirupsen/logrus"
+)
+
+var ErrNoDevTools = errors.New("no devtools socket for app")
+
+// Device is what is needed of the device driver to reach a DevTools socket.
+type Device interface {
+	Shell(ctx context.Context, deviceID string, args ...string) (string, error)
+	Forward(ctx context.Context, deviceID, remote string) (int, error)
+	RemoveForward(ctx context.Context, deviceID string, port int) error
+}
+
+// Page is the visible page of a Chrome tab or an app WebView.
+type Page struct {
+	Package string
+	URL     string
+	Title   string
+
+	conn     *Conn
+	device   Device
+	deviceID string
+	port     int
+}
+
+type target struct {
+	Type                 string `json:"type"`
+	URL                  string `json:"url"`
+	Title                string `json:"title"`
+	WebSocketDebuggerURL string `json:"webSocketDebuggerUrl"`
+}
+
+const chromePackage = "com.android.chrome"
+
+// Attach connects to the visible page of packageName. Chrome exposes
+// chrome_devtools_remote; WebViews expose webview_devtools_remote_<pid> when
+// the app enabled WebView debugging. ErrNoDevTools means neither is there.
+func Attach(ctx context.Context, device Device, deviceID, packageName string) (*Page, error) {
+	socket, err := findSocket(ctx, device, deviceID, packageName)
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := device.Forward(ctx, deviceID, "localabstract:"+socket)
+	if err != nil {
+		return nil, err
+	}
+	page := &Page{
+		Package:  packageName,
+		device:   device,
+		deviceID: deviceID,
+		port:     port,
+	}
+
+	if err := page.connect(ctx); err != nil {
+		page.Close()
+		return nil, err
+	}
+	logs.Debugf("devtools attached to %s: %s", packageName, page.URL)
+	return page, nil
+}
+
+func findSocket(ctx context.Context, device Device, deviceID, packageName string) (string, error) {
+	output, err := device.Shell(ctx, deviceID, "cat", "/proc/net/unix")
+	if err != nil {
+		return "", err
+	}
+	sockets := map[string]bool{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		name := fields[len(fields)-1]
+		if strings.HasPrefix(name, "@") && strings.Contains(name, "devtools_remote") {
+			sockets[name[1:]] = true
+		}
+	}
+
+	if packageName == chromePackage && sockets["chrome_devtools_remote"] {
+		return "chrome_devtools_remote", nil
+	}
+
+	pid, err := device.Shell(ctx, deviceID, "pidof", packageName)
+	if err != nil {
+		return "", ErrNoDevTools
+	}
+	for _, p := range strings.Fields(pid) {
+		if name := "webview_devtools_remote_" + p; sockets[name] {
+			return name, nil
+		}
+	}
+	return "", ErrNoDevTools
+}
+
+// connect picks the first visible page target.
+func (p *Page) connect(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://127.0.0.1:%d/json/list", p.port), nil)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to list devtools targets: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var targets []target
+	if err := json.NewDecoder(resp.Body).Decode(&targets); err != nil {
+		return fmt.Errorf("invalid devtools target list: %w", err)
+	}
+
+	for _, t := range targets {
+		if t.Type != "page" || t.WebSocketDebuggerURL == "" {
+			continue
+		}
+		conn, err := Dial(ctx, t.WebSocketDebuggerURL)
+		if err != nil {
+			continue
+		}
+
+		var state string
+		if err := evaluate(ctx, conn, "document.visibilityState", &state); err != nil || state != "visible" {
+			conn.Close()
+			continue
+		}
+		p.conn, p.URL, p.Title = conn, t.URL, t.Title
+		return nil
+	}
+	return fmt.Errorf("no visible page in %s", p.Package)
+}
+
+// Alive reports whether the page can still be used.
+func (p *Page) Alive(ctx context.Context) bool {
+	if p.conn == nil || !p.conn.Alive() {
+		return false
+	}
+	var state string
+	return evaluate(ctx, p.conn, "document.visibilityState", &state) == nil && state == "visible"
+}
+
+func (p *Page) Close() {
+	if p.conn != nil {
+		_ = p.conn.Close()
+	}
+	if p.port != 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := p.device.RemoveForward(ctx, p.deviceID, p.port); err != nil {
+			logs.Debugf("failed to remove devtools forward: %v", err)
+		}
+	}
+}
+
+// Evaluate runs a JavaScript expression and decodes its value into out.
+func (p *Page) Evaluate(ctx context.Context, expression string, out any) error {
+	return evaluate(ctx, p.conn, expression, out)
+}
+
+func evaluate(ctx context.Context, conn *Conn, expression string, out any) error {
+	var result struct {
+		Result struct {
+			Value json.RawMessage `json:"value"`
+		} `json:"result"`
+		ExceptionDetails *struct {
+			Text string `json:"text"`
+		} `json:"exceptionDetails"`
+	}
+	err := conn.Call(ctx, "Runtime.evaluate", map[string]any{
+		"expression":    expression,
+		"returnByValue": true,
+	}, &result)
+	if err != nil {
+		return err
+	}
+	if result.ExceptionDetails != nil {
+		return fmt.Errorf("javascript error: %s", result.ExceptionDetails.Text)
+	}
+	if out != nil && len(result.Result.Value) > 0 {
+		return json.Unmarshal(result.Result.Value, out)
+	}
+	return nil
+}
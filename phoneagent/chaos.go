@@ -0,0 +1,125 @@
+package phoneagent
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"autoglm-go/phoneagent/definitions"
+	"autoglm-go/phoneagent/llm"
+	logs "github.com/sirupsen/logrus"
+)
+
+// errChaosDisconnect fails the action of a step the device dropped off in.
+var errChaosDisconnect = errors.New("device disconnected (chaos)")
+
+const (
+	defaultChaosDelay   = 10 * time.Second
+	defaultChaosOffline = 5 * time.Second
+)
+
+// chaos injects the faults of AgentConfig.Chaos. A nil chaos injects none, so
+// the agent calls it unconditionally. It is safe for concurrent use, the next
+// observation is captured in the background.
+type chaos struct {
+	config definitions.ChaosConfig
+
+	mu           sync.Mutex
+	rng          *rand.Rand
+	offlineUntil time.Time
+}
+
+func newChaos(config definitions.ChaosConfig) *chaos {
+	if !config.Enabled() {
+		return nil
+	}
+	if config.SlowModelDelay <= 0 {
+		config.SlowModelDelay = defaultChaosDelay
+	}
+	if config.DisconnectFor <= 0 {
+		config.DisconnectFor = defaultChaosOffline
+	}
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	logs.Warnf("🐒 chaos mode: disconnect %.2f, slow model %.2f, malformed action %.2f, screenshot failure %.2f, seed %d",
+		config.Disconnect, config.SlowModel, config.MalformedAction, config.ScreenshotFailure, seed)
+	return &chaos{config: config, rng: rand.New(rand.NewSource(seed))}
+}
+
+func (c *chaos) roll(rate float64, fault string) bool {
+	if rate <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	hit := c.rng.Float64() < rate
+	c.mu.Unlock()
+	if hit {
+		logs.Warnf("🐒 chaos: %s", fault)
+	}
+	return hit
+}
+
+// disconnect takes the device offline for DisconnectFor, as seen by the
+// agent, and returns the error the action fails with.
+func (c *chaos) disconnect() error {
+	if c == nil || !c.roll(c.config.Disconnect, "device disconnected") {
+		return nil
+	}
+	c.mu.Lock()
+	c.offlineUntil = time.Now().Add(c.config.DisconnectFor)
+	c.mu.Unlock()
+	return errChaosDisconnect
+}
+
+// offline reports whether the device is still disconnected by chaos.
+func (c *chaos) offline() bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Now().Before(c.offlineUntil)
+}
+
+// slowModel delays a model request, or ends it with ctx.
+func (c *chaos) slowModel(ctx context.Context) error {
+	if c == nil || !c.roll(c.config.SlowModel, "slow model response") {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(c.config.SlowModelDelay):
+		return nil
+	}
+}
+
+// malformAction cuts the answer of the model in half, so that it no longer
+// parses.
+func (c *chaos) malformAction(response *llm.ModelResponse) {
+	if c == nil || !c.roll(c.config.MalformedAction, "malformed action") {
+		return
+	}
+	runes := []rune(response.Action)
+	response.Action = string(runes[:len(runes)/2])
+	response.ToolAction = nil
+}
+
+// failScreenshot replaces screenshot with the empty one a failed capture
+// gives.
+func (c *chaos) failScreenshot(screenshot *definitions.Screenshot) *definitions.Screenshot {
+	if c == nil || screenshot == nil || !c.roll(c.config.ScreenshotFailure, "screenshot failed") {
+		return screenshot
+	}
+	return &definitions.Screenshot{Width: screenshot.Width, Height: screenshot.Height}
+}
+
+// isConnected is Device.IsConnected, false while chaos holds the device
+// offline.
+func (r *PhoneAgent) isConnected(ctx context.Context) bool {
+	return !r.chaos.offline() && r.Device.IsConnected(ctx, r.AgentConfig.DeviceID)
+}
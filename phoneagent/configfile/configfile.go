@@ -0,0 +1,271 @@
+package configfile
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	logs "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Values are the settings of a config file by key, the name of a command
+// line flag such as max-steps, or of an environment variable without its
+// PHONE_AGENT_ prefix such as soft-deadline. Lists have several values.
+type Values map[string][]string
+
+// Get returns the value of key, joined by commas when it is a list.
+func (v Values) Get(key string) (string, bool) {
+	values, ok := v[key]
+	return strings.Join(values, ","), ok
+}
+
+// Keys returns the keys of v, sorted.
+func (v Values) Keys() []string {
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// NormalizeKey writes key as a flag name: lower case with dashes, without the
+// PHONE_AGENT_ prefix of environment variables.
+func NormalizeKey(key string) string {
+	key = strings.TrimSpace(key)
+	if strings.HasPrefix(strings.ToUpper(key), "PHONE_AGENT_") {
+		key = key[len("PHONE_AGENT_"):]
+	}
+	return strings.ReplaceAll(strings.ToLower(key), "_", "-")
+}
+
+// Load reads a YAML (.yaml, .yml) or TOML (.toml) config file. Tables group
+// settings, as model or device, and are flattened: their keys are those of
+// the top level.
+//
+//	lang: en
+//	model:
+//	  base-url: http://localhost:8000/v1
+//	  model: autoglm-phone-9b
+//	device:
+//	  device-id: emulator-5554
+func Load(path string) (Values, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := Values{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		var doc map[string]any
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+		err = flatten(values, doc)
+	case ".toml":
+		err = parseTOML(values, string(data))
+	default:
+		err = fmt.Errorf("unknown config file format %s, want .yaml, .yml or .toml", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return values, nil
+}
+
+func flatten(values Values, doc map[string]any) error {
+	for k, v := range doc {
+		key := NormalizeKey(k)
+		switch v := v.(type) {
+		case map[string]any:
+			if err := flatten(values, v); err != nil {
+				return err
+			}
+		case []any:
+			list := make([]string, 0, len(v))
+			for _, item := range v {
+				s, err := scalar(key, item)
+				if err != nil {
+					return err
+				}
+				list = append(list, s)
+			}
+			if err := set(values, key, list); err != nil {
+				return err
+			}
+		default:
+			s, err := scalar(key, v)
+			if err != nil {
+				return err
+			}
+			if err := set(values, key, []string{s}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func scalar(key string, v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(v), nil
+	}
+	return "", fmt.Errorf("%s: want a string, number, boolean or a list of them, got %T", key, v)
+}
+
+func set(values Values, key string, list []string) error {
+	if _, ok := values[key]; ok {
+		return fmt.Errorf("%s is set twice", key)
+	}
+	values[key] = list
+	return nil
+}
+
+// parseTOML reads the subset of TOML a config file needs: [tables] and
+// key = value lines with strings, numbers, booleans and one-line arrays.
+func parseTOML(values Values, text string) error {
+	for n, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "[") {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("line %d: want key = value", n+1)
+		}
+		key := NormalizeKey(strings.Trim(strings.TrimSpace(k), `"`))
+		v = strings.TrimSpace(v)
+		var list []string
+		if strings.HasPrefix(v, "[") {
+			if !strings.HasSuffix(v, "]") {
+				return fmt.Errorf("line %d: arrays must be on one line", n+1)
+			}
+			for _, item := range splitArray(strings.TrimSpace(v[1 : len(v)-1])) {
+				s, err := tomlValue(item)
+				if err != nil {
+					return fmt.Errorf("line %d: %w", n+1, err)
+				}
+				list = append(list, s)
+			}
+		} else {
+			s, err := tomlValue(v)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", n+1, err)
+			}
+			list = []string{s}
+		}
+		if err := set(values, key, list); err != nil {
+			return fmt.Errorf("line %d: %w", n+1, err)
+		}
+	}
+	return nil
+}
+
+// splitArray splits the items of an array at the commas outside strings.
+func splitArray(s string) []string {
+	var items []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			items = append(items, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" {
+		items = append(items, last)
+	}
+	return items
+}
+
+// tomlValue reads a "string", a 'literal string', a number or a boolean,
+// followed by an optional # comment.
+func tomlValue(v string) (string, error) {
+	switch {
+	case strings.HasPrefix(v, `"`):
+		end := 1
+		for ; end < len(v) && v[end] != '"'; end++ {
+			if v[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(v) {
+			return "", fmt.Errorf("unterminated string %s", v)
+		}
+		if rest := strings.TrimSpace(v[end+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", fmt.Errorf("unexpected %s after a string", rest)
+		}
+		return strconv.Unquote(v[:end+1])
+	case strings.HasPrefix(v, "'"):
+		end := strings.IndexByte(v[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string %s", v)
+		}
+		return v[1 : end+1], nil
+	}
+	if i := strings.IndexByte(v, '#'); i >= 0 {
+		v = strings.TrimSpace(v[:i])
+	}
+	if v == "true" || v == "false" {
+		return v, nil
+	}
+	if _, err := strconv.ParseFloat(strings.ReplaceAll(v, "_", ""), 64); err != nil {
+		return "", fmt.Errorf("invalid value %s", v)
+	}
+	return strings.ReplaceAll(v, "_", ""), nil
+}
+
+// Watch calls onChange with the values of path each time it changes, checked
+// every interval, until ctx is done. A file that fails to load is logged and
+// skipped, the previous values stay in effect.
+func Watch(ctx context.Context, path string, interval time.Duration, onChange func(Values)) {
+	last := modTime(path)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		mod := modTime(path)
+		if mod.Equal(last) {
+			continue
+		}
+		last = mod
+		values, err := Load(path)
+		if err != nil {
+			logs.Warnf("🔧 config file not reloaded, err: %v", err)
+			continue
+		}
+		onChange(values)
+	}
+}
+
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
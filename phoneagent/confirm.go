@@ -0,0 +1,169 @@
+package phoneagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/labels"
+	"autoglm-go/phoneagent/webhook"
+	"autoglm-go/utils"
+	"github.com/google/uuid"
+)
+
+// ErrNoAnswer is the error of a confirmation or takeover nobody answered
+// within AgentConfig.ConfirmTimeout.
+var ErrNoAnswer = errors.New("no answer from the user")
+
+type ConfirmKind string
+
+const (
+	ConfirmAction   ConfirmKind = "confirmation" // approve or reject a sensitive action
+	ConfirmTakeover ConfirmKind = "takeover"     // operate the device by hand, then hand it back or abort
+)
+
+// ConfirmRequest asks a human to answer for the running task.
+type ConfirmRequest struct {
+	ID       string        `json:"id"`
+	Kind     ConfirmKind   `json:"kind"`
+	TaskID   string        `json:"task_id"`
+	DeviceID string        `json:"device_id,omitempty"`
+	Task     string        `json:"task"`
+	Message  string        `json:"message"` // what to confirm or take over
+	Step     int           `json:"step"`
+	Labels   labels.Labels `json:"labels,omitempty"`
+	At       time.Time     `json:"at"`
+	Deadline *time.Time    `json:"deadline,omitempty"` // nil when it waits as long as the task
+}
+
+// Confirmer asks a human to answer req: true approves the action, or hands
+// the device back after a takeover, false stops the task. It returns once ctx
+// ends, with its error.
+type Confirmer interface {
+	Confirm(ctx context.Context, req ConfirmRequest) (bool, error)
+}
+
+// ConfirmerFunc adapts a function to a Confirmer.
+type ConfirmerFunc func(ctx context.Context, req ConfirmRequest) (bool, error)
+
+func (f ConfirmerFunc) Confirm(ctx context.Context, req ConfirmRequest) (bool, error) {
+	return f(ctx, req)
+}
+
+// humanAction returns what action asks the user, if anything: a Tap with a
+// message is sensitive and confirmed first, a Take_over hands the device over.
+func humanAction(action helper.Action) (ConfirmKind, string, bool) {
+	if utils.AnyToString(action["_metadata"]) != "do" {
+		return "", "", false
+	}
+	switch utils.AnyToString(action["action"]) {
+	case "Tap":
+		if msg, ok := action["message"]; ok {
+			return ConfirmAction, utils.AnyToString(msg), true
+		}
+	case "Take_over":
+		message := utils.AnyToString(action["message"])
+		if message == "" {
+			message = "User intervention required"
+		}
+		return ConfirmTakeover, message, true
+	}
+	return "", "", false
+}
+
+// confirmAction asks the user about the action that needs them, see
+// humanAction: ok reports whether it may run, otherwise result finishes the
+// task.
+func (r *PhoneAgent) confirmAction(ctx context.Context, kind ConfirmKind, message string) (result helper.ActionResult, ok bool) {
+	r.humanWaited = true
+	approved, err := r.askHuman(ctx, kind, message)
+	switch {
+	case err != nil && kind == ConfirmTakeover:
+		message = fmt.Sprintf("Takeover not completed: %v", err)
+	case err != nil:
+		message = fmt.Sprintf("Sensitive operation not confirmed: %v", err)
+	case approved:
+		return helper.ActionResult{}, true
+	case kind == ConfirmTakeover:
+		message = "User aborted the task during takeover"
+	default:
+		message = "User cancelled sensitive operation"
+	}
+	return helper.ActionResult{Success: false, ShouldFinish: true, Message: message}, false
+}
+
+// askHuman posts the request to the webhooks and waits for the answer of
+// PhoneAgent.Confirmer, the terminal without one. The wait is bounded by
+// AgentConfig.ConfirmTimeout and the task, not by the step or action.
+func (r *PhoneAgent) askHuman(ctx context.Context, kind ConfirmKind, message string) (bool, error) {
+	req := ConfirmRequest{
+		ID:       uuid.New().String(),
+		Kind:     kind,
+		TaskID:   r.taskID,
+		DeviceID: r.AgentConfig.DeviceID,
+		Task:     r.task,
+		Message:  message,
+		Step:     r.StepCount,
+		Labels:   labels.From(ctx),
+		At:       time.Now(),
+	}
+	if r.taskCtx != nil {
+		ctx = r.taskCtx
+	}
+	if timeout := r.AgentConfig.ConfirmTimeout; timeout > 0 {
+		deadline := req.At.Add(timeout)
+		req.Deadline = &deadline
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, timeout, ErrNoAnswer)
+		defer cancel()
+	}
+
+	if r.webhooks != nil {
+		event := webhook.EventConfirmation
+		if kind == ConfirmTakeover {
+			event = webhook.EventTakeover
+		}
+		payload := r.payload(ctx, event, message, "")
+		payload.ConfirmationID, payload.Deadline = req.ID, req.Deadline
+		r.webhooks.Send(ctx, payload)
+	}
+
+	confirmer := r.Confirmer
+	if confirmer == nil {
+		confirmer = ConfirmerFunc(r.confirmOnTerminal)
+	}
+	r.log().Infof("🙋 waiting for the user (%s %s): %s", kind, req.ID, message)
+	approved, err := confirmer.Confirm(ctx, req)
+	if err != nil {
+		if errors.Is(context.Cause(ctx), ErrNoAnswer) {
+			err = fmt.Errorf("%w within %s", ErrNoAnswer, r.AgentConfig.ConfirmTimeout)
+		}
+		r.log().Warnf("🙋 %s %s not answered, err: %v", kind, req.ID, err)
+		return false, err
+	}
+	r.log().Infof("🙋 %s %s answered, approved: %t", kind, req.ID, approved)
+	return approved, nil
+}
+
+// confirmOnTerminal asks with DefaultConfirmation and DefaultTakeover. A
+// prompt left when ctx ends still takes the next line typed.
+func (r *PhoneAgent) confirmOnTerminal(ctx context.Context, req ConfirmRequest) (bool, error) {
+	answer := make(chan bool, 1)
+	go func() {
+		if req.Kind == ConfirmTakeover {
+			r.DefaultTakeover(req.Message)
+			answer <- true
+			return
+		}
+		answer <- r.DefaultConfirmation(req.Message)
+	}()
+	select {
+	case approved := <-answer:
+		return approved, nil
+	case <-ctx.Done():
+		fmt.Println()
+		return false, ctx.Err()
+	}
+}
@@ -0,0 +1,182 @@
+package phoneagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrTaskCancelled is returned by a task stopped with PhoneAgent.CancelTask.
+var ErrTaskCancelled = errors.New("task cancelled")
+
+// cleanUpTimeout bounds the clean up of the device after a cancellation.
+const cleanUpTimeout = 10 * time.Second
+
+// CancelOptions tune PhoneAgent.CancelTask.
+type CancelOptions struct {
+	Home bool // also go back to the home screen
+}
+
+// taskControl is how other goroutines pause, resume and cancel the running
+// task of an agent.
+type taskControl struct {
+	mu      sync.Mutex
+	cancel  context.CancelCauseFunc // of the running task, nil between tasks
+	paused  bool
+	opts    CancelOptions
+	changed chan struct{} // closed and replaced on every change
+}
+
+func (r *taskControl) start(cancel context.CancelCauseFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cancel, r.paused, r.opts = cancel, false, CancelOptions{}
+	r.changed = make(chan struct{})
+}
+
+func (r *taskControl) stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cancel, r.paused = nil, false
+}
+
+// notify must be called with r.mu held.
+func (r *taskControl) notify() {
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+func (r *taskControl) isPaused() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.paused
+}
+
+// PauseTask makes the running task wait once its current step is done, until
+// ResumeTask or CancelTask. It reports false when no task runs.
+func (r *PhoneAgent) PauseTask() bool {
+	c := &r.control
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cancel == nil {
+		return false
+	}
+	if !c.paused {
+		c.paused = true
+		c.notify()
+	}
+	return true
+}
+
+// ResumeTask lets a paused task go on. It reports false when no task runs.
+func (r *PhoneAgent) ResumeTask() bool {
+	c := &r.control
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cancel == nil {
+		return false
+	}
+	if c.paused {
+		c.paused = false
+		c.notify()
+	}
+	return true
+}
+
+// TaskPaused reports whether the running task is paused or about to pause.
+func (r *PhoneAgent) TaskPaused() bool {
+	return r.control.isPaused()
+}
+
+// CancelTask stops the running task at once, paused or not. Unlike cancelling
+// the context of Run, the task then leaves the device usable, see
+// CancelOptions, is saved for a later Resume and ends with
+// ErrTaskCancelled. It reports false when no task runs.
+func (r *PhoneAgent) CancelTask(opts CancelOptions) bool {
+	c := &r.control
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cancel == nil {
+		return false
+	}
+	c.opts = opts
+	c.cancel(ErrTaskCancelled)
+	return true
+}
+
+// waitWhilePaused holds the task between two steps while it is paused. The
+// session is saved as paused first, so that a task that is never resumed
+// can still be with PhoneAgent.Resume.
+func (r *PhoneAgent) waitWhilePaused(ctx context.Context, result *StepResult) {
+	c := &r.control
+	for announced := false; ; {
+		c.mu.Lock()
+		paused, changed := c.paused, c.changed
+		c.mu.Unlock()
+		if !paused {
+			if announced {
+				r.logFor(ctx).Infof("▶️ task resumed at step %d", r.StepCount)
+				r.emit(Event{Type: EventResumed})
+			}
+			return
+		}
+		if !announced {
+			announced = true
+			r.logFor(ctx).Infof("⏸️ task paused after step %d", r.StepCount)
+			r.saveSession(ctx, result, nil)
+			r.emit(Event{Type: EventPaused})
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		}
+	}
+}
+
+// cancelled ends a task stopped by CancelTask: it cleans up the device, saves the
+// session and returns the error of the task, nil when the task was not
+// cancelled.
+func (r *PhoneAgent) cancelled(ctx context.Context, result *StepResult) error {
+	if ctx.Err() == nil || !errors.Is(context.Cause(ctx), ErrTaskCancelled) {
+		return nil
+	}
+	r.control.mu.Lock()
+	opts := r.control.opts
+	r.control.mu.Unlock()
+
+	err := fmt.Errorf("%w at step %d", ErrTaskCancelled, r.StepCount)
+	r.logFor(ctx).Warnf("⏹️ %v", err)
+	r.cleanUp(context.WithoutCancel(ctx), opts)
+	r.saveSession(ctx, result, err)
+	r.emit(Event{Type: EventCancelled, Message: err.Error()})
+	return err
+}
+
+// cleanUp closes the keyboard a cancelled task may have left open and, with
+// opts.Home, goes to the home screen. Nothing is touched in a dry run or a
+// read-only one.
+func (r *PhoneAgent) cleanUp(ctx context.Context, opts CancelOptions) {
+	if r.AgentConfig.DryRun || r.AgentConfig.ReadOnly {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, cleanUpTimeout)
+	defer cancel()
+	deviceID := r.AgentConfig.DeviceID
+	if shell, ok := r.Device.(ShellDevice); ok {
+		out, err := shell.Shell(ctx, deviceID, "dumpsys", "input_method")
+		if err == nil && strings.Contains(out, "mInputShown=true") {
+			if err := r.Device.Back(ctx, deviceID); err != nil {
+				r.log().Warnf("failed to close the keyboard, err: %v", err)
+			}
+		}
+	}
+	if opts.Home {
+		if err := r.Device.Home(ctx, deviceID); err != nil {
+			r.log().Warnf("failed to go home, err: %v", err)
+		}
+	}
+}
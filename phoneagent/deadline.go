@@ -0,0 +1,84 @@
+package phoneagent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/webhook"
+)
+
+type softDeadlineKey struct{}
+
+// WithSoftDeadline returns ctx whose tasks report their progress once they
+// run longer than d, instead of after AgentConfig.SoftDeadline. Unlike
+// TaskTimeout, the task goes on.
+func WithSoftDeadline(ctx context.Context, d time.Duration) context.Context {
+	if d <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, softDeadlineKey{}, d)
+}
+
+func (r *PhoneAgent) softDeadlineOf(ctx context.Context) time.Duration {
+	if d, ok := ctx.Value(softDeadlineKey{}).(time.Duration); ok {
+		return d
+	}
+	return r.AgentConfig.SoftDeadline
+}
+
+// checkSoftDeadline reports the progress of a task past its soft deadline,
+// once: to the webhooks and as an EventProgress. It returns whether the
+// report is done, so that the caller stops checking.
+func (r *PhoneAgent) checkSoftDeadline(ctx context.Context, started time.Time, last *StepResult) bool {
+	deadline := r.softDeadlineOf(ctx)
+	elapsed := time.Since(started)
+	if deadline <= 0 || elapsed < deadline || isWatchCheck(ctx) {
+		return false
+	}
+	summary := r.progressSummary(last)
+	remaining, bound := r.estimateRemaining(elapsed)
+	eta := time.Now().Add(remaining)
+	r.log().Infof("⏳ task past its soft deadline of %s, %s, expected by %s", deadline, summary, eta.Format(time.TimeOnly))
+
+	r.emit(Event{Type: EventProgress, Message: summary})
+	if r.webhooks != nil {
+		payload := r.payload(ctx, webhook.EventProgress, summary, "")
+		payload.ETA, payload.ETAIsBound = &eta, bound
+		r.webhooks.Send(ctx, payload)
+	}
+	return true
+}
+
+// progressSummary describes the last step, and the subgoal in progress when
+// there is a plan.
+func (r *PhoneAgent) progressSummary(last *StepResult) string {
+	summary := fmt.Sprintf("step %d", r.StepCount)
+	if obs := r.stepObservation; obs != nil && obs.currentApp != "" {
+		summary += " in " + obs.currentApp
+	}
+	if last != nil && last.Action != nil {
+		summary += ": " + helper.FormatAction(last.Action)
+		if !last.Success {
+			summary += " (failed)"
+		}
+	}
+	if p := r.plan; p != nil && p.current < len(p.subgoals) {
+		summary += fmt.Sprintf(", subgoal %d/%d: %s", p.current+1, len(p.subgoals), p.subgoals[p.current])
+	}
+	return summary
+}
+
+// estimateRemaining extrapolates the pace so far over the subgoals left when
+// some of the plan is done. Otherwise it is the time of the steps left before
+// MaxSteps, which bounds it from above.
+func (r *PhoneAgent) estimateRemaining(elapsed time.Duration) (remaining time.Duration, bound bool) {
+	if p := r.plan; p != nil && p.current > 0 && p.current < len(p.subgoals) {
+		return elapsed / time.Duration(p.current) * time.Duration(len(p.subgoals)-p.current), false
+	}
+	if r.StepCount == 0 {
+		return 0, true
+	}
+	return elapsed / time.Duration(r.StepCount) * time.Duration(max(r.AgentConfig.MaxSteps-r.StepCount, 0)), true
+}
@@ -2,9 +2,12 @@ package definitions
 
 import (
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"autoglm-go/constants"
+	"autoglm-go/phoneagent/uilang"
 )
 
 type AgentConfig struct {
@@ -12,16 +15,358 @@ type AgentConfig struct {
 	DeviceID string
 	Lang     string
 	WdaUrl   string // ios only
+	UIDump   bool   // attach the view hierarchy to each observation
+
+	// SpeculationThreshold is the minimum navigation map confidence to warm
+	// up the next prompt ahead of time, 0 disables speculation.
+	SpeculationThreshold float64
+
+	// History caps for long tasks, 0 means unlimited. Thinking of steps older
+	// than HistoryKeepSteps is dropped from memory, and only the latest
+	// HistoryMaxSteps steps (plus the first one, which has the task) stay in
+	// the context. Removed content is written to HistoryDir when set.
+	HistoryKeepSteps int
+	HistoryMaxSteps  int
+	HistoryDir       string
+
+	// HistoryImages is how many of the latest screenshots, the current one
+	// included, stay in the context as images. Older ones are replaced by
+	// their screen text (from the UI dump) and the action taken, so the
+	// image cost per step stays flat. 0 or 1 sends each screenshot once.
+	HistoryImages int
+
+	// UnchangedScreen compares the screen of each step with the previous one
+	// by perceptual hash: "annotate" tells the model when it did not change,
+	// "reuse" also repeats a Wait on an unchanged screen without a model
+	// call, a few times in a row. Screens closer than UnchangedDistance dhash
+	// bits are the same, 0 means the default of 2.
+	UnchangedScreen   string
+	UnchangedDistance int
+
+	// HistoryTokenBudget caps the estimated tokens of the context. Past it,
+	// the oldest steps but the first leave the context and are summarized as
+	// a line each in the task message. 0 means unlimited.
+	HistoryTokenBudget int
+
+	// WebCDP attaches to Chrome tabs and debuggable WebViews over the DevTools
+	// protocol, to list their DOM elements and click or type into them
+	// directly. Screen coordinates are used when no page can be attached.
+	WebCDP bool
+
+	// Notifications lists the notifications of the device in each
+	// observation, for devices that can read them.
+	Notifications bool
+
+	// Grounding retries a tap that left the screen unchanged on the UI dump
+	// element the model quoted in its reasoning, without another model call.
+	Grounding bool
+
+	// OCR is the local OCR engine that finds the labels the model gives
+	// instead of coordinates, or with a tap far off the screen, when the UI
+	// dump does not have them: tesseract, empty for none. OCRLanguages are
+	// its language codes, chi_sim+eng by default.
+	OCR          string
+	OCRLanguages string
+
+	// CalibrationFile holds the tap corrections of devices that miss their
+	// targets, by device id, see PhoneAgent.Calibrate. Devices without one
+	// are tapped where the model says.
+	CalibrationFile string
+
+	// InstallDir is the local folder of the APKs the Install action takes,
+	// Install is refused without one.
+	InstallDir string
+
+	// PlannerReviewSteps is how often, in steps, the planner model checks
+	// the progress of its plan. 0 disables reviews.
+	PlannerReviewSteps int
+
+	// ModelProfile names the model profile the tasks run with unless they
+	// select another one, see phoneagent.UseModelProfiles. Empty runs them
+	// with the main model.
+	ModelProfile string
+
+	// UILanguage is the BCP 47 tag of the device UI language, e.g. "ja" or
+	// "de". The system prompt mentions it and UI text matching follows its
+	// rules. Empty means the UI is in the prompt language.
+	UILanguage string
+
+	// ReplyLanguage is the BCP 47 tag of the language finish messages,
+	// Take_over requests and confirmation questions must be in, e.g. "en".
+	// The system prompt asks for it, and messages written in another script
+	// are translated with a short text-only model call. Empty leaves them as
+	// the model wrote them.
+	ReplyLanguage string
+
+	// InputLocale is the BCP 47 tag, e.g. "de-DE", of the dates, decimal
+	// numbers and phone numbers typed by Type: the model writes placeholders
+	// such as {{date:+1}}, told about in the system prompt, and they are
+	// typed in the format of the locale. InputLocaleAuto reads the locale of
+	// the device. Empty types the text as the model wrote it.
+	InputLocale string
+
+	// ReconnectTimeout is how long a device that dropped off mid-task (USB
+	// glitch, adb over Wi-Fi reset) is waited for before the task fails. The
+	// task resumes from the screen found after reconnecting. 0 disables it.
+	ReconnectTimeout time.Duration
+
+	// Timeouts of a device action, a whole step (observation, model request
+	// and action) and a task, 0 means none. A timed out action is reported
+	// to the model as failed, a timed out step is skipped and the task goes
+	// on, a timed out task stops. Each must be shorter than the next one.
+	ActionTimeout time.Duration
+	StepTimeout   time.Duration
+	TaskTimeout   time.Duration
+
+	// ConfirmTimeout is how long a sensitive action or a takeover waits for
+	// the user before the task stops, 0 waits as long as the task may run.
+	// The wait counts against TaskTimeout only.
+	ConfirmTimeout time.Duration
+
+	// SoftDeadline is how long a task runs before its progress is reported
+	// to the webhooks, once, with an estimate of when it ends. It does not
+	// stop the task; 0 means never, see phoneagent.WithSoftDeadline.
+	SoftDeadline time.Duration
+
+	// StepPause is how long the agent waits after a step before it observes
+	// the screen again, for apps that flag fast automation, see ParsePacing.
+	StepPause time.Duration
+
+	// AnomalyFactor reports a step whose latency or tokens are at least this
+	// many times the rolling baseline of its model and app, 0 disables it.
+	// AnomalyBaselines keeps the baselines across runs, in memory when empty.
+	AnomalyFactor    float64
+	AnomalyBaselines string
+
+	// MemoryFile keeps the facts learned about apps at the end of tasks,
+	// given to the later tasks in the same apps; no memory when empty.
+	MemoryFile string
+
+	// AutoUnlock unlocks a locked device at task start with the credential
+	// of VaultFile, under unlock:<device id> or unlock. When off, a task on
+	// a locked device fails; a screen that is only off is always woken.
+	AutoUnlock bool
+	VaultFile  string
+
+	// SessionDir is where tasks are saved after every step so that an
+	// interrupted one can be resumed, see PhoneAgent.Resume. Empty disables it.
+	SessionDir string
+
+	// RecordDir is where every step is recorded as a JSONL line per session,
+	// with its screenshot, prompt, model output, action, result and timings,
+	// for fine-tuning and evaluation datasets. Empty disables it.
+	RecordDir string
+	// RecordSnapshots also records the state of the agent at every step,
+	// its conversation, plan and policy decisions, for rewinding a session
+	// to a step, see recorder.Rewind.
+	RecordSnapshots bool
+	// RecordVideo also records a video of each task in RecordDir, with its
+	// steps as WebVTT subtitles, for audits. Devices that cannot record their
+	// screen get one stitched from the step screenshots, with ffmpeg.
+	RecordVideo bool
+
+	// Webhooks are told when a task finishes or fails and when it waits for
+	// a confirmation or a takeover.
+	Webhooks WebhookConfig
+
+	// Policy allows, denies or asks to confirm every action before it runs,
+	// see policy.Engine.
+	Policy PolicyConfig
+	// MaxPolicyBlocks stops the task when the policy denies that many
+	// actions of the model in a row, 0 never stops it.
+	MaxPolicyBlocks int
+	// MaxRepeats stops the task when the model takes the same action on an
+	// unchanged screen that many times in a row, 0 never stops it.
+	MaxRepeats int
+	// MaxActionErrors is how many actions in a row may fail, each reported to
+	// the model to recover from, before the task stops; 0 ends the task at
+	// the first action the device fails to run instead.
+	MaxActionErrors int
+	// MaxTaskTokens and MaxTaskCost stop a task once its model requests used
+	// that many tokens or that much estimated cost, 0 is unlimited, see
+	// phoneagent.Budget.
+	MaxTaskTokens int
+	MaxTaskCost   float64
+
+	// Redact masks private information on the screens before the model sees
+	// them.
+	Redact RedactConfig
+
+	// DryRun observes and asks the model as usual but only logs the actions
+	// instead of sending them to the device, nor closes dialogs or solves
+	// captchas; the model is told the screen did not change. Finish actions
+	// still end the task.
+	DryRun bool
+
+	// ReadOnly only lets the model observe and answer, for audits: every
+	// action that would operate the device is refused at the executor, and
+	// dialogs, captchas and locked screens are left as they are. The screen
+	// is still woken.
+	ReadOnly bool
+
+	// AndroidUser is the user apps are launched, installed and pushed to on
+	// devices with several users or a work profile: a user id, or "work" for
+	// the work profile. Empty targets the current user.
+	AndroidUser string
+
+	// OutcomeTemplates format the summaries of finished tasks per channel,
+	// cli, chat or webhook, as text/template templates of a
+	// phoneagent.OutcomeSummary. Channels without one keep their default.
+	OutcomeTemplates map[string]string
+
+	// Chaos injects faults for resilience testing, see ChaosConfig. Never
+	// set it for real tasks.
+	Chaos ChaosConfig
+}
+
+// ValidateTimeouts checks that ActionTimeout < StepTimeout < TaskTimeout,
+// ignoring those that are not set, and that none is negative.
+func (c *AgentConfig) ValidateTimeouts() error {
+	stages := []struct {
+		name  string
+		limit time.Duration
+	}{
+		{"action", c.ActionTimeout},
+		{"step", c.StepTimeout},
+		{"task", c.TaskTimeout},
+	}
+	for i := range stages {
+		if stages[i].limit < 0 {
+			return fmt.Errorf("%s timeout must not be negative", stages[i].name)
+		}
+		for j := i + 1; j < len(stages); j++ {
+			if stages[i].limit > 0 && stages[j].limit > 0 && stages[i].limit >= stages[j].limit {
+				return fmt.Errorf("%s timeout (%s) must be shorter than the %s timeout (%s)",
+					stages[i].name, stages[i].limit, stages[j].name, stages[j].limit)
+			}
+		}
+	}
+	if c.ConfirmTimeout < 0 {
+		return fmt.Errorf("confirm timeout must not be negative")
+	}
+	return nil
+}
+
+// GetUILanguage returns UILanguage, or the tag of Lang when it is empty.
+func (c *AgentConfig) GetUILanguage() string {
+	if c.UILanguage != "" {
+		return c.UILanguage
+	}
+	if c.Lang == "en" {
+		return "en"
+	}
+	return "zh"
+}
+
+// systemPromptCache holds the system prompts rendered today, keyed by
+// language, entries of earlier days are dropped.
+var systemPromptCache struct {
+	sync.Mutex
+	day     string
+	prompts map[string]string
 }
 
 func (c *AgentConfig) GetSystemPrompt() string {
 	today := time.Now()
+	day := today.Format("2006-01-02")
+	key := c.Lang + "|" + c.UILanguage + "|" + c.ReplyLanguage + "|" + c.InputLocale
 
+	systemPromptCache.Lock()
+	defer systemPromptCache.Unlock()
+	if systemPromptCache.day != day {
+		systemPromptCache.day, systemPromptCache.prompts = day, map[string]string{}
+	}
+	if prompt, ok := systemPromptCache.prompts[key]; ok {
+		return prompt
+	}
+	prompt := c.buildSystemPrompt(today)
+	systemPromptCache.prompts[key] = prompt
+	return prompt
+}
+
+func (c *AgentConfig) buildSystemPrompt(today time.Time) string {
+	var prompt string
 	if c.Lang == "en" {
-		return fmt.Sprintf(constants.DefaultEnSystemPrompt, today.Format("2006-01-02, Monday"))
+		prompt = fmt.Sprintf(constants.DefaultEnSystemPrompt, today.Format("2006-01-02, Monday"))
+	} else {
+		weekdayNames := []string{"星期一", "星期二", "星期三", "星期四", "星期五", "星期六", "星期日"}
+		weekday := weekdayNames[today.Weekday()]
+		prompt = fmt.Sprintf(constants.DefaultCnSystemPrompt, today.Format("2006年01月02日")+" "+weekday)
+	}
+	if hint := c.uiLanguageHint(); hint != "" {
+		prompt = strings.TrimRight(prompt, "\n") + "\n\n" + hint
+	}
+	if hint := c.replyLanguageHint(); hint != "" {
+		prompt = strings.TrimRight(prompt, "\n") + "\n\n" + hint
+	}
+	if hint := c.inputLocaleHint(today); hint != "" {
+		prompt = strings.TrimRight(prompt, "\n") + "\n\n" + hint
+	}
+	return prompt
+}
+
+// uiLanguageHint tells the model which language the screen is in, so it
+// quotes and types UI text as shown instead of translating it.
+func (c *AgentConfig) uiLanguageHint() string {
+	if c.UILanguage == "" {
+		return ""
 	}
+	name := c.UILanguage
+	if lang, err := uilang.Parse(c.UILanguage); err == nil {
+		name = fmt.Sprintf("%s (%s)", lang.Name(), lang.Tag)
+	}
+	if c.Lang == "en" {
+		return fmt.Sprintf("The device UI language is %s. Texts on the screen are in that language: read, quote and type them as shown, do not translate them.", name)
+	}
+	return fmt.Sprintf("设备界面语言为 %s。屏幕上的文字使用该语言，识别、引用和输入界面文字时保持原文，不要翻译。", name)
+}
+
+// replyLanguageHint asks for the messages shown to the user in ReplyLanguage.
+func (c *AgentConfig) replyLanguageHint() string {
+	if c.ReplyLanguage == "" {
+		return ""
+	}
+	name := c.ReplyLanguage
+	if lang, err := uilang.Parse(c.ReplyLanguage); err == nil {
+		name = fmt.Sprintf("%s (%s)", lang.Name(), lang.Tag)
+	}
+	if c.Lang == "en" {
+		return fmt.Sprintf("Write the message of finish, Take_over and confirmation messages in %s, whatever the language of the task and the screen.", name)
+	}
+	return fmt.Sprintf("finish、Take_over 的 message 以及敏感操作的确认信息请使用 %s 书写，与任务和屏幕的语言无关。", name)
+}
+
+// InputLocaleAuto is the InputLocale reading the locale of the device.
+const InputLocaleAuto = "auto"
+
+// inputLocaleHint tells the model about the placeholders of Type, which are
+// typed in the date, number and phone formats of InputLocale.
+func (c *AgentConfig) inputLocaleHint(today time.Time) string {
+	if c.InputLocale == "" {
+		return ""
+	}
+	example := ""
+	if lang, err := uilang.Parse(c.InputLocale); err == nil {
+		example = lang.FormatDate(today.AddDate(0, 0, 1))
+	}
+	if c.Lang == "en" {
+		hint := "In the text of Type, write dates, decimal numbers and phone numbers as placeholders, they are typed in the format of the device locale: {{date}} for today, {{date:+1}} for tomorrow or any number of days from today, {{date:2026-10-15}} for a given date, {{number:3.5}} with a period as decimal separator, {{phone:+8613800138000}} with the country code."
+		if example != "" {
+			hint += fmt.Sprintf(" For instance do(action=\"Type\", text=\"{{date:+1}}\") types %s.", example)
+		}
+		return hint
+	}
+	hint := "在 Type 的 text 中，日期、小数和电话号码请写成占位符，输入时会按设备区域的格式填写：{{date}} 表示今天，{{date:+1}} 表示明天或距今天的任意天数，{{date:2026-10-15}} 表示指定日期，{{number:3.5}} 以英文句点作小数点，{{phone:+8613800138000}} 需带国家区号。"
+	if example != "" {
+		hint += fmt.Sprintf("例如 do(action=\"Type\", text=\"{{date:+1}}\") 会输入 %s。", example)
+	}
+	return hint
+}
 
-	weekdayNames := []string{"星期一", "星期二", "星期三", "星期四", "星期五", "星期六", "星期日"}
-	weekday := weekdayNames[today.Weekday()]
-	return fmt.Sprintf(constants.DefaultCnSystemPrompt, today.Format("2006年01月02日")+" "+weekday)
+// Settings are what a reload of the config file changes while the process
+// runs, for the tasks that start after it; zero values keep the configured
+// ones.
+type Settings struct {
+	MaxSteps    int
+	Temperature *float32
 }
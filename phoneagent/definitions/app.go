@@ -0,0 +1,10 @@
+package definitions
+
+// Intent is what an app is started with by package, activity or intent
+// rather than by its name.
+type Intent struct {
+	Package  string // e.g. com.android.settings
+	Activity string // e.g. .Settings or com.android.settings.Settings, the launcher activity when empty
+	Action   string // e.g. android.intent.action.VIEW
+	URI      string // data URI, e.g. https://example.com or a deep link
+}
@@ -0,0 +1,61 @@
+package definitions
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ChaosConfig injects faults into tasks to exercise the recovery paths. Each
+// rate is the probability, from 0 to 1, of the fault at every chance of it:
+// a step for Disconnect, MalformedAction and ScreenshotFailure, a model
+// request for SlowModel.
+type ChaosConfig struct {
+	Disconnect        float64 // the device drops off before the action, for DisconnectFor
+	SlowModel         float64 // the model request waits SlowModelDelay first
+	MalformedAction   float64 // the model answer is cut in half
+	ScreenshotFailure float64 // the screenshot comes back empty
+
+	SlowModelDelay time.Duration
+	DisconnectFor  time.Duration
+
+	// Seed makes the faults of every run the same, 0 picks a random one.
+	Seed int64
+}
+
+// Enabled reports whether any fault can happen.
+func (c ChaosConfig) Enabled() bool {
+	return c.Disconnect > 0 || c.SlowModel > 0 || c.MalformedAction > 0 || c.ScreenshotFailure > 0
+}
+
+// ParseChaosRates reads the rates of c written as fault=rate pairs separated
+// by commas, e.g. "disconnect=0.05,slow_model=0.1".
+func (c *ChaosConfig) ParseChaosRates(s string) error {
+	rates := map[string]*float64{
+		"disconnect":       &c.Disconnect,
+		"slow_model":       &c.SlowModel,
+		"malformed_action": &c.MalformedAction,
+		"screenshot":       &c.ScreenshotFailure,
+	}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return fmt.Errorf("invalid chaos rate %q, want fault=rate", part)
+		}
+		rate, ok := rates[strings.TrimSpace(name)]
+		if !ok {
+			return fmt.Errorf("unknown chaos fault %q, want disconnect, slow_model, malformed_action or screenshot", name)
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || v < 0 || v > 1 {
+			return fmt.Errorf("invalid rate of chaos fault %s: %q, want a number from 0 to 1", name, value)
+		}
+		*rate = v
+	}
+	return nil
+}
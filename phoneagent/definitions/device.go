@@ -1,5 +1,15 @@
 package definitions
 
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+	"strings"
+)
+
 type ConnectionType string
 
 const (
@@ -8,12 +18,41 @@ const (
 	Remote ConnectionType = "remote"
 )
 
+// OSType is the system of a device.
+type OSType string
+
+const (
+	Android   OSType = "android"
+	IOS       OSType = "ios"
+	HarmonyOS OSType = "harmonyos"
+)
+
+// DeviceOptions configures device backends that need more than a device id.
+type DeviceOptions struct {
+	AppiumURL          string
+	AppiumCapabilities map[string]any
+
+	KeyboardAPK string // ADB Keyboard APK for the adb devices without it
+
+	WdaURL string // WebDriverAgent of the ios devices
+}
+
 type DeviceInfo struct {
 	DeviceID       string         `json:"device_id"`
 	Status         string         `json:"status"`
 	ConnectionType ConnectionType `json:"connection_type"`
 	Model          string         `json:"model,omitempty"`
-	AndroidVersion string         `json:"android_version,omitempty"`
+	AndroidVersion string         `json:"android_version,omitempty"` // of the system of OS
+	OS             OSType         `json:"os,omitempty"`
+}
+
+// UserProfile is an Android user of a device: the main user, a secondary user
+// or a work profile, whose apps duplicate those of the main user.
+type UserProfile struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Managed bool   `json:"managed,omitempty"` // a work profile
+	Running bool   `json:"running,omitempty"`
 }
 
 // Screenshot represents a captured screenshot.
@@ -22,4 +61,63 @@ type Screenshot struct {
 	Width       int    `json:"width"`
 	Height      int    `json:"height"`
 	IsSensitive bool   `json:"is_sensitive"`
+	Data        []byte `json:"-"` // raw image bytes, used for re-encoding
+}
+
+// FallbackScreenshot is a black screen returned by the backends in place of
+// a screenshot they failed to take. It has no Data, which tells the agent to
+// check that the device is still there.
+func FallbackScreenshot() *Screenshot {
+	const (
+		defaultWidth  = 1080
+		defaultHeight = 2400
+	)
+	var buf bytes.Buffer
+	_ = png.Encode(&buf, image.NewGray(image.Rect(0, 0, defaultWidth, defaultHeight)))
+	return &Screenshot{
+		Base64Data: base64.StdEncoding.EncodeToString(buf.Bytes()),
+		Width:      defaultWidth,
+		Height:     defaultHeight,
+	}
+}
+
+// ScreenState is whether the screen is on and whether the lock screen shows.
+type ScreenState struct {
+	Awake  bool
+	Locked bool
+}
+
+type UnlockKind string
+
+const (
+	UnlockPIN      UnlockKind = "pin"
+	UnlockPassword UnlockKind = "password"
+	UnlockPattern  UnlockKind = "pattern" // dots 1-9 of the 3x3 grid, row by row
+)
+
+// UnlockCredential is the secret of a secure lock screen, written as
+// kind:secret, e.g. pin:1234 or pattern:1,2,3,6,9.
+type UnlockCredential struct {
+	Kind   UnlockKind
+	Secret string
+}
+
+func ParseUnlockCredential(s string) (UnlockCredential, error) {
+	kind, secret, ok := strings.Cut(s, ":")
+	c := UnlockCredential{Kind: UnlockKind(kind), Secret: secret}
+	switch {
+	case !ok || secret == "":
+		return c, fmt.Errorf("invalid unlock credential, want pin:, password: or pattern: and the secret")
+	case c.Kind != UnlockPIN && c.Kind != UnlockPassword && c.Kind != UnlockPattern:
+		return c, fmt.Errorf("unknown unlock kind %q, want pin, password or pattern", kind)
+	}
+	return c, nil
+}
+
+// ScreenRecording is a video of the screen being recorded, see
+// phoneagent.ScreenRecorder.
+type ScreenRecording interface {
+	// Stop ends the recording and saves it to dir, in one or more mp4 files
+	// to be played in order.
+	Stop(ctx context.Context, dir string) ([]string, error)
 }
@@ -0,0 +1,23 @@
+package definitions
+
+import "time"
+
+type GestureKind string
+
+const (
+	GestureTap       GestureKind = "tap"
+	GestureLongPress GestureKind = "long_press"
+	GestureSwipe     GestureKind = "swipe"
+	GestureKey       GestureKind = "key"
+)
+
+// Gesture is an input of the user on the device, see
+// phoneagent.GestureDevice.
+type Gesture struct {
+	Kind     GestureKind
+	Start    [2]int // pixels of the screen, where the touch began
+	End      [2]int // where it was lifted
+	Key      string // of GestureKey, e.g. back, home or enter
+	Duration time.Duration
+	At       time.Time // when it ended
+}
@@ -0,0 +1,83 @@
+package definitions
+
+import (
+	"fmt"
+	"time"
+)
+
+// GroupDefaults is the config a device group gives its devices. Empty fields
+// are inherited from the parent group; settings given on the command line
+// win over all of them.
+type GroupDefaults struct {
+	Model      string `json:"model,omitempty"`
+	BaseURL    string `json:"base_url,omitempty"`
+	MaxSteps   int    `json:"max_steps,omitempty"`
+	Lang       string `json:"lang,omitempty"`
+	UILanguage string `json:"ui_lang,omitempty"`
+
+	Provider     string   `json:"provider,omitempty"`
+	ModelProfile string   `json:"model_profile,omitempty"` // for the tasks that select none
+	Temperature  *float32 `json:"temperature,omitempty"`
+	// PolicyFile is the safety policy of the devices, see policy.Load. It
+	// replaces the one of the command line rather than adding to it.
+	PolicyFile string `json:"policy_file,omitempty"`
+	Pacing     string `json:"pacing,omitempty"` // see ParsePacing
+}
+
+// Merge returns d with the empty fields taken from parent.
+func (d GroupDefaults) Merge(parent GroupDefaults) GroupDefaults {
+	if d.Model == "" {
+		d.Model = parent.Model
+	}
+	if d.BaseURL == "" {
+		d.BaseURL = parent.BaseURL
+	}
+	if d.MaxSteps == 0 {
+		d.MaxSteps = parent.MaxSteps
+	}
+	if d.Lang == "" {
+		d.Lang = parent.Lang
+	}
+	if d.UILanguage == "" {
+		d.UILanguage = parent.UILanguage
+	}
+	if d.Provider == "" {
+		d.Provider = parent.Provider
+	}
+	if d.ModelProfile == "" {
+		d.ModelProfile = parent.ModelProfile
+	}
+	if d.Temperature == nil {
+		d.Temperature = parent.Temperature
+	}
+	if d.PolicyFile == "" {
+		d.PolicyFile = parent.PolicyFile
+	}
+	if d.Pacing == "" {
+		d.Pacing = parent.Pacing
+	}
+	return d
+}
+
+// Pacings are the presets of ParsePacing.
+var Pacings = map[string]time.Duration{
+	"fast":    0,
+	"normal":  time.Second,
+	"careful": 3 * time.Second,
+}
+
+// ParsePacing returns the AgentConfig.StepPause of a pacing: a preset of
+// Pacings or a duration such as 500ms. Empty is fast.
+func ParsePacing(pacing string) (time.Duration, error) {
+	if pacing == "" {
+		return 0, nil
+	}
+	if pause, ok := Pacings[pacing]; ok {
+		return pause, nil
+	}
+	pause, err := time.ParseDuration(pacing)
+	if err != nil || pause < 0 {
+		return 0, fmt.Errorf("invalid pacing %q, want fast, normal, careful or a duration", pacing)
+	}
+	return pause, nil
+}
@@ -1,7 +1,13 @@
 package definitions
 
+import (
+	"net/http"
+	"time"
+)
+
 type ModelConfig struct {
-	BaseURL   string
+	Provider  string // API kind: openai (default), anthropic, gemini or ollama
+	BaseURL   string // empty for the provider's public endpoint
 	ModelName string
 	APIKey    string
 	Lang      string
@@ -10,4 +16,188 @@ type ModelConfig struct {
 	Temperature      float32
 	TopP             float32
 	FrequencyPenalty float32
+
+	MaxImageBytes int // provider image size limit, 0 means unlimited
+	Image         ImageConfig
+	AdaptiveImage bool // adjust screenshot resolution/quality to upload speed
+	EarlyAction   bool // execute the action as soon as it is streamed and go on without the rest, text responses only
+
+	// ToolCalls sends the actions as tools and reads them from tool calls,
+	// actions written as text are still parsed. Servers rejecting tools fall
+	// back to text for the rest of the run.
+	ToolCalls bool
+
+	// Observation names the observation builder: image (default), image+tree,
+	// tree, or one registered with phoneagent.RegisterObservationBuilder.
+	Observation string
+
+	// ResponseParser names the grammar of the responses: text (default),
+	// json, or one registered with llm.RegisterResponseParser. ActionMarkers
+	// also start the action in the content, without being part of it.
+	ResponseParser string
+	ActionMarkers  []string
+
+	MaxThinkingTokens int  // cut the thinking after about this many tokens and ask for the action, 0 means unlimited
+	ShowThinking      bool // stream the whole thinking to the terminal instead of one folded line
+
+	TrackUsage bool    // ask for token usage in the stream
+	CostPer1K  float64 // price per 1000 tokens, for routing reports
+
+	// Pricing estimates the cost of each request by the model that answered
+	// it, then PriceCatalog. Models in neither cost CostPer1K for all tokens,
+	// with a warning once per model when a catalog is set.
+	Pricing      map[string]ModelPrice
+	PriceCatalog PriceCatalog
+
+	// Retry applies to rate limits, server errors and dropped connections
+	// before the response starts. When the attempts are used up, Fallbacks
+	// are tried in order with the same policy.
+	Retry     RetryPolicy
+	Fallbacks []FallbackModel
+
+	HTTP HTTPConfig
+}
+
+// HTTPConfig is how the requests reach the model API. The zero value uses the
+// proxy of the environment, the system CAs and no timeout but the context's.
+type HTTPConfig struct {
+	Proxy              string // URL of an http, https or socks5 proxy, instead of HTTPS_PROXY
+	CAFile             string // PEM file of CAs trusted besides the system ones, e.g. of a corporate proxy
+	InsecureSkipVerify bool   // do not verify the certificate of the server, for testing only
+
+	// Timeout bounds each request, retries and fallbacks included, from
+	// sending it to the end of the streamed response. 0 means none.
+	Timeout time.Duration
+
+	// Headers are sent with every request, e.g. a key of an LLM gateway. The
+	// headers of llm.WithHeaders take precedence.
+	Headers map[string]string
+
+	// Client sends the requests when set, the settings above but Timeout and
+	// Headers are then ignored.
+	Client *http.Client
+}
+
+// ImageConfig is how screenshots are encoded for the model, before any
+// stepping down for MaxImageBytes or AdaptiveImage. The zero value sends the
+// original PNG.
+type ImageConfig struct {
+	MaxEdge   int    // long edge in pixels, 0 keeps the original size
+	Format    string // png (default), jpeg or webp
+	Quality   int    // 1-100 for jpeg and webp, 0 for the encoder default
+	Grayscale bool
+
+	// Transport is how the images reach the model: inline (default) base64,
+	// url to the artifact storage with signed URLs, or file to the file API
+	// of the provider. It may be given per provider, see
+	// llm.ParseImageTransport.
+	Transport string
+	// Detail is the OpenAI detail level: low, high or auto, empty leaves it
+	// to the server.
+	Detail string
+}
+
+// RetryPolicy is an exponential backoff: InitialBackoff doubles after each
+// failed attempt up to MaxBackoff, each wait varied by ±Jitter (0-1).
+type RetryPolicy struct {
+	MaxAttempts    int // including the first, 0 or 1 means no retry
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Jitter         float64
+}
+
+// ModelPrice is the price per 1000 prompt and completion tokens.
+type ModelPrice struct {
+	Prompt     float64 `json:"prompt"`
+	Completion float64 `json:"completion"`
+}
+
+// PriceCatalog looks up the price of a model, see pricing.Catalog.
+type PriceCatalog interface {
+	Price(provider, model string) (ModelPrice, bool)
+}
+
+// FallbackModel is a secondary model, used when the primary keeps failing.
+type FallbackModel struct {
+	Model    string `json:"model"`
+	BaseURL  string `json:"base_url,omitempty"` // default: the primary model's
+	APIKey   string `json:"api_key,omitempty"`  // default: the primary model's
+	Provider string `json:"provider,omitempty"` // default: the primary model's
+}
+
+// RouteConfig is an extra model the router may send steps to.
+type RouteConfig struct {
+	Name        string   `json:"name"`
+	Model       string   `json:"model"`
+	BaseURL     string   `json:"base_url,omitempty"`    // default: the main model's
+	APIKey      string   `json:"api_key,omitempty"`     // default: the main model's
+	Provider    string   `json:"provider,omitempty"`    // default: the main model's
+	Observation string   `json:"observation,omitempty"` // default: the main model's
+	Handles     []string `json:"handles"`               // navigation, reasoning and/or reading
+	CostPer1K   float64  `json:"cost_per_1k"`
+}
+
+// Apply returns a copy of base for the model of the route.
+func (c *RouteConfig) Apply(base *ModelConfig) *ModelConfig {
+	config := *base
+	config.ModelName = c.Model
+	config.CostPer1K = c.CostPer1K
+	if c.BaseURL != "" {
+		config.BaseURL = c.BaseURL
+	}
+	if c.APIKey != "" {
+		config.APIKey = c.APIKey
+	}
+	if c.Provider != "" {
+		config.Provider = c.Provider
+	}
+	if c.Observation != "" {
+		config.Observation = c.Observation
+	}
+	return &config
+}
+
+// ModelProfile is a named model a task may run with instead of the main
+// one, see phoneagent.WithModelProfile. Fields left out keep the main
+// model's.
+type ModelProfile struct {
+	Name        string   `json:"name"`
+	Model       string   `json:"model,omitempty"`
+	BaseURL     string   `json:"base_url,omitempty"`
+	APIKey      string   `json:"api_key,omitempty"`
+	Provider    string   `json:"provider,omitempty"`
+	Observation string   `json:"observation,omitempty"`
+	Temperature *float32 `json:"temperature,omitempty"`
+	MaxTokens   int      `json:"max_tokens,omitempty"`
+	CostPer1K   float64  `json:"cost_per_1k,omitempty"`
+}
+
+// Apply returns a copy of base with the fields the profile sets.
+func (p *ModelProfile) Apply(base *ModelConfig) *ModelConfig {
+	config := *base
+	if p.Model != "" {
+		config.ModelName = p.Model
+	}
+	if p.BaseURL != "" {
+		config.BaseURL = p.BaseURL
+	}
+	if p.APIKey != "" {
+		config.APIKey = p.APIKey
+	}
+	if p.Provider != "" {
+		config.Provider = p.Provider
+	}
+	if p.Observation != "" {
+		config.Observation = p.Observation
+	}
+	if p.Temperature != nil {
+		config.Temperature = *p.Temperature
+	}
+	if p.MaxTokens > 0 {
+		config.MaxTokens = p.MaxTokens
+	}
+	if p.CostPer1K > 0 {
+		config.CostPer1K = p.CostPer1K
+	}
+	return &config
 }
@@ -0,0 +1,40 @@
+package definitions
+
+// PolicyConfig filters the actions of the model before they run, see
+// policy.Engine. Rules are tried in order and the first that matches
+// decides; DenyApps, BlockedActions and ConfirmActions come after them, so
+// that a rule can allow what they would stop.
+type PolicyConfig struct {
+	// DenyApps are apps, by name or package, never launched nor operated;
+	// Back and Home still run, to leave them.
+	DenyApps []string `yaml:"deny_apps"`
+	// BlockedActions and ConfirmActions are action names, e.g. Type, or
+	// classes of actions, e.g. payment or send_message.
+	BlockedActions []string     `yaml:"blocked_actions"`
+	ConfirmActions []string     `yaml:"confirm_actions"`
+	Rules          []PolicyRule `yaml:"rules"`
+	// Classes add or replace classes of actions: keywords matched against
+	// the label of the tapped element and the message of sensitive taps.
+	Classes map[string][]string `yaml:"classes"`
+	// AuditLog is the JSONL file every decision is appended to, empty logs
+	// them only.
+	AuditLog string `yaml:"audit_log"`
+}
+
+// PolicyRule decides about the actions it matches: all of its conditions
+// that are set must hold.
+type PolicyRule struct {
+	Name     string   `yaml:"name"`
+	Decision string   `yaml:"decision"` // allow, deny or confirm
+	Apps     []string `yaml:"apps"`     // the current app, or the one launched
+	Actions  []string `yaml:"actions"`  // action names or classes
+	// Text is a regular expression on the text of the action: typed text,
+	// the label of the tapped element or the message of a sensitive tap.
+	Text   string `yaml:"text"`
+	Reason string `yaml:"reason"` // told to the model and the user, the name by default
+}
+
+// Enabled reports whether the policy can stop anything.
+func (c PolicyConfig) Enabled() bool {
+	return len(c.DenyApps) > 0 || len(c.BlockedActions) > 0 || len(c.ConfirmActions) > 0 || len(c.Rules) > 0
+}
@@ -0,0 +1,24 @@
+package definitions
+
+// RedactConfig masks private information on the screenshots and in the UI
+// texts before the model sees them, see redact.Redactor.
+type RedactConfig struct {
+	// Patterns find sensitive texts in the UI dump: built-in names phone,
+	// bank_card, id_card and email, or regular expressions. The elements
+	// showing them are masked, and the matches replaced in their texts.
+	Patterns []string `json:"patterns"`
+	// Apps, by name or package, whose screens are masked entirely.
+	Apps    []string       `json:"apps"`
+	Regions []RedactRegion `json:"regions"`
+}
+
+// RedactRegion is an area masked on every screen of its apps.
+type RedactRegion struct {
+	Apps []string `json:"apps"` // all apps when empty
+	Box  [4]int   `json:"box"`  // left, top, right, bottom on the 0-999 grid of the model
+}
+
+// Enabled reports whether anything is masked.
+func (c RedactConfig) Enabled() bool {
+	return len(c.Patterns) > 0 || len(c.Apps) > 0 || len(c.Regions) > 0
+}
@@ -0,0 +1,48 @@
+package definitions
+
+// UIElement is one node of the device view hierarchy.
+type UIElement struct {
+	Index       int    `json:"index"`
+	Text        string `json:"text,omitempty"`
+	ContentDesc string `json:"content_desc,omitempty"`
+	ResourceID  string `json:"resource_id,omitempty"`
+	Class       string `json:"class,omitempty"`
+	Package     string `json:"package,omitempty"`
+	Bounds      [4]int `json:"bounds"` // left, top, right, bottom in pixels
+	Clickable   bool   `json:"clickable,omitempty"`
+}
+
+func (e *UIElement) Center() (int, int) {
+	return (e.Bounds[0] + e.Bounds[2]) / 2, (e.Bounds[1] + e.Bounds[3]) / 2
+}
+
+func (e *UIElement) Contains(x, y int) bool {
+	return x >= e.Bounds[0] && x <= e.Bounds[2] && y >= e.Bounds[1] && y <= e.Bounds[3]
+}
+
+type OverlayKind string
+
+const (
+	OverlayFloating OverlayKind = "floating" // drawn over other apps: chat heads, floating widgets
+	OverlayPiP      OverlayKind = "pip"      // picture-in-picture video
+)
+
+// Overlay is a window of another app shown above the foreground one, which
+// takes the taps on the area it covers.
+type Overlay struct {
+	Kind    OverlayKind `json:"kind"`
+	Package string      `json:"package"`
+	Bounds  [4]int      `json:"bounds"` // left, top, right, bottom in pixels
+}
+
+func (o *Overlay) Contains(x, y int) bool {
+	return x >= o.Bounds[0] && x <= o.Bounds[2] && y >= o.Bounds[1] && y <= o.Bounds[3]
+}
+
+// Notification is a notification in the shade of the device.
+type Notification struct {
+	Key     string `json:"key"` // as the system tells notifications apart
+	Package string `json:"package"`
+	Title   string `json:"title,omitempty"`
+	Text    string `json:"text,omitempty"`
+}
@@ -0,0 +1,15 @@
+package definitions
+
+// VoiceConfig configures the speech endpoints, which speak the OpenAI audio
+// API and default to the model endpoint.
+type VoiceConfig struct {
+	BaseURL string
+	APIKey  string
+
+	ASRModel    string
+	ASRLanguage string // ISO-639-1 hint, empty lets the endpoint detect it
+
+	TTSBackend string // system or openai, empty disables speech output
+	TTSModel   string
+	TTSVoice   string // voice name of the backend, empty for its default
+}
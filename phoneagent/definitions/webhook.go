@@ -0,0 +1,13 @@
+package definitions
+
+// WebhookConfig is where task events are posted, see package webhook.
+type WebhookConfig struct {
+	// URLs receive a JSON payload, in the message format of Slack or Feishu
+	// for their incoming webhook URLs.
+	URLs []string
+	// Events are the events posted: finished, failed, confirmation and
+	// takeover; empty posts all of them.
+	Events []string
+	// Secret signs generic payloads with HMAC-SHA256, see webhook.SignatureHeader.
+	Secret string
+}
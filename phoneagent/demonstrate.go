@@ -0,0 +1,363 @@
+package phoneagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"autoglm-go/constants"
+	"autoglm-go/phoneagent/definitions"
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/recorder"
+)
+
+// GestureDevice is implemented by devices that report the input of the
+// user, to record demonstrations.
+type GestureDevice interface {
+	Gestures(ctx context.Context, deviceID string) (<-chan definitions.Gesture, error)
+}
+
+const (
+	// demoSettle is waited after a gesture before the screen is captured.
+	demoSettle = 500 * time.Millisecond
+	// doubleTapTime and doubleTapDistance tell a double tap from two taps.
+	doubleTapTime     = 300 * time.Millisecond
+	doubleTapDistance = 40
+)
+
+// demoStep is a recorded gesture not yet written, the next one may still
+// change it into a Type or a Double Tap.
+type demoStep struct {
+	record *recorder.Record
+	image  []byte
+	at     time.Time
+	point  [2]int                 // of a tap, in pixels
+	field  *definitions.UIElement // the text field typed into
+}
+
+// Demonstrate records the user doing task on the device until ctx ends, and
+// returns the session id. Every gesture is written to AgentConfig.RecordDir
+// like a step of the model: the screen before it, its app, and the action
+// it amounts to, with the element it touched as the thinking. Taps on the
+// keyboard become a Type of the text that appeared in the field, two quick
+// taps a Double Tap, and a tap on the home screen that opens a known app a
+// Launch. The session replays with LoadReplay and guides the model through
+// similar tasks with WithSkill.
+func (r *PhoneAgent) Demonstrate(ctx context.Context, task string) (string, error) {
+	device, ok := r.Device.(GestureDevice)
+	if !ok {
+		return "", fmt.Errorf("devices of type %T cannot record demonstrations", r.Device)
+	}
+	if r.AgentConfig.RecordDir == "" {
+		return "", errors.New("recording a demonstration requires a record dir")
+	}
+	rec, err := r.stepRecorder()
+	if err != nil {
+		return "", err
+	}
+	r.Reset(ctx)
+	r.startSession()
+
+	gestures, err := device.Gestures(ctx, r.AgentConfig.DeviceID)
+	if err != nil {
+		return "", err
+	}
+	r.log().Infof("🎬 recording the demonstration of %q, press Ctrl-C when done", task)
+
+	step := 0
+	var pending *demoStep
+	write := func(p *demoStep) {
+		if p == nil {
+			return
+		}
+		step++
+		p.record.Step = step
+		if step == 1 {
+			// as the model would have been asked
+			p.record.SystemPrompt = r.AgentConfig.GetSystemPrompt()
+			p.record.Prompt = task + "\n\n" + p.record.Prompt
+		}
+		r.log().Infof("🎬 step %d: %s", step, p.record.Thinking)
+		if err := rec.Write(p.record, p.image); err != nil {
+			r.log().Warnf("failed to record step %d, err: %v", step, err)
+		}
+	}
+
+	before := r.demoObservation(ctx)
+	for {
+		var gesture definitions.Gesture
+		select {
+		case <-ctx.Done():
+		case gesture, ok = <-gestures:
+		}
+		if ctx.Err() != nil || !ok {
+			break
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(demoSettle):
+		}
+		// captured even when interrupted, for the last gesture
+		after := r.demoObservation(context.WithoutCancel(ctx))
+
+		next := r.demoStep(task, gesture, before, after)
+		switch {
+		case next == nil:
+		case pending != nil && pending.field != nil && next.field != nil && next.field.ResourceID == pending.field.ResourceID:
+			// more keys of the same field
+			pending.record.Action["text"] = next.record.Action["text"]
+			pending.record.ActionText = helper.FormatAction(pending.record.Action)
+			pending.record.Thinking = next.record.Thinking
+		case pending != nil && isDoubleTap(pending, next):
+			pending.record.Action["action"] = "Double Tap"
+			pending.record.ActionText = helper.FormatAction(pending.record.Action)
+			pending.record.Thinking = strings.Replace(pending.record.Thinking, "tap", "double tap", 1)
+		default:
+			write(pending)
+			pending = next
+		}
+		before = after
+	}
+	write(pending)
+
+	finish := helper.Action{"_metadata": "finish", "message": "demonstration ended"}
+	write(&demoStep{record: r.demoRecord(task, before, finish, "the demonstration ends"), image: screenshotData(before)})
+	r.log().Infof("🎬 demonstration recorded as session %s, %d step(s)", r.SessionID, step)
+	return r.SessionID, nil
+}
+
+// demoObservation captures the screen with its UI dump, redacted like the
+// observations of the model.
+func (r *PhoneAgent) demoObservation(ctx context.Context) *observation {
+	obs := &observation{}
+	obs.currentApp, _ = r.Device.GetCurrentApp(ctx, r.AgentConfig.DeviceID)
+	obs.uiElements, _ = r.Device.DumpUI(ctx, r.AgentConfig.DeviceID)
+	obs.screenshot, _ = r.Device.GetScreenshot(ctx, r.AgentConfig.DeviceID)
+	return r.redactObservation(obs)
+}
+
+func screenshotData(obs *observation) []byte {
+	if obs.screenshot == nil {
+		return nil
+	}
+	return obs.screenshot.Data
+}
+
+// demoStep converts gesture, between the screens before and after it, into
+// the action of the model. Gestures that have none are nil.
+func (r *PhoneAgent) demoStep(task string, gesture definitions.Gesture, before, after *observation) *demoStep {
+	var action helper.Action
+	var thinking string
+	step := &demoStep{at: gesture.At}
+	switch gesture.Kind {
+	case definitions.GestureKey:
+		switch gesture.Key {
+		case "back":
+			action, thinking = helper.Action{"_metadata": "do", "action": "Back"}, "go back"
+		case "home":
+			action, thinking = helper.Action{"_metadata": "do", "action": "Home"}, "go to the home screen"
+		default:
+			r.log().Debugf("🎬 %s key not recorded", gesture.Key)
+			return nil
+		}
+	case definitions.GestureSwipe:
+		action = helper.Action{"_metadata": "do", "action": "Swipe",
+			"start": relative(gesture.Start, before), "end": relative(gesture.End, before)}
+		thinking = "swipe " + direction(gesture.Start, gesture.End)
+	case definitions.GestureTap, definitions.GestureLongPress:
+		x, y := gesture.Start[0], gesture.Start[1]
+		if field := typedField(before, after, x, y); field != nil {
+			step.field = field
+			action = helper.Action{"_metadata": "do", "action": "Type", "text": field.Text}
+			thinking = fmt.Sprintf("type %q", field.Text)
+			break
+		}
+		if before.currentApp == "System Home" && after.currentApp != before.currentApp && constants.APP_PACKAGES_ANDROID[after.currentApp] != "" {
+			action, thinking = helper.Action{"_metadata": "do", "action": "Launch", "app": after.currentApp}, "open "+after.currentApp
+			break
+		}
+		name, verb := "Tap", "tap"
+		if gesture.Kind == definitions.GestureLongPress {
+			name, verb = "Long Press", "long press"
+		} else {
+			step.point = gesture.Start
+		}
+		action = helper.Action{"_metadata": "do", "action": name, "element": relative(gesture.Start, before)}
+		if label := labelAt(before.uiElements, x, y); label != "" {
+			thinking = fmt.Sprintf("%s %q", verb, label)
+		} else {
+			thinking = fmt.Sprintf("%s at %v", verb, action["element"])
+		}
+	default:
+		return nil
+	}
+	step.record = r.demoRecord(task, before, action, thinking)
+	step.image = screenshotData(before)
+	return step
+}
+
+func (r *PhoneAgent) demoRecord(task string, obs *observation, action helper.Action, thinking string) *recorder.Record {
+	record := &recorder.Record{
+		Session:    r.SessionID,
+		Time:       time.Now(),
+		Task:       task,
+		DeviceID:   r.AgentConfig.DeviceID,
+		App:        obs.currentApp,
+		Prompt:     helper.BuildScreenInfo(obs.currentApp),
+		Model:      "demonstration",
+		Thinking:   thinking,
+		Action:     action,
+		ActionText: helper.FormatAction(action),
+		Result:     &recorder.Result{Success: true, Finished: action["_metadata"] == "finish"},
+	}
+	if s := obs.screenshot; s != nil {
+		record.Screenshot = &recorder.Screenshot{Width: s.Width, Height: s.Height, Sensitive: s.IsSensitive}
+	}
+	return record
+}
+
+// relative converts a point of the screen of obs to the 0-999 grid of the
+// model.
+func relative(point [2]int, obs *observation) []int {
+	if obs.screenshot == nil || obs.screenshot.Width == 0 || obs.screenshot.Height == 0 {
+		return []int{point[0], point[1]}
+	}
+	return []int{
+		min(999, point[0]*1000/obs.screenshot.Width),
+		min(999, point[1]*1000/obs.screenshot.Height),
+	}
+}
+
+func direction(start, end [2]int) string {
+	dx, dy := end[0]-start[0], end[1]-start[1]
+	if math.Abs(float64(dx)) > math.Abs(float64(dy)) {
+		if dx > 0 {
+			return "right"
+		}
+		return "left"
+	}
+	if dy > 0 {
+		return "down"
+	}
+	return "up"
+}
+
+// typedField returns the text field whose text a tap at x, y changed without
+// touching it, a key of the keyboard.
+func typedField(before, after *observation, x, y int) *definitions.UIElement {
+	for i := range after.uiElements {
+		field := &after.uiElements[i]
+		if !strings.Contains(field.Class, "EditText") || field.Contains(x, y) {
+			continue
+		}
+		for _, old := range before.uiElements {
+			if old.Class == field.Class && old.ResourceID == field.ResourceID && old.Bounds == field.Bounds {
+				if old.Text != field.Text {
+					return field
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func isDoubleTap(first, second *demoStep) bool {
+	if first.field != nil || second.field != nil || first.record.Action["action"] != "Tap" || second.record.Action["action"] != "Tap" {
+		return false
+	}
+	return second.at.Sub(first.at) <= doubleTapTime &&
+		math.Hypot(float64(second.point[0]-first.point[0]), float64(second.point[1]-first.point[1])) <= doubleTapDistance
+}
+
+// labelAt is the text of the smallest element of elements at x, y.
+func labelAt(elements []definitions.UIElement, x, y int) string {
+	label, area := "", -1
+	for i := range elements {
+		e := &elements[i]
+		text := e.Text
+		if text == "" {
+			text = e.ContentDesc
+		}
+		if text == "" || !e.Contains(x, y) {
+			continue
+		}
+		if a := (e.Bounds[2] - e.Bounds[0]) * (e.Bounds[3] - e.Bounds[1]); area < 0 || a < area {
+			label, area = text, a
+		}
+	}
+	return label
+}
+
+// Skill is a recorded demonstration of a task, see WithSkill.
+type Skill struct {
+	Task  string
+	Steps []string // what the user did, one line per step
+}
+
+// LoadSkill reads a demonstration, or any session or trajectory LoadReplay
+// reads.
+func LoadSkill(path string) (*Skill, error) {
+	replay, err := LoadReplay(path)
+	if err != nil {
+		return nil, err
+	}
+	skill := &Skill{Task: replay.Task}
+	for _, step := range replay.Steps {
+		if step.Action["_metadata"] == "finish" {
+			continue
+		}
+		line := helper.FormatAction(step.Action)
+		if step.Thinking != "" {
+			line = fmt.Sprintf("%s: %s", step.Thinking, line)
+		}
+		if step.App != "" {
+			line = fmt.Sprintf("[%s] %s", step.App, line)
+		}
+		skill.Steps = append(skill.Steps, line)
+	}
+	if len(skill.Steps) == 0 {
+		return nil, fmt.Errorf("%s has no steps", path)
+	}
+	return skill, nil
+}
+
+const (
+	skillGuideCn = "参考示范：用户完成过类似的任务「%s」，步骤如下。请参照这些步骤，但以当前屏幕和本次任务为准，内容不同（如联系人、文字）时相应调整，不要照搬坐标：\n%s"
+	skillGuideEn = "Demonstration: the user did a similar task, %q, with the steps below. Follow them, but go by the current screen and this task, adapt what differs, e.g. contacts or texts, and do not copy the coordinates blindly:\n%s"
+)
+
+func (s *Skill) guide(lang string) string {
+	var steps strings.Builder
+	for i, step := range s.Steps {
+		fmt.Fprintf(&steps, "%d. %s\n", i+1, step)
+	}
+	format := skillGuideCn
+	if lang == "en" {
+		format = skillGuideEn
+	}
+	return fmt.Sprintf(format, s.Task, strings.TrimRight(steps.String(), "\n"))
+}
+
+type skillKey struct{}
+
+// WithSkill returns ctx whose tasks are shown skill with the task, as an
+// example to generalize from.
+func WithSkill(ctx context.Context, skill *Skill) context.Context {
+	if skill == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, skillKey{}, skill)
+}
+
+// skillPrompt is the task with the guide of the skill of ctx, if any.
+func (r *PhoneAgent) skillPrompt(ctx context.Context, task string) string {
+	skill, _ := ctx.Value(skillKey{}).(*Skill)
+	if skill == nil {
+		return task
+	}
+	return task + "\n\n" + skill.guide(r.AgentConfig.Lang)
+}
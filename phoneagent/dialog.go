@@ -0,0 +1,51 @@
+package phoneagent
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	maxDialogsPerStep = 3 // dialogs can come one after another
+	dialogSettle      = 500 * time.Millisecond
+)
+
+// checkDialogs taps away system dialogs the Dialogs policy handles before the
+// model sees them, and returns the observation to continue with. The model is
+// told what was done in the next observation.
+func (r *PhoneAgent) checkDialogs(ctx context.Context, obs *observation) *observation {
+	if r.Dialogs == nil || obs.screenshot == nil || r.AgentConfig.DryRun || r.AgentConfig.ReadOnly {
+		return obs
+	}
+	deviceID := r.AgentConfig.DeviceID
+
+	for i := 0; i < maxDialogsPerStep; i++ {
+		elements := obs.uiElements
+		if elements == nil {
+			var err error
+			if elements, err = r.Device.DumpUI(ctx, deviceID); err != nil {
+				return obs
+			}
+		}
+		detection, err := r.Dialogs.Handle(ctx, r.Device, deviceID, elements, r.uiLanguage)
+		if err != nil {
+			r.log().Warnf("failed to handle dialog, err: %v", err)
+			return obs
+		}
+		if detection == nil {
+			return obs
+		}
+		r.hookObservations = append(r.hookObservations,
+			fmt.Sprintf("a %s dialog (%q) was closed automatically by tapping %q", detection.Rule.Kind, detection.Matched, detection.Button))
+
+		select {
+		case <-ctx.Done():
+			return obs
+		case <-time.After(dialogSettle):
+		}
+		r.lastUIElements = nil
+		obs = r.captureObservation(ctx)
+	}
+	return obs
+}
@@ -0,0 +1,100 @@
+package dialog
+
+import (
+	"strings"
+
+	"autoglm-go/phoneagent/definitions"
+	"autoglm-go/phoneagent/uilang"
+)
+
+type Kind string
+
+const (
+	Update  Kind = "update"  // app or system update prompts
+	Rating  Kind = "rating"  // rate us, leave a review
+	Battery Kind = "battery" // battery optimization and background run requests
+)
+
+// Rule recognizes a dialog by any of its Match texts and names the buttons
+// each policy taps, first found first. Texts are matched case-insensitively
+// against texts and content descriptions of the UI dump.
+type Rule struct {
+	Kind    Kind     `json:"kind"`
+	Match   []string `json:"match"`
+	Dismiss []string `json:"dismiss"`
+	Accept  []string `json:"accept,omitempty"`
+}
+
+// DefaultRules are the built-in Chinese and English rules.
+var DefaultRules = []Rule{
+	{
+		Kind:    Update,
+		Match:   []string{"发现新版本", "版本更新", "新版本", "升级到最新版本", "new version available", "update available", "a new version of"},
+		Dismiss: []string{"以后再说", "下次再说", "暂不更新", "暂不升级", "稍后更新", "忽略此版本", "取消", "not now", "later", "remind me later", "skip", "no thanks", "cancel"},
+		Accept:  []string{"立即更新", "立即升级", "更新", "升级", "update now", "update"},
+	},
+	{
+		Kind:    Rating,
+		Match:   []string{"给个好评", "为我们评分", "喜欢这个应用吗", "鼓励一下", "去应用商店评分", "rate us", "rate this app", "enjoying", "leave a review", "how would you rate"},
+		Dismiss: []string{"残忍拒绝", "下次再说", "以后再说", "不了", "暂不", "取消", "no thanks", "not now", "maybe later", "remind me later", "never", "cancel"},
+		Accept:  []string{"去评分", "去好评", "五星好评", "rate now", "rate"},
+	},
+	{
+		Kind:    Battery,
+		Match:   []string{"电池优化", "忽略电池优化", "允许应用始终在后台运行", "后台运行权限", "battery optimization", "ignore battery optimizations", "always run in the background", "run in background"},
+		Dismiss: []string{"拒绝", "不允许", "取消", "deny", "don't allow", "cancel", "no"},
+		Accept:  []string{"允许", "确定", "allow", "ok"},
+	},
+}
+
+// Detection is a dialog found on the screen.
+type Detection struct {
+	Rule    *Rule
+	Matched string // the text that gave it away
+	Button  string // the button Handler.Handle tapped
+}
+
+// Detect looks for the dialog of the first matching rule in the UI dump. lang
+// is the UI language for folding, nil folds like English.
+func Detect(elements []definitions.UIElement, rules []Rule, lang *uilang.Language) *Detection {
+	if lang == nil {
+		lang = uilang.New("")
+	}
+	for i := range rules {
+		for _, word := range rules[i].Match {
+			folded := lang.Fold(word)
+			for j := range elements {
+				if strings.Contains(lang.Fold(elements[j].Text+" "+elements[j].ContentDesc), folded) {
+					return &Detection{Rule: &rules[i], Matched: word}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// findButton returns the element labelled with the first of labels found,
+// preferring clickable elements, and the label. Labels must match the whole
+// text, so that "update" does not hit the message of the dialog.
+func findButton(elements []definitions.UIElement, labels []string, lang *uilang.Language) (*definitions.UIElement, string) {
+	for _, label := range labels {
+		folded := lang.Fold(label)
+		var found *definitions.UIElement
+		for i := range elements {
+			e := &elements[i]
+			if lang.Fold(e.Text) != folded && lang.Fold(e.ContentDesc) != folded {
+				continue
+			}
+			if e.Clickable {
+				return e, label
+			}
+			if found == nil {
+				found = e
+			}
+		}
+		if found != nil {
+			return found, label
+		}
+	}
+	return nil, ""
+}
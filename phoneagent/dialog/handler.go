@@ -0,0 +1,113 @@
+package dialog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"autoglm-go/phoneagent/definitions"
+	"autoglm-go/phoneagent/uilang"
+	logs "github.com/sirupsen/logrus"
+)
+
+type Policy string
+
+const (
+	Dismiss Policy = "dismiss" // tap the dismiss button
+	Accept  Policy = "accept"  // tap the accept button
+	Ignore  Policy = "ignore"  // leave the dialog to the model
+)
+
+// Device is the part of the device driver the handler uses.
+type Device interface {
+	Tap(ctx context.Context, x, y int, deviceID string) error
+}
+
+// Handler taps dialogs away according to the policy of their kind.
+type Handler struct {
+	Rules    []Rule
+	Policies map[Kind]Policy // kinds not listed use Default
+	Default  Policy
+}
+
+// NewHandler builds a handler with the rules of the file, if any, checked
+// before DefaultRules.
+func NewHandler(policy, rulesFile string) (*Handler, error) {
+	h := &Handler{Policies: map[Kind]Policy{}, Default: Ignore}
+	for _, part := range strings.Split(policy, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kind, value, ok := strings.Cut(part, "=")
+		if !ok {
+			kind, value = "", kind
+		}
+		p := Policy(strings.TrimSpace(value))
+		if p != Dismiss && p != Accept && p != Ignore {
+			return nil, fmt.Errorf("invalid dialog policy %q, want dismiss, accept or ignore", value)
+		}
+		if kind == "" {
+			h.Default = p
+		} else {
+			h.Policies[Kind(strings.TrimSpace(kind))] = p
+		}
+	}
+
+	if rulesFile != "" {
+		data, err := os.ReadFile(rulesFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &h.Rules); err != nil {
+			return nil, fmt.Errorf("invalid dialog rules file %s: %w", rulesFile, err)
+		}
+	}
+	h.Rules = append(h.Rules, DefaultRules...)
+	return h, nil
+}
+
+func (h *Handler) policy(kind Kind) Policy {
+	if p, ok := h.Policies[kind]; ok {
+		return p
+	}
+	return h.Default
+}
+
+// Handle taps the button of a dialog on the screen, and returns the dialog it
+// handled. Dialogs with the ignore policy, or without a button of their
+// policy, are left alone and nil is returned.
+func (h *Handler) Handle(ctx context.Context, device Device, deviceID string, elements []definitions.UIElement, lang *uilang.Language) (*Detection, error) {
+	if lang == nil {
+		lang = uilang.New("")
+	}
+	detection := Detect(elements, h.Rules, lang)
+	if detection == nil {
+		return nil, nil
+	}
+
+	var labels []string
+	switch policy := h.policy(detection.Rule.Kind); policy {
+	case Dismiss:
+		labels = detection.Rule.Dismiss
+	case Accept:
+		labels = detection.Rule.Accept
+	default:
+		return nil, nil
+	}
+	button, label := findButton(elements, labels, lang)
+	if button == nil {
+		logs.Debugf("%s dialog %q has no button to %s", detection.Rule.Kind, detection.Matched, h.policy(detection.Rule.Kind))
+		return nil, nil
+	}
+
+	x, y := button.Center()
+	if err := device.Tap(ctx, x, y, deviceID); err != nil {
+		return nil, err
+	}
+	detection.Button = label
+	logs.Infof("🪟 %s dialog %q: tapped %q", detection.Rule.Kind, detection.Matched, label)
+	return detection, nil
+}
@@ -0,0 +1,24 @@
+package phoneagent
+
+import (
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/utils"
+)
+
+// dryRunMessage tells the model the action was not performed, so that it
+// goes on planning from the same screen.
+const dryRunMessage = "Dry run: the action was not performed on the device, the screen is unchanged. Continue with the action that would come next, or finish."
+
+// dryRun logs the action instead of running it, see AgentConfig.DryRun. Only
+// finish actions run, so that the task ends as it would.
+func (r *PhoneAgent) dryRun(action helper.Action) (helper.ActionResult, bool) {
+	if !r.AgentConfig.DryRun || utils.AnyToString(action["_metadata"]) != "do" {
+		return helper.ActionResult{}, false
+	}
+	what := "would run"
+	if kind, message, needed := humanAction(action); needed {
+		what = "would ask for " + string(kind) + " (" + message + ") and run"
+	}
+	r.log().Infof("🧪 dry run, step %d %s: %s", r.StepCount, what, utils.JsonString(action))
+	return helper.ActionResult{Success: true, ShouldFinish: false, Message: dryRunMessage}, true
+}
@@ -0,0 +1,348 @@
+// Package emulator provisions headless Android emulators for the runs
+// without physical phones, e.g. the regression suites of CI: AVDs started
+// with the emulator of the Android SDK, or docker-android containers. They
+// are adb devices once booted and are torn down after the run.
+package emulator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	logs "github.com/sirupsen/logrus"
+)
+
+// Backend is how emulators are started.
+type Backend string
+
+const (
+	// AVD starts an AVD with the emulator of the Android SDK, read-only so
+	// that instances share it.
+	AVD Backend = "avd"
+	// Docker runs a docker-android container per emulator, reached over adb
+	// at a port of localhost.
+	Docker Backend = "docker"
+)
+
+// Spec describes the emulators of a Farm.
+type Spec struct {
+	Backend Backend
+
+	// AVD is the AVD started by the AVD backend. With SystemImage, e.g.
+	// "system-images;android-34;google_apis;x86_64", it is created with
+	// avdmanager when it does not exist.
+	AVD         string
+	SystemImage string
+
+	// Image is the container image of the Docker backend, e.g.
+	// budtmo/docker-android:emulator_14.0, which serves adb on port 5555.
+	Image string
+
+	// BootTimeout is how long an emulator has to boot, 5 minutes when 0.
+	BootTimeout time.Duration
+}
+
+// instance is a started emulator.
+type instance struct {
+	serial string
+
+	cmd    *exec.Cmd     // AVD
+	exited chan struct{} // closed when cmd exits
+	output bytes.Buffer  // of cmd, read once it exited
+
+	container string // Docker
+}
+
+// Farm starts emulators and tears them down.
+type Farm struct {
+	spec Spec
+
+	mu        sync.Mutex
+	instances []*instance
+}
+
+// NewFarm creates a farm of emulators of spec.
+func NewFarm(spec Spec) (*Farm, error) {
+	switch spec.Backend {
+	case AVD:
+		if spec.AVD == "" {
+			return nil, errors.New("the avd backend needs an avd name")
+		}
+	case Docker:
+		if spec.Image == "" {
+			return nil, errors.New("the docker backend needs an image")
+		}
+	default:
+		return nil, fmt.Errorf("unknown emulator backend %q, want avd or docker", spec.Backend)
+	}
+	if spec.BootTimeout <= 0 {
+		spec.BootTimeout = 5 * time.Minute
+	}
+	return &Farm{spec: spec}, nil
+}
+
+// Provision starts n emulators at once and returns their adb serials once
+// they booted. The emulators started are torn down when one fails.
+func (f *Farm) Provision(ctx context.Context, n int) ([]string, error) {
+	if f.spec.Backend == AVD && f.spec.SystemImage != "" {
+		if err := f.createAVD(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	started := make([]*instance, 0, n)
+	for i := 0; i < n; i++ {
+		var inst *instance
+		var err error
+		if f.spec.Backend == AVD {
+			inst, err = f.startAVD()
+		} else {
+			inst, err = f.startContainer(ctx)
+		}
+		if err != nil {
+			f.teardown(context.WithoutCancel(ctx), started)
+			return nil, err
+		}
+		logs.Infof("📱 emulator %s starting", inst.serial)
+		started = append(started, inst)
+	}
+
+	errs := make([]error, len(started))
+	var wg sync.WaitGroup
+	for i, inst := range started {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = f.waitBoot(ctx, inst)
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		f.teardown(context.WithoutCancel(ctx), started)
+		return nil, err
+	}
+
+	f.mu.Lock()
+	f.instances = append(f.instances, started...)
+	f.mu.Unlock()
+
+	serials := make([]string, 0, len(started))
+	for _, inst := range started {
+		serials = append(serials, inst.serial)
+	}
+	return serials, nil
+}
+
+// Serials returns the serials of the emulators provisioned.
+func (f *Farm) Serials() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	serials := make([]string, 0, len(f.instances))
+	for _, inst := range f.instances {
+		serials = append(serials, inst.serial)
+	}
+	return serials
+}
+
+// Teardown stops all the emulators provisioned.
+func (f *Farm) Teardown(ctx context.Context) {
+	f.mu.Lock()
+	instances := f.instances
+	f.instances = nil
+	f.mu.Unlock()
+	f.teardown(ctx, instances)
+}
+
+func (f *Farm) teardown(ctx context.Context, instances []*instance) {
+	var wg sync.WaitGroup
+	for _, inst := range instances {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if inst.cmd != nil {
+				f.stopAVD(ctx, inst)
+			} else {
+				f.stopContainer(ctx, inst)
+			}
+			logs.Infof("📱 emulator %s torn down", inst.serial)
+		}()
+	}
+	wg.Wait()
+}
+
+// createAVD creates the AVD of the spec from its system image, unless it
+// exists.
+func (f *Farm) createAVD(ctx context.Context) error {
+	output, err := exec.CommandContext(ctx, "emulator", "-list-avds").Output()
+	if err != nil {
+		return fmt.Errorf("emulator -list-avds failed: %w", err)
+	}
+	for _, name := range strings.Fields(string(output)) {
+		if name == f.spec.AVD {
+			return nil
+		}
+	}
+
+	logs.Infof("📱 creating avd %s from %s", f.spec.AVD, f.spec.SystemImage)
+	cmd := exec.CommandContext(ctx, "avdmanager", "create", "avd", "-n", f.spec.AVD, "-k", f.spec.SystemImage, "--force")
+	cmd.Stdin = strings.NewReader("no\n") // no custom hardware profile
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("avdmanager create avd failed: %v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// startAVD starts the emulator of the AVD at a free pair of console and adb
+// ports, the serial of the emulator is emulator-<console port>.
+func (f *Farm) startAVD() (*instance, error) {
+	port, err := f.consolePort()
+	if err != nil {
+		return nil, err
+	}
+	inst := &instance{serial: fmt.Sprintf("emulator-%d", port), exited: make(chan struct{})}
+	inst.cmd = exec.Command("emulator",
+		"-avd", f.spec.AVD,
+		"-port", strconv.Itoa(port),
+		"-read-only",
+		"-no-window", "-no-audio", "-no-boot-anim", "-no-snapshot",
+		"-gpu", "swiftshader_indirect",
+	)
+	inst.cmd.Stdout = &inst.output
+	inst.cmd.Stderr = &inst.output
+	if err := inst.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start the emulator: %w", err)
+	}
+	go func() {
+		_ = inst.cmd.Wait()
+		close(inst.exited)
+	}()
+	return inst, nil
+}
+
+// consolePort returns the first even port from 5554 whose pair is free and
+// not taken by an emulator of the farm.
+func (f *Farm) consolePort() (int, error) {
+	f.mu.Lock()
+	taken := map[string]bool{}
+	for _, inst := range f.instances {
+		taken[inst.serial] = true
+	}
+	f.mu.Unlock()
+
+	for port := 5554; port <= 5682; port += 2 {
+		if taken[fmt.Sprintf("emulator-%d", port)] || !portFree(port) || !portFree(port+1) {
+			continue
+		}
+		return port, nil
+	}
+	return 0, errors.New("no free emulator port")
+}
+
+func portFree(port int) bool {
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return false
+	}
+	_ = listener.Close()
+	return true
+}
+
+// stopAVD asks the emulator to quit, and kills it when it does not.
+func (f *Farm) stopAVD(ctx context.Context, inst *instance) {
+	_, _ = adb(ctx, inst.serial, "emu", "kill")
+	select {
+	case <-inst.exited:
+	case <-time.After(15 * time.Second):
+		_ = inst.cmd.Process.Kill()
+		<-inst.exited
+	}
+}
+
+// startContainer runs a container of the image publishing adb at a free port
+// of localhost, the serial is that address.
+func (f *Farm) startContainer(ctx context.Context) (*instance, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	_ = listener.Close()
+
+	name := fmt.Sprintf("autoglm-emulator-%d", port)
+	// a container of an earlier run that was not torn down
+	_ = exec.CommandContext(ctx, "docker", "rm", "-f", name).Run()
+
+	output, err := exec.CommandContext(ctx, "docker", "run", "-d", "--rm",
+		"--name", name,
+		"--privileged", "--device", "/dev/kvm",
+		"-p", fmt.Sprintf("127.0.0.1:%d:5555", port),
+		f.spec.Image,
+	).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("docker run failed: %v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	return &instance{
+		serial:    fmt.Sprintf("127.0.0.1:%d", port),
+		container: strings.TrimSpace(string(output)),
+	}, nil
+}
+
+func (f *Farm) stopContainer(ctx context.Context, inst *instance) {
+	_ = exec.CommandContext(ctx, "adb", "disconnect", inst.serial).Run()
+	if output, err := exec.CommandContext(ctx, "docker", "rm", "-f", inst.container).CombinedOutput(); err != nil {
+		logs.Warnf("📱 failed to remove container %s, err: %v, output: %s", inst.container, err, strings.TrimSpace(string(output)))
+	}
+}
+
+// waitBoot waits until Android finished booting on the emulator, then turns
+// its animations off, which only slow the agent down.
+func (f *Farm) waitBoot(ctx context.Context, inst *instance) error {
+	ctx, cancel := context.WithTimeout(ctx, f.spec.BootTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	for {
+		if inst.container != "" {
+			_ = exec.CommandContext(ctx, "adb", "connect", inst.serial).Run()
+		}
+		if output, err := adb(ctx, inst.serial, "shell", "getprop", "sys.boot_completed"); err == nil && strings.TrimSpace(output) == "1" {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("emulator %s did not boot in %s", inst.serial, f.spec.BootTimeout)
+		case <-inst.exited:
+			return fmt.Errorf("emulator %s exited: %s", inst.serial, lastLines(inst.output.String(), 5))
+		case <-ticker.C:
+		}
+	}
+
+	for _, setting := range []string{"window_animation_scale", "transition_animation_scale", "animator_duration_scale"} {
+		_, _ = adb(ctx, inst.serial, "shell", "settings", "put", "global", setting, "0")
+	}
+	logs.Infof("📱 emulator %s booted", inst.serial)
+	return nil
+}
+
+func adb(ctx context.Context, serial string, args ...string) (string, error) {
+	output, err := exec.CommandContext(ctx, "adb", append([]string{"-s", serial}, args...)...).CombinedOutput()
+	return string(output), err
+}
+
+// lastLines returns the last n lines of s.
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
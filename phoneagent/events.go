@@ -0,0 +1,82 @@
+package phoneagent
+
+import (
+	"context"
+	"time"
+
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/llm"
+)
+
+type EventType string
+
+const (
+	EventScreenshot   EventType = "screenshot"    // the screen a step starts from, Image is what the model sees
+	EventThinking     EventType = "thinking"      // Delta is the next piece of the streamed reasoning
+	EventActionDelta  EventType = "action_delta"  // Delta is the next piece of the action as the model writes it
+	EventAction       EventType = "action"        // the parsed action, about to run
+	EventActionResult EventType = "action_result" // Success and Message of the action
+	EventPlan         EventType = "plan"          // the plan was made or revised or another subgoal is in progress, see Plan and Subgoal
+	EventProgress     EventType = "progress"      // the task runs past its soft deadline, Message sums up where it is
+	EventAnomaly      EventType = "anomaly"       // the step took far longer or more tokens than usual, Message says which
+	EventPaused       EventType = "paused"        // the task waits after the step, see PhoneAgent.PauseTask
+	EventResumed      EventType = "resumed"       // the paused task goes on
+	EventCancelled    EventType = "cancelled"     // the task was stopped with PhoneAgent.CancelTask, Message says at which step
+)
+
+// Event is a moment of a running task, for PhoneAgent.OnEvent.
+type Event struct {
+	Type    EventType     `json:"type"`
+	Step    int           `json:"step"`
+	At      time.Time     `json:"at"`
+	Delta   string        `json:"delta,omitempty"`
+	Action  helper.Action `json:"action,omitempty"`
+	Success bool          `json:"success,omitempty"`
+	Message string        `json:"message,omitempty"`
+	App     string        `json:"app,omitempty"`
+	Width   int           `json:"width,omitempty"`
+	Height  int           `json:"height,omitempty"`
+	Image   string        `json:"image,omitempty"` // data URL
+	// ImageURL is a signed URL of the image in the artifact storage, sent
+	// instead of Image to the streams asking for ?images=url.
+	ImageURL string `json:"image_url,omitempty"`
+
+	Bytes     int `json:"bytes,omitempty"`      // of the screenshot taken
+	SentBytes int `json:"sent_bytes,omitempty"` // of Image, before base64
+
+	Plan    []string `json:"plan,omitempty"`    // the subgoals of the planner
+	Subgoal int      `json:"subgoal,omitempty"` // in progress, from 1
+}
+
+func (r *PhoneAgent) emit(event Event) {
+	if r.OnEvent == nil {
+		return
+	}
+	event.Step = r.StepCount
+	event.At = time.Now()
+	r.OnEvent(event)
+}
+
+// streamThinking adds the thinking and action deltas of the model to the
+// events, next to the usual output of the client.
+func (r *PhoneAgent) streamThinking(ctx context.Context, opts llm.RequestOptions) llm.RequestOptions {
+	if r.OnEvent == nil {
+		return opts
+	}
+	opts = r.stepClient().DefaultOutput(ctx, opts)
+	output, actionOutput := opts.OnThinkingDelta, opts.OnActionDelta
+	step := r.StepCount
+	opts.OnThinkingDelta = func(delta string) {
+		if output != nil {
+			output(delta)
+		}
+		r.OnEvent(Event{Type: EventThinking, Step: step, At: time.Now(), Delta: delta})
+	}
+	opts.OnActionDelta = func(delta string) {
+		if actionOutput != nil {
+			actionOutput(delta)
+		}
+		r.OnEvent(Event{Type: EventActionDelta, Step: step, At: time.Now(), Delta: delta})
+	}
+	return opts
+}
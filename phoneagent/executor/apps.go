@@ -0,0 +1,104 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+
+	"autoglm-go/constants"
+	"autoglm-go/phoneagent/android"
+	"autoglm-go/phoneagent/definitions"
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/utils"
+)
+
+// AppManager is implemented by devices that start apps by package or intent
+// and reset them, for Launch with a package, Force_Stop and Clear_Data.
+type AppManager interface {
+	StartIntent(ctx context.Context, intent definitions.Intent, deviceID string) error
+	ForceStop(ctx context.Context, packageName, deviceID string) error
+	// ClearData deletes the data of the app, as a fresh install.
+	ClearData(ctx context.Context, packageName, deviceID string) error
+}
+
+// AppPackager is implemented by devices whose apps have other packages than
+// on Android, bundle ids on iOS and HarmonyOS, it returns the package of an
+// app name.
+type AppPackager interface {
+	AppPackage(appName string) (string, bool)
+}
+
+// Installer is implemented by devices that install apps, for Install.
+type Installer interface {
+	Install(ctx context.Context, deviceID string, paths []string, opts android.InstallOptions) error
+}
+
+var packageRe = regexp.MustCompile(`^[A-Za-z][\w]*(\.[A-Za-z][\w]*)+$`)
+
+// appPackage is the package of the app of action: its package, the package
+// of its app name on device, or the app itself when it is written as a
+// package.
+func appPackage(device Device, action helper.Action) (string, error) {
+	if pkg := utils.AnyToString(action["package"]); pkg != "" {
+		return pkg, nil
+	}
+	app := utils.AnyToString(action["app"])
+	if app == "" {
+		return "", fmt.Errorf("no app or package specified")
+	}
+	if packager, ok := device.(AppPackager); ok {
+		if pkg, ok := packager.AppPackage(app); ok {
+			return pkg, nil
+		}
+	} else if pkg, ok := constants.APP_PACKAGES_ANDROID[app]; ok {
+		return pkg, nil
+	}
+	if packageRe.MatchString(app) {
+		return app, nil
+	}
+	return "", fmt.Errorf("unknown app %s, give its package", app)
+}
+
+func forceStop(ctx context.Context, device Device, action helper.Action, opts Options) (helper.ActionResult, error) {
+	return manageApp(action, device, "force stop", func(manager AppManager, pkg string) error {
+		return manager.ForceStop(ctx, pkg, opts.DeviceID)
+	})
+}
+
+func clearData(ctx context.Context, device Device, action helper.Action, opts Options) (helper.ActionResult, error) {
+	return manageApp(action, device, "clear the data of", func(manager AppManager, pkg string) error {
+		return manager.ClearData(ctx, pkg, opts.DeviceID)
+	})
+}
+
+// manageApp runs call with the package of action on an AppManager.
+func manageApp(action helper.Action, device Device, what string, call func(manager AppManager, pkg string) error) (helper.ActionResult, error) {
+	manager, ok := device.(AppManager)
+	if !ok {
+		return helper.ActionResult{Success: false, Message: fmt.Sprintf("%s is not supported on this device", utils.AnyToString(action["action"]))}, nil
+	}
+	pkg, err := appPackage(device, action)
+	if err != nil {
+		return helper.ActionResult{Success: false, Message: err.Error()}, nil
+	}
+	return result(what+" "+pkg, call(manager, pkg))
+}
+
+// install installs apk, a path within Options.InstallDir.
+func install(ctx context.Context, device Device, action helper.Action, opts Options) (helper.ActionResult, error) {
+	if opts.InstallDir == "" {
+		return helper.ActionResult{Success: false, Message: "Install is disabled, no install folder is set"}, nil
+	}
+	installer, ok := device.(Installer)
+	if !ok {
+		return helper.ActionResult{Success: false, Message: "Install is not supported on this device"}, nil
+	}
+	apk := filepath.FromSlash(utils.AnyToString(action["apk"]))
+	if !filepath.IsLocal(apk) {
+		return helper.ActionResult{Success: false, Message: fmt.Sprintf("apk %s must be a path within the install folder", apk)}, nil
+	}
+	grant, _ := action["grant_permissions"].(bool)
+	paths := []string{filepath.Join(opts.InstallDir, apk)}
+	return result("install "+apk, installer.Install(ctx, opts.DeviceID, paths, android.InstallOptions{GrantPermissions: grant}))
+}
@@ -0,0 +1,390 @@
+// Package executor runs the actions of the model on a device, without the
+// model: other tools can tap, swipe and type with the same implementations
+// as the agent.
+//
+//	result, err := executor.Execute(ctx, &android.ADBDevice{}, helper.Action{
+//		"_metadata": "do", "action": "Tap", "element": []int{500, 920},
+//	}, executor.Options{DeviceID: "emulator-5554"})
+//
+// Coordinates are relative, from 0 to 1000 on both axes of the screen, as the
+// model gives them; helper.ParseAction reads actions written like it.
+package executor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"autoglm-go/phoneagent/calibration"
+	"autoglm-go/phoneagent/definitions"
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/utils"
+	logs "github.com/sirupsen/logrus"
+)
+
+// Device is what the actions need of a device, the devices of the android,
+// ios and appium packages implement it.
+type Device interface {
+	GetScreenshot(ctx context.Context, deviceID string) (*definitions.Screenshot, error)
+	GetCurrentApp(ctx context.Context, deviceID string) (string, error)
+	Tap(ctx context.Context, x, y int, deviceID string) error
+	DoubleTap(ctx context.Context, x, y int, deviceID string) error
+	LongPress(ctx context.Context, x, y int, deviceID string) error
+	Swipe(ctx context.Context, startX, startY, endX, endY int, deviceID string) error
+	Back(ctx context.Context, deviceID string) error
+	Home(ctx context.Context, deviceID string) error
+	LaunchApp(ctx context.Context, appName, deviceID string) (bool, error)
+	TypeText(ctx context.Context, text, deviceID string) error
+	ClearText(ctx context.Context, deviceID string) error
+	DetectAndSetADBKeyboard(ctx context.Context, deviceID string) (string, error)
+	RestoreKeyboard(ctx context.Context, ime, deviceID string) error
+	DumpUI(ctx context.Context, deviceID string) ([]definitions.UIElement, error)
+}
+
+// Gesturer is implemented by devices with timed presses, drags along a path
+// and two-finger pinches, points in pixels. Without it Long Press keeps the
+// duration of the device, Drag is a Swipe between two points and Pinch fails.
+type Gesturer interface {
+	Press(ctx context.Context, x, y int, duration time.Duration, deviceID string) error
+	// Drag holds the first point of path for hold, then moves through the
+	// others in duration.
+	Drag(ctx context.Context, path [][2]int, hold, duration time.Duration, deviceID string) error
+	// Pinch moves two fingers around x, y from fromSpan pixels apart to
+	// toSpan, apart to zoom in and together to zoom out.
+	Pinch(ctx context.Context, x, y, fromSpan, toSpan int, duration time.Duration, deviceID string) error
+}
+
+// Options tell Execute where the action runs.
+type Options struct {
+	DeviceID string
+	// ScreenWidth and ScreenHeight are the size of the screen in pixels, a
+	// screenshot is taken to learn it when either is 0 and the action has
+	// coordinates.
+	ScreenWidth  int
+	ScreenHeight int
+	// Calibration corrects the points of the device, see calibration.Fit,
+	// optional.
+	Calibration *calibration.Matrix
+	// InstallDir is the local folder Install takes APKs from, Install is
+	// refused without one.
+	InstallDir string
+}
+
+// Point converts the relative coordinates of an action to pixels.
+func (o Options) Point(element []int) (int, int) {
+	x := int(float64(element[0]) / float64(1000) * float64(o.ScreenWidth))
+	y := int(float64(element[1]) / float64(1000) * float64(o.ScreenHeight))
+	if o.Calibration != nil {
+		return o.Calibration.Apply(x, y)
+	}
+	return x, y
+}
+
+// typePause lets the keyboard settle between the steps of a Type.
+const typePause = time.Second
+
+// waitUntilPoll is how often Wait_Until checks the screen.
+const waitUntilPoll = time.Second
+
+// Gesture timings, the longest a model can ask for is maxGestureTime.
+const (
+	dragTime       = time.Second
+	pinchTime      = 500 * time.Millisecond
+	maxGestureTime = 10 * time.Second
+)
+
+// pinchSpan is the distance between the fingers of a pinch when they are
+// apart, as a share of the screen width.
+const pinchSpan = 0.6
+
+var handlers = map[string]func(ctx context.Context, device Device, action helper.Action, opts Options) (helper.ActionResult, error){
+	"Launch":     launch,
+	"Tap":        tap,
+	"Type":       typeText,
+	"Type_Name":  typeText,
+	"Swipe":      swipe,
+	"Back":       back,
+	"Home":       home,
+	"Double Tap": doubleTap,
+	"Long Press": longPress,
+	"Drag":       drag,
+	"Pinch":      pinch,
+	"Wait":       wait,
+	"Wait_Until": waitUntil,
+	"Force_Stop": forceStop,
+	"Clear_Data": clearData,
+	"Install":    install,
+}
+
+// positioned are the actions with coordinates.
+var positioned = map[string]bool{"Tap": true, "Swipe": true, "Double Tap": true, "Long Press": true, "Drag": true, "Pinch": true}
+
+// Supports reports whether Execute runs the do() action name. The others,
+// e.g. Take_over, need the user or the agent.
+func Supports(name string) bool {
+	_, ok := handlers[name]
+	return ok
+}
+
+// Execute runs action on the device. A finish action only reports its
+// message. As for the agent, an action that cannot run or that the device
+// fails is an unsuccessful result; the error is for ctx ending and for a
+// screen size that cannot be learned.
+func Execute(ctx context.Context, device Device, action helper.Action, opts Options) (helper.ActionResult, error) {
+	switch actionType := utils.AnyToString(action["_metadata"]); actionType {
+	case "finish":
+		return helper.ActionResult{Success: true, ShouldFinish: true, Message: utils.AnyToString(action["message"])}, nil
+	case "do":
+	default:
+		return helper.ActionResult{Success: false, Message: fmt.Sprintf("Unknown action type: %s", actionType)}, nil
+	}
+	name := utils.AnyToString(action["action"])
+	handler, ok := handlers[name]
+	if !ok {
+		return helper.ActionResult{Success: false, Message: fmt.Sprintf("Unknown action name: %s", name)}, nil
+	}
+	if positioned[name] && (opts.ScreenWidth <= 0 || opts.ScreenHeight <= 0) {
+		screenshot, err := device.GetScreenshot(ctx, opts.DeviceID)
+		if err != nil {
+			return helper.ActionResult{}, fmt.Errorf("failed to get the screen size: %w", err)
+		}
+		opts.ScreenWidth, opts.ScreenHeight = screenshot.Width, screenshot.Height
+	}
+	return handler(ctx, device, action, opts)
+}
+
+// result is the result of a device call that did what, unsuccessful with
+// the error of the device.
+func result(what string, err error) (helper.ActionResult, error) {
+	if err != nil {
+		return helper.ActionResult{Success: false, Message: fmt.Sprintf("failed to %s, err: %v", what, err)}, nil
+	}
+	return helper.ActionResult{Success: true}, nil
+}
+
+func launch(ctx context.Context, device Device, action helper.Action, opts Options) (helper.ActionResult, error) {
+	intent := definitions.Intent{
+		Activity: utils.AnyToString(action["activity"]),
+		Action:   utils.AnyToString(action["intent"]),
+		URI:      utils.AnyToString(action["uri"]),
+	}
+	appName := utils.AnyToString(action["app"])
+	if utils.AnyToString(action["package"]) == "" && intent == (definitions.Intent{}) {
+		if len(appName) == 0 {
+			return helper.ActionResult{Success: false, Message: "No app name specified"}, nil
+		}
+		if _, err := device.LaunchApp(ctx, appName, opts.DeviceID); err != nil {
+			logs.Errorf("failed to launch app, err: %v", err)
+			return helper.ActionResult{Success: false, Message: fmt.Sprintf("failed to launch app, err: %v", err)}, nil
+		}
+		return helper.ActionResult{Success: true}, nil
+	}
+
+	manager, ok := device.(AppManager)
+	if !ok {
+		return helper.ActionResult{Success: false, Message: "Launch by package or intent is not supported on this device, use app"}, nil
+	}
+	// a view intent needs no package, the system picks the app
+	if appName != "" || utils.AnyToString(action["package"]) != "" || intent.Activity != "" {
+		pkg, err := appPackage(device, action)
+		if err != nil {
+			return helper.ActionResult{Success: false, Message: err.Error()}, nil
+		}
+		intent.Package = pkg
+	}
+	return result("launch app", manager.StartIntent(ctx, intent, opts.DeviceID))
+}
+
+func tap(ctx context.Context, device Device, action helper.Action, opts Options) (helper.ActionResult, error) {
+	element := utils.AnyToIntSlice(action["element"])
+	if len(element) != 2 {
+		return helper.ActionResult{Success: false, Message: "Invalid element coordinates"}, nil
+	}
+	x, y := opts.Point(element)
+	return result("tap", device.Tap(ctx, x, y, opts.DeviceID))
+}
+
+// typeText types through the ADB keyboard, which takes any text, replacing
+// the text of the focused field, and restores the keyboard of the user.
+func typeText(ctx context.Context, device Device, action helper.Action, opts Options) (helper.ActionResult, error) {
+	text := utils.AnyToString(action["text"])
+	originalIME, _ := device.DetectAndSetADBKeyboard(ctx, opts.DeviceID)
+	time.Sleep(typePause)
+	_ = device.ClearText(ctx, opts.DeviceID)
+	time.Sleep(typePause)
+	err := device.TypeText(ctx, text, opts.DeviceID)
+	time.Sleep(typePause)
+	_ = device.RestoreKeyboard(ctx, originalIME, opts.DeviceID)
+	time.Sleep(typePause)
+	return result("type", err)
+}
+
+func swipe(ctx context.Context, device Device, action helper.Action, opts Options) (helper.ActionResult, error) {
+	start := utils.AnyToIntSlice(action["start"])
+	end := utils.AnyToIntSlice(action["end"])
+	if len(start) != 2 || len(end) != 2 {
+		return helper.ActionResult{Success: false, Message: "Invalid swipe coordinates"}, nil
+	}
+	startX, startY := opts.Point(start)
+	endX, endY := opts.Point(end)
+	return result("swipe", device.Swipe(ctx, startX, startY, endX, endY, opts.DeviceID))
+}
+
+func back(ctx context.Context, device Device, action helper.Action, opts Options) (helper.ActionResult, error) {
+	return result("go back", device.Back(ctx, opts.DeviceID))
+}
+
+func home(ctx context.Context, device Device, action helper.Action, opts Options) (helper.ActionResult, error) {
+	return result("go home", device.Home(ctx, opts.DeviceID))
+}
+
+func doubleTap(ctx context.Context, device Device, action helper.Action, opts Options) (helper.ActionResult, error) {
+	element := utils.AnyToIntSlice(action["element"])
+	if len(element) != 2 {
+		return helper.ActionResult{Success: false, ShouldFinish: true, Message: "Invalid element coordinates"}, nil
+	}
+	x, y := opts.Point(element)
+	return result("double tap", device.DoubleTap(ctx, x, y, opts.DeviceID))
+}
+
+func longPress(ctx context.Context, device Device, action helper.Action, opts Options) (helper.ActionResult, error) {
+	element := utils.AnyToIntSlice(action["element"])
+	if len(element) != 2 {
+		return helper.ActionResult{Success: false, ShouldFinish: true, Message: "Invalid element coordinates"}, nil
+	}
+	x, y := opts.Point(element)
+	if duration, ok := gestureTime(action["duration"]); ok {
+		if gesturer, ok := device.(Gesturer); ok {
+			return result("long press", gesturer.Press(ctx, x, y, duration, opts.DeviceID))
+		}
+	}
+	return result("long press", device.LongPress(ctx, x, y, opts.DeviceID))
+}
+
+func drag(ctx context.Context, device Device, action helper.Action, opts Options) (helper.ActionResult, error) {
+	var path [][2]int
+	if items, ok := action["path"].([]any); ok {
+		for _, item := range items {
+			if point := utils.AnyToIntSlice(item); len(point) == 2 {
+				path = append(path, [2]int{point[0], point[1]})
+			}
+		}
+	} else if items, ok := action["path"].([][]int); ok {
+		for _, point := range items {
+			if len(point) == 2 {
+				path = append(path, [2]int{point[0], point[1]})
+			}
+		}
+	}
+	if len(path) < 2 {
+		return helper.ActionResult{Success: false, Message: "Drag needs a path of at least 2 points"}, nil
+	}
+	for i, point := range path {
+		x, y := opts.Point(point[:])
+		path[i] = [2]int{x, y}
+	}
+	duration, ok := gestureTime(action["duration"])
+	if !ok {
+		duration = dragTime
+	}
+	hold, _ := gestureTime(action["hold"])
+
+	gesturer, ok := device.(Gesturer)
+	if !ok {
+		if len(path) > 2 || hold > 0 {
+			return helper.ActionResult{Success: false, Message: "Drag along a path or with hold is not supported on this device, use Swipe"}, nil
+		}
+		return result("drag", device.Swipe(ctx, path[0][0], path[0][1], path[1][0], path[1][1], opts.DeviceID))
+	}
+	return result("drag", gesturer.Drag(ctx, path, hold, duration, opts.DeviceID))
+}
+
+// pinch zooms in around element when scale is above 1, out when below.
+func pinch(ctx context.Context, device Device, action helper.Action, opts Options) (helper.ActionResult, error) {
+	element := utils.AnyToIntSlice(action["element"])
+	if len(element) != 2 {
+		return helper.ActionResult{Success: false, Message: "Invalid element coordinates"}, nil
+	}
+	scale, _ := action["scale"].(float64)
+	if n, ok := action["scale"].(int); ok {
+		scale = float64(n)
+	}
+	if scale <= 0 || scale == 1 {
+		return helper.ActionResult{Success: false, Message: "Pinch needs a scale above 1 to zoom in or below 1 to zoom out"}, nil
+	}
+	gesturer, ok := device.(Gesturer)
+	if !ok {
+		return helper.ActionResult{Success: false, Message: "Pinch is not supported on this device"}, nil
+	}
+	x, y := opts.Point(element)
+	// both fingers stay on the screen
+	apart := min(int(pinchSpan*float64(opts.ScreenWidth)), 2*min(x, opts.ScreenWidth-1-x))
+	together := int(float64(apart) / max(scale, 1/scale))
+	from, to := together, apart
+	if scale < 1 {
+		from, to = apart, together
+	}
+	duration, ok := gestureTime(action["duration"])
+	if !ok {
+		duration = pinchTime
+	}
+	return result("pinch", gesturer.Pinch(ctx, x, y, from, to, duration, opts.DeviceID))
+}
+
+// gestureTime reads a duration in seconds, up to maxGestureTime.
+func gestureTime(value any) (time.Duration, bool) {
+	seconds, ok := helper.Seconds(value)
+	if !ok || seconds <= 0 {
+		return 0, false
+	}
+	return min(time.Duration(seconds*float64(time.Second)), maxGestureTime), true
+}
+
+func wait(ctx context.Context, device Device, action helper.Action, opts Options) (helper.ActionResult, error) {
+	duration := time.Duration(helper.WaitSeconds(action) * float64(time.Second))
+	select {
+	case <-ctx.Done():
+		return helper.ActionResult{}, ctx.Err()
+	case <-time.After(duration):
+	}
+	return helper.ActionResult{Success: true}, nil
+}
+
+// waitUntil polls the UI dump until text_appears shows on the screen or the
+// timeout (seconds) is over.
+func waitUntil(ctx context.Context, device Device, action helper.Action, opts Options) (helper.ActionResult, error) {
+	text := utils.AnyToString(action["text_appears"])
+	if text == "" {
+		return helper.ActionResult{Success: false, Message: "Wait_Until needs text_appears"}, nil
+	}
+	timeout := helper.WaitUntilTimeout(action)
+
+	start := time.Now()
+	ticker := time.NewTicker(waitUntilPoll)
+	defer ticker.Stop()
+	var dumpErr error
+	for {
+		elements, err := device.DumpUI(ctx, opts.DeviceID)
+		dumpErr = err
+		if err == nil && helper.UIContainsText(elements, text) {
+			return helper.ActionResult{
+				Success: true,
+				Message: fmt.Sprintf("%q appeared after %.1fs", text, time.Since(start).Seconds()),
+			}, nil
+		}
+		if time.Since(start)+waitUntilPoll > timeout {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return helper.ActionResult{}, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	message := fmt.Sprintf("%q did not appear within %s", text, timeout)
+	if dumpErr != nil {
+		message = fmt.Sprintf("%s, UI dump failed: %v", message, dumpErr)
+	}
+	return helper.ActionResult{Success: false, Message: message}, nil
+}
@@ -0,0 +1,220 @@
+package phoneagent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"autoglm-go/phoneagent/helper"
+	"github.com/sashabaranov/go-openai"
+)
+
+// OutputSchema declares the fields a task returns, by name, with their type:
+// string, number, integer, boolean, array or object. Once the task finished
+// they are extracted from its finish message and final screen into
+// PhoneAgent.Output.
+type OutputSchema map[string]string
+
+var outputTypes = []string{"string", "number", "integer", "boolean", "array", "object"}
+
+var outputNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+const (
+	extractPromptCn = `你从手机操作任务的结果中提取结构化数据。根据执行者的结束消息和最后的屏幕截图，填写给定的字段。只输出一个 JSON 对象，键为字段名，值符合字段类型；无法确定的字段填 null，不要编造。`
+	extractPromptEn = `You extract structured data from the result of a phone automation task. From the executor's finish message and the final screenshot, fill in the given fields. Output only a JSON object keyed by the field names, with values of the field types; use null for fields you cannot tell, never make them up.`
+
+	extractTaskCn = "任务：%s\n\n执行者的结束消息：%s\n\n字段：\n%s"
+	extractTaskEn = "Task: %s\n\nExecutor's finish message: %s\n\nFields:\n%s"
+)
+
+// ParseOutputSchema reads a schema written as a JSON object, {"price":
+// "number"}, or as name: type pairs separated by commas, price: number, eta:
+// string, with or without braces.
+func ParseOutputSchema(s string) (OutputSchema, error) {
+	s = strings.TrimSpace(s)
+	schema := OutputSchema{}
+	if err := json.Unmarshal([]byte(s), &schema); err != nil {
+		schema = OutputSchema{}
+		inner := strings.TrimSuffix(strings.TrimPrefix(s, "{"), "}")
+		for _, part := range strings.Split(inner, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			name, typ, ok := strings.Cut(part, ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid output field %q, want name: type", part)
+			}
+			schema[strings.Trim(strings.TrimSpace(name), `"'`)] = strings.Trim(strings.TrimSpace(typ), `"'`)
+		}
+	}
+	return schema, schema.Validate()
+}
+
+// Validate checks the names and types of the fields.
+func (s OutputSchema) Validate() error {
+	for name, typ := range s {
+		if !outputNameRe.MatchString(name) {
+			return fmt.Errorf("invalid output field name %q", name)
+		}
+		if !slices.Contains(outputTypes, typ) {
+			return fmt.Errorf("invalid type %q of output field %s, want one of %s", typ, name, strings.Join(outputTypes, ", "))
+		}
+	}
+	return nil
+}
+
+func (s OutputSchema) names() []string {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+type outputSchemaKey struct{}
+
+// WithOutputSchema returns ctx whose tasks extract schema once they finish,
+// see PhoneAgent.Output.
+func WithOutputSchema(ctx context.Context, schema OutputSchema) context.Context {
+	if len(schema) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, outputSchemaKey{}, schema)
+}
+
+func outputSchemaOf(ctx context.Context) OutputSchema {
+	schema, _ := ctx.Value(outputSchemaKey{}).(OutputSchema)
+	return schema
+}
+
+// extractOutput fills the output schema of ctx, if any, from the finish
+// message and the final screen. Every field of the schema is in the output,
+// nil when the model could not tell it; a failed request leaves no output.
+func (r *PhoneAgent) extractOutput(ctx context.Context, message string) {
+	schema := outputSchemaOf(ctx)
+	if len(schema) == 0 {
+		return
+	}
+	system, format := extractPromptCn, extractTaskCn
+	if r.AgentConfig.Lang == "en" {
+		system, format = extractPromptEn, extractTaskEn
+	}
+	var fields strings.Builder
+	for _, name := range schema.names() {
+		fmt.Fprintf(&fields, "- %s: %s\n", name, schema[name])
+	}
+
+	user := openai.ChatCompletionMessage{
+		Role: openai.ChatMessageRoleUser,
+		MultiContent: []openai.ChatMessagePart{
+			{Type: openai.ChatMessagePartTypeText, Text: fmt.Sprintf(format, r.task, message, fields.String())},
+		},
+	}
+	if r.finalFrame != "" {
+		user.MultiContent = append(user.MultiContent, openai.ChatMessagePart{
+			Type:     openai.ChatMessagePartTypeImageURL,
+			ImageURL: &openai.ChatMessageImageURL{URL: r.finalFrame},
+		})
+	}
+
+	response, err := r.ModelClient.Request(ctx, []openai.ChatCompletionMessage{helper.CreateSystemMessage(system), user})
+	if err != nil {
+		r.log().Errorf("output extraction failed, err: %v", err)
+		return
+	}
+	r.Usage.Add(response)
+	output, missing, err := parseOutput(response.RawContent, schema)
+	if err != nil {
+		r.log().Errorf("invalid extracted output, err: %v", err)
+		return
+	}
+	if len(missing) > 0 {
+		r.log().Warnf("📦 output field(s) %s not found", strings.Join(missing, ", "))
+	}
+	r.Output = output
+}
+
+// parseOutput reads the JSON object of the model, tolerating text or code
+// fences around it, and converts its values to the types of schema. Values
+// that are missing or do not convert are nil, and listed in missing.
+func parseOutput(content string, schema OutputSchema) (output map[string]any, missing []string, err error) {
+	raw := jsonObjectRe.FindString(content)
+	if raw == "" {
+		return nil, nil, fmt.Errorf("no JSON in %q", content)
+	}
+	var values map[string]any
+	if err := json.Unmarshal([]byte(raw), &values); err != nil {
+		return nil, nil, fmt.Errorf("%w in %q", err, raw)
+	}
+	output = make(map[string]any, len(schema))
+	for _, name := range schema.names() {
+		value, ok := convertOutput(values[name], schema[name])
+		if !ok {
+			missing = append(missing, name)
+			value = nil
+		}
+		output[name] = value
+	}
+	return output, missing, nil
+}
+
+// outputNumberRe finds the number in values such as "¥1,299.00" or "12 min".
+var outputNumberRe = regexp.MustCompile(`-?\d+(\.\d+)?`)
+
+func convertOutput(value any, typ string) (any, bool) {
+	if value == nil {
+		return nil, false
+	}
+	switch typ {
+	case "string":
+		if s, ok := value.(string); ok {
+			return s, s != ""
+		}
+		return fmt.Sprint(value), true
+	case "number", "integer":
+		var n float64
+		switch v := value.(type) {
+		case float64:
+			n = v
+		case string:
+			m := outputNumberRe.FindString(strings.ReplaceAll(v, ",", ""))
+			parsed, err := strconv.ParseFloat(m, 64)
+			if err != nil {
+				return nil, false
+			}
+			n = parsed
+		default:
+			return nil, false
+		}
+		if typ == "integer" {
+			if n != math.Trunc(n) {
+				return nil, false
+			}
+			return int64(n), true
+		}
+		return n, true
+	case "boolean":
+		switch v := value.(type) {
+		case bool:
+			return v, true
+		case string:
+			b, err := strconv.ParseBool(strings.ToLower(strings.TrimSpace(v)))
+			return b, err == nil
+		}
+		return nil, false
+	case "array":
+		v, ok := value.([]any)
+		return v, ok
+	case "object":
+		v, ok := value.(map[string]any)
+		return v, ok
+	}
+	return nil, false
+}
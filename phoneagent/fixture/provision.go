@@ -0,0 +1,238 @@
+package fixture
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"autoglm-go/phoneagent/android"
+	logs "github.com/sirupsen/logrus"
+)
+
+// photoDir is where photos are pushed, a folder of its own makes them easy
+// to tell apart from the user's pictures.
+const photoDir = "/sdcard/Pictures/AutoGLM"
+
+// Device is what provisioning needs of the device driver.
+type Device interface {
+	Shell(ctx context.Context, deviceID string, args ...string) (string, error)
+	Push(ctx context.Context, deviceID, local, remote string) error
+}
+
+// Installer is implemented by drivers that install apps.
+type Installer interface {
+	Install(ctx context.Context, deviceID string, paths []string, opts android.InstallOptions) error
+}
+
+// Provisioned is the fixtures set up on a device, undone by Cleanup.
+type Provisioned struct {
+	device   Device
+	deviceID string
+	cleanups []func(ctx context.Context) error
+}
+
+// Provision sets up the fixtures in order. If one fails the ones already set
+// up are cleaned up again.
+func Provision(ctx context.Context, device Device, deviceID string, fixtures []Fixture) (*Provisioned, error) {
+	p := &Provisioned{device: device, deviceID: deviceID}
+	for i := range fixtures {
+		f := &fixtures[i]
+		cleanup, err := p.provision(ctx, f)
+		if err != nil {
+			if cleanupErr := p.Cleanup(ctx); cleanupErr != nil {
+				logs.Warnf("failed to clean up fixtures, err: %v", cleanupErr)
+			}
+			return nil, fmt.Errorf("failed to provision %s fixture: %w", f.Type, err)
+		}
+		logs.Infof("📦 provisioned %s fixture %s", f.Type, f.describe())
+		p.cleanups = append(p.cleanups, cleanup)
+	}
+	return p, nil
+}
+
+// Cleanup removes the fixtures in reverse order.
+func (p *Provisioned) Cleanup(ctx context.Context) error {
+	var errs []error
+	for i := len(p.cleanups) - 1; i >= 0; i-- {
+		if err := p.cleanups[i](ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	p.cleanups = nil
+	return errors.Join(errs...)
+}
+
+func (f *Fixture) describe() string {
+	switch f.Type {
+	case Contact:
+		return f.Name
+	case SMS:
+		return "from " + f.From
+	default:
+		return filepath.Base(f.Path)
+	}
+}
+
+func (p *Provisioned) provision(ctx context.Context, f *Fixture) (func(ctx context.Context) error, error) {
+	switch f.Type {
+	case Photo:
+		return p.provisionPhoto(ctx, f)
+	case File:
+		return p.provisionFile(ctx, f.Path, f.Remote)
+	case Contact:
+		return p.provisionContact(ctx, f)
+	case SMS:
+		return p.provisionSMS(ctx, f)
+	case App:
+		return p.provisionApp(ctx, f)
+	}
+	return nil, fmt.Errorf("unknown fixture type: %q", f.Type)
+}
+
+func (p *Provisioned) shell(ctx context.Context, args ...string) (string, error) {
+	output, err := p.device.Shell(ctx, p.deviceID, args...)
+	if err != nil {
+		return output, fmt.Errorf("%s: %w, output: %s", strings.Join(args, " "), err, output)
+	}
+	return output, nil
+}
+
+func (p *Provisioned) provisionFile(ctx context.Context, local, remote string) (func(ctx context.Context) error, error) {
+	if _, err := p.shell(ctx, "mkdir", "-p", shellQuote(path.Dir(remote))); err != nil {
+		return nil, err
+	}
+	if err := p.device.Push(ctx, p.deviceID, local, remote); err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context) error {
+		_, err := p.shell(ctx, "rm", "-f", shellQuote(remote))
+		return err
+	}, nil
+}
+
+func (p *Provisioned) provisionPhoto(ctx context.Context, f *Fixture) (func(ctx context.Context) error, error) {
+	remote := path.Join(photoDir, filepath.Base(f.Path))
+	removeFile, err := p.provisionFile(ctx, f.Path, remote)
+	if err != nil {
+		return nil, err
+	}
+	// the media scanner adds the file to the gallery
+	_, err = p.shell(ctx, "am", "broadcast", "-a", "android.intent.action.MEDIA_SCANNER_SCAN_FILE",
+		"-d", shellQuote("file://"+remote))
+	if err != nil {
+		_ = removeFile(ctx)
+		return nil, err
+	}
+
+	return func(ctx context.Context) error {
+		_, err := p.shell(ctx, "content", "delete", "--uri", "content://media/external/images/media",
+			"--where", shellQuote("_data="+sqlQuote(remote)))
+		return errors.Join(err, removeFile(ctx))
+	}, nil
+}
+
+var idRe = regexp.MustCompile(`_id=(\d+)`)
+
+func (p *Provisioned) provisionContact(ctx context.Context, f *Fixture) (func(ctx context.Context) error, error) {
+	_, err := p.shell(ctx, "content", "insert", "--uri", "content://com.android.contacts/raw_contacts",
+		"--bind", "account_type:n:", "--bind", "account_name:n:")
+	if err != nil {
+		return nil, err
+	}
+	output, err := p.shell(ctx, "content", "query", "--uri", "content://com.android.contacts/raw_contacts",
+		"--projection", "_id", "--sort", shellQuote("_id DESC"))
+	if err != nil {
+		return nil, err
+	}
+	m := idRe.FindStringSubmatch(output)
+	if m == nil {
+		return nil, fmt.Errorf("inserted contact not found")
+	}
+	id, _ := strconv.Atoi(m[1])
+
+	remove := func(ctx context.Context) error {
+		// as a sync adapter the contact is deleted instead of only marked deleted
+		_, err := p.shell(ctx, "content", "delete", "--uri", shellQuote("content://com.android.contacts/raw_contacts?caller_is_syncadapter=true"),
+			"--where", shellQuote("_id="+strconv.Itoa(id)))
+		return err
+	}
+	rows := [][]string{{"vnd.android.cursor.item/name", "data1:s:" + f.Name}}
+	if f.Phone != "" {
+		// data2 2 is TYPE_MOBILE
+		rows = append(rows, []string{"vnd.android.cursor.item/phone_v2", "data1:s:" + f.Phone, "data2:i:2"})
+	}
+	for _, row := range rows {
+		args := []string{"content", "insert", "--uri", "content://com.android.contacts/data",
+			"--bind", "raw_contact_id:i:" + strconv.Itoa(id), "--bind", "mimetype:s:" + row[0]}
+		for _, bind := range row[1:] {
+			args = append(args, "--bind", shellQuote(bind))
+		}
+		if _, err := p.shell(ctx, args...); err != nil {
+			_ = remove(ctx)
+			return nil, err
+		}
+	}
+	return remove, nil
+}
+
+// provisionSMS writes to the inbox provider, which most Android versions
+// only allow for the default SMS app. Emulators usually accept it.
+func (p *Provisioned) provisionSMS(ctx context.Context, f *Fixture) (func(ctx context.Context) error, error) {
+	date := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	_, err := p.shell(ctx, "content", "insert", "--uri", "content://sms/inbox",
+		"--bind", shellQuote("address:s:"+f.From), "--bind", shellQuote("body:s:"+f.Body),
+		"--bind", "read:i:0", "--bind", "date:l:"+date)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context) error {
+		_, err := p.shell(ctx, "content", "delete", "--uri", "content://sms",
+			"--where", shellQuote("address="+sqlQuote(f.From)+" AND date="+date))
+		return err
+	}, nil
+}
+
+func (p *Provisioned) provisionApp(ctx context.Context, f *Fixture) (func(ctx context.Context) error, error) {
+	// an app that was there before is left installed
+	installed := false
+	if f.Package != "" {
+		output, err := p.shell(ctx, "pm", "path", shellQuote(f.Package))
+		installed = err == nil && strings.Contains(output, "package:")
+	}
+	if f.Path != "" {
+		installer, ok := p.device.(Installer)
+		if !ok {
+			return nil, fmt.Errorf("the device cannot install apps")
+		}
+		opts := android.InstallOptions{Downgrade: f.Downgrade, GrantPermissions: f.GrantPermissions}
+		if err := installer.Install(ctx, p.deviceID, append([]string{f.Path}, f.Splits...), opts); err != nil {
+			return nil, err
+		}
+	}
+	if f.ClearData {
+		if _, err := p.shell(ctx, "pm", "clear", shellQuote(f.Package)); err != nil {
+			return nil, err
+		}
+	}
+	return func(ctx context.Context) error {
+		if f.Package == "" || installed || f.Path == "" {
+			return nil
+		}
+		_, err := p.shell(ctx, "pm", "uninstall", shellQuote(f.Package))
+		return err
+	}, nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func sqlQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
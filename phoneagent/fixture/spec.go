@@ -0,0 +1,100 @@
+package fixture
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	Photo   = "photo"   // image pushed to the gallery
+	Contact = "contact" // contact with a name and a phone number
+	SMS     = "sms"     // message in the inbox
+	File    = "file"    // any file pushed to a device path
+	App     = "app"     // app installed from an APK, split APKs or a bundle
+)
+
+// Fixture is test data a task needs on the device.
+type Fixture struct {
+	Type   string `json:"type"`
+	Path   string `json:"path,omitempty"`   // local file, photo and file
+	Remote string `json:"remote,omitempty"` // device path, file only
+	Name   string `json:"name,omitempty"`   // contact
+	Phone  string `json:"phone,omitempty"`  // contact
+	From   string `json:"from,omitempty"`   // sms sender
+	Body   string `json:"body,omitempty"`   // sms text
+
+	Splits    []string `json:"splits,omitempty"`    // app: more split APKs or OBB files next to Path
+	Package   string   `json:"package,omitempty"`   // app: uninstalled again by Cleanup unless it was installed before
+	Downgrade bool     `json:"downgrade,omitempty"` // app: allow a lower version than the installed one
+
+	GrantPermissions bool `json:"grant_permissions,omitempty"` // app: grant all runtime permissions
+	ClearData        bool `json:"clear_data,omitempty"`        // app: clear the data of Package, the task starts it fresh
+}
+
+// Spec is a self-contained task: the instruction and the fixtures it needs.
+type Spec struct {
+	Task     string    `json:"task"`
+	Fixtures []Fixture `json:"fixtures,omitempty"`
+}
+
+// LoadSpec reads a task file. Local paths are relative to the file.
+func LoadSpec(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var spec Spec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("invalid task file %s: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	for i := range spec.Fixtures {
+		f := &spec.Fixtures[i]
+		if f.Path != "" && !filepath.IsAbs(f.Path) {
+			f.Path = filepath.Join(dir, f.Path)
+		}
+		for j, split := range f.Splits {
+			if !filepath.IsAbs(split) {
+				f.Splits[j] = filepath.Join(dir, split)
+			}
+		}
+		if err := f.validate(); err != nil {
+			return nil, fmt.Errorf("fixture %d: %w", i+1, err)
+		}
+	}
+	return &spec, nil
+}
+
+func (f *Fixture) validate() error {
+	switch f.Type {
+	case Photo:
+		if f.Path == "" {
+			return fmt.Errorf("photo needs a path")
+		}
+	case File:
+		if f.Path == "" || f.Remote == "" {
+			return fmt.Errorf("file needs a path and a remote path")
+		}
+	case App:
+		if f.ClearData && f.Package == "" {
+			return fmt.Errorf("app with clear_data needs a package")
+		}
+		if f.Path == "" && !f.ClearData {
+			return fmt.Errorf("app needs a path")
+		}
+	case Contact:
+		if f.Name == "" {
+			return fmt.Errorf("contact needs a name")
+		}
+	case SMS:
+		if f.From == "" || f.Body == "" {
+			return fmt.Errorf("sms needs a sender and a body")
+		}
+	default:
+		return fmt.Errorf("unknown fixture type: %q", f.Type)
+	}
+	return nil
+}
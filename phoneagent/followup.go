@@ -0,0 +1,64 @@
+package phoneagent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"autoglm-go/phoneagent/definitions"
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/labels"
+	"autoglm-go/phoneagent/trajectory"
+)
+
+// Continue runs instruction as a follow-up of the previous task on the same
+// device, e.g. "now turn on dark mode" after "open settings", keeping the
+// conversation with the model. The follow-up gets a trajectory, session and
+// steps of its own. Without a previous task it is Run.
+func (r *PhoneAgent) Continue(ctx context.Context, instruction string) (string, error) {
+	if len(r.State) == 0 {
+		return r.Run(ctx, instruction)
+	}
+	r.stepBase = r.StepCount
+	r.task = instruction
+	r.plan = nil
+	r.Trajectory = trajectory.New(instruction, r.AgentConfig.DeviceID)
+	r.Trajectory.Labels = labels.From(ctx)
+	r.judgeFrames = nil
+	r.SessionID = ""
+	r.sessionCreatedAt = time.Time{}
+	r.storedSteps = 0
+	r.addDeviceNote(fmt.Sprintf(helper.GetMessage("follow_up", r.AgentConfig.Lang), instruction))
+	r.log().Infof("💬 follow-up after step %d: %s", r.StepCount, instruction)
+
+	return r.run(ctx, instruction, true)
+}
+
+// LastScreenshot returns the screenshot the last step was taken on, nil
+// before the first step.
+func (r *PhoneAgent) LastScreenshot() *definitions.Screenshot {
+	if r.stepObservation == nil {
+		return nil
+	}
+	return r.stepObservation.screenshot
+}
+
+// Undo presses Back to revert the last action and tells the model in the
+// next step, so that it does not take the action again.
+func (r *PhoneAgent) Undo(ctx context.Context) error {
+	r.dropObservation()
+	r.dropSpeculation()
+	if err := r.Device.Back(ctx, r.AgentConfig.DeviceID); err != nil {
+		return err
+	}
+	r.addDeviceNote(helper.GetMessage("action_undone", r.AgentConfig.Lang))
+	return nil
+}
+
+// addDeviceNote adds note to what the model is told in the next step.
+func (r *PhoneAgent) addDeviceNote(note string) {
+	if r.deviceNote != "" {
+		note = r.deviceNote + "\n\n" + note
+	}
+	r.deviceNote = note
+}
@@ -0,0 +1,261 @@
+package phoneagent
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"autoglm-go/phoneagent/definitions"
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/imaging"
+	"autoglm-go/phoneagent/ocr"
+	"autoglm-go/phoneagent/uilang"
+	"autoglm-go/utils"
+	logs "github.com/sirupsen/logrus"
+)
+
+const (
+	groundingSettle = 800 * time.Millisecond
+	// screens closer than this many dhash bits count as unchanged
+	unchangedDistance = 2
+)
+
+// quotedRe finds the phrases the model quotes in its reasoning, which are
+// usually the labels it wants to tap, e.g. 点击“搜索”按钮.
+// Quotes of other UI languages are included: «», „“, ‹› and 《》.
+var quotedRe = regexp.MustCompile(`["“”„「『《«‹'‘‚]([^"“”„」』》»›'’\n]{1,40})["”“」』》»›'’‘]`)
+
+// groundTap retries a tap that did not change the screen on the UI element
+// the model named in its reasoning, when that element is elsewhere. The
+// retry is local, the model is not asked again. It returns the label of the
+// element tapped on retry, empty when the tap was not retried.
+func (r *PhoneAgent) groundTap(ctx context.Context, x, y int) string {
+	obs, thinking := r.stepObservation, r.stepThinking
+	if !r.AgentConfig.Grounding || obs == nil || obs.screenshot == nil || thinking == "" {
+		return ""
+	}
+	before, err := imaging.DHashData(obs.screenshot.Data)
+	if err != nil {
+		return ""
+	}
+
+	time.Sleep(groundingSettle)
+	deviceID := r.AgentConfig.DeviceID
+	screenshot, err := r.Device.GetScreenshot(ctx, deviceID)
+	if err != nil {
+		return ""
+	}
+	var elements []definitions.UIElement
+	if r.redactor != nil {
+		// masked like the observation the model saw, to compare with it
+		if elements, err = r.Device.DumpUI(ctx, deviceID); err != nil {
+			return ""
+		}
+		screenshot, elements, _ = r.redactor.Apply(screenshot, obs.currentApp, elements)
+	}
+	after, err := imaging.DHashData(screenshot.Data)
+	if err != nil || imaging.HashDistance(before, after) > unchangedDistance {
+		return ""
+	}
+
+	if elements == nil {
+		if elements, err = r.Device.DumpUI(ctx, deviceID); err != nil {
+			return ""
+		}
+	}
+	if obs.uiElements != nil && !helper.DiffUIElements(obs.uiElements, elements).Empty() {
+		return ""
+	}
+
+	target := findNamedElement(elements, thinking, x, y, r.uiLanguage)
+	if target == nil {
+		return ""
+	}
+	label := target.Text
+	if label == "" {
+		label = target.ContentDesc
+	}
+	tx, ty := target.Center()
+	r.log().Infof("🎯 tap at (%d, %d) changed nothing, retrying on %q at (%d, %d)", x, y, label, tx, ty)
+	_ = r.Device.Tap(ctx, tx, ty, deviceID)
+	return label
+}
+
+// findNamedElement returns the element whose label the reasoning quotes last,
+// nearest to x, y on ties. Elements under x, y were already tapped and are
+// skipped. Labels are compared by the rules of the UI language.
+func findNamedElement(elements []definitions.UIElement, thinking string, x, y int, lang *uilang.Language) *definitions.UIElement {
+	matches := quotedRe.FindAllStringSubmatchIndex(thinking, -1)
+	if len(matches) == 0 {
+		return nil
+	}
+
+	var (
+		best         *definitions.UIElement
+		bestPos      = -1
+		bestDistance = math.MaxFloat64
+	)
+	for i := range elements {
+		e := &elements[i]
+		if e.Contains(x, y) || e.Bounds[2] <= e.Bounds[0] || e.Bounds[3] <= e.Bounds[1] {
+			continue
+		}
+		for _, label := range []string{e.Text, e.ContentDesc} {
+			label = lang.Fold(label)
+			if utf8.RuneCountInString(label) < 2 {
+				continue
+			}
+			for _, m := range matches {
+				phrase := lang.Fold(thinking[m[2]:m[3]])
+				if utf8.RuneCountInString(phrase) < 2 || (!strings.Contains(label, phrase) && !strings.Contains(phrase, label)) {
+					continue
+				}
+				cx, cy := e.Center()
+				distance := math.Hypot(float64(cx-x), float64(cy-y))
+				if m[2] > bestPos || (m[2] == bestPos && distance < bestDistance) {
+					best, bestPos, bestDistance = e, m[2], distance
+				}
+			}
+		}
+	}
+	return best
+}
+
+// newOCR sets up the engine of AgentConfig.OCR, nil when there is none or it
+// is not installed.
+func newOCR(config *definitions.AgentConfig) ocr.Engine {
+	switch config.OCR {
+	case "":
+		return nil
+	case "tesseract":
+		engine, err := ocr.NewTesseract(cmp.Or(config.OCRLanguages, "chi_sim+eng"))
+		if err != nil {
+			logs.Errorf("failed to set up ocr, grounding by the UI dump only, err: %v", err)
+			return nil
+		}
+		return engine
+	default:
+		logs.Errorf("unknown ocr engine %s, grounding by the UI dump only", config.OCR)
+		return nil
+	}
+}
+
+// groundPoints resolves the points of action given as a label, or far off
+// the screen, before anything else looks at them: to the UI element showing
+// the label, else to where OCR reads it on the screenshot the model saw. An
+// off-screen tap is looked for by the phrases its reasoning quotes, and
+// clamped onto the screen when none is found. A label that is not found
+// fails the action.
+func (r *PhoneAgent) groundPoints(ctx context.Context, action helper.Action) (helper.ActionResult, bool) {
+	schema, ok := helper.LookupActionSchema(utils.AnyToString(action["action"]))
+	if !ok {
+		return helper.ActionResult{}, true
+	}
+	for _, spec := range schema.Params {
+		value, ok := action[spec.Name]
+		if spec.Type != helper.ParamPoint || !ok {
+			continue
+		}
+		if label, ok := helper.PointLabel(value); ok {
+			point, found := r.locateLabel(ctx, []string{label})
+			if !found {
+				return helper.ActionResult{
+					Success: false,
+					Message: fmt.Sprintf("%q not found on the screen, give the coordinates of %s instead", label, spec.Name),
+				}, false
+			}
+			r.log().Infof("🎯 %s %q found at %v", spec.Name, label, point)
+			action[spec.Name] = point
+			continue
+		}
+		point, ok := value.([]int)
+		if !ok || !helper.OffGrid(point) {
+			continue
+		}
+		if spec.Name == "element" {
+			if found, ok := r.locateLabel(ctx, quotedPhrases(r.stepThinking)); ok {
+				r.log().Infof("🎯 %s %v is off the screen, moved to %v where the quoted label is", spec.Name, point, found)
+				action[spec.Name] = found
+				continue
+			}
+		}
+		r.log().Warnf("🎯 %s %v is off the screen, clamped onto it", spec.Name, point)
+		action[spec.Name] = helper.ClampPoint(point)
+	}
+	return helper.ActionResult{}, true
+}
+
+// quotedPhrases returns the phrases quoted in thinking, the last first.
+func quotedPhrases(thinking string) []string {
+	matches := quotedRe.FindAllStringSubmatch(thinking, -1)
+	phrases := make([]string, 0, len(matches))
+	for i := len(matches) - 1; i >= 0; i-- {
+		phrases = append(phrases, matches[i][1])
+	}
+	return phrases
+}
+
+// locateLabel returns the center of the first of labels found on the
+// observation of the step, on the 0-999 grid.
+func (r *PhoneAgent) locateLabel(ctx context.Context, labels []string) ([]int, bool) {
+	obs := r.stepObservation
+	if obs == nil || obs.screenshot == nil || obs.screenshot.Width == 0 || obs.screenshot.Height == 0 || len(labels) == 0 {
+		return nil, false
+	}
+	width, height := obs.screenshot.Width, obs.screenshot.Height
+	toGrid := func(bounds [4]int) []int {
+		return helper.ClampPoint([]int{(bounds[0] + bounds[2]) / 2 * 1000 / width, (bounds[1] + bounds[3]) / 2 * 1000 / height})
+	}
+
+	for _, label := range labels {
+		if e := findLabeledElement(obs.uiElements, label, r.uiLanguage); e != nil {
+			return toGrid(e.Bounds), true
+		}
+	}
+	if r.OCR == nil || len(obs.screenshot.Data) == 0 {
+		return nil, false
+	}
+	lines, err := r.OCR.Recognize(ctx, obs.screenshot.Data)
+	if err != nil {
+		r.log().Warnf("🎯 ocr failed, err: %v", err)
+		return nil, false
+	}
+	for _, label := range labels {
+		if bounds, ok := ocr.Find(lines, label, r.uiLanguage.Fold); ok {
+			return toGrid(bounds), true
+		}
+	}
+	return nil, false
+}
+
+// findLabeledElement returns the element labeled label, else the one with the
+// shortest label containing it.
+func findLabeledElement(elements []definitions.UIElement, label string, lang *uilang.Language) *definitions.UIElement {
+	want := lang.Fold(label)
+	if utf8.RuneCountInString(want) < 2 {
+		return nil
+	}
+	var best *definitions.UIElement
+	bestLen := math.MaxInt
+	for i := range elements {
+		e := &elements[i]
+		if e.Bounds[2] <= e.Bounds[0] || e.Bounds[3] <= e.Bounds[1] {
+			continue
+		}
+		for _, text := range []string{e.Text, e.ContentDesc} {
+			text = lang.Fold(text)
+			if text == want {
+				return e
+			}
+			if strings.Contains(text, want) && len(text) < bestLen {
+				best, bestLen = e, len(text)
+			}
+		}
+	}
+	return best
+}
@@ -0,0 +1,404 @@
+package group
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+
+	"autoglm-go/phoneagent/definitions"
+)
+
+// Lister lists the devices the dashboard shows next to the groups.
+type Lister interface {
+	ListDevices(ctx context.Context) ([]definitions.DeviceInfo, error)
+}
+
+// Node is a group with its subgroups, as returned by GET /api/groups.
+type Node struct {
+	Group
+	Path      []string                  `json:"path"`
+	Devices   []string                  `json:"devices"` // assigned directly to this group
+	Effective definitions.GroupDefaults `json:"effective"`
+	Children  []*Node                   `json:"children"`
+}
+
+// DeviceView is a device with its group, as returned by GET /api/devices.
+type DeviceView struct {
+	definitions.DeviceInfo
+	Group     string                    `json:"group,omitempty"`
+	Path      []string                  `json:"path,omitempty"`
+	Effective definitions.GroupDefaults `json:"effective"`
+	Sources   map[string]string         `json:"sources"` // the group of each effective default
+}
+
+// View returns the group and the effective defaults of a device.
+func (r *Tree) View(device definitions.DeviceInfo) DeviceView {
+	id := r.GroupOf(device.DeviceID)
+	return DeviceView{
+		DeviceInfo: device,
+		Group:      id,
+		Path:       r.Path(id),
+		Effective:  r.Effective(device.DeviceID),
+		Sources:    r.Sources(device.DeviceID),
+	}
+}
+
+// Nodes returns the group tree, top level groups first.
+func (r *Tree) Nodes() []*Node {
+	groups := r.Groups()
+	nodes := make(map[string]*Node, len(groups))
+	for _, g := range groups {
+		nodes[g.ID] = &Node{Group: g, Path: r.Path(g.ID), Devices: []string{}, Children: []*Node{}}
+	}
+
+	r.mu.RLock()
+	for device, id := range r.devices {
+		nodes[id].Devices = append(nodes[id].Devices, device)
+	}
+	r.mu.RUnlock()
+
+	var roots []*Node
+	for _, g := range groups {
+		node := nodes[g.ID]
+		sort.Strings(node.Devices)
+		var parent definitions.GroupDefaults
+		for _, id := range node.Path {
+			parent = nodes[id].Defaults.Merge(parent)
+		}
+		node.Effective = parent
+		if g.Parent == "" {
+			roots = append(roots, node)
+		} else {
+			nodes[g.Parent].Children = append(nodes[g.Parent].Children, node)
+		}
+	}
+	return roots
+}
+
+// Handler serves the group API under /api and the dashboard at /.
+//
+//	GET    /api/groups                 group tree
+//	POST   /api/groups                 create {"id", "name", "parent", "defaults"}
+//	PUT    /api/groups/{id}            update {"name", "defaults"}
+//	POST   /api/groups/{id}/move       move {"parent"}, empty for the top level
+//	DELETE /api/groups/{id}            delete an empty group
+//	GET    /api/devices                devices with their group and effective defaults
+//	GET    /api/devices/{id}/settings  effective defaults of a device and their groups
+//	PUT    /api/devices/{id}/group     assign {"group"}, empty to remove
+//	POST   /api/batches                start a BatchRequest on many devices
+//	GET    /api/batches                batch jobs, newest first
+//	GET    /api/batches/{id}           progress and result per device
+//	POST   /api/batches/{id}/cancel    cancel the devices not done yet
+func Handler(tree *Tree, lister Lister, batches *Batches) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(dashboardPage))
+	})
+	mux.HandleFunc("GET /api/groups", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, tree.Nodes())
+	})
+	mux.HandleFunc("POST /api/groups", func(w http.ResponseWriter, req *http.Request) {
+		var g Group
+		if !readJSON(w, req, &g) {
+			return
+		}
+		created, err := tree.Create(g)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, created)
+	})
+	mux.HandleFunc("PUT /api/groups/{id}", func(w http.ResponseWriter, req *http.Request) {
+		var body struct {
+			Name     string                    `json:"name"`
+			Defaults definitions.GroupDefaults `json:"defaults"`
+		}
+		if !readJSON(w, req, &body) {
+			return
+		}
+		if err := tree.Update(req.PathValue("id"), body.Name, body.Defaults); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST /api/groups/{id}/move", func(w http.ResponseWriter, req *http.Request) {
+		var body struct {
+			Parent string `json:"parent"`
+		}
+		if !readJSON(w, req, &body) {
+			return
+		}
+		if err := tree.Move(req.PathValue("id"), body.Parent); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("DELETE /api/groups/{id}", func(w http.ResponseWriter, req *http.Request) {
+		if err := tree.Delete(req.PathValue("id")); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /api/devices", func(w http.ResponseWriter, req *http.Request) {
+		devices, err := lister.ListDevices(req.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		// assigned devices that are not connected are listed too
+		seen := map[string]bool{}
+		for _, d := range devices {
+			seen[d.DeviceID] = true
+		}
+		tree.mu.RLock()
+		for id := range tree.devices {
+			if !seen[id] {
+				devices = append(devices, definitions.DeviceInfo{DeviceID: id, Status: "offline"})
+			}
+		}
+		tree.mu.RUnlock()
+
+		views := make([]DeviceView, 0, len(devices))
+		for _, d := range devices {
+			views = append(views, tree.View(d))
+		}
+		writeJSON(w, http.StatusOK, views)
+	})
+	mux.HandleFunc("GET /api/devices/{id}/settings", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, tree.View(definitions.DeviceInfo{DeviceID: req.PathValue("id")}))
+	})
+	mux.HandleFunc("PUT /api/devices/{id}/group", func(w http.ResponseWriter, req *http.Request) {
+		var body struct {
+			Group string `json:"group"`
+		}
+		if !readJSON(w, req, &body) {
+			return
+		}
+		if err := tree.Assign(req.PathValue("id"), body.Group); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST /api/batches", func(w http.ResponseWriter, req *http.Request) {
+		var body BatchRequest
+		if !readJSON(w, req, &body) {
+			return
+		}
+		job, err := batches.Start(body)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, job)
+	})
+	mux.HandleFunc("GET /api/batches", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, batches.List())
+	})
+	mux.HandleFunc("GET /api/batches/{id}", func(w http.ResponseWriter, req *http.Request) {
+		job, ok := batches.Get(req.PathValue("id"))
+		if !ok {
+			http.Error(w, "batch not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+	})
+	mux.HandleFunc("POST /api/batches/{id}/cancel", func(w http.ResponseWriter, req *http.Request) {
+		if err := batches.Cancel(req.PathValue("id")); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+func readJSON(w http.ResponseWriter, req *http.Request, v any) bool {
+	if err := json.NewDecoder(req.Body).Decode(v); err != nil {
+		http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrExists), errors.Is(err, ErrCycle), errors.Is(err, ErrNotEmpty):
+		status = http.StatusConflict
+	}
+	http.Error(w, err.Error(), status)
+}
+
+const dashboardPage = `<!doctype html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width">
+<title>Device groups</title>
+<style>
+body{font-family:sans-serif;margin:16px;display:flex;gap:32px;flex-wrap:wrap}
+section{min-width:320px}ul{list-style:none;padding-left:18px}li{margin:4px 0}
+.group{font-weight:bold;cursor:pointer}.defaults{color:#666;font-size:12px}.error{color:#c00}
+table{border-collapse:collapse}td,th{border-bottom:1px solid #ddd;padding:4px 8px;text-align:left}
+input,select,button{margin:2px}
+</style>
+</head><body>
+<section>
+<h3>Groups</h3>
+<div id="tree"></div>
+<h4>New group</h4>
+<input id="new-id" placeholder="id"> <input id="new-name" placeholder="name">
+<select id="new-parent"></select> <button onclick="createGroup()">Create</button>
+<h4 id="edit-title">Select a group</h4>
+<div id="edit" hidden>
+<input id="edit-name" placeholder="name"><br>
+<input id="edit-model" placeholder="model"> <input id="edit-base-url" placeholder="base url"><br>
+<input id="edit-max-steps" type="number" placeholder="max steps"> <input id="edit-lang" placeholder="lang (cn/en)">
+<input id="edit-ui-lang" placeholder="ui lang"><br>
+<input id="edit-provider" placeholder="provider"> <input id="edit-model-profile" placeholder="model profile">
+<input id="edit-temperature" type="number" step="0.1" placeholder="temperature"><br>
+<input id="edit-policy-file" placeholder="policy file">
+<select id="edit-pacing"><option value="">(inherit pacing)</option><option>fast</option><option>normal</option><option>careful</option></select><br>
+<button onclick="saveGroup()">Save</button>
+move under <select id="edit-parent"></select> <button onclick="moveGroup()">Move</button>
+<button onclick="deleteGroup()">Delete</button>
+</div>
+<p id="error" class="error"></p>
+</section>
+<section>
+<h3>Devices</h3>
+<table><thead><tr><th></th><th>Device</th><th>Status</th><th>Group</th><th>Effective defaults</th></tr></thead>
+<tbody id="devices"></tbody></table>
+<h4>Batch on selected devices</h4>
+<select id="batch-op"><option value="move">Move to group</option><option value="reconnect">Reconnect adb</option><option value="task">Run task</option></select>
+<select id="batch-group"></select> <input id="batch-instruction" placeholder="instruction">
+<label><input type="checkbox" id="batch-force"> run again if just run</label>
+<button onclick="startBatch()">Run</button>
+<div id="batches"></div>
+</section>
+<script>
+let groups = [], selected = null, checked = new Set(), polling = null;
+const $ = id => document.getElementById(id);
+async function api(method, path, body) {
+  const resp = await fetch(path, {method, headers: {'Content-Type': 'application/json'}, body: body && JSON.stringify(body)});
+  if (!resp.ok) { $('error').textContent = await resp.text(); throw new Error(resp.status); }
+  $('error').textContent = '';
+  return resp.status === 204 ? null : resp.json();
+}
+function flatten(nodes, out) { for (const n of nodes || []) { out.push(n); flatten(n.children, out); } return out; }
+function describe(d, sources) {
+  return Object.entries(d || {}).filter(([, v]) => v !== null && v !== '')
+    .map(([k, v]) => k + '=' + v + (sources && sources[k] ? ' (' + sources[k] + ')' : '')).join(' ');
+}
+function options(select, value, skip, none) {
+  select.innerHTML = '<option value="">' + (none || '(top level)') + '</option>' + groups.filter(g => g.id !== skip)
+    .map(g => '<option value="' + g.id + '">' + g.path.join(' / ') + '</option>').join('');
+  select.value = value || '';
+}
+function renderTree(nodes) {
+  const ul = document.createElement('ul');
+  for (const n of nodes || []) {
+    const li = document.createElement('li');
+    const name = document.createElement('span');
+    name.className = 'group';
+    name.textContent = (n.name || n.id) + ' (' + n.devices.length + ')';
+    name.onclick = () => select(n);
+    const defaults = document.createElement('div');
+    defaults.className = 'defaults';
+    defaults.textContent = describe(n.effective);
+    li.append(name, defaults, renderTree(n.children));
+    ul.append(li);
+  }
+  return ul;
+}
+function select(n) {
+  selected = n;
+  $('edit').hidden = false;
+  $('edit-title').textContent = 'Group ' + n.path.join(' / ');
+  $('edit-name').value = n.name || '';
+  $('edit-model').value = n.defaults.model || '';
+  $('edit-base-url').value = n.defaults.base_url || '';
+  $('edit-max-steps').value = n.defaults.max_steps || '';
+  $('edit-lang').value = n.defaults.lang || '';
+  $('edit-ui-lang').value = n.defaults.ui_lang || '';
+  $('edit-provider').value = n.defaults.provider || '';
+  $('edit-model-profile').value = n.defaults.model_profile || '';
+  $('edit-temperature').value = n.defaults.temperature ?? '';
+  $('edit-policy-file').value = n.defaults.policy_file || '';
+  $('edit-pacing').value = n.defaults.pacing || '';
+  options($('edit-parent'), n.parent, n.id);
+}
+async function refresh() {
+  const tree = await api('GET', '/api/groups');
+  groups = flatten(tree, []);
+  $('tree').replaceChildren(renderTree(tree));
+  options($('new-parent'), $('new-parent').value);
+  options($('batch-group'), $('batch-group').value, null, '(no group)');
+  if (selected) { const n = groups.find(g => g.id === selected.id); if (n) select(n); else { selected = null; $('edit').hidden = true; } }
+  const devices = await api('GET', '/api/devices');
+  $('devices').replaceChildren(...devices.map(d => {
+    const tr = document.createElement('tr');
+    const group = document.createElement('select');
+    options(group, d.group);
+    group.onchange = () => api('PUT', '/api/devices/' + encodeURIComponent(d.device_id) + '/group', {group: group.value}).then(refresh);
+    const box = document.createElement('input');
+    box.type = 'checkbox';
+    box.checked = checked.has(d.device_id);
+    box.onchange = () => box.checked ? checked.add(d.device_id) : checked.delete(d.device_id);
+    const cells = [box, d.device_id, d.status, group, describe(d.effective, d.sources)].map(v => {
+      const td = document.createElement('td'); td.append(v); return td;
+    });
+    tr.append(...cells);
+    return tr;
+  }));
+}
+function createGroup() {
+  api('POST', '/api/groups', {id: $('new-id').value, name: $('new-name').value, parent: $('new-parent').value}).then(refresh);
+}
+function saveGroup() {
+  api('PUT', '/api/groups/' + selected.id, {name: $('edit-name').value, defaults: {
+    model: $('edit-model').value, base_url: $('edit-base-url').value, max_steps: Number($('edit-max-steps').value) || 0,
+    lang: $('edit-lang').value, ui_lang: $('edit-ui-lang').value, provider: $('edit-provider').value,
+    model_profile: $('edit-model-profile').value, policy_file: $('edit-policy-file').value, pacing: $('edit-pacing').value,
+    temperature: $('edit-temperature').value === '' ? null : Number($('edit-temperature').value)}}).then(refresh);
+}
+function moveGroup() { api('POST', '/api/groups/' + selected.id + '/move', {parent: $('edit-parent').value}).then(refresh); }
+function deleteGroup() { api('DELETE', '/api/groups/' + selected.id).then(refresh); }
+function startBatch() {
+  api('POST', '/api/batches', {op: $('batch-op').value, devices: [...checked], group: $('batch-group').value,
+    instruction: $('batch-instruction').value, force: $('batch-force').checked}).then(pollBatches);
+}
+async function pollBatches() {
+  clearTimeout(polling);
+  const jobs = await api('GET', '/api/batches');
+  $('batches').replaceChildren(...jobs.slice(0, 5).map(j => {
+    const div = document.createElement('div');
+    div.textContent = j.request.op + ': ' + j.done + '/' + j.total + ' done, ' + j.failed + ' failed' + (j.finished_at ? '' : '…');
+    const ul = document.createElement('ul');
+    for (const r of j.results) {
+      const li = document.createElement('li');
+      li.textContent = r.device_id + ' ' + r.status + ' ' + (r.error || r.message || '');
+      ul.append(li);
+    }
+    div.append(ul);
+    return div;
+  }));
+  if (jobs.some(j => !j.finished_at)) polling = setTimeout(pollBatches, 2000); else refresh();
+}
+pollBatches();
+</script>
+</body></html>`
@@ -0,0 +1,351 @@
+package group
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	logs "github.com/sirupsen/logrus"
+)
+
+type BatchOp string
+
+const (
+	OpMove      BatchOp = "move"      // assign the devices to Group
+	OpReconnect BatchOp = "reconnect" // drop and reopen the adb connection of network devices
+	OpTask      BatchOp = "task"      // run Instruction on every device
+)
+
+// defaultParallel is how many devices a batch handles at once unless the
+// request says otherwise; tasks are further limited by the Runner.
+const defaultParallel = 8
+
+// maxBatchJobs is how many jobs are kept for GET /api/batches, oldest
+// finished ones are forgotten first.
+const maxBatchJobs = 100
+
+// Devices is the part of the device driver the API uses.
+type Devices interface {
+	Lister
+	Connect(ctx context.Context, address string) (string, error)
+	Disconnect(ctx context.Context, address string) (string, error)
+	IsConnected(ctx context.Context, deviceID string) bool
+}
+
+// Runner runs a task on a device until it ends and returns its result. force
+// runs the task even when the same one has just run on the device.
+type Runner interface {
+	RunTask(ctx context.Context, deviceID, instruction string, force bool) (string, error)
+}
+
+// RunnerFunc adapts a function to Runner.
+type RunnerFunc func(ctx context.Context, deviceID, instruction string, force bool) (string, error)
+
+func (f RunnerFunc) RunTask(ctx context.Context, deviceID, instruction string, force bool) (string, error) {
+	return f(ctx, deviceID, instruction, force)
+}
+
+// BatchRequest selects devices by id, by group or both, and what to do on
+// each of them.
+type BatchRequest struct {
+	Op          BatchOp  `json:"op"`
+	Devices     []string `json:"devices"`
+	Groups      []string `json:"groups"`      // adds the devices of these groups and their subgroups
+	Group       string   `json:"group"`       // target of move, empty removes the devices from their group
+	Instruction string   `json:"instruction"` // task of OpTask
+	Force       bool     `json:"force"`       // run the task again on devices that have just run it
+	Parallel    int      `json:"parallel"`
+}
+
+type DeviceStatus string
+
+const (
+	StatusPending   DeviceStatus = "pending"
+	StatusRunning   DeviceStatus = "running"
+	StatusSucceeded DeviceStatus = "succeeded"
+	StatusFailed    DeviceStatus = "failed"
+	StatusCancelled DeviceStatus = "cancelled"
+)
+
+// DeviceResult is the outcome of a batch on one device.
+type DeviceResult struct {
+	DeviceID string       `json:"device_id"`
+	Status   DeviceStatus `json:"status"`
+	Message  string       `json:"message,omitempty"`
+	Error    string       `json:"error,omitempty"`
+}
+
+// BatchJob is a batch and its progress, as returned by the batch API.
+type BatchJob struct {
+	ID         string         `json:"id"`
+	Request    BatchRequest   `json:"request"`
+	CreatedAt  time.Time      `json:"created_at"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+	Total      int            `json:"total"`
+	Done       int            `json:"done"` // devices that succeeded, failed or were cancelled
+	Failed     int            `json:"failed"`
+	Results    []DeviceResult `json:"results"` // in the order of the devices
+}
+
+type batchJob struct {
+	BatchJob
+	cancel context.CancelFunc
+}
+
+// Batches runs batch operations in the background and keeps their progress.
+type Batches struct {
+	ctx     context.Context // jobs end with it
+	tree    *Tree
+	devices Devices
+	runner  Runner // nil rejects OpTask
+
+	mu    sync.Mutex
+	jobs  map[string]*batchJob
+	order []string // job ids, oldest first
+}
+
+func NewBatches(ctx context.Context, tree *Tree, devices Devices, runner Runner) *Batches {
+	return &Batches{
+		ctx:     ctx,
+		tree:    tree,
+		devices: devices,
+		runner:  runner,
+		jobs:    map[string]*batchJob{},
+	}
+}
+
+// Start validates the request and runs it in the background. Every device gets
+// its own result, one failing does not stop the others.
+func (r *Batches) Start(req BatchRequest) (BatchJob, error) {
+	switch req.Op {
+	case OpMove:
+		if req.Group != "" {
+			if _, ok := r.tree.Get(req.Group); !ok {
+				return BatchJob{}, fmt.Errorf("%w: %s", ErrNotFound, req.Group)
+			}
+		}
+	case OpReconnect:
+	case OpTask:
+		if r.runner == nil {
+			return BatchJob{}, fmt.Errorf("tasks cannot be run by this server")
+		}
+		if strings.TrimSpace(req.Instruction) == "" {
+			return BatchJob{}, fmt.Errorf("instruction is required")
+		}
+	default:
+		return BatchJob{}, fmt.Errorf("unknown batch op %q, want move, reconnect or task", req.Op)
+	}
+	devices, err := r.selectDevices(req)
+	if err != nil {
+		return BatchJob{}, err
+	}
+	if len(devices) == 0 {
+		return BatchJob{}, fmt.Errorf("no devices selected")
+	}
+	if req.Parallel <= 0 {
+		req.Parallel = defaultParallel
+	}
+
+	ctx, cancel := context.WithCancel(r.ctx)
+	job := &batchJob{
+		BatchJob: BatchJob{
+			ID:        uuid.New().String(),
+			Request:   req,
+			CreatedAt: time.Now(),
+			Total:     len(devices),
+			Results:   make([]DeviceResult, len(devices)),
+		},
+		cancel: cancel,
+	}
+	for i, id := range devices {
+		job.Results[i] = DeviceResult{DeviceID: id, Status: StatusPending}
+	}
+
+	r.mu.Lock()
+	r.jobs[job.ID] = job
+	r.order = append(r.order, job.ID)
+	r.forget()
+	snapshot := job.snapshot()
+	r.mu.Unlock()
+
+	logs.Infof("🗂️ batch %s: %s on %d device(s)", job.ID, req.Op, len(devices))
+	go r.run(ctx, job)
+	return snapshot, nil
+}
+
+// selectDevices returns the requested devices without duplicates, in the
+// order given, group members after the explicit ids.
+func (r *Batches) selectDevices(req BatchRequest) ([]string, error) {
+	seen := map[string]bool{}
+	var devices []string
+	add := func(id string) {
+		if id = strings.TrimSpace(id); id != "" && !seen[id] {
+			seen[id] = true
+			devices = append(devices, id)
+		}
+	}
+	for _, id := range req.Devices {
+		add(id)
+	}
+	for _, g := range req.Groups {
+		if _, ok := r.tree.Get(g); !ok {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, g)
+		}
+		for _, id := range r.tree.Devices(g) {
+			add(id)
+		}
+	}
+	return devices, nil
+}
+
+func (r *Batches) run(ctx context.Context, job *batchJob) {
+	defer job.cancel()
+
+	slots := make(chan struct{}, job.Request.Parallel)
+	var wg sync.WaitGroup
+	for i := range job.Results {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			r.finish(job, i, "", ctx.Err())
+			continue
+		}
+		r.update(job, i, StatusRunning)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			message, err := r.apply(ctx, job.Request, job.Results[i].DeviceID)
+			r.finish(job, i, message, err)
+		}(i)
+	}
+	wg.Wait()
+
+	r.mu.Lock()
+	now := time.Now()
+	job.FinishedAt = &now
+	done, failed := job.Done, job.Failed
+	r.mu.Unlock()
+	logs.Infof("🗂️ batch %s finished: %d device(s), %d failed", job.ID, done, failed)
+}
+
+// apply runs the operation on one device.
+func (r *Batches) apply(ctx context.Context, req BatchRequest, deviceID string) (string, error) {
+	switch req.Op {
+	case OpMove:
+		if err := r.tree.Assign(deviceID, req.Group); err != nil {
+			return "", err
+		}
+		if req.Group == "" {
+			return "removed from its group", nil
+		}
+		return "moved to " + strings.Join(r.tree.Path(req.Group), " / "), nil
+	case OpReconnect:
+		// only network devices have a connection adb can reopen
+		if !strings.Contains(deviceID, ":") {
+			return "", fmt.Errorf("not a network device, only ip:port devices can be reconnected")
+		}
+		if _, err := r.devices.Disconnect(ctx, deviceID); err != nil {
+			return "", err
+		}
+		message, err := r.devices.Connect(ctx, deviceID)
+		if err != nil {
+			return "", err
+		}
+		if !r.devices.IsConnected(ctx, deviceID) {
+			return "", fmt.Errorf("still offline after reconnecting: %s", message)
+		}
+		return message, nil
+	default:
+		return r.runner.RunTask(ctx, deviceID, req.Instruction, req.Force)
+	}
+}
+
+func (r *Batches) update(job *batchJob, i int, status DeviceStatus) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job.Results[i].Status = status
+}
+
+func (r *Batches) finish(job *batchJob, i int, message string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := &job.Results[i]
+	result.Message = message
+	job.Done++
+	switch {
+	case err == nil:
+		result.Status = StatusSucceeded
+	case errors.Is(err, context.Canceled):
+		result.Status = StatusCancelled
+		result.Error = err.Error()
+	default:
+		result.Status = StatusFailed
+		result.Error = err.Error()
+		job.Failed++
+		logs.Warnf("🗂️ batch %s: %s failed on %s, err: %v", job.ID, job.Request.Op, result.DeviceID, err)
+	}
+}
+
+// forget drops the oldest finished jobs beyond maxBatchJobs, it must be
+// called with r.mu held.
+func (r *Batches) forget() {
+	for i := 0; len(r.order) > maxBatchJobs && i < len(r.order); {
+		id := r.order[i]
+		if r.jobs[id].FinishedAt == nil {
+			i++
+			continue
+		}
+		delete(r.jobs, id)
+		r.order = append(r.order[:i], r.order[i+1:]...)
+	}
+}
+
+// snapshot copies the job, it must be called with the mutex of the Batches
+// held.
+func (j *batchJob) snapshot() BatchJob {
+	job := j.BatchJob
+	job.Results = append([]DeviceResult(nil), j.Results...)
+	return job
+}
+
+// Get returns the progress of a job.
+func (r *Batches) Get(id string) (BatchJob, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job, ok := r.jobs[id]
+	if !ok {
+		return BatchJob{}, false
+	}
+	return job.snapshot(), true
+}
+
+// List returns the kept jobs, newest first.
+func (r *Batches) List() []BatchJob {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	jobs := make([]BatchJob, 0, len(r.order))
+	for i := len(r.order) - 1; i >= 0; i-- {
+		jobs = append(jobs, r.jobs[r.order[i]].snapshot())
+	}
+	return jobs
+}
+
+// Cancel stops a job: devices not started yet are cancelled, running tasks
+// see their context cancelled.
+func (r *Batches) Cancel(id string) error {
+	r.mu.Lock()
+	job, ok := r.jobs[id]
+	r.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: batch %s", ErrNotFound, id)
+	}
+	job.cancel()
+	return nil
+}
@@ -0,0 +1,392 @@
+package group
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+
+	"autoglm-go/phoneagent/definitions"
+	"autoglm-go/phoneagent/llm"
+	"autoglm-go/phoneagent/policy"
+	"autoglm-go/phoneagent/uilang"
+)
+
+var (
+	ErrNotFound = errors.New("group not found")
+	ErrExists   = errors.New("group already exists")
+	ErrCycle    = errors.New("a group cannot be moved under itself")
+	ErrNotEmpty = errors.New("group has subgroups or devices")
+)
+
+// idRe keeps group ids usable in URL paths.
+var idRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// Group is a node of the device group tree, e.g. region → office → rack.
+type Group struct {
+	ID       string                    `json:"id"`
+	Name     string                    `json:"name,omitempty"`   // display name, default: ID
+	Parent   string                    `json:"parent,omitempty"` // empty for top level groups
+	Defaults definitions.GroupDefaults `json:"defaults"`
+}
+
+// file is the JSON layout of the groups file.
+type file struct {
+	Groups  []*Group          `json:"groups"`
+	Devices map[string]string `json:"devices"` // device id -> group id
+}
+
+// Tree is the device group hierarchy. Every change is saved to its file.
+type Tree struct {
+	filename string
+
+	mu      sync.RWMutex
+	groups  map[string]*Group
+	devices map[string]string
+}
+
+// Load reads the groups file at path, a missing file is an empty tree.
+func Load(path string) (*Tree, error) {
+	tree := &Tree{
+		filename: path,
+		groups:   map[string]*Group{},
+		devices:  map[string]string{},
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return tree, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid groups file %s: %w", path, err)
+	}
+	for _, g := range f.Groups {
+		if !idRe.MatchString(g.ID) {
+			return nil, fmt.Errorf("invalid group id %q in %s", g.ID, path)
+		}
+		if err := validate(g.Defaults); err != nil {
+			return nil, fmt.Errorf("group %s: %w", g.ID, err)
+		}
+		tree.groups[g.ID] = g
+	}
+	for _, g := range f.Groups {
+		if g.Parent != "" && tree.groups[g.Parent] == nil {
+			return nil, fmt.Errorf("group %s: parent %s: %w", g.ID, g.Parent, ErrNotFound)
+		}
+		if tree.isAncestor(g.ID, g.Parent) {
+			return nil, fmt.Errorf("group %s: %w", g.ID, ErrCycle)
+		}
+	}
+	for device, id := range f.Devices {
+		if tree.groups[id] == nil {
+			return nil, fmt.Errorf("device %s: group %s: %w", device, id, ErrNotFound)
+		}
+		tree.devices[device] = id
+	}
+	return tree, nil
+}
+
+func validate(d definitions.GroupDefaults) error {
+	if d.Lang != "" && d.Lang != "cn" && d.Lang != "en" {
+		return fmt.Errorf("invalid language option: %s. Must be 'cn' or 'en'", d.Lang)
+	}
+	if d.MaxSteps < 0 {
+		return fmt.Errorf("invalid max steps: %d", d.MaxSteps)
+	}
+	if d.UILanguage != "" {
+		if _, err := uilang.Parse(d.UILanguage); err != nil {
+			return err
+		}
+	}
+	if d.Provider != "" {
+		if _, err := llm.NewProvider(&definitions.ModelConfig{Provider: d.Provider}); err != nil {
+			return err
+		}
+	}
+	if d.Temperature != nil && *d.Temperature < 0 {
+		return fmt.Errorf("invalid temperature: %v", *d.Temperature)
+	}
+	if d.PolicyFile != "" {
+		if _, err := policy.Load(d.PolicyFile); err != nil {
+			return err
+		}
+	}
+	_, err := definitions.ParsePacing(d.Pacing)
+	return err
+}
+
+// save must be called with r.mu held.
+func (r *Tree) save() error {
+	f := file{Groups: r.sorted(), Devices: r.devices}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(r.filename), ".groups-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), r.filename)
+}
+
+// sorted must be called with r.mu held.
+func (r *Tree) sorted() []*Group {
+	groups := make([]*Group, 0, len(r.groups))
+	for _, g := range r.groups {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].ID < groups[j].ID })
+	return groups
+}
+
+// isAncestor reports whether id is parent or one of its ancestors. It must be
+// called with r.mu held.
+func (r *Tree) isAncestor(id, parent string) bool {
+	for seen := 0; parent != "" && seen <= len(r.groups); seen++ {
+		if parent == id {
+			return true
+		}
+		g := r.groups[parent]
+		if g == nil {
+			return false
+		}
+		parent = g.Parent
+	}
+	return parent != ""
+}
+
+// Create adds a group under parent, or at the top level when parent is empty.
+func (r *Tree) Create(g Group) (*Group, error) {
+	if !idRe.MatchString(g.ID) {
+		return nil, fmt.Errorf("invalid group id %q", g.ID)
+	}
+	if err := validate(g.Defaults); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.groups[g.ID] != nil {
+		return nil, fmt.Errorf("%w: %s", ErrExists, g.ID)
+	}
+	if g.Parent != "" && r.groups[g.Parent] == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, g.Parent)
+	}
+	created := g
+	r.groups[g.ID] = &created
+	if err := r.save(); err != nil {
+		delete(r.groups, g.ID)
+		return nil, err
+	}
+	return &created, nil
+}
+
+// Move puts the group and everything under it below parent. An empty parent
+// makes it a top level group.
+func (r *Tree) Move(id, parent string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	g := r.groups[id]
+	if g == nil {
+		return fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if parent != "" && r.groups[parent] == nil {
+		return fmt.Errorf("%w: %s", ErrNotFound, parent)
+	}
+	if r.isAncestor(id, parent) {
+		return ErrCycle
+	}
+	old := g.Parent
+	g.Parent = parent
+	if err := r.save(); err != nil {
+		g.Parent = old
+		return err
+	}
+	return nil
+}
+
+// Update replaces the display name and defaults of a group.
+func (r *Tree) Update(id, name string, defaults definitions.GroupDefaults) error {
+	if err := validate(defaults); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	g := r.groups[id]
+	if g == nil {
+		return fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	old := *g
+	g.Name, g.Defaults = name, defaults
+	if err := r.save(); err != nil {
+		*g = old
+		return err
+	}
+	return nil
+}
+
+// Delete removes a group that has no subgroups and no devices.
+func (r *Tree) Delete(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	g := r.groups[id]
+	if g == nil {
+		return fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	for _, other := range r.groups {
+		if other.Parent == id {
+			return ErrNotEmpty
+		}
+	}
+	for _, groupID := range r.devices {
+		if groupID == id {
+			return ErrNotEmpty
+		}
+	}
+	delete(r.groups, id)
+	if err := r.save(); err != nil {
+		r.groups[id] = g
+		return err
+	}
+	return nil
+}
+
+// Assign puts a device into a group, an empty id takes it out of its group.
+// A device is in at most one group.
+func (r *Tree) Assign(deviceID, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if id != "" && r.groups[id] == nil {
+		return fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	old, had := r.devices[deviceID]
+	if id == "" {
+		delete(r.devices, deviceID)
+	} else {
+		r.devices[deviceID] = id
+	}
+	if err := r.save(); err != nil {
+		if had {
+			r.devices[deviceID] = old
+		} else {
+			delete(r.devices, deviceID)
+		}
+		return err
+	}
+	return nil
+}
+
+// Get returns a copy of the group.
+func (r *Tree) Get(id string) (Group, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	g := r.groups[id]
+	if g == nil {
+		return Group{}, false
+	}
+	return *g, true
+}
+
+// Groups returns copies of all groups ordered by id.
+func (r *Tree) Groups() []Group {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	groups := make([]Group, 0, len(r.groups))
+	for _, g := range r.sorted() {
+		groups = append(groups, *g)
+	}
+	return groups
+}
+
+// GroupOf returns the group id of a device, empty when it is in none.
+func (r *Tree) GroupOf(deviceID string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.devices[deviceID]
+}
+
+// Devices returns the devices assigned to the group and its subgroups.
+func (r *Tree) Devices(id string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var devices []string
+	for device, groupID := range r.devices {
+		if groupID == id || r.isAncestor(id, r.groups[groupID].Parent) {
+			devices = append(devices, device)
+		}
+	}
+	sort.Strings(devices)
+	return devices
+}
+
+// Path returns the ids from the top level group down to id.
+func (r *Tree) Path(id string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.path(id)
+}
+
+// path must be called with r.mu held.
+func (r *Tree) path(id string) []string {
+	var path []string
+	for g := r.groups[id]; g != nil && len(path) <= len(r.groups); g = r.groups[g.Parent] {
+		path = append([]string{g.ID}, path...)
+	}
+	return path
+}
+
+// Effective returns the defaults a device gets from its group and the groups
+// above it, the nearest group winning.
+func (r *Tree) Effective(deviceID string) definitions.GroupDefaults {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var defaults definitions.GroupDefaults
+	path := r.path(r.devices[deviceID])
+	for i := len(path) - 1; i >= 0; i-- {
+		defaults = defaults.Merge(r.groups[path[i]].Defaults)
+	}
+	return defaults
+}
+
+// Sources returns the group each of the effective defaults of a device comes
+// from, by JSON field name.
+func (r *Tree) Sources(deviceID string) map[string]string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	sources := map[string]string{}
+	path := r.path(r.devices[deviceID])
+	for i := len(path) - 1; i >= 0; i-- {
+		var fields map[string]json.RawMessage
+		data, _ := json.Marshal(r.groups[path[i]].Defaults)
+		_ = json.Unmarshal(data, &fields)
+		for name := range fields {
+			if sources[name] == "" {
+				sources[name] = path[i]
+			}
+		}
+	}
+	return sources
+}
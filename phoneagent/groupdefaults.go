@@ -0,0 +1,111 @@
+package phoneagent
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"autoglm-go/phoneagent/definitions"
+	"autoglm-go/phoneagent/policy"
+	"autoglm-go/phoneagent/uilang"
+	"autoglm-go/utils"
+)
+
+var (
+	groupDefaultsMu sync.RWMutex
+	groupDefaultsOf func(deviceID string) definitions.GroupDefaults
+)
+
+// UseGroupDefaults makes defaults tell the settings a device inherits from
+// its groups, and returns a function restoring the previous one. They are
+// looked up at the start of every task, so a device moved into a group runs
+// its next task with the settings of the group.
+func UseGroupDefaults(defaults func(deviceID string) definitions.GroupDefaults) func() {
+	groupDefaultsMu.Lock()
+	defer groupDefaultsMu.Unlock()
+	previous := groupDefaultsOf
+	groupDefaultsOf = defaults
+	return func() {
+		groupDefaultsMu.Lock()
+		defer groupDefaultsMu.Unlock()
+		groupDefaultsOf = previous
+	}
+}
+
+func groupDefaults(deviceID string) definitions.GroupDefaults {
+	groupDefaultsMu.RLock()
+	defer groupDefaultsMu.RUnlock()
+	if groupDefaultsOf == nil {
+		return definitions.GroupDefaults{}
+	}
+	return groupDefaultsOf(deviceID)
+}
+
+// useGroupDefaults switches the agent to the settings its device inherits
+// from its groups for a task and returns a function switching it back. The
+// model profile of the group is for the tasks that select none.
+func (r *PhoneAgent) useGroupDefaults(ctx context.Context) (func(), error) {
+	d := groupDefaults(r.AgentConfig.DeviceID)
+	if d == (definitions.GroupDefaults{}) {
+		return func() {}, nil
+	}
+	engine := r.policy
+	if d.PolicyFile != "" {
+		config, err := policy.Load(d.PolicyFile)
+		if err != nil {
+			return nil, fmt.Errorf("policy of the device group: %w", err)
+		}
+		engine = newPolicy(config)
+	}
+	pause, err := definitions.ParsePacing(d.Pacing)
+	if err != nil {
+		return nil, fmt.Errorf("pacing of the device group: %w", err)
+	}
+
+	agentConfig, modelConfig, client := r.AgentConfig, r.ModelConfig, r.ModelClient
+	language, previousPolicy := r.uiLanguage, r.policy
+	groupAgent, groupModel := *agentConfig, *modelConfig
+	if d.MaxSteps > 0 {
+		groupAgent.MaxSteps = d.MaxSteps
+	}
+	if d.Lang != "" {
+		groupAgent.Lang, groupModel.Lang = d.Lang, d.Lang
+	}
+	if d.UILanguage != "" {
+		groupAgent.UILanguage = d.UILanguage
+	}
+	if d.ModelProfile != "" {
+		groupAgent.ModelProfile = d.ModelProfile
+	}
+	if d.Pacing != "" {
+		groupAgent.StepPause = pause
+	}
+	// the model config is shared with the other agents
+	modelChanged := d.Model != "" || d.BaseURL != "" || d.Provider != "" || d.Temperature != nil || d.Lang != ""
+	if d.Model != "" {
+		groupModel.ModelName = d.Model
+	}
+	if d.BaseURL != "" {
+		groupModel.BaseURL = d.BaseURL
+	}
+	if d.Provider != "" {
+		groupModel.Provider = d.Provider
+	}
+	if d.Temperature != nil {
+		groupModel.Temperature = *d.Temperature
+	}
+
+	r.AgentConfig, r.policy = &groupAgent, engine
+	if groupAgent.GetUILanguage() != agentConfig.GetUILanguage() {
+		r.uiLanguage = uilang.New(groupAgent.GetUILanguage())
+	}
+	if modelChanged {
+		r.ModelConfig = &groupModel
+		r.ModelClient = client.Derive(r.ModelConfig)
+	}
+	r.logFor(ctx).Infof("🗂️ device group settings: %s", utils.JsonString(d))
+	return func() {
+		r.AgentConfig, r.ModelConfig, r.ModelClient = agentConfig, modelConfig, client
+		r.uiLanguage, r.policy = language, previousPolicy
+	}, nil
+}
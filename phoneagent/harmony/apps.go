@@ -0,0 +1,89 @@
+package harmony
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"autoglm-go/constants"
+	"autoglm-go/phoneagent/definitions"
+)
+
+// mainAbility is the entry ability of a bundle in `bm dump -n`.
+var mainAbility = regexp.MustCompile(`"mainAbility"\s*:\s*"([^"]+)"`)
+
+// StartIntent starts an ability with aa start. Intent.Package is the bundle
+// and Intent.Activity the ability, the main ability of the bundle when empty
+// and neither an action nor a URI is given.
+func (r *HDCDevice) StartIntent(ctx context.Context, intent definitions.Intent, deviceID string) error {
+	args := []string{"aa", "start"}
+	ability := intent.Activity
+	if ability == "" && intent.Package != "" && intent.Action == "" && intent.URI == "" {
+		var err error
+		if ability, err = r.mainAbility(ctx, deviceID, intent.Package); err != nil {
+			return err
+		}
+	}
+	if intent.Package != "" {
+		args = append(args, "-b", intent.Package)
+	}
+	if ability != "" {
+		args = append(args, "-a", ability)
+	}
+	if intent.Action != "" {
+		args = append(args, "-A", intent.Action)
+	}
+	if intent.URI != "" {
+		args = append(args, "-U", intent.URI)
+	}
+
+	output, err := r.Shell(ctx, deviceID, args...)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(output, "successfully") {
+		return fmt.Errorf("aa start failed: %s", strings.TrimSpace(output))
+	}
+	time.Sleep(time.Second * 1)
+	return nil
+}
+
+// mainAbility returns the entry ability of bundle.
+func (r *HDCDevice) mainAbility(ctx context.Context, deviceID, bundle string) (string, error) {
+	output, err := r.Shell(ctx, deviceID, "bm", "dump", "-n", bundle)
+	if err != nil {
+		return "", err
+	}
+	if m := mainAbility.FindStringSubmatch(output); m != nil && m[1] != "" {
+		return m[1], nil
+	}
+	return "", fmt.Errorf("bundle %s is not installed or has no main ability", bundle)
+}
+
+// ForceStop stops the app.
+func (r *HDCDevice) ForceStop(ctx context.Context, packageName, deviceID string) error {
+	_, err := r.Shell(ctx, deviceID, "aa", "force-stop", packageName)
+	return err
+}
+
+// ClearData deletes the data and the cache of the app.
+func (r *HDCDevice) ClearData(ctx context.Context, packageName, deviceID string) error {
+	for _, flag := range []string{"-d", "-c"} {
+		output, err := r.Shell(ctx, deviceID, "bm", "clean", "-n", packageName, flag)
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(output, "successfully") {
+			return fmt.Errorf("bm clean failed: %s", strings.TrimSpace(output))
+		}
+	}
+	return nil
+}
+
+// AppPackage returns the bundle of an app name.
+func (r *HDCDevice) AppPackage(appName string) (string, bool) {
+	bundle, ok := constants.APP_PACKAGES_HARMONY[appName]
+	return bundle, ok
+}
@@ -0,0 +1,223 @@
+// Package harmony drives HarmonyOS NEXT devices with hdc, the device
+// connector of OpenHarmony: screenshots with snapshot_display, input with
+// uitest and apps with the ability manager (aa).
+package harmony
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"autoglm-go/phoneagent/definitions"
+	logs "github.com/sirupsen/logrus"
+)
+
+const (
+	hdcPath = "hdc"
+)
+
+// HDCDevice drives HarmonyOS devices through hdc.
+type HDCDevice struct{}
+
+// hdc runs an hdc command, on deviceID when it is not empty.
+func (r *HDCDevice) hdc(ctx context.Context, deviceID string, args ...string) (string, error) {
+	var cmdArgs []string
+	if deviceID != "" {
+		cmdArgs = append(cmdArgs, "-t", deviceID)
+	}
+	cmdArgs = append(cmdArgs, args...)
+	logs.Debugf("[hdc] run cmd: %s %s", hdcPath, strings.Join(cmdArgs, " "))
+
+	output, err := exec.CommandContext(ctx, hdcPath, cmdArgs...).CombinedOutput()
+	if err != nil {
+		return string(output), fmt.Errorf("hdc %s failed: %v, output: %s", args[0], err, strings.TrimSpace(string(output)))
+	}
+	return string(output), nil
+}
+
+// Shell runs a command in the shell of the device. hdc joins the arguments
+// into one command line, so they are quoted here.
+func (r *HDCDevice) Shell(ctx context.Context, deviceID string, args ...string) (string, error) {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		quoted = append(quoted, quote(arg))
+	}
+	return r.hdc(ctx, deviceID, "shell", strings.Join(quoted, " "))
+}
+
+// quote quotes arg for the shell of the device when it needs it.
+func quote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\n'\"\\$`&|;<>()*?[]{}~#!") {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+func (r *HDCDevice) Connect(ctx context.Context, address string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	output, err := r.hdc(ctx, "", "tconn", address)
+	if err != nil {
+		logs.Errorf("[Connect] run cmd failed, err: %v", err)
+		return fmt.Sprintf("Connect error: %v", err), err
+	}
+	output = strings.TrimSpace(output)
+	if strings.Contains(output, "Connect OK") || strings.Contains(strings.ToLower(output), "already") {
+		return fmt.Sprintf("Connected to %s", address), nil
+	}
+	return fmt.Sprintf("Connection error: %s", output), nil
+}
+
+func (r *HDCDevice) Disconnect(ctx context.Context, address string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	output, err := r.hdc(ctx, "", "tconn", address, "-remove")
+	if err != nil {
+		logs.Errorf("[Disconnect] run cmd failed, err: %v", err)
+		return fmt.Sprintf("Disconnect error: %v", err), err
+	}
+	return output, nil
+}
+
+// ListDevices parses `hdc list targets -v`, lines such as
+// "23E0223B22000921	USB	Connected	localhost	hdc".
+func (r *HDCDevice) ListDevices(ctx context.Context) ([]definitions.DeviceInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	output, err := r.hdc(ctx, "", "list", "targets", "-v")
+	if err != nil {
+		logs.Errorf("[ListDevices] run cmd failed, err: %v", err)
+		return nil, err
+	}
+
+	var devices []definitions.DeviceInfo
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		parts := strings.Fields(scanner.Text())
+		if len(parts) < 3 || parts[0] == "[Empty]" {
+			continue
+		}
+
+		info := definitions.DeviceInfo{
+			DeviceID:       parts[0],
+			Status:         hdcStatus(parts[2]),
+			ConnectionType: definitions.USB,
+			OS:             definitions.HarmonyOS,
+		}
+		if parts[1] == "TCP" {
+			info.ConnectionType = definitions.Remote
+		}
+		if info.Status == "device" {
+			info.Model = r.param(ctx, info.DeviceID, "const.product.model")
+			info.AndroidVersion = r.param(ctx, info.DeviceID, "const.product.software.version")
+		}
+		devices = append(devices, info)
+	}
+	return devices, nil
+}
+
+// hdcStatus maps the states of hdc to those of adb, which the rest of the
+// agent checks.
+func hdcStatus(state string) string {
+	switch state {
+	case "Connected":
+		return "device"
+	case "Unauthorized":
+		return "unauthorized"
+	default:
+		return "offline"
+	}
+}
+
+// param reads a system parameter of the device, empty when it fails.
+func (r *HDCDevice) param(ctx context.Context, deviceID, name string) string {
+	output, err := r.Shell(ctx, deviceID, "param", "get", name)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(output)
+}
+
+// GetDeviceInfo returns deviceID from `hdc list targets`, or the only device
+// when deviceID is empty, like hdc itself.
+func (r *HDCDevice) GetDeviceInfo(ctx context.Context, deviceID string) (*definitions.DeviceInfo, error) {
+	devices, err := r.ListDevices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if deviceID == "" {
+		if len(devices) != 1 {
+			return nil, fmt.Errorf("%d devices found, a device id is required", len(devices))
+		}
+		return &devices[0], nil
+	}
+	for i := range devices {
+		if devices[i].DeviceID == deviceID {
+			return &devices[i], nil
+		}
+	}
+	return nil, fmt.Errorf("device %s not found", deviceID)
+}
+
+func (r *HDCDevice) IsConnected(ctx context.Context, deviceID string) bool {
+	info, err := r.GetDeviceInfo(ctx, deviceID)
+	return err == nil && info.Status == "device"
+}
+
+// Ping runs a one-off shell command on the device.
+func (r *HDCDevice) Ping(ctx context.Context, deviceID string) error {
+	output, err := r.Shell(ctx, deviceID, "echo", "ok")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(output) != "ok" {
+		return fmt.Errorf("unexpected output: %s", strings.TrimSpace(output))
+	}
+	return nil
+}
+
+// EnableTCPIP makes the device listen for hdc on port, to connect it with
+// Connect afterwards.
+func (r *HDCDevice) EnableTCPIP(ctx context.Context, port int, deviceID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if _, err := r.hdc(ctx, deviceID, "tmode", "port", strconv.Itoa(port)); err != nil {
+		logs.Errorf("[EnableTCPIP] run cmd failed, err: %v", err)
+		return err
+	}
+	time.Sleep(time.Second * 3)
+	return nil
+}
+
+func (r *HDCDevice) GetDeviceIP(ctx context.Context, deviceID string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	output, err := r.Shell(ctx, deviceID, "ifconfig", "wlan0")
+	if err != nil {
+		logs.Errorf("[GetDeviceIP] run cmd failed, err: %v", err)
+		return "", err
+	}
+	// inet addr:192.168.1.5  Bcast:192.168.1.255  Mask:255.255.255.0
+	for _, field := range strings.Fields(output) {
+		if ip, ok := strings.CutPrefix(field, "addr:"); ok && ip != "" {
+			return ip, nil
+		}
+	}
+	return "", nil
+}
+
+func (r *HDCDevice) RestartServer(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	return r.hdc(ctx, "", "kill", "-r")
+}
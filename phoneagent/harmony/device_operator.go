@@ -0,0 +1,210 @@
+package harmony
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"autoglm-go/constants"
+	"autoglm-go/phoneagent/definitions"
+	"github.com/google/uuid"
+	logs "github.com/sirupsen/logrus"
+)
+
+const screenshotPath = "/data/local/tmp/autoglm_screen.jpeg"
+
+// The key codes of OpenHarmony for ClearText.
+const (
+	keyCtrlLeft = "2072"
+	keyA        = "2017"
+	keyDel      = "2055"
+)
+
+// GetScreenshot takes a screenshot with snapshot_display, which writes JPEG,
+// and converts it to PNG as the screenshots of the other devices. When it
+// fails the screenshot is a fallback one, as on the other backends.
+func (r *HDCDevice) GetScreenshot(ctx context.Context, deviceID string) (*definitions.Screenshot, error) {
+	tempPath := filepath.Join(os.TempDir(), fmt.Sprintf("screenshot_%s.jpeg", uuid.New().String()))
+	defer func() {
+		_ = os.Remove(tempPath)
+	}()
+
+	output, err := r.Shell(ctx, deviceID, "snapshot_display", "-f", screenshotPath)
+	if err != nil || !strings.Contains(output, "success") {
+		logs.Errorf("Screenshot command error: %v, output: %s", err, output)
+		return definitions.FallbackScreenshot(), nil
+	}
+	if _, err := r.hdc(ctx, deviceID, "file", "recv", screenshotPath, tempPath); err != nil {
+		logs.Errorf("Receive screenshot error: %v", err)
+		return definitions.FallbackScreenshot(), nil
+	}
+
+	data, err := os.ReadFile(tempPath)
+	if err != nil {
+		logs.Errorf("Error reading image file: %v", err)
+		return definitions.FallbackScreenshot(), nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		logs.Errorf("Error decoding image: %v", err)
+		return definitions.FallbackScreenshot(), nil
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		logs.Errorf("Error encoding image: %v", err)
+		return definitions.FallbackScreenshot(), nil
+	}
+
+	bounds := img.Bounds()
+	return &definitions.Screenshot{
+		Base64Data: base64.StdEncoding.EncodeToString(buf.Bytes()),
+		Width:      bounds.Dx(),
+		Height:     bounds.Dy(),
+		Data:       buf.Bytes(),
+	}, nil
+}
+
+// missionName is the bundle of a mission of `aa dump -l`, e.g.
+// "mission name #[#com.huawei.hmos.settings:entry:com.huawei.hmos.settings.MainAbility]".
+var missionName = regexp.MustCompile(`mission name #\[#([^:\]]+)`)
+
+// ForegroundBundle returns the bundle of the app in the foreground, empty on
+// the home screen.
+func (r *HDCDevice) ForegroundBundle(ctx context.Context, deviceID string) (string, error) {
+	output, err := r.Shell(ctx, deviceID, "aa", "dump", "-l")
+	if err != nil {
+		return "", fmt.Errorf("failed to run aa dump: %w", err)
+	}
+	bundle := ""
+	for _, line := range strings.Split(output, "\n") {
+		if m := missionName.FindStringSubmatch(line); m != nil {
+			bundle = m[1]
+		}
+		if strings.Contains(line, "state #FOREGROUND") && bundle != "" {
+			return bundle, nil
+		}
+	}
+	return "", nil
+}
+
+func (r *HDCDevice) GetCurrentApp(ctx context.Context, deviceID string) (string, error) {
+	bundle, err := r.ForegroundBundle(ctx, deviceID)
+	if err != nil {
+		logs.Errorf("Error getting the foreground app: %v", err)
+		return "", err
+	}
+	for appName, bundleName := range constants.APP_PACKAGES_HARMONY {
+		if bundle != "" && bundle == bundleName {
+			return appName, nil
+		}
+	}
+	return "System Home", nil
+}
+
+// uiInput runs a uitest uiInput command.
+func (r *HDCDevice) uiInput(ctx context.Context, deviceID string, args ...string) error {
+	output, err := r.Shell(ctx, deviceID, append([]string{"uitest", "uiInput"}, args...)...)
+	if err != nil {
+		return err
+	}
+	if strings.Contains(strings.ToLower(output), "error") {
+		return fmt.Errorf("uitest uiInput %s failed: %s", args[0], strings.TrimSpace(output))
+	}
+	return nil
+}
+
+func (r *HDCDevice) Tap(ctx context.Context, x, y int, deviceID string) error {
+	err := r.uiInput(ctx, deviceID, "click", strconv.Itoa(x), strconv.Itoa(y))
+	time.Sleep(time.Second * 1)
+	return err
+}
+
+func (r *HDCDevice) DoubleTap(ctx context.Context, x, y int, deviceID string) error {
+	err := r.uiInput(ctx, deviceID, "doubleClick", strconv.Itoa(x), strconv.Itoa(y))
+	time.Sleep(time.Second * 1)
+	return err
+}
+
+func (r *HDCDevice) LongPress(ctx context.Context, x, y int, deviceID string) error {
+	err := r.uiInput(ctx, deviceID, "longClick", strconv.Itoa(x), strconv.Itoa(y))
+	time.Sleep(time.Second * 1)
+	return err
+}
+
+// Swipe takes as long as the Swipe of adb devices, uitest takes a speed in
+// pixels per second instead of a duration.
+func (r *HDCDevice) Swipe(ctx context.Context, startX, startY, endX, endY int, deviceID string) error {
+	distSq := (startX-endX)*(startX-endX) + (startY-endY)*(startY-endY)
+	durationMs := int(float64(distSq) / 1000)
+	durationMs = max(1000, min(durationMs, 2000)) // Clamp between 1000-2000ms
+	velocity := int(math.Sqrt(float64(distSq)) * 1000 / float64(durationMs))
+	velocity = max(200, min(velocity, 40000)) // the range uitest accepts
+
+	err := r.uiInput(ctx, deviceID, "swipe",
+		strconv.Itoa(startX), strconv.Itoa(startY),
+		strconv.Itoa(endX), strconv.Itoa(endY),
+		strconv.Itoa(velocity))
+	time.Sleep(time.Second * 1)
+	return err
+}
+
+func (r *HDCDevice) Back(ctx context.Context, deviceID string) error {
+	err := r.uiInput(ctx, deviceID, "keyEvent", "Back")
+	time.Sleep(time.Second * 1)
+	return err
+}
+
+func (r *HDCDevice) Home(ctx context.Context, deviceID string) error {
+	err := r.uiInput(ctx, deviceID, "keyEvent", "Home")
+	time.Sleep(time.Second * 1)
+	return err
+}
+
+func (r *HDCDevice) LaunchApp(ctx context.Context, appName, deviceID string) (bool, error) {
+	bundle, ok := constants.APP_PACKAGES_HARMONY[appName]
+	if !ok {
+		return false, fmt.Errorf("app name %s not found in APP_PACKAGES_HARMONY", appName)
+	}
+	if err := r.StartIntent(ctx, definitions.Intent{Package: bundle}, deviceID); err != nil {
+		logs.Errorf("failed to launch app, err: %v", err)
+		return false, err
+	}
+	return true, nil
+}
+
+// TypeText types into the focused field, any language.
+func (r *HDCDevice) TypeText(ctx context.Context, text, deviceID string) error {
+	if text == "" {
+		return nil
+	}
+	return r.uiInput(ctx, deviceID, "text", text)
+}
+
+// ClearText selects all the text of the focused field and deletes it.
+func (r *HDCDevice) ClearText(ctx context.Context, deviceID string) error {
+	if err := r.uiInput(ctx, deviceID, "keyEvent", keyCtrlLeft, keyA); err != nil {
+		return err
+	}
+	return r.uiInput(ctx, deviceID, "keyEvent", keyDel)
+}
+
+// DetectAndSetADBKeyboard is a no-op, uitest types with the keyboard of the
+// device.
+func (r *HDCDevice) DetectAndSetADBKeyboard(ctx context.Context, deviceID string) (string, error) {
+	return "", nil
+}
+
+func (r *HDCDevice) RestoreKeyboard(ctx context.Context, ime, deviceID string) error {
+	return nil
+}
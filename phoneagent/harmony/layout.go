@@ -0,0 +1,83 @@
+package harmony
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"autoglm-go/phoneagent/definitions"
+	logs "github.com/sirupsen/logrus"
+)
+
+const layoutPath = "/data/local/tmp/autoglm_layout.json"
+
+// layoutNode is a component of `uitest dumpLayout`.
+type layoutNode struct {
+	Attributes map[string]string `json:"attributes"`
+	Children   []layoutNode      `json:"children"`
+}
+
+var boundsRe = regexp.MustCompile(`\[(-?\d+),(-?\d+)\]\[(-?\d+),(-?\d+)\]`)
+
+// DumpUI dumps the component tree with uitest and returns the components that
+// carry text, a description or are clickable.
+func (r *HDCDevice) DumpUI(ctx context.Context, deviceID string) ([]definitions.UIElement, error) {
+	output, err := r.Shell(ctx, deviceID, "uitest", "dumpLayout", "-p", layoutPath)
+	if err != nil {
+		logs.Errorf("[DumpUI] uitest dumpLayout failed, err: %v, output: %s", err, output)
+		return nil, fmt.Errorf("uitest dumpLayout failed: %w", err)
+	}
+	output, err = r.Shell(ctx, deviceID, "cat", layoutPath)
+	if err != nil {
+		logs.Errorf("[DumpUI] read layout failed, err: %v", err)
+		return nil, fmt.Errorf("failed to read layout: %w", err)
+	}
+	return parseLayout(output)
+}
+
+func parseLayout(output string) ([]definitions.UIElement, error) {
+	start := strings.Index(output, "{")
+	if start < 0 {
+		return nil, fmt.Errorf("no layout in dump output")
+	}
+	var root layoutNode
+	if err := json.Unmarshal([]byte(output[start:]), &root); err != nil {
+		return nil, fmt.Errorf("failed to parse layout: %w", err)
+	}
+
+	var elements []definitions.UIElement
+	var walk func(node layoutNode)
+	walk = func(node layoutNode) {
+		attrs := node.Attributes
+		clickable := attrs["clickable"] == "true"
+		if attrs["text"] != "" || attrs["description"] != "" || clickable {
+			element := definitions.UIElement{
+				Index:       len(elements),
+				Text:        attrs["text"],
+				ContentDesc: attrs["description"],
+				ResourceID:  attrs["id"],
+				Class:       attrs["type"],
+				Package:     attrs["bundleName"],
+				Clickable:   clickable,
+			}
+			if element.ResourceID == "" {
+				element.ResourceID = attrs["key"]
+			}
+			if m := boundsRe.FindStringSubmatch(attrs["bounds"]); m != nil {
+				for i := 0; i < 4; i++ {
+					element.Bounds[i], _ = strconv.Atoi(m[i+1])
+				}
+			}
+			elements = append(elements, element)
+		}
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	walk(root)
+
+	return elements, nil
+}
@@ -0,0 +1,320 @@
+// Package health watches the connection of the devices: a Monitor lists them
+// and pings those online at every heartbeat, tells offline, unauthorized and
+// unresponsive devices apart, reconnects the network ones (adb tcpip
+// endpoints) and reports every change as an Event, for the scheduler to
+// hold tasks back from a device that is gone and start them when it returns.
+package health
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"autoglm-go/phoneagent/definitions"
+	logs "github.com/sirupsen/logrus"
+)
+
+// State is the connection state of a device.
+type State string
+
+const (
+	StateOnline       State = "online"
+	StateOffline      State = "offline"      // listed, but adb cannot talk to it
+	StateUnauthorized State = "unauthorized" // the USB debugging prompt was not accepted
+	StateUnresponsive State = "unresponsive" // listed online, but the ping failed
+	StateGone         State = "gone"         // not listed anymore
+)
+
+const (
+	pingTimeout = 5 * time.Second
+	// maxReconnectBackoff bounds the time between two reconnects of an
+	// endpoint, which doubles from the heartbeat interval.
+	maxReconnectBackoff = 5 * time.Minute
+)
+
+// Manager is what the monitor needs of the device driver.
+type Manager interface {
+	ListDevices(ctx context.Context) ([]definitions.DeviceInfo, error)
+	Connect(ctx context.Context, address string) (string, error)
+	Disconnect(ctx context.Context, address string) (string, error)
+}
+
+// Pinger is implemented by drivers that check a device answers, beyond it
+// being listed.
+type Pinger interface {
+	Ping(ctx context.Context, deviceID string) error
+}
+
+// Status is the last known state of a device.
+type Status struct {
+	DeviceID   string    `json:"device_id"`
+	State      State     `json:"state"`
+	Since      time.Time `json:"since"`               // of the state
+	LastSeen   time.Time `json:"last_seen,omitempty"` // online
+	Error      string    `json:"error,omitempty"`     // of the ping or the last reconnect
+	Reconnects int       `json:"reconnects,omitempty"`
+}
+
+// Event is a change of the state of a device.
+type Event struct {
+	DeviceID string    `json:"device_id"`
+	State    State     `json:"state"`
+	Previous State     `json:"previous,omitempty"` // empty for a device seen for the first time
+	Time     time.Time `json:"time"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Online reports whether the event is of a device coming online.
+func (e Event) Online() bool {
+	return e.State == StateOnline
+}
+
+// Monitor heartbeats the devices of a driver.
+type Monitor struct {
+	manager Manager
+
+	mu          sync.Mutex
+	status      map[string]*Status
+	endpoints   map[string]bool      // network devices reconnected when lost
+	nextAttempt map[string]time.Time // of the reconnect, by endpoint
+	backoff     map[string]time.Duration
+	subscribers map[chan Event]struct{}
+}
+
+func NewMonitor(manager Manager) *Monitor {
+	return &Monitor{
+		manager:     manager,
+		status:      map[string]*Status{},
+		endpoints:   map[string]bool{},
+		nextAttempt: map[string]time.Time{},
+		backoff:     map[string]time.Duration{},
+		subscribers: map[chan Event]struct{}{},
+	}
+}
+
+// Watch adds network endpoints ("host:port") to reconnect when they are not
+// online, even before they were seen. Network devices seen online are
+// watched already.
+func (r *Monitor) Watch(endpoints ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, endpoint := range endpoints {
+		if isEndpoint(endpoint) {
+			r.endpoints[endpoint] = true
+		}
+	}
+}
+
+// Run heartbeats the devices every interval until ctx ends.
+func (r *Monitor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		r.check(ctx, interval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Subscribe returns a channel of the events from now on, until cancel. Events
+// are dropped for subscribers that do not keep up.
+func (r *Monitor) Subscribe() (<-chan Event, func()) {
+	events := make(chan Event, 64)
+	r.mu.Lock()
+	r.subscribers[events] = struct{}{}
+	r.mu.Unlock()
+	return events, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if _, ok := r.subscribers[events]; ok {
+			delete(r.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// Status returns the state of deviceID, false before it was seen.
+func (r *Monitor) Status(deviceID string) (Status, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status, ok := r.status[deviceID]
+	if !ok {
+		return Status{}, false
+	}
+	return *status, true
+}
+
+// Statuses returns the states of all the devices seen.
+func (r *Monitor) Statuses() []Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	statuses := make([]Status, 0, len(r.status))
+	for _, status := range r.status {
+		statuses = append(statuses, *status)
+	}
+	return statuses
+}
+
+// IsConnected reports whether deviceID was online at the last heartbeat. A
+// nil Monitor knows nothing, ok is false then and for unseen devices.
+func (r *Monitor) IsConnected(deviceID string) (online, ok bool) {
+	if r == nil {
+		return false, false
+	}
+	status, ok := r.Status(deviceID)
+	return status.State == StateOnline, ok
+}
+
+// check lists the devices, pings those online, updates their states and
+// reconnects the endpoints not online.
+func (r *Monitor) check(ctx context.Context, interval time.Duration) {
+	devices, err := r.manager.ListDevices(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			logs.Warnf("🩺 failed to list devices, err: %v", err)
+		}
+		return
+	}
+	pinger, _ := r.manager.(Pinger)
+	seen := map[string]bool{}
+	for _, info := range devices {
+		seen[info.DeviceID] = true
+		state, detail := stateOf(info.Status), ""
+		if state == StateOnline && pinger != nil {
+			pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+			err := pinger.Ping(pingCtx, info.DeviceID)
+			cancel()
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				state, detail = StateUnresponsive, err.Error()
+			}
+		}
+		if state != StateOnline && detail == "" {
+			detail = "adb status " + info.Status
+		}
+		r.update(info.DeviceID, state, detail)
+	}
+
+	r.mu.Lock()
+	var gone []string
+	for id, status := range r.status {
+		if !seen[id] && status.State != StateGone {
+			gone = append(gone, id)
+		}
+	}
+	r.mu.Unlock()
+	for _, id := range gone {
+		r.update(id, StateGone, "")
+	}
+
+	r.reconnect(ctx, interval)
+}
+
+// update sets the state of deviceID, emitting an event when it changed.
+func (r *Monitor) update(deviceID string, state State, detail string) {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status, ok := r.status[deviceID]
+	if !ok {
+		status = &Status{DeviceID: deviceID}
+		r.status[deviceID] = status
+	}
+	if state == StateOnline {
+		status.LastSeen = now
+		status.Error = ""
+		if isEndpoint(deviceID) {
+			r.endpoints[deviceID] = true
+		}
+		delete(r.backoff, deviceID)
+		delete(r.nextAttempt, deviceID)
+	} else if detail != "" {
+		status.Error = detail
+	}
+	if ok && status.State == state {
+		return
+	}
+	event := Event{DeviceID: deviceID, State: state, Previous: status.State, Time: now, Error: detail}
+	status.State, status.Since = state, now
+
+	switch {
+	case state == StateOnline:
+		logs.Infof("📶 device %s is online", deviceID)
+	case state == StateUnauthorized:
+		logs.Warnf("🔒 device %s is unauthorized, accept the USB debugging prompt on it", deviceID)
+	case ok:
+		logs.Warnf("📵 device %s is %s: %s", deviceID, state, detail)
+	}
+	for subscriber := range r.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
+
+// reconnect runs adb connect again for the endpoints not online, backing
+// off from interval for those that stay away. A connection adb still lists
+// as offline is dropped first, adb does not recover it by itself.
+func (r *Monitor) reconnect(ctx context.Context, interval time.Duration) {
+	now := time.Now()
+	r.mu.Lock()
+	var due []string
+	for endpoint := range r.endpoints {
+		status, ok := r.status[endpoint]
+		if ok && status.State == StateOnline || now.Before(r.nextAttempt[endpoint]) {
+			continue
+		}
+		backoff := min(max(2*r.backoff[endpoint], interval), maxReconnectBackoff)
+		r.backoff[endpoint] = backoff
+		r.nextAttempt[endpoint] = now.Add(backoff)
+		if ok {
+			status.Reconnects++
+		}
+		due = append(due, endpoint)
+	}
+	r.mu.Unlock()
+
+	for _, endpoint := range due {
+		if status, _ := r.Status(endpoint); status.State == StateOffline || status.State == StateUnresponsive {
+			_, _ = r.manager.Disconnect(ctx, endpoint)
+		}
+		output, err := r.manager.Connect(ctx, endpoint)
+		if err == nil && !strings.Contains(strings.ToLower(output), "connected") {
+			err = errors.New(strings.TrimSpace(output))
+		}
+		if err != nil {
+			logs.Debugf("🩺 reconnect %s failed, err: %v", endpoint, err)
+			r.mu.Lock()
+			if status, ok := r.status[endpoint]; ok {
+				status.Error = err.Error()
+			}
+			r.mu.Unlock()
+		}
+	}
+}
+
+// stateOf maps the status of `adb devices`.
+func stateOf(status string) State {
+	switch status {
+	case "device":
+		return StateOnline
+	case "unauthorized":
+		return StateUnauthorized
+	default:
+		// offline, connecting, authorizing, recovery, bootloader...
+		return StateOffline
+	}
+}
+
+// isEndpoint reports whether deviceID is a network device adb connects to.
+func isEndpoint(deviceID string) bool {
+	return strings.Contains(deviceID, ":")
+}
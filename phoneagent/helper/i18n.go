@@ -0,0 +1,282 @@
+package helper
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"autoglm-go/constants"
+)
+
+// Bundle holds the messages of a language. A message missing from it is
+// looked up in Fallback, in order, then in the bundle of the language without
+// its region (es for es-MX), then in English and Chinese.
+type Bundle struct {
+	Lang     string            `json:"lang"`
+	Fallback []string          `json:"fallback,omitempty"`
+	Messages map[string]string `json:"messages"`
+}
+
+var (
+	bundlesMu sync.RWMutex
+	bundles   = map[string]*Bundle{
+		"zh": {Lang: "zh", Messages: constants.MESSAGES_ZH_MAP},
+		"en": {Lang: "en", Messages: constants.MESSAGES_EN_MAP},
+		"ja": {Lang: "ja", Messages: constants.MESSAGES_JA_MAP},
+		"ko": {Lang: "ko", Messages: constants.MESSAGES_KO_MAP},
+		"es": {Lang: "es", Messages: constants.MESSAGES_ES_MAP},
+	}
+
+	// outputLang is the language of the messages shown to the operator, see
+	// UseOutputLang.
+	outputLang string
+)
+
+// normalizeLang lower-cases lang with - between its parts, cn and empty are
+// Chinese as for the prompts.
+func normalizeLang(lang string) string {
+	lang = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(lang), "_", "-"))
+	if lang == "" || lang == "cn" {
+		return "zh"
+	}
+	return lang
+}
+
+// GetMessage returns the message key in lang, following the fallbacks of its
+// bundle when it has none.
+func GetMessage(key string, lang string) string {
+	bundlesMu.RLock()
+	defer bundlesMu.RUnlock()
+	for _, l := range fallbackChain(lang) {
+		if b, ok := bundles[l]; ok {
+			if message, ok := b.Messages[key]; ok {
+				return message
+			}
+		}
+	}
+	return ""
+}
+
+// GetOutputMessage returns the message key for the terminal, in the language
+// set by UseOutputLang, lang when there is none. Messages sent to the model
+// use GetMessage in the language of the prompt.
+func GetOutputMessage(key string, lang string) string {
+	bundlesMu.RLock()
+	if outputLang != "" {
+		lang = outputLang
+	}
+	bundlesMu.RUnlock()
+	return GetMessage(key, lang)
+}
+
+// UseOutputLang makes GetOutputMessage answer in lang and returns a function
+// restoring the previous language.
+func UseOutputLang(lang string) func() {
+	bundlesMu.Lock()
+	defer bundlesMu.Unlock()
+	previous := outputLang
+	outputLang = lang
+	return func() {
+		bundlesMu.Lock()
+		defer bundlesMu.Unlock()
+		outputLang = previous
+	}
+}
+
+// HasBundle reports whether lang, or lang without its region, has a bundle.
+func HasBundle(lang string) bool {
+	bundlesMu.RLock()
+	defer bundlesMu.RUnlock()
+	lang = normalizeLang(lang)
+	base, _, _ := strings.Cut(lang, "-")
+	_, ok := bundles[lang]
+	_, baseOK := bundles[base]
+	return ok || baseOK
+}
+
+// fallbackChain is the languages a message of lang is looked up in, in order.
+// bundlesMu must be held.
+func fallbackChain(lang string) []string {
+	var chain []string
+	var visit func(l string)
+	visit = func(l string) {
+		l = normalizeLang(l)
+		if slices.Contains(chain, l) {
+			return
+		}
+		chain = append(chain, l)
+		if b, ok := bundles[l]; ok {
+			for _, fallback := range b.Fallback {
+				visit(fallback)
+			}
+		}
+		if base, _, ok := strings.Cut(l, "-"); ok {
+			visit(base)
+		}
+	}
+	visit(lang)
+	visit("en")
+	visit("zh")
+	return chain
+}
+
+// LoadBundles reads message bundles from JSON or TOML files, or directories
+// of them, each bundle adding to or overriding the messages of its language.
+// A file without lang is named after it, as ja.toml.
+func LoadBundles(paths ...string) error {
+	var files []string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return fmt.Errorf("failed to read message bundles: %w", err)
+		}
+		if !info.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			return fmt.Errorf("failed to read message bundles: %w", err)
+		}
+		for _, e := range entries {
+			if ext := filepath.Ext(e.Name()); !e.IsDir() && (ext == ".json" || ext == ".toml") {
+				files = append(files, filepath.Join(p, e.Name()))
+			}
+		}
+	}
+	for _, file := range files {
+		b, err := readBundle(file)
+		if err != nil {
+			return fmt.Errorf("invalid message bundle %s: %w", file, err)
+		}
+		addBundle(b)
+	}
+	return nil
+}
+
+func readBundle(file string) (*Bundle, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	b := &Bundle{}
+	switch filepath.Ext(file) {
+	case ".json":
+		err = json.Unmarshal(data, b)
+	case ".toml":
+		err = parseTOMLBundle(string(data), b)
+	default:
+		err = fmt.Errorf("want a .json or .toml file")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if b.Lang == "" {
+		b.Lang = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	}
+	return b, nil
+}
+
+// addBundle merges b over the bundle of its language.
+func addBundle(b *Bundle) {
+	bundlesMu.Lock()
+	defer bundlesMu.Unlock()
+	lang := normalizeLang(b.Lang)
+	merged := &Bundle{Lang: lang, Messages: map[string]string{}}
+	if existing, ok := bundles[lang]; ok {
+		merged.Fallback = existing.Fallback
+		for k, v := range existing.Messages {
+			merged.Messages[k] = v
+		}
+	}
+	if len(b.Fallback) > 0 {
+		merged.Fallback = b.Fallback
+	}
+	for k, v := range b.Messages {
+		merged.Messages[k] = v
+	}
+	bundles[lang] = merged
+}
+
+// parseTOMLBundle reads the subset of TOML a bundle needs: lang and fallback
+// at the top, the messages as strings in a [messages] table.
+//
+//	lang = "fr"
+//	fallback = ["es", "en"]
+//
+//	[messages]
+//	thinking = "Réflexion"
+func parseTOMLBundle(text string, b *Bundle) error {
+	table := ""
+	for n, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			table = strings.TrimSpace(strings.Trim(line, "[]"))
+			if table != "messages" {
+				return fmt.Errorf("line %d: unknown table [%s]", n+1, table)
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("line %d: want key = value", n+1)
+		}
+		key = strings.Trim(strings.TrimSpace(key), `"`)
+		value = strings.TrimSpace(value)
+		switch {
+		case table == "messages":
+			s, err := tomlString(value)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", n+1, err)
+			}
+			if b.Messages == nil {
+				b.Messages = map[string]string{}
+			}
+			b.Messages[key] = s
+		case key == "lang":
+			s, err := tomlString(value)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", n+1, err)
+			}
+			b.Lang = s
+		case key == "fallback":
+			if !strings.HasPrefix(value, "[") || !strings.HasSuffix(value, "]") {
+				return fmt.Errorf("line %d: fallback must be an array of strings", n+1)
+			}
+			for _, item := range strings.Split(strings.Trim(value, "[]"), ",") {
+				if item = strings.TrimSpace(item); item == "" {
+					continue
+				}
+				s, err := tomlString(item)
+				if err != nil {
+					return fmt.Errorf("line %d: %w", n+1, err)
+				}
+				b.Fallback = append(b.Fallback, s)
+			}
+		default:
+			return fmt.Errorf("line %d: unknown key %s", n+1, key)
+		}
+	}
+	return nil
+}
+
+// tomlString reads a basic "string", with escapes, or a literal 'string'.
+func tomlString(value string) (string, error) {
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		return value[1 : len(value)-1], nil
+	}
+	if len(value) >= 2 && value[0] == '"' {
+		if s, err := strconv.Unquote(value); err == nil {
+			return s, nil
+		}
+	}
+	return "", fmt.Errorf("invalid string %s", value)
+}
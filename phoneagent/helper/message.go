@@ -2,8 +2,9 @@ package helper
 
 import (
 	"fmt"
+	"slices"
+	"strings"
 
-	"autoglm-go/constants"
 	"autoglm-go/utils"
 	"github.com/sashabaranov/go-openai"
 	logs "github.com/sirupsen/logrus"
@@ -24,6 +25,20 @@ func CreateAssistantMessage(content string) openai.ChatCompletionMessage {
 }
 
 func CreateUserMessage(text string, imageBase64 *string) openai.ChatCompletionMessage {
+	return CreateUserMessageWithImage(text, imageBase64, "image/png")
+}
+
+func CreateUserMessageWithImage(text string, imageBase64 *string, mimeType string) openai.ChatCompletionMessage {
+	var imageURL string
+	if imageBase64 != nil && *imageBase64 != "" {
+		imageURL = fmt.Sprintf("data:%s;base64,%s", mimeType, *imageBase64)
+	}
+	return CreateUserMessageWithImageURL(text, imageURL)
+}
+
+// CreateUserMessageWithImageURL builds a user message with an already
+// formatted image URL (either a data URL or a remote one).
+func CreateUserMessageWithImageURL(text string, imageURL string) openai.ChatCompletionMessage {
 	msg := openai.ChatCompletionMessage{
 		Role: openai.ChatMessageRoleUser,
 		MultiContent: []openai.ChatMessagePart{
@@ -34,11 +49,11 @@ func CreateUserMessage(text string, imageBase64 *string) openai.ChatCompletionMe
 		},
 	}
 	// 如果有图片，加入 MultiContent
-	if imageBase64 != nil && *imageBase64 != "" {
+	if imageURL != "" {
 		msg.MultiContent = append(msg.MultiContent, openai.ChatMessagePart{
 			Type: openai.ChatMessagePartTypeImageURL,
 			ImageURL: &openai.ChatMessageImageURL{
-				URL: fmt.Sprintf("data:image/png;base64,%s", *imageBase64),
+				URL: imageURL,
 			},
 		})
 	}
@@ -71,16 +86,10 @@ func BuildScreenInfo(currentApp string) string {
 	return utils.JsonString(info)
 }
 
-func GetMessage(key string, lang string) string {
-	if lang == "en" {
-		return constants.MESSAGES_EN_MAP[key]
-	}
-	return constants.MESSAGES_ZH_MAP[key]
-}
-
 func RemoveImagesFromMessage(message openai.ChatCompletionMessage) openai.ChatCompletionMessage {
 	var multiContent []openai.ChatMessagePart
 	if message.MultiContent != nil {
+		// a new slice, the parts may be shared with copies of the history
 		for _, part := range message.MultiContent {
 			if part.Type == openai.ChatMessagePartTypeText {
 				multiContent = append(multiContent, part)
@@ -90,3 +99,83 @@ func RemoveImagesFromMessage(message openai.ChatCompletionMessage) openai.ChatCo
 	}
 	return message
 }
+
+// RemoveThinkingFromMessage drops the <think> part of an assistant message and
+// returns it separately. The thinking is empty if there was none.
+func RemoveThinkingFromMessage(message openai.ChatCompletionMessage) (openai.ChatCompletionMessage, string) {
+	start := strings.Index(message.Content, "<think>")
+	end := strings.Index(message.Content, "</think>")
+	if start < 0 || end < start+len("<think>") {
+		return message, ""
+	}
+
+	thinking := message.Content[start+len("<think>") : end]
+	message.Content = message.Content[:start] + "<think></think>" + message.Content[end+len("</think>"):]
+	return message, thinking
+}
+
+// MessageText returns the text content of a message, without images.
+func MessageText(message openai.ChatCompletionMessage) string {
+	if message.MultiContent == nil {
+		return message.Content
+	}
+
+	texts := make([]string, 0, len(message.MultiContent))
+	for _, part := range message.MultiContent {
+		if part.Type == openai.ChatMessagePartTypeText {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// CloneMessages returns a deep copy of messages, which may be changed
+// without changing them.
+func CloneMessages(messages []openai.ChatCompletionMessage) []openai.ChatCompletionMessage {
+	cloned := slices.Clone(messages)
+	for i := range cloned {
+		msg := &cloned[i]
+		msg.MultiContent = slices.Clone(msg.MultiContent)
+		for j, part := range msg.MultiContent {
+			if part.ImageURL != nil {
+				image := *part.ImageURL
+				msg.MultiContent[j].ImageURL = &image
+			}
+		}
+		msg.ToolCalls = slices.Clone(msg.ToolCalls)
+		if msg.FunctionCall != nil {
+			call := *msg.FunctionCall
+			msg.FunctionCall = &call
+		}
+	}
+	return cloned
+}
+
+// imageTokens is about what a screenshot costs, providers scale images to
+// around a megapixel.
+const imageTokens = 1000
+
+// EstimateTokens guesses the prompt tokens of messages without a tokenizer:
+// a token per 4 ASCII characters, per other character (CJK mostly), and
+// imageTokens per image.
+func EstimateTokens(messages []openai.ChatCompletionMessage) int {
+	tokens := 0
+	for _, message := range messages {
+		tokens += 4 // role and separators
+		ascii := 0
+		for _, c := range MessageText(message) {
+			if c < 0x80 {
+				ascii++
+			} else {
+				tokens++
+			}
+		}
+		tokens += (ascii + 3) / 4
+		for _, part := range message.MultiContent {
+			if part.Type == openai.ChatMessagePartTypeImageURL {
+				tokens += imageTokens
+			}
+		}
+	}
+	return tokens
+}
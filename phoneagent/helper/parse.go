@@ -3,9 +3,10 @@ package helper
 import (
 	"errors"
 	"fmt"
-	"regexp"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	logs "github.com/sirupsen/logrus"
 )
@@ -19,6 +20,41 @@ type ActionResult struct {
 	RequiresConfirmation bool
 }
 
+// ErrActionParse is wrapped by the *ParseError of answers that are not a
+// valid do() or finish() call.
+var ErrActionParse = errors.New("failed to parse action")
+
+// ParseError is an answer ParseAction could not read. Fragment is the part of
+// it where parsing stopped, to quote back to the model.
+type ParseError struct {
+	Fragment string
+	Err      error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%v: %v, near %q", ErrActionParse, e.Err, e.Fragment)
+}
+
+func (e *ParseError) Unwrap() []error {
+	return []error{ErrActionParse, e.Err}
+}
+
+// fragmentAt returns up to 40 runes of s from the byte pos.
+func fragmentAt(s string, pos int) string {
+	pos = min(max(pos, 0), len(s))
+	for pos < len(s) && !utf8.RuneStart(s[pos]) {
+		pos++
+	}
+	s = s[pos:]
+	if runes := []rune(s); len(runes) > 40 {
+		return string(runes[:40]) + "..."
+	}
+	return s
+}
+
+// ParseAction parses a do() or finish() call. do() actions are checked
+// against their schema, see ValidateAction; the errors are *ActionError.
+// Answers that cannot be read fail with a *ParseError.
 func ParseAction(rawActionStr string) (Action, error) {
 	logs.Debugf("begin to parse action: %s", rawActionStr)
 
@@ -29,7 +65,11 @@ func ParseAction(rawActionStr string) (Action, error) {
 		action, err := parseDoCall(rawActionStr)
 		if err != nil {
 			logs.Errorf("failed to parse do() action, rawActionStr: %s, err: %v", rawActionStr, err)
-			return nil, fmt.Errorf("failed to parse do() action: %w", err)
+			return nil, err
+		}
+		if err := ValidateAction(action); err != nil {
+			logs.Errorf("invalid do() action, rawActionStr: %s, err: %v", rawActionStr, err)
+			return nil, err
 		}
 		return action, nil
 	}
@@ -38,7 +78,7 @@ func ParseAction(rawActionStr string) (Action, error) {
 	if strings.HasPrefix(rawActionStr, "finish") {
 		msg, err := parseFinishMessage(rawActionStr)
 		if err != nil {
-			return nil, err
+			return nil, &ParseError{Fragment: fragmentAt(rawActionStr, 0), Err: err}
 		}
 
 		return Action{
@@ -46,100 +86,397 @@ func ParseAction(rawActionStr string) (Action, error) {
 			"message":   msg,
 		}, nil
 	}
-	return nil, fmt.Errorf("failed to parse action: %s", rawActionStr)
+	return nil, &ParseError{Fragment: fragmentAt(rawActionStr, 0), Err: errors.New("not a do() or finish() call")}
 }
 
-func parseDoCall(expr string) (Action, error) {
+// parseDoCall parses a do() call, its errors are *ParseError.
+func parseDoCall(expr string) (_ Action, err error) {
 	// 去掉 do( 和 )
 	if !strings.HasPrefix(expr, "do(") || !strings.HasSuffix(expr, ")") {
-		return nil, errors.New("invalid do() syntax")
+		return nil, &ParseError{Fragment: fragmentAt(expr, len(expr)-40), Err: errors.New("invalid do() syntax")}
 	}
 
-	body := strings.TrimSuffix(strings.TrimPrefix(expr, "do("), ")")
-
+	// positions in errors are those of expr
+	p := &literalParser{s: strings.TrimSuffix(expr, ")"), pos: len("do(")}
+	defer func() {
+		if err != nil {
+			err = &ParseError{Fragment: fragmentAt(expr, p.pos), Err: err}
+		}
+	}()
 	action := Action{
 		"_metadata": "do",
 	}
 
-	if strings.TrimSpace(body) == "" {
-		return action, nil
-	}
-
-	parts := strings.Split(body, ", ")
-
-	for _, part := range parts {
-		kv := strings.SplitN(part, "=", 2)
-		if len(kv) != 2 {
-			return nil, fmt.Errorf("invalid argument: %s", part)
+	for {
+		p.skipSpace()
+		if p.done() {
+			return action, nil
 		}
-
-		key := strings.TrimSpace(kv[0])
-		valStr := strings.TrimSpace(kv[1])
-
-		val, err := parseLiteral(valStr)
+		key := p.ident()
+		if key == "" {
+			return nil, fmt.Errorf("invalid argument at %d: %s", p.pos, p.rest())
+		}
+		p.skipSpace()
+		if !p.consume('=') {
+			return nil, fmt.Errorf("expected = after %s at %d", key, p.pos)
+		}
+		val, err := p.value()
 		if err != nil {
 			return nil, fmt.Errorf("invalid value for %s: %w", key, err)
 		}
-
 		action[key] = val
+
+		p.skipSpace()
+		if !p.done() && !p.consume(',') {
+			return nil, fmt.Errorf("expected , after %s at %d: %s", key, p.pos, p.rest())
+		}
 	}
-	return action, nil
 }
 
-var messageRe = regexp.MustCompile(`message="((?:\\.|[^"])*)"`)
+// unescaper undoes the escapes literalParser understands, for messages that
+// cannot be read as one string literal.
+var unescaper = strings.NewReplacer(`\\`, `\`, `\"`, `"`, `\'`, `'`, `\n`, "\n", `\t`, "\t")
 
+// parseFinishMessage reads the message of finish(message=...), in single,
+// double or triple quotes, over several lines and with escapes. Quotes the
+// model did not escape are kept: the message then runs to the last quote
+// before the closing parenthesis.
 func parseFinishMessage(s string) (string, error) {
-	matches := messageRe.FindStringSubmatch(s)
-	if len(matches) < 2 {
+	i := strings.Index(s, "message=")
+	if i < 0 {
 		return "", errors.New("message not found")
 	}
-	return matches[1], nil
+	p := &literalParser{s: s[i+len("message="):]}
+	p.skipSpace()
+	if p.done() || (p.s[p.pos] != '"' && p.s[p.pos] != '\'') {
+		return "", errors.New("message is not a string")
+	}
+	start, quote := p.pos, p.s[p.pos]
+
+	message, err := p.quoted()
+	if err == nil {
+		p.skipSpace()
+		if p.done() || p.s[p.pos] == ')' || p.s[p.pos] == ',' {
+			return message, nil
+		}
+	}
+
+	quotes := string(quote)
+	if strings.HasPrefix(p.s[start:], strings.Repeat(quotes, 3)) {
+		quotes = strings.Repeat(quotes, 3)
+	}
+	rest := p.s[start+len(quotes):]
+	end := strings.LastIndex(rest, quotes+")")
+	if end < 0 {
+		end = strings.LastIndex(rest, quotes)
+	}
+	if end < 0 {
+		return "", errors.New("unterminated message")
+	}
+	return unescaper.Replace(rest[:end]), nil
+}
+
+// literalParser reads the Python-like literals of do() arguments: quoted
+// strings with escapes, [lists] and (tuples) nested at will, {dicts},
+// signed ints and floats, booleans and None. Commas and brackets inside
+// strings are part of the string.
+type literalParser struct {
+	s   string
+	pos int
 }
 
-func parseLiteral(s string) (any, error) {
-	logs.Debugf("begin to parse literal: %s", s)
-	// string
-	if strings.HasPrefix(s, `"`) && strings.HasSuffix(s, `"`) {
-		return s[1 : len(s)-1], nil
+func (p *literalParser) done() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *literalParser) rest() string {
+	return p.s[p.pos:]
+}
+
+func (p *literalParser) skipSpace() {
+	for !p.done() && strings.IndexByte(" \t\r\n", p.s[p.pos]) >= 0 {
+		p.pos++
 	}
+}
 
-	// bool
-	if s == "true" {
-		return true, nil
+func (p *literalParser) consume(c byte) bool {
+	if !p.done() && p.s[p.pos] == c {
+		p.pos++
+		return true
 	}
-	if s == "false" {
-		return false, nil
+	return false
+}
+
+func (p *literalParser) ident() string {
+	start := p.pos
+	for !p.done() {
+		c := p.s[p.pos]
+		if c != '_' && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			break
+		}
+		p.pos++
 	}
+	return p.s[start:p.pos]
+}
+
+func (p *literalParser) value() (any, error) {
+	p.skipSpace()
+	if p.done() {
+		return nil, fmt.Errorf("missing value at %d", p.pos)
+	}
+	switch p.s[p.pos] {
+	case '"', '\'':
+		return p.quoted()
+	case '[':
+		return p.list(']')
+	case '(':
+		return p.list(')')
+	case '{':
+		return p.dict()
+	default:
+		return p.scalar()
+	}
+}
 
-	// int[]
-	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
-		content := strings.TrimSpace(s[1 : len(s)-1])
-		if content == "" {
-			return []int{}, nil
+// quoted reads a string, in triple quotes too, unescaping \n, \t and
+// escaped quotes or backslashes. Other backslashes are kept as they are.
+func (p *literalParser) quoted() (string, error) {
+	start, quote := p.pos, p.s[p.pos]
+	triple := strings.HasPrefix(p.rest(), strings.Repeat(string(quote), 3))
+	if triple {
+		p.pos += 3
+	} else {
+		p.pos++
+	}
+	var sb strings.Builder
+	for !p.done() {
+		c := p.s[p.pos]
+		p.pos++
+		switch {
+		case c == quote && !triple:
+			return sb.String(), nil
+		case c == quote && strings.HasPrefix(p.rest(), strings.Repeat(string(quote), 2)):
+			p.pos += 2
+			return sb.String(), nil
+		case c == '\\' && !p.done():
+			next := p.s[p.pos]
+			p.pos++
+			switch next {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case '"', '\'', '\\':
+				sb.WriteByte(next)
+			default:
+				sb.WriteByte(c)
+				sb.WriteByte(next)
+			}
+		default:
+			sb.WriteByte(c)
 		}
+	}
+	return "", fmt.Errorf("unterminated string at %d", start)
+}
 
-		parts := strings.Split(content, ",")
-		result := make([]int, 0, len(parts))
+// list reads [a, b, ...], or a tuple up to closing. A list of ints is an
+// []int, like coordinates.
+func (p *literalParser) list(closing byte) (any, error) {
+	start := p.pos
+	p.pos++ // [ or (
+	var items []any
+	for {
+		p.skipSpace()
+		if p.done() {
+			return nil, fmt.Errorf("unterminated list at %d", start)
+		}
+		if p.consume(closing) {
+			break
+		}
+		item, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		p.skipSpace()
+		if p.consume(closing) {
+			break
+		}
+		if p.done() {
+			return nil, fmt.Errorf("unterminated list at %d", start)
+		}
+		if !p.consume(',') {
+			return nil, fmt.Errorf("expected , or %c at %d", closing, p.pos)
+		}
+	}
 
-		for _, p := range parts {
-			v, err := strconv.Atoi(strings.TrimSpace(p))
+	ints := make([]int, 0, len(items))
+	for _, item := range items {
+		n, ok := item.(int)
+		if !ok {
+			return items, nil
+		}
+		ints = append(ints, n)
+	}
+	return ints, nil
+}
+
+// dict reads {key: value, ...}, keys quoted or bare.
+func (p *literalParser) dict() (any, error) {
+	start := p.pos
+	p.pos++ // {
+	result := map[string]any{}
+	for {
+		p.skipSpace()
+		if p.done() {
+			return nil, fmt.Errorf("unterminated dict at %d", start)
+		}
+		if p.consume('}') {
+			return result, nil
+		}
+		var key string
+		if !p.done() && (p.s[p.pos] == '"' || p.s[p.pos] == '\'') {
+			quoted, err := p.quoted()
 			if err != nil {
-				return nil, fmt.Errorf("invalid int in array: %s", p)
+				return nil, err
 			}
-			result = append(result, v)
+			key = quoted
+		} else if key = p.ident(); key == "" {
+			return nil, fmt.Errorf("invalid dict key at %d", p.pos)
+		}
+		p.skipSpace()
+		if !p.consume(':') {
+			return nil, fmt.Errorf("expected : after dict key %s at %d", key, p.pos)
+		}
+		val, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		result[key] = val
+		p.skipSpace()
+		if p.consume('}') {
+			return result, nil
+		}
+		if !p.consume(',') {
+			return nil, fmt.Errorf("expected , or } at %d", p.pos)
 		}
-		return result, nil
 	}
+}
 
-	// int
-	if i, err := strconv.Atoi(strings.TrimSpace(s)); err == nil {
-		return i, nil
+// scalar reads a bare literal up to the next separator.
+func (p *literalParser) scalar() (any, error) {
+	start := p.pos
+	for !p.done() && strings.IndexByte(",]})", p.s[p.pos]) < 0 {
+		p.pos++
+	}
+	s := strings.TrimSpace(p.s[start:p.pos])
+	// a sign the model spaced from its number, e.g. - 12
+	if len(s) > 1 && (s[0] == '-' || s[0] == '+') {
+		s = s[:1] + strings.TrimSpace(s[1:])
 	}
 
-	// float
-	if f, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+	switch s {
+	case "true", "True":
+		return true, nil
+	case "false", "False":
+		return false, nil
+	case "None", "null":
+		return nil, nil
+	}
+	if i, err := strconv.Atoi(s); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
 		return f, nil
 	}
+	if s == "" {
+		return nil, fmt.Errorf("missing value at %d", start)
+	}
+	return nil, fmt.Errorf("unsupported literal %s at %d", s, start)
+}
+
+// ActionEnd returns the length of the complete call at the start of s, such
+// as `do(action="Tap", element=[500, 100])`, or -1 while its closing
+// parenthesis has not arrived yet. Brackets inside quoted strings are ignored.
+func ActionEnd(s string) int {
+	start := strings.IndexByte(s, '(')
+	if start < 0 {
+		return -1
+	}
 
-	return nil, fmt.Errorf("unsupported literal: %s", s)
+	var (
+		depth   int
+		quote   byte
+		escaped bool
+	)
+	for i := start; i < len(s); i++ {
+		c := s[i]
+		if quote != 0 {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == quote:
+				quote = 0
+			}
+			continue
+		}
+
+		switch c {
+		case '"', '\'':
+			quote = c
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+// Seconds reads a number of seconds from an action argument: a number, or a
+// string such as "3" or "3 seconds".
+func Seconds(value any) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	case string:
+		v = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(v), "seconds"))
+		seconds, err := strconv.ParseFloat(v, 64)
+		return seconds, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// WaitSeconds is how long a Wait action waits, from seconds=2.5 or the older
+// duration="2 seconds". 1 when neither is usable.
+func WaitSeconds(action Action) float64 {
+	for _, key := range []string{"seconds", "duration"} {
+		if seconds, ok := Seconds(action[key]); ok && seconds >= 0 {
+			return seconds
+		}
+	}
+	return 1
+}
+
+// Wait_Until timeouts, for a missing or too long timeout argument.
+const (
+	waitUntilDefault = 10 * time.Second
+	waitUntilMax     = time.Minute
+)
+
+// WaitUntilTimeout is how long a Wait_Until action waits at most, from its
+// timeout in seconds.
+func WaitUntilTimeout(action Action) time.Duration {
+	if seconds, ok := Seconds(action["timeout"]); ok && seconds > 0 {
+		return min(time.Duration(seconds*float64(time.Second)), waitUntilMax)
+	}
+	return waitUntilDefault
 }
@@ -0,0 +1,114 @@
+package helper
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseDoCall(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		want Action
+	}{
+		{
+			name: "comma in a string",
+			expr: `do(action="Type", text="苹果, 香蕉")`,
+			want: Action{"_metadata": "do", "action": "Type", "text": "苹果, 香蕉"},
+		},
+		{
+			name: "coordinates",
+			expr: `do(action="Tap", element=[500, 100])`,
+			want: Action{"_metadata": "do", "action": "Tap", "element": []int{500, 100}},
+		},
+		{
+			name: "nested lists",
+			expr: `do(action="Swipe", points=[[1,2],[3,4]])`,
+			want: Action{"_metadata": "do", "action": "Swipe", "points": []any{[]int{1, 2}, []int{3, 4}}},
+		},
+		{
+			name: "dict with a list",
+			expr: `do(action="Call_API", args={"a": [1, "b,c"]})`,
+			want: Action{"_metadata": "do", "action": "Call_API", "args": map[string]any{"a": []any{1, "b,c"}}},
+		},
+		{
+			name: "escaped double quote",
+			expr: `do(action="Type", text="say \"hi\", then go")`,
+			want: Action{"_metadata": "do", "action": "Type", "text": `say "hi", then go`},
+		},
+		{
+			name: "escaped single quote",
+			expr: `do(action='Type', text='it\'s (here)')`,
+			want: Action{"_metadata": "do", "action": "Type", "text": "it's (here)"},
+		},
+		{
+			name: "scalars",
+			expr: `do(action="Wait", seconds=2.5, strict=True, target=None, offset=- 12)`,
+			want: Action{"_metadata": "do", "action": "Wait", "seconds": 2.5, "strict": true, "target": nil, "offset": -12},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDoCall(tt.expr)
+			if err != nil {
+				t.Fatalf("parseDoCall(%q) failed: %v", tt.expr, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDoCall(%q) = %#v, want %#v", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFinishMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{name: "double quotes", raw: `finish(message="done, 3 items")`, want: "done, 3 items"},
+		{name: "single quotes", raw: `finish(message='done')`, want: "done"},
+		{name: "escaped quotes", raw: `finish(message="he said \"ok\" and \'fine\'")`, want: `he said "ok" and 'fine'`},
+		{name: "triple quotes", raw: "finish(message=\"\"\"line 1\nline \"2\" here\"\"\")", want: "line 1\nline \"2\" here"},
+		{name: "triple quotes ending in a quote", raw: "finish(message=\"\"\"line \"2\"\"\"\")", want: "line \"2\""},
+		{name: "unescaped quotes", raw: `finish(message="the "best" one")`, want: `the "best" one`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action, err := ParseAction(tt.raw)
+			if err != nil {
+				t.Fatalf("ParseAction(%q) failed: %v", tt.raw, err)
+			}
+			if got := action["message"]; got != tt.want {
+				t.Errorf("ParseAction(%q) message = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseActionMalformed(t *testing.T) {
+	for _, raw := range []string{
+		`do(action="Tap", element=[500, 100)`,
+		`do(action="Tap", element=[[1, 2], [3, 4)`,
+		`do(action="Call_API", args={"a": [1, 2})`,
+		`do(action="Call_API", args={"a" 1})`,
+		`do(action="Type", text="unterminated)`,
+		`do(action="Type", text=)`,
+		`do(action="Tap" element=[1, 2])`,
+		`do(action="Tap", =1)`,
+		`do(action="Tap", element=[1, 2]`,
+		`finish(message=)`,
+		`finish(message="unterminated)`,
+		`tap(1, 2)`,
+		``,
+	} {
+		t.Run(raw, func(t *testing.T) {
+			_, err := ParseAction(raw)
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Errorf("ParseAction(%q) = %v, want a *ParseError", raw, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package helper
+
+import (
+	"sync"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// messageSlicePool recycles conversation slices between tasks so long
+// sessions don't keep growing fresh backing arrays.
+var messageSlicePool = sync.Pool{
+	New: func() any {
+		s := make([]openai.ChatCompletionMessage, 0, 64)
+		return &s
+	},
+}
+
+func GetMessageSlice() []openai.ChatCompletionMessage {
+	return (*messageSlicePool.Get().(*[]openai.ChatCompletionMessage))[:0]
+}
+
+// PutMessageSlice returns s to the pool. s must not be used afterwards, nor
+// be held by anyone else: messages leaving their owner are copied, see
+// CloneMessages, and RemoveImagesFromMessage never writes to the parts it
+// was given. The messages are cleared so the pool keeps none of their parts.
+func PutMessageSlice(s []openai.ChatCompletionMessage) {
+	if cap(s) == 0 {
+		return
+	}
+	clear(s[:cap(s)])
+	s = s[:0]
+	messageSlicePool.Put(&s)
+}
@@ -0,0 +1,324 @@
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ParamType is the type of an action parameter.
+type ParamType string
+
+const (
+	ParamString  ParamType = "string"
+	ParamNumber  ParamType = "number"
+	ParamBool    ParamType = "bool"
+	ParamPoint   ParamType = "point"   // [x,y] on the 0-999 grid, or the label to find there, see PointLabel
+	ParamPath    ParamType = "path"    // two or more [x,y] points, [[x1,y1], [x2,y2], ...]
+	ParamSeconds ParamType = "seconds" // a number or a string like "3 seconds"
+	ParamAny     ParamType = "any"
+)
+
+// ParamSpec declares one parameter of an action.
+type ParamSpec struct {
+	Name     string
+	Type     ParamType
+	Required bool
+}
+
+// ActionSchema declares the parameters of a do() action. Parameters that are
+// not declared are kept as they are.
+type ActionSchema struct {
+	Name   string
+	Params []ParamSpec
+}
+
+// Errors of invalid actions, wrapped in an *ActionError.
+var (
+	ErrUnknownAction = errors.New("unknown action")
+	ErrMissingParam  = errors.New("missing parameter")
+	ErrInvalidParam  = errors.New("invalid parameter")
+)
+
+// ActionError describes what is wrong with an action, in words the model can
+// act on.
+type ActionError struct {
+	Action string
+	Param  string // empty for ErrUnknownAction
+	Err    error
+	Detail string
+}
+
+func (e *ActionError) Error() string {
+	msg := fmt.Sprintf("%s: %v", e.Action, e.Err)
+	if e.Param != "" {
+		msg += " " + e.Param
+	}
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	return msg
+}
+
+func (e *ActionError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	actionSchemasMu sync.RWMutex
+	actionSchemas   = map[string]ActionSchema{}
+)
+
+func init() {
+	point := func(name string) ParamSpec { return ParamSpec{Name: name, Type: ParamPoint, Required: true} }
+	message := ParamSpec{Name: "message", Type: ParamString}
+	for _, schema := range []ActionSchema{
+		{Name: "Launch", Params: []ParamSpec{
+			{Name: "app", Type: ParamString},
+			{Name: "package", Type: ParamString},
+			{Name: "activity", Type: ParamString},
+			{Name: "intent", Type: ParamString},
+			{Name: "uri", Type: ParamString},
+		}},
+		{Name: "Force_Stop", Params: []ParamSpec{{Name: "app", Type: ParamString}, {Name: "package", Type: ParamString}}},
+		{Name: "Clear_Data", Params: []ParamSpec{{Name: "app", Type: ParamString}, {Name: "package", Type: ParamString}}},
+		{Name: "Install", Params: []ParamSpec{
+			{Name: "apk", Type: ParamString, Required: true},
+			{Name: "grant_permissions", Type: ParamBool},
+		}},
+		{Name: "Tap", Params: []ParamSpec{point("element"), message}},
+		{Name: "Type", Params: []ParamSpec{{Name: "text", Type: ParamString, Required: true}}},
+		{Name: "Type_Name", Params: []ParamSpec{{Name: "text", Type: ParamString, Required: true}}},
+		{Name: "Interact"},
+		{Name: "Swipe", Params: []ParamSpec{point("start"), point("end")}},
+		{Name: "Note", Params: []ParamSpec{{Name: "message", Type: ParamAny}}},
+		{Name: "Call_API", Params: []ParamSpec{{Name: "instruction", Type: ParamString}}},
+		{Name: "Long Press", Params: []ParamSpec{point("element"), {Name: "duration", Type: ParamSeconds}}},
+		{Name: "Drag", Params: []ParamSpec{
+			{Name: "path", Type: ParamPath, Required: true},
+			{Name: "duration", Type: ParamSeconds},
+			{Name: "hold", Type: ParamSeconds},
+		}},
+		{Name: "Pinch", Params: []ParamSpec{
+			point("element"),
+			{Name: "scale", Type: ParamNumber, Required: true},
+			{Name: "duration", Type: ParamSeconds},
+		}},
+		{Name: "Double Tap", Params: []ParamSpec{point("element")}},
+		{Name: "Take_over", Params: []ParamSpec{message}},
+		{Name: "Back"},
+		{Name: "Home"},
+		{Name: "Wait", Params: []ParamSpec{{Name: "seconds", Type: ParamSeconds}, {Name: "duration", Type: ParamSeconds}}},
+		{Name: "Wait_Until", Params: []ParamSpec{
+			{Name: "text_appears", Type: ParamString, Required: true},
+			{Name: "timeout", Type: ParamSeconds},
+		}},
+		{Name: "Dismiss_Overlay", Params: []ParamSpec{{Name: "index", Type: ParamNumber}}},
+		{Name: "Open_Notification", Params: []ParamSpec{{Name: "index", Type: ParamNumber}}},
+		{Name: "Dismiss_Notification", Params: []ParamSpec{{Name: "index", Type: ParamNumber}}},
+	} {
+		actionSchemas[schema.Name] = schema
+	}
+}
+
+// RegisterActionSchema declares an action for ParseAction. Names must not
+// clash with registered actions.
+func RegisterActionSchema(schema ActionSchema) error {
+	if schema.Name == "" {
+		return fmt.Errorf("action name is required")
+	}
+	actionSchemasMu.Lock()
+	defer actionSchemasMu.Unlock()
+	if _, ok := actionSchemas[schema.Name]; ok {
+		return fmt.Errorf("action %s already registered", schema.Name)
+	}
+	actionSchemas[schema.Name] = schema
+	return nil
+}
+
+// LookupActionSchema returns the schema of a registered action.
+func LookupActionSchema(name string) (ActionSchema, bool) {
+	actionSchemasMu.RLock()
+	defer actionSchemasMu.RUnlock()
+	schema, ok := actionSchemas[name]
+	return schema, ok
+}
+
+// ValidateAction checks a do() action against the schema of its name and
+// normalizes the declared parameters in place: numbers given as strings,
+// strings given as numbers and points just outside the grid. Points far off
+// it are kept for grounding, see OffGrid.
+func ValidateAction(action Action) error {
+	name, ok := action["action"].(string)
+	if !ok {
+		return &ActionError{Action: "do", Param: "action", Err: ErrMissingParam}
+	}
+	schema, ok := LookupActionSchema(name)
+	if !ok {
+		return &ActionError{Action: name, Err: ErrUnknownAction}
+	}
+
+	for _, spec := range schema.Params {
+		value, ok := action[spec.Name]
+		if !ok || value == nil {
+			if spec.Required {
+				return &ActionError{Action: name, Param: spec.Name, Err: ErrMissingParam}
+			}
+			continue
+		}
+		normalized, err := normalizeParam(spec.Type, value)
+		if err != nil {
+			return &ActionError{Action: name, Param: spec.Name, Err: ErrInvalidParam, Detail: err.Error()}
+		}
+		action[spec.Name] = normalized
+	}
+	return nil
+}
+
+func normalizeParam(kind ParamType, value any) (any, error) {
+	switch kind {
+	case ParamString:
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case int, float64, bool:
+			return fmt.Sprint(v), nil
+		}
+		return nil, fmt.Errorf("want a string, got %v", value)
+	case ParamNumber:
+		switch v := value.(type) {
+		case int, float64:
+			return v, nil
+		case string:
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				return f, nil
+			}
+		}
+		return nil, fmt.Errorf("want a number, got %v", value)
+	case ParamSeconds:
+		if _, ok := Seconds(value); ok {
+			return value, nil
+		}
+		return nil, fmt.Errorf("want seconds, got %v", value)
+	case ParamBool:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			if b, err := strconv.ParseBool(strings.ToLower(strings.TrimSpace(v))); err == nil {
+				return b, nil
+			}
+		}
+		return nil, fmt.Errorf("want true or false, got %v", value)
+	case ParamPoint:
+		if label, ok := PointLabel(value); ok {
+			return label, nil
+		}
+		var point []int
+		switch v := value.(type) {
+		case []int:
+			point = v
+		case []any:
+			// fractions of the screen, e.g. [0.23, 0.78], are scaled to the grid
+			scale := 1.0
+			if fractionPoint(v) {
+				scale = 1000
+			}
+			for _, item := range v {
+				switch n := item.(type) {
+				case int:
+					point = append(point, n)
+				case float64:
+					point = append(point, int(math.Round(n*scale)))
+				default:
+					return nil, fmt.Errorf("want [x,y], got %v", value)
+				}
+			}
+		case map[string]any:
+			// {"x": 500, "y": 100}
+			return normalizeParam(kind, []any{v["x"], v["y"]})
+		}
+		if len(point) != 2 {
+			return nil, fmt.Errorf("want [x,y], got %v", value)
+		}
+		if OffGrid(point) {
+			return point, nil
+		}
+		return ClampPoint(point), nil
+	case ParamPath:
+		items, ok := value.([]any)
+		if !ok || len(items) < 2 {
+			return nil, fmt.Errorf("want [[x1,y1], [x2,y2], ...], got %v", value)
+		}
+		path := make([][]int, 0, len(items))
+		for _, item := range items {
+			point, err := normalizeParam(ParamPoint, item)
+			if err != nil {
+				return nil, err
+			}
+			p, ok := point.([]int)
+			if !ok {
+				return nil, fmt.Errorf("want [x,y] points, got %v", item)
+			}
+			path = append(path, p)
+		}
+		return path, nil
+	default:
+		return value, nil
+	}
+}
+
+// fractionPoint tells whether the items of a point are all between 0 and 1,
+// one of them with a fraction.
+func fractionPoint(items []any) bool {
+	fraction := false
+	for _, item := range items {
+		switch n := item.(type) {
+		case int:
+			if n != 0 && n != 1 {
+				return false
+			}
+		case float64:
+			if n < 0 || n > 1 {
+				return false
+			}
+			fraction = fraction || n != math.Trunc(n)
+		default:
+			return false
+		}
+	}
+	return fraction
+}
+
+// offGridMargin is how far off the grid a point may be and still be meant
+// for its edge.
+const offGridMargin = 50
+
+// OffGrid tells whether point is obviously off the screen, e.g. given in
+// pixels.
+func OffGrid(point []int) bool {
+	return slices.ContainsFunc(point, func(v int) bool { return v < -offGridMargin || v > 999+offGridMargin })
+}
+
+// ClampPoint moves point onto the grid.
+func ClampPoint(point []int) []int {
+	return []int{min(max(point[0], 0), 999), min(max(point[1], 0), 999)}
+}
+
+// PointLabel returns the text the model gave instead of the coordinates of a
+// point, e.g. element="搜索", for the agent to find on the screen.
+func PointLabel(value any) (string, bool) {
+	if items, ok := value.([]any); ok && len(items) == 1 {
+		value = items[0]
+	}
+	label, ok := value.(string)
+	label = strings.TrimSpace(label)
+	if !ok || label == "" || strings.ContainsAny(label[:1], "[(0123456789-") {
+		return "", false
+	}
+	return label, true
+}
@@ -0,0 +1,105 @@
+package helper
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Tool names of the actions in tool-calling mode.
+const (
+	ToolDo     = "do"
+	ToolFinish = "finish"
+)
+
+// ActionFromToolCall converts a do or finish tool call to the action the
+// text syntax of the same call parses to.
+func ActionFromToolCall(name, arguments string) (Action, error) {
+	args := map[string]any{}
+	if strings.TrimSpace(arguments) != "" {
+		if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+			return nil, fmt.Errorf("invalid arguments of tool %s: %w", name, err)
+		}
+	}
+
+	switch name {
+	case ToolFinish:
+		message, _ := args["message"].(string)
+		return Action{"_metadata": "finish", "message": message}, nil
+	case ToolDo:
+		action := Action{"_metadata": "do"}
+		for key, value := range args {
+			action[key] = toolValue(value)
+		}
+		if err := ValidateAction(action); err != nil {
+			return nil, err
+		}
+		return action, nil
+	default:
+		return nil, fmt.Errorf("unknown tool: %s", name)
+	}
+}
+
+// toolValue turns JSON numbers into the ints of the text syntax.
+func toolValue(value any) any {
+	switch v := value.(type) {
+	case float64:
+		if v == math.Trunc(v) {
+			return int(v)
+		}
+		return v
+	case []any:
+		ints := make([]int, 0, len(v))
+		for _, item := range v {
+			n, ok := item.(float64)
+			if !ok {
+				return v
+			}
+			ints = append(ints, int(n))
+		}
+		return ints
+	default:
+		return v
+	}
+}
+
+// FormatAction writes an action in the text syntax, the action name first,
+// e.g. do(action="Tap", element=[500, 100]).
+func FormatAction(action Action) string {
+	if action["_metadata"] == "finish" {
+		return "finish(message=" + formatLiteral(fmt.Sprint(action["message"])) + ")"
+	}
+
+	keys := make([]string, 0, len(action))
+	for key := range action {
+		if key != "_metadata" && key != "action" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	parts := []string{fmt.Sprintf(`action="%v"`, action["action"])}
+	for _, key := range keys {
+		parts = append(parts, key+"="+formatLiteral(action[key]))
+	}
+	return "do(" + strings.Join(parts, ", ") + ")"
+}
+
+// quoteEscaper escapes strings the way literalParser unescapes them.
+var quoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`)
+
+func formatLiteral(value any) string {
+	switch v := value.(type) {
+	case string:
+		return `"` + quoteEscaper.Replace(v) + `"`
+	case []int:
+		items := make([]string, len(v))
+		for i, n := range v {
+			items[i] = fmt.Sprint(n)
+		}
+		return "[" + strings.Join(items, ",") + "]"
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,189 @@
+package helper
+
+import (
+	"cmp"
+	"fmt"
+	"strings"
+
+	"autoglm-go/phoneagent/definitions"
+)
+
+// UIDiff is the difference between two consecutive UI dumps.
+type UIDiff struct {
+	Added   []definitions.UIElement
+	Removed []definitions.UIElement
+	Changed []definitions.UIElement
+	Region  [4]int // union of the bounds of all changes, in pixels
+}
+
+func (d *UIDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+func (d *UIDiff) Size() int {
+	return len(d.Added) + len(d.Removed) + len(d.Changed)
+}
+
+// uiKey identifies an element across dumps independently of its content.
+func uiKey(e *definitions.UIElement) string {
+	return fmt.Sprintf("%s|%s|%v", e.Class, e.ResourceID, e.Bounds)
+}
+
+func DiffUIElements(prev, cur []definitions.UIElement) *UIDiff {
+	diff := &UIDiff{}
+
+	prevByKey := make(map[string]definitions.UIElement, len(prev))
+	for _, e := range prev {
+		prevByKey[uiKey(&e)] = e
+	}
+	curKeys := make(map[string]struct{}, len(cur))
+
+	first := true
+	extend := func(b [4]int) {
+		if first {
+			diff.Region = b
+			first = false
+			return
+		}
+		diff.Region[0] = min(diff.Region[0], b[0])
+		diff.Region[1] = min(diff.Region[1], b[1])
+		diff.Region[2] = max(diff.Region[2], b[2])
+		diff.Region[3] = max(diff.Region[3], b[3])
+	}
+
+	for _, e := range cur {
+		key := uiKey(&e)
+		curKeys[key] = struct{}{}
+		old, ok := prevByKey[key]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, e)
+			extend(e.Bounds)
+		case old.Text != e.Text || old.ContentDesc != e.ContentDesc || old.Clickable != e.Clickable:
+			diff.Changed = append(diff.Changed, e)
+			extend(e.Bounds)
+		}
+	}
+	for _, e := range prev {
+		if _, ok := curKeys[uiKey(&e)]; !ok {
+			diff.Removed = append(diff.Removed, e)
+			extend(e.Bounds)
+		}
+	}
+	return diff
+}
+
+// relativeBounds converts pixel bounds to the 0-999 coordinate system used
+// by the model.
+func relativeBounds(b [4]int, width, height int) [4]int {
+	if width <= 0 || height <= 0 {
+		return b
+	}
+	return [4]int{
+		b[0] * 1000 / width, b[1] * 1000 / height,
+		b[2] * 1000 / width, b[3] * 1000 / height,
+	}
+}
+
+func formatUIElement(e *definitions.UIElement, width, height int) string {
+	var sb strings.Builder
+	className := e.Class
+	if i := strings.LastIndex(className, "."); i >= 0 {
+		className = className[i+1:]
+	}
+	sb.WriteString(className)
+	if e.ResourceID != "" {
+		// the package prefix of Android ids adds nothing
+		_, id, _ := strings.Cut(e.ResourceID, ":id/")
+		sb.WriteString(" id=" + cmp.Or(id, e.ResourceID))
+	}
+	if e.Text != "" {
+		sb.WriteString(fmt.Sprintf(" text=%q", e.Text))
+	}
+	if e.ContentDesc != "" {
+		sb.WriteString(fmt.Sprintf(" desc=%q", e.ContentDesc))
+	}
+	b := relativeBounds(e.Bounds, width, height)
+	sb.WriteString(fmt.Sprintf(" bounds=[%d,%d][%d,%d]", b[0], b[1], b[2], b[3]))
+	if e.Clickable {
+		sb.WriteString(" clickable")
+	}
+	return sb.String()
+}
+
+// FormatUIElements renders elements as a compact list, one per line, with
+// bounds in the model coordinate system.
+func FormatUIElements(elements []definitions.UIElement, width, height int) string {
+	lines := make([]string, 0, len(elements))
+	for i := range elements {
+		lines = append(lines, fmt.Sprintf("[%d] %s", elements[i].Index, formatUIElement(&elements[i], width, height)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// FormatUIDiff renders a diff as "changed regions" context for the model.
+func FormatUIDiff(diff *UIDiff, width, height int) string {
+	if diff.Empty() {
+		return "UI unchanged since last step."
+	}
+
+	var sb strings.Builder
+	region := relativeBounds(diff.Region, width, height)
+	sb.WriteString(fmt.Sprintf("UI changes since last step (%d added, %d removed, %d changed) in region [%d,%d][%d,%d]:\n",
+		len(diff.Added), len(diff.Removed), len(diff.Changed), region[0], region[1], region[2], region[3]))
+	for i := range diff.Added {
+		sb.WriteString("+ " + formatUIElement(&diff.Added[i], width, height) + "\n")
+	}
+	for i := range diff.Changed {
+		sb.WriteString("~ " + formatUIElement(&diff.Changed[i], width, height) + "\n")
+	}
+	for i := range diff.Removed {
+		sb.WriteString("- " + formatUIElement(&diff.Removed[i], width, height) + "\n")
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// UIContainsText reports whether an element shows text, in its text or its
+// content description.
+func UIContainsText(elements []definitions.UIElement, text string) bool {
+	for i := range elements {
+		if strings.Contains(elements[i].Text, text) || strings.Contains(elements[i].ContentDesc, text) {
+			return true
+		}
+	}
+	return false
+}
+
+// FormatOverlays lists overlay windows numbered from 1, with bounds in the
+// model coordinate system.
+func FormatOverlays(overlays []definitions.Overlay, width, height int) string {
+	lines := make([]string, 0, len(overlays))
+	for i, o := range overlays {
+		b := relativeBounds(o.Bounds, width, height)
+		kind := "floating window"
+		if o.Kind == definitions.OverlayPiP {
+			kind = "picture-in-picture"
+		}
+		lines = append(lines, fmt.Sprintf("[%d] %s of %s bounds=[%d,%d][%d,%d], covers the app below, close it with do(action=\"Dismiss_Overlay\", index=%d)",
+			i+1, kind, o.Package, b[0], b[1], b[2], b[3], i+1))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// FormatNotifications lists notifications for the model, numbered for
+// Open_Notification and Dismiss_Notification.
+func FormatNotifications(notifications []definitions.Notification) string {
+	lines := make([]string, 0, len(notifications))
+	for i, n := range notifications {
+		line := fmt.Sprintf("[%d] %s", i+1, n.Package)
+		if n.Title != "" {
+			line += ": " + n.Title
+		}
+		if n.Text != "" {
+			line += " - " + n.Text
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, `Open one with do(action="Open_Notification", index=x), clear it with do(action="Dismiss_Notification", index=x).`)
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,82 @@
+package history
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"autoglm-go/phoneagent/seal"
+	"autoglm-go/utils"
+)
+
+// Record is one step artifact moved out of memory.
+type Record struct {
+	Index   int       `json:"index"` // position of the message in the conversation
+	Role    string    `json:"role"`
+	Content string    `json:"content"`
+	Time    time.Time `json:"time"`
+}
+
+// Spill appends records to a JSONL file. The file is created on the first
+// write, so sessions that never exceed their caps leave nothing on disk.
+// A Spill with an empty dir discards the records.
+type Spill struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+func NewSpill(dir, name string) *Spill {
+	if dir == "" {
+		return &Spill{}
+	}
+	return &Spill{
+		path: filepath.Join(dir, fmt.Sprintf("%s-%d.jsonl", name, time.Now().UnixNano())),
+	}
+}
+
+// Path returns the spill file, empty when records are discarded.
+func (s *Spill) Path() string {
+	return s.path
+}
+
+func (s *Spill) Write(records ...Record) error {
+	if s.path == "" || len(records) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+			return fmt.Errorf("failed to create history dir: %w", err)
+		}
+		file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to open history file: %w", err)
+		}
+		s.file = file
+	}
+
+	for _, record := range records {
+		if _, err := s.file.WriteString(seal.Line(utils.JsonString(record)) + "\n"); err != nil {
+			return fmt.Errorf("failed to write history file: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *Spill) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
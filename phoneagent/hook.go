@@ -0,0 +1,70 @@
+package phoneagent
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"autoglm-go/phoneagent/helper"
+)
+
+// StepInfo describes a finished step to step hooks, or an action to the
+// BeforeExecute and AfterExecute hooks of a Middleware.
+type StepInfo struct {
+	Task       string
+	Step       int
+	CurrentApp string // app the action was taken in
+	Action     helper.Action
+	Success    bool
+	Message    string
+
+	// Subgoal is the subgoal of the plan in progress, from 1, and Subgoals
+	// how many the plan has; both are 0 without a planner.
+	Subgoal  int
+	Subgoals int
+
+	// ActionTime is how long the action took, for AfterExecute.
+	ActionTime time.Duration
+}
+
+// StepHookResult is what a hook reports back to the agent.
+type StepHookResult struct {
+	Observation string // shown to the model with the next screenshot
+	Stop        bool   // end the task with Message
+	Message     string
+}
+
+// StepHook runs between agent steps, after the action has been executed.
+type StepHook interface {
+	AfterStep(ctx context.Context, info *StepInfo) (*StepHookResult, error)
+}
+
+// runStepHooks runs the hooks in order. Observations are kept for the next
+// prompt; the first hook asking to stop ends the task.
+func (r *PhoneAgent) runStepHooks(ctx context.Context, info *StepInfo) *StepHookResult {
+	for _, hook := range r.StepHooks {
+		result, err := hook.AfterStep(ctx, info)
+		if err != nil {
+			r.log().Warnf("step hook failed, err: %v", err)
+			r.hookObservations = append(r.hookObservations, "error: "+err.Error())
+			continue
+		}
+		if result == nil {
+			continue
+		}
+		if result.Observation != "" {
+			r.hookObservations = append(r.hookObservations, result.Observation)
+		}
+		if result.Stop {
+			return result
+		}
+	}
+	return nil
+}
+
+// takeHookObservations returns and clears the pending hook output.
+func (r *PhoneAgent) takeHookObservations() string {
+	text := strings.Join(r.hookObservations, "\n")
+	r.hookObservations = r.hookObservations[:0]
+	return text
+}
@@ -0,0 +1,322 @@
+package imaging
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	logs "github.com/sirupsen/logrus"
+	"golang.org/x/image/draw"
+)
+
+const (
+	FormatPNG  = "png"
+	FormatJPEG = "jpeg"
+	FormatWebP = "webp" // needs the ffmpeg accelerator, JPEG without it
+)
+
+// Level describes one encoding setting. MaxEdge 0 keeps the original size.
+type Level struct {
+	MaxEdge   int
+	Format    string
+	Quality   int
+	Grayscale bool
+}
+
+// Original tells whether level sends the screenshot as it is.
+func (l Level) Original() bool {
+	return l.MaxEdge == 0 && (l.Format == FormatPNG || l.Format == "") && !l.Grayscale
+}
+
+// ParseFormat accepts png, jpeg (or jpg) and webp, empty for png.
+func ParseFormat(name string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", FormatPNG:
+		return FormatPNG, nil
+	case FormatJPEG, "jpg":
+		return FormatJPEG, nil
+	case FormatWebP:
+		return FormatWebP, nil
+	}
+	return "", fmt.Errorf("invalid image format %q, want png, jpeg or webp", name)
+}
+
+// defaultQuality is used by the lossy formats when a level sets none.
+var defaultQuality = map[string]int{FormatJPEG: 85, FormatWebP: 80}
+
+// DefaultLevels goes from the original screenshot down to a small JPEG.
+var DefaultLevels = []Level{
+	{MaxEdge: 0, Format: FormatPNG},
+	{MaxEdge: 1600, Format: FormatJPEG, Quality: 85},
+	{MaxEdge: 1280, Format: FormatJPEG, Quality: 75},
+	{MaxEdge: 1024, Format: FormatJPEG, Quality: 65},
+	{MaxEdge: 768, Format: FormatJPEG, Quality: 55},
+}
+
+// Levels starts from first, then steps down through the smaller default
+// levels, in the format of first when it is lossy, at most at its quality and
+// in gray when it is. The zero Level gives DefaultLevels.
+func Levels(first Level) []Level {
+	if first.Format == "" {
+		first.Format = FormatPNG
+	}
+	if first.Format != FormatPNG && first.Quality <= 0 {
+		first.Quality = defaultQuality[first.Format]
+	}
+	levels := []Level{first}
+	for _, level := range DefaultLevels {
+		if level.MaxEdge == 0 || (first.MaxEdge > 0 && level.MaxEdge >= first.MaxEdge) {
+			continue
+		}
+		if first.Format != FormatPNG {
+			level.Format = first.Format
+			level.Quality = min(level.Quality, first.Quality)
+		}
+		level.Grayscale = first.Grayscale
+		levels = append(levels, level)
+	}
+	return levels
+}
+
+type Encoded struct {
+	Base64Data string
+	MimeType   string
+	Width      int
+	Height     int
+	Size       int // encoded bytes before base64
+
+	dataURL string
+}
+
+// DataURL returns the image as a data URL. The string is built once and
+// reused, since it is as large as the base64 payload.
+func (e *Encoded) DataURL() string {
+	if e.dataURL == "" {
+		e.dataURL = "data:" + e.MimeType + ";base64," + e.Base64Data
+	}
+	return e.dataURL
+}
+
+// Encode decodes a screenshot and re-encodes it according to level, on the
+// shared pool and its accelerator when there is one, see UsePool.
+func Encode(data []byte, level Level) (encoded *Encoded, err error) {
+	run(func() {
+		if encoded, err = encodeAccelerated(data, level); encoded != nil {
+			return
+		}
+		var img image.Image
+		if img, _, err = image.Decode(bytes.NewReader(data)); err != nil {
+			err = fmt.Errorf("failed to decode image: %w", err)
+			return
+		}
+		encoded, err = EncodeImage(img, level)
+	})
+	return encoded, err
+}
+
+// encodeAccelerated encodes data with the accelerator of the shared pool. It
+// returns nil without one, or when it failed and the image is to be encoded
+// in process.
+func encodeAccelerated(data []byte, level Level) (*Encoded, error) {
+	accel := accelerator()
+	if accel == nil || time.Now().UnixNano() < accelPausedUntil.Load() {
+		return nil, nil
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	out, err := accel.Encode(data, level)
+	if err != nil {
+		logs.Warnf("image accelerator failed, encoding in process for %s, err: %v", accelPause, err)
+		accelPausedUntil.Store(time.Now().Add(accelPause).UnixNano())
+		return nil, nil
+	}
+	width, height := scaledSize(config.Width, config.Height, level.MaxEdge)
+	return &Encoded{
+		Base64Data: base64.StdEncoding.EncodeToString(out),
+		MimeType:   "image/" + cmp.Or(level.Format, FormatPNG),
+		Width:      width,
+		Height:     height,
+		Size:       len(out),
+	}, nil
+}
+
+// accelPause is how long a failed accelerator is left alone.
+const accelPause = time.Minute
+
+var accelPausedUntil atomic.Int64 // unix nanoseconds
+
+// webpFallback warns once that WebP is encoded as JPEG.
+var webpFallback sync.Once
+
+func EncodeImage(img image.Image, level Level) (*Encoded, error) {
+	img = Resize(img, level.MaxEdge)
+	if level.Grayscale {
+		img = toGray(img)
+	}
+
+	var buf bytes.Buffer
+	mimeType := "image/png"
+	switch level.Format {
+	case FormatJPEG, FormatWebP:
+		if level.Format == FormatWebP {
+			webpFallback.Do(func() { logs.Warnf("webp screenshots need the ffmpeg accelerator, sending jpeg") })
+		}
+		mimeType = "image/jpeg"
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: cmp.Or(level.Quality, defaultQuality[FormatJPEG])}); err != nil {
+			return nil, fmt.Errorf("failed to encode jpeg: %w", err)
+		}
+	default:
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, fmt.Errorf("failed to encode png: %w", err)
+		}
+	}
+
+	bounds := img.Bounds()
+	return &Encoded{
+		Base64Data: base64.StdEncoding.EncodeToString(buf.Bytes()),
+		MimeType:   mimeType,
+		Width:      bounds.Dx(),
+		Height:     bounds.Dy(),
+		Size:       buf.Len(),
+	}, nil
+}
+
+// Resize scales img down so that its long edge is at most maxEdge.
+func Resize(img image.Image, maxEdge int) image.Image {
+	bounds := img.Bounds()
+	width, height := scaledSize(bounds.Dx(), bounds.Dy(), maxEdge)
+	if width == bounds.Dx() && height == bounds.Dy() {
+		return img
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
+
+func toGray(img image.Image) image.Image {
+	gray := image.NewGray(img.Bounds())
+	draw.Draw(gray, gray.Bounds(), img, img.Bounds().Min, draw.Src)
+	return gray
+}
+
+// scaledSize is the size of an image resized to maxEdge.
+func scaledSize(width, height, maxEdge int) (int, int) {
+	longEdge := max(width, height)
+	if maxEdge <= 0 || longEdge <= maxEdge {
+		return width, height
+	}
+	scale := float64(maxEdge) / float64(longEdge)
+	return max(1, int(float64(width)*scale)), max(1, int(float64(height)*scale))
+}
+
+// AdaptiveEncoder picks an encoding level per request. It steps down when the
+// encoded image exceeds the provider limit, when uploads are slow, or when the
+// provider rejects an image, and steps back up when uploads are fast again.
+type AdaptiveEncoder struct {
+	mu         sync.Mutex
+	levels     []Level
+	current    int
+	maxBytes   int
+	targetTime time.Duration
+}
+
+// NewAdaptiveEncoder steps through levels, DefaultLevels when empty, see
+// Levels.
+func NewAdaptiveEncoder(levels []Level, maxBytes int, targetTime time.Duration) *AdaptiveEncoder {
+	if targetTime <= 0 {
+		targetTime = 2 * time.Second
+	}
+	if len(levels) == 0 {
+		levels = DefaultLevels
+	}
+	return &AdaptiveEncoder{
+		levels:     levels,
+		maxBytes:   maxBytes,
+		targetTime: targetTime,
+	}
+}
+
+// Encode encodes data at the current level, stepping down further until the
+// result fits within the provider image size limit. It runs on the shared
+// pool, see UsePool.
+func (e *AdaptiveEncoder) Encode(data []byte) (encoded *Encoded, err error) {
+	run(func() { encoded, err = e.encode(data) })
+	return encoded, err
+}
+
+func (e *AdaptiveEncoder) encode(data []byte) (*Encoded, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var img image.Image // decoded once the accelerator fails, or without one
+	for {
+		level := e.levels[e.current]
+		var (
+			encoded *Encoded
+			err     error
+		)
+		if img == nil {
+			encoded, err = encodeAccelerated(data, level)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if encoded == nil {
+			if img == nil {
+				if img, _, err = image.Decode(bytes.NewReader(data)); err != nil {
+					return nil, fmt.Errorf("failed to decode image: %w", err)
+				}
+			}
+			if encoded, err = EncodeImage(img, level); err != nil {
+				return nil, err
+			}
+		}
+		if e.maxBytes <= 0 || encoded.Size <= e.maxBytes || e.current == len(e.levels)-1 {
+			return encoded, nil
+		}
+		e.current++
+	}
+}
+
+// Downgrade moves to the next smaller level. It returns false when already at
+// the smallest one.
+func (e *AdaptiveEncoder) Downgrade() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.current >= len(e.levels)-1 {
+		return false
+	}
+	e.current++
+	return true
+}
+
+// Observe records how long it took to upload a request carrying an image.
+func (e *AdaptiveEncoder) Observe(elapsed time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	switch {
+	case elapsed > e.targetTime && e.current < len(e.levels)-1:
+		e.current++
+	case elapsed < e.targetTime/4 && e.current > 0:
+		e.current--
+	}
+}
+
+func (e *AdaptiveEncoder) Level() Level {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.levels[e.current]
+}
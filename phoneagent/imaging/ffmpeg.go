@@ -0,0 +1,107 @@
+package imaging
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"image"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	logs "github.com/sirupsen/logrus"
+)
+
+// ffmpegTimeout bounds one encoding, a stuck accelerator falls back to the
+// in-process encoder.
+const ffmpegTimeout = 10 * time.Second
+
+// FFmpeg encodes with ffmpeg, which can use the GPU or a media engine of the
+// host: HWAccel is the -hwaccel method (cuda, vaapi, qsv, videotoolbox...),
+// empty for software, and JPEGEncoder the encoder of JPEG levels, e.g.
+// mjpeg_qsv or mjpeg_vaapi, mjpeg by default.
+type FFmpeg struct {
+	Path        string
+	HWAccel     string
+	JPEGEncoder string
+}
+
+// NewFFmpeg finds ffmpeg in PATH.
+func NewFFmpeg(hwaccel, jpegEncoder string) (*FFmpeg, error) {
+	path, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg not found: %w", err)
+	}
+	return &FFmpeg{Path: path, HWAccel: hwaccel, JPEGEncoder: jpegEncoder}, nil
+}
+
+func (r *FFmpeg) Encode(data []byte, level Level) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ffmpegTimeout)
+	defer cancel()
+
+	args := []string{"-hide_banner", "-loglevel", "error"}
+	if r.HWAccel != "" {
+		args = append(args, "-hwaccel", r.HWAccel)
+	}
+	args = append(args, "-f", "image2pipe", "-i", "pipe:0")
+	var filters []string
+	if level.MaxEdge > 0 {
+		// the size Resize gives, so both encoders agree on it
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode image: %w", err)
+		}
+		if width, height := scaledSize(config.Width, config.Height, level.MaxEdge); width != config.Width || height != config.Height {
+			filters = append(filters, fmt.Sprintf("scale=%d:%d", width, height))
+		}
+	}
+	if level.Grayscale {
+		// the lossy encoders take no gray pixel format
+		if level.Format == FormatJPEG || level.Format == FormatWebP {
+			filters = append(filters, "hue=s=0")
+		} else {
+			filters = append(filters, "format=gray")
+		}
+	}
+	if len(filters) > 0 {
+		args = append(args, "-vf", strings.Join(filters, ","))
+	}
+	switch level.Format {
+	case FormatJPEG:
+		encoder := r.JPEGEncoder
+		if encoder == "" {
+			encoder = "mjpeg"
+		}
+		args = append(args, "-c:v", encoder, "-q:v", strconv.Itoa(jpegQScale(level.Quality)), "-f", "mjpeg")
+	case FormatWebP:
+		args = append(args, "-c:v", "libwebp", "-quality", strconv.Itoa(cmp.Or(level.Quality, defaultQuality[FormatWebP])), "-f", "webp")
+	default:
+		args = append(args, "-c:v", "png", "-f", "image2pipe")
+	}
+	args = append(args, "-frames:v", "1", "pipe:1")
+
+	cmd := exec.CommandContext(ctx, r.Path, args...)
+	cmd.Stdin = bytes.NewReader(data)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	logs.Debugf("[FFmpeg] run cmd: %s", strings.Join(cmd.Args, " "))
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("ffmpeg wrote no image: %s", strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// jpegQScale maps a JPEG quality of 1-100 to the ffmpeg scale of 2 (best) to
+// 31.
+func jpegQScale(quality int) int {
+	if quality <= 0 {
+		quality = 75
+	}
+	return min(max(2+(100-quality)*29/100, 2), 31)
+}
@@ -0,0 +1,46 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math/bits"
+
+	"golang.org/x/image/draw"
+)
+
+// DHash is a 64 bit difference hash of an image. Screens that look the same
+// have close hashes even when a clock or a cursor changed.
+func DHash(img image.Image) uint64 {
+	small := image.NewGray(image.Rect(0, 0, 9, 8))
+	draw.ApproxBiLinear.Scale(small, small.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if small.GrayAt(x, y).Y > small.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// DHashData decodes an encoded image and hashes it, on the shared pool.
+func DHashData(data []byte) (hash uint64, err error) {
+	run(func() {
+		img, _, decodeErr := image.Decode(bytes.NewReader(data))
+		if decodeErr != nil {
+			err = fmt.Errorf("failed to decode image: %w", decodeErr)
+			return
+		}
+		hash = DHash(img)
+	})
+	return hash, err
+}
+
+// HashDistance is the number of differing bits of two hashes.
+func HashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
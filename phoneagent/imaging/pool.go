@@ -0,0 +1,103 @@
+package imaging
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	logs "github.com/sirupsen/logrus"
+)
+
+// slowQueueWait is how long a caller may wait for a worker before it is
+// logged, a sign that the pool is too small for the fleet.
+const slowQueueWait = 2 * time.Second
+
+// Accelerator encodes images outside the Go process, e.g. with a hardware
+// encoder. data is an encoded image, the result is encoded at level with the
+// long edge scaled like Resize.
+type Accelerator interface {
+	Encode(data []byte, level Level) ([]byte, error)
+}
+
+// Pool runs the decoding, scaling, encoding and hashing of screenshots on a
+// fixed number of workers shared by every device session, so a large fleet
+// neither saturates the CPU nor holds a decoded screenshot per device at
+// once. Callers wait while the queue is full.
+type Pool struct {
+	tasks       chan func()
+	accelerator Accelerator
+	wg          sync.WaitGroup
+	pending     atomic.Int64
+	closeOnce   sync.Once
+}
+
+// NewPool starts workers goroutines with room for queue waiting tasks.
+// accelerator is optional.
+func NewPool(workers, queue int, accelerator Accelerator) *Pool {
+	workers = max(workers, 1)
+	p := &Pool{tasks: make(chan func(), max(queue, 0)), accelerator: accelerator}
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for task := range p.tasks {
+				task()
+			}
+		}()
+	}
+	return p
+}
+
+// Pending returns how many tasks are queued or running.
+func (p *Pool) Pending() int {
+	return int(p.pending.Load())
+}
+
+// Close stops the workers once the queued tasks are done. Tasks must not be
+// started afterwards.
+func (p *Pool) Close() {
+	p.closeOnce.Do(func() {
+		close(p.tasks)
+		p.wg.Wait()
+	})
+}
+
+// do runs task on a worker and waits for it.
+func (p *Pool) do(task func()) {
+	p.pending.Add(1)
+	defer p.pending.Add(-1)
+	done := make(chan struct{})
+	queued := time.Now()
+	p.tasks <- func() {
+		defer close(done)
+		task()
+	}
+	<-done
+	if wait := time.Since(queued); wait > slowQueueWait {
+		logs.Debugf("image task took %s with %d pending, consider more image workers", wait.Round(time.Millisecond), p.Pending())
+	}
+}
+
+var sharedPool atomic.Pointer[Pool]
+
+// UsePool sends the image work of this package through p, nil runs it on the
+// calling goroutine, which is the default.
+func UsePool(p *Pool) {
+	sharedPool.Store(p)
+}
+
+// run runs task on the shared pool, if any.
+func run(task func()) {
+	if p := sharedPool.Load(); p != nil {
+		p.do(task)
+		return
+	}
+	task()
+}
+
+func accelerator() Accelerator {
+	if p := sharedPool.Load(); p != nil {
+		return p.accelerator
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package phoneagent
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"autoglm-go/phoneagent/definitions"
+	"autoglm-go/phoneagent/uilang"
+)
+
+// inputLocale is the locale and time zone the placeholders of Type are
+// expanded in.
+type inputLocale struct {
+	lang *uilang.Language
+	zone *time.Location
+}
+
+// resolveInputLocale returns the locale of AgentConfig.InputLocale, read
+// from the device once for InputLocaleAuto. A device that cannot tell falls
+// back on the UI language and the local time zone.
+func (r *PhoneAgent) resolveInputLocale(ctx context.Context) *inputLocale {
+	if r.inputLocale != nil {
+		return r.inputLocale
+	}
+	locale := &inputLocale{lang: r.uiLanguage, zone: time.Local}
+	if tag := r.AgentConfig.InputLocale; tag != definitions.InputLocaleAuto {
+		locale.lang = uilang.New(tag)
+	} else if device, ok := r.Device.(ShellDevice); ok {
+		deviceID := r.AgentConfig.DeviceID
+		for _, prop := range []string{"persist.sys.locale", "ro.product.locale"} {
+			out, err := device.Shell(ctx, deviceID, "getprop", prop)
+			if lang, perr := uilang.Parse(strings.TrimSpace(out)); err == nil && perr == nil {
+				locale.lang = lang
+				break
+			}
+		}
+		out, err := device.Shell(ctx, deviceID, "getprop", "persist.sys.timezone")
+		if zone, lerr := time.LoadLocation(strings.TrimSpace(out)); err == nil && lerr == nil && strings.TrimSpace(out) != "" {
+			locale.zone = zone
+		}
+	}
+	r.log().Infof("🌐 typing dates and numbers as %s, in %s", locale.lang.Tag, locale.zone)
+	r.inputLocale = locale
+	return locale
+}
+
+// expandInput replaces the placeholders of the text of Type, unchanged
+// unless AgentConfig.InputLocale is set.
+func (r *PhoneAgent) expandInput(ctx context.Context, text string) (string, error) {
+	if r.AgentConfig.InputLocale == "" || !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	locale := r.resolveInputLocale(ctx)
+	return locale.lang.ExpandInput(text, time.Now().In(locale.zone))
+}
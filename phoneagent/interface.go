@@ -6,25 +6,16 @@ import (
 
 	"autoglm-go/constants"
 	"autoglm-go/phoneagent/android"
+	"autoglm-go/phoneagent/appium"
 	"autoglm-go/phoneagent/definitions"
+	"autoglm-go/phoneagent/executor"
+	"autoglm-go/phoneagent/harmony"
 	"autoglm-go/phoneagent/ios"
 )
 
-// DeviceOperator 定义设备操作接口
+// DeviceOperator 定义设备操作接口，即 executor 执行操作所需的接口
 type DeviceOperator interface {
-	GetScreenshot(ctx context.Context, deviceID string) (*definitions.Screenshot, error)
-	GetCurrentApp(ctx context.Context, deviceID string) (string, error)
-	Tap(ctx context.Context, x, y int, deviceID string) error
-	DoubleTap(ctx context.Context, x, y int, deviceID string) error
-	LongPress(ctx context.Context, x, y int, deviceID string) error
-	Swipe(ctx context.Context, startX, startY, endX, endY int, deviceID string) error
-	Back(ctx context.Context, deviceID string) error
-	Home(ctx context.Context, deviceID string) error
-	LaunchApp(ctx context.Context, appName, deviceID string) (bool, error)
-	TypeText(ctx context.Context, text, deviceID string) error
-	ClearText(ctx context.Context, deviceID string) error
-	DetectAndSetADBKeyboard(ctx context.Context, deviceID string) (string, error)
-	RestoreKeyboard(ctx context.Context, ime, deviceID string) error
+	executor.Device
 }
 
 // DeviceManager 管理设备连接和状态
@@ -44,12 +35,22 @@ type Device interface {
 	DeviceManager
 }
 
-func CreateDevice(deviceType string) (Device, error) {
+func CreateDevice(deviceType string, opts *definitions.DeviceOptions) (Device, error) {
+	if opts == nil {
+		opts = &definitions.DeviceOptions{}
+	}
 	switch deviceType {
 	case constants.ADB:
-		return &android.ADBDevice{}, nil
+		return &android.ADBDevice{KeyboardAPK: opts.KeyboardAPK}, nil
 	case constants.IOS:
-		return &ios.IOSDevice{}, nil
+		return ios.NewIOSDevice(opts.WdaURL), nil
+	case constants.HDC:
+		return &harmony.HDCDevice{}, nil
+	case constants.APPIUM:
+		if opts.AppiumURL == "" {
+			return nil, fmt.Errorf("appium server url is required")
+		}
+		return appium.NewAppiumDevice(opts.AppiumURL, opts.AppiumCapabilities), nil
 	default:
 		return nil, fmt.Errorf("unknown device type: %v", deviceType)
 	}
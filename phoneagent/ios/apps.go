@@ -0,0 +1,40 @@
+package ios
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"autoglm-go/constants"
+	"autoglm-go/phoneagent/definitions"
+)
+
+// StartIntent launches the app of the bundle id of intent.Package, iOS has
+// no activities, actions or intent URIs.
+func (r *IOSDevice) StartIntent(ctx context.Context, intent definitions.Intent, deviceID string) error {
+	if intent.Package == "" || intent.Activity != "" || intent.Action != "" || intent.URI != "" {
+		return fmt.Errorf("iOS apps can only be started by their bundle id")
+	}
+	if err := r.command(ctx, deviceID, http.MethodPost, "/wda/apps/launch", map[string]any{"bundleId": intent.Package}, nil); err != nil {
+		return err
+	}
+	time.Sleep(time.Second * 1)
+	return nil
+}
+
+// ForceStop terminates the app.
+func (r *IOSDevice) ForceStop(ctx context.Context, packageName, deviceID string) error {
+	return r.command(ctx, deviceID, http.MethodPost, "/wda/apps/terminate", map[string]any{"bundleId": packageName}, nil)
+}
+
+// ClearData is not possible on iPhones, apps can only be reinstalled.
+func (r *IOSDevice) ClearData(ctx context.Context, packageName, deviceID string) error {
+	return fmt.Errorf("clearing app data is %w", ErrNotSupported)
+}
+
+// AppPackage returns the bundle id of an app name.
+func (r *IOSDevice) AppPackage(appName string) (string, bool) {
+	bundleID, ok := constants.APP_PACKAGES_IOS[appName]
+	return bundleID, ok
+}
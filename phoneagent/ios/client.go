@@ -0,0 +1,217 @@
+// Package ios drives iPhones through WebDriverAgent (WDA), running on the
+// device and reached over the network or a USB port forward (iproxy, or
+// `ios forward` of go-ios). The devices attached over USB are listed with
+// libimobiledevice.
+package ios
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	logs "github.com/sirupsen/logrus"
+)
+
+// DefaultWDAURL is where WDA listens when forwarded over USB.
+const DefaultWDAURL = "http://localhost:8100"
+
+// IOSDevice drives devices through WebDriverAgent. A WDA session is opened
+// per device id on first use.
+type IOSDevice struct {
+	wdaURL string
+	client *http.Client
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+type session struct {
+	id string
+
+	// screenshots are in pixels, WDA coordinates in points
+	scale         float64
+	scaleMeasured bool
+}
+
+// NewIOSDevice creates a device for the WDA at wdaURL, DefaultWDAURL when
+// empty.
+func NewIOSDevice(wdaURL string) *IOSDevice {
+	if wdaURL == "" {
+		wdaURL = DefaultWDAURL
+	}
+	return &IOSDevice{
+		wdaURL:   strings.TrimSuffix(wdaURL, "/"),
+		client:   &http.Client{Timeout: time.Minute},
+		sessions: map[string]*session{},
+	}
+}
+
+// Error is an error returned by WDA.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("wda %s (%d): %s", e.Code, e.StatusCode, e.Message)
+}
+
+// do sends a WDA command and decodes the "value" field of the response into
+// out when it is not nil.
+func (r *IOSDevice) do(ctx context.Context, method, path string, body any, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, r.wdaURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("wda request %s %s failed: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var envelope struct {
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return fmt.Errorf("invalid wda response (%d): %s", resp.StatusCode, data)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		var wdErr struct {
+			Error   string `json:"error"`
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(envelope.Value, &wdErr)
+		return &Error{StatusCode: resp.StatusCode, Code: wdErr.Error, Message: wdErr.Message}
+	}
+	if out != nil && len(envelope.Value) > 0 {
+		return json.Unmarshal(envelope.Value, out)
+	}
+	return nil
+}
+
+func (r *IOSDevice) getSession(ctx context.Context, deviceID string) (*session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if s, ok := r.sessions[deviceID]; ok {
+		return s, nil
+	}
+	var created struct {
+		SessionID string `json:"sessionId"`
+	}
+	err := r.do(ctx, http.MethodPost, "/session", map[string]any{
+		"capabilities": map[string]any{"alwaysMatch": map[string]any{}},
+	}, &created)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create wda session: %w", err)
+	}
+	s := &session{id: created.SessionID, scale: 1}
+	logs.Infof("wda session %s created for %s", s.id, orDefault(deviceID, r.wdaURL))
+	r.sessions[deviceID] = s
+	return s, nil
+}
+
+// command runs a command on the session of deviceID. A session that WDA no
+// longer knows, after it restarted, is recreated once.
+func (r *IOSDevice) command(ctx context.Context, deviceID, method, path string, body any, out any) error {
+	for attempt := 0; ; attempt++ {
+		s, err := r.getSession(ctx, deviceID)
+		if err != nil {
+			return err
+		}
+
+		err = r.do(ctx, method, "/session/"+s.id+path, body, out)
+		if wdErr, ok := err.(*Error); ok && wdErr.Code == "invalid session id" && attempt == 0 {
+			logs.Warnf("wda session %s expired, creating a new one", s.id)
+			r.dropSession(deviceID, s)
+			continue
+		}
+		return err
+	}
+}
+
+func (r *IOSDevice) dropSession(deviceID string, s *session) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.sessions[deviceID] == s {
+		delete(r.sessions, deviceID)
+	}
+}
+
+// Close ends all WDA sessions.
+func (r *IOSDevice) Close() error {
+	r.mu.Lock()
+	sessions := r.sessions
+	r.sessions = map[string]*session{}
+	r.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, s := range sessions {
+		if err := r.do(ctx, http.MethodDelete, "/session/"+s.id, nil, nil); err != nil {
+			logs.Warnf("failed to delete wda session %s: %v", s.id, err)
+		}
+	}
+	return nil
+}
+
+// Status is the /status of WDA.
+type Status struct {
+	Ready   bool   `json:"ready"`
+	Message string `json:"message"`
+	OS      struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	} `json:"os"`
+	Device string `json:"device"`
+	Build  struct {
+		Version string `json:"version"`
+	} `json:"build"`
+}
+
+// Status asks WDA whether it is ready.
+func (r *IOSDevice) Status(ctx context.Context) (*Status, error) {
+	var status Status
+	if err := r.do(ctx, http.MethodGet, "/status", nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// WDAURL returns the URL of WDA.
+func (r *IOSDevice) WDAURL() string {
+	return r.wdaURL
+}
+
+// orDefault returns s, or fallback when s is empty.
+func orDefault(s, fallback string) string {
+	if s == "" {
+		return fallback
+	}
+	return s
+}
@@ -1,46 +1,151 @@
 package ios
 
 import (
-	"autoglm-go/phoneagent/definitions"
 	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"autoglm-go/phoneagent/definitions"
+	logs "github.com/sirupsen/logrus"
 )
 
+var ErrNotSupported = errors.New("not supported by the ios backend")
+
 func (r *IOSDevice) Connect(ctx context.Context, address string) (string, error) {
-	// TODO implement me
-	panic("implement me")
+	return fmt.Sprintf("connect is %v, set --wda-url to the WDA of the device", ErrNotSupported), ErrNotSupported
 }
 
 func (r *IOSDevice) Disconnect(ctx context.Context, address string) (string, error) {
-	// TODO implement me
-	panic("implement me")
+	return fmt.Sprintf("disconnect is %v", ErrNotSupported), ErrNotSupported
 }
 
+// ListDevices lists the devices attached over USB or paired over the network
+// with libimobiledevice. Without it, or without such devices, it reports the
+// device of the WDA, whose id is the WDA URL.
 func (r *IOSDevice) ListDevices(ctx context.Context) ([]definitions.DeviceInfo, error) {
-	// TODO implement me
-	panic("implement me")
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var devices []definitions.DeviceInfo
+	for _, network := range []bool{false, true} {
+		args := []string{"-l"}
+		if network {
+			args = []string{"-n"}
+		}
+		output, err := exec.CommandContext(ctx, "idevice_id", args...).Output()
+		if err != nil {
+			logs.Debugf("[ListDevices] idevice_id %s failed, err: %v", args[0], err)
+			continue
+		}
+		for _, udid := range strings.Fields(string(output)) {
+			info := r.deviceInfo(ctx, udid, network)
+			devices = append(devices, info)
+		}
+	}
+	if len(devices) > 0 {
+		return devices, nil
+	}
+	info, err := r.GetDeviceInfo(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	return []definitions.DeviceInfo{*info}, nil
 }
 
+// deviceInfo describes the device of udid with ideviceinfo.
+func (r *IOSDevice) deviceInfo(ctx context.Context, udid string, network bool) definitions.DeviceInfo {
+	info := definitions.DeviceInfo{DeviceID: udid, Status: "device", ConnectionType: definitions.USB, OS: definitions.IOS}
+	args := []string{"-u", udid}
+	if network {
+		info.ConnectionType = definitions.WiFi
+		args = append(args, "-n")
+	}
+	output, err := exec.CommandContext(ctx, "ideviceinfo", args...).CombinedOutput()
+	if err != nil {
+		// a device that did not trust the computer yet
+		if strings.Contains(string(output), "Pair") || strings.Contains(string(output), "pair") {
+			info.Status = "unauthorized"
+		}
+		return info
+	}
+	values := map[string]string{}
+	for _, line := range strings.Split(string(output), "\n") {
+		if key, value, ok := strings.Cut(line, ": "); ok {
+			values[key] = strings.TrimSpace(value)
+		}
+	}
+	info.Model = values["DeviceName"]
+	if product := values["ProductType"]; product != "" {
+		info.Model = strings.TrimSpace(info.Model + " (" + product + ")")
+	}
+	info.AndroidVersion = values["ProductVersion"] // the iOS version
+	return info
+}
+
+// GetDeviceInfo reports the device of the WDA, WDA serves one device.
 func (r *IOSDevice) GetDeviceInfo(ctx context.Context, deviceID string) (*definitions.DeviceInfo, error) {
-	// TODO implement me
-	panic("implement me")
+	info := &definitions.DeviceInfo{
+		DeviceID:       deviceID,
+		Status:         "offline",
+		ConnectionType: definitions.Remote,
+		OS:             definitions.IOS,
+	}
+	if deviceID == "" {
+		info.DeviceID = r.wdaURL
+	}
+	if status, err := r.Status(ctx); err == nil && status.Ready {
+		info.Status = "device"
+		info.Model = status.Device
+		info.AndroidVersion = status.OS.Version
+	}
+	return info, nil
 }
 
+// IsConnected reports whether the WDA is ready for commands.
 func (r *IOSDevice) IsConnected(ctx context.Context, deviceID string) bool {
-	// TODO implement me
-	panic("implement me")
+	status, err := r.Status(ctx)
+	return err == nil && status.Ready
+}
+
+// Pair pairs the computer with the device of udid, or the only one attached,
+// with libimobiledevice. The device asks to trust the computer, Pair fails
+// until it is accepted.
+func (r *IOSDevice) Pair(ctx context.Context, udid string) (string, error) {
+	args := []string{"pair"}
+	if udid != "" {
+		args = append([]string{"-u", udid}, args...)
+	}
+	logs.Debugf("[Pair] run cmd: idevicepair %s", strings.Join(args, " "))
+	output, err := exec.CommandContext(ctx, "idevicepair", args...).CombinedOutput()
+	message := strings.TrimSpace(string(output))
+	if err != nil {
+		return message, fmt.Errorf("idevicepair failed: %v, output: %s", err, message)
+	}
+	return message, nil
 }
 
 func (r *IOSDevice) EnableTCPIP(ctx context.Context, port int, deviceID string) error {
-	// TODO implement me
-	panic("implement me")
+	return ErrNotSupported
 }
 
 func (r *IOSDevice) GetDeviceIP(ctx context.Context, deviceID string) (string, error) {
-	// TODO implement me
-	panic("implement me")
+	var status struct {
+		IOS struct {
+			IP string `json:"ip"`
+		} `json:"ios"`
+	}
+	if err := r.do(ctx, "GET", "/status", nil, &status); err != nil {
+		return "", err
+	}
+	if status.IOS.IP == "" {
+		return "", fmt.Errorf("wda did not report the ip of the device")
+	}
+	return status.IOS.IP, nil
 }
 
 func (r *IOSDevice) RestartServer(ctx context.Context) (string, error) {
-	// TODO implement me
-	panic("implement me")
+	return "", ErrNotSupported
 }
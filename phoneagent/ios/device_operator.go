@@ -1,73 +1,282 @@
 package ios
 
 import (
-	"autoglm-go/phoneagent/definitions"
+	"bytes"
 	"context"
+	"encoding/base64"
+	"fmt"
+	"image"
+	_ "image/png"
+	"math"
+	"net/http"
+	"time"
+
+	"autoglm-go/constants"
+	"autoglm-go/phoneagent/definitions"
+	logs "github.com/sirupsen/logrus"
 )
 
-type IOSDevice struct{}
+// springboard is the bundle id of the home screen.
+const springboard = "com.apple.springboard"
 
+// W3C element reference key
+const elementKey = "element-6066-11e4-a52e-4f735466cecf"
+
+// GetScreenshot takes a screenshot, a fallback one when it fails as on the
+// other backends.
 func (r *IOSDevice) GetScreenshot(ctx context.Context, deviceID string) (*definitions.Screenshot, error) {
-	// TODO implement me
-	panic("implement me")
+	var b64 string
+	if err := r.do(ctx, http.MethodGet, "/screenshot", nil, &b64); err != nil {
+		logs.Errorf("wda screenshot failed: %v", err)
+		return definitions.FallbackScreenshot(), nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		logs.Errorf("invalid screenshot data: %v", err)
+		return definitions.FallbackScreenshot(), nil
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		logs.Errorf("failed to decode screenshot: %v", err)
+		return definitions.FallbackScreenshot(), nil
+	}
+
+	r.updateScale(ctx, deviceID, cfg.Width)
+
+	return &definitions.Screenshot{
+		Base64Data: b64,
+		Width:      cfg.Width,
+		Height:     cfg.Height,
+		Data:       data,
+	}, nil
+}
+
+// updateScale records the ratio between points and screenshot pixels, 2 or 3
+// on retina screens.
+func (r *IOSDevice) updateScale(ctx context.Context, deviceID string, screenshotWidth int) {
+	s, err := r.getSession(ctx, deviceID)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	measured := s.scaleMeasured
+	r.mu.Unlock()
+	if measured {
+		return
+	}
+
+	var size struct {
+		Width float64 `json:"width"`
+	}
+	if err := r.command(ctx, deviceID, http.MethodGet, "/window/size", nil, &size); err != nil || size.Width <= 0 || screenshotWidth <= 0 {
+		return
+	}
+
+	r.mu.Lock()
+	s.scale = size.Width / float64(screenshotWidth)
+	s.scaleMeasured = true
+	r.mu.Unlock()
+}
+
+// scale returns points per screenshot pixel.
+func (r *IOSDevice) scale(ctx context.Context, deviceID string) float64 {
+	s, err := r.getSession(ctx, deviceID)
+	if err != nil {
+		return 1
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return s.scale
+}
+
+// point converts screenshot pixels to points.
+func (r *IOSDevice) point(ctx context.Context, deviceID string, x, y int) (int, int) {
+	scale := r.scale(ctx, deviceID)
+	return int(float64(x) * scale), int(float64(y) * scale)
+}
+
+// ActiveApp returns the bundle id of the foreground app.
+func (r *IOSDevice) ActiveApp(ctx context.Context) (string, error) {
+	var info struct {
+		BundleID string `json:"bundleId"`
+	}
+	if err := r.do(ctx, http.MethodGet, "/wda/activeAppInfo", nil, &info); err != nil {
+		return "", fmt.Errorf("failed to get active app: %w", err)
+	}
+	return info.BundleID, nil
 }
 
 func (r *IOSDevice) GetCurrentApp(ctx context.Context, deviceID string) (string, error) {
-	// TODO implement me
-	panic("implement me")
+	bundleID, err := r.ActiveApp(ctx)
+	if err != nil {
+		return "", err
+	}
+	if bundleID == springboard {
+		return "System Home", nil
+	}
+	for appName, id := range constants.APP_PACKAGES_IOS {
+		if id == bundleID {
+			return appName, nil
+		}
+	}
+	return "System Home", nil
 }
 
+// fingerActions performs a gesture of one finger by steps, at the same time.
+func (r *IOSDevice) fingerActions(ctx context.Context, deviceID string, fingers ...[]map[string]any) error {
+	actions := make([]any, 0, len(fingers))
+	for i, steps := range fingers {
+		actions = append(actions, map[string]any{
+			"type":       "pointer",
+			"id":         fmt.Sprintf("finger%d", i+1),
+			"parameters": map[string]any{"pointerType": "touch"},
+			"actions":    steps,
+		})
+	}
+	return r.command(ctx, deviceID, http.MethodPost, "/actions", map[string]any{"actions": actions}, nil)
+}
+
+func move(x, y, durationMs int) map[string]any {
+	return map[string]any{"type": "pointerMove", "duration": durationMs, "x": x, "y": y}
+}
+
+func pause(durationMs int) map[string]any {
+	return map[string]any{"type": "pause", "duration": durationMs}
+}
+
+var (
+	down = map[string]any{"type": "pointerDown", "button": 0}
+	up   = map[string]any{"type": "pointerUp", "button": 0}
+)
+
 func (r *IOSDevice) Tap(ctx context.Context, x, y int, deviceID string) error {
-	// TODO implement me
-	panic("implement me")
+	x, y = r.point(ctx, deviceID, x, y)
+	logs.Debugf("[Tap] wda tap: %d,%d", x, y)
+
+	err := r.fingerActions(ctx, deviceID, []map[string]any{move(x, y, 0), down, pause(100), up})
+	time.Sleep(time.Second * 1)
+	return err
 }
 
 func (r *IOSDevice) DoubleTap(ctx context.Context, x, y int, deviceID string) error {
-	// TODO implement me
-	panic("implement me")
+	x, y = r.point(ctx, deviceID, x, y)
+	logs.Debugf("[DoubleTap] wda double tap: %d,%d", x, y)
+
+	err := r.fingerActions(ctx, deviceID, []map[string]any{move(x, y, 0), down, pause(50), up, pause(100), down, pause(50), up})
+	time.Sleep(time.Second * 1)
+	return err
 }
 
 func (r *IOSDevice) LongPress(ctx context.Context, x, y int, deviceID string) error {
-	// TODO implement me
-	panic("implement me")
+	return r.Press(ctx, x, y, 3*time.Second, deviceID)
 }
 
 func (r *IOSDevice) Swipe(ctx context.Context, startX, startY, endX, endY int, deviceID string) error {
-	// TODO implement me
-	panic("implement me")
+	distSq := (startX-endX)*(startX-endX) + (startY-endY)*(startY-endY)
+	durationMs := int(float64(distSq) / 1000)
+	durationMs = max(1000, min(durationMs, 2000)) // Clamp between 1000-2000ms
+
+	startX, startY = r.point(ctx, deviceID, startX, startY)
+	endX, endY = r.point(ctx, deviceID, endX, endY)
+	logs.Debugf("[Swipe] wda swipe: %d,%d -> %d,%d in %dms", startX, startY, endX, endY, durationMs)
+
+	err := r.fingerActions(ctx, deviceID, []map[string]any{move(startX, startY, 0), down, move(endX, endY, durationMs), up})
+	time.Sleep(time.Second * 1)
+	return err
 }
 
+// Back swipes from the left edge of the screen, iPhones have no back button
+// and most apps go back with this gesture.
 func (r *IOSDevice) Back(ctx context.Context, deviceID string) error {
-	// TODO implement me
-	panic("implement me")
+	var size struct {
+		Width  int `json:"width"`
+		Height int `json:"height"`
+	}
+	if err := r.command(ctx, deviceID, http.MethodGet, "/window/size", nil, &size); err != nil {
+		return err
+	}
+	y := size.Height / 2
+	logs.Debugf("[Back] wda edge swipe at %d", y)
+
+	err := r.fingerActions(ctx, deviceID, []map[string]any{move(0, y, 0), down, move(size.Width*2/3, y, 300), up})
+	time.Sleep(time.Second * 1)
+	return err
 }
 
 func (r *IOSDevice) Home(ctx context.Context, deviceID string) error {
-	// TODO implement me
-	panic("implement me")
+	err := r.do(ctx, http.MethodPost, "/wda/homescreen", map[string]any{}, nil)
+	time.Sleep(time.Second * 1)
+	return err
 }
 
 func (r *IOSDevice) LaunchApp(ctx context.Context, appName, deviceID string) (bool, error) {
-	// TODO implement me
-	panic("implement me")
+	bundleID, ok := constants.APP_PACKAGES_IOS[appName]
+	if !ok {
+		return false, nil
+	}
+	if err := r.command(ctx, deviceID, http.MethodPost, "/wda/apps/launch", map[string]any{"bundleId": bundleID}, nil); err != nil {
+		return false, err
+	}
+	time.Sleep(time.Second * 1)
+	return true, nil
+}
+
+// activeElement returns the id of the focused element, empty when there is
+// none.
+func (r *IOSDevice) activeElement(ctx context.Context, deviceID string) string {
+	var element map[string]string
+	if err := r.command(ctx, deviceID, http.MethodGet, "/element/active", nil, &element); err != nil {
+		return ""
+	}
+	return element[elementKey]
 }
 
+// TypeText types into the focused field with the keyboard of the device, any
+// language.
 func (r *IOSDevice) TypeText(ctx context.Context, text, deviceID string) error {
-	// TODO implement me
-	panic("implement me")
+	chars := make([]string, 0, len(text))
+	for _, c := range text {
+		chars = append(chars, string(c))
+	}
+	return r.command(ctx, deviceID, http.MethodPost, "/wda/keys", map[string]any{"value": chars}, nil)
 }
 
 func (r *IOSDevice) ClearText(ctx context.Context, deviceID string) error {
-	// TODO implement me
-	panic("implement me")
+	id := r.activeElement(ctx, deviceID)
+	if id == "" {
+		return nil
+	}
+	return r.command(ctx, deviceID, http.MethodPost, "/element/"+id+"/clear", map[string]any{}, nil)
 }
 
+// DetectAndSetADBKeyboard is a no-op, WDA types with the keyboard of the
+// device.
 func (r *IOSDevice) DetectAndSetADBKeyboard(ctx context.Context, deviceID string) (string, error) {
-	// TODO implement me
-	panic("implement me")
+	return "", nil
 }
 
 func (r *IOSDevice) RestoreKeyboard(ctx context.Context, ime, deviceID string) error {
-	// TODO implement me
-	panic("implement me")
+	return nil
+}
+
+func (r *IOSDevice) DumpUI(ctx context.Context, deviceID string) ([]definitions.UIElement, error) {
+	var source string
+	if err := r.do(ctx, http.MethodGet, "/source", nil, &source); err != nil {
+		return nil, fmt.Errorf("failed to get page source: %w", err)
+	}
+	elements, err := parseSource(source)
+	if err != nil {
+		return nil, err
+	}
+
+	// WDA reports points, convert them to screenshot pixels
+	if scale := r.scale(ctx, deviceID); scale > 0 && scale != 1 {
+		for i := range elements {
+			for j := range elements[i].Bounds {
+				elements[i].Bounds[j] = int(math.Round(float64(elements[i].Bounds[j]) / scale))
+			}
+		}
+	}
+	return elements, nil
 }
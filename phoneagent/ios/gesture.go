@@ -0,0 +1,54 @@
+package ios
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	logs "github.com/sirupsen/logrus"
+)
+
+// Press touches x, y for duration.
+func (r *IOSDevice) Press(ctx context.Context, x, y int, duration time.Duration, deviceID string) error {
+	x, y = r.point(ctx, deviceID, x, y)
+	logs.Debugf("[Press] wda press: %d,%d for %s", x, y, duration)
+
+	err := r.fingerActions(ctx, deviceID, []map[string]any{move(x, y, 0), down, pause(int(duration.Milliseconds())), up})
+	time.Sleep(time.Second * 1)
+	return err
+}
+
+// Drag holds the first point of path, then moves through the others, each
+// segment taking an equal share of duration.
+func (r *IOSDevice) Drag(ctx context.Context, path [][2]int, hold, duration time.Duration, deviceID string) error {
+	if len(path) < 2 {
+		return fmt.Errorf("a drag needs at least 2 points")
+	}
+	segment := int(duration.Milliseconds()) / (len(path) - 1)
+	x, y := r.point(ctx, deviceID, path[0][0], path[0][1])
+	steps := []map[string]any{move(x, y, 0), down, pause(int(hold.Milliseconds()))}
+	for _, point := range path[1:] {
+		x, y = r.point(ctx, deviceID, point[0], point[1])
+		steps = append(steps, move(x, y, segment))
+	}
+	logs.Debugf("[Drag] wda drag through %d points in %s", len(path), duration)
+
+	err := r.fingerActions(ctx, deviceID, append(steps, up))
+	time.Sleep(time.Second * 1)
+	return err
+}
+
+// Pinch moves two fingers apart or together around x, y.
+func (r *IOSDevice) Pinch(ctx context.Context, x, y, fromSpan, toSpan int, duration time.Duration, deviceID string) error {
+	ms := int(duration.Milliseconds())
+	finger := func(side int) []map[string]any {
+		fromX, fromY := r.point(ctx, deviceID, x+side*fromSpan/2, y)
+		toX, toY := r.point(ctx, deviceID, x+side*toSpan/2, y)
+		return []map[string]any{move(fromX, fromY, 0), down, move(toX, toY, ms), up}
+	}
+	logs.Debugf("[Pinch] wda pinch at %d,%d from %d to %d pixels apart", x, y, fromSpan, toSpan)
+
+	err := r.fingerActions(ctx, deviceID, finger(-1), finger(1))
+	time.Sleep(time.Second * 1)
+	return err
+}
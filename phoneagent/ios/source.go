@@ -0,0 +1,62 @@
+package ios
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"autoglm-go/phoneagent/definitions"
+)
+
+// parseSource extracts the elements of a WDA page source, whose x, y, width
+// and height are in points.
+func parseSource(source string) ([]definitions.UIElement, error) {
+	decoder := xml.NewDecoder(strings.NewReader(source))
+
+	var elements []definitions.UIElement
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse page source: %w", err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		attrs := make(map[string]string, len(start.Attr))
+		for _, attr := range start.Attr {
+			attrs[attr.Name.Local] = attr.Value
+		}
+		if attrs["visible"] == "false" || attrs["width"] == "" {
+			continue
+		}
+
+		element := definitions.UIElement{
+			Text:       attrs["label"],
+			ResourceID: attrs["name"],
+			Class:      strings.TrimPrefix(attrs["type"], "XCUIElementType"),
+			Clickable:  attrs["accessible"] == "true" && attrs["enabled"] != "false",
+		}
+		if element.Text == "" {
+			element.Text = attrs["value"]
+		}
+		x, _ := strconv.Atoi(attrs["x"])
+		y, _ := strconv.Atoi(attrs["y"])
+		w, _ := strconv.Atoi(attrs["width"])
+		h, _ := strconv.Atoi(attrs["height"])
+		element.Bounds = [4]int{x, y, x + w, y + h}
+
+		if element.Text == "" && !element.Clickable {
+			continue
+		}
+		element.Index = len(elements)
+		elements = append(elements, element)
+	}
+	return elements, nil
+}
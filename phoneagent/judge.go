@@ -0,0 +1,89 @@
+package phoneagent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/trajectory"
+	"github.com/sashabaranov/go-openai"
+)
+
+// judgeFrames is how many of the last screenshots the judge sees, the final
+// screen and the one before it.
+const judgeFrames = 2
+
+const (
+	judgePromptCn = `你是手机操作任务的独立评审。根据用户任务和最后的屏幕截图（按时间顺序，最后一张是结束时的屏幕）判断任务是否真的完成，不要轻信执行者的说法。只输出 JSON：{"pass": true 或 false, "reason": "一句话理由"}`
+	judgePromptEn = `You independently review phone automation tasks. From the user's task and the last screenshots (in order, the last one is the final screen), decide whether the task was really done; do not take the executor's word for it. Output only JSON: {"pass": true or false, "reason": "one sentence"}`
+
+	judgeTaskCn = "任务：%s\n\n执行者的结束消息：%s"
+	judgeTaskEn = "Task: %s\n\nExecutor's finish message: %s"
+)
+
+var jsonObjectRe = regexp.MustCompile(`(?s)\{.*\}`)
+
+// keepJudgeFrame remembers the screenshot of the current step for the judge.
+func (r *PhoneAgent) keepJudgeFrame(imageURL string) {
+	if r.Judge == nil {
+		return
+	}
+	r.judgeFrames = append(r.judgeFrames, imageURL)
+	if len(r.judgeFrames) > judgeFrames {
+		r.judgeFrames = r.judgeFrames[len(r.judgeFrames)-judgeFrames:]
+	}
+}
+
+// judgeResult asks the judge model whether the finished task was done. A
+// failed request leaves no verdict rather than a made-up one.
+func (r *PhoneAgent) judgeResult(ctx context.Context, message string) *trajectory.Verdict {
+	system, format := judgePromptCn, judgeTaskCn
+	if r.AgentConfig.Lang == "en" {
+		system, format = judgePromptEn, judgeTaskEn
+	}
+
+	user := openai.ChatCompletionMessage{
+		Role: openai.ChatMessageRoleUser,
+		MultiContent: []openai.ChatMessagePart{
+			{Type: openai.ChatMessagePartTypeText, Text: fmt.Sprintf(format, r.task, message)},
+		},
+	}
+	for _, frame := range r.judgeFrames {
+		user.MultiContent = append(user.MultiContent, openai.ChatMessagePart{
+			Type:     openai.ChatMessagePartTypeImageURL,
+			ImageURL: &openai.ChatMessageImageURL{URL: frame},
+		})
+	}
+
+	response, err := r.Judge.Request(ctx, []openai.ChatCompletionMessage{helper.CreateSystemMessage(system), user})
+	if err != nil {
+		r.log().Errorf("judge request failed, err: %v", err)
+		return nil
+	}
+	r.Usage.Add(response)
+	verdict, err := parseVerdict(response.RawContent)
+	if err != nil {
+		r.log().Errorf("invalid judge verdict, err: %v", err)
+		return nil
+	}
+	verdict.Model = r.Judge.ModelName()
+	return verdict
+}
+
+// parseVerdict reads the JSON verdict, tolerating text or code fences
+// around it.
+func parseVerdict(content string) (*trajectory.Verdict, error) {
+	raw := jsonObjectRe.FindString(content)
+	if raw == "" {
+		return nil, fmt.Errorf("no JSON in %q", content)
+	}
+	var verdict trajectory.Verdict
+	if err := json.Unmarshal([]byte(raw), &verdict); err != nil {
+		return nil, fmt.Errorf("%w in %q", err, raw)
+	}
+	verdict.Reason = strings.TrimSpace(verdict.Reason)
+	return &verdict, nil
+}
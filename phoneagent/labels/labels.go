@@ -0,0 +1,110 @@
+package labels
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	logs "github.com/sirupsen/logrus"
+)
+
+// HeaderPrefix prefixes the labels sent with model requests, one header per
+// label, e.g. X-Label-Team: search.
+const HeaderPrefix = "X-Label-"
+
+// Labels are free-form key/value pairs attached to a task, such as team,
+// experiment or ticket id, for chargeback and tracing. They travel with the
+// context of the task.
+type Labels map[string]string
+
+var keyRe = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+type contextKey struct{}
+
+// With returns ctx carrying labels on top of those already in it.
+func With(ctx context.Context, labels Labels) context.Context {
+	if len(labels) == 0 {
+		return ctx
+	}
+	merged := Labels{}
+	for k, v := range From(ctx) {
+		merged[k] = v
+	}
+	for k, v := range labels {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, contextKey{}, merged)
+}
+
+// From returns the labels of ctx, nil when there are none. The map must not be
+// modified.
+func From(ctx context.Context) Labels {
+	labels, _ := ctx.Value(contextKey{}).(Labels)
+	return labels
+}
+
+// Parse reads labels written as key=value pairs separated by commas.
+func Parse(s string) (Labels, error) {
+	labels := Labels{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid label %q, want key=value", part)
+		}
+		labels[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return labels, labels.Validate()
+}
+
+// Validate checks that the keys can be sent as header names and the values
+// as header values.
+func (l Labels) Validate() error {
+	for k, v := range l {
+		if !keyRe.MatchString(k) {
+			return fmt.Errorf("invalid label key %q, want letters, digits, '_', '.' or '-'", k)
+		}
+		if strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("invalid value of label %s: line breaks are not allowed", k)
+		}
+	}
+	return nil
+}
+
+// Fields returns the labels as log fields.
+func (l Labels) Fields() logs.Fields {
+	fields := make(logs.Fields, len(l))
+	for k, v := range l {
+		fields[k] = v
+	}
+	return fields
+}
+
+// SetHeaders adds the labels to h, skipping those Validate rejects.
+func (l Labels) SetHeaders(h http.Header) {
+	for k, v := range l {
+		if keyRe.MatchString(k) && !strings.ContainsAny(v, "\r\n") {
+			h.Set(HeaderPrefix+k, v)
+		}
+	}
+}
+
+// String writes the labels sorted by key, e.g. team=search,ticket=T-42.
+func (l Labels) String() string {
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=" + l[k]
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,221 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"autoglm-go/phoneagent/definitions"
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	anthropicBaseURL = "https://api.anthropic.com/v1"
+	anthropicVersion = "2023-06-01"
+	// anthropicFilesBeta enables the Files API, for ImageFile.
+	anthropicFilesBeta = "files-api-2025-04-14"
+
+	// anthropicMaxTokens is used when ModelConfig.MaxTokens is 0, the
+	// Messages API requires a limit.
+	anthropicMaxTokens = 4096
+)
+
+// anthropicProvider talks to the Anthropic Messages API.
+type anthropicProvider struct {
+	client  *http.Client
+	baseURL string
+	apiKey  string
+}
+
+func newAnthropicProvider(cfg *definitions.ModelConfig, client *http.Client) *anthropicProvider {
+	return &anthropicProvider{client: client, baseURL: baseURL(cfg, anthropicBaseURL), apiKey: cfg.APIKey}
+}
+
+type anthropicContent struct {
+	Type   string           `json:"type"`
+	Text   string           `json:"text,omitempty"`
+	Source *anthropicSource `json:"source,omitempty"`
+}
+
+type anthropicSource struct {
+	Type      string `json:"type"` // base64, url or file
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+	FileID    string `json:"file_id,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string             `json:"role"`
+	Content []anthropicContent `json:"content"`
+}
+
+type anthropicRequest struct {
+	Model       string             `json:"model"`
+	System      string             `json:"system,omitempty"`
+	Messages    []anthropicMessage `json:"messages"`
+	MaxTokens   int                `json:"max_tokens"`
+	Temperature float32            `json:"temperature"`
+	Stream      bool               `json:"stream"`
+}
+
+// anthropicErrorStatus maps the error types of stream error events to the
+// status codes the same errors have as responses.
+var anthropicErrorStatus = map[string]int{
+	"invalid_request_error": http.StatusBadRequest,
+	"rate_limit_error":      http.StatusTooManyRequests,
+	"api_error":             http.StatusInternalServerError,
+	"overloaded_error":      529,
+}
+
+// anthropicEvent covers the stream events that carry text or usage.
+type anthropicEvent struct {
+	Type    string `json:"type"`
+	Message struct {
+		Usage struct {
+			InputTokens int `json:"input_tokens"`
+		} `json:"usage"`
+	} `json:"message"`
+	Delta struct {
+		Type     string `json:"type"`
+		Text     string `json:"text"`
+		Thinking string `json:"thinking"`
+	} `json:"delta"`
+	Usage struct {
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (r *anthropicProvider) Stream(ctx context.Context, req openai.ChatCompletionRequest) (ChatStream, error) {
+	body := anthropicRequest{
+		Model:     req.Model,
+		MaxTokens: req.MaxCompletionTokens,
+		// top_p is left out, newer models reject it next to temperature
+		Temperature: req.Temperature,
+		Stream:      true,
+	}
+	if body.MaxTokens <= 0 {
+		body.MaxTokens = anthropicMaxTokens
+	}
+
+	var system []string
+	files := false
+	for _, msg := range req.Messages {
+		if msg.Role == openai.ChatMessageRoleSystem {
+			system = append(system, msg.Content)
+			continue
+		}
+		var content []anthropicContent
+		for _, part := range messageParts(msg) {
+			switch {
+			case part.Data != "":
+				content = append(content, anthropicContent{
+					Type:   "image",
+					Source: &anthropicSource{Type: "base64", MediaType: part.MimeType, Data: part.Data},
+				})
+			case part.URL != "":
+				content = append(content, anthropicContent{Type: "image", Source: &anthropicSource{Type: "url", URL: part.URL}})
+			case part.FileID != "":
+				content = append(content, anthropicContent{Type: "image", Source: &anthropicSource{Type: "file", FileID: part.FileID}})
+				files = true
+			case part.Text != "":
+				// a trailing assistant message is a prefill, which must not
+				// end with whitespace
+				content = append(content, anthropicContent{Type: "text", Text: strings.TrimRight(part.Text, " \t\n")})
+			}
+		}
+		if len(content) == 0 {
+			continue
+		}
+		role := "user"
+		if msg.Role == openai.ChatMessageRoleAssistant {
+			role = "assistant"
+		}
+		body.Messages = append(body.Messages, anthropicMessage{Role: role, Content: content})
+	}
+	body.System = strings.Join(system, "\n\n")
+
+	header := http.Header{}
+	header.Set("x-api-key", r.apiKey)
+	header.Set("anthropic-version", anthropicVersion)
+	if files {
+		header.Set("anthropic-beta", anthropicFilesBeta)
+	}
+	resp, err := postStream(ctx, r.client, r.baseURL+"/messages", header, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var inputTokens int
+	return newLineStream(resp.Body, func(line []byte) (*openai.ChatCompletionStreamResponse, error) {
+		data := sseData(line)
+		if data == nil {
+			return nil, nil
+		}
+		var event anthropicEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return nil, fmt.Errorf("invalid anthropic stream event: %w", err)
+		}
+		switch event.Type {
+		case "message_start":
+			inputTokens = event.Message.Usage.InputTokens
+		case "content_block_delta":
+			return deltaChunk(event.Delta.Text, event.Delta.Thinking), nil
+		case "message_delta":
+			return usageChunk(inputTokens, event.Usage.OutputTokens), nil
+		case "message_stop":
+			return nil, io.EOF
+		case "error":
+			return nil, &openai.APIError{
+				Type:           event.Error.Type,
+				Message:        event.Error.Message,
+				HTTPStatusCode: anthropicErrorStatus[event.Error.Type],
+			}
+		}
+		return nil, nil
+	}), nil
+}
+
+// UploadFile uploads an image with the Files API, for ImageFile.
+func (r *anthropicProvider) UploadFile(ctx context.Context, mimeType string, data []byte) (string, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreatePart(textproto.MIMEHeader{
+		"Content-Disposition": {`form-data; name="file"; filename="screenshot` + extensionOf(mimeType) + `"`},
+		"Content-Type":        {mimeType},
+	})
+	if err != nil {
+		return "", err
+	}
+	if _, err := part.Write(data); err != nil {
+		return "", err
+	}
+	if err := form.Close(); err != nil {
+		return "", err
+	}
+	header := http.Header{}
+	header.Set("x-api-key", r.apiKey)
+	header.Set("anthropic-version", anthropicVersion)
+	header.Set("anthropic-beta", anthropicFilesBeta)
+	header.Set("Content-Type", form.FormDataContentType())
+	var file struct {
+		ID string `json:"id"`
+	}
+	if err := postDecode(ctx, r.client, r.baseURL+"/files", header, body.Bytes(), &file); err != nil {
+		return "", err
+	}
+	if file.ID == "" {
+		return "", fmt.Errorf("anthropic returned no file id")
+	}
+	return fileRefPrefix + file.ID, nil
+}
@@ -0,0 +1,234 @@
+package llm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	logs "github.com/sirupsen/logrus"
+)
+
+type CassetteMode string
+
+const (
+	CassetteRecord CassetteMode = "record" // send requests and save the responses
+	CassetteReplay CassetteMode = "replay" // answer from the saved responses, nothing is sent
+)
+
+// ErrCassetteMismatch is returned in replay when a request is not the one
+// recorded at its position, or comes after the last one.
+var ErrCassetteMismatch = errors.New("request does not match the cassette")
+
+// base64Re matches inline images, which are left out of the saved requests.
+var base64Re = regexp.MustCompile(`[A-Za-z0-9+/]{1000,}={0,2}`)
+
+// Interaction is a model request and its response as saved in a cassette.
+type Interaction struct {
+	Request struct {
+		Method string `json:"method"`
+		URL    string `json:"url"`
+		Body   string `json:"body"` // images replaced by their size
+	} `json:"request"`
+	Response struct {
+		Status      int    `json:"status"`
+		ContentType string `json:"content_type,omitempty"`
+		Body        string `json:"body"`
+	} `json:"response"`
+	Error string `json:"error,omitempty"` // the request failed without a response
+}
+
+// Cassette records the HTTP exchanges of model requests to a file and plays
+// them back in the same order, so the agent loop, response parsing and error
+// paths can run deterministically without a model endpoint. Requests are
+// matched by position, method and URL; their bodies vary with the screenshots
+// and are kept for reading only. API keys are never saved.
+type Cassette struct {
+	path string
+	mode CassetteMode
+	base http.RoundTripper
+
+	mu           sync.Mutex
+	interactions []*Interaction
+	next         int // in replay
+}
+
+// OpenCassette loads the cassette at path for replay, or starts an empty one
+// that is written to path after every response for record.
+func OpenCassette(path string, mode CassetteMode) (*Cassette, error) {
+	c := &Cassette{path: path, mode: mode}
+	switch mode {
+	case CassetteRecord:
+	case CassetteReplay:
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cassette: %w", err)
+		}
+		if err := json.Unmarshal(data, &c.interactions); err != nil {
+			return nil, fmt.Errorf("invalid cassette %s: %w", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown cassette mode %q, want record or replay", mode)
+	}
+	return c, nil
+}
+
+// UseCassette sends the requests of every ModelClient through c until the
+// returned function is called. It must be called before the first request.
+func UseCassette(c *Cassette) (restore func()) {
+	previous := sharedHTTPClient.Transport
+	c.base = previous
+	if labels, ok := previous.(labelTransport); ok {
+		c.base = labels.base
+	}
+	sharedHTTPClient.Transport = labelTransport{c}
+	return func() { sharedHTTPClient.Transport = previous }
+}
+
+// Remaining returns how many recorded interactions were not replayed yet.
+func (c *Cassette) Remaining() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.interactions) - c.next
+}
+
+func (c *Cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	if c.mode == CassetteReplay {
+		return c.replay(req)
+	}
+
+	interaction := &Interaction{}
+	interaction.Request.Method = req.Method
+	interaction.Request.URL = req.URL.Redacted()
+	interaction.Request.Body = base64Re.ReplaceAllStringFunc(string(body), func(data string) string {
+		return fmt.Sprintf("<%d base64 bytes>", len(data))
+	})
+	// saved in the order of the requests, once their response is read
+	c.mu.Lock()
+	c.interactions = append(c.interactions, interaction)
+	c.mu.Unlock()
+
+	resp, err := c.base.RoundTrip(req)
+	if err != nil {
+		c.mu.Lock()
+		interaction.Error = err.Error()
+		c.mu.Unlock()
+		c.save()
+		return nil, err
+	}
+	c.mu.Lock()
+	interaction.Response.Status = resp.StatusCode
+	interaction.Response.ContentType = resp.Header.Get("Content-Type")
+	c.mu.Unlock()
+	resp.Body = &recordingBody{ReadCloser: resp.Body, done: func(data []byte) {
+		c.mu.Lock()
+		interaction.Response.Body = string(data)
+		c.mu.Unlock()
+		c.save()
+	}}
+	return resp, nil
+}
+
+func (c *Cassette) replay(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.next >= len(c.interactions) {
+		return nil, fmt.Errorf("%w: %s %s after the last of %d recorded requests", ErrCassetteMismatch, req.Method, req.URL.Redacted(), len(c.interactions))
+	}
+	interaction := c.interactions[c.next]
+	c.next++
+	if url := req.URL.Redacted(); req.Method != interaction.Request.Method || url != interaction.Request.URL {
+		return nil, fmt.Errorf("%w: request %d is %s %s, recorded %s %s", ErrCassetteMismatch, c.next,
+			req.Method, url, interaction.Request.Method, interaction.Request.URL)
+	}
+	if interaction.Error != "" {
+		return nil, errors.New(interaction.Error)
+	}
+	header := http.Header{}
+	if interaction.Response.ContentType != "" {
+		header.Set("Content-Type", interaction.Response.ContentType)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", interaction.Response.Status, http.StatusText(interaction.Response.Status)),
+		StatusCode:    interaction.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader([]byte(interaction.Response.Body))),
+		ContentLength: int64(len(interaction.Response.Body)),
+		Request:       req,
+	}, nil
+}
+
+// save writes the cassette. Failing to write is logged, the requests go on.
+func (c *Cassette) save() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.write(); err != nil {
+		logs.Warnf("failed to save cassette %s, err: %v", c.path, err)
+	}
+}
+
+// write must be called with c.mu held.
+func (c *Cassette) write() error {
+	data, err := json.MarshalIndent(c.interactions, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return err
+	}
+	tmp := fmt.Sprintf("%s.%d.tmp", c.path, time.Now().UnixNano())
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// recordingBody keeps what is read from a response body and hands it to done
+// at the end of the body or when it is closed, whichever comes first.
+type recordingBody struct {
+	io.ReadCloser
+	buf  bytes.Buffer
+	done func(data []byte)
+	once sync.Once
+}
+
+func (r *recordingBody) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.buf.Write(p[:n])
+	if err != nil {
+		r.finish()
+	}
+	return n, err
+}
+
+func (r *recordingBody) Close() error {
+	r.finish()
+	return r.ReadCloser.Close()
+}
+
+func (r *recordingBody) finish() {
+	r.once.Do(func() { r.done(r.buf.Bytes()) })
+}
@@ -4,45 +4,230 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"autoglm-go/phoneagent/definitions"
 	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/logging"
+	"autoglm-go/phoneagent/metrics"
+	"autoglm-go/phoneagent/tracing"
 	"github.com/sashabaranov/go-openai"
 	logs "github.com/sirupsen/logrus"
 )
 
 type ModelClient struct {
-	config *definitions.ModelConfig
-	client *openai.Client
+	config     *definitions.ModelConfig
+	provider   ModelProvider
+	fallbacks  []target
+	limiter    *Limiter
+	limiterKey string
+
+	noTools atomic.Bool // the server rejected tools, actions are parsed from text
 }
 
+// NewModelClient uses the provider named by cfg.Provider. An unknown provider
+// fails every request, callers validate it with NewProvider beforehand.
 func NewModelClient(cfg *definitions.ModelConfig) *ModelClient {
 	if cfg == nil {
 		cfg = &definitions.ModelConfig{}
 	}
-	openaiCfg := openai.DefaultConfig(cfg.APIKey)
-	if cfg.BaseURL != "" {
-		openaiCfg.BaseURL = cfg.BaseURL
+	provider, err := NewProvider(cfg)
+	if err != nil {
+		provider = failingProvider{err}
 	}
+	return NewModelClientWithProvider(cfg, provider)
+}
 
+// NewModelClientWithProvider uses a custom provider for the primary model,
+// cfg.Provider is ignored. The fallbacks use their own providers.
+func NewModelClientWithProvider(cfg *definitions.ModelConfig, provider ModelProvider) *ModelClient {
+	if cfg == nil {
+		cfg = &definitions.ModelConfig{}
+	}
 	return &ModelClient{
-		config: cfg,
-		client: openai.NewClientWithConfig(openaiCfg),
+		config:    cfg,
+		provider:  provider,
+		fallbacks: newFallbackTargets(cfg),
 	}
 }
 
+type failingProvider struct {
+	err error
+}
+
+func (r failingProvider) Stream(ctx context.Context, req openai.ChatCompletionRequest) (ChatStream, error) {
+	return nil, r.err
+}
+
+// SetConfig replaces the config of the client, between requests. The
+// provider keeps the endpoint of the previous one.
+func (c *ModelClient) SetConfig(cfg *definitions.ModelConfig) {
+	c.config = cfg
+}
+
+// Derive returns a client for cfg, with its own provider and fallbacks,
+// waiting for the limiter of c.
+func (c *ModelClient) Derive(cfg *definitions.ModelConfig) *ModelClient {
+	derived := NewModelClient(cfg)
+	derived.limiter, derived.limiterKey = c.limiter, c.limiterKey
+	return derived
+}
+
+// SetLimiter makes the client wait for a slot of limiter before each request,
+// instead of the one of UseLimiter. key identifies the caller for fair
+// scheduling.
+func (c *ModelClient) SetLimiter(limiter *Limiter, key string) {
+	c.limiter = limiter
+	c.limiterKey = key
+}
+
 type ModelResponse struct {
 	Thinking          string
 	Action            string
-	RawContent        string
+	RawContent        string // the content field, without the reasoning_content of thinking models
 	TimeToFirstToken  *float64
 	TimeToThinkingEnd *float64
+	TimeToStreamOpen  float64 // time until response headers, includes the upload
 	TotalTime         float64
+	Usage             *openai.Usage // nil unless the provider reports it, see ModelConfig.TrackUsage
+	Model             string        // the model that answered, a fallback's name after falling back
+	Cost              float64       // estimated from Usage, see ModelConfig.Pricing
+	ToolAction        helper.Action // the action of a tool call or a ResponseParser, nil when it is parsed from Action
+
+	// Early is set when the response was returned as soon as the action was
+	// complete, see RequestOptions.ReturnOnAction. Its Usage is then nil.
+	Early bool
+}
+
+// RequestOptions are optional callbacks invoked while the response streams.
+type RequestOptions struct {
+	// OnAction is called once, from the streaming goroutine, as soon as the
+	// action call is complete. The rest of the stream is still read before
+	// Request returns, unless ReturnOnAction is set.
+	OnAction func(action string)
+
+	// ReturnOnAction returns the response as soon as the action call is
+	// complete, for text responses, and reads the rest of the stream in the
+	// background, for up to drainTimeout even once the ctx of the request is
+	// done. Drained then records the response with the usage of the whole
+	// stream, see UsageMeter.Wait.
+	ReturnOnAction bool
+	Drained        *UsageMeter
+
+	// OnThinkingDelta receives the thinking as it streams, OnThinkingDone the
+	// whole thinking once the action starts, and OnActionDelta the action
+	// text as it streams. They are called from the streaming goroutine. When
+	// none is set the thinking goes to stdout, see DefaultOutput.
+	OnThinkingDelta func(delta string)
+	OnThinkingDone  func(thinking string)
+	OnActionDelta   func(delta string)
+
+	// Tools are sent with ModelConfig.ToolCalls, see helper.ActionFromToolCall.
+	Tools []openai.Tool
+}
+
+// DefaultOutput writes the thinking to stdout when opts has no stream
+// callbacks: in full with ModelConfig.ShowThinking, else as one folded line.
+// With structured logs it is a thinking record of the logger of ctx instead.
+func (c *ModelClient) DefaultOutput(ctx context.Context, opts RequestOptions) RequestOptions {
+	if opts.OnThinkingDelta != nil || opts.OnThinkingDone != nil || opts.OnActionDelta != nil {
+		return opts
+	}
+	if logging.Structured() {
+		opts.OnThinkingDone = func(thinking string) {
+			logging.From(ctx).WithFields(logs.Fields{logging.FieldEvent: "thinking", "thinking": thinking}).
+				Info(FoldThinking(thinking, c.config.Lang))
+		}
+	} else if c.config.ShowThinking {
+		opts.OnThinkingDelta = func(delta string) { fmt.Print(delta) }
+	} else {
+		opts.OnThinkingDone = func(thinking string) { fmt.Println(FoldThinking(thinking, c.config.Lang)) }
+	}
+	return opts
+}
+
+func (c *ModelClient) Config() *definitions.ModelConfig {
+	return c.config
+}
+
+func (c *ModelClient) ModelName() string {
+	return c.config.ModelName
 }
 
 func (c *ModelClient) Request(ctx context.Context, messages []openai.ChatCompletionMessage) (*ModelResponse, error) {
+	return c.RequestWithOptions(ctx, messages, RequestOptions{})
+}
+
+// RequestWithOptions streams the response of the model, traced as a client
+// span of the span of ctx with the gen_ai attributes of OpenTelemetry.
+func (c *ModelClient) RequestWithOptions(ctx context.Context, messages []openai.ChatCompletionMessage, opts RequestOptions) (*ModelResponse, error) {
+	ctx, span := tracing.StartClient(ctx, "chat "+c.config.ModelName,
+		tracing.String("gen_ai.operation.name", "chat"),
+		tracing.String("gen_ai.request.model", c.config.ModelName),
+		tracing.Int("gen_ai.request.messages", len(messages)),
+	)
+	defer span.End()
+	response, err := c.request(ctx, messages, opts)
+	span.RecordError(err)
+	if response == nil {
+		metrics.ModelRequests.Inc(c.config.ModelName, "error")
+		return response, err
+	}
+	observeResponse(response)
+	span.SetAttributes(
+		tracing.String("gen_ai.response.model", response.Model),
+		tracing.Float64("llm.time_to_stream_open", response.TimeToStreamOpen),
+		tracing.Float64("llm.total_time", response.TotalTime),
+	)
+	if response.TimeToFirstToken != nil {
+		span.SetAttributes(tracing.Float64("llm.time_to_first_token", *response.TimeToFirstToken))
+	}
+	if u := response.Usage; u != nil {
+		span.SetAttributes(tracing.Int("gen_ai.usage.input_tokens", u.PromptTokens), tracing.Int("gen_ai.usage.output_tokens", u.CompletionTokens))
+	}
+	return response, err
+}
+
+func (c *ModelClient) request(ctx context.Context, messages []openai.ChatCompletionMessage, opts RequestOptions) (*ModelResponse, error) {
+	limiter, key := c.limiterFor(ctx)
+	if limiter != nil {
+		if err := limiter.Acquire(ctx, key); err != nil {
+			return nil, err
+		}
+		defer limiter.Release()
+	}
+	// a stream drained after returning early is closed and cancelled by
+	// the draining goroutine, and outlives ctx: it is cancelled with ctx
+	// only until the response returns
+	draining := false
+	cancel := context.CancelFunc(func() {})
+	detach := func() bool { return false }
+	if opts.ReturnOnAction {
+		parent := ctx
+		ctx, cancel = context.WithCancel(context.WithoutCancel(parent))
+		detach = context.AfterFunc(parent, cancel)
+	}
+	if timeout := c.config.HTTP.Timeout; timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		cancelStream := cancel
+		ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+		cancel = func() {
+			cancelTimeout()
+			cancelStream()
+		}
+	}
+	defer func() {
+		if !draining {
+			detach()
+			cancel()
+		}
+	}()
+	ctx = withDefaultHeaders(ctx, c.config.HTTP.Headers)
+
+	opts = c.DefaultOutput(ctx, opts)
 	startTime := time.Now()
 
 	var (
@@ -51,8 +236,20 @@ func (c *ModelClient) Request(ctx context.Context, messages []openai.ChatComplet
 
 		rawContent         strings.Builder
 		thinkingBuf        strings.Builder
+		actionBuf          strings.Builder
 		inActionPhase      bool
+		actionNotified     bool
 		firstTokenReceived bool
+		usage              *openai.Usage
+
+		reasoning      strings.Builder // reasoning_content of thinking models
+		thinkingTokens int             // streamed thinking chunks, about one token each
+		thinkingDone   bool
+
+		toolName strings.Builder // the first tool call, streamed in pieces
+		toolArgs strings.Builder
+
+		abandoned *openai.Usage // of the stream given up for its long thinking
 	)
 
 	req := openai.ChatCompletionRequest{
@@ -64,16 +261,60 @@ func (c *ModelClient) Request(ctx context.Context, messages []openai.ChatComplet
 		FrequencyPenalty:    c.config.FrequencyPenalty,
 		Stream:              true,
 	}
+	if c.config.TrackUsage {
+		req.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+	}
+	if c.config.ToolCalls && len(opts.Tools) > 0 && !c.noTools.Load() {
+		req.Tools = opts.Tools
+	}
 
-	stream, err := c.client.CreateChatCompletionStream(ctx, req)
+	stream, model, opened, err := c.openStream(ctx, req)
+	if err != nil && req.Tools != nil && IsToolsUnsupported(err) {
+		logs.Warnf("model %s does not support tools, parsing actions from text, err: %v", c.config.ModelName, err)
+		c.noTools.Store(true)
+		req.Tools = nil
+		stream, model, opened, err = c.openStream(ctx, req)
+	}
 	if err != nil {
-		logs.Errorf("CreateChatCompletionStream error: %v", err)
+		logs.Errorf("model stream error: %v", err)
 		return nil, err
 	}
-	defer stream.Close()
+	defer func() {
+		if !draining {
+			stream.Close()
+		}
+	}()
+	timeToStreamOpen := opened.Sub(startTime).Seconds()
+
+	parser := c.responseParser()
+	actionMarkers := slices.Concat(parser.Markers(), c.config.ActionMarkers)
+	if _, ok := parser.(textParser); !ok {
+		// the early action is read with the text syntax
+		opts.OnAction, opts.ReturnOnAction = nil, false
+	}
+	maxThinking := c.config.MaxThinkingTokens
 
-	actionMarkers := []string{"finish(message=", "do(action="}
+	thinkingDelta := func(delta string) {
+		if opts.OnThinkingDelta != nil && delta != "" {
+			opts.OnThinkingDelta(delta)
+		}
+	}
+	actionDelta := func(delta string) {
+		if opts.OnActionDelta != nil && delta != "" {
+			opts.OnActionDelta(delta)
+		}
+	}
+	endThinking := func(thinking string) {
+		if !thinkingDone {
+			thinkingDone = true
+			if opts.OnThinkingDone != nil {
+				opts.OnThinkingDone(thinking)
+			}
+		}
+	}
 
+	earlyAction := ""
+recv:
 	for {
 		resp, err := stream.Recv()
 		if err != nil {
@@ -81,83 +322,204 @@ func (c *ModelClient) Request(ctx context.Context, messages []openai.ChatComplet
 				break
 			}
 			logs.Errorf("Stream error: %v", err)
-			return nil, err
+			return nil, &StreamInterruptedError{Partial: rawContent.String(), Err: classify(err)}
 		}
 
+		if resp.Usage != nil {
+			usage = resp.Usage
+		}
 		if len(resp.Choices) == 0 {
 			continue
 		}
-
-		delta := resp.Choices[0].Delta.Content
-		if delta == "" {
-			continue
+		if resp.Choices[0].FinishReason == openai.FinishReasonContentFilter {
+			logs.Errorf("model %s answer blocked by the content filter", model)
+			return nil, fmt.Errorf("%w: model %s", ErrContentFiltered, model)
 		}
 
-		rawContent.WriteString(delta)
-
-		// time to first token
-		if !firstTokenReceived {
-			t := time.Since(startTime).Seconds()
-			timeToFirstToken = &t
-			firstTokenReceived = true
+		for _, call := range resp.Choices[0].Delta.ToolCalls {
+			if call.Index != nil && *call.Index != 0 {
+				continue
+			}
+			if toolName.Len() == 0 && timeToThinkingEnd == nil {
+				t := time.Since(startTime).Seconds()
+				timeToThinkingEnd = &t
+			}
+			toolName.WriteString(call.Function.Name)
+			toolArgs.WriteString(call.Function.Arguments)
 		}
 
-		if inActionPhase {
-			continue
+		// thinking models may stream their reasoning separately
+		if delta := resp.Choices[0].Delta.ReasoningContent; delta != "" && !inActionPhase {
+			reasoning.WriteString(delta)
+			thinkingTokens++
+			thinkingDelta(delta)
 		}
 
-		// for print thinking part
-		thinkingBuf.WriteString(delta)
-		thinkingBufStr := thinkingBuf.String()
-
-		markerFound := false
-		for _, marker := range actionMarkers {
-			if strings.Contains(thinkingBufStr, marker) {
-				// before marker is the thinking part
-				thinkingPart := strings.SplitN(thinkingBufStr, marker, 2)[0]
-				fmt.Print(thinkingPart)
-
-				inActionPhase = true
-				markerFound = true
+		delta := resp.Choices[0].Delta.Content
+		if delta != "" {
+			rawContent.WriteString(delta)
+
+			// time to first token
+			if !firstTokenReceived {
+				t := time.Since(startTime).Seconds()
+				timeToFirstToken = &t
+				firstTokenReceived = true
+			}
 
-				if timeToThinkingEnd == nil {
-					t := time.Since(startTime).Seconds()
-					timeToThinkingEnd = &t
+			if inActionPhase {
+				actionBuf.WriteString(delta)
+				actionDelta(delta)
+				actionNotified = c.notifyAction(opts, &actionBuf, actionNotified)
+				if earlyAction = returnOnAction(opts, &actionBuf); earlyAction != "" {
+					break recv
+				}
+				continue
+			}
+			thinkingTokens++
+
+			// for print thinking part
+			thinkingBuf.WriteString(delta)
+			thinkingBufStr := thinkingBuf.String()
+
+			markerFound := false
+			for _, marker := range actionMarkers {
+				if strings.Contains(thinkingBufStr, marker) {
+					// before marker is the thinking part
+					parts := strings.SplitN(thinkingBufStr, marker, 2)
+					thinkingDelta(parts[0])
+					content, _, _ := strings.Cut(rawContent.String(), marker)
+					endThinking(strings.TrimSpace(reasoning.String() + "\n" + content))
+
+					action := marker + parts[1]
+					if slices.Contains(c.config.ActionMarkers, marker) {
+						action = strings.TrimLeft(parts[1], " ")
+					}
+					actionBuf.WriteString(action)
+					actionDelta(action)
+					actionNotified = c.notifyAction(opts, &actionBuf, actionNotified)
+					if earlyAction = returnOnAction(opts, &actionBuf); earlyAction != "" {
+						break recv
+					}
+
+					inActionPhase = true
+					markerFound = true
+
+					if timeToThinkingEnd == nil {
+						t := time.Since(startTime).Seconds()
+						timeToThinkingEnd = &t
+					}
+					break
 				}
-				break
 			}
-		}
 
-		if markerFound {
-			continue
-		}
+			if markerFound {
+				continue
+			}
 
-		// Check if thinkingBuf ends with a prefix of any marker
-		// If so, don't print yet (wait for more content)
-		isPotentialMarker := false
-		for _, marker := range actionMarkers {
-			for i := 1; i < len(marker); i++ {
-				if strings.HasSuffix(thinkingBufStr, marker[:i]) {
-					isPotentialMarker = true
+			// Check if thinkingBuf ends with a prefix of any marker
+			// If so, don't print yet (wait for more content)
+			isPotentialMarker := false
+			for _, marker := range actionMarkers {
+				for i := 1; i < len(marker); i++ {
+					if strings.HasSuffix(thinkingBufStr, marker[:i]) {
+						isPotentialMarker = true
+						break
+					}
+				}
+				if isPotentialMarker {
 					break
 				}
 			}
-			if isPotentialMarker {
-				break
+
+			if !isPotentialMarker {
+				// Safe to print the thinking part
+				thinkingDelta(thinkingBufStr)
+				thinkingBuf.Reset()
 			}
 		}
 
-		if !isPotentialMarker {
-			// Safe to print the thinking part
-			fmt.Print(thinkingBufStr)
+		// over the budget: drop the rest of the thinking and ask for the
+		// action right away, once
+		if maxThinking > 0 && thinkingTokens >= maxThinking && !inActionPhase {
+			maxThinking = 0
+			thinking := strings.TrimSpace(reasoning.String() + rawContent.String())
+			logs.Warnf("thinking exceeded %d tokens, asking for the action", c.config.MaxThinkingTokens)
+
+			stream.Close()
+			// the abandoned stream is billed too, estimated when it ended
+			// before its usage
+			abandoned, usage = usage, nil
+			if abandoned == nil && c.config.TrackUsage {
+				prompt := helper.EstimateTokens(req.Messages)
+				abandoned = &openai.Usage{PromptTokens: prompt, CompletionTokens: thinkingTokens, TotalTokens: prompt + thinkingTokens}
+			}
+			req.Messages = append(messages[:len(messages):len(messages)],
+				openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: "<think>" + thinking + "</think>"},
+				openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: helper.GetMessage("thinking_limit", c.config.Lang)},
+			)
+			stream, model, _, err = c.openStream(ctx, req)
+			if err != nil {
+				logs.Errorf("model stream error: %v", err)
+				return nil, err
+			}
+			// the thinking so far is kept with the reasoning, the action is
+			// parsed from the content of the new stream only
+			rawContent.Reset()
+			reasoning.Reset()
+			reasoning.WriteString(thinking + "\n")
 			thinkingBuf.Reset()
 		}
 	}
 
 	totalTime := time.Since(startTime).Seconds()
+	if earlyAction != "" {
+		// the action is also the text of the response, trailing tokens
+		// included, so the rest is read in the background
+		parsed := parser.Parse(rawContent.String())
+		thinking := strings.TrimSpace(reasoning.String() + "\n" + parsed.Thinking)
+		endThinking(thinking)
+		response := &ModelResponse{
+			Thinking:          thinking,
+			Action:            earlyAction,
+			RawContent:        rawContent.String(),
+			TimeToFirstToken:  timeToFirstToken,
+			TimeToThinkingEnd: timeToThinkingEnd,
+			TimeToStreamOpen:  timeToStreamOpen,
+			TotalTime:         totalTime,
+			Model:             model,
+			Early:             true,
+		}
+		draining = true
+		detach()
+		if opts.Drained != nil {
+			opts.Drained.draining.Add(1)
+		}
+		drained := *response
+		drained.Usage = usage // unless the rest of the stream reports it
+		go c.drain(stream, cancel, limiter, drained, abandoned, opts.Drained)
+		return response, nil
+	}
+	if usage = addUsage(abandoned, usage); usage != nil {
+		limiter.Spend(usage.TotalTokens)
+	}
 
 	// parse thinking and action from raw content
-	thinking, action := parseResponse(rawContent.String())
+	parsed := parser.Parse(rawContent.String())
+	thinking, action, toolAction := parsed.Thinking, parsed.Action, parsed.Parsed
+	if toolName.Len() > 0 {
+		toolAction, err = helper.ActionFromToolCall(toolName.String(), toolArgs.String())
+		if err != nil {
+			logs.Warnf("invalid tool call, parsing the action from text, err: %v", err)
+		} else {
+			// the content is all thinking when the action is a tool call
+			thinking = strings.TrimSpace(strings.NewReplacer("<think>", "", "</think>", "").Replace(rawContent.String()))
+			action = helper.FormatAction(toolAction)
+		}
+	}
+	if r := strings.TrimSpace(reasoning.String()); r != "" {
+		thinking = strings.TrimSpace(r + "\n" + thinking)
+	}
+	endThinking(thinking)
 
 	printMetrics(
 		c.config.Lang,
@@ -172,10 +534,119 @@ func (c *ModelClient) Request(ctx context.Context, messages []openai.ChatComplet
 		RawContent:        rawContent.String(),
 		TimeToFirstToken:  timeToFirstToken,
 		TimeToThinkingEnd: timeToThinkingEnd,
+		TimeToStreamOpen:  timeToStreamOpen,
 		TotalTime:         totalTime,
+		Usage:             usage,
+		Model:             model,
+		Cost:              c.cost(model, usage),
+		ToolAction:        toolAction,
 	}, nil
 }
 
+// observeResponse adds the timings of a response to the metrics of its model.
+func observeResponse(response *ModelResponse) {
+	metrics.ModelRequests.Inc(response.Model, "ok")
+	metrics.ModelInferenceTime.Observe(response.TotalTime, response.Model)
+	if response.TimeToFirstToken != nil {
+		metrics.ModelTimeToFirstToken.Observe(*response.TimeToFirstToken, response.Model)
+	}
+	if response.TimeToThinkingEnd != nil {
+		metrics.ModelThinkingTime.Observe(*response.TimeToThinkingEnd, response.Model)
+	}
+}
+
+// foldedThinkingRunes is how much of the thinking the folded line shows.
+const foldedThinkingRunes = 80
+
+// FoldThinking shortens thinking to one line with its total length.
+func FoldThinking(thinking, lang string) string {
+	thinking = strings.Join(strings.Fields(strings.NewReplacer("<think>", "", "</think>", "").Replace(thinking)), " ")
+	runes := []rune(thinking)
+	if len(runes) <= foldedThinkingRunes {
+		return thinking
+	}
+	return fmt.Sprintf(helper.GetOutputMessage("thinking_folded", lang), string(runes[:foldedThinkingRunes]), len(runes))
+}
+
+// notifyAction calls opts.OnAction once the streamed action is complete and
+// reports whether it has been called.
+func (c *ModelClient) notifyAction(opts RequestOptions, actionBuf *strings.Builder, notified bool) bool {
+	if notified || opts.OnAction == nil {
+		return notified
+	}
+	action := actionBuf.String()
+	end := helper.ActionEnd(action)
+	if end < 0 {
+		return false
+	}
+	opts.OnAction(action[:end])
+	return true
+}
+
+// returnOnAction returns the complete action of actionBuf when the response
+// is returned as soon as it is, empty otherwise.
+func returnOnAction(opts RequestOptions, actionBuf *strings.Builder) string {
+	if !opts.ReturnOnAction {
+		return ""
+	}
+	action := actionBuf.String()
+	if end := helper.ActionEnd(action); end >= 0 {
+		return action[:end]
+	}
+	return ""
+}
+
+// drainTimeout bounds the reading of the rest of a stream returned early.
+const drainTimeout = 30 * time.Second
+
+// drain reads the rest of a stream returned early, for its usage, then
+// closes it so its connection goes back to the pool. spent is the usage of
+// the stream abandoned before it, if any, see ModelConfig.MaxThinkingTokens.
+func (c *ModelClient) drain(stream ChatStream, cancel context.CancelFunc, limiter *Limiter, response ModelResponse, spent *openai.Usage, meter *UsageMeter) {
+	defer cancel()
+	defer stream.Close()
+	timer := time.AfterFunc(drainTimeout, cancel)
+	defer timer.Stop()
+	started := time.Now()
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			if err != io.EOF {
+				logs.Debugf("failed to drain the model stream, err: %v", err)
+			}
+			break
+		}
+		if resp.Usage != nil {
+			response.Usage = resp.Usage
+		}
+	}
+	if response.Usage = addUsage(spent, response.Usage); response.Usage != nil {
+		limiter.Spend(response.Usage.TotalTokens)
+	}
+	response.Cost = c.cost(response.Model, response.Usage)
+	response.TotalTime += time.Since(started).Seconds()
+	printMetrics(c.config.Lang, response.TimeToFirstToken, response.TimeToThinkingEnd, response.TotalTime)
+	if meter != nil {
+		meter.Add(&response)
+		meter.draining.Done()
+	}
+}
+
+// addUsage returns the sum of a and b, either of which may be nil.
+func addUsage(a, b *openai.Usage) *openai.Usage {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	}
+	return &openai.Usage{
+		PromptTokens:     a.PromptTokens + b.PromptTokens,
+		CompletionTokens: a.CompletionTokens + b.CompletionTokens,
+		TotalTokens:      a.TotalTokens + b.TotalTokens,
+	}
+}
+
 func parseResponse(content string) (string, string) {
 	/*
 	   Parse the model response into thinking and action parts.
@@ -228,16 +699,16 @@ func parseResponse(content string) (string, string) {
 
 func printMetrics(lang string, firstToken *float64, thinkingEnd *float64, total float64) {
 	logs.Info("")
-	logs.Info(strings.Repeat("=", 50))
-	logs.Info("⏱️  " + helper.GetMessage("performance_metrics", lang))
-	logs.Info(strings.Repeat("-", 50))
+	logging.Rule("=")
+	logs.Info("⏱️  " + helper.GetOutputMessage("performance_metrics", lang))
+	logging.Rule("-")
 
 	if firstToken != nil {
-		logs.Infof("%s: %.3fs", helper.GetMessage("time_to_first_token", lang), *firstToken)
+		logs.Infof("%s: %.3fs", helper.GetOutputMessage("time_to_first_token", lang), *firstToken)
 	}
 	if thinkingEnd != nil {
-		logs.Infof("%s: %.3fs", helper.GetMessage("time_to_thinking_end", lang), *thinkingEnd)
+		logs.Infof("%s: %.3fs", helper.GetOutputMessage("time_to_thinking_end", lang), *thinkingEnd)
 	}
-	logs.Infof("%s: %.3fs", helper.GetMessage("total_inference_time", lang), total)
-	logs.Info(strings.Repeat("=", 50))
+	logs.Infof("%s: %.3fs", helper.GetOutputMessage("total_inference_time", lang), total)
+	logging.Rule("=")
 }
@@ -0,0 +1,131 @@
+package llm
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Classes of failed requests. The errors of the client wrap one of them when
+// it applies, so callers branch with errors.Is instead of matching messages.
+var (
+	ErrRateLimited           = errors.New("rate limited by the model provider")
+	ErrContextLengthExceeded = errors.New("context length of the model exceeded")
+	ErrContentFiltered       = errors.New("answer blocked by the content filter of the model provider")
+	ErrStreamInterrupted     = errors.New("model stream interrupted")
+)
+
+// StreamInterruptedError is a stream that failed after output was delivered,
+// which is not retried. Partial is the content received until then.
+type StreamInterruptedError struct {
+	Partial string
+	Err     error
+}
+
+func (e *StreamInterruptedError) Error() string {
+	return fmt.Sprintf("%v after %d bytes: %v", ErrStreamInterrupted, len(e.Partial), e.Err)
+}
+
+func (e *StreamInterruptedError) Unwrap() []error {
+	return []error{ErrStreamInterrupted, e.Err}
+}
+
+// classError adds a class to an error and keeps its message.
+type classError struct {
+	class error
+	err   error
+}
+
+func (e *classError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classError) Unwrap() []error {
+	return []error{e.class, e.err}
+}
+
+// classify wraps err with its class: ErrRateLimited, ErrContextLengthExceeded
+// or ErrContentFiltered. Other errors are returned as they are.
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	var class error
+	switch status, msg := httpStatus(err), strings.ToLower(err.Error()); {
+	case status == http.StatusTooManyRequests || strings.Contains(msg, "rate limit"):
+		class = ErrRateLimited
+	case (status == http.StatusBadRequest || status == http.StatusRequestEntityTooLarge) && containsAny(msg,
+		"context length", "context_length", "maximum context", "context window", "too many tokens", "上下文"):
+		class = ErrContextLengthExceeded
+	case containsAny(msg, "content_filter", "content filter", "content management policy", "敏感"):
+		class = ErrContentFiltered
+	default:
+		return err
+	}
+	if errors.Is(err, class) {
+		return err
+	}
+	return &classError{class: class, err: err}
+}
+
+// httpStatus returns the status code of an API error, 0 for other errors.
+func httpStatus(err error) int {
+	var apiErr *openai.APIError
+	var reqErr *openai.RequestError
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr.HTTPStatusCode
+	case errors.As(err, &reqErr):
+		return reqErr.HTTPStatusCode
+	}
+	return 0
+}
+
+func containsAny(s string, keywords ...string) bool {
+	for _, keyword := range keywords {
+		if strings.Contains(s, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsImageTooLarge reports whether the provider rejected the request because
+// an attached image is too large.
+func IsImageTooLarge(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if httpStatus(err) == http.StatusRequestEntityTooLarge {
+		return true
+	}
+
+	msg := strings.ToLower(err.Error())
+	if !strings.Contains(msg, "image") && !strings.Contains(msg, "图片") {
+		return false
+	}
+	for _, keyword := range []string{"too large", "exceed", "size", "过大", "超过"} {
+		if strings.Contains(msg, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsToolsUnsupported reports whether the server rejected the request because
+// it does not support tool calling.
+func IsToolsUnsupported(err error) bool {
+	status := httpStatus(err)
+	if status == 0 {
+		return false
+	}
+	if status != http.StatusBadRequest && status != http.StatusUnprocessableEntity && status != http.StatusNotImplemented {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "tool") || strings.Contains(msg, "function")
+}
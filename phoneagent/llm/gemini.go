@@ -0,0 +1,192 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"autoglm-go/phoneagent/definitions"
+	"github.com/sashabaranov/go-openai"
+)
+
+const geminiBaseURL = "https://generativelanguage.googleapis.com/v1beta"
+
+// geminiProvider talks to the Gemini generateContent API.
+type geminiProvider struct {
+	client  *http.Client
+	baseURL string
+	apiKey  string
+}
+
+func newGeminiProvider(cfg *definitions.ModelConfig, client *http.Client) *geminiProvider {
+	return &geminiProvider{client: client, baseURL: baseURL(cfg, geminiBaseURL), apiKey: cfg.APIKey}
+}
+
+type geminiPart struct {
+	Text       string            `json:"text,omitempty"`
+	InlineData *geminiInlineData `json:"inlineData,omitempty"`
+	FileData   *geminiFileData   `json:"fileData,omitempty"`
+	Thought    bool              `json:"thought,omitempty"`
+}
+
+type geminiInlineData struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+// geminiFileData is an uploaded file, or an image by URL.
+type geminiFileData struct {
+	MimeType string `json:"mimeType,omitempty"`
+	FileURI  string `json:"fileUri"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiRequest struct {
+	SystemInstruction *geminiContent  `json:"systemInstruction,omitempty"`
+	Contents          []geminiContent `json:"contents"`
+	GenerationConfig  struct {
+		Temperature     float32 `json:"temperature"`
+		TopP            float32 `json:"topP,omitempty"`
+		MaxOutputTokens int     `json:"maxOutputTokens,omitempty"`
+	} `json:"generationConfig"`
+}
+
+type geminiResponse struct {
+	Candidates []struct {
+		Content geminiContent `json:"content"`
+	} `json:"candidates"`
+	UsageMetadata *struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+		ThoughtsTokenCount   int `json:"thoughtsTokenCount"`
+	} `json:"usageMetadata"`
+}
+
+func (r *geminiProvider) Stream(ctx context.Context, req openai.ChatCompletionRequest) (ChatStream, error) {
+	var body geminiRequest
+	body.GenerationConfig.Temperature = req.Temperature
+	body.GenerationConfig.TopP = req.TopP
+	body.GenerationConfig.MaxOutputTokens = req.MaxCompletionTokens
+
+	var system []geminiPart
+	for _, msg := range req.Messages {
+		if msg.Role == openai.ChatMessageRoleSystem {
+			system = append(system, geminiPart{Text: msg.Content})
+			continue
+		}
+		var parts []geminiPart
+		for _, part := range messageParts(msg) {
+			switch {
+			case part.Data != "":
+				parts = append(parts, geminiPart{InlineData: &geminiInlineData{MimeType: part.MimeType, Data: part.Data}})
+			case part.URL != "":
+				parts = append(parts, geminiPart{FileData: &geminiFileData{MimeType: part.MimeType, FileURI: part.URL}})
+			case part.Text != "":
+				parts = append(parts, geminiPart{Text: part.Text})
+			}
+		}
+		if len(parts) == 0 {
+			continue
+		}
+		role := "user"
+		if msg.Role == openai.ChatMessageRoleAssistant {
+			role = "model"
+		}
+		body.Contents = append(body.Contents, geminiContent{Role: role, Parts: parts})
+	}
+	if len(system) > 0 {
+		body.SystemInstruction = &geminiContent{Parts: system}
+	}
+
+	header := http.Header{}
+	header.Set("x-goog-api-key", r.apiKey)
+	endpoint := fmt.Sprintf("%s/models/%s:streamGenerateContent?alt=sse", r.baseURL, url.PathEscape(strings.TrimPrefix(req.Model, "models/")))
+	resp, err := postStream(ctx, r.client, endpoint, header, body)
+	if err != nil {
+		return nil, err
+	}
+
+	return newLineStream(resp.Body, func(line []byte) (*openai.ChatCompletionStreamResponse, error) {
+		data := sseData(line)
+		if data == nil {
+			return nil, nil
+		}
+		var chunk geminiResponse
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return nil, fmt.Errorf("invalid gemini stream chunk: %w", err)
+		}
+		var content, reasoning strings.Builder
+		if len(chunk.Candidates) > 0 {
+			for _, part := range chunk.Candidates[0].Content.Parts {
+				if part.Thought {
+					reasoning.WriteString(part.Text)
+				} else {
+					content.WriteString(part.Text)
+				}
+			}
+		}
+		result := deltaChunk(content.String(), reasoning.String())
+		// the usage is cumulative, the last chunk has the total
+		if u := chunk.UsageMetadata; u != nil {
+			if result == nil {
+				result = &openai.ChatCompletionStreamResponse{}
+			}
+			result.Usage = usageChunk(u.PromptTokenCount, u.CandidatesTokenCount+u.ThoughtsTokenCount).Usage
+		}
+		return result, nil
+	}), nil
+}
+
+// UploadFile uploads an image with the File API, for ImageFile. The file is
+// referenced by its URI and deleted by Gemini after 48 hours.
+func (r *geminiProvider) UploadFile(ctx context.Context, mimeType string, data []byte) (string, error) {
+	endpoint, err := url.Parse(r.baseURL)
+	if err != nil {
+		return "", err
+	}
+	// https://generativelanguage.googleapis.com/upload/v1beta/files
+	endpoint.Path = "/upload" + endpoint.Path + "/files"
+
+	// a resumable upload, started with the metadata then sent at once
+	header := http.Header{}
+	header.Set("x-goog-api-key", r.apiKey)
+	header.Set("Content-Type", "application/json")
+	header.Set("X-Goog-Upload-Protocol", "resumable")
+	header.Set("X-Goog-Upload-Command", "start")
+	header.Set("X-Goog-Upload-Header-Content-Length", strconv.Itoa(len(data)))
+	header.Set("X-Goog-Upload-Header-Content-Type", mimeType)
+	resp, err := post(ctx, r.client, endpoint.String(), header, []byte(`{"file": {"display_name": "screenshot"}}`))
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	uploadURL := resp.Header.Get("X-Goog-Upload-URL")
+	if uploadURL == "" {
+		return "", fmt.Errorf("gemini returned no upload URL")
+	}
+
+	header = http.Header{}
+	header.Set("x-goog-api-key", r.apiKey)
+	header.Set("X-Goog-Upload-Offset", "0")
+	header.Set("X-Goog-Upload-Command", "upload, finalize")
+	var uploaded struct {
+		File struct {
+			URI string `json:"uri"`
+		} `json:"file"`
+	}
+	if err := postDecode(ctx, r.client, uploadURL, header, data, &uploaded); err != nil {
+		return "", err
+	}
+	if uploaded.File.URI == "" {
+		return "", fmt.Errorf("gemini returned no file URI")
+	}
+	return uploaded.File.URI, nil
+}
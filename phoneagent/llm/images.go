@@ -0,0 +1,262 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"autoglm-go/phoneagent/artifact"
+	"autoglm-go/phoneagent/definitions"
+	"autoglm-go/phoneagent/seal"
+	"github.com/sashabaranov/go-openai"
+)
+
+// Image transports of definitions.ImageConfig.Transport.
+const (
+	ImageInline = "inline" // base64 data URLs in the request, the default
+	ImageURL    = "url"    // put in the artifact storage, sent as signed URLs
+	ImageFile   = "file"   // uploaded with the file API of the provider
+)
+
+// Image detail levels of definitions.ImageConfig.Detail, for OpenAI.
+const (
+	ImageDetailLow  = "low"
+	ImageDetailHigh = "high"
+	ImageDetailAuto = "auto"
+)
+
+const (
+	// imageURLTTL is how long the signed URLs of ImageURL stay valid, they
+	// are handed out again once half of it is over.
+	imageURLTTL = time.Hour
+	// imageFileTTL is how long an uploaded file is used before uploading it
+	// again, Gemini deletes its files after 48 hours.
+	imageFileTTL = 24 * time.Hour
+	// maxImageRefs bounds the images remembered as sent, the history holds a
+	// few screenshots at a time.
+	maxImageRefs = 256
+)
+
+// fileRefPrefix marks the image URLs that are files of the provider's file
+// API, such as file-id:file_011CNha8iCJcU1wXNR6q4V8w.
+const fileRefPrefix = "file-id:"
+
+// FileUploader is implemented by the providers with a file API, for
+// ImageFile. It returns the URL to reference the file with: its URI, or its
+// id after fileRefPrefix.
+type FileUploader interface {
+	UploadFile(ctx context.Context, mimeType string, data []byte) (string, error)
+}
+
+// ParseImageTransport parses a transport for every provider, such as url, or
+// per provider such as anthropic=file,gemini=file,openai=url, the others
+// staying inline. An empty spec sends every image inline.
+func ParseImageTransport(spec string) (map[string]string, error) {
+	transports := map[string]string{}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		provider, transport, ok := strings.Cut(item, "=")
+		if !ok {
+			provider, transport = "", item
+		}
+		switch transport {
+		case ImageInline, ImageURL, ImageFile:
+		default:
+			return nil, fmt.Errorf("invalid image transport %q, want inline, url or file", transport)
+		}
+		transports[strings.TrimSpace(provider)] = transport
+	}
+	return transports, nil
+}
+
+// imageTransportOf returns the transport of the provider of cfg.
+func imageTransportOf(cfg *definitions.ModelConfig) (string, error) {
+	transports, err := ParseImageTransport(cfg.Image.Transport)
+	if err != nil {
+		return "", err
+	}
+	provider := cfg.Provider
+	if provider == "" {
+		provider = ProviderOpenAI
+	}
+	if transport, ok := transports[provider]; ok {
+		return transport, nil
+	}
+	if transport, ok := transports[""]; ok {
+		return transport, nil
+	}
+	return ImageInline, nil
+}
+
+// imageProvider sends the images of the requests of its provider as
+// ImageURL or ImageFile tell, with the detail level of the config. The agent
+// keeps data URLs in its history, they are swapped for each request.
+type imageProvider struct {
+	ModelProvider
+	transport string
+	detail    openai.ImageURLDetail
+	uploader  FileUploader // for ImageFile
+
+	mu   sync.Mutex
+	refs map[[sha256.Size]byte]imageRef // the images already stored or uploaded
+}
+
+type imageRef struct {
+	url     string
+	expires time.Time
+}
+
+// withImages wraps provider for the image transport and detail of cfg, it
+// returns provider itself when the images go inline as they are.
+func withImages(cfg *definitions.ModelConfig, provider ModelProvider) (ModelProvider, error) {
+	transport, err := imageTransportOf(cfg)
+	if err != nil {
+		return nil, err
+	}
+	detail := openai.ImageURLDetail(cfg.Image.Detail)
+	switch detail {
+	case "", ImageDetailLow, ImageDetailHigh, ImageDetailAuto:
+	default:
+		return nil, fmt.Errorf("invalid image detail %q, want low, high or auto", cfg.Image.Detail)
+	}
+	if transport == ImageInline && detail == "" {
+		return provider, nil
+	}
+	wrapped := &imageProvider{ModelProvider: provider, transport: transport, detail: detail}
+	switch {
+	case transport == ImageURL && cfg.Provider == ProviderOllama:
+		return nil, fmt.Errorf("provider %s only accepts inline images", cfg.Provider)
+	case transport == ImageFile:
+		uploader, ok := provider.(FileUploader)
+		if !ok {
+			return nil, fmt.Errorf("provider %s has no file API for image transport file", providerName(cfg.Provider))
+		}
+		wrapped.uploader = uploader
+	}
+	return wrapped, nil
+}
+
+func providerName(provider string) string {
+	if provider == "" {
+		return ProviderOpenAI
+	}
+	return provider
+}
+
+func (r *imageProvider) Stream(ctx context.Context, req openai.ChatCompletionRequest) (ChatStream, error) {
+	messages, err := r.images(ctx, req.Messages)
+	if err != nil {
+		return nil, err
+	}
+	req.Messages = messages
+	return r.ModelProvider.Stream(ctx, req)
+}
+
+// images returns a copy of messages with their images sent as r tells, the
+// messages of the history are left as they are.
+func (r *imageProvider) images(ctx context.Context, messages []openai.ChatCompletionMessage) ([]openai.ChatCompletionMessage, error) {
+	out := make([]openai.ChatCompletionMessage, len(messages))
+	for i, msg := range messages {
+		out[i] = msg
+		if len(msg.MultiContent) == 0 {
+			continue
+		}
+		parts := make([]openai.ChatMessagePart, len(msg.MultiContent))
+		for j, part := range msg.MultiContent {
+			parts[j] = part
+			if part.Type != openai.ChatMessagePartTypeImageURL || part.ImageURL == nil {
+				continue
+			}
+			image := *part.ImageURL
+			if r.detail != "" {
+				image.Detail = r.detail
+			}
+			if r.transport != ImageInline && strings.HasPrefix(image.URL, "data:") {
+				url, err := r.send(ctx, image.URL)
+				if err != nil {
+					return nil, fmt.Errorf("failed to send image by %s: %w", r.transport, err)
+				}
+				image.URL = url
+			}
+			parts[j].ImageURL = &image
+		}
+		out[i].MultiContent = parts
+	}
+	return out, nil
+}
+
+// send stores or uploads the image of a data URL and returns the URL to send
+// instead, the one of a previous request while it is valid.
+func (r *imageProvider) send(ctx context.Context, dataURL string) (string, error) {
+	sum := sha256.Sum256([]byte(dataURL))
+	r.mu.Lock()
+	ref, ok := r.refs[sum]
+	r.mu.Unlock()
+	if ok && time.Now().Before(ref.expires) {
+		return ref.url, nil
+	}
+
+	mimeType, encoded, ok := parseDataURL(dataURL)
+	if !ok {
+		return "", fmt.Errorf("not a base64 data URL")
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	switch r.transport {
+	case ImageURL:
+		storage := artifact.Current()
+		if storage == nil {
+			return "", fmt.Errorf("image transport url needs an artifact store")
+		}
+		if seal.Enabled() && !artifact.ServesSealed(storage) {
+			return "", fmt.Errorf("image transport url cannot serve the encrypted screenshots of %s, use inline or file", storage)
+		}
+		key := artifact.ContentKey("model-inputs", data, extensionOf(mimeType))
+		if err := storage.Put(ctx, key, seal.Data(data), mimeType); err != nil {
+			return "", err
+		}
+		if ref.url, err = storage.URL(ctx, key, imageURLTTL); err != nil {
+			return "", err
+		}
+		if !strings.HasPrefix(ref.url, "https://") && !strings.HasPrefix(ref.url, "http://") {
+			return "", fmt.Errorf("%s is not reachable by the model, the artifact store needs an absolute base URL", ref.url)
+		}
+		ref.expires = time.Now().Add(imageURLTTL / 2)
+	default:
+		if ref.url, err = r.uploader.UploadFile(ctx, mimeType, data); err != nil {
+			return "", err
+		}
+		ref.expires = time.Now().Add(imageFileTTL)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.refs == nil || len(r.refs) >= maxImageRefs {
+		r.refs = map[[sha256.Size]byte]imageRef{}
+	}
+	r.refs[sum] = ref
+	return ref.url, nil
+}
+
+// urlMimeType guesses the image type of url from its extension, empty when
+// it has none.
+func urlMimeType(url string) string {
+	url, _, _ = strings.Cut(url, "?")
+	return mime.TypeByExtension(path.Ext(url))
+}
+
+// extensionOf is the file extension of an image type, such as .png.
+func extensionOf(mimeType string) string {
+	return "." + strings.TrimPrefix(mimeType, "image/")
+}
@@ -0,0 +1,272 @@
+package llm
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"autoglm-go/phoneagent/metrics"
+)
+
+// LimiterOptions are the limits of a Limiter, 0 leaves one out.
+type LimiterOptions struct {
+	MaxInFlight int     // requests at the same time
+	QPS         float64 // requests started per second, in bursts of up to a second's worth
+	// TokensPerMinute is the budget of prompt and completion tokens. The
+	// tokens of a request are known from its usage once it ends, so the
+	// budget may be overdrawn by the requests in flight; the next ones wait
+	// until it is back.
+	TokensPerMinute int
+}
+
+// Limiter bounds the model requests in flight, started per second and the
+// tokens they use per minute. A single Limiter can be shared by every
+// ModelClient in the process, see UseLimiter. When the limits are reached,
+// waiting requests are granted round-robin across keys (usually one key per
+// device session) so a busy session cannot starve the others.
+type Limiter struct {
+	mu       sync.Mutex
+	max      int // 0 is unlimited
+	inFlight int
+	requests bucket // of QPS
+	tokens   bucket // of TokensPerMinute
+	timer    *time.Timer
+	queues   map[string][]*waiter
+	order    []string // keys with waiters, in round-robin order
+	next     int
+}
+
+type waiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+// bucket is a token bucket refilled at rate per second up to capacity,
+// unlimited when rate is 0.
+type bucket struct {
+	rate, capacity, level float64
+	at                    time.Time
+}
+
+func newBucket(rate, capacity float64, now time.Time) bucket {
+	return bucket{rate: rate, capacity: capacity, level: capacity, at: now}
+}
+
+func (b *bucket) refill(now time.Time) {
+	if b.rate == 0 {
+		return
+	}
+	b.level = math.Min(b.capacity, b.level+now.Sub(b.at).Seconds()*b.rate)
+	b.at = now
+}
+
+// wait is how long until the bucket holds one.
+func (b *bucket) wait() time.Duration {
+	if b.rate == 0 || b.level >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.level) / b.rate * float64(time.Second))
+}
+
+func NewLimiter(opts LimiterOptions) *Limiter {
+	now := time.Now()
+	l := &Limiter{
+		max:    max(opts.MaxInFlight, 0),
+		queues: map[string][]*waiter{},
+	}
+	if opts.QPS > 0 {
+		l.requests = newBucket(opts.QPS, math.Max(opts.QPS, 1), now)
+	}
+	if opts.TokensPerMinute > 0 {
+		tpm := float64(opts.TokensPerMinute)
+		l.tokens = newBucket(tpm/60, tpm, now)
+	}
+	return l
+}
+
+// Acquire blocks until a request slot is available for key or ctx is done.
+func (l *Limiter) Acquire(ctx context.Context, key string) error {
+	started := time.Now()
+	l.mu.Lock()
+	if ok, _ := l.ready(started); ok && len(l.order) == 0 {
+		l.take()
+		l.mu.Unlock()
+		metrics.ModelLimiterWait.Observe(0)
+		return nil
+	}
+
+	w := &waiter{ready: make(chan struct{})}
+	if _, ok := l.queues[key]; !ok {
+		l.order = append(l.order, key)
+	}
+	l.queues[key] = append(l.queues[key], w)
+	l.dispatch()
+	l.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		metrics.ModelLimiterWait.Observe(time.Since(started).Seconds())
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		if w.granted {
+			// the slot was handed over concurrently, pass it on
+			l.mu.Unlock()
+			l.Release()
+		} else {
+			l.removeWaiter(key, w)
+			l.mu.Unlock()
+		}
+		return ctx.Err()
+	}
+}
+
+func (l *Limiter) Release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight--
+	l.dispatch()
+}
+
+// Spend takes the tokens a request used from the budget per minute.
+func (l *Limiter) Spend(tokens int) {
+	if l == nil || l.tokens.rate == 0 || tokens <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens.refill(time.Now())
+	l.tokens.level -= float64(tokens)
+}
+
+// ready reports whether a request may start now, or else how long until the
+// buckets let it, 0 when it waits for one in flight to end. It must be called
+// with l.mu held.
+func (l *Limiter) ready(now time.Time) (bool, time.Duration) {
+	if l.max > 0 && l.inFlight >= l.max {
+		return false, 0
+	}
+	l.requests.refill(now)
+	l.tokens.refill(now)
+	wait := max(l.requests.wait(), l.tokens.wait())
+	return wait == 0, wait
+}
+
+// take must be called with l.mu held.
+func (l *Limiter) take() {
+	l.inFlight++
+	if l.requests.rate > 0 {
+		l.requests.level--
+	}
+}
+
+// dispatch grants the waiters a request may start for, then waits for the
+// buckets to refill when they hold the others back. It must be called with
+// l.mu held.
+func (l *Limiter) dispatch() {
+	for len(l.order) > 0 {
+		ok, wait := l.ready(time.Now())
+		if !ok {
+			if wait > 0 && l.timer == nil {
+				l.timer = time.AfterFunc(wait, func() {
+					l.mu.Lock()
+					defer l.mu.Unlock()
+					l.timer = nil
+					l.dispatch()
+				})
+			}
+			return
+		}
+		if l.next >= len(l.order) {
+			l.next = 0
+		}
+		key := l.order[l.next]
+		queue := l.queues[key]
+
+		w := queue[0]
+		if len(queue) == 1 {
+			delete(l.queues, key)
+			l.order = append(l.order[:l.next], l.order[l.next+1:]...)
+		} else {
+			l.queues[key] = queue[1:]
+			l.next++
+		}
+
+		w.granted = true
+		l.take()
+		close(w.ready)
+	}
+}
+
+// removeWaiter must be called with l.mu held.
+func (l *Limiter) removeWaiter(key string, w *waiter) {
+	queue := l.queues[key]
+	for i, candidate := range queue {
+		if candidate == w {
+			queue = append(queue[:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(queue) > 0 {
+		l.queues[key] = queue
+		return
+	}
+
+	delete(l.queues, key)
+	for i, k := range l.order {
+		if k == key {
+			l.order = append(l.order[:i], l.order[i+1:]...)
+			if l.next > i {
+				l.next--
+			}
+			break
+		}
+	}
+}
+
+var (
+	sharedLimiterMu sync.RWMutex
+	sharedLimiter   *Limiter
+)
+
+// UseLimiter makes the clients without a limiter of their own, see
+// SetLimiter, wait for l, and returns a function restoring the previous one.
+// They queue by the key of WithLimiterKey.
+func UseLimiter(l *Limiter) func() {
+	sharedLimiterMu.Lock()
+	defer sharedLimiterMu.Unlock()
+	previous := sharedLimiter
+	sharedLimiter = l
+	return func() {
+		sharedLimiterMu.Lock()
+		defer sharedLimiterMu.Unlock()
+		sharedLimiter = previous
+	}
+}
+
+// SharedLimiter returns the limiter of UseLimiter, nil when there is none.
+func SharedLimiter() *Limiter {
+	sharedLimiterMu.RLock()
+	defer sharedLimiterMu.RUnlock()
+	return sharedLimiter
+}
+
+type limiterKey struct{}
+
+// WithLimiterKey returns ctx whose model requests queue as key for the
+// shared limiter, usually the device of the session.
+func WithLimiterKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, limiterKey{}, key)
+}
+
+// limiterFor returns the limiter the requests of c wait for in ctx, and
+// their key.
+func (c *ModelClient) limiterFor(ctx context.Context) (*Limiter, string) {
+	if c.limiter != nil {
+		return c.limiter, c.limiterKey
+	}
+	key, _ := ctx.Value(limiterKey{}).(string)
+	return SharedLimiter(), key
+}
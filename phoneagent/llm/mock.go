@@ -0,0 +1,152 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"autoglm-go/phoneagent/definitions"
+	"github.com/sashabaranov/go-openai"
+)
+
+// mockChunkRunes is how much content a mock chunk carries, small enough for
+// the action markers to be split across chunks as with real streams.
+const mockChunkRunes = 8
+
+// MockResponse is one scripted model answer.
+type MockResponse struct {
+	// Content is the text the model writes, e.g. MockAnswer("...", `do(action="Back")`).
+	Content   string
+	Reasoning string           // streamed as reasoning_content before Content
+	ToolCall  *openai.ToolCall // sent after Content
+	Usage     *openai.Usage
+	// Err fails the request before the stream opens, e.g. an *openai.APIError
+	// with status 429 to exercise retries. StreamErr ends the stream after
+	// Content instead.
+	Err       error
+	StreamErr error
+}
+
+// MockAnswer writes thinking and action the way AutoGLM models do, the
+// thinking right before the action call.
+func MockAnswer(thinking, action string) string {
+	return thinking + "\n" + action
+}
+
+// MockProvider answers requests with scripted responses in order, for running
+// the agent loop without a model endpoint. It fails once they are used up.
+type MockProvider struct {
+	mu        sync.Mutex
+	responses []MockResponse
+	requests  []openai.ChatCompletionRequest
+}
+
+// NewMockModelClient returns a client answering with responses in order, and
+// its provider to check the requests with.
+func NewMockModelClient(cfg *definitions.ModelConfig, responses ...MockResponse) (*ModelClient, *MockProvider) {
+	provider := NewMockProvider(responses...)
+	return NewModelClientWithProvider(cfg, provider), provider
+}
+
+func NewMockProvider(responses ...MockResponse) *MockProvider {
+	return &MockProvider{responses: responses}
+}
+
+// Add scripts more responses after the pending ones.
+func (r *MockProvider) Add(responses ...MockResponse) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.responses = append(r.responses, responses...)
+}
+
+// Requests returns the requests received so far, including failed ones.
+func (r *MockProvider) Requests() []openai.ChatCompletionRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]openai.ChatCompletionRequest(nil), r.requests...)
+}
+
+// Pending returns how many responses are not used yet.
+func (r *MockProvider) Pending() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.responses)
+}
+
+func (r *MockProvider) Stream(ctx context.Context, req openai.ChatCompletionRequest) (ChatStream, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req)
+	if len(r.responses) == 0 {
+		return nil, fmt.Errorf("mock model has no response left for request %d", len(r.requests))
+	}
+	response := r.responses[0]
+	r.responses = r.responses[1:]
+	if response.Err != nil {
+		return nil, response.Err
+	}
+	return &mockStream{chunks: mockChunks(response), err: response.StreamErr}, nil
+}
+
+func mockChunks(response MockResponse) []openai.ChatCompletionStreamResponse {
+	var chunks []openai.ChatCompletionStreamResponse
+	for _, part := range splitRunes(response.Reasoning, mockChunkRunes) {
+		chunks = append(chunks, *deltaChunk("", part))
+	}
+	for _, part := range splitRunes(response.Content, mockChunkRunes) {
+		chunks = append(chunks, *deltaChunk(part, ""))
+	}
+	if call := response.ToolCall; call != nil {
+		index := 0
+		streamed := *call
+		streamed.Index = &index
+		if streamed.Type == "" {
+			streamed.Type = openai.ToolTypeFunction
+		}
+		chunks = append(chunks, openai.ChatCompletionStreamResponse{
+			Choices: []openai.ChatCompletionStreamChoice{{
+				Delta: openai.ChatCompletionStreamChoiceDelta{ToolCalls: []openai.ToolCall{streamed}},
+			}},
+		})
+	}
+	if response.Usage != nil {
+		chunks = append(chunks, openai.ChatCompletionStreamResponse{Usage: response.Usage})
+	}
+	return chunks
+}
+
+func splitRunes(s string, n int) []string {
+	var parts []string
+	runes := []rune(s)
+	for len(runes) > 0 {
+		size := min(n, len(runes))
+		parts = append(parts, string(runes[:size]))
+		runes = runes[size:]
+	}
+	return parts
+}
+
+type mockStream struct {
+	chunks []openai.ChatCompletionStreamResponse
+	err    error // after the chunks, io.EOF when nil
+}
+
+func (r *mockStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	if len(r.chunks) == 0 {
+		if r.err != nil {
+			return openai.ChatCompletionStreamResponse{}, r.err
+		}
+		return openai.ChatCompletionStreamResponse{}, io.EOF
+	}
+	chunk := r.chunks[0]
+	r.chunks = r.chunks[1:]
+	return chunk, nil
+}
+
+func (r *mockStream) Close() error {
+	return nil
+}
@@ -0,0 +1,102 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"autoglm-go/phoneagent/definitions"
+	"github.com/sashabaranov/go-openai"
+)
+
+const ollamaBaseURL = "http://localhost:11434/api"
+
+// ollamaProvider talks to the native chat API of a local Ollama server.
+type ollamaProvider struct {
+	client  *http.Client
+	baseURL string
+	apiKey  string // for servers behind an authenticating proxy
+}
+
+func newOllamaProvider(cfg *definitions.ModelConfig, client *http.Client) *ollamaProvider {
+	return &ollamaProvider{client: client, baseURL: baseURL(cfg, ollamaBaseURL), apiKey: cfg.APIKey}
+}
+
+type ollamaMessage struct {
+	Role     string   `json:"role"`
+	Content  string   `json:"content"`
+	Thinking string   `json:"thinking,omitempty"`
+	Images   []string `json:"images,omitempty"`
+}
+
+type ollamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Options  struct {
+		Temperature      float32 `json:"temperature"`
+		TopP             float32 `json:"top_p,omitempty"`
+		FrequencyPenalty float32 `json:"frequency_penalty,omitempty"`
+		NumPredict       int     `json:"num_predict,omitempty"`
+	} `json:"options"`
+}
+
+type ollamaResponse struct {
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+	Error           string        `json:"error"`
+}
+
+func (r *ollamaProvider) Stream(ctx context.Context, req openai.ChatCompletionRequest) (ChatStream, error) {
+	body := ollamaRequest{Model: req.Model, Stream: true}
+	body.Options.Temperature = req.Temperature
+	body.Options.TopP = req.TopP
+	body.Options.FrequencyPenalty = req.FrequencyPenalty
+	body.Options.NumPredict = req.MaxCompletionTokens
+
+	for _, msg := range req.Messages {
+		m := ollamaMessage{Role: msg.Role}
+		var text []string
+		for _, part := range messageParts(msg) {
+			if part.Data != "" {
+				m.Images = append(m.Images, part.Data)
+			} else if part.Text != "" {
+				text = append(text, part.Text)
+			}
+		}
+		m.Content = strings.Join(text, "\n")
+		body.Messages = append(body.Messages, m)
+	}
+
+	header := http.Header{}
+	if r.apiKey != "" && r.apiKey != "EMPTY" {
+		header.Set("Authorization", "Bearer "+r.apiKey)
+	}
+	resp, err := postStream(ctx, r.client, r.baseURL+"/chat", header, body)
+	if err != nil {
+		return nil, err
+	}
+
+	return newLineStream(resp.Body, func(line []byte) (*openai.ChatCompletionStreamResponse, error) {
+		var chunk ollamaResponse
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return nil, fmt.Errorf("invalid ollama stream chunk: %w", err)
+		}
+		if chunk.Error != "" {
+			return nil, errors.New(chunk.Error)
+		}
+		result := deltaChunk(chunk.Message.Content, chunk.Message.Thinking)
+		if chunk.Done {
+			if result == nil {
+				result = &openai.ChatCompletionStreamResponse{}
+			}
+			result.Usage = usageChunk(chunk.PromptEvalCount, chunk.EvalCount).Usage
+		}
+		return result, nil
+	}), nil
+}
@@ -0,0 +1,153 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"autoglm-go/phoneagent/helper"
+	logs "github.com/sirupsen/logrus"
+)
+
+// Names of the built-in response parsers.
+const (
+	ParserText = "text" // do(...) and finish(...) calls, the default
+	ParserJSON = "json" // a JSON object such as {"action": "Tap", "element": [500, 100]}
+)
+
+// ResponseParser splits the content of a response into its thinking and its
+// action, for models fine-tuned with another output grammar. Parsers are
+// registered once and shared by all clients.
+type ResponseParser interface {
+	// Markers are where the action starts in the streamed content, the text
+	// before the first one streams as thinking.
+	Markers() []string
+	Parse(content string) ParsedResponse
+}
+
+// ParsedResponse is the content of a response split by a ResponseParser.
+type ParsedResponse struct {
+	Thinking string
+	Action   string        // as the model wrote it, kept in the history
+	Parsed   helper.Action // nil to parse Action with the text syntax
+}
+
+var (
+	responseParsersMu sync.RWMutex
+	responseParsers   = map[string]ResponseParser{
+		ParserText: textParser{},
+		ParserJSON: jsonParser{},
+	}
+)
+
+// RegisterResponseParser adds a named parser for ModelConfig.ResponseParser.
+func RegisterResponseParser(name string, parser ResponseParser) error {
+	if name == "" {
+		return fmt.Errorf("response parser name is required")
+	}
+	responseParsersMu.Lock()
+	defer responseParsersMu.Unlock()
+	if _, ok := responseParsers[name]; ok {
+		return fmt.Errorf("response parser %s already registered", name)
+	}
+	responseParsers[name] = parser
+	return nil
+}
+
+// ResponseParserFor returns the parser registered as name, the text parser
+// when name is empty.
+func ResponseParserFor(name string) (ResponseParser, error) {
+	if name == "" {
+		name = ParserText
+	}
+	responseParsersMu.RLock()
+	defer responseParsersMu.RUnlock()
+	parser, ok := responseParsers[name]
+	if !ok {
+		names := make([]string, 0, len(responseParsers))
+		for n := range responseParsers {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown response parser: %s. Must be one of %s", name, strings.Join(names, ", "))
+	}
+	return parser, nil
+}
+
+// responseParser returns the parser of the config, the text parser with the
+// extra ActionMarkers of the config when it is not registered.
+func (c *ModelClient) responseParser() ResponseParser {
+	parser, err := ResponseParserFor(c.config.ResponseParser)
+	if err != nil {
+		logs.Warnf("%v, using %s", err, ParserText)
+		parser = textParser{}
+	}
+	if text, ok := parser.(textParser); ok {
+		text.extra = c.config.ActionMarkers
+		parser = text
+	}
+	return parser
+}
+
+// textParser parses do(...) and finish(...) calls, see parseResponse. The
+// extra markers introduce an action without being part of it, e.g.
+// "Action:".
+type textParser struct {
+	extra []string
+}
+
+func (p textParser) Markers() []string {
+	return []string{"finish(message=", "do(action="}
+}
+
+func (p textParser) Parse(content string) ParsedResponse {
+	for _, marker := range p.extra {
+		if before, after, ok := strings.Cut(content, marker); ok {
+			thinking, action := parseResponse(after)
+			return ParsedResponse{Thinking: stripThinkTags(before + "\n" + thinking), Action: strings.TrimSpace(action)}
+		}
+	}
+	thinking, action := parseResponse(content)
+	return ParsedResponse{Thinking: thinking, Action: action}
+}
+
+// jsonParser reads the action from the first JSON object of the content,
+// fenced as ```json or not. Its keys are those of the do tool, with
+// {"action": "finish", "message": "..."} to finish. Content without a valid
+// object falls back to the text syntax.
+type jsonParser struct{}
+
+func (jsonParser) Markers() []string {
+	return []string{"```json", `{"action"`}
+}
+
+func (jsonParser) Parse(content string) ParsedResponse {
+	for i := strings.IndexByte(content, '{'); i >= 0; {
+		var args map[string]any
+		decoder := json.NewDecoder(strings.NewReader(content[i:]))
+		if err := decoder.Decode(&args); err == nil {
+			object := strings.TrimSpace(content[i : i+int(decoder.InputOffset())])
+			tool := helper.ToolDo
+			if args["action"] == "finish" {
+				tool = helper.ToolFinish
+			}
+			if action, err := helper.ActionFromToolCall(tool, object); err == nil {
+				thinking := strings.TrimSuffix(strings.TrimSpace(content[:i]), "```json")
+				return ParsedResponse{Thinking: stripThinkTags(thinking), Action: object, Parsed: action}
+			}
+		}
+		next := strings.IndexByte(content[i+1:], '{')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	thinking, action := parseResponse(content)
+	return ParsedResponse{Thinking: thinking, Action: action}
+}
+
+func stripThinkTags(s string) string {
+	return strings.TrimSpace(strings.NewReplacer("<think>", "", "</think>", "").Replace(s))
+}
@@ -0,0 +1,270 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"autoglm-go/phoneagent/definitions"
+	"github.com/sashabaranov/go-openai"
+)
+
+// Providers for ModelConfig.Provider. vLLM, LM Studio and most hosted models
+// speak the OpenAI API and need no provider of their own.
+const (
+	ProviderOpenAI    = "openai"
+	ProviderAnthropic = "anthropic"
+	ProviderGemini    = "gemini"
+	ProviderOllama    = "ollama"
+)
+
+// ModelProvider opens a streamed chat completion on one kind of API. Requests
+// and chunks are in OpenAI form, which is what the agent keeps its history
+// in; other providers translate them. Thinking is streamed as
+// Delta.ReasoningContent when the API returns it separately.
+type ModelProvider interface {
+	Stream(ctx context.Context, req openai.ChatCompletionRequest) (ChatStream, error)
+}
+
+// ChatStream yields the response chunks until io.EOF.
+type ChatStream interface {
+	Recv() (openai.ChatCompletionStreamResponse, error)
+	Close() error
+}
+
+// NewProvider returns the provider named by cfg.Provider, OpenAI when empty.
+// An empty cfg.BaseURL means the provider's public endpoint.
+func NewProvider(cfg *definitions.ModelConfig) (ModelProvider, error) {
+	client, err := httpClientOf(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var provider ModelProvider
+	switch cfg.Provider {
+	case "", ProviderOpenAI:
+		provider = newOpenAIProvider(cfg, client)
+	case ProviderAnthropic:
+		provider = newAnthropicProvider(cfg, client)
+	case ProviderGemini:
+		provider = newGeminiProvider(cfg, client)
+	case ProviderOllama:
+		provider = newOllamaProvider(cfg, client)
+	default:
+		return nil, fmt.Errorf("unknown model provider: %s. Must be 'openai', 'anthropic', 'gemini' or 'ollama'", cfg.Provider)
+	}
+	return withImages(cfg, provider)
+}
+
+type openAIProvider struct {
+	client *openai.Client
+}
+
+func newOpenAIProvider(cfg *definitions.ModelConfig, client *http.Client) *openAIProvider {
+	openaiCfg := openai.DefaultConfig(cfg.APIKey)
+	if cfg.BaseURL != "" {
+		openaiCfg.BaseURL = cfg.BaseURL
+	}
+	openaiCfg.HTTPClient = client
+	return &openAIProvider{client: openai.NewClientWithConfig(openaiCfg)}
+}
+
+func (r *openAIProvider) Stream(ctx context.Context, req openai.ChatCompletionRequest) (ChatStream, error) {
+	return r.client.CreateChatCompletionStream(ctx, req)
+}
+
+func baseURL(cfg *definitions.ModelConfig, fallback string) string {
+	if cfg.BaseURL != "" {
+		return strings.TrimRight(cfg.BaseURL, "/")
+	}
+	return fallback
+}
+
+// postStream sends body as JSON and returns the streaming response. Errors
+// are *openai.APIError so IsImageTooLarge works for every provider.
+func postStream(ctx context.Context, client *http.Client, url string, header http.Header, body any) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	header.Set("Content-Type", "application/json")
+	return post(ctx, client, url, header, data)
+}
+
+// postDecode sends data, such as a file to upload, and decodes the JSON
+// response into v.
+func postDecode(ctx context.Context, client *http.Client, url string, header http.Header, data []byte, v any) error {
+	resp, err := post(ctx, client, url, header, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// post sends data and returns the response when it succeeded, errors are
+// *openai.APIError.
+func post(ctx context.Context, client *http.Client, url string, header http.Header, data []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	return nil, &openai.APIError{
+		HTTPStatusCode: resp.StatusCode,
+		HTTPStatus:     resp.Status,
+		Message:        errorMessage(raw),
+	}
+}
+
+// errorMessage extracts {"error": {"message": ...}} or {"error": "..."}.
+func errorMessage(raw []byte) string {
+	var body struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if json.Unmarshal(raw, &body) == nil && len(body.Error) > 0 {
+		var text string
+		if json.Unmarshal(body.Error, &text) == nil {
+			return text
+		}
+		var detail struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body.Error, &detail) == nil && detail.Message != "" {
+			return detail.Message
+		}
+	}
+	return strings.TrimSpace(string(raw))
+}
+
+// lineStream turns the lines of a response body into chunks. parse returns
+// nil for lines without content and io.EOF at the end of the response.
+type lineStream struct {
+	body    io.ReadCloser
+	scanner *bufio.Scanner
+	parse   func(line []byte) (*openai.ChatCompletionStreamResponse, error)
+}
+
+func newLineStream(body io.ReadCloser, parse func(line []byte) (*openai.ChatCompletionStreamResponse, error)) *lineStream {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64<<10), 4<<20)
+	return &lineStream{body: body, scanner: scanner, parse: parse}
+}
+
+func (r *lineStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	for r.scanner.Scan() {
+		line := bytes.TrimSpace(r.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		chunk, err := r.parse(line)
+		if err != nil {
+			return openai.ChatCompletionStreamResponse{}, err
+		}
+		if chunk != nil {
+			return *chunk, nil
+		}
+	}
+	if err := r.scanner.Err(); err != nil {
+		return openai.ChatCompletionStreamResponse{}, err
+	}
+	return openai.ChatCompletionStreamResponse{}, io.EOF
+}
+
+func (r *lineStream) Close() error {
+	return r.body.Close()
+}
+
+// sseData returns the payload of a server-sent "data:" line, nil for other
+// lines such as "event:".
+func sseData(line []byte) []byte {
+	data, ok := bytes.CutPrefix(line, []byte("data:"))
+	if !ok {
+		return nil
+	}
+	return bytes.TrimSpace(data)
+}
+
+// deltaChunk is a chunk with streamed text, thinking or both.
+func deltaChunk(content, reasoning string) *openai.ChatCompletionStreamResponse {
+	if content == "" && reasoning == "" {
+		return nil
+	}
+	return &openai.ChatCompletionStreamResponse{
+		Choices: []openai.ChatCompletionStreamChoice{{
+			Delta: openai.ChatCompletionStreamChoiceDelta{Content: content, ReasoningContent: reasoning},
+		}},
+	}
+}
+
+func usageChunk(prompt, completion int) *openai.ChatCompletionStreamResponse {
+	return &openai.ChatCompletionStreamResponse{
+		Usage: &openai.Usage{
+			PromptTokens:     prompt,
+			CompletionTokens: completion,
+			TotalTokens:      prompt + completion,
+		},
+	}
+}
+
+// contentPart is a text or an image of a message, in order.
+type contentPart struct {
+	Text     string
+	MimeType string
+	Data     string // base64 image data, empty for text
+	URL      string // of an image sent by URL instead, see ImageURL
+	FileID   string // of an image uploaded with the file API instead, see ImageFile
+}
+
+// messageParts splits an OpenAI message into text and images. Images are
+// data URLs, unless sent by URL or as files for ImageConfig.Transport.
+func messageParts(msg openai.ChatCompletionMessage) []contentPart {
+	if len(msg.MultiContent) == 0 {
+		return []contentPart{{Text: msg.Content}}
+	}
+	var parts []contentPart
+	for _, p := range msg.MultiContent {
+		switch {
+		case p.Type == openai.ChatMessagePartTypeText:
+			parts = append(parts, contentPart{Text: p.Text})
+		case p.Type == openai.ChatMessagePartTypeImageURL && p.ImageURL != nil:
+			url := p.ImageURL.URL
+			if mimeType, data, ok := parseDataURL(url); ok {
+				parts = append(parts, contentPart{MimeType: mimeType, Data: data})
+			} else if id, ok := strings.CutPrefix(url, fileRefPrefix); ok {
+				parts = append(parts, contentPart{FileID: id})
+			} else if strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "http://") {
+				parts = append(parts, contentPart{MimeType: urlMimeType(url), URL: url})
+			}
+		}
+	}
+	return parts
+}
+
+// parseDataURL splits data:<mime>;base64,<data>.
+func parseDataURL(url string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(url, "data:")
+	if !ok {
+		return "", "", false
+	}
+	meta, data, ok := strings.Cut(rest, ",")
+	mimeType, isBase64 := strings.CutSuffix(meta, ";base64")
+	if !ok || !isBase64 {
+		return "", "", false
+	}
+	return mimeType, data, true
+}
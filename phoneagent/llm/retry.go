@@ -0,0 +1,167 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"autoglm-go/phoneagent/definitions"
+	"autoglm-go/phoneagent/metrics"
+	"github.com/sashabaranov/go-openai"
+	logs "github.com/sirupsen/logrus"
+)
+
+// target is a model the client can send a request to: the primary one or a
+// fallback.
+type target struct {
+	model    string
+	provider ModelProvider
+}
+
+func newFallbackTargets(cfg *definitions.ModelConfig) []target {
+	targets := make([]target, 0, len(cfg.Fallbacks))
+	for _, fallbackCfg := range newFallbackConfigs(cfg) {
+		provider, err := NewProvider(fallbackCfg)
+		if err != nil {
+			provider = failingProvider{err}
+		}
+		targets = append(targets, target{model: fallbackCfg.ModelName, provider: provider})
+	}
+	return targets
+}
+
+// newFallbackConfigs returns the configs of the fallback models of cfg.
+func newFallbackConfigs(cfg *definitions.ModelConfig) []*definitions.ModelConfig {
+	configs := make([]*definitions.ModelConfig, 0, len(cfg.Fallbacks))
+	for _, fallback := range cfg.Fallbacks {
+		fallbackCfg := *cfg
+		fallbackCfg.ModelName = fallback.Model
+		if fallback.BaseURL != "" {
+			fallbackCfg.BaseURL = fallback.BaseURL
+		}
+		if fallback.APIKey != "" {
+			fallbackCfg.APIKey = fallback.APIKey
+		}
+		if fallback.Provider != "" {
+			fallbackCfg.Provider = fallback.Provider
+		}
+		configs = append(configs, &fallbackCfg)
+	}
+	return configs
+}
+
+// IsTransient reports whether a failed request may succeed when sent again:
+// rate limits, server errors and network failures.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if errors.Is(err, ErrRateLimited) {
+		return true
+	}
+	if status := httpStatus(err); status != 0 {
+		return status == http.StatusTooManyRequests || status == http.StatusRequestTimeout || status >= 500
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, keyword := range []string{"connection reset", "connection refused", "broken pipe", "eof"} {
+		if strings.Contains(msg, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff is the wait after the given failed attempt, counted from 1.
+func backoff(policy definitions.RetryPolicy, attempt int) time.Duration {
+	delay := policy.InitialBackoff
+	for i := 1; i < attempt && (policy.MaxBackoff <= 0 || delay < policy.MaxBackoff); i++ {
+		delay *= 2
+	}
+	if policy.MaxBackoff > 0 && delay > policy.MaxBackoff {
+		delay = policy.MaxBackoff
+	}
+	if policy.Jitter > 0 {
+		delay = time.Duration(float64(delay) * (1 + policy.Jitter*(2*rand.Float64()-1)))
+	}
+	return delay
+}
+
+// openStream sends req to the primary model, then to the fallbacks, retrying
+// transient failures of each with backoff. The first chunk is received here
+// too, so errors reported at the start of the stream are retried as well;
+// once output has been delivered nothing is retried. model is the one that
+// answered and opened is when its stream was opened.
+func (c *ModelClient) openStream(ctx context.Context, req openai.ChatCompletionRequest) (stream ChatStream, model string, opened time.Time, err error) {
+	attempts := max(c.config.Retry.MaxAttempts, 1)
+	targets := append([]target{{model: c.config.ModelName, provider: c.provider}}, c.fallbacks...)
+
+	for i, t := range targets {
+		if i > 0 {
+			logs.Warnf("model %s failed, falling back to %s, err: %v", targets[i-1].model, t.model, err)
+		}
+		req.Model = t.model
+		for attempt := 1; attempt <= attempts; attempt++ {
+			stream, opened, err = openOnce(ctx, t.provider, req)
+			err = classify(err)
+			if err == nil {
+				return stream, t.model, opened, nil
+			}
+			if !IsTransient(err) {
+				return nil, "", opened, err
+			}
+			if attempt == attempts {
+				break
+			}
+			delay := backoff(c.config.Retry, attempt)
+			metrics.ModelRetries.Inc(t.model)
+			logs.Warnf("model request failed (attempt %d/%d), retrying in %s, err: %v", attempt, attempts, delay.Round(time.Millisecond), err)
+			select {
+			case <-ctx.Done():
+				return nil, "", opened, ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+	}
+	return nil, "", opened, err
+}
+
+func openOnce(ctx context.Context, provider ModelProvider, req openai.ChatCompletionRequest) (ChatStream, time.Time, error) {
+	stream, err := provider.Stream(ctx, req)
+	opened := time.Now()
+	if err != nil {
+		return nil, opened, err
+	}
+	first, err := stream.Recv()
+	if err != nil && !errors.Is(err, io.EOF) {
+		stream.Close()
+		return nil, opened, err
+	}
+	return &peekedStream{ChatStream: stream, first: first, firstErr: err, pending: true}, opened, nil
+}
+
+// peekedStream replays the chunk received by openOnce.
+type peekedStream struct {
+	ChatStream
+	first    openai.ChatCompletionStreamResponse
+	firstErr error
+	pending  bool
+}
+
+func (r *peekedStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	if r.pending {
+		r.pending = false
+		return r.first, r.firstErr
+	}
+	return r.ChatStream.Recv()
+}
@@ -0,0 +1,166 @@
+package llm
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"autoglm-go/phoneagent/definitions"
+	"autoglm-go/phoneagent/labels"
+	"autoglm-go/phoneagent/tracing"
+)
+
+// sharedHTTPClient is used by every ModelClient so that requests from all
+// device sessions reuse pooled connections, multiplexed over HTTP/2 when the
+// provider supports it, instead of each client dialing its own.
+var sharedHTTPClient = &http.Client{
+	Transport: labelTransport{newTransport()},
+}
+
+func newTransport() *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          256,
+		MaxIdleConnsPerHost:   64,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// httpClients are the clients of the configs with their own proxy or TLS
+// settings, shared by the configs with the same ones.
+var (
+	httpClientsMu sync.Mutex
+	httpClients   = map[string]*http.Client{}
+)
+
+// httpClientOf returns the client sending the requests of cfg.
+func httpClientOf(cfg *definitions.ModelConfig) (*http.Client, error) {
+	h := cfg.HTTP
+	if h.Client != nil {
+		client := *h.Client
+		if _, ok := client.Transport.(labelTransport); !ok {
+			base := client.Transport
+			if base == nil {
+				base = http.DefaultTransport
+			}
+			client.Transport = labelTransport{base}
+		}
+		return &client, nil
+	}
+	if h.Proxy == "" && h.CAFile == "" && !h.InsecureSkipVerify {
+		return sharedHTTPClient, nil
+	}
+
+	key := fmt.Sprintf("%s|%s|%t", h.Proxy, h.CAFile, h.InsecureSkipVerify)
+	httpClientsMu.Lock()
+	defer httpClientsMu.Unlock()
+	if client, ok := httpClients[key]; ok {
+		return client, nil
+	}
+
+	transport := newTransport()
+	if h.Proxy != "" {
+		proxy, err := url.Parse(h.Proxy)
+		if err != nil || proxy.Host == "" {
+			return nil, fmt.Errorf("invalid model proxy %q, want e.g. http://proxy:3128", h.Proxy)
+		}
+		switch proxy.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported model proxy scheme %q, must be 'http', 'https' or 'socks5'", proxy.Scheme)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	if h.CAFile != "" || h.InsecureSkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: h.InsecureSkipVerify}
+	}
+	if h.CAFile != "" {
+		pem, err := os.ReadFile(h.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read model CA file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in model CA file %s", h.CAFile)
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	client := &http.Client{Transport: labelTransport{transport}}
+	httpClients[key] = client
+	return client, nil
+}
+
+type headersKey struct{}
+
+// WithHeaders returns ctx sending headers with the model requests made with
+// it, such as a trace id or the key of a tenant, on top of those already in
+// it.
+func WithHeaders(ctx context.Context, headers map[string]string) context.Context {
+	if len(headers) == 0 {
+		return ctx
+	}
+	merged := maps.Clone(headersFrom(ctx))
+	if merged == nil {
+		merged = map[string]string{}
+	}
+	maps.Copy(merged, headers)
+	return context.WithValue(ctx, headersKey{}, merged)
+}
+
+// withDefaultHeaders returns ctx sending headers, unless it sets them already.
+func withDefaultHeaders(ctx context.Context, headers map[string]string) context.Context {
+	if len(headers) == 0 {
+		return ctx
+	}
+	merged := maps.Clone(headers)
+	maps.Copy(merged, headersFrom(ctx))
+	return context.WithValue(ctx, headersKey{}, merged)
+}
+
+func headersFrom(ctx context.Context) map[string]string {
+	headers, _ := ctx.Value(headersKey{}).(map[string]string)
+	return headers
+}
+
+// labelTransport sends the task labels of the request context as headers,
+// so an LLM gateway can attribute the calls, see labels.HeaderPrefix, the
+// W3C traceparent of its span when it is traced, and the headers of
+// WithHeaders.
+type labelTransport struct {
+	base http.RoundTripper
+}
+
+func (t labelTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	l, traceparent, headers := labels.From(ctx), tracing.Traceparent(ctx), headersFrom(ctx)
+	if len(l) > 0 || traceparent != "" || len(headers) > 0 {
+		req = req.Clone(ctx)
+		l.SetHeaders(req.Header)
+		if traceparent != "" {
+			req.Header.Set("traceparent", traceparent)
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+	}
+	return t.base.RoundTrip(req)
+}
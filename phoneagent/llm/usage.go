@@ -0,0 +1,150 @@
+package llm
+
+import (
+	"cmp"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"autoglm-go/phoneagent/definitions"
+	"github.com/sashabaranov/go-openai"
+	logs "github.com/sirupsen/logrus"
+)
+
+// unpricedModels are the models missing from the price catalog already
+// warned about.
+var unpricedModels sync.Map
+
+// cost estimates the price of usage on model, 0 when it is not reported.
+func (c *ModelClient) cost(model string, usage *openai.Usage) float64 {
+	if usage == nil {
+		return 0
+	}
+	price, ok := c.config.Pricing[model]
+	if !ok && c.config.PriceCatalog != nil {
+		if price, ok = c.config.PriceCatalog.Price(cmp.Or(c.config.Provider, "openai"), model); !ok {
+			if _, warned := unpricedModels.LoadOrStore(model, true); !warned {
+				logs.Warnf("💲 no price for model %s in the pricing catalog, counting %g per 1000 tokens", model, c.config.CostPer1K)
+			}
+		}
+	}
+	if !ok {
+		price = definitions.ModelPrice{Prompt: c.config.CostPer1K, Completion: c.config.CostPer1K}
+	}
+	return (float64(usage.PromptTokens)*price.Prompt + float64(usage.CompletionTokens)*price.Completion) / 1000
+}
+
+// ModelUsage adds up the requests of one model, or of all of them.
+type ModelUsage struct {
+	Requests         int
+	Unreported       int // requests without usage in the stream
+	PromptTokens     int
+	CompletionTokens int
+	Cost             float64
+}
+
+func (u ModelUsage) TotalTokens() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+// Add adds the usage of other to u.
+func (u *ModelUsage) Add(other ModelUsage) {
+	u.Requests += other.Requests
+	u.Unreported += other.Unreported
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.Cost += other.Cost
+}
+
+// UsageMeter accumulates the token usage and cost of the responses of a
+// session, per model. It is safe for concurrent use.
+type UsageMeter struct {
+	mu       sync.Mutex
+	models   map[string]*ModelUsage
+	draining sync.WaitGroup // responses returned early, see RequestOptions.Drained
+}
+
+func NewUsageMeter() *UsageMeter {
+	return &UsageMeter{models: map[string]*ModelUsage{}}
+}
+
+// Add records one response.
+func (r *UsageMeter) Add(response *ModelResponse) {
+	entry := ModelUsage{Requests: 1, Cost: response.Cost}
+	if response.Usage == nil {
+		entry.Unreported = 1
+	} else {
+		entry.PromptTokens = response.Usage.PromptTokens
+		entry.CompletionTokens = response.Usage.CompletionTokens
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	usage, ok := r.models[response.Model]
+	if !ok {
+		usage = &ModelUsage{}
+		r.models[response.Model] = usage
+	}
+	usage.Add(entry)
+}
+
+// Wait waits for the usage of the responses returned early, recorded once the
+// rest of their streams is read.
+func (r *UsageMeter) Wait() {
+	r.draining.Wait()
+}
+
+// Models returns the usage per model name.
+func (r *UsageMeter) Models() map[string]ModelUsage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	models := make(map[string]ModelUsage, len(r.models))
+	for name, usage := range r.models {
+		models[name] = *usage
+	}
+	return models
+}
+
+// Total returns the usage of all models.
+func (r *UsageMeter) Total() ModelUsage {
+	var total ModelUsage
+	for _, usage := range r.Models() {
+		total.Add(usage)
+	}
+	return total
+}
+
+// Summary describes the usage so far, per model when there are several.
+func (r *UsageMeter) Summary() string {
+	models := r.Models()
+	names := make([]string, 0, len(models))
+	for name := range models {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var total ModelUsage
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		usage := models[name]
+		total.Add(usage)
+		parts = append(parts, fmt.Sprintf("%s %d requests/%d tokens", name, usage.Requests, usage.TotalTokens()))
+	}
+	summary := fmt.Sprintf("%d tokens (%d prompt, %d completion), cost %.4f",
+		total.TotalTokens(), total.PromptTokens, total.CompletionTokens, total.Cost)
+	if len(parts) > 1 {
+		summary += "; " + strings.Join(parts, ", ")
+	}
+	if total.Unreported > 0 {
+		summary += fmt.Sprintf("; %d requests without usage reported", total.Unreported)
+	}
+	return summary
+}
+
+func (r *UsageMeter) Reset() {
+	r.Wait()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.models = map[string]*ModelUsage{}
+}
@@ -0,0 +1,164 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"autoglm-go/phoneagent/definitions"
+	logs "github.com/sirupsen/logrus"
+)
+
+// ErrUnauthorized is returned by Warm when an endpoint rejects its API key.
+var ErrUnauthorized = errors.New("invalid API key")
+
+// probeTimeout bounds a probe of an endpoint.
+const probeTimeout = 10 * time.Second
+
+const openAIBaseURL = "https://api.openai.com/v1"
+
+// endpoint is a model API and the key it is called with.
+type endpoint struct {
+	provider string
+	url      string // of its list of models
+	header   http.Header
+	client   *http.Client
+	err      error // of the HTTP settings of the endpoint
+}
+
+func (e endpoint) key() string {
+	return e.provider + " " + e.url + " " + fmt.Sprint(e.header)
+}
+
+// endpointOf returns the list of models of the API of cfg, a cheap request
+// that needs the same credentials as a chat completion.
+func endpointOf(cfg *definitions.ModelConfig) endpoint {
+	header := http.Header{}
+	for k, v := range cfg.HTTP.Headers {
+		header.Set(k, v)
+	}
+	e := endpoint{provider: cfg.Provider, header: header}
+	e.client, e.err = httpClientOf(cfg)
+	switch cfg.Provider {
+	case ProviderAnthropic:
+		e.url = baseURL(cfg, anthropicBaseURL) + "/models"
+		header.Set("x-api-key", cfg.APIKey)
+		header.Set("anthropic-version", anthropicVersion)
+	case ProviderGemini:
+		e.url = baseURL(cfg, geminiBaseURL) + "/models"
+		header.Set("x-goog-api-key", cfg.APIKey)
+	case ProviderOllama:
+		e.url = baseURL(cfg, ollamaBaseURL) + "/tags"
+		if cfg.APIKey != "" {
+			header.Set("Authorization", "Bearer "+cfg.APIKey)
+		}
+	default:
+		e.url = baseURL(cfg, openAIBaseURL) + "/models"
+		header.Set("Authorization", "Bearer "+cfg.APIKey)
+	}
+	return e
+}
+
+// endpointsOf returns the distinct endpoints of configs and their fallbacks.
+func endpointsOf(configs []*definitions.ModelConfig) []endpoint {
+	var endpoints []endpoint
+	seen := map[string]bool{}
+	add := func(cfg *definitions.ModelConfig) {
+		if e := endpointOf(cfg); !seen[e.key()] {
+			seen[e.key()] = true
+			endpoints = append(endpoints, e)
+		}
+	}
+	for _, cfg := range configs {
+		add(cfg)
+		for _, fallback := range newFallbackConfigs(cfg) {
+			add(fallback)
+		}
+	}
+	return endpoints
+}
+
+// probe sends the request of e through the client of the model requests,
+// which leaves a pooled connection for those that follow. An endpoint
+// without a list of models, as some OpenAI compatible servers are, still
+// counts as reachable.
+func (e endpoint) probe(ctx context.Context) error {
+	if e.err != nil {
+		return e.err
+	}
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header = e.header.Clone()
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("%w for %s: %s %s", ErrUnauthorized, e.url, resp.Status, errorMessage(raw))
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return fmt.Errorf("%s answered %s", e.url, resp.Status)
+	}
+	return nil
+}
+
+// Warm opens a connection to the endpoint of each of configs, and of their
+// fallbacks, and checks its credentials, so that the first step of a task
+// does not wait for the TLS handshake. It returns an ErrUnauthorized for the
+// first endpoint rejecting its key; the ones that cannot be reached are only
+// logged, the retries of the requests may still get through.
+func Warm(ctx context.Context, configs ...*definitions.ModelConfig) error {
+	endpoints := endpointsOf(configs)
+	errs := make([]error, len(endpoints))
+	var wg sync.WaitGroup
+	for i, e := range endpoints {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = e.probe(ctx)
+		}()
+	}
+	wg.Wait()
+	for i, err := range errs {
+		switch {
+		case errors.Is(err, ErrUnauthorized):
+			return err
+		case err != nil:
+			logs.Warnf("🔥 failed to warm up %s, err: %v", endpoints[i].url, err)
+		default:
+			logs.Debugf("🔥 %s is warm", endpoints[i].url)
+		}
+	}
+	return nil
+}
+
+// KeepWarm probes the endpoints of configs every interval until ctx ends,
+// so that their pooled connections are not closed as idle between tasks
+// and a revoked key shows up in the logs before a task fails on it.
+func KeepWarm(ctx context.Context, interval time.Duration, configs ...*definitions.ModelConfig) {
+	endpoints := endpointsOf(configs)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, e := range endpoints {
+			if err := e.probe(ctx); err != nil && ctx.Err() == nil {
+				logs.Warnf("🔥 failed to keep %s warm, err: %v", e.url, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package phoneagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"autoglm-go/phoneagent/definitions"
+	"autoglm-go/phoneagent/vault"
+)
+
+// ErrDeviceLocked is returned when a task starts on a locked device that
+// cannot be unlocked.
+var ErrDeviceLocked = errors.New("device is locked")
+
+// ScreenLocker is implemented by devices that can tell and change the lock
+// state of their screen. Tasks on other devices start without the check.
+type ScreenLocker interface {
+	ScreenState(ctx context.Context, deviceID string) (definitions.ScreenState, error)
+	WakeScreen(ctx context.Context, deviceID string) error
+	Unlock(ctx context.Context, deviceID string, credential definitions.UnlockCredential) error
+}
+
+// ensureUnlocked wakes the screen and, when AgentConfig.AutoUnlock allows it,
+// unlocks the device, so that the first observation shows the device and not
+// a black or lock screen.
+func (r *PhoneAgent) ensureUnlocked(ctx context.Context) error {
+	locker, ok := r.Device.(ScreenLocker)
+	if !ok {
+		return nil
+	}
+	deviceID := r.AgentConfig.DeviceID
+	state, err := locker.ScreenState(ctx, deviceID)
+	if err != nil {
+		r.log().Warnf("failed to read the screen state of %s, err: %v", deviceID, err)
+		return nil
+	}
+	if !state.Awake {
+		r.log().Infof("🔅 screen of %s is off, waking it", deviceID)
+		if err := locker.WakeScreen(ctx, deviceID); err != nil {
+			return fmt.Errorf("failed to wake the screen: %w", err)
+		}
+	}
+	if !state.Locked {
+		return nil
+	}
+	if r.AgentConfig.ReadOnly {
+		r.log().Infof("🔒 %s is locked, read-only tasks observe the lock screen", deviceID)
+		return nil
+	}
+	if !r.AgentConfig.AutoUnlock {
+		return fmt.Errorf("%w: %s, unlock it or enable auto unlock", ErrDeviceLocked, deviceID)
+	}
+
+	// a swipe lock has no credential, only secure locks need one
+	var credential definitions.UnlockCredential
+	if r.AgentConfig.VaultFile != "" {
+		v, err := vault.Load(r.AgentConfig.VaultFile)
+		if err != nil {
+			return fmt.Errorf("%w: %s, failed to read the vault: %v", ErrDeviceLocked, deviceID, err)
+		}
+		if secret, ok := v.Get("unlock:"+deviceID, "unlock"); ok {
+			if credential, err = definitions.ParseUnlockCredential(secret); err != nil {
+				return fmt.Errorf("%w: %s, %v", ErrDeviceLocked, deviceID, err)
+			}
+		}
+	}
+
+	r.log().Infof("🔓 unlocking %s", deviceID)
+	if err := locker.Unlock(ctx, deviceID, credential); err != nil {
+		return fmt.Errorf("%w: %s, unlock failed: %v", ErrDeviceLocked, deviceID, err)
+	}
+	if state, err = locker.ScreenState(ctx, deviceID); err == nil && state.Locked {
+		if credential.Kind == "" {
+			return fmt.Errorf("%w: %s has a secure lock and the vault has no unlock credential for it", ErrDeviceLocked, deviceID)
+		}
+		return fmt.Errorf("%w: %s is still locked, check its %s in the vault", ErrDeviceLocked, deviceID, credential.Kind)
+	}
+	return nil
+}
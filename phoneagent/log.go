@@ -0,0 +1,38 @@
+package phoneagent
+
+import (
+	"context"
+
+	"autoglm-go/phoneagent/labels"
+	"autoglm-go/phoneagent/logging"
+	logs "github.com/sirupsen/logrus"
+)
+
+// log is the logger of the running task, see logFor.
+func (r *PhoneAgent) log() *logs.Entry {
+	return r.logFor(r.taskCtx)
+}
+
+// logFor returns a logger whose records carry the labels of ctx, and the
+// session, step and device of the agent that correlate them in the
+// structured logs.
+func (r *PhoneAgent) logFor(ctx context.Context) *logs.Entry {
+	return logs.WithFields(r.logFields(ctx))
+}
+
+func (r *PhoneAgent) logFields(ctx context.Context) logs.Fields {
+	fields := logs.Fields{}
+	if ctx != nil {
+		fields = labels.From(ctx).Fields()
+	}
+	if r.SessionID != "" {
+		fields[logging.FieldSession] = r.SessionID
+	}
+	if r.StepCount > 0 {
+		fields[logging.FieldStep] = r.StepCount
+	}
+	if r.AgentConfig.DeviceID != "" {
+		fields[logging.FieldDevice] = r.AgentConfig.DeviceID
+	}
+	return fields
+}
@@ -0,0 +1,149 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	logs "github.com/sirupsen/logrus"
+)
+
+// correlation fields are left out of the text logs, a terminal shows one task
+var correlation = map[string]bool{FieldComponent: true, FieldSession: true, FieldStep: true, FieldDevice: true, FieldEvent: true}
+
+// TextFormatter writes the message of a record, followed by its fields other
+// than the correlation ones, such as the labels of the task, sorted by key.
+type TextFormatter struct{}
+
+func (f *TextFormatter) Format(entry *logs.Entry) ([]byte, error) {
+	keys := make([]string, 0, len(entry.Data))
+	for k := range entry.Data {
+		if !correlation[k] {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return []byte(entry.Message + "\n"), nil // 只返回消息 + 换行符
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	sb.WriteString(entry.Message)
+	for _, k := range keys {
+		sb.WriteString(fmt.Sprintf(" %s=%v", k, entry.Data[k]))
+	}
+	sb.WriteString("\n")
+	return []byte(sb.String()), nil
+}
+
+// JSONFormatter writes a record as one JSON object per line: time, level,
+// component, msg and its fields.
+type JSONFormatter struct{}
+
+func (f *JSONFormatter) Format(entry *logs.Entry) ([]byte, error) {
+	record := make(map[string]any, len(entry.Data)+4)
+	for k, v := range entry.Data {
+		if err, ok := v.(error); ok {
+			v = err.Error() // errors marshal as {}
+		}
+		record[k] = v
+	}
+	record["time"] = entry.Time.Format(time.RFC3339Nano)
+	record["level"] = entry.Level.String()
+	record["msg"] = strings.TrimRight(entry.Message, "\n")
+	if component := componentOf(entry); component != "" {
+		record[FieldComponent] = component
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal log record: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// componentOf is the FieldComponent of entry, else the last element of the
+// package that logged it, agent for the phoneagent package itself.
+func componentOf(entry *logs.Entry) string {
+	if component, ok := entry.Data[FieldComponent].(string); ok {
+		return component
+	}
+	if entry.Caller == nil {
+		return ""
+	}
+	// autoglm-go/phoneagent/android.(*ADBDevice).Tap
+	fn := entry.Caller.Function
+	pkg := fn[strings.LastIndex(fn, "/")+1:]
+	if i := strings.Index(pkg, "."); i >= 0 {
+		pkg = pkg[:i]
+	}
+	if pkg == "phoneagent" {
+		return "agent"
+	}
+	return pkg
+}
+
+// Levels are the least severe level logged per component, and for the
+// components not listed.
+type Levels struct {
+	Default    logs.Level
+	Components map[string]logs.Level
+}
+
+// ParseLevels reads levels written as a default level and component=level
+// pairs separated by commas, e.g. info,llm=debug,android=warn. Empty is info.
+func ParseLevels(s string) (Levels, error) {
+	lv := Levels{Default: logs.InfoLevel}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		component, name, ok := strings.Cut(part, "=")
+		if !ok {
+			component, name = "", part
+		}
+		level, err := logs.ParseLevel(strings.TrimSpace(name))
+		if err != nil {
+			return Levels{}, fmt.Errorf("invalid log level %q: %w", part, err)
+		}
+		if component = strings.TrimSpace(component); component == "" {
+			lv.Default = level
+			continue
+		}
+		if lv.Components == nil {
+			lv.Components = map[string]logs.Level{}
+		}
+		lv.Components[component] = level
+	}
+	return lv, nil
+}
+
+func (lv Levels) of(component string) logs.Level {
+	if level, ok := lv.Components[component]; ok {
+		return level
+	}
+	return lv.Default
+}
+
+// max is the most verbose of the levels, logrus drops what is above it.
+func (lv Levels) max() logs.Level {
+	level := lv.Default
+	for _, l := range lv.Components {
+		level = max(level, l)
+	}
+	return level
+}
+
+// levelFilter drops the records above the level of their component.
+type levelFilter struct {
+	logs.Formatter
+	levels Levels
+}
+
+func (f *levelFilter) Format(entry *logs.Entry) ([]byte, error) {
+	if entry.Level > f.levels.of(componentOf(entry)) {
+		return nil, nil
+	}
+	return f.Formatter.Format(entry)
+}
@@ -0,0 +1,119 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+
+	logs "github.com/sirupsen/logrus"
+)
+
+// Fields of the records that correlate them, beside the labels of the task.
+const (
+	FieldComponent = "component"  // package that logged, see componentOf
+	FieldSession   = "session_id" // session of the task
+	FieldStep      = "step"       // step number of the task
+	FieldDevice    = "device_id"
+	FieldEvent     = "event_type" // kind of record, such as thinking or action
+)
+
+// Formats of the logs, for Setup.
+const (
+	FormatText = "text" // the message, with the labels of the task
+	FormatJSON = "json" // one object per line with all the fields
+)
+
+var (
+	structured atomic.Bool
+	// base is the formatter of Setup, before the levels of the components
+	base logs.Formatter = &TextFormatter{}
+)
+
+// Structured reports whether the logs are written as JSON, for the output
+// that is not meant for a terminal then, such as rules and streamed thinking.
+func Structured() bool {
+	return structured.Load()
+}
+
+// Setup makes logrus write records in format to out, at the levels given as
+// in ParseLevels.
+func Setup(out io.Writer, format, levels string) error {
+	lv, err := ParseLevels(levels)
+	if err != nil {
+		return err
+	}
+	var formatter logs.Formatter
+	switch format {
+	case "", FormatText:
+		formatter = &TextFormatter{}
+	case FormatJSON:
+		formatter = &JSONFormatter{}
+	default:
+		return fmt.Errorf("invalid log format %q, want %s or %s", format, FormatText, FormatJSON)
+	}
+	structured.Store(format == FormatJSON)
+	base = formatter
+	logs.SetOutput(out)
+	useLevels(lv)
+	return nil
+}
+
+// SetLevels changes the levels of the logs, as in ParseLevels, keeping the
+// format of Setup.
+func SetLevels(levels string) error {
+	lv, err := ParseLevels(levels)
+	if err != nil {
+		return err
+	}
+	useLevels(lv)
+	return nil
+}
+
+func useLevels(lv Levels) {
+	// the component of a record is its caller's package
+	logs.SetReportCaller(Structured() || len(lv.Components) > 0)
+	formatter := base
+	if len(lv.Components) > 0 {
+		formatter = &levelFilter{Formatter: base, levels: lv}
+	}
+	logs.SetFormatter(formatter)
+	logs.SetLevel(lv.max())
+}
+
+type contextKey struct{}
+
+// With returns ctx carrying fields on top of those already in it, for the
+// records of From.
+func With(ctx context.Context, fields logs.Fields) context.Context {
+	merged := logs.Fields{}
+	for k, v := range fromContext(ctx) {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, contextKey{}, merged)
+}
+
+// From returns a logger whose records carry the fields of ctx.
+func From(ctx context.Context) *logs.Entry {
+	return logs.WithFields(fromContext(ctx))
+}
+
+func fromContext(ctx context.Context) logs.Fields {
+	if ctx == nil {
+		return nil
+	}
+	fields, _ := ctx.Value(contextKey{}).(logs.Fields)
+	return fields
+}
+
+// Rule logs a line of 50 char to set apart the parts of a step on a
+// terminal, nothing in the structured logs.
+func Rule(char string) {
+	if !Structured() {
+		logs.Info(strings.Repeat(char, 50))
+	}
+}
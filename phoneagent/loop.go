@@ -0,0 +1,52 @@
+package phoneagent
+
+import (
+	"errors"
+	"fmt"
+
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/imaging"
+)
+
+// ErrStuckLoop is returned when the model took the same action on the same
+// screen AgentConfig.MaxRepeats times in a row.
+var ErrStuckLoop = errors.New("stuck in a loop")
+
+// repeatedStep is the last action of the model and how many steps in a row
+// took it on the screen of hash.
+type repeatedStep struct {
+	hash   uint64
+	action string
+	count  int
+}
+
+// trackRepeat counts the steps in a row taking action on the screen of
+// screenshotData, as perceptual hashes tell it, to stop a model that makes
+// no progress. Steps waiting for the user do not count.
+func (r *PhoneAgent) trackRepeat(screenshotData []byte, action helper.Action) {
+	if r.AgentConfig.MaxRepeats <= 0 || len(screenshotData) == 0 || r.humanWaited {
+		r.repeated = repeatedStep{}
+		return
+	}
+	hash, err := imaging.DHashData(screenshotData)
+	if err != nil {
+		r.repeated = repeatedStep{}
+		return
+	}
+	formatted := helper.FormatAction(action)
+	if p := &r.repeated; p.count > 0 && p.action == formatted && imaging.HashDistance(hash, p.hash) <= unchangedDistance {
+		p.count++
+		return
+	}
+	r.repeated = repeatedStep{hash: hash, action: formatted, count: 1}
+}
+
+// stuckLoop returns an ErrStuckLoop naming the repeated action once it was
+// taken AgentConfig.MaxRepeats times in a row on the same screen.
+func (r *PhoneAgent) stuckLoop() error {
+	limit := r.AgentConfig.MaxRepeats
+	if limit <= 0 || r.repeated.count < limit {
+		return nil
+	}
+	return fmt.Errorf("%w: %s taken %d times in a row on an unchanged screen", ErrStuckLoop, r.repeated.action, r.repeated.count)
+}
@@ -0,0 +1,142 @@
+package phoneagent
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/utils"
+)
+
+// agentStepPrefix starts a macro line the model carries out.
+const agentStepPrefix = "agent:"
+
+// Macro is a script of fixed actions and instructions for the model, one per
+// line, run by PhoneAgent.RunMacro:
+//
+//	# blank lines and lines starting with # are skipped
+//	do(action="Launch", app="京东")
+//	do(action="Wait", duration="2 seconds")
+//	agent: 找到最便宜的商品并加入购物车
+//	do(action="Tap", element=[500, 920])
+//	finish(message="已加入购物车")
+//
+// Actions are written as the model writes them, see helper.ParseAction, so
+// those of a log or a record can be pasted in.
+type Macro struct {
+	Name  string
+	Steps []MacroStep
+}
+
+// MacroStep is a line of a macro: an Action, or else an Instruction.
+type MacroStep struct {
+	Line        int
+	Action      helper.Action
+	Instruction string
+}
+
+// LoadMacro reads the macro at path.
+func LoadMacro(path string) (*Macro, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	macro, err := ParseMacro(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid macro %s: %w", path, err)
+	}
+	macro.Name = path
+	return macro, nil
+}
+
+// ParseMacro reads a macro and checks its actions.
+func ParseMacro(r io.Reader) (*Macro, error) {
+	macro := &Macro{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		step := MacroStep{Line: line}
+		if instruction, ok := strings.CutPrefix(text, agentStepPrefix); ok {
+			step.Instruction = strings.TrimSpace(instruction)
+			if step.Instruction == "" {
+				return nil, fmt.Errorf("line %d: agent step without instruction", line)
+			}
+		} else {
+			action, err := helper.ParseAction(text)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			step.Action = action
+		}
+		macro.Steps = append(macro.Steps, step)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(macro.Steps) == 0 {
+		return nil, fmt.Errorf("no steps")
+	}
+	return macro, nil
+}
+
+// RunMacro runs the steps of macro in order. Fixed actions go through
+// ExecuteAction, with the policy, confirmations and timeouts of the agent;
+// each instruction runs as a task, those after the first as follow-ups, see
+// Continue, and the model is told the fixed actions run since its last
+// task. The macro stops at the first action or task that fails, and at a
+// finish action, whose message it returns; otherwise it returns the message
+// of its last task.
+func (r *PhoneAgent) RunMacro(ctx context.Context, macro *Macro) (string, error) {
+	var message string
+	var done []string // fixed actions the model was not told about
+	for i, step := range macro.Steps {
+		prefix := fmt.Sprintf("macro line %d", step.Line)
+		if step.Action == nil {
+			if len(done) > 0 {
+				r.addDeviceNote(fmt.Sprintf(helper.GetMessage("macro_actions", r.AgentConfig.Lang), strings.Join(done, "\n")))
+				done = nil
+			}
+			r.log().Infof("📜 %s (%d/%d): agent: %s", prefix, i+1, len(macro.Steps), step.Instruction)
+			var err error
+			if message, err = r.Continue(ctx, step.Instruction); err != nil {
+				return "", fmt.Errorf("%s: %w", prefix, err)
+			}
+			if r.Outcome != nil && r.Outcome.Level != OutcomeSuccess {
+				return "", fmt.Errorf("%s: task ended %s: %s", prefix, r.Outcome.Level, message)
+			}
+			continue
+		}
+
+		r.log().Infof("📜 %s (%d/%d): %s", prefix, i+1, len(macro.Steps), helper.FormatAction(step.Action))
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		screenshot, err := r.Device.GetScreenshot(ctx, r.AgentConfig.DeviceID)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", prefix, err)
+		}
+		result, err := r.ExecuteAction(ctx, step.Action, screenshot.Width, screenshot.Height)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", prefix, err)
+		}
+		if !result.Success {
+			return "", fmt.Errorf("%s: %s failed: %s", prefix, helper.FormatAction(step.Action), result.Message)
+		}
+		if result.ShouldFinish {
+			return utils.AnyToString(step.Action["message"]), nil
+		}
+		// the screen the agent expects next is not the one it will see
+		r.dropObservation()
+		r.dropSpeculation()
+		done = append(done, helper.FormatAction(step.Action))
+	}
+	return message, nil
+}
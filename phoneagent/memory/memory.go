@@ -0,0 +1,208 @@
+// Package memory keeps what the agent learned about apps across sessions,
+// e.g. where the search button of an app is or that its login needs a code,
+// so that later tasks in the same app start from it.
+package memory
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	logs "github.com/sirupsen/logrus"
+)
+
+// maxFactsPerApp bounds the facts of an app, the least recently used go
+// first.
+const maxFactsPerApp = 20
+
+// Fact is a thing learned about an app.
+type Fact struct {
+	ID        string     `json:"id"`
+	App       string     `json:"app"` // as the foreground app is named, see PhoneAgent
+	Text      string     `json:"text"`
+	Source    string     `json:"source,omitempty"` // session that learned it
+	Uses      int        `json:"uses"`             // tasks it was given to
+	CreatedAt time.Time  `json:"created_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+}
+
+// lastUsed is when the fact was last given to a task or learned.
+func (f *Fact) lastUsed() time.Time {
+	if f.UsedAt != nil && f.UsedAt.After(f.CreatedAt) {
+		return *f.UsedAt
+	}
+	return f.CreatedAt
+}
+
+// Store keeps the facts in a JSON file.
+type Store struct {
+	mu      sync.Mutex
+	path    string
+	facts   []Fact
+	saveErr bool
+}
+
+var (
+	sharedMu sync.Mutex
+	shared   = map[string]*Store{}
+)
+
+// Shared returns the store kept in path, loaded once and shared by the
+// agents of the process.
+func Shared(path string) (*Store, error) {
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	if s, ok := shared[path]; ok {
+		return s, nil
+	}
+	s := &Store{path: path}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read app memory: %w", err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &s.facts); err != nil {
+			return nil, fmt.Errorf("invalid app memory %s: %w", path, err)
+		}
+	}
+	shared[path] = s
+	return s, nil
+}
+
+// List returns the facts of app, of all apps when it is empty, by app and
+// most used first.
+func (s *Store) List(app string) []Fact {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var facts []Fact
+	for _, f := range s.facts {
+		if app == "" || f.App == app {
+			facts = append(facts, f)
+		}
+	}
+	slices.SortStableFunc(facts, func(a, b Fact) int {
+		if c := strings.Compare(a.App, b.App); c != 0 {
+			return c
+		}
+		return b.Uses - a.Uses
+	})
+	return facts
+}
+
+// Use returns up to n facts of app, most used first, and counts them as
+// given to a task.
+func (s *Store) Use(app string, n int) []Fact {
+	facts := s.List(app)
+	if len(facts) > n {
+		facts = facts[:n]
+	}
+	if len(facts) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for i := range s.facts {
+		f := &s.facts[i]
+		if slices.ContainsFunc(facts, func(used Fact) bool { return used.ID == f.ID }) {
+			f.Uses++
+			f.UsedAt = &now
+		}
+	}
+	s.save()
+	return facts
+}
+
+// Add keeps texts as facts of app learned by source and returns how many
+// were new, the ones already known are left as they are.
+func (s *Store) Add(app, source string, texts []string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	added := 0
+	now := time.Now()
+	for _, text := range texts {
+		text = strings.TrimSpace(text)
+		id := factID(app, text)
+		if text == "" || slices.ContainsFunc(s.facts, func(f Fact) bool { return f.ID == id }) {
+			continue
+		}
+		s.facts = append(s.facts, Fact{ID: id, App: app, Text: text, Source: source, CreatedAt: now})
+		added++
+	}
+	if added == 0 {
+		return 0
+	}
+	s.trim(app)
+	s.save()
+	return added
+}
+
+// trim drops the least recently used facts of app beyond maxFactsPerApp,
+// s.mu must be held.
+func (s *Store) trim(app string) {
+	var facts []*Fact
+	for i := range s.facts {
+		if s.facts[i].App == app {
+			facts = append(facts, &s.facts[i])
+		}
+	}
+	if len(facts) <= maxFactsPerApp {
+		return
+	}
+	slices.SortFunc(facts, func(a, b *Fact) int { return a.lastUsed().Compare(b.lastUsed()) })
+	drop := map[string]bool{}
+	for _, f := range facts[:len(facts)-maxFactsPerApp] {
+		drop[f.ID] = true
+	}
+	s.facts = slices.DeleteFunc(s.facts, func(f Fact) bool { return drop[f.ID] })
+}
+
+// Remove drops the facts keep returns false for and returns how many.
+func (s *Store) Remove(keep func(Fact) bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	before := len(s.facts)
+	s.facts = slices.DeleteFunc(s.facts, func(f Fact) bool { return !keep(f) })
+	removed := before - len(s.facts)
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, s.write()
+}
+
+// save writes the facts, logging a failure once, s.mu must be held.
+func (s *Store) save() {
+	// once, the facts are still kept in memory
+	if err := s.write(); err != nil && !s.saveErr {
+		s.saveErr = true
+		logs.Warnf("failed to save app memory to %s, err: %v", s.path, err)
+	}
+}
+
+// write writes the facts to the file of the store, s.mu must be held.
+func (s *Store) write() error {
+	data, err := json.MarshalIndent(s.facts, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// factID is a short id of text in app, the same for the same fact learned
+// twice.
+func factID(app, text string) string {
+	sum := sha1.Sum([]byte(app + "\x00" + strings.ToLower(strings.Join(strings.Fields(text), " "))))
+	return hex.EncodeToString(sum[:4])
+}
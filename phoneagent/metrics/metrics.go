@@ -0,0 +1,232 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// the metrics of the agent, in the Prometheus text format at Handler
+var (
+	ModelTimeToFirstToken = NewHistogram("autoglm_model_time_to_first_token_seconds",
+		"Time from the model request to its first content token.", latencyBuckets, "model")
+	ModelThinkingTime = NewHistogram("autoglm_model_thinking_seconds",
+		"Time from the model request to the end of its thinking.", latencyBuckets, "model")
+	ModelInferenceTime = NewHistogram("autoglm_model_inference_seconds",
+		"Total time of a model request, until the end of its stream.", latencyBuckets, "model")
+	ModelRequests = NewCounter("autoglm_model_requests_total",
+		"Model requests, by result: ok or error.", "model", "result")
+	ModelRetries = NewCounter("autoglm_model_retries_total",
+		"Model requests retried after a transient failure.", "model")
+	ModelLimiterWait = NewHistogram("autoglm_model_limiter_wait_seconds",
+		"Time a model request waited for the limits of in-flight requests, requests per second and tokens per minute.", latencyBuckets)
+
+	StepDuration = NewHistogram("autoglm_step_duration_seconds",
+		"Duration of an agent step, from the observation to the end of its action.", stepBuckets)
+	Actions = NewCounter("autoglm_actions_total",
+		"Actions executed, by type and result: success or failure.", "action", "result")
+	ParseFailures = NewCounter("autoglm_action_parse_failures_total",
+		"Model responses whose action could not be parsed.")
+	Anomalies = NewCounter("autoglm_step_anomalies_total",
+		"Steps far above the latency or token baseline of their model and app, by metric.", "metric", "model")
+	Tasks = NewCounter("autoglm_tasks_total",
+		"Finished tasks, by outcome, success, partial or failed, and its reason.", "outcome", "reason")
+	ResponseCacheLookups = NewCounter("autoglm_response_cache_lookups_total",
+		"Steps looked up in the response cache, by result: hit or miss.", "result")
+)
+
+var (
+	latencyBuckets = []float64{0.25, 0.5, 1, 2, 4, 8, 16, 32, 64}
+	stepBuckets    = []float64{1, 2, 5, 10, 20, 30, 60, 120, 300}
+)
+
+// Result is the result label of a success flag.
+func Result(ok bool) string {
+	if ok {
+		return "success"
+	}
+	return "failure"
+}
+
+type collector interface {
+	write(w io.Writer)
+	metricName() string
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []collector
+)
+
+func register(c collector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, c)
+}
+
+// family holds the series of a metric, one per combination of label values.
+type family struct {
+	name, help string
+	labels     []string
+}
+
+func (f *family) metricName() string { return f.name }
+
+func (f *family) key(values []string) string {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s takes %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// labelPairs formats the labels of the series key, with extra appended, as
+// {a="x",le="1"}.
+func (f *family) labelPairs(key string, extra ...string) string {
+	var pairs []string
+	if len(f.labels) > 0 {
+		for i, v := range strings.Split(key, "\xff") {
+			pairs = append(pairs, fmt.Sprintf("%s=%q", f.labels[i], v))
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", extra[i], extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Counter is a monotonically increasing value per combination of labels.
+type Counter struct {
+	family
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounter registers a counter with the given label names.
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{family: family{name: name, help: help, labels: labels}, values: map[string]float64{}}
+	register(c)
+	return c
+}
+
+// Inc adds one to the series of labelValues, given in the order of the label
+// names.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) Add(v float64, labelValues ...string) {
+	key := c.key(labelValues)
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(key), formatFloat(c.values[key]))
+	}
+}
+
+// Histogram counts observations in cumulative buckets per combination of
+// labels.
+type Histogram struct {
+	family
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram registers a histogram with the given upper bounds, in
+// increasing order, and label names.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{family: family{name: name, help: help, labels: labels}, buckets: buckets, series: map[string]*histogramSeries{}}
+	register(h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(key, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(key, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(key), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(key), s.count)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Handler serves the registered metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteText(w)
+	})
+}
+
+// WriteText writes the registered metrics in the Prometheus text format,
+// sorted by name.
+func WriteText(w io.Writer) {
+	registryMu.Lock()
+	collectors := slices.Clone(registry)
+	registryMu.Unlock()
+	slices.SortFunc(collectors, func(a, b collector) int { return strings.Compare(a.metricName(), b.metricName()) })
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
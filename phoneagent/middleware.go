@@ -0,0 +1,160 @@
+package phoneagent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"autoglm-go/phoneagent/helper"
+	"github.com/sashabaranov/go-openai"
+)
+
+// Middleware hooks into the steps of the agent, for the logging, policy
+// checks, prompt changes or metrics of an integrator. The agent calls the
+// hooks of every middleware in the order of PhoneAgent.Middleware, the
+// StepHooks run once the step is done, after them. A middleware needing only
+// some of the hooks embeds BaseMiddleware for the others.
+type Middleware interface {
+	// BeforeModelCall is called before the model is asked for the action of
+	// a step. An error fails the step without asking the model.
+	BeforeModelCall(ctx context.Context, call *ModelCall) error
+	// AfterParse is called once the action of the model is parsed, before it
+	// is executed. An error rejects the action, the model is told why as for
+	// an action it got wrong.
+	AfterParse(ctx context.Context, action *ParsedAction) error
+	// BeforeExecute is called right before an action is executed on the
+	// device, once the policy and confirmations allowed it. An error blocks
+	// the action, which fails with it.
+	BeforeExecute(ctx context.Context, info *StepInfo) error
+	// AfterExecute is called after an action was executed on the device,
+	// with its result and ActionTime in info.
+	AfterExecute(ctx context.Context, info *StepInfo)
+}
+
+// BaseMiddleware implements the hooks of Middleware doing nothing.
+type BaseMiddleware struct{}
+
+func (BaseMiddleware) BeforeModelCall(ctx context.Context, call *ModelCall) error { return nil }
+
+func (BaseMiddleware) AfterParse(ctx context.Context, action *ParsedAction) error { return nil }
+
+func (BaseMiddleware) BeforeExecute(ctx context.Context, info *StepInfo) error { return nil }
+
+func (BaseMiddleware) AfterExecute(ctx context.Context, info *StepInfo) {}
+
+// ModelCall is the request of a step about to be sent to the model.
+type ModelCall struct {
+	Task       string
+	Step       int
+	CurrentApp string
+	Model      string
+	// Messages are sent to the model. A hook may change them, e.g. add to
+	// the prompt, for this request only: the history of the agent keeps its
+	// own.
+	Messages []openai.ChatCompletionMessage
+}
+
+// ParsedAction is the answer of the model once its action is parsed.
+type ParsedAction struct {
+	Task       string
+	Step       int
+	CurrentApp string
+	Thinking   string // empty for an action executed as it streams
+	Raw        string // the action as the model wrote it
+	// Action is executed after the hooks, which may change or replace it.
+	Action helper.Action
+}
+
+// beforeModelCall runs the BeforeModelCall hooks and returns the messages
+// to send.
+func (r *PhoneAgent) beforeModelCall(ctx context.Context) ([]openai.ChatCompletionMessage, error) {
+	if len(r.Middleware) == 0 {
+		return r.State, nil
+	}
+	call := &ModelCall{
+		Task:       r.task,
+		Step:       r.StepCount,
+		CurrentApp: r.stepApp(),
+		Model:      r.stepClient().ModelName(),
+		Messages:   helper.CloneMessages(r.State),
+	}
+	for _, m := range r.Middleware {
+		if err := m.BeforeModelCall(ctx, call); err != nil {
+			return nil, fmt.Errorf("model call rejected: %w", err)
+		}
+	}
+	return call.Messages, nil
+}
+
+// afterParse runs the AfterParse hooks on the action parsed from raw and
+// returns the action to execute.
+func (r *PhoneAgent) afterParse(ctx context.Context, thinking, raw string, action helper.Action) (helper.Action, error) {
+	parsed := &ParsedAction{
+		Task:       r.task,
+		Step:       r.StepCount,
+		CurrentApp: r.stepApp(),
+		Thinking:   thinking,
+		Raw:        raw,
+		Action:     action,
+	}
+	for _, m := range r.Middleware {
+		if err := m.AfterParse(ctx, parsed); err != nil {
+			return nil, fmt.Errorf("action rejected: %w", err)
+		}
+	}
+	if parsed.Action == nil {
+		return nil, fmt.Errorf("action rejected: removed by a middleware")
+	}
+	return parsed.Action, nil
+}
+
+// actionInfo is the StepInfo of action in the running step.
+func (r *PhoneAgent) actionInfo(action helper.Action) *StepInfo {
+	info := &StepInfo{
+		Task:       r.task,
+		Step:       r.StepCount,
+		CurrentApp: r.stepApp(),
+		Action:     action,
+	}
+	info.Subgoal, info.Subgoals = r.planProgress()
+	return info
+}
+
+// beforeExecute runs the BeforeExecute hooks, the result of a blocked
+// action is returned with false.
+func (r *PhoneAgent) beforeExecute(ctx context.Context, action helper.Action) (helper.ActionResult, bool) {
+	if len(r.Middleware) == 0 {
+		return helper.ActionResult{}, true
+	}
+	info := r.actionInfo(action)
+	for _, m := range r.Middleware {
+		if err := m.BeforeExecute(ctx, info); err != nil {
+			r.log().Warnf("🧱 action blocked by a middleware, err: %v", err)
+			return helper.ActionResult{Success: false, Message: fmt.Sprintf("action blocked: %v", err)}, false
+		}
+	}
+	return helper.ActionResult{}, true
+}
+
+// afterExecute runs the AfterExecute hooks with the result of action.
+func (r *PhoneAgent) afterExecute(ctx context.Context, action helper.Action, result helper.ActionResult, err error, elapsed time.Duration) {
+	if len(r.Middleware) == 0 {
+		return
+	}
+	info := r.actionInfo(action)
+	info.Success, info.Message, info.ActionTime = result.Success && err == nil, result.Message, elapsed
+	if err != nil {
+		info.Message = err.Error()
+	}
+	for _, m := range r.Middleware {
+		m.AfterExecute(ctx, info)
+	}
+}
+
+// stepApp is the app the running step started in.
+func (r *PhoneAgent) stepApp() string {
+	if r.stepObservation == nil {
+		return ""
+	}
+	return r.stepObservation.currentApp
+}
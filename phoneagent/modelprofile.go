@@ -0,0 +1,79 @@
+package phoneagent
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"autoglm-go/phoneagent/definitions"
+)
+
+var (
+	modelProfilesMu sync.RWMutex
+	modelProfiles   = map[string]definitions.ModelProfile{}
+)
+
+// UseModelProfiles makes profiles the models tasks may select by name, with
+// WithModelProfile or AgentConfig.ModelProfile, and returns a function
+// restoring the previous ones.
+func UseModelProfiles(profiles []definitions.ModelProfile) func() {
+	byName := make(map[string]definitions.ModelProfile, len(profiles))
+	for _, p := range profiles {
+		byName[p.Name] = p
+	}
+	modelProfilesMu.Lock()
+	defer modelProfilesMu.Unlock()
+	previous := modelProfiles
+	modelProfiles = byName
+	return func() {
+		modelProfilesMu.Lock()
+		defer modelProfilesMu.Unlock()
+		modelProfiles = previous
+	}
+}
+
+// LookupModelProfile returns the model profile named name.
+func LookupModelProfile(name string) (definitions.ModelProfile, bool) {
+	modelProfilesMu.RLock()
+	defer modelProfilesMu.RUnlock()
+	p, ok := modelProfiles[name]
+	return p, ok
+}
+
+type modelProfileKey struct{}
+
+// WithModelProfile returns ctx whose tasks run with the model profile name
+// instead of AgentConfig.ModelProfile.
+func WithModelProfile(ctx context.Context, name string) context.Context {
+	if name == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, modelProfileKey{}, name)
+}
+
+func (r *PhoneAgent) modelProfileOf(ctx context.Context) string {
+	if name, ok := ctx.Value(modelProfileKey{}).(string); ok {
+		return name
+	}
+	return r.AgentConfig.ModelProfile
+}
+
+// useModelProfile switches the agent to the model profile of the task and
+// returns a function switching it back to its main model.
+func (r *PhoneAgent) useModelProfile(ctx context.Context) (func(), error) {
+	name := r.modelProfileOf(ctx)
+	if name == "" {
+		return func() {}, nil
+	}
+	profile, ok := LookupModelProfile(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown model profile %q", name)
+	}
+	config, client := r.ModelConfig, r.ModelClient
+	r.ModelConfig = profile.Apply(config)
+	r.ModelClient = client.Derive(r.ModelConfig)
+	r.logFor(ctx).Infof("🧠 model profile %s: %s", name, r.ModelConfig.ModelName)
+	return func() {
+		r.ModelConfig, r.ModelClient = config, client
+	}, nil
+}
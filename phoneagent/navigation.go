@@ -0,0 +1,187 @@
+package phoneagent
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"autoglm-go/constants"
+	"autoglm-go/phoneagent/cdp"
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/utils"
+)
+
+// NavigationMap learns which screen (foreground app) follows an action on a
+// given screen. It is safe for concurrent use and can be shared by agents.
+type NavigationMap struct {
+	mu          sync.Mutex
+	transitions map[string]map[string]int
+}
+
+func NewNavigationMap() *NavigationMap {
+	return &NavigationMap{
+		transitions: map[string]map[string]int{},
+	}
+}
+
+// navigationKey identifies an action on a screen. Tap coordinates are
+// bucketed so nearby taps on the same control share statistics.
+func navigationKey(fromApp string, action helper.Action) string {
+	name := utils.AnyToString(action["action"])
+	switch name {
+	case "Launch":
+		return fmt.Sprintf("%s|Launch|%s", fromApp, utils.AnyToString(action["app"]))
+	case "Tap", "Double Tap", "Long Press":
+		element := utils.AnyToIntSlice(action["element"])
+		if len(element) == 2 {
+			return fmt.Sprintf("%s|%s|%d,%d", fromApp, name, element[0]/50, element[1]/50)
+		}
+	}
+	return fmt.Sprintf("%s|%s", fromApp, name)
+}
+
+func (m *NavigationMap) Record(fromApp string, action helper.Action, toApp string) {
+	key := navigationKey(fromApp, action)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counts, ok := m.transitions[key]
+	if !ok {
+		counts = map[string]int{}
+		m.transitions[key] = counts
+	}
+	counts[toApp]++
+}
+
+// Predict returns the most likely next app and its observed frequency.
+// Launch and Home are deterministic and predicted with full confidence.
+func (m *NavigationMap) Predict(fromApp string, action helper.Action) (string, float64) {
+	switch utils.AnyToString(action["action"]) {
+	case "Launch":
+		if app := utils.AnyToString(action["app"]); app != "" {
+			return app, 1
+		}
+	case "Home":
+		return "System Home", 1
+	}
+
+	key := navigationKey(fromApp, action)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var (
+		best  string
+		count int
+		total int
+	)
+	for app, n := range m.transitions[key] {
+		total += n
+		if n > count {
+			best, count = app, n
+		}
+	}
+	if total == 0 {
+		return "", 0
+	}
+	return best, float64(count) / float64(total)
+}
+
+// speculation is the screen and action of the previous step and, when the
+// navigation map was confident about the next screen, what was warmed up for
+// it while the next observation was captured.
+type speculation struct {
+	fromApp string
+	action  helper.Action
+	app     string // predicted, empty without a confident prediction
+
+	done   chan struct{} // closed once warmed up, nil when nothing is
+	cancel context.CancelFunc
+	web    *webPage // attached to the predicted app, with WebCDP
+}
+
+// speculate keeps the step for the navigation map, when the agent has one.
+// When the screen following action is predicted with at least
+// AgentConfig.SpeculationThreshold confidence, the next prompt is warmed up
+// for it in the background: with WebCDP the DevTools page of the predicted
+// app is attached, the slowest part of observing web content.
+func (r *PhoneAgent) speculate(ctx context.Context, fromApp string, action helper.Action) {
+	if r.Navigation == nil {
+		return
+	}
+	s := &speculation{fromApp: fromApp, action: action}
+	r.speculation = s
+	threshold := r.AgentConfig.SpeculationThreshold
+	if threshold <= 0 {
+		return
+	}
+	app, confidence := r.Navigation.Predict(fromApp, action)
+	if app == "" || confidence < threshold {
+		return
+	}
+	s.app = app
+	r.log().Debugf("🔮 next screen %s (confidence %.2f)", app, confidence)
+	device, ok := r.Device.(cdp.Device)
+	packageName, known := constants.APP_PACKAGES_ANDROID[app]
+	if !r.AgentConfig.WebCDP || !ok || !known || (r.web != nil && r.web.app == app) {
+		return
+	}
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.done = make(chan struct{})
+	log, deviceID := r.log(), r.AgentConfig.DeviceID
+	go func() {
+		defer close(s.done)
+		s.web = attachWeb(ctx, log, device, deviceID, app, packageName)
+	}()
+}
+
+// resolveSpeculation records the screen the previous step led to and, when
+// it is the predicted one, uses what was warmed up for it.
+func (r *PhoneAgent) resolveSpeculation(currentApp string) {
+	s := r.speculation
+	r.speculation = nil
+	if s == nil {
+		return
+	}
+	r.Navigation.Record(s.fromApp, s.action, currentApp)
+	if s.app == "" {
+		return
+	}
+	if s.app != currentApp {
+		r.log().Debugf("🔮 speculation miss: predicted %s, got %s", s.app, currentApp)
+		s.drop()
+		return
+	}
+	r.log().Debugf("🔮 speculation hit: %s", currentApp)
+	if s.done == nil {
+		return
+	}
+	<-s.done
+	s.cancel()
+	if s.web != nil {
+		r.closeWeb()
+		r.web = s.web
+	}
+}
+
+// dropSpeculation forgets the speculation of the previous step, the screen
+// the agent expects next is not the one it will see.
+func (r *PhoneAgent) dropSpeculation() {
+	if s := r.speculation; s != nil {
+		r.speculation = nil
+		s.drop()
+	}
+}
+
+// drop stops the warm-up and releases what it prepared.
+func (s *speculation) drop() {
+	if s.done == nil {
+		return
+	}
+	s.cancel()
+	<-s.done
+	if s.web != nil {
+		s.web.page.Close()
+	}
+}
@@ -0,0 +1,91 @@
+package phoneagent
+
+import (
+	"context"
+	"fmt"
+
+	"autoglm-go/phoneagent/definitions"
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/utils"
+)
+
+// maxNotifications is how many notifications an observation lists, the
+// first ones of the shade.
+const maxNotifications = 10
+
+// NotificationDevice is implemented by devices that can read their
+// notifications. With AgentConfig.Notifications observations list them, and
+// the Open_Notification and Dismiss_Notification actions tap or clear one.
+type NotificationDevice interface {
+	ListNotifications(ctx context.Context, deviceID string) ([]definitions.Notification, error)
+	OpenNotification(ctx context.Context, deviceID string, notification definitions.Notification) error
+	DismissNotification(ctx context.Context, deviceID string, notification definitions.Notification) error
+}
+
+// listNotifications returns the notifications of the device, none when it
+// cannot tell.
+func (r *PhoneAgent) listNotifications(ctx context.Context) []definitions.Notification {
+	device, ok := r.Device.(NotificationDevice)
+	if !ok {
+		return nil
+	}
+	notifications, err := device.ListNotifications(ctx, r.AgentConfig.DeviceID)
+	if err != nil {
+		r.log().Debugf("failed to list notifications, err: %v", err)
+	}
+	if len(notifications) > maxNotifications {
+		notifications = notifications[:maxNotifications]
+	}
+	return notifications
+}
+
+// notificationContext describes the notifications of obs for the model.
+func (r *PhoneAgent) notificationContext(obs *observation) string {
+	if len(obs.notifications) == 0 {
+		return ""
+	}
+	return helper.FormatNotifications(obs.notifications)
+}
+
+func (r *PhoneAgent) handleOpenNotification(ctx context.Context, action helper.Action, screenWidth, screenHeight int) (helper.ActionResult, error) {
+	return r.onNotification(ctx, action, "Opened", NotificationDevice.OpenNotification)
+}
+
+func (r *PhoneAgent) handleDismissNotification(ctx context.Context, action helper.Action, screenWidth, screenHeight int) (helper.ActionResult, error) {
+	return r.onNotification(ctx, action, "Dismissed", NotificationDevice.DismissNotification)
+}
+
+// onNotification runs call on the notification [index] of the step
+// observation, or of the device when the observation has none.
+func (r *PhoneAgent) onNotification(ctx context.Context, action helper.Action, done string, call func(NotificationDevice, context.Context, string, definitions.Notification) error) (helper.ActionResult, error) {
+	name := utils.AnyToString(action["action"])
+	device, ok := r.Device.(NotificationDevice)
+	if !ok {
+		return helper.ActionResult{Success: false, Message: name + " is not supported on this device"}, nil
+	}
+	var notifications []definitions.Notification
+	if r.stepObservation != nil {
+		notifications = r.stepObservation.notifications
+	}
+	if notifications == nil {
+		notifications = r.listNotifications(ctx)
+	}
+	if len(notifications) == 0 {
+		return helper.ActionResult{Success: false, Message: "No notification"}, nil
+	}
+	index := 1
+	if n, ok := action["index"].(float64); ok {
+		index = int(n)
+	} else if n, ok := action["index"].(int); ok {
+		index = n
+	}
+	if index < 1 || index > len(notifications) {
+		return helper.ActionResult{Success: false, Message: fmt.Sprintf("No notification [%d], there are %d", index, len(notifications))}, nil
+	}
+
+	notification := notifications[index-1]
+	if err := call(device, ctx, r.AgentConfig.DeviceID, notification); err != nil {
+		return helper.ActionResult{Success: false, Message: fmt.Sprintf("%s failed: %v", name, err)}, nil
+	}
+	return helper.ActionResult{Success: true, Message: fmt.Sprintf("%s the notification of %s", done, notification.Package)}, nil
+}
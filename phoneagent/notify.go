@@ -0,0 +1,74 @@
+package phoneagent
+
+import (
+	"cmp"
+	"context"
+
+	"autoglm-go/phoneagent/labels"
+	"autoglm-go/phoneagent/webhook"
+	"autoglm-go/utils"
+	"github.com/google/uuid"
+)
+
+type taskIDKey struct{}
+
+// WithTaskID returns ctx whose task is reported to webhooks as id, e.g. the id
+// the session manager gave it. Tasks without one get a random id.
+func WithTaskID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, taskIDKey{}, id)
+}
+
+func taskIDOf(ctx context.Context) string {
+	id, _ := ctx.Value(taskIDKey{}).(string)
+	if id == "" {
+		id = uuid.New().String()
+	}
+	return id
+}
+
+// notify posts event of the running task to AgentConfig.Webhooks.
+func (r *PhoneAgent) notify(ctx context.Context, event webhook.Event, message, errText string) {
+	if r.webhooks == nil {
+		return
+	}
+	r.webhooks.Send(ctx, r.payload(ctx, event, message, errText))
+}
+
+func (r *PhoneAgent) payload(ctx context.Context, event webhook.Event, message, errText string) webhook.Payload {
+	return webhook.Payload{
+		Event:    event,
+		TaskID:   r.taskID,
+		DeviceID: r.AgentConfig.DeviceID,
+		Task:     r.task,
+		Message:  message,
+		Steps:    r.StepCount,
+		Cost:     r.Usage.Total().Cost,
+		Error:    errText,
+		Labels:   labels.From(ctx),
+	}
+}
+
+// notifyEnd reports how the task ended after last, its final step: finished
+// when the model called finish, failed otherwise, with its Outcome.
+func (r *PhoneAgent) notifyEnd(ctx context.Context, task string, last *StepResult, message string, err error) {
+	if r.webhooks == nil || isWatchCheck(ctx) {
+		return
+	}
+	r.task = cmp.Or(r.task, task)
+	var payload webhook.Payload
+	if err == nil && last != nil && last.Finished && last.Success && utils.AnyToString(last.Action["_metadata"]) == "finish" {
+		payload = r.payload(ctx, webhook.EventFinished, message, "")
+	} else {
+		// without an error the message says what went wrong
+		if err != nil {
+			message = err.Error()
+		}
+		payload = r.payload(ctx, webhook.EventFailed, "", message)
+	}
+	if r.Outcome != nil {
+		payload.Outcome, payload.Reason = string(r.Outcome.Level), r.Outcome.Reason
+		payload.Summary = r.Summary(ctx, ChannelWebhook)
+		payload.ChatText = r.Summary(ctx, ChannelChat)
+	}
+	r.webhooks.Send(ctx, payload)
+}
@@ -0,0 +1,170 @@
+package phoneagent
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/llm"
+	"github.com/sashabaranov/go-openai"
+)
+
+// Observation presets for ModelConfig.Observation.
+const (
+	ObservationImage     = "image"      // screenshot, with the UI elements when AgentConfig.UIDump is set
+	ObservationImageTree = "image+tree" // screenshot and UI elements
+	ObservationTree      = "tree"       // UI elements only, for models without vision
+)
+
+// ObservationSections are the parts of a step observation. Empty sections
+// are not available this step.
+type ObservationSections struct {
+	FirstStep  bool
+	Task       string // first step only
+	ScreenInfo string
+	Profile    string // whose app is in the foreground, on devices with several users
+	UIElements string // the element tree, or its changes since the last step
+	Web        string
+	Overlays   string // windows of other apps drawn above the foreground one
+	Script     string
+	Plan       string
+	Memory     string // facts learned about the foreground app in earlier tasks
+	Device     string // reconnect notice
+	Unchanged  string // the screen did not change since the last step, see AgentConfig.UnchangedScreen
+	ImageURL   string // screenshot data URL
+
+	Notifications string // with AgentConfig.Notifications
+}
+
+// Text joins the text sections in the default layout.
+func (s *ObservationSections) Text() string {
+	var text string
+	if s.FirstStep {
+		text = fmt.Sprintf("%s\n\n%s", s.Task, s.ScreenInfo)
+	} else {
+		text = fmt.Sprintf("** Screen Info **\n\n%s", s.ScreenInfo)
+	}
+	if s.Profile != "" {
+		text = fmt.Sprintf("%s\n%s", text, s.Profile)
+	}
+	for _, section := range []struct{ title, body string }{
+		{"UI Elements", s.UIElements},
+		{"Web Elements", s.Web},
+		{"Overlays", s.Overlays},
+		{"Notifications", s.Notifications},
+		{"Script Output", s.Script},
+		{"Plan", s.Plan},
+		{"App Memory", s.Memory},
+	} {
+		if section.body != "" {
+			text = fmt.Sprintf("%s\n\n** %s **\n\n%s", text, section.title, section.body)
+		}
+	}
+	if s.Device != "" {
+		text = fmt.Sprintf("%s\n\n%s", text, s.Device)
+	}
+	if s.Unchanged != "" {
+		text = fmt.Sprintf("%s\n\n%s", text, s.Unchanged)
+	}
+	return text
+}
+
+// ObservationBuilder composes the user message of a step. Builders are
+// registered once and shared by all agents.
+type ObservationBuilder interface {
+	// WantsTree reports whether the UI is dumped with every observation, so
+	// that UIElements is filled.
+	WantsTree() bool
+	Build(s *ObservationSections) openai.ChatCompletionMessage
+}
+
+type presetBuilder struct {
+	image, tree bool
+}
+
+func (b presetBuilder) WantsTree() bool {
+	return b.tree
+}
+
+func (b presetBuilder) Build(s *ObservationSections) openai.ChatCompletionMessage {
+	if !b.image {
+		return openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: s.Text()}
+	}
+	return helper.CreateUserMessageWithImageURL(s.Text(), s.ImageURL)
+}
+
+var (
+	observationBuildersMu sync.RWMutex
+	observationBuilders   = map[string]ObservationBuilder{
+		ObservationImage:     presetBuilder{image: true},
+		ObservationImageTree: presetBuilder{image: true, tree: true},
+		ObservationTree:      presetBuilder{tree: true},
+	}
+)
+
+// RegisterObservationBuilder adds a named builder for ModelConfig.Observation.
+func RegisterObservationBuilder(name string, builder ObservationBuilder) error {
+	if name == "" {
+		return fmt.Errorf("observation builder name is required")
+	}
+	observationBuildersMu.Lock()
+	defer observationBuildersMu.Unlock()
+	if _, ok := observationBuilders[name]; ok {
+		return fmt.Errorf("observation builder %s already registered", name)
+	}
+	observationBuilders[name] = builder
+	return nil
+}
+
+// ObservationBuilderFor returns the builder registered as name, the image
+// preset when name is empty.
+func ObservationBuilderFor(name string) (ObservationBuilder, error) {
+	if name == "" {
+		name = ObservationImage
+	}
+	observationBuildersMu.RLock()
+	defer observationBuildersMu.RUnlock()
+	builder, ok := observationBuilders[name]
+	if !ok {
+		names := make([]string, 0, len(observationBuilders))
+		for n := range observationBuilders {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown observation preset: %s. Must be one of %s", name, strings.Join(names, ", "))
+	}
+	return builder, nil
+}
+
+// observationBuilder is the builder of the model client, the image preset
+// for unknown names.
+func observationBuilder(client *llm.ModelClient) ObservationBuilder {
+	builder, err := ObservationBuilderFor(client.Config().Observation)
+	if err != nil {
+		return presetBuilder{image: true}
+	}
+	return builder
+}
+
+// needsUIDump reports whether observations need a UI dump, for any of the
+// models the agent may use, for the summaries of old screenshots or to find
+// the texts to redact.
+func (r *PhoneAgent) needsUIDump() bool {
+	if r.AgentConfig.UIDump || r.AgentConfig.HistoryImages > 1 || r.redactor.NeedsUI() {
+		return true
+	}
+	clients := []*llm.ModelClient{r.ModelClient, r.Planner}
+	if r.Router != nil {
+		for _, route := range r.Router.routes {
+			clients = append(clients, route.Client)
+		}
+	}
+	for _, client := range clients {
+		if client != nil && observationBuilder(client).WantsTree() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package ocr
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	logs "github.com/sirupsen/logrus"
+)
+
+// tesseractTimeout bounds one recognition, grounding goes on without it.
+const tesseractTimeout = 15 * time.Second
+
+// minConfidence drops the words tesseract mostly guessed, 0-100.
+const minConfidence = 30
+
+// Word is a recognized word, Bounds are left, top, right, bottom in pixels.
+type Word struct {
+	Text       string
+	Bounds     [4]int
+	Confidence float64
+}
+
+// Line is a line of words as laid out on the image.
+type Line struct {
+	Words []Word
+}
+
+// Engine recognizes the text of a screenshot. Implementations must be safe
+// for concurrent use.
+type Engine interface {
+	Recognize(ctx context.Context, image []byte) ([]Line, error)
+}
+
+// Tesseract runs the tesseract command line. Languages are tesseract
+// language codes joined by +, e.g. chi_sim+eng.
+type Tesseract struct {
+	Path      string
+	Languages string
+}
+
+// NewTesseract finds tesseract in PATH.
+func NewTesseract(languages string) (*Tesseract, error) {
+	path, err := exec.LookPath("tesseract")
+	if err != nil {
+		return nil, fmt.Errorf("tesseract not found: %w", err)
+	}
+	return &Tesseract{Path: path, Languages: languages}, nil
+}
+
+func (r *Tesseract) Recognize(ctx context.Context, image []byte) ([]Line, error) {
+	ctx, cancel := context.WithTimeout(ctx, tesseractTimeout)
+	defer cancel()
+
+	// sparse text: screens are made of scattered labels rather than pages
+	args := []string{"stdin", "stdout", "--psm", "11"}
+	if r.Languages != "" {
+		args = append(args, "-l", r.Languages)
+	}
+	args = append(args, "tsv")
+	cmd := exec.CommandContext(ctx, r.Path, args...)
+	cmd.Stdin = bytes.NewReader(image)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	logs.Debugf("[OCR] run cmd: %s", strings.Join(cmd.Args, " "))
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("tesseract failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return parseTSV(&stdout)
+}
+
+// parseTSV reads the words of tesseract's tsv output into lines, keyed by
+// block, paragraph and line number.
+func parseTSV(r io.Reader) ([]Line, error) {
+	var lines []Line
+	index := map[string]int{}
+	// not encoding/csv: the texts may hold quotes
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// level, page, block, paragraph, line, word, left, top, width,
+		// height, conf, text; the header is skipped by its level
+		record := strings.SplitN(scanner.Text(), "\t", 12)
+		if len(record) < 12 || record[0] != "5" {
+			continue
+		}
+		text := strings.TrimSpace(record[11])
+		confidence, _ := strconv.ParseFloat(record[10], 64)
+		if text == "" || confidence < minConfidence {
+			continue
+		}
+		var box [4]int
+		for j := range box {
+			box[j], _ = strconv.Atoi(record[6+j])
+		}
+		word := Word{Text: text, Bounds: [4]int{box[0], box[1], box[0] + box[2], box[1] + box[3]}, Confidence: confidence}
+
+		key := strings.Join(record[1:5], "/")
+		n, ok := index[key]
+		if !ok {
+			n = len(lines)
+			index[key] = n
+			lines = append(lines, Line{})
+		}
+		lines[n].Words = append(lines[n].Words, word)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("invalid tesseract output: %w", err)
+	}
+	return lines, nil
+}
+
+// Find returns the bounds of the words of a line that spell label, compared
+// after fold and without spaces. Exact lines come first, then the shortest
+// line containing label.
+func Find(lines []Line, label string, fold func(string) string) ([4]int, bool) {
+	want := compact(fold(label))
+	if want == "" {
+		return [4]int{}, false
+	}
+	var (
+		best      [4]int
+		bestLen   = -1
+		bestExact bool
+	)
+	for _, line := range lines {
+		var text strings.Builder
+		var owners []int // word of each byte of text
+		for i, word := range line.Words {
+			part := compact(fold(word.Text))
+			text.WriteString(part)
+			for range len(part) {
+				owners = append(owners, i)
+			}
+		}
+		start := strings.Index(text.String(), want)
+		if start < 0 {
+			continue
+		}
+		exact := text.Len() == len(want)
+		if bestLen >= 0 && (bestExact && !exact || bestExact == exact && text.Len() >= bestLen) {
+			continue
+		}
+		first, last := owners[start], owners[start+len(want)-1]
+		box := line.Words[first].Bounds
+		for _, word := range line.Words[first+1 : last+1] {
+			box = [4]int{min(box[0], word.Bounds[0]), min(box[1], word.Bounds[1]), max(box[2], word.Bounds[2]), max(box[3], word.Bounds[3])}
+		}
+		best, bestLen, bestExact = box, text.Len(), exact
+	}
+	return best, bestLen >= 0
+}
+
+func compact(s string) string {
+	return strings.Join(strings.Fields(s), "")
+}
@@ -0,0 +1,164 @@
+package phoneagent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+
+	"autoglm-go/phoneagent/labels"
+	"autoglm-go/utils"
+)
+
+type OutcomeLevel string
+
+const (
+	OutcomeSuccess OutcomeLevel = "success" // finished and nothing contradicts it
+	OutcomePartial OutcomeLevel = "partial" // finished, but the judge rejected it or output fields are missing
+	OutcomeFailed  OutcomeLevel = "failed"  // did not finish
+)
+
+// Reasons of an Outcome.
+const (
+	ReasonCompleted        = "completed"
+	ReasonJudgeRejected    = "judge_rejected"
+	ReasonOutputIncomplete = "output_incomplete"
+	ReasonAborted          = "aborted" // by the user, a hook or a failed action that ended the task
+	ReasonMaxSteps         = "max_steps"
+	ReasonTimeout          = "timeout"
+	ReasonCancelled        = "cancelled"
+	ReasonDeviceLocked     = "device_locked"
+	ReasonReplayDiverged   = "replay_diverged"
+	ReasonPolicyDeadlock   = "policy_deadlock" // the policy kept denying the actions of the model
+	ReasonStuckLoop        = "stuck_loop"      // the model kept taking the same action on an unchanged screen
+	ReasonActionErrors     = "action_errors"   // the actions of the model kept failing
+	ReasonBudgetExceeded   = "budget_exceeded" // the task used its tokens or cost, see Budget
+	ReasonError            = "error"
+)
+
+// Outcome classifies how a task ended.
+type Outcome struct {
+	Level  OutcomeLevel `json:"level"`
+	Reason string       `json:"reason"`           // one of the Reason constants
+	Detail string       `json:"detail,omitempty"` // the judge's reason, missing fields or the error
+}
+
+// Channels of the outcome templates, see AgentConfig.OutcomeTemplates.
+const (
+	ChannelCLI     = "cli"
+	ChannelChat    = "chat"    // Slack and Feishu webhooks
+	ChannelWebhook = "webhook" // the summary field of generic webhooks
+)
+
+var outcomeChannels = []string{ChannelCLI, ChannelChat, ChannelWebhook}
+
+// OutcomeSummary is what the outcome templates render.
+type OutcomeSummary struct {
+	Outcome
+	Task     string
+	TaskID   string
+	DeviceID string
+	Message  string // finish message, or the error
+	Steps    int
+	Cost     float64
+	Output   map[string]any
+	Labels   labels.Labels
+}
+
+// LoadOutcomeTemplates reads a JSON object of text/template templates by
+// channel, cli, chat or webhook, and checks that they parse.
+func LoadOutcomeTemplates(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var templates map[string]string
+	if err := json.Unmarshal(data, &templates); err != nil {
+		return nil, fmt.Errorf("invalid outcome templates %s: %w", path, err)
+	}
+	for channel, text := range templates {
+		if !slices.Contains(outcomeChannels, channel) {
+			return nil, fmt.Errorf("invalid outcome template channel %q, want %s", channel, strings.Join(outcomeChannels, ", "))
+		}
+		if _, err := template.New(channel).Parse(text); err != nil {
+			return nil, fmt.Errorf("invalid %s outcome template: %w", channel, err)
+		}
+	}
+	return templates, nil
+}
+
+// classifyOutcome tells how the task ended after last, its final step.
+func (r *PhoneAgent) classifyOutcome(last *StepResult, err error) Outcome {
+	switch {
+	case errors.Is(err, ErrTaskTimeout):
+		return Outcome{Level: OutcomeFailed, Reason: ReasonTimeout, Detail: err.Error()}
+	case errors.Is(err, ErrTaskCancelled), errors.Is(err, context.Canceled):
+		return Outcome{Level: OutcomeFailed, Reason: ReasonCancelled, Detail: err.Error()}
+	case errors.Is(err, ErrDeviceLocked):
+		return Outcome{Level: OutcomeFailed, Reason: ReasonDeviceLocked, Detail: err.Error()}
+	case errors.Is(err, ErrReplayDiverged):
+		return Outcome{Level: OutcomeFailed, Reason: ReasonReplayDiverged, Detail: err.Error()}
+	case errors.Is(err, ErrPolicyDeadlock):
+		return Outcome{Level: OutcomeFailed, Reason: ReasonPolicyDeadlock, Detail: err.Error()}
+	case errors.Is(err, ErrStuckLoop):
+		return Outcome{Level: OutcomeFailed, Reason: ReasonStuckLoop, Detail: err.Error()}
+	case errors.Is(err, ErrActionErrors):
+		return Outcome{Level: OutcomeFailed, Reason: ReasonActionErrors, Detail: err.Error()}
+	case errors.Is(err, ErrBudgetExceeded):
+		return Outcome{Level: OutcomeFailed, Reason: ReasonBudgetExceeded, Detail: err.Error()}
+	case err != nil:
+		return Outcome{Level: OutcomeFailed, Reason: ReasonError, Detail: err.Error()}
+	case last == nil || !last.Finished:
+		return Outcome{Level: OutcomeFailed, Reason: ReasonMaxSteps, Detail: fmt.Sprintf("not finished after %d steps", r.StepCount)}
+	case !last.Success || utils.AnyToString(last.Action["_metadata"]) != "finish":
+		return Outcome{Level: OutcomeFailed, Reason: ReasonAborted, Detail: last.Message}
+	}
+	if t := r.Trajectory; t != nil && t.Verdict != nil && !t.Verdict.Pass {
+		return Outcome{Level: OutcomePartial, Reason: ReasonJudgeRejected, Detail: t.Verdict.Reason}
+	}
+	var missing []string
+	for name, value := range r.Output {
+		if value == nil {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		slices.Sort(missing)
+		return Outcome{Level: OutcomePartial, Reason: ReasonOutputIncomplete, Detail: "missing " + strings.Join(missing, ", ")}
+	}
+	return Outcome{Level: OutcomeSuccess, Reason: ReasonCompleted}
+}
+
+// Summary renders the outcome of the last task with the template of channel
+// in AgentConfig.OutcomeTemplates, empty without one or when it fails.
+func (r *PhoneAgent) Summary(ctx context.Context, channel string) string {
+	text := r.AgentConfig.OutcomeTemplates[channel]
+	if text == "" || r.Outcome == nil {
+		return ""
+	}
+	tmpl, err := template.New(channel).Parse(text)
+	if err != nil {
+		r.log().Warnf("invalid %s outcome template, err: %v", channel, err)
+		return ""
+	}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, OutcomeSummary{
+		Outcome:  *r.Outcome,
+		Task:     r.task,
+		TaskID:   r.taskID,
+		DeviceID: r.AgentConfig.DeviceID,
+		Message:  r.outcomeMessage,
+		Steps:    r.StepCount,
+		Cost:     r.Usage.Total().Cost,
+		Output:   r.Output,
+		Labels:   labels.From(ctx),
+	}); err != nil {
+		r.log().Warnf("%s outcome template failed, err: %v", channel, err)
+		return ""
+	}
+	return strings.TrimSpace(sb.String())
+}
@@ -0,0 +1,91 @@
+package phoneagent
+
+import (
+	"context"
+	"fmt"
+
+	"autoglm-go/phoneagent/definitions"
+	"autoglm-go/phoneagent/helper"
+)
+
+// OverlayDevice is implemented by devices that can see windows drawn above
+// the foreground app, such as chat heads and picture-in-picture. Observations
+// list them and the Dismiss_Overlay action closes them.
+type OverlayDevice interface {
+	ListOverlays(ctx context.Context, deviceID string) ([]definitions.Overlay, error)
+	DismissOverlay(ctx context.Context, deviceID string, overlay definitions.Overlay) error
+}
+
+// listOverlays returns the overlays of the device, none when it cannot tell.
+func (r *PhoneAgent) listOverlays(ctx context.Context) []definitions.Overlay {
+	device, ok := r.Device.(OverlayDevice)
+	if !ok {
+		return nil
+	}
+	overlays, err := device.ListOverlays(ctx, r.AgentConfig.DeviceID)
+	if err != nil {
+		r.log().Debugf("failed to list overlays, err: %v", err)
+	}
+	return overlays
+}
+
+// overlayContext describes the overlays of obs for the model.
+func (r *PhoneAgent) overlayContext(obs *observation) string {
+	if len(obs.overlays) == 0 || obs.screenshot == nil {
+		return ""
+	}
+	return helper.FormatOverlays(obs.overlays, obs.screenshot.Width, obs.screenshot.Height)
+}
+
+// noteOverlayTap tells the model, in the next observation, that a tap at x, y
+// landed on an overlay rather than on the app below it.
+func (r *PhoneAgent) noteOverlayTap(x, y int) {
+	if r.stepObservation == nil {
+		return
+	}
+	for i, o := range r.stepObservation.overlays {
+		if o.Contains(x, y) {
+			r.hookObservations = append(r.hookObservations, fmt.Sprintf(
+				"The last tap landed on overlay [%d] (%s), not on the app below it. Use Dismiss_Overlay if it is in the way.",
+				i+1, o.Package))
+			return
+		}
+	}
+}
+
+func (r *PhoneAgent) handleDismissOverlay(ctx context.Context, action helper.Action, screenWidth, screenHeight int) (helper.ActionResult, error) {
+	device, ok := r.Device.(OverlayDevice)
+	if !ok {
+		return helper.ActionResult{Success: false, Message: "Dismiss_Overlay is not supported on this device"}, nil
+	}
+	var overlays []definitions.Overlay
+	if r.stepObservation != nil {
+		overlays = r.stepObservation.overlays
+	}
+	if len(overlays) == 0 {
+		return helper.ActionResult{Success: false, Message: "No overlay to dismiss"}, nil
+	}
+	index := 1
+	if n, ok := action["index"].(float64); ok {
+		index = int(n)
+	} else if n, ok := action["index"].(int); ok {
+		index = n
+	}
+	if index < 1 || index > len(overlays) {
+		return helper.ActionResult{Success: false, Message: fmt.Sprintf("No overlay [%d], there are %d", index, len(overlays))}, nil
+	}
+
+	overlay := overlays[index-1]
+	if err := device.DismissOverlay(ctx, r.AgentConfig.DeviceID, overlay); err != nil {
+		return helper.ActionResult{}, err
+	}
+	for _, o := range r.listOverlays(ctx) {
+		if o.Package == overlay.Package && o.Kind == overlay.Kind {
+			return helper.ActionResult{
+				Success: false,
+				Message: fmt.Sprintf("Overlay of %s is still shown, try its close button", overlay.Package),
+			}, nil
+		}
+	}
+	return helper.ActionResult{Success: true, Message: fmt.Sprintf("Dismissed overlay of %s", overlay.Package)}, nil
+}
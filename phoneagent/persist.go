@@ -0,0 +1,129 @@
+package phoneagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/labels"
+	"autoglm-go/phoneagent/store"
+	"autoglm-go/phoneagent/trajectory"
+)
+
+// Resume continues a task saved in AgentConfig.SessionDir from its last
+// completed step. The conversation, step count and trajectory are restored;
+// plan, usage and earlier screenshots are not, the model is told to check the
+// screen again.
+func (r *PhoneAgent) Resume(ctx context.Context, sessionID string) (string, error) {
+	sessions, err := r.sessionStore()
+	if err != nil {
+		return "", err
+	}
+	if sessions == nil {
+		return "", fmt.Errorf("cannot resume %s without a session dir", sessionID)
+	}
+	session, steps, err := sessions.Load(sessionID)
+	if err != nil {
+		return "", err
+	}
+	if session.Status == store.StatusFinished {
+		return "", fmt.Errorf("session %s has finished already: %s", sessionID, session.Result)
+	}
+	if len(session.Messages) == 0 {
+		return "", fmt.Errorf("session %s has no completed step to resume from", sessionID)
+	}
+	if session.DeviceID != "" && session.DeviceID != r.AgentConfig.DeviceID {
+		r.log().Warnf("💾 session %s ran on %s, resuming it on %q", sessionID, session.DeviceID, r.AgentConfig.DeviceID)
+	}
+
+	r.Reset(ctx)
+	r.SessionID = sessionID
+	r.sessionCreatedAt = session.CreatedAt
+	r.State = append(r.State, session.Messages...)
+	r.StepCount = session.Steps
+	r.task = session.Task
+	r.Trajectory = trajectory.New(session.Task, session.DeviceID)
+	r.Trajectory.Labels = session.Labels
+	for _, step := range steps {
+		r.Trajectory.Add(step.Step)
+	}
+	r.storedSteps = len(r.Trajectory.Steps)
+	r.deviceNote = helper.GetMessage("session_resumed", r.AgentConfig.Lang)
+	r.log().Infof("💾 resuming session %s after step %d: %s", sessionID, session.Steps, session.Task)
+
+	return r.run(labels.With(ctx, session.Labels), session.Task, true)
+}
+
+// sessionStore opens the store of AgentConfig.SessionDir on first use, nil
+// when sessions are not persisted.
+func (r *PhoneAgent) sessionStore() (*store.Store, error) {
+	if r.sessions == nil && r.AgentConfig.SessionDir != "" {
+		sessions, err := store.Open(r.AgentConfig.SessionDir)
+		if err != nil {
+			return nil, err
+		}
+		r.sessions = sessions
+	}
+	return r.sessions, nil
+}
+
+// saveSession persists the task after a step. taskErr is the error that
+// ended the task, if any. Failing to save is logged, the task goes on.
+func (r *PhoneAgent) saveSession(ctx context.Context, result *StepResult, taskErr error) {
+	sessions, err := r.sessionStore()
+	if sessions == nil {
+		if err != nil {
+			r.log().Warnf("💾 failed to open session dir, err: %v", err)
+		}
+		return
+	}
+	r.startSession()
+
+	// the step log first, the session marks the steps as completed
+	if r.Trajectory != nil {
+		for _, step := range r.Trajectory.Steps[r.storedSteps:] {
+			stored := store.Step{Step: step, AgentStep: r.StepCount}
+			if obs := r.stepObservation; obs != nil && obs.screenshot != nil {
+				stored.Screenshot = store.Screenshot{
+					Width:     obs.screenshot.Width,
+					Height:    obs.screenshot.Height,
+					Bytes:     len(obs.screenshot.Data),
+					Sensitive: obs.screenshot.IsSensitive,
+				}
+			}
+			if err := sessions.AppendStep(r.SessionID, stored); err != nil {
+				r.log().Warnf("💾 failed to save step of session %s, err: %v", r.SessionID, err)
+				return
+			}
+		}
+		r.storedSteps = len(r.Trajectory.Steps)
+	}
+
+	session := &store.Session{
+		ID:        r.SessionID,
+		Task:      r.task,
+		DeviceID:  r.AgentConfig.DeviceID,
+		Labels:    labels.From(ctx),
+		Status:    store.StatusRunning,
+		Steps:     r.StepCount,
+		Messages:  r.State,
+		CreatedAt: r.sessionCreatedAt,
+	}
+	switch {
+	case errors.Is(taskErr, ErrTaskCancelled):
+		session.Status, session.Error = store.StatusCancelled, taskErr.Error()
+	case taskErr != nil:
+		session.Status, session.Error = store.StatusFailed, taskErr.Error()
+	case result != nil && result.Finished && !result.Success:
+		// e.g. the device was lost, worth resuming later
+		session.Status, session.Error = store.StatusFailed, result.Message
+	case result != nil && result.Finished:
+		session.Status, session.Result = store.StatusFinished, result.Message
+	case r.TaskPaused():
+		session.Status = store.StatusPaused
+	}
+	if err := sessions.Save(session); err != nil {
+		r.log().Warnf("💾 failed to save session %s, err: %v", r.SessionID, err)
+	}
+}
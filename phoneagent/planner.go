@@ -0,0 +1,266 @@
+package phoneagent
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/imaging"
+	"autoglm-go/phoneagent/llm"
+	"autoglm-go/utils"
+	"github.com/sashabaranov/go-openai"
+)
+
+// escalateAfterFailures is how many failed executor steps on one subgoal
+// hand the next steps to the planner model and have it review the plan.
+const escalateAfterFailures = 2
+
+// plan is the planner's split of the task and how execution is going.
+type plan struct {
+	subgoals  []string
+	current   int
+	failures  int  // failed steps on the current subgoal
+	escalated bool // the planner executes steps until one succeeds
+	deviated  bool // the plan is reviewed at the next step, out of turn
+	reviewed  int  // step count at the last review
+	recent    []string
+
+	// outcome of the previous step, judged when its result is on screen
+	lastHash    uint64
+	lastSuccess bool
+	lastMoves   bool // the action should have changed the screen
+}
+
+const (
+	plannerPromptCn = `你是手机操作任务的规划者。把用户任务拆成 2 到 8 个按顺序完成的子目标，每行一个，格式为"序号. 子目标"，不要输出其他内容。`
+	plannerPromptEn = `You plan phone automation tasks. Split the user's task into 2 to 8 ordered subgoals, one per line as "N. subgoal", and output nothing else.`
+
+	reviewPromptCn = "任务：%s\n\n计划：\n%s\n\n最近的操作：\n%s\n\n根据当前屏幕，回答正在进行的子目标序号，只输出数字。如果计划已不适用，改为输出新的编号计划。"
+	reviewPromptEn = "Task: %s\n\nPlan:\n%s\n\nRecent actions:\n%s\n\nFrom the current screen, reply with the number of the subgoal in progress, only the number. If the plan no longer fits, reply with a revised numbered plan instead."
+
+	deviationNoteCn = "\n\n执行者在子目标 %d 上连续失败了 %d 次，请确认计划是否仍然可行。"
+	deviationNoteEn = "\n\nThe executor failed %d times in a row on subgoal %d, check whether the plan still works."
+)
+
+var (
+	subgoalRe = regexp.MustCompile(`(?m)^\s*(\d+)\s*[.)）、:：]\s*(.+?)\s*$`)
+	numberRe  = regexp.MustCompile(`\d+`)
+)
+
+func parseSubgoals(text string) []string {
+	var subgoals []string
+	for _, m := range subgoalRe.FindAllStringSubmatch(text, -1) {
+		subgoals = append(subgoals, m[2])
+	}
+	return subgoals
+}
+
+func (p *plan) format() string {
+	lines := make([]string, len(p.subgoals))
+	for i, subgoal := range p.subgoals {
+		lines[i] = fmt.Sprintf("%d. %s", i+1, subgoal)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// askPlanner sends a one-off request with the screen to the planner model.
+func (r *PhoneAgent) askPlanner(ctx context.Context, system, text, imageURL string) (string, error) {
+	messages := []openai.ChatCompletionMessage{
+		helper.CreateSystemMessage(system),
+		helper.CreateUserMessageWithImageURL(text, imageURL),
+	}
+	response, err := r.Planner.Request(ctx, messages)
+	if err != nil {
+		return "", err
+	}
+	r.Usage.Add(response)
+	return response.RawContent, nil
+}
+
+// makePlan asks the planner to split the task into subgoals. Without a
+// usable plan the executor runs alone.
+func (r *PhoneAgent) makePlan(ctx context.Context, task, imageURL string) {
+	r.plan = nil
+	if r.Planner == nil {
+		return
+	}
+	system := plannerPromptCn
+	if r.AgentConfig.Lang == "en" {
+		system = plannerPromptEn
+	}
+	content, err := r.askPlanner(ctx, system, task, imageURL)
+	if err != nil {
+		r.log().Warnf("planner failed, running without a plan, err: %v", err)
+		return
+	}
+	subgoals := parseSubgoals(content)
+	if len(subgoals) == 0 {
+		r.log().Warnf("planner returned no subgoals: %s", content)
+		return
+	}
+	r.plan = &plan{subgoals: subgoals, reviewed: r.StepCount, lastSuccess: true}
+	r.log().Infof("🗺️ plan:\n%s", r.plan.format())
+	r.emitPlan()
+}
+
+// emitPlan tells the event listeners the plan and the subgoal in progress.
+func (r *PhoneAgent) emitPlan() {
+	r.emit(Event{Type: EventPlan, Plan: slices.Clone(r.plan.subgoals), Subgoal: r.plan.current + 1})
+}
+
+// reviewPlan lets the planner tell which subgoal is in progress, or revise
+// the plan, every PlannerReviewSteps steps and once the executor deviated
+// from it, see judgeLastStep.
+func (r *PhoneAgent) reviewPlan(ctx context.Context, imageURL string) {
+	p := r.plan
+	every := r.AgentConfig.PlannerReviewSteps
+	if p == nil || (!p.deviated && (every <= 0 || r.StepCount-p.reviewed < every)) {
+		return
+	}
+	deviated := p.deviated
+	p.reviewed, p.deviated = r.StepCount, false
+
+	format := reviewPromptCn
+	system := plannerPromptCn
+	if r.AgentConfig.Lang == "en" {
+		format, system = reviewPromptEn, plannerPromptEn
+	}
+	text := fmt.Sprintf(format, r.task, p.format(), strings.Join(p.recent, "\n"))
+	if deviated {
+		if r.AgentConfig.Lang == "en" {
+			text += fmt.Sprintf(deviationNoteEn, p.failures, p.current+1)
+		} else {
+			text += fmt.Sprintf(deviationNoteCn, p.current+1, p.failures)
+		}
+		// without escalation the executor gets as many tries on the reviewed plan
+		if !p.escalated {
+			p.failures = 0
+		}
+	}
+	content, err := r.askPlanner(ctx, system, text, imageURL)
+	if err != nil {
+		r.log().Warnf("plan review failed, err: %v", err)
+		return
+	}
+
+	if subgoals := parseSubgoals(content); len(subgoals) >= 2 {
+		p.subgoals, p.current, p.failures = subgoals, 0, 0
+		r.log().Infof("🗺️ revised plan:\n%s", p.format())
+		r.emitPlan()
+		return
+	}
+	if n, err := strconv.Atoi(numberRe.FindString(content)); err == nil && n >= 1 && n <= len(p.subgoals) {
+		if n-1 != p.current {
+			p.current, p.failures = n-1, 0
+			r.log().Infof("🗺️ subgoal %d of %d: %s", n, len(p.subgoals), p.subgoals[p.current])
+			r.emitPlan()
+		}
+	}
+}
+
+// judgeLastStep counts the previous step as failed when its action failed or
+// should have changed the screen but did not. After repeated failures on a
+// subgoal the plan is reviewed and the steps escalate to the planner model,
+// unless it is the executor model itself.
+func (r *PhoneAgent) judgeLastStep(screenshotData []byte) {
+	p := r.plan
+	if p == nil || len(screenshotData) == 0 {
+		return
+	}
+	hash, err := imaging.DHashData(screenshotData)
+	if err != nil {
+		return
+	}
+	defer func() { p.lastHash = hash }()
+	if r.StepCount <= 1 {
+		return
+	}
+
+	failed := !p.lastSuccess || (p.lastMoves && imaging.HashDistance(hash, p.lastHash) <= unchangedDistance)
+	switch {
+	case !failed:
+		if p.escalated {
+			r.log().Infof("🗺️ back to the executor model")
+		}
+		p.failures, p.escalated = 0, false
+	case !p.escalated:
+		p.failures++
+		if p.failures < escalateAfterFailures {
+			return
+		}
+		p.deviated = true
+		if r.Planner == r.ModelClient {
+			r.log().Infof("🗺️ executor failed %d times on %q, reviewing the plan", p.failures, p.subgoals[p.current])
+			return
+		}
+		p.escalated = true
+		r.log().Infof("🗺️ executor failed %d times on %q, escalating to the planner model", p.failures, p.subgoals[p.current])
+	}
+}
+
+// recordStep remembers the action of this step for the next judgement and
+// the plan review.
+func (r *PhoneAgent) recordStep(action helper.Action, success bool) {
+	p := r.plan
+	if p == nil {
+		return
+	}
+	name := utils.AnyToString(action["action"])
+	p.lastSuccess = success
+	switch name {
+	case "Wait", "Wait_Until", "Note", "Call_API", "Interact", "Take_over", "":
+		p.lastMoves = false
+	default:
+		p.lastMoves = true
+	}
+
+	p.recent = append(p.recent, "- "+utils.JsonString(action))
+	if len(p.recent) > 5 {
+		p.recent = p.recent[len(p.recent)-5:]
+	}
+}
+
+// planContext is the plan section of the executor prompt.
+func (r *PhoneAgent) planContext() string {
+	p := r.plan
+	if p == nil {
+		return ""
+	}
+	lines := make([]string, len(p.subgoals))
+	for i, subgoal := range p.subgoals {
+		marker := " "
+		switch {
+		case i < p.current:
+			marker = "x"
+		case i == p.current:
+			marker = ">"
+		}
+		lines[i] = fmt.Sprintf("[%s] %d. %s", marker, i+1, subgoal)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// planProgress returns the subgoal in progress, from 1, and how many the plan
+// has, zeros without a plan.
+func (r *PhoneAgent) planProgress() (int, int) {
+	if r.plan == nil {
+		return 0, 0
+	}
+	return r.plan.current + 1, len(r.plan.subgoals)
+}
+
+// stepClient is the model client for this step: the planner model while
+// escalated, otherwise the routed model or the executor model.
+func (r *PhoneAgent) stepClient() *llm.ModelClient {
+	if r.plan != nil && r.plan.escalated && r.Planner != nil {
+		return r.Planner
+	}
+	if r.stepRoute != nil {
+		return r.stepRoute.Client
+	}
+	return r.ModelClient
+}
@@ -0,0 +1,199 @@
+package phoneagent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"autoglm-go/phoneagent/helper"
+)
+
+// ActionPlugin adds a custom action, called by the model as
+// do(action="<Name>", ...). Plugins are registered once at startup and shared
+// by all agents, so Execute must be safe for concurrent use.
+type ActionPlugin interface {
+	Name() string
+	// PromptDoc documents the action for the system prompt, in the same
+	// format as the built-in actions, e.g.
+	//   - do(action="Scan", target="xxx")
+	//       Scan 扫描屏幕上的二维码。
+	PromptDoc(lang string) string
+	Execute(ctx context.Context, env *ActionContext, action helper.Action) (helper.ActionResult, error)
+}
+
+// ActionParser is implemented by plugins whose arguments do not follow the
+// default key=value syntax.
+type ActionParser interface {
+	ParseAction(raw string) (helper.Action, error)
+}
+
+// ActionSchemaProvider is implemented by plugins that declare their
+// parameters, so ParseAction checks and normalizes them. Other plugin actions
+// are accepted with any parameters.
+type ActionSchemaProvider interface {
+	Schema() []helper.ParamSpec
+}
+
+// ActionValidator is implemented by plugins that check arguments before the
+// action is executed. A validation error is reported back to the model.
+type ActionValidator interface {
+	ValidateAction(action helper.Action) error
+}
+
+// RolloutPlugin is implemented by plugins enabled on some devices only. The
+// agents of the other devices neither document nor run the action.
+type RolloutPlugin interface {
+	EnabledFor(deviceID string) bool
+}
+
+// ActionContext is what a plugin can use of the agent.
+type ActionContext struct {
+	Device       Device
+	DeviceID     string
+	Lang         string
+	ScreenWidth  int
+	ScreenHeight int
+}
+
+// Point converts model coordinates (0-999) to screen pixels.
+func (c *ActionContext) Point(element []int) (int, int) {
+	x := int(float64(element[0]) / float64(1000) * float64(c.ScreenWidth))
+	y := int(float64(element[1]) / float64(1000) * float64(c.ScreenHeight))
+	return x, y
+}
+
+var builtinActions = map[string]bool{
+	"Launch": true, "Tap": true, "Type": true, "Type_Name": true, "Swipe": true,
+	"Back": true, "Home": true, "Double Tap": true, "Long Press": true, "Wait": true,
+	"Take_over": true, "Note": true, "Call_API": true, "Interact": true, "Wait_Until": true,
+	"Dismiss_Overlay": true, "Drag": true, "Pinch": true, "Force_Stop": true, "Clear_Data": true,
+	"Install": true, "Open_Notification": true, "Dismiss_Notification": true,
+}
+
+var (
+	pluginsMu sync.RWMutex
+	plugins   = map[string]ActionPlugin{}
+)
+
+// RegisterActionPlugin makes a plugin available to every agent. Names must
+// not clash with built-in actions or other plugins.
+func RegisterActionPlugin(plugin ActionPlugin) error {
+	name := plugin.Name()
+	if name == "" {
+		return fmt.Errorf("plugin name is required")
+	}
+	if builtinActions[name] {
+		return fmt.Errorf("action %s is built in", name)
+	}
+
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+
+	if _, ok := plugins[name]; ok {
+		return fmt.Errorf("action plugin %s already registered", name)
+	}
+	schema := helper.ActionSchema{Name: name}
+	if provider, ok := plugin.(ActionSchemaProvider); ok {
+		schema.Params = provider.Schema()
+	}
+	if err := helper.RegisterActionSchema(schema); err != nil {
+		return err
+	}
+	plugins[name] = plugin
+	return nil
+}
+
+func LookupActionPlugin(name string) (ActionPlugin, bool) {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+
+	plugin, ok := plugins[name]
+	return plugin, ok
+}
+
+// pluginEnabled reports whether the plugin is enabled on the device, see
+// RolloutPlugin.
+func pluginEnabled(plugin ActionPlugin, deviceID string) bool {
+	rollout, ok := plugin.(RolloutPlugin)
+	return !ok || rollout.EnabledFor(deviceID)
+}
+
+// ActionPlugins returns the registered plugins sorted by name.
+func ActionPlugins() []ActionPlugin {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+
+	result := make([]ActionPlugin, 0, len(plugins))
+	for _, plugin := range plugins {
+		result = append(result, plugin)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name() < result[j].Name()
+	})
+	return result
+}
+
+// pluginPromptDocs renders the documentation of the plugins enabled on the
+// device as an extra section of the system prompt.
+func pluginPromptDocs(lang, deviceID string) string {
+	var registered []ActionPlugin
+	for _, plugin := range ActionPlugins() {
+		if pluginEnabled(plugin, deviceID) {
+			registered = append(registered, plugin)
+		}
+	}
+	if len(registered) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	if lang == "en" {
+		sb.WriteString("\n# Additional actions\n")
+	} else {
+		sb.WriteString("\n额外的操作指令及其作用如下：\n")
+	}
+	for _, plugin := range registered {
+		sb.WriteString(strings.TrimRight(plugin.PromptDoc(lang), "\n"))
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// parseAction parses the model output, letting a plugin with its own syntax
+// parse the calls of its action.
+func parseAction(raw string) (helper.Action, error) {
+	trimmed := strings.TrimSpace(raw)
+	if strings.HasPrefix(trimmed, `do(action="`) {
+		name := strings.TrimPrefix(trimmed, `do(action="`)
+		if i := strings.IndexByte(name, '"'); i >= 0 {
+			if plugin, ok := LookupActionPlugin(name[:i]); ok {
+				if parser, ok := plugin.(ActionParser); ok {
+					return parser.ParseAction(trimmed)
+				}
+			}
+		}
+	}
+	return helper.ParseAction(raw)
+}
+
+func (r *PhoneAgent) executePlugin(ctx context.Context, plugin ActionPlugin, action helper.Action, screenWidth, screenHeight int) (helper.ActionResult, error) {
+	if validator, ok := plugin.(ActionValidator); ok {
+		if err := validator.ValidateAction(action); err != nil {
+			return helper.ActionResult{
+				Success:      false,
+				ShouldFinish: false,
+				Message:      fmt.Sprintf("Invalid %s action: %v", plugin.Name(), err),
+			}, nil
+		}
+	}
+
+	return plugin.Execute(ctx, &ActionContext{
+		Device:       r.Device,
+		DeviceID:     r.AgentConfig.DeviceID,
+		Lang:         r.AgentConfig.Lang,
+		ScreenWidth:  screenWidth,
+		ScreenHeight: screenHeight,
+	}, action)
+}
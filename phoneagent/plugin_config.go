@@ -0,0 +1,288 @@
+package phoneagent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"autoglm-go/phoneagent/helper"
+)
+
+// ConfigAction is a custom action declared in an actions file instead of
+// code, e.g. a private action of a fine-tuned model:
+//
+//	{"name": "LongSwipe",
+//	 "params": [{"name": "start", "type": "point", "required": true},
+//	            {"name": "end", "type": "point", "required": true},
+//	            {"name": "duration", "type": "seconds", "default": 1.5}],
+//	 "docs": {"cn": "- do(action=\"LongSwipe\", start=[x1,y1], end=[x2,y2], duration=\"2 seconds\")\n    慢速滑动。"},
+//	 "shell": ["input", "swipe", "{{start.x}}", "{{start.y}}", "{{end.x}}", "{{end.y}}", "{{duration.ms}}"],
+//	 "rollout": {"groups": ["canary"], "percent": 10}}
+//
+// It runs either Shell on the device or Command as a ProcessPlugin.
+type ConfigAction struct {
+	Name   string        `json:"name"`
+	Params []ConfigParam `json:"params,omitempty"`
+	// Docs is the prompt doc by prompt language, see ActionPlugin.PromptDoc.
+	// The doc of Command is used for a language left out.
+	Docs map[string]string `json:"docs,omitempty"`
+	// Shell is the command line run on the device. Its arguments may hold
+	// the parameters as {{name}}, points in pixels as {{name.x}} and
+	// {{name.y}}, and seconds in milliseconds as {{name.ms}}.
+	Shell []string `json:"shell,omitempty"`
+	// Command is the program line of a ProcessPlugin serving the action.
+	Command []string      `json:"command,omitempty"`
+	Rollout ActionRollout `json:"rollout,omitempty"`
+}
+
+// ConfigParam declares a parameter of a ConfigAction.
+type ConfigParam struct {
+	Name     string           `json:"name"`
+	Type     helper.ParamType `json:"type,omitempty"` // any by default
+	Required bool             `json:"required,omitempty"`
+	Default  any              `json:"default,omitempty"` // of an optional parameter left out, for Shell
+}
+
+// ActionRollout selects the devices an action is enabled on, all of them
+// when it is empty. A device is in when it is listed, when its group or one
+// above it is listed, or else when it falls in the percentage; the devices
+// of a percentage are picked by a hash of the device and action, so they stay
+// in as it grows. Other devices neither see nor run the action.
+type ActionRollout struct {
+	Devices []string `json:"devices,omitempty"`
+	Groups  []string `json:"groups,omitempty"` // see UseDeviceGroups
+	Percent float64  `json:"percent,omitempty"`
+}
+
+func (r ActionRollout) empty() bool {
+	return len(r.Devices) == 0 && len(r.Groups) == 0 && r.Percent == 0
+}
+
+// includes reports whether the device is in the rollout of the action name.
+func (r ActionRollout) includes(name, deviceID string) bool {
+	if r.empty() || slices.Contains(r.Devices, deviceID) {
+		return true
+	}
+	for _, group := range deviceGroups(deviceID) {
+		if slices.Contains(r.Groups, group) {
+			return true
+		}
+	}
+	if r.Percent <= 0 {
+		return false
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name + "\x00" + deviceID))
+	return float64(h.Sum32()%10000) < r.Percent*100
+}
+
+var (
+	deviceGroupsMu sync.RWMutex
+	deviceGroupsOf func(deviceID string) []string
+)
+
+// UseDeviceGroups makes groups tell the groups of a device, its own one and
+// those above it, for the rollout of actions, and returns a function
+// restoring the previous one.
+func UseDeviceGroups(groups func(deviceID string) []string) func() {
+	deviceGroupsMu.Lock()
+	defer deviceGroupsMu.Unlock()
+	previous := deviceGroupsOf
+	deviceGroupsOf = groups
+	return func() {
+		deviceGroupsMu.Lock()
+		defer deviceGroupsMu.Unlock()
+		deviceGroupsOf = previous
+	}
+}
+
+func deviceGroups(deviceID string) []string {
+	deviceGroupsMu.RLock()
+	defer deviceGroupsMu.RUnlock()
+	if deviceGroupsOf == nil {
+		return nil
+	}
+	return deviceGroupsOf(deviceID)
+}
+
+// ReadConfigActions reads the actions file at path, a JSON list of
+// ConfigAction.
+func ReadConfigActions(path string) ([]ConfigAction, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var actions []ConfigAction
+	if err := json.Unmarshal(data, &actions); err != nil {
+		return nil, fmt.Errorf("invalid actions file %s: %w", path, err)
+	}
+	return actions, nil
+}
+
+// ConfigPlugin is the action plugin of a ConfigAction.
+type ConfigPlugin struct {
+	action  ConfigAction
+	process *ProcessPlugin
+}
+
+// NewConfigPlugin checks the action and starts its Command.
+func NewConfigPlugin(ctx context.Context, action ConfigAction) (*ConfigPlugin, error) {
+	if action.Name == "" {
+		return nil, fmt.Errorf("custom action needs a name")
+	}
+	if (len(action.Shell) == 0) == (len(action.Command) == 0) {
+		return nil, fmt.Errorf("custom action %s needs either shell or command", action.Name)
+	}
+	for _, param := range action.Params {
+		switch param.Type {
+		case "", helper.ParamString, helper.ParamNumber, helper.ParamBool, helper.ParamPoint, helper.ParamSeconds, helper.ParamAny:
+		default:
+			return nil, fmt.Errorf("custom action %s: unknown type %q of %s", action.Name, param.Type, param.Name)
+		}
+	}
+	if p := action.Rollout.Percent; p < 0 || p > 100 {
+		return nil, fmt.Errorf("custom action %s: rollout percent must be between 0 and 100", action.Name)
+	}
+
+	plugin := &ConfigPlugin{action: action}
+	if len(action.Command) > 0 {
+		process, err := StartProcessPlugin(ctx, action.Command[0], action.Command[1:]...)
+		if err != nil {
+			return nil, fmt.Errorf("custom action %s: %w", action.Name, err)
+		}
+		plugin.process = process
+	} else if action.Docs["cn"] == "" && action.Docs["en"] == "" {
+		return nil, fmt.Errorf("custom action %s needs docs", action.Name)
+	}
+	return plugin, nil
+}
+
+func (p *ConfigPlugin) Name() string {
+	return p.action.Name
+}
+
+func (p *ConfigPlugin) PromptDoc(lang string) string {
+	if doc := p.action.Docs[lang]; doc != "" {
+		return doc
+	}
+	if p.process != nil {
+		return p.process.PromptDoc(lang)
+	}
+	for _, doc := range p.action.Docs {
+		return doc
+	}
+	return ""
+}
+
+func (p *ConfigPlugin) Schema() []helper.ParamSpec {
+	specs := make([]helper.ParamSpec, 0, len(p.action.Params))
+	for _, param := range p.action.Params {
+		kind := param.Type
+		if kind == "" {
+			kind = helper.ParamAny
+		}
+		specs = append(specs, helper.ParamSpec{Name: param.Name, Type: kind, Required: param.Required})
+	}
+	return specs
+}
+
+// EnabledFor reports whether the device is in the rollout of the action.
+func (p *ConfigPlugin) EnabledFor(deviceID string) bool {
+	return p.action.Rollout.includes(p.action.Name, deviceID)
+}
+
+func (p *ConfigPlugin) Execute(ctx context.Context, env *ActionContext, action helper.Action) (helper.ActionResult, error) {
+	if p.process != nil {
+		return p.process.Execute(ctx, env, action)
+	}
+	device, ok := env.Device.(ShellDevice)
+	if !ok {
+		return helper.ActionResult{Success: false, Message: fmt.Sprintf("%s is not supported on this device", p.action.Name)}, nil
+	}
+	args, err := p.shellArgs(env, action)
+	if err != nil {
+		return helper.ActionResult{Success: false, Message: fmt.Sprintf("Invalid %s action: %v", p.action.Name, err)}, nil
+	}
+	out, err := device.Shell(ctx, env.DeviceID, args...)
+	if err != nil {
+		return helper.ActionResult{Success: false, Message: fmt.Sprintf("%s failed: %v", p.action.Name, err)}, nil
+	}
+	return helper.ActionResult{Success: true, Message: strings.TrimSpace(out)}, nil
+}
+
+// shellPlaceholder matches {{name}} and {{name.x}}, {{name.y}} or {{name.ms}}.
+var shellPlaceholder = regexp.MustCompile(`\{\{\s*([^}.\s]+)(?:\.(x|y|ms))?\s*\}\}`)
+
+// shellArgs fills the parameters of action into Shell. Values other than
+// numbers and points are quoted for the device shell.
+func (p *ConfigPlugin) shellArgs(env *ActionContext, action helper.Action) ([]string, error) {
+	params := map[string]ConfigParam{}
+	for _, param := range p.action.Params {
+		params[param.Name] = param
+	}
+	var err error
+	args := make([]string, len(p.action.Shell))
+	for i, arg := range p.action.Shell {
+		args[i] = shellPlaceholder.ReplaceAllStringFunc(arg, func(m string) string {
+			parts := shellPlaceholder.FindStringSubmatch(m)
+			value, e := shellValue(env, action, params[parts[1]], parts[1], parts[2])
+			if e != nil && err == nil {
+				err = e
+			}
+			return value
+		})
+	}
+	return args, err
+}
+
+func shellValue(env *ActionContext, action helper.Action, param ConfigParam, name, field string) (string, error) {
+	value, ok := action[name]
+	if !ok || value == nil {
+		value = param.Default
+	}
+	if value == nil {
+		return "", nil
+	}
+	switch field {
+	case "x", "y":
+		point, ok := value.([]int)
+		if !ok {
+			return "", fmt.Errorf("%s is not a point", name)
+		}
+		x, y := env.Point(point)
+		if field == "y" {
+			return strconv.Itoa(y), nil
+		}
+		return strconv.Itoa(x), nil
+	case "ms":
+		seconds, ok := helper.Seconds(value)
+		if !ok {
+			return "", fmt.Errorf("%s is not seconds", name)
+		}
+		return strconv.Itoa(int(seconds * 1000)), nil
+	}
+	switch v := value.(type) {
+	case int:
+		return strconv.Itoa(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	return "'" + strings.ReplaceAll(fmt.Sprint(value), "'", `'\''`) + "'", nil
+}
+
+// Close stops the Command of the action.
+func (p *ConfigPlugin) Close() error {
+	if p.process == nil {
+		return nil
+	}
+	return p.process.Close()
+}
@@ -0,0 +1,165 @@
+package phoneagent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+
+	"autoglm-go/phoneagent/helper"
+	logs "github.com/sirupsen/logrus"
+)
+
+// ProcessPlugin is an action plugin served by an external program, so that
+// plugins can be written in any language. The program reads one JSON request
+// per line on stdin and writes one JSON response per line on stdout:
+//
+//	{"method":"describe","lang":"cn"}
+//	    -> {"name":"Scan","prompt_doc":"- do(action=\"Scan\")\n    ..."}
+//	{"method":"execute","device_id":"...","screen_width":1080,"screen_height":2400,"action":{...}}
+//	    -> {"success":true,"should_finish":false,"message":"..."}
+//
+// A non-empty "error" field fails the request. Logs go to stderr.
+type ProcessPlugin struct {
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+
+	name string
+	docs map[string]string
+}
+
+type pluginRequest struct {
+	Method       string        `json:"method"`
+	Lang         string        `json:"lang,omitempty"`
+	DeviceID     string        `json:"device_id,omitempty"`
+	ScreenWidth  int           `json:"screen_width,omitempty"`
+	ScreenHeight int           `json:"screen_height,omitempty"`
+	Action       helper.Action `json:"action,omitempty"`
+}
+
+type pluginResponse struct {
+	Name         string `json:"name"`
+	PromptDoc    string `json:"prompt_doc"`
+	Success      bool   `json:"success"`
+	ShouldFinish bool   `json:"should_finish"`
+	Message      string `json:"message"`
+	Error        string `json:"error"`
+}
+
+// StartProcessPlugin starts the plugin program and asks it to describe its
+// action in both prompt languages.
+func StartProcessPlugin(ctx context.Context, name string, args ...string) (*ProcessPlugin, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start plugin %s: %w", name, err)
+	}
+
+	p := &ProcessPlugin{
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: bufio.NewReader(stdout),
+		docs:   map[string]string{},
+	}
+	for _, lang := range []string{"cn", "en"} {
+		resp, err := p.call(ctx, &pluginRequest{Method: "describe", Lang: lang})
+		if err != nil {
+			p.Close()
+			return nil, fmt.Errorf("failed to describe plugin %s: %w", name, err)
+		}
+		p.name = resp.Name
+		p.docs[lang] = resp.PromptDoc
+	}
+	logs.Infof("loaded action plugin %s from %s", p.name, name)
+	return p, nil
+}
+
+func (p *ProcessPlugin) Name() string {
+	return p.name
+}
+
+func (p *ProcessPlugin) PromptDoc(lang string) string {
+	if doc, ok := p.docs[lang]; ok {
+		return doc
+	}
+	return p.docs["cn"]
+}
+
+func (p *ProcessPlugin) Execute(ctx context.Context, env *ActionContext, action helper.Action) (helper.ActionResult, error) {
+	resp, err := p.call(ctx, &pluginRequest{
+		Method:       "execute",
+		DeviceID:     env.DeviceID,
+		ScreenWidth:  env.ScreenWidth,
+		ScreenHeight: env.ScreenHeight,
+		Action:       action,
+	})
+	if err != nil {
+		return helper.ActionResult{}, fmt.Errorf("plugin %s: %w", p.name, err)
+	}
+	return helper.ActionResult{
+		Success:      resp.Success,
+		ShouldFinish: resp.ShouldFinish,
+		Message:      resp.Message,
+	}, nil
+}
+
+// call sends one request and waits for its response. The program is killed
+// when ctx is cancelled first, since the protocol cannot resync after that.
+func (p *ProcessPlugin) call(ctx context.Context, req *pluginRequest) (*pluginResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stop := context.AfterFunc(ctx, func() {
+		_ = p.cmd.Process.Kill()
+	})
+	defer stop()
+
+	line, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.stdin.Write(append(line, '\n')); err != nil {
+		return nil, err
+	}
+
+	data, err := p.stdout.ReadBytes('\n')
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	var resp pluginResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("invalid plugin response: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return &resp, nil
+}
+
+// Close closes the plugin stdin and waits for the program to exit.
+func (p *ProcessPlugin) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_ = p.stdin.Close()
+	return p.cmd.Wait()
+}
@@ -0,0 +1,252 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"autoglm-go/phoneagent/definitions"
+	logs "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+type Decision string
+
+const (
+	Allow   Decision = "allow"
+	Deny    Decision = "deny"
+	Confirm Decision = "confirm" // asks the user first, see phoneagent.Confirmer
+)
+
+// defaultClasses are the classes of actions known without configuration.
+var defaultClasses = map[string][]string{
+	"payment":      {"pay", "checkout", "purchase", "buy now", "place order", "支付", "付款", "购买", "下单", "提交订单", "结算"},
+	"send_message": {"send", "发送"},
+	"delete":       {"delete", "remove", "删除", "清空"},
+}
+
+// escapeActions still run in a denied app, to leave it.
+var escapeActions = []string{"back", "home"}
+
+// Input is the action to decide about.
+type Input struct {
+	App     string // current app
+	Action  string // name, e.g. Tap
+	Target  string // app of a Launch, Force_Stop or Clear_Data
+	Text    string // typed text
+	Label   string // text of the tapped element, if known
+	Message string // of a sensitive tap
+}
+
+// Verdict is the decision about an action and the rule that made it, empty
+// for the default allow.
+type Verdict struct {
+	Decision Decision
+	Rule     string
+	Reason   string
+}
+
+type rule struct {
+	definitions.PolicyRule
+	decision Decision
+	text     *regexp.Regexp
+}
+
+// Engine evaluates the actions of every step against a PolicyConfig. It is
+// safe for concurrent use.
+type Engine struct {
+	rules    []rule
+	lists    []rule // of BlockedActions and ConfirmActions
+	denyApps []string
+	classes  map[string][]string
+	auditLog string
+}
+
+// Load reads a policy from a YAML file and checks it.
+func Load(path string) (definitions.PolicyConfig, error) {
+	var config definitions.PolicyConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("invalid policy %s: %w", path, err)
+	}
+	if _, err := New(config); err != nil {
+		return config, fmt.Errorf("invalid policy %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// New compiles config, nil when it has nothing to enforce.
+func New(config definitions.PolicyConfig) (*Engine, error) {
+	if !config.Enabled() {
+		return nil, nil
+	}
+	r := &Engine{denyApps: lower(config.DenyApps), classes: map[string][]string{}, auditLog: config.AuditLog}
+	for name, keywords := range defaultClasses {
+		r.classes[name] = keywords
+	}
+	for name, keywords := range config.Classes {
+		r.classes[strings.ToLower(name)] = lower(keywords)
+	}
+	for i, c := range config.Rules {
+		compiled := rule{PolicyRule: c, decision: Decision(strings.ToLower(c.Decision))}
+		if compiled.Name == "" {
+			compiled.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		switch compiled.decision {
+		case Allow, Deny, Confirm:
+		default:
+			return nil, fmt.Errorf("%s: invalid decision %q, want allow, deny or confirm", compiled.Name, c.Decision)
+		}
+		if c.Text != "" {
+			re, err := regexp.Compile(c.Text)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid text pattern: %w", compiled.Name, err)
+			}
+			compiled.text = re
+		}
+		compiled.Apps, compiled.Actions = lower(c.Apps), lower(c.Actions)
+		r.rules = append(r.rules, compiled)
+	}
+	for _, list := range []struct {
+		name     string
+		decision Decision
+		actions  []string
+	}{{"blocked_actions", Deny, config.BlockedActions}, {"confirm_actions", Confirm, config.ConfirmActions}} {
+		if len(list.actions) > 0 {
+			r.lists = append(r.lists, rule{
+				PolicyRule: definitions.PolicyRule{Name: list.name, Actions: lower(list.actions)},
+				decision:   list.decision,
+			})
+		}
+	}
+	return r, nil
+}
+
+func lower(values []string) []string {
+	out := make([]string, len(values))
+	for i, v := range values {
+		out[i] = strings.ToLower(strings.TrimSpace(v))
+	}
+	return out
+}
+
+// Evaluate decides about in: the first matching rule, then DenyApps, then
+// the blocked and confirmed actions. Everything else is allowed.
+func (r *Engine) Evaluate(in Input) Verdict {
+	if r == nil {
+		return Verdict{Decision: Allow}
+	}
+	in.App, in.Target, in.Action = strings.ToLower(in.App), strings.ToLower(in.Target), strings.ToLower(in.Action)
+
+	for _, rule := range r.rules {
+		if r.matches(rule, in) {
+			return rule.verdict()
+		}
+	}
+	if v, ok := r.denyApp(in); ok {
+		return v
+	}
+	for _, rule := range r.lists {
+		if r.matches(rule, in) {
+			return rule.verdict()
+		}
+	}
+	return Verdict{Decision: Allow}
+}
+
+func (r *Engine) denyApp(in Input) (Verdict, bool) {
+	if in.Target != "" && slices.Contains(r.denyApps, in.Target) {
+		return Verdict{Decision: Deny, Rule: "deny_apps", Reason: fmt.Sprintf("app %s is not allowed", in.Target)}, true
+	}
+	if in.App != "" && slices.Contains(r.denyApps, in.App) && !slices.Contains(escapeActions, in.Action) {
+		return Verdict{Decision: Deny, Rule: "deny_apps", Reason: fmt.Sprintf("app %s is not allowed, leave it with Back or Home", in.App)}, true
+	}
+	return Verdict{}, false
+}
+
+func (r rule) verdict() Verdict {
+	reason := r.Reason
+	if reason == "" {
+		reason = r.Name
+	}
+	return Verdict{Decision: r.decision, Rule: r.Name, Reason: reason}
+}
+
+func (r *Engine) matches(rule rule, in Input) bool {
+	if len(rule.Apps) > 0 && !slices.Contains(rule.Apps, in.App) && (in.Target == "" || !slices.Contains(rule.Apps, in.Target)) {
+		return false
+	}
+	if len(rule.Actions) > 0 && !slices.ContainsFunc(rule.Actions, func(action string) bool { return r.isAction(action, in) }) {
+		return false
+	}
+	if rule.text != nil && !rule.text.MatchString(in.Text) && !rule.text.MatchString(in.Label) && !rule.text.MatchString(in.Message) {
+		return false
+	}
+	return true
+}
+
+// isAction tells whether in is the action named name, or of the class name.
+func (r *Engine) isAction(name string, in Input) bool {
+	if normalize(name) == normalize(in.Action) {
+		return true
+	}
+	keywords, ok := r.classes[name]
+	if !ok {
+		return false
+	}
+	label, message := strings.ToLower(in.Label), strings.ToLower(in.Message)
+	return slices.ContainsFunc(keywords, func(keyword string) bool {
+		return (label != "" && strings.Contains(label, keyword)) || (message != "" && strings.Contains(message, keyword))
+	})
+}
+
+func normalize(action string) string {
+	return strings.NewReplacer(" ", "", "_", "").Replace(action)
+}
+
+// Entry is a line of the audit log.
+type Entry struct {
+	At       time.Time      `json:"at"`
+	TaskID   string         `json:"task_id"`
+	DeviceID string         `json:"device_id,omitempty"`
+	Step     int            `json:"step"`
+	App      string         `json:"app,omitempty"`
+	Action   map[string]any `json:"action"`
+	Decision Decision       `json:"decision"`
+	Rule     string         `json:"rule,omitempty"`
+	Reason   string         `json:"reason,omitempty"`
+}
+
+// auditMu serializes the writes of the engines of all sessions.
+var auditMu sync.Mutex
+
+// Audit appends entry to the audit log, if any.
+func (r *Engine) Audit(entry Entry) {
+	if r == nil || r.auditLog == "" {
+		return
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		logs.Warnf("failed to encode policy audit entry, err: %v", err)
+		return
+	}
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	f, err := os.OpenFile(r.auditLog, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		logs.Warnf("failed to open policy audit log, err: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		logs.Warnf("failed to write policy audit log, err: %v", err)
+	}
+}
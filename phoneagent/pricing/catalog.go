@@ -0,0 +1,207 @@
+package pricing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"autoglm-go/phoneagent/definitions"
+	logs "github.com/sirupsen/logrus"
+)
+
+// maxCatalogBytes bounds a remote catalog.
+const maxCatalogBytes = 4 << 20
+
+// Catalog is the price of the models per provider, loaded from a JSON file
+// or URL. It implements definitions.PriceCatalog and is safe for concurrent
+// use.
+//
+// The JSON object maps model names to a definitions.ModelPrice, or providers
+// to such objects:
+//
+//	{"gpt-4o": {"prompt": 0.0025, "completion": 0.01},
+//	 "anthropic": {"claude-sonnet-4": {"prompt": 0.003, "completion": 0.015}}}
+//
+// Models listed at the top level apply to every provider.
+type Catalog struct {
+	source string
+	client *http.Client
+
+	mu     sync.RWMutex
+	prices map[string]map[string]definitions.ModelPrice // by provider, "" for all, then model
+}
+
+// Load reads the catalog from source, a file path or an http(s) URL.
+func Load(ctx context.Context, source string) (*Catalog, error) {
+	r := &Catalog{source: source, client: &http.Client{Timeout: 30 * time.Second}}
+	if err := r.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Refresh reads the catalog again, the prices stay as they were when it
+// fails.
+func (r *Catalog) Refresh(ctx context.Context) error {
+	data, err := r.read(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read the pricing catalog %s: %w", r.source, err)
+	}
+	prices, err := parse(data)
+	if err != nil {
+		return fmt.Errorf("invalid pricing catalog %s: %w", r.source, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prices = prices
+	return nil
+}
+
+// Run refreshes the catalog every interval until ctx ends. Failures are
+// logged and the previous prices kept.
+func (r *Catalog) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := r.Refresh(ctx); err != nil {
+			logs.Warnf("💲 %v", err)
+			continue
+		}
+		logs.Debugf("💲 pricing catalog %s refreshed, %d model(s)", r.source, r.Len())
+	}
+}
+
+func (r *Catalog) read(ctx context.Context) ([]byte, error) {
+	if !strings.HasPrefix(r.source, "http://") && !strings.HasPrefix(r.source, "https://") {
+		return os.ReadFile(r.source)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxCatalogBytes))
+}
+
+// parse tells prices from providers by their prompt and completion keys.
+func parse(data []byte) (map[string]map[string]definitions.ModelPrice, error) {
+	var entries map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	prices := map[string]map[string]definitions.ModelPrice{"": {}}
+	for name, entry := range entries {
+		if isPrice(entry) {
+			price, err := decodePrice(name, entry)
+			if err != nil {
+				return nil, err
+			}
+			prices[""][name] = price
+			continue
+		}
+		models := map[string]definitions.ModelPrice{}
+		for model, raw := range entry {
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal(raw, &fields); err != nil || !isPrice(fields) {
+				return nil, fmt.Errorf("invalid price of %s/%s, want {\"prompt\": ..., \"completion\": ...}", name, model)
+			}
+			price, err := decodePrice(name+"/"+model, fields)
+			if err != nil {
+				return nil, err
+			}
+			models[model] = price
+		}
+		prices[strings.ToLower(name)] = models
+	}
+	return prices, nil
+}
+
+func isPrice(fields map[string]json.RawMessage) bool {
+	_, prompt := fields["prompt"]
+	_, completion := fields["completion"]
+	return prompt || completion
+}
+
+func decodePrice(name string, fields map[string]json.RawMessage) (definitions.ModelPrice, error) {
+	var price definitions.ModelPrice
+	for key, target := range map[string]*float64{"prompt": &price.Prompt, "completion": &price.Completion} {
+		raw, ok := fields[key]
+		if !ok {
+			continue
+		}
+		if err := json.Unmarshal(raw, target); err != nil || *target < 0 {
+			return price, fmt.Errorf("invalid %s price of %s: %s", key, name, raw)
+		}
+	}
+	return price, nil
+}
+
+// Price returns the price of model on provider: an exact entry of the
+// provider or of all providers, otherwise the longest entry model starts with
+// followed by a version, so that gpt-4o matches gpt-4o-2024-08-06. A
+// provider/ prefix of model is ignored.
+func (r *Catalog) Price(provider, model string) (definitions.ModelPrice, bool) {
+	provider = strings.ToLower(provider)
+	if prefix, rest, ok := strings.Cut(model, "/"); ok && strings.EqualFold(prefix, provider) {
+		model = rest
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, scope := range []string{provider, ""} {
+		if price, ok := r.prices[scope][model]; ok {
+			return price, true
+		}
+	}
+	for _, scope := range []string{provider, ""} {
+		best := ""
+		for name := range r.prices[scope] {
+			if len(name) > len(best) && isVersionOf(model, name) {
+				best = name
+			}
+		}
+		if best != "" {
+			return r.prices[scope][best], true
+		}
+	}
+	return definitions.ModelPrice{}, false
+}
+
+func isVersionOf(model, name string) bool {
+	if !strings.HasPrefix(model, name) || len(model) == len(name) {
+		return false
+	}
+	switch model[len(name)] {
+	case '-', '@', ':', '.':
+		return true
+	}
+	return false
+}
+
+// Len returns the number of models in the catalog.
+func (r *Catalog) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	n := 0
+	for _, models := range r.prices {
+		n += len(models)
+	}
+	return n
+}
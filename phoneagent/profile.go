@@ -0,0 +1,126 @@
+package phoneagent
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"autoglm-go/phoneagent/definitions"
+)
+
+// ProfileDevice is implemented by devices that can have several users or a
+// work profile, whose apps duplicate the personal ones. Observations tell the
+// model whose app is in the foreground, and AgentConfig.AndroidUser picks the
+// user apps are launched as.
+type ProfileDevice interface {
+	ListUsers(ctx context.Context, deviceID string) ([]definitions.UserProfile, error)
+	SetUser(deviceID string, userID int)
+	FocusedUser(deviceID string) (int, bool)
+}
+
+// AndroidUserWork selects the work profile of the device as
+// AgentConfig.AndroidUser, whatever its user id.
+const AndroidUserWork = "work"
+
+// selectProfile lists the users of the device, once, and makes the device
+// target AgentConfig.AndroidUser.
+func (r *PhoneAgent) selectProfile(ctx context.Context) error {
+	want := r.AgentConfig.AndroidUser
+	device, ok := r.Device.(ProfileDevice)
+	if !ok {
+		if want != "" {
+			return fmt.Errorf("devices of type %T have no users to select", r.Device)
+		}
+		return nil
+	}
+	if r.profiles == nil {
+		users, err := device.ListUsers(ctx, r.AgentConfig.DeviceID)
+		if err != nil {
+			if want != "" {
+				return err
+			}
+			r.log().Debugf("failed to list users, err: %v", err)
+			return nil
+		}
+		r.profiles = users
+		if len(users) > 1 {
+			names := make([]string, len(users))
+			for i, u := range users {
+				names[i] = describeProfile(u)
+			}
+			r.log().Infof("👥 users on the device: %s", strings.Join(names, ", "))
+		}
+	}
+	if want == "" {
+		return nil
+	}
+	user, err := findProfile(r.profiles, want)
+	if err != nil {
+		return err
+	}
+	device.SetUser(r.AgentConfig.DeviceID, user.ID)
+	r.log().Infof("👥 launching apps as %s", describeProfile(user))
+	return nil
+}
+
+// findProfile returns the user named by want, a user id or AndroidUserWork.
+func findProfile(users []definitions.UserProfile, want string) (definitions.UserProfile, error) {
+	for _, u := range users {
+		if (want == AndroidUserWork && u.Managed) || want == strconv.Itoa(u.ID) {
+			return u, nil
+		}
+	}
+	if want == AndroidUserWork {
+		return definitions.UserProfile{}, fmt.Errorf("the device has no work profile")
+	}
+	return definitions.UserProfile{}, fmt.Errorf("the device has no user %s", want)
+}
+
+func describeProfile(u definitions.UserProfile) string {
+	if u.Managed {
+		return fmt.Sprintf("%s (user %d, work profile)", u.Name, u.ID)
+	}
+	return fmt.Sprintf("%s (user %d)", u.Name, u.ID)
+}
+
+// focusedProfile returns the user of the foreground app, when the device has
+// several and it can tell.
+func (r *PhoneAgent) focusedProfile() *definitions.UserProfile {
+	device, ok := r.Device.(ProfileDevice)
+	if !ok || len(r.profiles) < 2 {
+		return nil
+	}
+	userID, ok := device.FocusedUser(r.AgentConfig.DeviceID)
+	if !ok {
+		return nil
+	}
+	for i := range r.profiles {
+		if r.profiles[i].ID == userID {
+			return &r.profiles[i]
+		}
+	}
+	return nil
+}
+
+// profileContext tells the model whose app is in the foreground, so that it
+// does not mistake the work copy of an app for the personal one.
+func (r *PhoneAgent) profileContext(obs *observation) string {
+	if obs.profile == nil {
+		return ""
+	}
+	text := "Profile: the foreground app belongs to " + describeProfile(*obs.profile)
+	var others []string
+	for _, u := range r.profiles {
+		if u.ID != obs.profile.ID {
+			others = append(others, describeProfile(u))
+		}
+	}
+	text += "; the device also has " + strings.Join(others, ", ") + ", with their own copies of the apps (work apps carry a briefcase badge)."
+	if want := r.AgentConfig.AndroidUser; want != "" {
+		if user, err := findProfile(r.profiles, want); err == nil {
+			text += " Launch opens the apps of " + describeProfile(user) + "."
+		}
+	}
+	return text
+}
@@ -0,0 +1,65 @@
+package phoneagent
+
+import (
+	"context"
+	"slices"
+
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/utils"
+)
+
+// readOnlyActions leave the device as it is: they only wait, take notes or
+// ask the user.
+var readOnlyActions = []string{"Note", "Call_API", "Wait", "Wait_Until", "Interact"}
+
+const (
+	readOnlyPromptCn = "\n\n# 只读模式\n本次任务只能观察设备，不能操作：点击、输入、滑动、返回、启动应用等操作都会被拒绝。只根据当前屏幕回答问题或检查状态，需要时可以等待，完成后用 finish(message=...) 给出答案。"
+	readOnlyPromptEn = "\n\n# Read-only mode\nThis task may only observe the device, not operate it: taps, typing, swipes, Back, Home, launching apps and the like are refused. Answer the question or check the state from the current screen, wait if needed, and give the answer with finish(message=...)."
+
+	readOnlyMessage = "Blocked: read-only mode, the device cannot be operated. Answer from the current screen with finish(message=...)."
+)
+
+type readOnlyKey struct{}
+
+// WithReadOnly returns ctx whose tasks run read-only, as with
+// AgentConfig.ReadOnly.
+func WithReadOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readOnlyKey{}, true)
+}
+
+// useReadOnly switches the agent to read-only for a task of a WithReadOnly
+// ctx and returns a function switching it back.
+func (r *PhoneAgent) useReadOnly(ctx context.Context) func() {
+	if ctx.Value(readOnlyKey{}) == nil || r.AgentConfig.ReadOnly {
+		return func() {}
+	}
+	config := r.AgentConfig
+	readOnly := *config
+	readOnly.ReadOnly = true
+	r.AgentConfig = &readOnly
+	return func() { r.AgentConfig = config }
+}
+
+// readOnlyPrompt is the system prompt section of AgentConfig.ReadOnly.
+func (r *PhoneAgent) readOnlyPrompt() string {
+	if !r.AgentConfig.ReadOnly {
+		return ""
+	}
+	if r.AgentConfig.Lang == "en" {
+		return readOnlyPromptEn
+	}
+	return readOnlyPromptCn
+}
+
+// blockInput refuses the actions that would operate the device, see
+// AgentConfig.ReadOnly.
+func (r *PhoneAgent) blockInput(action helper.Action) (helper.ActionResult, bool) {
+	if !r.AgentConfig.ReadOnly || utils.AnyToString(action["_metadata"]) != "do" {
+		return helper.ActionResult{}, false
+	}
+	if slices.Contains(readOnlyActions, utils.AnyToString(action["action"])) {
+		return helper.ActionResult{}, false
+	}
+	r.log().Warnf("👁️ read-only, step %d blocked: %s", r.StepCount, utils.JsonString(action))
+	return helper.ActionResult{Success: false, ShouldFinish: false, Message: readOnlyMessage}, true
+}
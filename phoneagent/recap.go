@@ -0,0 +1,166 @@
+package phoneagent
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/history"
+	"github.com/sashabaranov/go-openai"
+)
+
+// historyHead returns the length of the start of State that is never
+// removed: the system prompt, the first user message (task) and its answer,
+// if the model gave one.
+func (r *PhoneAgent) historyHead() int {
+	if len(r.State) > 2 && r.State[2].Role == openai.ChatMessageRoleAssistant {
+		return 3
+	}
+	return min(2, len(r.State))
+}
+
+const (
+	recapHeader     = "** Earlier Steps (summarized) **"
+	recapMaxLines   = 40  // steps listed in the recap, older ones are only counted
+	recapActionRune = 120 // caps the action of a step in the recap
+)
+
+var currentAppRe = regexp.MustCompile(`"current_app":\s*"([^"]*)"`)
+
+// dropSteps removes the n messages following the first step from State.
+// They are written to the history file, and each step becomes a line of the
+// recap in the task message, so the model still knows what it did. Steps
+// are told by their user messages: a step whose model call failed has no
+// answer.
+func (r *PhoneAgent) dropSteps(n int) {
+	records := make([]history.Record, 0, n)
+	now := time.Now()
+	app := ""
+	head := r.historyHead()
+	step := r.droppedSteps + countRole(r.State[:head], openai.ChatMessageRoleUser)
+	for i := head; i < head+n; i++ {
+		msg, index := r.State[i], r.droppedMessages+i
+		text := helper.MessageText(msg)
+		records = append(records, history.Record{
+			Index:   index,
+			Role:    msg.Role,
+			Content: text,
+			Time:    now,
+		})
+		switch msg.Role {
+		case openai.ChatMessageRoleUser:
+			step++
+			r.droppedSteps++
+			app = ""
+			if m := currentAppRe.FindStringSubmatch(text); m != nil {
+				app = m[1]
+			}
+		case openai.ChatMessageRoleAssistant:
+			r.recap = append(r.recap, recapLine(step, app, msg.Content))
+		}
+	}
+	r.spill(records)
+
+	kept := append(r.State[:head], r.State[head+n:]...)
+	clear(r.State[len(kept):])
+	r.State = kept
+	r.droppedMessages += n
+	r.State[1] = withRecap(r.State[1], r.recapText())
+}
+
+// stepStarts returns the indexes of the user messages of State past the
+// first step, each the start of a step.
+func (r *PhoneAgent) stepStarts() []int {
+	var starts []int
+	for i := r.historyHead(); i < len(r.State); i++ {
+		if r.State[i].Role == openai.ChatMessageRoleUser {
+			starts = append(starts, i)
+		}
+	}
+	return starts
+}
+
+func countRole(messages []openai.ChatCompletionMessage, role string) int {
+	n := 0
+	for _, msg := range messages {
+		if msg.Role == role {
+			n++
+		}
+	}
+	return n
+}
+
+// fitTokenBudget drops the oldest steps, past the first one, while the
+// estimated tokens of State exceed AgentConfig.HistoryTokenBudget. The
+// current prompt, the last user message, is always kept.
+func (r *PhoneAgent) fitTokenBudget() {
+	budget := r.AgentConfig.HistoryTokenBudget
+	if budget <= 0 {
+		return
+	}
+	tokens := helper.EstimateTokens(r.State)
+	if tokens <= budget {
+		return
+	}
+	head := r.historyHead()
+	end, steps := head, 0
+	for _, start := range r.stepStarts() {
+		if tokens <= budget {
+			break
+		}
+		tokens -= helper.EstimateTokens(r.State[end:start])
+		end = start
+		steps = countRole(r.State[head:end], openai.ChatMessageRoleUser)
+	}
+	if end > head {
+		r.log().Infof("📚 context about %d tokens over the budget of %d, summarizing %d earlier steps", helper.EstimateTokens(r.State)-budget, budget, steps)
+		r.dropSteps(end - head)
+	}
+}
+
+// recapLine describes a removed step by its app and action.
+func recapLine(step int, app, answer string) string {
+	action := answer
+	if _, after, ok := strings.Cut(answer, "<answer>"); ok {
+		action, _, _ = strings.Cut(after, "</answer>")
+	}
+	action = strings.Join(strings.Fields(action), " ")
+	if runes := []rune(action); len(runes) > recapActionRune {
+		action = string(runes[:recapActionRune]) + "…"
+	}
+	if app == "" {
+		return fmt.Sprintf("Step %d: %s", step, action)
+	}
+	return fmt.Sprintf("Step %d (%s): %s", step, app, action)
+}
+
+func (r *PhoneAgent) recapText() string {
+	var sb strings.Builder
+	sb.WriteString(recapHeader + "\n")
+	lines := r.recap
+	if len(lines) > recapMaxLines {
+		fmt.Fprintf(&sb, "(%d earlier steps omitted)\n", len(lines)-recapMaxLines)
+		lines = lines[len(lines)-recapMaxLines:]
+	}
+	sb.WriteString(strings.Join(lines, "\n"))
+	return sb.String()
+}
+
+// withRecap puts recap at the end of msg, replacing the previous one.
+func withRecap(msg openai.ChatCompletionMessage, recap string) openai.ChatCompletionMessage {
+	if msg.MultiContent == nil {
+		content, _, _ := strings.Cut(msg.Content, "\n\n"+recapHeader)
+		msg.Content = content + "\n\n" + recap
+		return msg
+	}
+	parts := make([]openai.ChatMessagePart, 0, len(msg.MultiContent)+1)
+	for _, part := range msg.MultiContent {
+		if part.Type != openai.ChatMessagePartTypeText || !strings.HasPrefix(part.Text, recapHeader) {
+			parts = append(parts, part)
+		}
+	}
+	msg.MultiContent = append(parts, openai.ChatMessagePart{Type: openai.ChatMessagePartTypeText, Text: recap})
+	return msg
+}
@@ -0,0 +1,58 @@
+package phoneagent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"autoglm-go/phoneagent/helper"
+)
+
+// reconnectPollInterval is how often a lost device is looked for again.
+const reconnectPollInterval = 2 * time.Second
+
+// deviceLost reports whether the device dropped off. It is only asked after
+// something failed, the check costs a round trip to adb or the server.
+func (r *PhoneAgent) deviceLost(ctx context.Context) bool {
+	return r.AgentConfig.ReconnectTimeout > 0 && !r.isConnected(ctx)
+}
+
+// reconnect waits up to AgentConfig.ReconnectTimeout for the device to come
+// back, running adb connect again for network devices. Everything the agent
+// knew about the screen is dropped, the next step starts from a fresh
+// observation and tells the model about the interruption.
+func (r *PhoneAgent) reconnect(ctx context.Context) error {
+	deviceID, timeout := r.AgentConfig.DeviceID, r.AgentConfig.ReconnectTimeout
+	r.log().Warnf("📵 device %s disconnected, waiting up to %s for it to come back", deviceID, timeout)
+
+	start := time.Now()
+	for {
+		if strings.Contains(deviceID, ":") {
+			if _, err := r.Device.Connect(ctx, deviceID); err != nil {
+				r.log().Debugf("reconnect %s failed, err: %v", deviceID, err)
+			}
+		}
+		if r.isConnected(ctx) {
+			break
+		}
+		if time.Since(start) >= timeout {
+			return fmt.Errorf("device %s did not reconnect within %s", deviceID, timeout)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(reconnectPollInterval):
+		}
+	}
+	r.log().Infof("📶 device %s reconnected after %s", deviceID, time.Since(start).Round(time.Second))
+
+	// the prefetched observation and the predictions are from before the drop
+	r.dropObservation()
+	r.dropSpeculation()
+	r.lastUIElements = nil
+	r.imageCache = nil
+	r.closeWeb()
+	r.deviceNote = helper.GetMessage("device_reconnected", r.AgentConfig.Lang)
+	return nil
+}
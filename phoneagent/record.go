@@ -0,0 +1,201 @@
+package phoneagent
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/imaging"
+	"autoglm-go/phoneagent/labels"
+	"autoglm-go/phoneagent/llm"
+	"autoglm-go/phoneagent/policy"
+	"autoglm-go/phoneagent/recorder"
+	"github.com/google/uuid"
+	"github.com/sashabaranov/go-openai"
+)
+
+// pendingRecord is the record of the running step, written once the step
+// ends, see AgentConfig.RecordDir.
+type pendingRecord struct {
+	*recorder.Record
+	image    []byte
+	started  time.Time
+	snapshot *recorder.Snapshot // of AgentConfig.RecordSnapshots
+}
+
+// startSession gives the task its session id, shared by the session store
+// and the step records.
+func (r *PhoneAgent) startSession() {
+	if r.SessionID != "" {
+		return
+	}
+	r.SessionID = uuid.New().String()
+	r.sessionCreatedAt = time.Now()
+	if r.AgentConfig.SessionDir != "" {
+		r.log().Infof("💾 session %s, resume it with --resume %s", r.SessionID, r.SessionID)
+	}
+}
+
+// startRecord begins the record of the step once the observation is taken.
+func (r *PhoneAgent) startRecord(ctx context.Context, obs *observation, started time.Time) {
+	r.record = nil
+	if r.AgentConfig.RecordDir == "" {
+		return
+	}
+	r.startSession()
+	record := &recorder.Record{
+		Session:  r.SessionID,
+		Step:     r.StepCount,
+		Time:     started,
+		DeviceID: r.AgentConfig.DeviceID,
+		Labels:   labels.From(ctx),
+		App:      obs.currentApp,
+	}
+	record.Timings.Observe = time.Since(started).Seconds()
+	if r.video != nil {
+		record.Video = r.video.ref
+		record.VideoTime = started.Sub(r.video.started).Seconds()
+	}
+	pending := &pendingRecord{Record: record, started: started}
+	if s := obs.screenshot; s != nil {
+		record.Screenshot = &recorder.Screenshot{Width: s.Width, Height: s.Height, Sensitive: s.IsSensitive, Bytes: len(s.Data)}
+		pending.image = s.Data
+	}
+	if r.AgentConfig.RecordSnapshots {
+		pending.snapshot = r.snapshot()
+	}
+	r.record = pending
+}
+
+// snapshot is the state of the agent as the step begins, before its
+// observation is added.
+func (r *PhoneAgent) snapshot() *recorder.Snapshot {
+	messages := make([]openai.ChatCompletionMessage, len(r.State))
+	for i, msg := range r.State {
+		// RemoveImagesFromMessage filters in place, State keeps its images
+		msg.MultiContent = slices.Clone(msg.MultiContent)
+		messages[i] = helper.RemoveImagesFromMessage(msg)
+	}
+	return &recorder.Snapshot{
+		Step:            r.StepCount,
+		Task:            r.task,
+		Messages:        messages,
+		DroppedMessages: r.droppedMessages,
+		Plan:            r.planContext(),
+		Notes:           r.deviceNote,
+		LastStepOK:      r.lastStepOK,
+		PolicyBlocks:    slices.Clone(r.policyBlocks),
+	}
+}
+
+// recordPolicy adds a decision of the policy to the snapshot of the step.
+func (r *PhoneAgent) recordPolicy(action helper.Action, verdict policy.Verdict) {
+	if r.record == nil || r.record.snapshot == nil {
+		return
+	}
+	r.record.snapshot.Policy = append(r.record.snapshot.Policy, recorder.PolicyDecision{
+		Action:   action,
+		Decision: string(verdict.Decision),
+		Rule:     verdict.Rule,
+		Reason:   verdict.Reason,
+	})
+}
+
+// recordImage keeps the size of the screenshot as sent, the record dir has
+// the original.
+func (r *PhoneAgent) recordImage(encoded *imaging.Encoded) {
+	if r.record == nil || r.record.Screenshot == nil {
+		return
+	}
+	r.record.Screenshot.Sent = &recorder.SentImage{Width: encoded.Width, Height: encoded.Height, MimeType: encoded.MimeType, Bytes: encoded.Size}
+}
+
+// recordPrompt keeps the observation sent to the model, and the system prompt
+// on the first step.
+func (r *PhoneAgent) recordPrompt(isFirstStep bool) {
+	if r.record == nil || len(r.State) == 0 {
+		return
+	}
+	r.record.Task = r.task
+	if isFirstStep && r.State[0].Role == openai.ChatMessageRoleSystem {
+		r.record.SystemPrompt = r.State[0].Content
+	}
+	r.record.Prompt = messageText(r.State[len(r.State)-1])
+}
+
+func (r *PhoneAgent) recordResponse(response *llm.ModelResponse) {
+	if r.record == nil {
+		return
+	}
+	r.record.Model = response.Model
+	r.record.RawOutput = response.RawContent
+	r.record.Thinking = response.Thinking
+	r.record.ActionText = response.Action
+	r.record.Usage = response.Usage
+	r.record.Timings.Model = response.TotalTime
+	r.record.Timings.TimeToFirstToken = response.TimeToFirstToken
+}
+
+// finishRecord writes the record of the step with its result. Failing to
+// write is logged, the task goes on.
+func (r *PhoneAgent) finishRecord(result *StepResult, err error) {
+	pending := r.record
+	if pending == nil {
+		return
+	}
+	r.record = nil
+	rec, openErr := r.stepRecorder()
+	if openErr != nil {
+		r.log().Warnf("failed to open record dir, err: %v", openErr)
+		return
+	}
+	switch {
+	case err != nil:
+		pending.Error = err.Error()
+	case result != nil:
+		pending.Action = result.Action
+		pending.Result = &recorder.Result{Success: result.Success, Finished: result.Finished, Message: result.Message}
+		if result.Action == nil && !result.Success {
+			// no model answer, or one that did not parse
+			pending.Error = result.Message
+		}
+	}
+	pending.Timings.Step = time.Since(pending.started).Seconds()
+	if pending.snapshot != nil {
+		if err := rec.WriteSnapshot(pending.Record, pending.snapshot); err != nil {
+			r.log().Warnf("failed to record the state of step %d, err: %v", pending.Step, err)
+		}
+	}
+	if err := rec.Write(pending.Record, pending.image); err != nil {
+		r.log().Warnf("failed to record step %d, err: %v", pending.Step, err)
+	}
+	r.markVideo(pending)
+}
+
+// stepRecorder opens the recorder of AgentConfig.RecordDir on first use.
+func (r *PhoneAgent) stepRecorder() (*recorder.Recorder, error) {
+	if r.recorder == nil {
+		rec, err := recorder.New(r.AgentConfig.RecordDir)
+		if err != nil {
+			return nil, err
+		}
+		r.recorder = rec
+	}
+	return r.recorder, nil
+}
+
+// messageText joins the text parts of msg.
+func messageText(msg openai.ChatCompletionMessage) string {
+	if msg.MultiContent == nil {
+		return msg.Content
+	}
+	var parts []string
+	for _, part := range msg.MultiContent {
+		if part.Type == openai.ChatMessagePartTypeText {
+			parts = append(parts, part.Text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
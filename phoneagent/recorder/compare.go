@@ -0,0 +1,188 @@
+package recorder
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/llm"
+	"autoglm-go/utils"
+	"github.com/sashabaranov/go-openai"
+)
+
+// defaultTolerance is how far apart, on the 0-999 grid, two points may be and
+// still count as the same target.
+const defaultTolerance = 50
+
+type CompareOptions struct {
+	Tolerance int // 0 means defaultTolerance
+}
+
+// StepComparison is the answer of the candidate model on one recorded step.
+type StepComparison struct {
+	Step          int           `json:"step"`
+	Recorded      helper.Action `json:"recorded"`
+	Candidate     helper.Action `json:"candidate,omitempty"`
+	CandidateText string        `json:"candidate_text,omitempty"`
+	Match         bool          `json:"match"`
+	Reason        string        `json:"reason,omitempty"` // why the actions differ
+}
+
+// Comparison is the outcome of Compare.
+type Comparison struct {
+	Model   string           `json:"model"`
+	Steps   []StepComparison `json:"steps"`
+	Matched int              `json:"matched"`
+	Usage   llm.ModelUsage   `json:"usage"`
+	// Diverged is the step where a Rewind left the recording, 0 when it
+	// did not.
+	Diverged int `json:"diverged,omitempty"`
+}
+
+// Compare shows the recorded screens of a session to another model, one step
+// after the other, and checks whether it picks the recorded actions. The
+// recorded answers, not the candidate's, stay in the conversation, so every
+// step is judged on the same history. dir is the record dir the screenshot
+// paths are relative to.
+func Compare(ctx context.Context, client *llm.ModelClient, dir string, records []Record, opts CompareOptions) (*Comparison, error) {
+	if opts.Tolerance <= 0 {
+		opts.Tolerance = defaultTolerance
+	}
+	comparison := &Comparison{Model: client.Config().ModelName}
+	usage := llm.NewUsageMeter()
+	defer func() { comparison.Usage = usage.Total() }()
+	var messages []openai.ChatCompletionMessage
+	for _, record := range records {
+		if record.SystemPrompt != "" {
+			messages = append(messages[:0], helper.CreateSystemMessage(record.SystemPrompt))
+		}
+		if record.Action == nil {
+			// the recorded step has no answer to compare with
+			continue
+		}
+		if len(messages) == 0 {
+			return nil, fmt.Errorf("step %d comes before the system prompt, records must start with the first step of a task", record.Step)
+		}
+
+		user, err := stepMessage(dir, record, record.Prompt)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, user)
+
+		response, err := client.RequestWithOptions(ctx, messages, llm.RequestOptions{OnThinkingDone: func(string) {}})
+		if err != nil {
+			return nil, fmt.Errorf("step %d: %w", record.Step, err)
+		}
+		usage.Add(response)
+
+		step := StepComparison{Step: record.Step, Recorded: record.Action, CandidateText: response.Action}
+		candidate := response.ToolAction
+		if candidate == nil {
+			candidate, err = helper.ParseAction(response.Action)
+		}
+		if err != nil {
+			step.Reason = "invalid action: " + err.Error()
+		} else {
+			step.Candidate = candidate
+			step.Match, step.Reason = sameAction(record.Action, candidate, opts.Tolerance)
+		}
+		if step.Match {
+			comparison.Matched++
+		}
+		comparison.Steps = append(comparison.Steps, step)
+
+		// the next step sees the recorded answer, and only the latest screenshot
+		messages[len(messages)-1] = helper.RemoveImagesFromMessage(messages[len(messages)-1])
+		messages = append(messages, recordedAnswer(record))
+	}
+	return comparison, nil
+}
+
+// stepMessage is the observation of the recorded step with prompt and the
+// recorded screenshot, dir is the record dir.
+func stepMessage(dir string, record Record, prompt string) (openai.ChatCompletionMessage, error) {
+	if record.Screenshot == nil || record.Screenshot.Path == "" && record.Screenshot.Key == "" {
+		return openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: prompt}, nil
+	}
+	image, err := ReadScreenshot(dir, record.Screenshot)
+	if err != nil {
+		return openai.ChatCompletionMessage{}, fmt.Errorf("failed to read screenshot of step %d: %w", record.Step, err)
+	}
+	dataURL := "data:" + http.DetectContentType(image) + ";base64," + base64.StdEncoding.EncodeToString(image)
+	return helper.CreateUserMessageWithImageURL(prompt, dataURL), nil
+}
+
+// recordedAnswer is the answer of the model in the recorded step.
+func recordedAnswer(record Record) openai.ChatCompletionMessage {
+	answer := record.ActionText
+	if answer == "" {
+		answer = helper.FormatAction(record.Action)
+	}
+	return helper.CreateAssistantMessage(fmt.Sprintf("<think>%s</think><answer>%s</answer>", record.Thinking, answer))
+}
+
+// sameAction reports whether the candidate does what the recorded action
+// did, and why not.
+func sameAction(recorded, candidate helper.Action, tolerance int) (bool, string) {
+	if recorded["_metadata"] != candidate["_metadata"] {
+		return false, fmt.Sprintf("%v instead of %v", candidate["_metadata"], recorded["_metadata"])
+	}
+	if recorded["_metadata"] == "finish" {
+		return true, ""
+	}
+	name := utils.AnyToString(recorded["action"])
+	if got := utils.AnyToString(candidate["action"]); got != name {
+		return false, fmt.Sprintf("%s instead of %s", got, name)
+	}
+	for _, key := range []string{"element", "start", "end"} {
+		want, got := utils.AnyToIntSlice(recorded[key]), utils.AnyToIntSlice(candidate[key])
+		if len(want) != 2 {
+			continue
+		}
+		if len(got) != 2 {
+			return false, "no " + key
+		}
+		if d := math.Hypot(float64(want[0]-got[0]), float64(want[1]-got[1])); d > float64(tolerance) {
+			return false, fmt.Sprintf("%s %v is %.0f away from %v", key, got, d, want)
+		}
+	}
+	for _, key := range []string{"text", "app"} {
+		want, got := strings.TrimSpace(fmt.Sprint(recorded[key])), strings.TrimSpace(fmt.Sprint(candidate[key]))
+		if recorded[key] != nil && !strings.EqualFold(want, got) {
+			return false, fmt.Sprintf("%s %q instead of %q", key, got, want)
+		}
+	}
+	return true, ""
+}
+
+// String renders the comparison as a table, one line per step.
+func (c *Comparison) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%-6s %-6s %-40s %s\n", "STEP", "MATCH", "RECORDED", "CANDIDATE / REASON")
+	for _, step := range c.Steps {
+		match := "yes"
+		if !step.Match {
+			match = "no"
+		}
+		candidate := step.CandidateText
+		if step.Reason != "" {
+			candidate += " (" + step.Reason + ")"
+		}
+		fmt.Fprintf(&sb, "%-6d %-6s %-40s %s\n", step.Step, match, helper.FormatAction(step.Recorded), candidate)
+	}
+	rate := 0.0
+	if len(c.Steps) > 0 {
+		rate = float64(c.Matched) / float64(len(c.Steps)) * 100
+	}
+	fmt.Fprintf(&sb, "%s matched %d of %d steps (%.1f%%); %d tokens, cost %.4f",
+		c.Model, c.Matched, len(c.Steps), rate, c.Usage.TotalTokens(), c.Usage.Cost)
+	if c.Diverged > 0 {
+		fmt.Fprintf(&sb, "\nleft the recording at step %d, the recorded screens after it do not apply", c.Diverged)
+	}
+	return sb.String()
+}
@@ -0,0 +1,184 @@
+package recorder
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/seal"
+	"github.com/sashabaranov/go-openai"
+)
+
+// Outcomes of the tasks a Dataset keeps.
+const (
+	OutcomeSuccess = "success" // finished with finish()
+	OutcomeFailed  = "failed"
+	OutcomeAll     = "all"
+)
+
+type DatasetOptions struct {
+	Outcome string // OutcomeSuccess when empty
+	// ImagePrefix replaces the record dir in the screenshot references, e.g.
+	// the URL the training pipeline reads them from. Without it they are the
+	// paths of the screenshots on disk, or their data URLs when the
+	// artifacts are encrypted, see package seal.
+	ImagePrefix string
+}
+
+// Sample is a training sample in the chat format of fine-tuning APIs: the
+// conversation the model saw at one step, ending with the recorded answer.
+type Sample struct {
+	Messages []openai.ChatCompletionMessage `json:"messages"`
+}
+
+// DatasetStats counts what a Dataset wrote and left out.
+type DatasetStats struct {
+	Tasks      int `json:"tasks"`      // kept
+	Filtered   int `json:"filtered"`   // tasks of another outcome
+	Samples    int `json:"samples"`    // written
+	Failed     int `json:"failed"`     // steps whose action failed, kept only as history
+	Duplicates int `json:"duplicates"` // samples already written
+}
+
+// Dataset converts recorded tasks into samples, one per step, written to w
+// as JSON lines. Like Compare, earlier steps keep their text and recorded
+// answer but only the current step shows its screenshot. Two samples with
+// the same texts are duplicates, e.g. of reruns of a task, and only the
+// first is written.
+type Dataset struct {
+	w     *json.Encoder
+	opts  DatasetOptions
+	seen  map[[sha256.Size]byte]struct{}
+	Stats DatasetStats
+}
+
+func NewDataset(w io.Writer, opts DatasetOptions) (*Dataset, error) {
+	switch opts.Outcome {
+	case "":
+		opts.Outcome = OutcomeSuccess
+	case OutcomeSuccess, OutcomeFailed, OutcomeAll:
+	default:
+		return nil, fmt.Errorf("invalid outcome %q, want success, failed or all", opts.Outcome)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	return &Dataset{w: encoder, opts: opts, seen: map[[sha256.Size]byte]struct{}{}}, nil
+}
+
+// Add writes the samples of the tasks of a session, as returned by Load. dir
+// is the record dir the screenshot paths are relative to.
+func (r *Dataset) Add(dir string, records []Record) error {
+	for _, task := range splitTasks(records) {
+		if !r.keeps(task) {
+			r.Stats.Filtered++
+			continue
+		}
+		r.Stats.Tasks++
+		if err := r.addTask(dir, task); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitTasks starts a task at every record with a system prompt, records
+// before the first one are dropped.
+func splitTasks(records []Record) [][]Record {
+	var tasks [][]Record
+	for _, record := range records {
+		if record.SystemPrompt != "" {
+			tasks = append(tasks, nil)
+		}
+		if len(tasks) > 0 {
+			tasks[len(tasks)-1] = append(tasks[len(tasks)-1], record)
+		}
+	}
+	return tasks
+}
+
+func (r *Dataset) keeps(task []Record) bool {
+	if r.opts.Outcome == OutcomeAll {
+		return true
+	}
+	last := task[len(task)-1]
+	succeeded := last.Result != nil && last.Result.Finished && last.Result.Success && last.Action["_metadata"] == "finish"
+	return succeeded == (r.opts.Outcome == OutcomeSuccess)
+}
+
+func (r *Dataset) addTask(dir string, task []Record) error {
+	messages := []openai.ChatCompletionMessage{helper.CreateSystemMessage(task[0].SystemPrompt)}
+	for _, record := range task {
+		if record.Action == nil {
+			// the model saw no answer of its own
+			continue
+		}
+		user := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: record.Prompt}
+		if record.Screenshot != nil && (record.Screenshot.Path != "" || record.Screenshot.Key != "") {
+			url, err := r.imageURL(dir, record.Screenshot)
+			if err != nil {
+				return fmt.Errorf("session %s step %d: %w", record.Session, record.Step, err)
+			}
+			user = helper.CreateUserMessageWithImageURL(record.Prompt, url)
+		}
+		answer := record.ActionText
+		if answer == "" {
+			answer = helper.FormatAction(record.Action)
+		}
+		assistant := helper.CreateAssistantMessage(fmt.Sprintf("<think>%s</think><answer>%s</answer>", record.Thinking, answer))
+
+		if record.Result != nil && !record.Result.Success {
+			r.Stats.Failed++
+		} else if err := r.write(append(messages, user, assistant)); err != nil {
+			return fmt.Errorf("session %s step %d: %w", record.Session, record.Step, err)
+		}
+		messages = append(messages, helper.RemoveImagesFromMessage(user), assistant)
+	}
+	return nil
+}
+
+func (r *Dataset) imageURL(dir string, shot *Screenshot) (string, error) {
+	path := shot.Path
+	if shot.Key != "" {
+		path = shot.Key
+	}
+	if r.opts.ImagePrefix != "" {
+		return r.opts.ImagePrefix + path, nil
+	}
+	file := filepath.Join(dir, filepath.FromSlash(path))
+	if !seal.Enabled() && shot.Key == "" {
+		return file, nil
+	}
+	// an encrypted screenshot is of no use to whoever reads the dataset, nor
+	// one in an artifact store
+	image, err := ReadScreenshot(dir, shot)
+	if err != nil {
+		return "", err
+	}
+	return "data:" + http.DetectContentType(image) + ";base64," + base64.StdEncoding.EncodeToString(image), nil
+}
+
+func (r *Dataset) write(messages []openai.ChatCompletionMessage) error {
+	key := sha256.New()
+	for _, message := range messages {
+		texts := []string{message.Role, message.Content}
+		for _, part := range message.MultiContent {
+			texts = append(texts, part.Text)
+		}
+		fmt.Fprintf(key, "%q\n", strings.Join(texts, "\x00"))
+	}
+	var sum [sha256.Size]byte
+	key.Sum(sum[:0])
+	if _, ok := r.seen[sum]; ok {
+		r.Stats.Duplicates++
+		return nil
+	}
+	r.seen[sum] = struct{}{}
+	r.Stats.Samples++
+	return r.w.Encode(Sample{Messages: messages})
+}
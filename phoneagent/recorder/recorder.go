@@ -0,0 +1,227 @@
+package recorder
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"autoglm-go/phoneagent/artifact"
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/seal"
+	"autoglm-go/utils"
+	"github.com/sashabaranov/go-openai"
+)
+
+var sessionRe = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Record is one agent step as seen by the model and the device, for building
+// fine-tuning and evaluation datasets from real runs.
+type Record struct {
+	Session  string            `json:"session"`
+	Step     int               `json:"step"`
+	Time     time.Time         `json:"time"`
+	Task     string            `json:"task"`
+	DeviceID string            `json:"device_id,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	App      string            `json:"app,omitempty"` // foreground app before the action
+
+	Screenshot *Screenshot `json:"screenshot,omitempty"`
+	Snapshot   string      `json:"snapshot,omitempty"` // path of the Snapshot of the step, see WriteSnapshot
+	// SystemPrompt is set on the first step of a task, the prompt of the
+	// next steps is only the observation.
+	SystemPrompt string `json:"system_prompt,omitempty"`
+	Prompt       string `json:"prompt"` // text of the observation
+
+	Model      string        `json:"model,omitempty"`
+	RawOutput  string        `json:"raw_output,omitempty"`
+	Thinking   string        `json:"thinking,omitempty"`
+	ActionText string        `json:"action_text,omitempty"` // the action as the model wrote it
+	Action     helper.Action `json:"action,omitempty"`
+	Error      string        `json:"error,omitempty"` // why the step has no action or result
+	Result     *Result       `json:"result,omitempty"`
+	Usage      *openai.Usage `json:"usage,omitempty"`
+	Timings    Timings       `json:"timings"`
+
+	// Video is the session video the step is in, relative to the record
+	// dir or under videos/ of the artifact storage, and VideoTime where the
+	// step begins in it, in seconds.
+	Video     string  `json:"video,omitempty"`
+	VideoTime float64 `json:"video_time,omitempty"`
+}
+
+type Screenshot struct {
+	Path string `json:"path,omitempty"` // relative to the record dir, empty for sensitive screens
+	// Key is where the screenshot is in the storage of artifact.Use instead
+	// of Path, content-addressed under screenshots/.
+	Key       string `json:"key,omitempty"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Sensitive bool   `json:"sensitive,omitempty"`
+	Bytes     int    `json:"bytes,omitempty"` // of the screenshot at Path
+	// Sent is the image the model got, after resizing and re-encoding.
+	Sent *SentImage `json:"sent,omitempty"`
+}
+
+type SentImage struct {
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	MimeType string `json:"mime_type"`
+	Bytes    int    `json:"bytes"` // before base64
+}
+
+type Result struct {
+	Success  bool   `json:"success"`
+	Finished bool   `json:"finished"`
+	Message  string `json:"message,omitempty"`
+}
+
+// Timings are in seconds.
+type Timings struct {
+	Observe          float64  `json:"observe"` // screenshot, UI dump and dialog handling
+	Model            float64  `json:"model"`
+	TimeToFirstToken *float64 `json:"time_to_first_token,omitempty"`
+	Action           float64  `json:"action"`
+	Step             float64  `json:"step"`
+}
+
+// Recorder appends the steps of each session to <dir>/<session>.jsonl and
+// keeps their screenshots in <dir>/<session>/.
+type Recorder struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func New(dir string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create record dir: %w", err)
+	}
+	return &Recorder{dir: dir}, nil
+}
+
+// Write saves the screenshot, unless it shows a sensitive screen, and
+// appends the record.
+func (r *Recorder) Write(record *Record, image []byte) error {
+	if !sessionRe.MatchString(record.Session) {
+		return fmt.Errorf("invalid session id %q", record.Session)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if shot := record.Screenshot; shot != nil && !shot.Sensitive && len(image) > 0 {
+		if err := r.saveScreenshot(record, image); err != nil {
+			return fmt.Errorf("failed to save screenshot: %w", err)
+		}
+	}
+
+	file, err := os.OpenFile(filepath.Join(r.dir, record.Session+".jsonl"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open record file: %w", err)
+	}
+	if _, err := file.WriteString(seal.Line(utils.JsonString(record)) + "\n"); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write record: %w", err)
+	}
+	return file.Close()
+}
+
+// saveScreenshot keeps the screenshot of record in the storage of
+// artifact.Use, or the dir of its session without one.
+func (r *Recorder) saveScreenshot(record *Record, image []byte) error {
+	if storage := artifact.Current(); storage != nil {
+		key := artifact.ContentKey("screenshots", image, imageExt(image))
+		if err := storage.Put(context.Background(), key, seal.Data(image), http.DetectContentType(image)); err != nil {
+			return err
+		}
+		record.Screenshot.Key = key
+		return nil
+	}
+	name := fmt.Sprintf("step-%04d%s", record.Step, imageExt(image))
+	dir := filepath.Join(r.dir, record.Session)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	if err := seal.WriteFile(filepath.Join(dir, name), image, 0o644); err != nil {
+		return err
+	}
+	record.Screenshot.Path = filepath.ToSlash(filepath.Join(record.Session, name))
+	return nil
+}
+
+// ReadScreenshot reads the screenshot of a record, dir is the record dir.
+func ReadScreenshot(dir string, shot *Screenshot) ([]byte, error) {
+	if shot.Key != "" {
+		return readArtifact(shot.Key)
+	}
+	return seal.ReadFile(filepath.Join(dir, filepath.FromSlash(shot.Path)))
+}
+
+// readArtifact reads key from the storage of artifact.Use.
+func readArtifact(key string) ([]byte, error) {
+	storage := artifact.Current()
+	if storage == nil {
+		return nil, fmt.Errorf("%s is in an artifact store, set --artifact-store to read it", key)
+	}
+	data, err := storage.Get(context.Background(), key)
+	if err != nil {
+		return nil, err
+	}
+	return seal.Open(data)
+}
+
+func imageExt(image []byte) string {
+	switch http.DetectContentType(image) {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/webp":
+		return ".webp"
+	default:
+		return ".png"
+	}
+}
+
+// Load reads the records of a session file written by Write.
+func Load(path string) ([]Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		data, err := seal.OpenLine(scanner.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("invalid record at %s:%d: %w", path, line, err)
+		}
+		var record Record
+		if err := json.Unmarshal(data, &record); err != nil {
+			return nil, fmt.Errorf("invalid record at %s:%d: %w", path, line, err)
+		}
+		// points decode as []any, actions use []int
+		for key, value := range record.Action {
+			if items, ok := value.([]any); ok {
+				if ints := utils.AnyToIntSlice(items); len(ints) == len(items) {
+					record.Action[key] = ints
+				}
+			}
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
@@ -0,0 +1,148 @@
+package recorder
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/llm"
+	"github.com/sashabaranov/go-openai"
+)
+
+// RewindOptions select the step a session is rewound to and what changes
+// from there.
+type RewindOptions struct {
+	Step        int
+	Instruction string // replaces the task everywhere it was told, empty keeps it
+	Observation string // replaces the text of the observation of Step, empty keeps it
+	Tolerance   int    // 0 means defaultTolerance
+}
+
+// Rewind restores the conversation of a session as it was when a step began,
+// from its snapshot or else from the records before it, and runs the model
+// forward from there on the recorded screens. As the screens after an action
+// are only known for the recorded one, the run stops at the first step where
+// the model picks another action, see Comparison.Diverged, or at a finish.
+// dir is the record dir.
+func Rewind(ctx context.Context, client *llm.ModelClient, dir string, records []Record, opts RewindOptions) (*Comparison, error) {
+	if opts.Tolerance <= 0 {
+		opts.Tolerance = defaultTolerance
+	}
+	start := -1
+	for i, record := range records {
+		if record.Step == opts.Step {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return nil, fmt.Errorf("step %d is not recorded", opts.Step)
+	}
+
+	var messages []openai.ChatCompletionMessage
+	task := records[start].Task
+	if records[start].Snapshot != "" {
+		snapshot, err := LoadSnapshot(dir, records[start])
+		if err != nil {
+			return nil, err
+		}
+		messages = snapshot.Messages
+		task = snapshot.Task
+	} else {
+		for _, record := range records[:start] {
+			if record.SystemPrompt != "" {
+				messages = append(messages[:0], helper.CreateSystemMessage(record.SystemPrompt))
+			}
+			if len(messages) > 0 && record.Action != nil {
+				messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: record.Prompt}, recordedAnswer(record))
+			}
+		}
+	}
+	if records[start].SystemPrompt != "" {
+		messages = []openai.ChatCompletionMessage{helper.CreateSystemMessage(records[start].SystemPrompt)}
+	}
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("step %d comes before the system prompt, records must start with the first step of a task", opts.Step)
+	}
+	replace := func(text string) string {
+		if opts.Instruction == "" || task == "" {
+			return text
+		}
+		return strings.ReplaceAll(text, task, opts.Instruction)
+	}
+	for i := range messages {
+		messages[i] = replaceText(messages[i], replace)
+	}
+
+	comparison := &Comparison{Model: client.Config().ModelName}
+	usage := llm.NewUsageMeter()
+	defer func() { comparison.Usage = usage.Total() }()
+	for i, record := range records[start:] {
+		if i > 0 && record.SystemPrompt != "" {
+			// the next task of the session
+			break
+		}
+		prompt := replace(record.Prompt)
+		if i == 0 && opts.Observation != "" {
+			prompt = opts.Observation
+		}
+		user, err := stepMessage(dir, record, prompt)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, user)
+
+		response, err := client.RequestWithOptions(ctx, messages, llm.RequestOptions{OnThinkingDone: func(string) {}})
+		if err != nil {
+			return nil, fmt.Errorf("step %d: %w", record.Step, err)
+		}
+		usage.Add(response)
+
+		step := StepComparison{Step: record.Step, Recorded: record.Action, CandidateText: response.Action}
+		candidate := response.ToolAction
+		if candidate == nil {
+			candidate, err = helper.ParseAction(response.Action)
+		}
+		if err != nil {
+			step.Reason = "invalid action: " + err.Error()
+		} else {
+			step.Candidate = candidate
+			if record.Action == nil {
+				step.Reason = "no recorded action"
+			} else {
+				step.Match, step.Reason = sameAction(record.Action, candidate, opts.Tolerance)
+			}
+		}
+		comparison.Steps = append(comparison.Steps, step)
+		if !step.Match {
+			comparison.Diverged = record.Step
+			break
+		}
+		comparison.Matched++
+		if candidate["_metadata"] == "finish" {
+			break
+		}
+
+		messages[len(messages)-1] = helper.RemoveImagesFromMessage(messages[len(messages)-1])
+		messages = append(messages, helper.CreateAssistantMessage(
+			fmt.Sprintf("<think>%s</think><answer>%s</answer>", response.Thinking, response.Action)))
+	}
+	return comparison, nil
+}
+
+// replaceText returns msg with replace applied to its text.
+func replaceText(msg openai.ChatCompletionMessage, replace func(string) string) openai.ChatCompletionMessage {
+	msg.Content = replace(msg.Content)
+	if msg.MultiContent != nil {
+		parts := make([]openai.ChatMessagePart, len(msg.MultiContent))
+		for i, part := range msg.MultiContent {
+			if part.Type == openai.ChatMessagePartTypeText {
+				part.Text = replace(part.Text)
+			}
+			parts[i] = part
+		}
+		msg.MultiContent = parts
+	}
+	return msg
+}
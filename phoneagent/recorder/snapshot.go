@@ -0,0 +1,75 @@
+package recorder
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/seal"
+	"autoglm-go/utils"
+	"github.com/sashabaranov/go-openai"
+)
+
+// Snapshot is the state of the agent when a step began, before the
+// observation of the step was added, so that a session can be rewound to any
+// of its steps, see Rewind.
+type Snapshot struct {
+	Step int    `json:"step"`
+	Task string `json:"task"`
+	// Messages is the conversation with the model so far, without images;
+	// the screenshot of the step is in the record.
+	Messages        []openai.ChatCompletionMessage `json:"messages"`
+	DroppedMessages int                            `json:"dropped_messages,omitempty"` // removed by the history caps
+	Plan            string                         `json:"plan,omitempty"`             // the subgoals as told to the model
+	Notes           string                         `json:"notes,omitempty"`            // of reconnects, follow-ups and undos
+	LastStepOK      bool                           `json:"last_step_ok"`
+	PolicyBlocks    []string                       `json:"policy_blocks,omitempty"` // denials in a row before the step
+	Policy          []PolicyDecision               `json:"policy,omitempty"`        // decisions of the step
+}
+
+// PolicyDecision is a decision of the policy on an action of the step.
+type PolicyDecision struct {
+	Action   helper.Action `json:"action"`
+	Decision string        `json:"decision"`
+	Rule     string        `json:"rule,omitempty"`
+	Reason   string        `json:"reason,omitempty"`
+}
+
+// WriteSnapshot saves the snapshot of the step of record next to its
+// screenshot and sets record.Snapshot.
+func (r *Recorder) WriteSnapshot(record *Record, snapshot *Snapshot) error {
+	if !sessionRe.MatchString(record.Session) {
+		return fmt.Errorf("invalid session id %q", record.Session)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	dir := filepath.Join(r.dir, record.Session)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("step-%04d.state.json", record.Step)
+	if err := seal.WriteFile(filepath.Join(dir, name), []byte(utils.JsonString(snapshot)), 0o644); err != nil {
+		return fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	record.Snapshot = filepath.ToSlash(filepath.Join(record.Session, name))
+	return nil
+}
+
+// LoadSnapshot reads the snapshot of record, dir is the record dir.
+func LoadSnapshot(dir string, record Record) (*Snapshot, error) {
+	if record.Snapshot == "" {
+		return nil, fmt.Errorf("step %d has no snapshot, record with --record-snapshots", record.Step)
+	}
+	data, err := seal.ReadFile(filepath.Join(dir, filepath.FromSlash(record.Snapshot)))
+	if err != nil {
+		return nil, err
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("invalid snapshot of step %d: %w", record.Step, err)
+	}
+	return &snapshot, nil
+}
@@ -0,0 +1,177 @@
+package recorder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"autoglm-go/phoneagent/seal"
+)
+
+// VideoMarker is a step of the session video.
+type VideoMarker struct {
+	Step  int
+	Start time.Duration // into the video
+	End   time.Duration
+	Text  string
+}
+
+// VideoFrame is a step screenshot of a video stitched from screenshots,
+// shown from At until the next one.
+type VideoFrame struct {
+	Path          string // sealed or not
+	Key           string // in the storage of artifact.Use instead of Path
+	At            time.Duration
+	Width, Height int
+}
+
+// JoinVideos saves the chunks of a screen recording as out and returns the
+// files written. Several chunks are joined with ffmpeg, without it they are
+// saved next to out as out.1.mp4, out.2.mp4...
+func JoinVideos(ctx context.Context, chunks []string, out string) ([]string, error) {
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("nothing recorded")
+	}
+	joined := chunks[0]
+	if len(chunks) > 1 {
+		ffmpeg, err := exec.LookPath("ffmpeg")
+		if err != nil {
+			return saveChunks(chunks, out)
+		}
+		dir, err := os.MkdirTemp("", "autoglm-video-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+		var list strings.Builder
+		for _, chunk := range chunks {
+			fmt.Fprintf(&list, "file %s\n", concatQuote(chunk))
+		}
+		listPath := filepath.Join(dir, "chunks.txt")
+		if err := os.WriteFile(listPath, []byte(list.String()), 0o644); err != nil {
+			return nil, err
+		}
+		joined = filepath.Join(dir, "joined.mp4")
+		cmd := exec.CommandContext(ctx, ffmpeg, "-hide_banner", "-loglevel", "error", "-y",
+			"-f", "concat", "-safe", "0", "-i", listPath, "-c", "copy", joined)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("ffmpeg failed to join the chunks: %v, output: %s", err, strings.TrimSpace(string(output)))
+		}
+	}
+	if err := copyVideo(joined, out); err != nil {
+		return nil, err
+	}
+	return []string{out}, nil
+}
+
+func saveChunks(chunks []string, out string) ([]string, error) {
+	base := strings.TrimSuffix(out, filepath.Ext(out))
+	var saved []string
+	for i, chunk := range chunks {
+		path := fmt.Sprintf("%s.%d.mp4", base, i+1)
+		if err := copyVideo(chunk, path); err != nil {
+			return saved, err
+		}
+		saved = append(saved, path)
+	}
+	return saved, nil
+}
+
+// copyVideo writes the video at from to to, sealed when a key is set.
+func copyVideo(from, to string) error {
+	data, err := os.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	return seal.WriteFile(to, data, 0o644)
+}
+
+// StitchVideo makes an mp4 of frames with ffmpeg, each shown until the next
+// one and the last until end, scaled to the size of the first.
+func StitchVideo(ctx context.Context, frames []VideoFrame, end time.Duration, out string) error {
+	if len(frames) == 0 {
+		return fmt.Errorf("no screenshot to stitch")
+	}
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return fmt.Errorf("ffmpeg not found: %w", err)
+	}
+	dir, err := os.MkdirTemp("", "autoglm-video-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	var list strings.Builder
+	var last string
+	for i, frame := range frames {
+		var data []byte
+		ext := filepath.Ext(frame.Path)
+		if frame.Key != "" {
+			data, err = readArtifact(frame.Key)
+			ext = path.Ext(frame.Key)
+		} else {
+			data, err = seal.ReadFile(frame.Path)
+		}
+		if err != nil {
+			return err
+		}
+		last = filepath.Join(dir, fmt.Sprintf("frame-%04d%s", i+1, ext))
+		if err := os.WriteFile(last, data, 0o600); err != nil {
+			return err
+		}
+		until := end
+		if i+1 < len(frames) {
+			until = frames[i+1].At
+		}
+		fmt.Fprintf(&list, "file %s\nduration %.3f\n", concatQuote(last), max(until-frame.At, 100*time.Millisecond).Seconds())
+	}
+	// the concat demuxer only keeps the duration of the last file when it is
+	// listed twice
+	fmt.Fprintf(&list, "file %s\n", concatQuote(last))
+	listPath := filepath.Join(dir, "frames.txt")
+	if err := os.WriteFile(listPath, []byte(list.String()), 0o644); err != nil {
+		return err
+	}
+
+	// even sizes for yuv420p
+	width, height := frames[0].Width/2*2, frames[0].Height/2*2
+	filter := fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,format=yuv420p", width, height, width, height)
+	stitched := filepath.Join(dir, "stitched.mp4")
+	cmd := exec.CommandContext(ctx, ffmpeg, "-hide_banner", "-loglevel", "error", "-y",
+		"-f", "concat", "-safe", "0", "-i", listPath, "-vf", filter, "-fps_mode", "vfr", "-movflags", "+faststart", stitched)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg failed to stitch the screenshots: %v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	return copyVideo(stitched, out)
+}
+
+// WriteMarkers writes the steps of a video as WebVTT subtitles, which
+// players show along the video.
+func WriteMarkers(path string, markers []VideoMarker) error {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for _, m := range markers {
+		fmt.Fprintf(&b, "\n%d\n%s --> %s\nStep %d: %s\n", m.Step, vttTime(m.Start), vttTime(max(m.End, m.Start+time.Second)), m.Step, oneLine(m.Text))
+	}
+	return seal.WriteFile(path, []byte(b.String()), 0o644)
+}
+
+func vttTime(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// concatQuote quotes a path for an ffmpeg concat list.
+func concatQuote(path string) string {
+	return "'" + strings.ReplaceAll(path, "'", `'\''`) + "'"
+}
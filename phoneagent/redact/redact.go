@@ -0,0 +1,193 @@
+package redact
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg"
+	"image/png"
+	"regexp"
+	"slices"
+	"strings"
+
+	"autoglm-go/constants"
+	"autoglm-go/phoneagent/definitions"
+)
+
+// Patterns are the built-in sensitive texts, by name.
+var Patterns = map[string]string{
+	"phone":     `(?:\+?86[- ]?)?\b1[3-9]\d(?:[- ]?\d{4}){2}\b`,
+	"bank_card": `\b\d{4}(?:[- ]?\d{4}){3}(?:[- ]?\d{1,3})?\b`,
+	"id_card":   `\b\d{6}(?:19|20)\d{2}(?:0[1-9]|1[0-2])(?:0[1-9]|[12]\d|3[01])\d{3}[\dXx]\b`,
+	"email":     `[\w.+-]+@[\w-]+(?:\.[\w-]+)+`,
+}
+
+// Replacement stands for a masked text in the UI dump.
+const Replacement = "***"
+
+var fill = image.NewUniform(color.Black)
+
+// Redactor masks the sensitive parts of screens. It is safe for concurrent
+// use.
+type Redactor struct {
+	patterns []*regexp.Regexp
+	apps     []string
+	regions  []definitions.RedactRegion
+}
+
+// New compiles config, nil when it masks nothing.
+func New(config definitions.RedactConfig) (*Redactor, error) {
+	if !config.Enabled() {
+		return nil, nil
+	}
+	r := &Redactor{apps: lower(config.Apps), regions: config.Regions}
+	for _, pattern := range config.Patterns {
+		pattern = strings.TrimSpace(pattern)
+		if builtin, ok := Patterns[pattern]; ok {
+			pattern = builtin
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact pattern %q: %w", pattern, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	for i := range r.regions {
+		box := r.regions[i].Box
+		if box[0] < 0 || box[1] < 0 || box[2] > 999 || box[3] > 999 || box[0] >= box[2] || box[1] >= box[3] {
+			return nil, fmt.Errorf("invalid redact region %v, want left, top, right, bottom within 0-999", box)
+		}
+		r.regions[i].Apps = lower(r.regions[i].Apps)
+	}
+	return r, nil
+}
+
+func lower(values []string) []string {
+	out := make([]string, len(values))
+	for i, v := range values {
+		out[i] = strings.ToLower(strings.TrimSpace(v))
+	}
+	return out
+}
+
+// NeedsUI reports whether Apply looks for texts, in the UI dump.
+func (r *Redactor) NeedsUI() bool {
+	return r != nil && len(r.patterns) > 0
+}
+
+// Apply masks the screen of app: the screenshot is returned as a new PNG, the
+// elements as a copy with their texts replaced. The screenshot is returned as
+// it is when nothing is masked on it, and entirely masked, with the error,
+// when it cannot be decoded.
+func (r *Redactor) Apply(screenshot *definitions.Screenshot, app string, elements []definitions.UIElement) (*definitions.Screenshot, []definitions.UIElement, error) {
+	if r == nil {
+		return screenshot, elements, nil
+	}
+	var boxes []image.Rectangle    // in pixels
+	var relative []image.Rectangle // on the 0-999 grid
+	whole := r.matchesApp(r.apps, app, elements)
+	for _, region := range r.regions {
+		if len(region.Apps) == 0 || r.matchesApp(region.Apps, app, elements) {
+			relative = append(relative, image.Rect(region.Box[0], region.Box[1], region.Box[2], region.Box[3]))
+		}
+	}
+
+	redacted := slices.Clone(elements)
+	for i := range redacted {
+		e := &redacted[i]
+		bounds := image.Rect(e.Bounds[0], e.Bounds[1], e.Bounds[2], e.Bounds[3])
+		if whole || r.insideRegion(bounds, relative, screenshot) {
+			e.Text, e.ContentDesc = blank(e.Text), blank(e.ContentDesc)
+			continue
+		}
+		text, desc := r.replace(e.Text), r.replace(e.ContentDesc)
+		if text != e.Text || desc != e.ContentDesc {
+			e.Text, e.ContentDesc = text, desc
+			boxes = append(boxes, bounds)
+		}
+	}
+
+	if screenshot == nil || len(screenshot.Data) == 0 || (!whole && len(boxes) == 0 && len(relative) == 0) {
+		return screenshot, redacted, nil
+	}
+	masked, err := mask(screenshot, whole, boxes, relative)
+	return masked, redacted, err
+}
+
+func blank(text string) string {
+	if text == "" {
+		return ""
+	}
+	return Replacement
+}
+
+func (r *Redactor) replace(text string) string {
+	for _, re := range r.patterns {
+		text = re.ReplaceAllString(text, Replacement)
+	}
+	return text
+}
+
+// matchesApp tells whether app, by name, package or the package of its UI
+// dump, is one of apps.
+func (r *Redactor) matchesApp(apps []string, app string, elements []definitions.UIElement) bool {
+	if len(apps) == 0 {
+		return false
+	}
+	names := []string{strings.ToLower(app), strings.ToLower(constants.APP_PACKAGES_ANDROID[app])}
+	if len(elements) > 0 {
+		names = append(names, strings.ToLower(elements[0].Package))
+	}
+	return slices.ContainsFunc(names, func(name string) bool {
+		return name != "" && slices.Contains(apps, name)
+	})
+}
+
+func (r *Redactor) insideRegion(bounds image.Rectangle, relative []image.Rectangle, screenshot *definitions.Screenshot) bool {
+	if screenshot == nil || screenshot.Width == 0 || screenshot.Height == 0 {
+		return false
+	}
+	for _, box := range relative {
+		if bounds.In(toPixels(box, screenshot.Width, screenshot.Height)) {
+			return true
+		}
+	}
+	return false
+}
+
+func toPixels(box image.Rectangle, width, height int) image.Rectangle {
+	return image.Rect(box.Min.X*width/1000, box.Min.Y*height/1000, (box.Max.X+1)*width/1000, (box.Max.Y+1)*height/1000)
+}
+
+func mask(screenshot *definitions.Screenshot, whole bool, boxes, relative []image.Rectangle) (*definitions.Screenshot, error) {
+	src, _, decodeErr := image.Decode(bytes.NewReader(screenshot.Data))
+	if decodeErr != nil {
+		// nothing is sent that could not be checked
+		src, whole = image.NewRGBA(image.Rect(0, 0, screenshot.Width, screenshot.Height)), true
+		decodeErr = fmt.Errorf("failed to decode screenshot, masked entirely: %w", decodeErr)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, src.Bounds().Dx(), src.Bounds().Dy()))
+	draw.Draw(img, img.Bounds(), src, src.Bounds().Min, draw.Src)
+
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	if whole {
+		boxes = []image.Rectangle{img.Bounds()}
+	}
+	for _, box := range relative {
+		boxes = append(boxes, toPixels(box, width, height))
+	}
+	for _, box := range boxes {
+		draw.Draw(img, box.Intersect(img.Bounds()), fill, image.Point{}, draw.Src)
+	}
+
+	var buf bytes.Buffer
+	// cannot fail for an RGBA image written to memory
+	_ = png.Encode(&buf, img)
+	masked := *screenshot
+	masked.Data = buf.Bytes()
+	masked.Base64Data = base64.StdEncoding.EncodeToString(masked.Data)
+	return &masked, decodeErr
+}
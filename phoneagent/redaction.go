@@ -0,0 +1,34 @@
+package phoneagent
+
+import (
+	"autoglm-go/phoneagent/definitions"
+	"autoglm-go/phoneagent/redact"
+	logs "github.com/sirupsen/logrus"
+)
+
+func newRedactor(config definitions.RedactConfig) *redact.Redactor {
+	redactor, err := redact.New(config)
+	if err != nil {
+		logs.Errorf("invalid redaction, nothing is masked, err: %v", err)
+	}
+	return redactor
+}
+
+// redactObservation masks obs as AgentConfig.Redact says, before it is shown
+// to the model, recorded or posted. The dialogs and the captcha are handled
+// on the screen as it is.
+func (r *PhoneAgent) redactObservation(obs *observation) *observation {
+	if r.redactor == nil {
+		return obs
+	}
+	screenshot, elements, err := r.redactor.Apply(obs.screenshot, obs.currentApp, obs.uiElements)
+	if err != nil {
+		r.log().Warnf("🙈 %v", err)
+	}
+	if screenshot != obs.screenshot {
+		r.log().Debugf("🙈 screenshot of %s redacted", obs.currentApp)
+	}
+	redacted := *obs
+	redacted.screenshot, redacted.uiElements = screenshot, elements
+	return &redacted
+}
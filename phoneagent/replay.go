@@ -0,0 +1,86 @@
+package phoneagent
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/llm"
+	"autoglm-go/phoneagent/recorder"
+	"autoglm-go/phoneagent/trajectory"
+	logs "github.com/sirupsen/logrus"
+)
+
+// ErrReplayDiverged is returned by a strict replay whose screen no longer
+// matches the recording.
+var ErrReplayDiverged = errors.New("replay diverged from the recording")
+
+// Replay feeds the agent the actions of a recorded task in place of model
+// answers, see PhoneAgent.Replay. The actions run through the usual loop, so
+// dialogs, timeouts, sessions and records work as in a live run.
+type Replay struct {
+	Task  string
+	Steps []trajectory.Step
+	// Strict stops the replay when the foreground app is not the recorded
+	// one, otherwise the action runs anyway.
+	Strict bool
+
+	next int
+}
+
+// LoadReplay reads a trajectory exported as JSON or a session recorded with
+// AgentConfig.RecordDir (.jsonl). Recorded steps without an action are
+// skipped.
+func LoadReplay(path string) (*Replay, error) {
+	if strings.EqualFold(filepath.Ext(path), ".jsonl") {
+		records, err := recorder.Load(path)
+		if err != nil {
+			return nil, err
+		}
+		replay := &Replay{}
+		for _, record := range records {
+			if replay.Task == "" {
+				replay.Task = record.Task
+			}
+			if record.Action == nil {
+				continue
+			}
+			step := trajectory.Step{App: record.App, Action: record.Action, Thinking: record.Thinking, Time: record.Time}
+			if record.Result != nil {
+				step.Success, step.Message = record.Result.Success, record.Result.Message
+			}
+			replay.Steps = append(replay.Steps, step)
+		}
+		return replay, nil
+	}
+	t, err := trajectory.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Replay{Task: t.Task, Steps: t.Steps}, nil
+}
+
+// response answers the step with the next recorded action. A replay that
+// runs out of actions finishes the task.
+func (r *Replay) response(currentApp string) (*llm.ModelResponse, error) {
+	if r.next >= len(r.Steps) {
+		action := helper.Action{"_metadata": "finish", "message": "replay ended without finish"}
+		return &llm.ModelResponse{Action: helper.FormatAction(action), ToolAction: action, Model: "replay"}, nil
+	}
+	step := r.Steps[r.next]
+	r.next++
+	if step.App != "" && currentApp != "" && step.App != currentApp {
+		if r.Strict {
+			return nil, fmt.Errorf("%w at step %d: %s is open, %s was recorded", ErrReplayDiverged, r.next, currentApp, step.App)
+		}
+		logs.Warnf("🔁 replay step %d: %s is open, %s was recorded", r.next, currentApp, step.App)
+	}
+	return &llm.ModelResponse{
+		Thinking:   step.Thinking,
+		Action:     helper.FormatAction(step.Action),
+		ToolAction: step.Action,
+		Model:      "replay",
+	}, nil
+}
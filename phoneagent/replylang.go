@@ -0,0 +1,60 @@
+package phoneagent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/llm"
+	"autoglm-go/phoneagent/uilang"
+	"github.com/sashabaranov/go-openai"
+)
+
+const translatePrompt = `Translate the text of the user into %s. Keep names, numbers, app names and quoted screen text unchanged. Reply with the translation only, without quotes or explanations.`
+
+// enforceReplyLanguage translates the message of the action, the text the
+// user reads or hears for finish, Take_over and confirmations, when it is not
+// written in AgentConfig.ReplyLanguage. The message is left as it is when the
+// translation fails.
+func (r *PhoneAgent) enforceReplyLanguage(ctx context.Context, action helper.Action) {
+	if r.AgentConfig.ReplyLanguage == "" || r.Replay != nil {
+		return
+	}
+	message, ok := action["message"].(string)
+	if !ok || strings.TrimSpace(message) == "" {
+		return
+	}
+	lang := uilang.New(r.AgentConfig.ReplyLanguage)
+	if lang.MatchesScript(message) {
+		return
+	}
+
+	translated, err := r.translate(ctx, message, lang)
+	if err != nil {
+		r.log().Warnf("failed to translate %q into %s, err: %v", message, lang.Tag, err)
+		return
+	}
+	r.log().Infof("🌐 message translated into %s: %s", lang.Tag, translated)
+	action["message"] = translated
+}
+
+func (r *PhoneAgent) translate(ctx context.Context, text string, lang *uilang.Language) (string, error) {
+	messages := []openai.ChatCompletionMessage{
+		helper.CreateSystemMessage(fmt.Sprintf(translatePrompt, fmt.Sprintf("%s (%s)", lang.Name(), lang.Tag))),
+		{Role: openai.ChatMessageRoleUser, Content: text},
+	}
+	response, err := r.ModelClient.RequestWithOptions(ctx, messages, llm.RequestOptions{OnThinkingDone: func(string) {}})
+	if err != nil {
+		return "", err
+	}
+	r.Usage.Add(response)
+	translated := strings.Trim(strings.TrimSpace(response.Action), `"“”`)
+	if translated == "" {
+		return "", fmt.Errorf("empty translation")
+	}
+	if !lang.MatchesScript(translated) {
+		return "", fmt.Errorf("translation is not in %s either: %s", lang.Tag, translated)
+	}
+	return translated, nil
+}
@@ -0,0 +1,166 @@
+// Package respcache remembers the actions the model chose on a screen, so
+// that repetitive flows such as a login or closing a pop-up skip the model
+// once it answered the same way often enough.
+package respcache
+
+import (
+	"crypto/sha256"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"autoglm-go/phoneagent/imaging"
+	"autoglm-go/phoneagent/metrics"
+)
+
+// Options tune a Cache.
+type Options struct {
+	TTL time.Duration // since an answer was last given, required
+	// MaxDistance is how many of the 64 bits of the screen hashes may
+	// differ, for a clock or a cursor.
+	MaxDistance int
+	// MinHits is how many times in a row the model must have given the same
+	// answer before it is served, 2 by default.
+	MinHits    int
+	MaxEntries int // 10000 by default, the oldest go first
+}
+
+// Key is what an answer depends on besides the screen.
+type Key struct {
+	Instruction string
+	App         string
+	History     []string // the recent actions, oldest first
+}
+
+func (k Key) hash() [sha256.Size]byte {
+	return sha256.Sum256([]byte(k.Instruction + "\x00" + k.App + "\x00" + strings.Join(k.History, "\x00")))
+}
+
+// Response is a cached answer of the model.
+type Response struct {
+	Thinking string
+	Action   string
+}
+
+type entry struct {
+	screen   uint64
+	response Response
+	hits     int
+	stored   time.Time
+}
+
+// Cache holds the answers of the model by Key and screen hash. It is safe
+// for concurrent use, one Cache can serve every agent of the process.
+type Cache struct {
+	mu      sync.Mutex
+	opts    Options
+	entries map[[sha256.Size]byte][]*entry
+	size    int
+}
+
+func New(opts Options) *Cache {
+	if opts.MinHits <= 0 {
+		opts.MinHits = 2
+	}
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = 10000
+	}
+	return &Cache{opts: opts, entries: map[[sha256.Size]byte][]*entry{}}
+}
+
+// find returns the entry of the screen closest to screen within
+// MaxDistance. It must be called with r.mu held.
+func (r *Cache) find(key [sha256.Size]byte, screen uint64) *entry {
+	var best *entry
+	for _, e := range r.entries[key] {
+		distance := imaging.HashDistance(e.screen, screen)
+		if distance <= r.opts.MaxDistance && (best == nil || distance < imaging.HashDistance(best.screen, screen)) {
+			best = e
+		}
+	}
+	return best
+}
+
+// Get returns the answer to serve for key on screen, given MinHits times
+// and not older than TTL.
+func (r *Cache) Get(key Key, screen uint64, now time.Time) (Response, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e := r.find(key.hash(), screen)
+	if e == nil || e.hits < r.opts.MinHits || now.Sub(e.stored) > r.opts.TTL {
+		metrics.ResponseCacheLookups.Inc("miss")
+		return Response{}, false
+	}
+	metrics.ResponseCacheLookups.Inc("hit")
+	return e.response, true
+}
+
+// Put records that the model answered response for key on screen: a hit of
+// the same answer, or a new entry replacing a different one.
+func (r *Cache) Put(key Key, screen uint64, response Response, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h := key.hash()
+	if e := r.find(h, screen); e != nil {
+		if e.response.Action == response.Action && now.Sub(e.stored) <= r.opts.TTL {
+			e.hits++
+		} else {
+			e.hits = 1
+		}
+		e.screen, e.response, e.stored = screen, response, now
+		return
+	}
+	r.entries[h] = append(r.entries[h], &entry{screen: screen, response: response, hits: 1, stored: now})
+	r.size++
+	if r.size > r.opts.MaxEntries {
+		r.evict(now)
+	}
+}
+
+// Forget drops the answer for key on screen, after it failed.
+func (r *Cache) Forget(key Key, screen uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h := key.hash()
+	if e := r.find(h, screen); e != nil {
+		r.remove(h, e)
+	}
+}
+
+// evict drops the expired entries, then the oldest one while there are too
+// many. It must be called with r.mu held.
+func (r *Cache) evict(now time.Time) {
+	for h, entries := range r.entries {
+		kept := slices.DeleteFunc(entries, func(e *entry) bool { return now.Sub(e.stored) > r.opts.TTL })
+		r.size -= len(entries) - len(kept)
+		if len(kept) == 0 {
+			delete(r.entries, h)
+		} else {
+			r.entries[h] = kept
+		}
+	}
+	for r.size > r.opts.MaxEntries {
+		var oldestKey [sha256.Size]byte
+		var oldest *entry
+		for h, entries := range r.entries {
+			for _, e := range entries {
+				if oldest == nil || e.stored.Before(oldest.stored) {
+					oldestKey, oldest = h, e
+				}
+			}
+		}
+		r.remove(oldestKey, oldest)
+	}
+}
+
+// remove must be called with r.mu held.
+func (r *Cache) remove(h [sha256.Size]byte, e *entry) {
+	entries := slices.DeleteFunc(r.entries[h], func(candidate *entry) bool { return candidate == e })
+	if len(entries) == 0 {
+		delete(r.entries, h)
+	} else {
+		r.entries[h] = entries
+	}
+	r.size--
+}
@@ -0,0 +1,97 @@
+package phoneagent
+
+import (
+	"sync"
+	"time"
+
+	"autoglm-go/phoneagent/definitions"
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/imaging"
+	"autoglm-go/phoneagent/llm"
+	"autoglm-go/phoneagent/respcache"
+	"autoglm-go/utils"
+)
+
+// responseCacheHistory is how many of the previous actions an answer of the
+// cache depends on.
+const responseCacheHistory = 3
+
+var (
+	responseCacheMu     sync.RWMutex
+	sharedResponseCache *respcache.Cache
+)
+
+// UseResponseCache makes every agent of the process take the answers of the
+// model from c on the screens it answered the same way on before, and
+// returns a function restoring the previous cache.
+func UseResponseCache(c *respcache.Cache) func() {
+	responseCacheMu.Lock()
+	defer responseCacheMu.Unlock()
+	previous := sharedResponseCache
+	sharedResponseCache = c
+	return func() {
+		responseCacheMu.Lock()
+		defer responseCacheMu.Unlock()
+		sharedResponseCache = previous
+	}
+}
+
+func responseCache() *respcache.Cache {
+	responseCacheMu.RLock()
+	defer responseCacheMu.RUnlock()
+	return sharedResponseCache
+}
+
+// cachedStep is the response cache lookup of the running step.
+type cachedStep struct {
+	cache  *respcache.Cache
+	key    respcache.Key
+	screen uint64
+	served bool // the answer came from the cache
+}
+
+// lookupResponse returns the cached answer for the task, app and recent
+// actions on screenshot, nil when there is none or the cache is off. It is
+// not used right after a failed step, as the same answer just did not work.
+func (r *PhoneAgent) lookupResponse(currentApp string, screenshot *definitions.Screenshot) (*cachedStep, *llm.ModelResponse) {
+	c := responseCache()
+	if c == nil || r.Replay != nil || len(screenshot.Data) == 0 {
+		return nil, nil
+	}
+	screen, err := imaging.DHashData(screenshot.Data)
+	if err != nil {
+		return nil, nil
+	}
+	step := &cachedStep{cache: c, key: respcache.Key{Instruction: r.task, App: currentApp}, screen: screen}
+	if r.Trajectory != nil {
+		steps := r.Trajectory.Steps
+		for _, s := range steps[max(len(steps)-responseCacheHistory, 0):] {
+			step.key.History = append(step.key.History, helper.FormatAction(s.Action))
+		}
+	}
+	if r.StepCount > 1 && !r.lastStepOK {
+		return step, nil
+	}
+	cached, ok := c.Get(step.key, screen, time.Now())
+	if !ok {
+		return step, nil
+	}
+	step.served = true
+	r.log().Infof("♻️ cached answer for this screen: %s", cached.Action)
+	return step, &llm.ModelResponse{Thinking: cached.Thinking, Action: cached.Action, Model: "cache"}
+}
+
+// storeResponse keeps the answer of the step when its action succeeded, and
+// forgets a cached one that failed. Only do() actions are kept, a finish
+// message belongs to its task.
+func (r *PhoneAgent) storeResponse(step *cachedStep, response *llm.ModelResponse, action helper.Action, ok bool) {
+	if step == nil {
+		return
+	}
+	switch {
+	case step.served && !ok:
+		step.cache.Forget(step.key, step.screen)
+	case !step.served && ok && utils.AnyToString(action["_metadata"]) == "do":
+		step.cache.Put(step.key, step.screen, respcache.Response{Thinking: response.Thinking, Action: helper.FormatAction(action)}, time.Now())
+	}
+}
@@ -0,0 +1,194 @@
+package phoneagent
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"autoglm-go/phoneagent/definitions"
+	"autoglm-go/phoneagent/llm"
+)
+
+// Difficulty is the kind of work a step needs.
+type Difficulty string
+
+const (
+	Navigation Difficulty = "navigation" // tap through known screens
+	Reasoning  Difficulty = "reasoning"  // first step, recovering from a failure
+	Reading    Difficulty = "reading"    // dense text to read or compare
+)
+
+const (
+	denseTextElements = 25
+	denseTextRunes    = 600
+)
+
+// readingWords in a task mean its steps read content off the screen.
+var readingWords = []string{"总结", "读取", "阅读", "比较", "对比", "提取", "记录", "价格", "summarize", "read", "compare", "extract", "price"}
+
+// Route is a model the router may send steps to.
+type Route struct {
+	Name      string
+	Client    *llm.ModelClient
+	Handles   []Difficulty
+	CostPer1K float64
+}
+
+func NewRoute(cfg *definitions.RouteConfig, base *definitions.ModelConfig) Route {
+	route := Route{
+		Name:      cfg.Name,
+		Client:    llm.NewModelClient(cfg.Apply(base)),
+		CostPer1K: cfg.CostPer1K,
+	}
+	for _, h := range cfg.Handles {
+		route.Handles = append(route.Handles, Difficulty(h))
+	}
+	return route
+}
+
+type routeStats struct {
+	steps  int
+	tokens int
+	cost   float64
+}
+
+// Router sends each step to the cheapest route that handles its difficulty,
+// falling back to the agent's main model, and keeps the cost per task.
+type Router struct {
+	routes []Route
+	stats  map[string]*routeStats
+
+	baselineCost float64 // what the main model would have cost
+	unreported   int     // steps without token usage
+}
+
+func NewRouter(routes ...Route) *Router {
+	routes = slices.Clone(routes)
+	sort.SliceStable(routes, func(i, j int) bool { return routes[i].CostPer1K < routes[j].CostPer1K })
+	return &Router{routes: routes, stats: map[string]*routeStats{}}
+}
+
+// pick returns the cheapest route for difficulty, nil for the main model.
+// Only routes cheaper than the main model are considered.
+func (r *Router) pick(difficulty Difficulty, mainCost float64) *Route {
+	for i := range r.routes {
+		route := &r.routes[i]
+		if route.CostPer1K < mainCost && slices.Contains(route.Handles, difficulty) {
+			return route
+		}
+	}
+	return nil
+}
+
+func (r *Router) record(name string, usage int, cost, mainCost float64) {
+	stats, ok := r.stats[name]
+	if !ok {
+		stats = &routeStats{}
+		r.stats[name] = stats
+	}
+	stats.steps++
+	if usage == 0 {
+		r.unreported++
+		return
+	}
+	stats.tokens += usage
+	stats.cost += float64(usage) / 1000 * cost
+	r.baselineCost += float64(usage) / 1000 * mainCost
+}
+
+// Summary describes the routing of the task so far.
+func (r *Router) Summary() string {
+	names := make([]string, 0, len(r.stats))
+	for name := range r.stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var (
+		parts []string
+		cost  float64
+	)
+	for _, name := range names {
+		stats := r.stats[name]
+		parts = append(parts, fmt.Sprintf("%s %d steps/%d tokens", name, stats.steps, stats.tokens))
+		cost += stats.cost
+	}
+	summary := strings.Join(parts, ", ")
+	if r.baselineCost > 0 {
+		summary += fmt.Sprintf("; cost %.4f vs %.4f on the main model (saved %.0f%%)",
+			cost, r.baselineCost, (r.baselineCost-cost)/r.baselineCost*100)
+	}
+	if r.unreported > 0 {
+		summary += fmt.Sprintf("; %d steps without usage reported", r.unreported)
+	}
+	return summary
+}
+
+func (r *Router) reset() {
+	r.stats = map[string]*routeStats{}
+	r.baselineCost = 0
+	r.unreported = 0
+}
+
+// classifyStep guesses the difficulty of the coming step from what is known
+// before asking a model.
+func (r *PhoneAgent) classifyStep(obs *observation, isFirstStep bool) Difficulty {
+	if isFirstStep || !r.lastStepOK {
+		return Reasoning
+	}
+
+	if obs.uiElements != nil {
+		var texts, runes int
+		for i := range obs.uiElements {
+			if n := utf8.RuneCountInString(obs.uiElements[i].Text); n > 0 {
+				texts++
+				runes += n
+			}
+		}
+		if texts > denseTextElements || runes > denseTextRunes {
+			return Reading
+		}
+		return Navigation
+	}
+
+	task := strings.ToLower(r.task)
+	for _, word := range readingWords {
+		if strings.Contains(task, word) {
+			return Reading
+		}
+	}
+	return Navigation
+}
+
+// routeStep picks the client for the coming step.
+func (r *PhoneAgent) routeStep(obs *observation, isFirstStep bool) {
+	r.stepRoute = nil
+	if r.Router == nil {
+		return
+	}
+	difficulty := r.classifyStep(obs, isFirstStep)
+	r.stepRoute = r.Router.pick(difficulty, r.ModelConfig.CostPer1K)
+	if r.stepRoute != nil {
+		r.log().Debugf("step %d (%s) routed to %s", r.StepCount, difficulty, r.stepRoute.Name)
+	} else {
+		r.log().Debugf("step %d (%s) on the main model", r.StepCount, difficulty)
+	}
+}
+
+// recordRoute adds the usage of a response to the routing stats.
+func (r *PhoneAgent) recordRoute(response *llm.ModelResponse) {
+	if r.Router == nil || (r.plan != nil && r.plan.escalated) {
+		return
+	}
+	var tokens int
+	if response.Usage != nil {
+		tokens = response.Usage.TotalTokens
+	}
+	name, cost := r.ModelConfig.ModelName, r.ModelConfig.CostPer1K
+	if route := r.stepRoute; route != nil {
+		name, cost = route.Name, route.CostPer1K
+	}
+	r.Router.record(name, tokens, cost, r.ModelConfig.CostPer1K)
+}
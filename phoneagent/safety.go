@@ -0,0 +1,105 @@
+package phoneagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"autoglm-go/phoneagent/definitions"
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/policy"
+	"autoglm-go/utils"
+	logs "github.com/sirupsen/logrus"
+)
+
+// ErrPolicyDeadlock is returned when the policy denied AgentConfig.MaxPolicyBlocks
+// actions of the model in a row.
+var ErrPolicyDeadlock = errors.New("policy deadlock")
+
+func newPolicy(config definitions.PolicyConfig) *policy.Engine {
+	engine, err := policy.New(config)
+	if err != nil {
+		logs.Errorf("invalid policy, no action is filtered, err: %v", err)
+	}
+	return engine
+}
+
+// checkPolicy decides about action with AgentConfig.Policy and audits the
+// decision. ok is false when the action is denied, result tells the model
+// why; a confirm verdict is asked by ExecuteAction.
+func (r *PhoneAgent) checkPolicy(ctx context.Context, action helper.Action, screenWidth, screenHeight int) (verdict policy.Verdict, result helper.ActionResult, ok bool) {
+	if r.policy == nil || utils.AnyToString(action["_metadata"]) != "do" {
+		return policy.Verdict{Decision: policy.Allow}, helper.ActionResult{}, true
+	}
+	in := policy.Input{
+		Action:  utils.AnyToString(action["action"]),
+		Text:    utils.AnyToString(action["text"]),
+		Message: utils.AnyToString(action["message"]),
+		Label:   r.tappedLabel(action, screenWidth, screenHeight),
+	}
+	if r.stepObservation != nil {
+		in.App = r.stepObservation.currentApp
+	}
+	switch in.Action {
+	case "Launch", "Force_Stop", "Clear_Data":
+		in.Target = utils.AnyToString(action["app"])
+		if in.Target == "" {
+			in.Target = utils.AnyToString(action["package"])
+		}
+	}
+
+	verdict = r.policy.Evaluate(in)
+	r.recordPolicy(action, verdict)
+	r.policy.Audit(policy.Entry{
+		At:       time.Now(),
+		TaskID:   r.taskID,
+		DeviceID: r.AgentConfig.DeviceID,
+		Step:     r.StepCount,
+		App:      in.App,
+		Action:   action,
+		Decision: verdict.Decision,
+		Rule:     verdict.Rule,
+		Reason:   verdict.Reason,
+	})
+	if verdict.Decision != policy.Deny {
+		r.policyBlocks = nil
+	}
+	switch verdict.Decision {
+	case policy.Deny:
+		r.log().Warnf("🛡️ step %d: %s denied by %s: %s", r.StepCount, in.Action, verdict.Rule, verdict.Reason)
+		r.policyBlocks = append(r.policyBlocks, fmt.Sprintf("step %d %s by %s (%s)", r.StepCount, helper.FormatAction(action), verdict.Rule, verdict.Reason))
+		return verdict, helper.ActionResult{
+			Success: false,
+			Message: fmt.Sprintf("Blocked by the safety policy (%s), do not try to do it another way", verdict.Reason),
+		}, false
+	case policy.Confirm:
+		r.log().Infof("🛡️ step %d: %s needs confirmation by %s: %s", r.StepCount, in.Action, verdict.Rule, verdict.Reason)
+	default:
+		r.log().Debugf("🛡️ step %d: %s allowed by %s", r.StepCount, in.Action, verdict.Rule)
+	}
+	return verdict, helper.ActionResult{}, true
+}
+
+// policyDeadlock returns an ErrPolicyDeadlock listing the blocked attempts
+// once AgentConfig.MaxPolicyBlocks actions in a row were denied, as the
+// model keeps trying what it may not do.
+func (r *PhoneAgent) policyDeadlock() error {
+	limit := r.AgentConfig.MaxPolicyBlocks
+	if limit <= 0 || len(r.policyBlocks) < limit {
+		return nil
+	}
+	return fmt.Errorf("%w: %d actions in a row denied: %s", ErrPolicyDeadlock, len(r.policyBlocks), strings.Join(r.policyBlocks, "; "))
+}
+
+// tappedLabel is the text of the smallest element of the current screen
+// under the tap of action, empty without a UI dump.
+func (r *PhoneAgent) tappedLabel(action helper.Action, screenWidth, screenHeight int) string {
+	element := utils.AnyToIntSlice(action["element"])
+	if len(element) != 2 || r.stepObservation == nil {
+		return ""
+	}
+	x, y := r.convertRelativeToAbsolute(element, screenWidth, screenHeight)
+	return labelAt(r.stepObservation.uiElements, x, y)
+}
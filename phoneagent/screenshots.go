@@ -0,0 +1,95 @@
+package phoneagent
+
+import (
+	"fmt"
+	"strings"
+
+	"autoglm-go/phoneagent/helper"
+	"github.com/sashabaranov/go-openai"
+)
+
+// screenTextRunes caps the screen text that replaces an old screenshot.
+const screenTextRunes = 400
+
+// keptImage is a screenshot still in the context, with the text that
+// replaces it once it is too old.
+type keptImage struct {
+	url     *openai.ChatMessageImageURL
+	summary string
+}
+
+// keepScreenshot runs once the model has seen the screenshot of the current
+// step, the last message of State. Only the latest AgentConfig.HistoryImages
+// screenshots stay, counting the one the next step adds.
+func (r *PhoneAgent) keepScreenshot(obs *observation, action string) {
+	last := &r.State[len(r.State)-1]
+	limit := r.AgentConfig.HistoryImages
+	if limit <= 1 {
+		*last = helper.RemoveImagesFromMessage(*last)
+		return
+	}
+
+	url := imageURLOf(*last)
+	if url == nil {
+		return
+	}
+	r.keptImages = append(r.keptImages, keptImage{url: url, summary: screenSummary(obs, action)})
+	for len(r.keptImages) >= limit {
+		r.retireScreenshot(r.keptImages[0])
+		r.keptImages = r.keptImages[1:]
+	}
+}
+
+// retireScreenshot replaces the image with its summary, unless the message
+// has already left the context.
+func (r *PhoneAgent) retireScreenshot(image keptImage) {
+	for i := len(r.State) - 1; i >= 0; i-- {
+		if imageURLOf(r.State[i]) != image.url {
+			continue
+		}
+		msg := helper.RemoveImagesFromMessage(r.State[i])
+		msg.MultiContent = append(msg.MultiContent, openai.ChatMessagePart{
+			Type: openai.ChatMessagePartTypeText,
+			Text: image.summary,
+		})
+		r.State[i] = msg
+		return
+	}
+}
+
+func imageURLOf(msg openai.ChatCompletionMessage) *openai.ChatMessageImageURL {
+	for _, part := range msg.MultiContent {
+		if part.Type == openai.ChatMessagePartTypeImageURL && part.ImageURL != nil {
+			return part.ImageURL
+		}
+	}
+	return nil
+}
+
+// screenSummary describes a screenshot for the model once the image is gone.
+func screenSummary(obs *observation, action string) string {
+	var sb strings.Builder
+	sb.WriteString("** Earlier Screenshot (omitted) **\n\n")
+	fmt.Fprintf(&sb, "App: %s\n", obs.currentApp)
+
+	seen := map[string]bool{}
+	var texts []string
+	for _, e := range obs.uiElements {
+		for _, text := range []string{e.Text, e.ContentDesc} {
+			text = strings.Join(strings.Fields(text), " ")
+			if text != "" && !seen[text] {
+				seen[text] = true
+				texts = append(texts, text)
+			}
+		}
+	}
+	if len(texts) > 0 {
+		screenText := []rune(strings.Join(texts, " | "))
+		if len(screenText) > screenTextRunes {
+			screenText = append(screenText[:screenTextRunes], '…')
+		}
+		fmt.Fprintf(&sb, "Screen text: %s\n", string(screenText))
+	}
+	fmt.Fprintf(&sb, "Action taken: %s", action)
+	return sb.String()
+}
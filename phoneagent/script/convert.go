@@ -0,0 +1,69 @@
+package script
+
+import (
+	lua "github.com/yuin/gopher-lua"
+)
+
+// toLua converts action arguments and decoded JSON to Lua values.
+func toLua(L *lua.LState, value any) lua.LValue {
+	switch v := value.(type) {
+	case nil:
+		return lua.LNil
+	case string:
+		return lua.LString(v)
+	case bool:
+		return lua.LBool(v)
+	case int:
+		return lua.LNumber(v)
+	case float64:
+		return lua.LNumber(v)
+	case []int:
+		table := L.NewTable()
+		for _, item := range v {
+			table.Append(lua.LNumber(item))
+		}
+		return table
+	case []any:
+		table := L.NewTable()
+		for _, item := range v {
+			table.Append(toLua(L, item))
+		}
+		return table
+	case map[string]any:
+		table := L.NewTable()
+		for key, item := range v {
+			table.RawSetString(key, toLua(L, item))
+		}
+		return table
+	default:
+		return lua.LNil
+	}
+}
+
+// fromLua converts a Lua value for JSON encoding. Tables with only array
+// items become lists, other tables become objects.
+func fromLua(value lua.LValue) any {
+	switch v := value.(type) {
+	case lua.LString:
+		return string(v)
+	case lua.LBool:
+		return bool(v)
+	case lua.LNumber:
+		return float64(v)
+	case *lua.LTable:
+		if n := v.Len(); n > 0 {
+			list := make([]any, 0, n)
+			for i := 1; i <= n; i++ {
+				list = append(list, fromLua(v.RawGetInt(i)))
+			}
+			return list
+		}
+		object := map[string]any{}
+		v.ForEach(func(key, item lua.LValue) {
+			object[key.String()] = fromLua(item)
+		})
+		return object
+	default:
+		return nil
+	}
+}
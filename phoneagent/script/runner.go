@@ -0,0 +1,178 @@
+package script
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"autoglm-go/phoneagent"
+	logs "github.com/sirupsen/logrus"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Runner runs a Lua script between agent steps. The script defines
+//
+//	function after_step(step) ... end
+//
+// where step has the fields task, step, app, action (a table of the action
+// arguments), success and message. Returning a string shows it to the model
+// with the next screenshot; returning a table {observation = "...",
+// stop = "message"} can also end the task. Globals persist across steps, and
+// the helpers http_get, http_post, json_encode, json_decode and log are
+// available to the script.
+type Runner struct {
+	mu     sync.Mutex
+	state  *lua.LState
+	client *http.Client
+}
+
+const afterStepFunc = "after_step"
+
+func NewRunner(path string) (*Runner, error) {
+	r := &Runner{
+		state:  lua.NewState(),
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+	r.registerHelpers()
+
+	if err := r.state.DoFile(path); err != nil {
+		r.state.Close()
+		return nil, fmt.Errorf("failed to load script %s: %w", path, err)
+	}
+	if r.state.GetGlobal(afterStepFunc).Type() != lua.LTFunction {
+		r.state.Close()
+		return nil, fmt.Errorf("script %s does not define %s", path, afterStepFunc)
+	}
+	return r, nil
+}
+
+func (r *Runner) AfterStep(ctx context.Context, info *phoneagent.StepInfo) (*phoneagent.StepHookResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	L := r.state
+	L.SetContext(ctx)
+	defer L.RemoveContext()
+
+	step := L.NewTable()
+	step.RawSetString("task", lua.LString(info.Task))
+	step.RawSetString("step", lua.LNumber(info.Step))
+	step.RawSetString("app", lua.LString(info.CurrentApp))
+	step.RawSetString("action", toLua(L, map[string]any(info.Action)))
+	step.RawSetString("success", lua.LBool(info.Success))
+	step.RawSetString("message", lua.LString(info.Message))
+
+	err := L.CallByParam(lua.P{
+		Fn:      L.GetGlobal(afterStepFunc),
+		NRet:    1,
+		Protect: true,
+	}, step)
+	if err != nil {
+		return nil, fmt.Errorf("script %s failed: %w", afterStepFunc, err)
+	}
+	ret := L.Get(-1)
+	L.Pop(1)
+
+	switch value := ret.(type) {
+	case lua.LString:
+		return &phoneagent.StepHookResult{Observation: string(value)}, nil
+	case *lua.LTable:
+		result := &phoneagent.StepHookResult{}
+		if observation, ok := value.RawGetString("observation").(lua.LString); ok {
+			result.Observation = string(observation)
+		}
+		if stop := value.RawGetString("stop"); stop != lua.LNil && stop != lua.LFalse {
+			result.Stop = true
+			if message, ok := stop.(lua.LString); ok {
+				result.Message = string(message)
+			}
+		}
+		return result, nil
+	default:
+		return nil, nil
+	}
+}
+
+func (r *Runner) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.state.Close()
+}
+
+func (r *Runner) registerHelpers() {
+	L := r.state
+	L.SetGlobal("log", L.NewFunction(func(L *lua.LState) int {
+		logs.Infof("📜 %s", L.CheckString(1))
+		return 0
+	}))
+	L.SetGlobal("http_get", L.NewFunction(func(L *lua.LState) int {
+		return r.httpRequest(L, http.MethodGet, L.CheckString(1), "", "", L.OptTable(2, nil))
+	}))
+	L.SetGlobal("http_post", L.NewFunction(func(L *lua.LState) int {
+		return r.httpRequest(L, http.MethodPost, L.CheckString(1), L.CheckString(2),
+			L.OptString(3, "application/json"), L.OptTable(4, nil))
+	}))
+	L.SetGlobal("json_encode", L.NewFunction(func(L *lua.LState) int {
+		data, err := json.Marshal(fromLua(L.CheckAny(1)))
+		if err != nil {
+			L.RaiseError("json_encode: %v", err)
+		}
+		L.Push(lua.LString(data))
+		return 1
+	}))
+	L.SetGlobal("json_decode", L.NewFunction(func(L *lua.LState) int {
+		var value any
+		if err := json.Unmarshal([]byte(L.CheckString(1)), &value); err != nil {
+			L.RaiseError("json_decode: %v", err)
+		}
+		L.Push(toLua(L, value))
+		return 1
+	}))
+}
+
+// httpRequest returns the response body and status code to the script, or
+// nil and an error message.
+func (r *Runner) httpRequest(L *lua.LState, method, url, body, contentType string, headers *lua.LTable) int {
+	ctx := L.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, strings.NewReader(body))
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if headers != nil {
+		headers.ForEach(func(key, value lua.LValue) {
+			req.Header.Set(key.String(), value.String())
+		})
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(lua.LString(data))
+	L.Push(lua.LNumber(resp.StatusCode))
+	return 2
+}
@@ -0,0 +1,235 @@
+// Package seal encrypts the artifacts the agent stores, screenshots,
+// recordings, sessions and trajectories, with AES-256-GCM under one key of
+// the process, see Use. Their readers go through ReadFile and OpenLine, which
+// decrypt sealed data and pass plain data through, so artifacts stored
+// before the key was set stay readable.
+package seal
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// magic starts a sealed file, followed by the key id, the nonce and the
+// ciphertext.
+var magic = []byte("AGSEAL\x01")
+
+// linePrefix starts a sealed line of a JSONL file, followed by the base64
+// of the key id, the nonce and the ciphertext.
+const linePrefix = "sealed:"
+
+// keyIDSize is the size of the key id, the start of the SHA-256 of the key,
+// to tell a wrong key from corrupt data.
+const keyIDSize = 4
+
+var (
+	ErrNoKey    = errors.New("artifact is encrypted and no key is set")
+	ErrWrongKey = errors.New("artifact is encrypted with another key")
+)
+
+// Sealer encrypts and decrypts with one key.
+type Sealer struct {
+	aead  cipher.AEAD
+	keyID []byte
+}
+
+// New returns a Sealer of a 32 byte key.
+func New(key []byte) (*Sealer, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("artifact key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(key)
+	return &Sealer{aead: aead, keyID: sum[:keyIDSize]}, nil
+}
+
+// LoadKey reads the key of spec:
+//
+//	file:PATH  the file at PATH
+//	env:NAME   the environment variable NAME
+//	cmd:LINE   the output of the command LINE, e.g. a KMS decrypting a data key
+//
+// The key is 32 raw bytes, or written in hex or base64.
+func LoadKey(ctx context.Context, spec string) ([]byte, error) {
+	kind, value, _ := strings.Cut(spec, ":")
+	var data []byte
+	switch kind {
+	case "file":
+		var err error
+		if data, err = os.ReadFile(value); err != nil {
+			return nil, err
+		}
+	case "env":
+		v, ok := os.LookupEnv(value)
+		if !ok {
+			return nil, fmt.Errorf("artifact key variable %s is not set", value)
+		}
+		data = []byte(v)
+	case "cmd":
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("artifact key command is empty")
+		}
+		cmd := exec.CommandContext(ctx, fields[0], fields[1:]...)
+		cmd.Stderr = os.Stderr
+		var err error
+		if data, err = cmd.Output(); err != nil {
+			return nil, fmt.Errorf("artifact key command failed: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("invalid artifact key %q, want file:PATH, env:NAME or cmd:LINE", kind)
+	}
+	return decodeKey(data)
+}
+
+func decodeKey(data []byte) ([]byte, error) {
+	if len(data) == 32 {
+		return data, nil
+	}
+	text := strings.TrimSpace(string(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, fmt.Errorf("artifact key must be 32 bytes, raw or in hex or base64")
+}
+
+// seal returns the key id, nonce and ciphertext of plain.
+func (s *Sealer) seal(plain []byte) []byte {
+	out := make([]byte, 0, keyIDSize+s.aead.NonceSize()+len(plain)+s.aead.Overhead())
+	out = append(out, s.keyID...)
+	nonce := make([]byte, s.aead.NonceSize())
+	_, _ = rand.Read(nonce)
+	out = append(out, nonce...)
+	return s.aead.Seal(out, nonce, plain, nil)
+}
+
+func (s *Sealer) open(sealed []byte) ([]byte, error) {
+	if s == nil {
+		return nil, ErrNoKey
+	}
+	if len(sealed) < keyIDSize+s.aead.NonceSize() {
+		return nil, fmt.Errorf("sealed artifact is truncated")
+	}
+	if !bytes.Equal(sealed[:keyIDSize], s.keyID) {
+		return nil, ErrWrongKey
+	}
+	sealed = sealed[keyIDSize:]
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	plain, err := s.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt artifact: %w", err)
+	}
+	return plain, nil
+}
+
+var (
+	mu      sync.RWMutex
+	current *Sealer
+)
+
+// Use makes s encrypt the artifacts written from now on, nil stores them in
+// plain, and returns a function restoring the previous one.
+func Use(s *Sealer) func() {
+	mu.Lock()
+	defer mu.Unlock()
+	previous := current
+	current = s
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		current = previous
+	}
+}
+
+func sealer() *Sealer {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// Enabled reports whether artifacts are encrypted.
+func Enabled() bool {
+	return sealer() != nil
+}
+
+// Data returns data as stored: encrypted when a key is set.
+func Data(data []byte) []byte {
+	s := sealer()
+	if s == nil {
+		return data
+	}
+	return append(bytes.Clone(magic), s.seal(data)...)
+}
+
+// Open returns the plain data of stored data, see Data.
+func Open(data []byte) ([]byte, error) {
+	sealed, ok := bytes.CutPrefix(data, magic)
+	if !ok {
+		return data, nil
+	}
+	return sealer().open(sealed)
+}
+
+// WriteFile writes data to path as os.WriteFile, encrypted when a key is
+// set.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	return os.WriteFile(path, Data(data), perm)
+}
+
+// ReadFile reads a file written by WriteFile.
+func ReadFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := Open(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return plain, nil
+}
+
+// Line returns a line of a JSONL file as stored, without its newline:
+// encrypted, on a line of its own, when a key is set.
+func Line(line string) string {
+	s := sealer()
+	if s == nil {
+		return line
+	}
+	return linePrefix + base64.StdEncoding.EncodeToString(s.seal([]byte(line)))
+}
+
+// OpenLine returns the plain line of a stored one, see Line.
+func OpenLine(line []byte) ([]byte, error) {
+	encoded, ok := bytes.CutPrefix(line, []byte(linePrefix))
+	if !ok {
+		return line, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(encoded)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid sealed line: %w", err)
+	}
+	return sealer().open(sealed)
+}
@@ -0,0 +1,62 @@
+package phoneagent
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"autoglm-go/utils"
+)
+
+// ErrActionErrors is returned when AgentConfig.MaxActionErrors actions of the
+// model in a row failed.
+var ErrActionErrors = errors.New("too many failed actions")
+
+// Kinds of an ActionError.
+const (
+	ActionErrorParse     = "parse"     // the answer is not a valid action
+	ActionErrorExecution = "execution" // the device failed to run it, e.g. an ADB error
+	ActionErrorFailed    = "failed"    // it ran without effect, e.g. an unknown app or a text that never appeared
+)
+
+// actionErrorHint follows the ActionError in the observation.
+const actionErrorHint = "The previous action did not work. Look at the current screen and recover with a corrected or different action."
+
+// ActionError is what the model is told of its failed action with the next
+// observation, so that it recovers instead of the task ending.
+type ActionError struct {
+	Kind    string `json:"kind"`
+	Action  string `json:"action,omitempty"` // as the model wrote it
+	Error   string `json:"error"`
+	Attempt int    `json:"attempt"` // failed actions in a row
+	Budget  int    `json:"budget"`  // AgentConfig.MaxActionErrors
+}
+
+func (e ActionError) String() string {
+	return "action_error " + utils.JsonString(e) + "\n" + actionErrorHint
+}
+
+// reportActionError counts a failed action and tells the model about it with
+// the next observation.
+func (r *PhoneAgent) reportActionError(kind, action, message string) {
+	r.actionErrors = append(r.actionErrors, fmt.Sprintf("step %d %s (%s: %s)", r.StepCount, action, kind, message))
+	report := ActionError{
+		Kind:    kind,
+		Action:  action,
+		Error:   message,
+		Attempt: len(r.actionErrors),
+		Budget:  r.AgentConfig.MaxActionErrors,
+	}
+	r.log().Warnf("🩹 step %d: %s action error, attempt %d of %d: %s", r.StepCount, kind, report.Attempt, report.Budget, message)
+	r.hookObservations = append(r.hookObservations, report.String())
+}
+
+// actionErrorsExhausted returns an ErrActionErrors listing the failed actions
+// once AgentConfig.MaxActionErrors failed in a row.
+func (r *PhoneAgent) actionErrorsExhausted() error {
+	limit := r.AgentConfig.MaxActionErrors
+	if limit <= 0 || len(r.actionErrors) < limit {
+		return nil
+	}
+	return fmt.Errorf("%w: %d in a row: %s", ErrActionErrors, len(r.actionErrors), strings.Join(r.actionErrors, "; "))
+}
@@ -0,0 +1,2433 @@
+// The gRPC API of the phone agent: the task API of the HTTP server, with a
+// bidirectional stream submitting and controlling tasks and streaming their
+// progress.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: agent.proto
+
+package agentpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	structpb "google.golang.org/protobuf/types/known/structpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type SubmitTaskRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// device_id may be left out for a tenant with a device pool.
+	DeviceId    string `protobuf:"bytes,1,opt,name=device_id,json=deviceId,proto3" json:"device_id,omitempty"`
+	Tenant      string `protobuf:"bytes,2,opt,name=tenant,proto3" json:"tenant,omitempty"`
+	Instruction string `protobuf:"bytes,3,opt,name=instruction,proto3" json:"instruction,omitempty"`
+	// force runs the task again even if it has just run on the device.
+	Force  bool              `protobuf:"varint,4,opt,name=force,proto3" json:"force,omitempty"`
+	Labels map[string]string `protobuf:"bytes,5,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// priority is low, normal, high or urgent, normal by default.
+	Priority string `protobuf:"bytes,6,opt,name=priority,proto3" json:"priority,omitempty"`
+	// output_schema declares the fields extracted once the task finished, by
+	// type: string, number, integer, boolean, array or object.
+	OutputSchema   map[string]string `protobuf:"bytes,7,rep,name=output_schema,json=outputSchema,proto3" json:"output_schema,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	IdempotencyKey string            `protobuf:"bytes,8,opt,name=idempotency_key,json=idempotencyKey,proto3" json:"idempotency_key,omitempty"`
+	// soft_deadline is in seconds.
+	SoftDeadline float64 `protobuf:"fixed64,9,opt,name=soft_deadline,json=softDeadline,proto3" json:"soft_deadline,omitempty"`
+	ModelProfile string  `protobuf:"bytes,10,opt,name=model_profile,json=modelProfile,proto3" json:"model_profile,omitempty"`
+	MaxSteps     int32   `protobuf:"varint,11,opt,name=max_steps,json=maxSteps,proto3" json:"max_steps,omitempty"`
+	// step_timeout is in seconds.
+	StepTimeout   float64 `protobuf:"fixed64,12,opt,name=step_timeout,json=stepTimeout,proto3" json:"step_timeout,omitempty"`
+	MaxRepeats    int32   `protobuf:"varint,13,opt,name=max_repeats,json=maxRepeats,proto3" json:"max_repeats,omitempty"`
+	MaxTokens     int64   `protobuf:"varint,14,opt,name=max_tokens,json=maxTokens,proto3" json:"max_tokens,omitempty"`
+	MaxCost       float64 `protobuf:"fixed64,15,opt,name=max_cost,json=maxCost,proto3" json:"max_cost,omitempty"`
+	ReadOnly      bool    `protobuf:"varint,16,opt,name=read_only,json=readOnly,proto3" json:"read_only,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubmitTaskRequest) Reset() {
+	*x = SubmitTaskRequest{}
+	mi := &file_agent_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubmitTaskRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubmitTaskRequest) ProtoMessage() {}
+
+func (x *SubmitTaskRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubmitTaskRequest.ProtoReflect.Descriptor instead.
+func (*SubmitTaskRequest) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *SubmitTaskRequest) GetDeviceId() string {
+	if x != nil {
+		return x.DeviceId
+	}
+	return ""
+}
+
+func (x *SubmitTaskRequest) GetTenant() string {
+	if x != nil {
+		return x.Tenant
+	}
+	return ""
+}
+
+func (x *SubmitTaskRequest) GetInstruction() string {
+	if x != nil {
+		return x.Instruction
+	}
+	return ""
+}
+
+func (x *SubmitTaskRequest) GetForce() bool {
+	if x != nil {
+		return x.Force
+	}
+	return false
+}
+
+func (x *SubmitTaskRequest) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *SubmitTaskRequest) GetPriority() string {
+	if x != nil {
+		return x.Priority
+	}
+	return ""
+}
+
+func (x *SubmitTaskRequest) GetOutputSchema() map[string]string {
+	if x != nil {
+		return x.OutputSchema
+	}
+	return nil
+}
+
+func (x *SubmitTaskRequest) GetIdempotencyKey() string {
+	if x != nil {
+		return x.IdempotencyKey
+	}
+	return ""
+}
+
+func (x *SubmitTaskRequest) GetSoftDeadline() float64 {
+	if x != nil {
+		return x.SoftDeadline
+	}
+	return 0
+}
+
+func (x *SubmitTaskRequest) GetModelProfile() string {
+	if x != nil {
+		return x.ModelProfile
+	}
+	return ""
+}
+
+func (x *SubmitTaskRequest) GetMaxSteps() int32 {
+	if x != nil {
+		return x.MaxSteps
+	}
+	return 0
+}
+
+func (x *SubmitTaskRequest) GetStepTimeout() float64 {
+	if x != nil {
+		return x.StepTimeout
+	}
+	return 0
+}
+
+func (x *SubmitTaskRequest) GetMaxRepeats() int32 {
+	if x != nil {
+		return x.MaxRepeats
+	}
+	return 0
+}
+
+func (x *SubmitTaskRequest) GetMaxTokens() int64 {
+	if x != nil {
+		return x.MaxTokens
+	}
+	return 0
+}
+
+func (x *SubmitTaskRequest) GetMaxCost() float64 {
+	if x != nil {
+		return x.MaxCost
+	}
+	return 0
+}
+
+func (x *SubmitTaskRequest) GetReadOnly() bool {
+	if x != nil {
+		return x.ReadOnly
+	}
+	return false
+}
+
+type GetTaskRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	TaskId        string                 `protobuf:"bytes,1,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetTaskRequest) Reset() {
+	*x = GetTaskRequest{}
+	mi := &file_agent_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetTaskRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetTaskRequest) ProtoMessage() {}
+
+func (x *GetTaskRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetTaskRequest.ProtoReflect.Descriptor instead.
+func (*GetTaskRequest) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *GetTaskRequest) GetTaskId() string {
+	if x != nil {
+		return x.TaskId
+	}
+	return ""
+}
+
+type ListTasksRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// device_id keeps the tasks of a device only.
+	DeviceId      string `protobuf:"bytes,1,opt,name=device_id,json=deviceId,proto3" json:"device_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListTasksRequest) Reset() {
+	*x = ListTasksRequest{}
+	mi := &file_agent_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListTasksRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListTasksRequest) ProtoMessage() {}
+
+func (x *ListTasksRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListTasksRequest.ProtoReflect.Descriptor instead.
+func (*ListTasksRequest) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ListTasksRequest) GetDeviceId() string {
+	if x != nil {
+		return x.DeviceId
+	}
+	return ""
+}
+
+type ListTasksResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Tasks         []*Task                `protobuf:"bytes,1,rep,name=tasks,proto3" json:"tasks,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListTasksResponse) Reset() {
+	*x = ListTasksResponse{}
+	mi := &file_agent_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListTasksResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListTasksResponse) ProtoMessage() {}
+
+func (x *ListTasksResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListTasksResponse.ProtoReflect.Descriptor instead.
+func (*ListTasksResponse) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ListTasksResponse) GetTasks() []*Task {
+	if x != nil {
+		return x.Tasks
+	}
+	return nil
+}
+
+// Task is a task and its progress.
+type Task struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Id          string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	DeviceId    string                 `protobuf:"bytes,2,opt,name=device_id,json=deviceId,proto3" json:"device_id,omitempty"`
+	Instruction string                 `protobuf:"bytes,3,opt,name=instruction,proto3" json:"instruction,omitempty"`
+	Labels      map[string]string      `protobuf:"bytes,4,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Priority    string                 `protobuf:"bytes,5,opt,name=priority,proto3" json:"priority,omitempty"`
+	// status is queued, running, paused, succeeded, failed or cancelled.
+	Status string `protobuf:"bytes,6,opt,name=status,proto3" json:"status,omitempty"`
+	// message is the finish message.
+	Message string `protobuf:"bytes,7,opt,name=message,proto3" json:"message,omitempty"`
+	// output holds the fields of the output schema, null when not found.
+	Output    *structpb.Struct `protobuf:"bytes,8,opt,name=output,proto3" json:"output,omitempty"`
+	Error     string           `protobuf:"bytes,9,opt,name=error,proto3" json:"error,omitempty"`
+	StepCount int32            `protobuf:"varint,10,opt,name=step_count,json=stepCount,proto3" json:"step_count,omitempty"`
+	// steps are left out of lists.
+	Steps        []*Step                `protobuf:"bytes,11,rep,name=steps,proto3" json:"steps,omitempty"`
+	Usage        *Usage                 `protobuf:"bytes,12,opt,name=usage,proto3" json:"usage,omitempty"`
+	SubmittedAt  *timestamppb.Timestamp `protobuf:"bytes,13,opt,name=submitted_at,json=submittedAt,proto3" json:"submitted_at,omitempty"`
+	StartedAt    *timestamppb.Timestamp `protobuf:"bytes,14,opt,name=started_at,json=startedAt,proto3" json:"started_at,omitempty"`
+	FinishedAt   *timestamppb.Timestamp `protobuf:"bytes,15,opt,name=finished_at,json=finishedAt,proto3" json:"finished_at,omitempty"`
+	ModelProfile string                 `protobuf:"bytes,16,opt,name=model_profile,json=modelProfile,proto3" json:"model_profile,omitempty"`
+	ReadOnly     bool                   `protobuf:"varint,17,opt,name=read_only,json=readOnly,proto3" json:"read_only,omitempty"`
+	// confirmation is the one the running task waits for an answer to.
+	Confirmation *Confirmation `protobuf:"bytes,18,opt,name=confirmation,proto3" json:"confirmation,omitempty"`
+	// plan is the subgoals of the planner, subgoal the one in progress from 1.
+	Plan    []string `protobuf:"bytes,19,rep,name=plan,proto3" json:"plan,omitempty"`
+	Subgoal int32    `protobuf:"varint,20,opt,name=subgoal,proto3" json:"subgoal,omitempty"`
+	// completion is how much of the task is estimated done, missing while it
+	// cannot be told.
+	Completion    *Completion `protobuf:"bytes,21,opt,name=completion,proto3" json:"completion,omitempty"`
+	Outcome       *Outcome    `protobuf:"bytes,22,opt,name=outcome,proto3" json:"outcome,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Task) Reset() {
+	*x = Task{}
+	mi := &file_agent_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Task) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Task) ProtoMessage() {}
+
+func (x *Task) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Task.ProtoReflect.Descriptor instead.
+func (*Task) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Task) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Task) GetDeviceId() string {
+	if x != nil {
+		return x.DeviceId
+	}
+	return ""
+}
+
+func (x *Task) GetInstruction() string {
+	if x != nil {
+		return x.Instruction
+	}
+	return ""
+}
+
+func (x *Task) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *Task) GetPriority() string {
+	if x != nil {
+		return x.Priority
+	}
+	return ""
+}
+
+func (x *Task) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Task) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *Task) GetOutput() *structpb.Struct {
+	if x != nil {
+		return x.Output
+	}
+	return nil
+}
+
+func (x *Task) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *Task) GetStepCount() int32 {
+	if x != nil {
+		return x.StepCount
+	}
+	return 0
+}
+
+func (x *Task) GetSteps() []*Step {
+	if x != nil {
+		return x.Steps
+	}
+	return nil
+}
+
+func (x *Task) GetUsage() *Usage {
+	if x != nil {
+		return x.Usage
+	}
+	return nil
+}
+
+func (x *Task) GetSubmittedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.SubmittedAt
+	}
+	return nil
+}
+
+func (x *Task) GetStartedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.StartedAt
+	}
+	return nil
+}
+
+func (x *Task) GetFinishedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.FinishedAt
+	}
+	return nil
+}
+
+func (x *Task) GetModelProfile() string {
+	if x != nil {
+		return x.ModelProfile
+	}
+	return ""
+}
+
+func (x *Task) GetReadOnly() bool {
+	if x != nil {
+		return x.ReadOnly
+	}
+	return false
+}
+
+func (x *Task) GetConfirmation() *Confirmation {
+	if x != nil {
+		return x.Confirmation
+	}
+	return nil
+}
+
+func (x *Task) GetPlan() []string {
+	if x != nil {
+		return x.Plan
+	}
+	return nil
+}
+
+func (x *Task) GetSubgoal() int32 {
+	if x != nil {
+		return x.Subgoal
+	}
+	return 0
+}
+
+func (x *Task) GetCompletion() *Completion {
+	if x != nil {
+		return x.Completion
+	}
+	return nil
+}
+
+func (x *Task) GetOutcome() *Outcome {
+	if x != nil {
+		return x.Outcome
+	}
+	return nil
+}
+
+type Completion struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Percent int32                  `protobuf:"varint,1,opt,name=percent,proto3" json:"percent,omitempty"`
+	// basis is plan or history.
+	Basis         string `protobuf:"bytes,2,opt,name=basis,proto3" json:"basis,omitempty"`
+	ExpectedSteps int32  `protobuf:"varint,3,opt,name=expected_steps,json=expectedSteps,proto3" json:"expected_steps,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Completion) Reset() {
+	*x = Completion{}
+	mi := &file_agent_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Completion) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Completion) ProtoMessage() {}
+
+func (x *Completion) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Completion.ProtoReflect.Descriptor instead.
+func (*Completion) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Completion) GetPercent() int32 {
+	if x != nil {
+		return x.Percent
+	}
+	return 0
+}
+
+func (x *Completion) GetBasis() string {
+	if x != nil {
+		return x.Basis
+	}
+	return ""
+}
+
+func (x *Completion) GetExpectedSteps() int32 {
+	if x != nil {
+		return x.ExpectedSteps
+	}
+	return 0
+}
+
+type Usage struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	Requests         int32                  `protobuf:"varint,1,opt,name=requests,proto3" json:"requests,omitempty"`
+	PromptTokens     int32                  `protobuf:"varint,2,opt,name=prompt_tokens,json=promptTokens,proto3" json:"prompt_tokens,omitempty"`
+	CompletionTokens int32                  `protobuf:"varint,3,opt,name=completion_tokens,json=completionTokens,proto3" json:"completion_tokens,omitempty"`
+	Cost             float64                `protobuf:"fixed64,4,opt,name=cost,proto3" json:"cost,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *Usage) Reset() {
+	*x = Usage{}
+	mi := &file_agent_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Usage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Usage) ProtoMessage() {}
+
+func (x *Usage) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Usage.ProtoReflect.Descriptor instead.
+func (*Usage) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Usage) GetRequests() int32 {
+	if x != nil {
+		return x.Requests
+	}
+	return 0
+}
+
+func (x *Usage) GetPromptTokens() int32 {
+	if x != nil {
+		return x.PromptTokens
+	}
+	return 0
+}
+
+func (x *Usage) GetCompletionTokens() int32 {
+	if x != nil {
+		return x.CompletionTokens
+	}
+	return 0
+}
+
+func (x *Usage) GetCost() float64 {
+	if x != nil {
+		return x.Cost
+	}
+	return 0
+}
+
+type Outcome struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Level         string                 `protobuf:"bytes,1,opt,name=level,proto3" json:"level,omitempty"`
+	Reason        string                 `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+	Detail        string                 `protobuf:"bytes,3,opt,name=detail,proto3" json:"detail,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Outcome) Reset() {
+	*x = Outcome{}
+	mi := &file_agent_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Outcome) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Outcome) ProtoMessage() {}
+
+func (x *Outcome) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Outcome.ProtoReflect.Descriptor instead.
+func (*Outcome) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *Outcome) GetLevel() string {
+	if x != nil {
+		return x.Level
+	}
+	return ""
+}
+
+func (x *Outcome) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *Outcome) GetDetail() string {
+	if x != nil {
+		return x.Detail
+	}
+	return ""
+}
+
+// Step is a step of a task.
+type Step struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Step          int32                  `protobuf:"varint,1,opt,name=step,proto3" json:"step,omitempty"`
+	App           string                 `protobuf:"bytes,2,opt,name=app,proto3" json:"app,omitempty"`
+	Action        *Action                `protobuf:"bytes,3,opt,name=action,proto3" json:"action,omitempty"`
+	Success       bool                   `protobuf:"varint,4,opt,name=success,proto3" json:"success,omitempty"`
+	Message       string                 `protobuf:"bytes,5,opt,name=message,proto3" json:"message,omitempty"`
+	At            *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=at,proto3" json:"at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Step) Reset() {
+	*x = Step{}
+	mi := &file_agent_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Step) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Step) ProtoMessage() {}
+
+func (x *Step) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Step.ProtoReflect.Descriptor instead.
+func (*Step) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *Step) GetStep() int32 {
+	if x != nil {
+		return x.Step
+	}
+	return 0
+}
+
+func (x *Step) GetApp() string {
+	if x != nil {
+		return x.App
+	}
+	return ""
+}
+
+func (x *Step) GetAction() *Action {
+	if x != nil {
+		return x.Action
+	}
+	return nil
+}
+
+func (x *Step) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *Step) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *Step) GetAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.At
+	}
+	return nil
+}
+
+// Action is an action of the model.
+type Action struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// kind is do, or finish for the end of the task.
+	Kind string `protobuf:"bytes,1,opt,name=kind,proto3" json:"kind,omitempty"`
+	// name is the action of a do, e.g. Tap, Type or Launch.
+	Name string `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	// args are the arguments of the action, e.g. element, text or app, the
+	// message of a finish.
+	Args          *structpb.Struct `protobuf:"bytes,3,opt,name=args,proto3" json:"args,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Action) Reset() {
+	*x = Action{}
+	mi := &file_agent_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Action) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Action) ProtoMessage() {}
+
+func (x *Action) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Action.ProtoReflect.Descriptor instead.
+func (*Action) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *Action) GetKind() string {
+	if x != nil {
+		return x.Kind
+	}
+	return ""
+}
+
+func (x *Action) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Action) GetArgs() *structpb.Struct {
+	if x != nil {
+		return x.Args
+	}
+	return nil
+}
+
+// Confirmation is a sensitive action or a takeover waiting for an answer.
+type Confirmation struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	// kind is confirmation or takeover.
+	Kind     string                 `protobuf:"bytes,2,opt,name=kind,proto3" json:"kind,omitempty"`
+	TaskId   string                 `protobuf:"bytes,3,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"`
+	DeviceId string                 `protobuf:"bytes,4,opt,name=device_id,json=deviceId,proto3" json:"device_id,omitempty"`
+	Task     string                 `protobuf:"bytes,5,opt,name=task,proto3" json:"task,omitempty"`
+	Message  string                 `protobuf:"bytes,6,opt,name=message,proto3" json:"message,omitempty"`
+	Step     int32                  `protobuf:"varint,7,opt,name=step,proto3" json:"step,omitempty"`
+	Labels   map[string]string      `protobuf:"bytes,8,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	At       *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=at,proto3" json:"at,omitempty"`
+	// deadline is missing when it waits as long as the task.
+	Deadline      *timestamppb.Timestamp `protobuf:"bytes,10,opt,name=deadline,proto3" json:"deadline,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Confirmation) Reset() {
+	*x = Confirmation{}
+	mi := &file_agent_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Confirmation) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Confirmation) ProtoMessage() {}
+
+func (x *Confirmation) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Confirmation.ProtoReflect.Descriptor instead.
+func (*Confirmation) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *Confirmation) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Confirmation) GetKind() string {
+	if x != nil {
+		return x.Kind
+	}
+	return ""
+}
+
+func (x *Confirmation) GetTaskId() string {
+	if x != nil {
+		return x.TaskId
+	}
+	return ""
+}
+
+func (x *Confirmation) GetDeviceId() string {
+	if x != nil {
+		return x.DeviceId
+	}
+	return ""
+}
+
+func (x *Confirmation) GetTask() string {
+	if x != nil {
+		return x.Task
+	}
+	return ""
+}
+
+func (x *Confirmation) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *Confirmation) GetStep() int32 {
+	if x != nil {
+		return x.Step
+	}
+	return 0
+}
+
+func (x *Confirmation) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *Confirmation) GetAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.At
+	}
+	return nil
+}
+
+func (x *Confirmation) GetDeadline() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Deadline
+	}
+	return nil
+}
+
+// TaskEvent is a moment of a task.
+type TaskEvent struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// id numbers the events of a task from 1, the status a stream starts with
+	// has none.
+	Id     uint64 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	TaskId string `protobuf:"bytes,2,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"`
+	// type is screenshot, thinking, action_delta, action, action_result, plan,
+	// progress, anomaly, paused, resumed, cancelled, confirmation, status or
+	// done.
+	Type string                 `protobuf:"bytes,3,opt,name=type,proto3" json:"type,omitempty"`
+	Step int32                  `protobuf:"varint,4,opt,name=step,proto3" json:"step,omitempty"`
+	At   *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=at,proto3" json:"at,omitempty"`
+	// delta is the next piece of the thinking or action.
+	Delta   string  `protobuf:"bytes,6,opt,name=delta,proto3" json:"delta,omitempty"`
+	Action  *Action `protobuf:"bytes,7,opt,name=action,proto3" json:"action,omitempty"`
+	Success bool    `protobuf:"varint,8,opt,name=success,proto3" json:"success,omitempty"`
+	Message string  `protobuf:"bytes,9,opt,name=message,proto3" json:"message,omitempty"`
+	App     string  `protobuf:"bytes,10,opt,name=app,proto3" json:"app,omitempty"`
+	// image is the screenshot, as the model sees it, of the given type.
+	Image     []byte   `protobuf:"bytes,11,opt,name=image,proto3" json:"image,omitempty"`
+	ImageType string   `protobuf:"bytes,12,opt,name=image_type,json=imageType,proto3" json:"image_type,omitempty"`
+	Width     int32    `protobuf:"varint,13,opt,name=width,proto3" json:"width,omitempty"`
+	Height    int32    `protobuf:"varint,14,opt,name=height,proto3" json:"height,omitempty"`
+	Plan      []string `protobuf:"bytes,15,rep,name=plan,proto3" json:"plan,omitempty"`
+	Subgoal   int32    `protobuf:"varint,16,opt,name=subgoal,proto3" json:"subgoal,omitempty"`
+	// task is the task of status and done.
+	Task          *Task         `protobuf:"bytes,17,opt,name=task,proto3" json:"task,omitempty"`
+	Confirmation  *Confirmation `protobuf:"bytes,18,opt,name=confirmation,proto3" json:"confirmation,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TaskEvent) Reset() {
+	*x = TaskEvent{}
+	mi := &file_agent_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TaskEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TaskEvent) ProtoMessage() {}
+
+func (x *TaskEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TaskEvent.ProtoReflect.Descriptor instead.
+func (*TaskEvent) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *TaskEvent) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *TaskEvent) GetTaskId() string {
+	if x != nil {
+		return x.TaskId
+	}
+	return ""
+}
+
+func (x *TaskEvent) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *TaskEvent) GetStep() int32 {
+	if x != nil {
+		return x.Step
+	}
+	return 0
+}
+
+func (x *TaskEvent) GetAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.At
+	}
+	return nil
+}
+
+func (x *TaskEvent) GetDelta() string {
+	if x != nil {
+		return x.Delta
+	}
+	return ""
+}
+
+func (x *TaskEvent) GetAction() *Action {
+	if x != nil {
+		return x.Action
+	}
+	return nil
+}
+
+func (x *TaskEvent) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *TaskEvent) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *TaskEvent) GetApp() string {
+	if x != nil {
+		return x.App
+	}
+	return ""
+}
+
+func (x *TaskEvent) GetImage() []byte {
+	if x != nil {
+		return x.Image
+	}
+	return nil
+}
+
+func (x *TaskEvent) GetImageType() string {
+	if x != nil {
+		return x.ImageType
+	}
+	return ""
+}
+
+func (x *TaskEvent) GetWidth() int32 {
+	if x != nil {
+		return x.Width
+	}
+	return 0
+}
+
+func (x *TaskEvent) GetHeight() int32 {
+	if x != nil {
+		return x.Height
+	}
+	return 0
+}
+
+func (x *TaskEvent) GetPlan() []string {
+	if x != nil {
+		return x.Plan
+	}
+	return nil
+}
+
+func (x *TaskEvent) GetSubgoal() int32 {
+	if x != nil {
+		return x.Subgoal
+	}
+	return 0
+}
+
+func (x *TaskEvent) GetTask() *Task {
+	if x != nil {
+		return x.Task
+	}
+	return nil
+}
+
+func (x *TaskEvent) GetConfirmation() *Confirmation {
+	if x != nil {
+		return x.Confirmation
+	}
+	return nil
+}
+
+type ControlRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// request_id is echoed by the ControlResult of the command.
+	RequestId string `protobuf:"bytes,1,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	// Types that are valid to be assigned to Command:
+	//
+	//	*ControlRequest_Submit
+	//	*ControlRequest_Watch
+	//	*ControlRequest_Cancel
+	//	*ControlRequest_Pause
+	//	*ControlRequest_Resume
+	//	*ControlRequest_Answer
+	Command       isControlRequest_Command `protobuf_oneof:"command"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ControlRequest) Reset() {
+	*x = ControlRequest{}
+	mi := &file_agent_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ControlRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ControlRequest) ProtoMessage() {}
+
+func (x *ControlRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ControlRequest.ProtoReflect.Descriptor instead.
+func (*ControlRequest) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *ControlRequest) GetRequestId() string {
+	if x != nil {
+		return x.RequestId
+	}
+	return ""
+}
+
+func (x *ControlRequest) GetCommand() isControlRequest_Command {
+	if x != nil {
+		return x.Command
+	}
+	return nil
+}
+
+func (x *ControlRequest) GetSubmit() *SubmitCommand {
+	if x != nil {
+		if x, ok := x.Command.(*ControlRequest_Submit); ok {
+			return x.Submit
+		}
+	}
+	return nil
+}
+
+func (x *ControlRequest) GetWatch() *WatchCommand {
+	if x != nil {
+		if x, ok := x.Command.(*ControlRequest_Watch); ok {
+			return x.Watch
+		}
+	}
+	return nil
+}
+
+func (x *ControlRequest) GetCancel() *CancelCommand {
+	if x != nil {
+		if x, ok := x.Command.(*ControlRequest_Cancel); ok {
+			return x.Cancel
+		}
+	}
+	return nil
+}
+
+func (x *ControlRequest) GetPause() *PauseCommand {
+	if x != nil {
+		if x, ok := x.Command.(*ControlRequest_Pause); ok {
+			return x.Pause
+		}
+	}
+	return nil
+}
+
+func (x *ControlRequest) GetResume() *ResumeCommand {
+	if x != nil {
+		if x, ok := x.Command.(*ControlRequest_Resume); ok {
+			return x.Resume
+		}
+	}
+	return nil
+}
+
+func (x *ControlRequest) GetAnswer() *AnswerCommand {
+	if x != nil {
+		if x, ok := x.Command.(*ControlRequest_Answer); ok {
+			return x.Answer
+		}
+	}
+	return nil
+}
+
+type isControlRequest_Command interface {
+	isControlRequest_Command()
+}
+
+type ControlRequest_Submit struct {
+	Submit *SubmitCommand `protobuf:"bytes,2,opt,name=submit,proto3,oneof"`
+}
+
+type ControlRequest_Watch struct {
+	Watch *WatchCommand `protobuf:"bytes,3,opt,name=watch,proto3,oneof"`
+}
+
+type ControlRequest_Cancel struct {
+	Cancel *CancelCommand `protobuf:"bytes,4,opt,name=cancel,proto3,oneof"`
+}
+
+type ControlRequest_Pause struct {
+	Pause *PauseCommand `protobuf:"bytes,5,opt,name=pause,proto3,oneof"`
+}
+
+type ControlRequest_Resume struct {
+	Resume *ResumeCommand `protobuf:"bytes,6,opt,name=resume,proto3,oneof"`
+}
+
+type ControlRequest_Answer struct {
+	Answer *AnswerCommand `protobuf:"bytes,7,opt,name=answer,proto3,oneof"`
+}
+
+func (*ControlRequest_Submit) isControlRequest_Command() {}
+
+func (*ControlRequest_Watch) isControlRequest_Command() {}
+
+func (*ControlRequest_Cancel) isControlRequest_Command() {}
+
+func (*ControlRequest_Pause) isControlRequest_Command() {}
+
+func (*ControlRequest_Resume) isControlRequest_Command() {}
+
+func (*ControlRequest_Answer) isControlRequest_Command() {}
+
+// SubmitCommand submits a task and streams its events.
+type SubmitCommand struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Task  *SubmitTaskRequest     `protobuf:"bytes,1,opt,name=task,proto3" json:"task,omitempty"`
+	// images sends the screenshots with the screenshot events.
+	Images        bool `protobuf:"varint,2,opt,name=images,proto3" json:"images,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubmitCommand) Reset() {
+	*x = SubmitCommand{}
+	mi := &file_agent_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubmitCommand) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubmitCommand) ProtoMessage() {}
+
+func (x *SubmitCommand) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubmitCommand.ProtoReflect.Descriptor instead.
+func (*SubmitCommand) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *SubmitCommand) GetTask() *SubmitTaskRequest {
+	if x != nil {
+		return x.Task
+	}
+	return nil
+}
+
+func (x *SubmitCommand) GetImages() bool {
+	if x != nil {
+		return x.Images
+	}
+	return false
+}
+
+// WatchCommand streams the events of a task.
+type WatchCommand struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	TaskId string                 `protobuf:"bytes,1,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"`
+	// after resends the events kept after the one of this id.
+	After         uint64 `protobuf:"varint,2,opt,name=after,proto3" json:"after,omitempty"`
+	Images        bool   `protobuf:"varint,3,opt,name=images,proto3" json:"images,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchCommand) Reset() {
+	*x = WatchCommand{}
+	mi := &file_agent_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchCommand) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchCommand) ProtoMessage() {}
+
+func (x *WatchCommand) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchCommand.ProtoReflect.Descriptor instead.
+func (*WatchCommand) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *WatchCommand) GetTaskId() string {
+	if x != nil {
+		return x.TaskId
+	}
+	return ""
+}
+
+func (x *WatchCommand) GetAfter() uint64 {
+	if x != nil {
+		return x.After
+	}
+	return 0
+}
+
+func (x *WatchCommand) GetImages() bool {
+	if x != nil {
+		return x.Images
+	}
+	return false
+}
+
+// CancelCommand stops a task, it ends once the current step is done.
+type CancelCommand struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	TaskId string                 `protobuf:"bytes,1,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"`
+	// home also goes back to the home screen.
+	Home          bool `protobuf:"varint,2,opt,name=home,proto3" json:"home,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CancelCommand) Reset() {
+	*x = CancelCommand{}
+	mi := &file_agent_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CancelCommand) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CancelCommand) ProtoMessage() {}
+
+func (x *CancelCommand) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CancelCommand.ProtoReflect.Descriptor instead.
+func (*CancelCommand) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *CancelCommand) GetTaskId() string {
+	if x != nil {
+		return x.TaskId
+	}
+	return ""
+}
+
+func (x *CancelCommand) GetHome() bool {
+	if x != nil {
+		return x.Home
+	}
+	return false
+}
+
+// PauseCommand pauses a task after its current step.
+type PauseCommand struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	TaskId        string                 `protobuf:"bytes,1,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PauseCommand) Reset() {
+	*x = PauseCommand{}
+	mi := &file_agent_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PauseCommand) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PauseCommand) ProtoMessage() {}
+
+func (x *PauseCommand) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PauseCommand.ProtoReflect.Descriptor instead.
+func (*PauseCommand) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *PauseCommand) GetTaskId() string {
+	if x != nil {
+		return x.TaskId
+	}
+	return ""
+}
+
+type ResumeCommand struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	TaskId        string                 `protobuf:"bytes,1,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResumeCommand) Reset() {
+	*x = ResumeCommand{}
+	mi := &file_agent_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResumeCommand) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResumeCommand) ProtoMessage() {}
+
+func (x *ResumeCommand) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResumeCommand.ProtoReflect.Descriptor instead.
+func (*ResumeCommand) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *ResumeCommand) GetTaskId() string {
+	if x != nil {
+		return x.TaskId
+	}
+	return ""
+}
+
+// AnswerCommand answers a confirmation: approve the action, or hand the
+// device back after a takeover. Rejecting it ends the task.
+type AnswerCommand struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	ConfirmationId string                 `protobuf:"bytes,1,opt,name=confirmation_id,json=confirmationId,proto3" json:"confirmation_id,omitempty"`
+	Approve        bool                   `protobuf:"varint,2,opt,name=approve,proto3" json:"approve,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *AnswerCommand) Reset() {
+	*x = AnswerCommand{}
+	mi := &file_agent_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AnswerCommand) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AnswerCommand) ProtoMessage() {}
+
+func (x *AnswerCommand) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AnswerCommand.ProtoReflect.Descriptor instead.
+func (*AnswerCommand) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *AnswerCommand) GetConfirmationId() string {
+	if x != nil {
+		return x.ConfirmationId
+	}
+	return ""
+}
+
+func (x *AnswerCommand) GetApprove() bool {
+	if x != nil {
+		return x.Approve
+	}
+	return false
+}
+
+type ControlResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Response:
+	//
+	//	*ControlResponse_Result
+	//	*ControlResponse_Event
+	Response      isControlResponse_Response `protobuf_oneof:"response"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ControlResponse) Reset() {
+	*x = ControlResponse{}
+	mi := &file_agent_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ControlResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ControlResponse) ProtoMessage() {}
+
+func (x *ControlResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ControlResponse.ProtoReflect.Descriptor instead.
+func (*ControlResponse) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *ControlResponse) GetResponse() isControlResponse_Response {
+	if x != nil {
+		return x.Response
+	}
+	return nil
+}
+
+func (x *ControlResponse) GetResult() *ControlResult {
+	if x != nil {
+		if x, ok := x.Response.(*ControlResponse_Result); ok {
+			return x.Result
+		}
+	}
+	return nil
+}
+
+func (x *ControlResponse) GetEvent() *TaskEvent {
+	if x != nil {
+		if x, ok := x.Response.(*ControlResponse_Event); ok {
+			return x.Event
+		}
+	}
+	return nil
+}
+
+type isControlResponse_Response interface {
+	isControlResponse_Response()
+}
+
+type ControlResponse_Result struct {
+	Result *ControlResult `protobuf:"bytes,1,opt,name=result,proto3,oneof"`
+}
+
+type ControlResponse_Event struct {
+	Event *TaskEvent `protobuf:"bytes,2,opt,name=event,proto3,oneof"`
+}
+
+func (*ControlResponse_Result) isControlResponse_Response() {}
+
+func (*ControlResponse_Event) isControlResponse_Response() {}
+
+// ControlResult answers a command.
+type ControlResult struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	RequestId string                 `protobuf:"bytes,1,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	// task is the task of the command, as it is after it.
+	Task *Task `protobuf:"bytes,2,opt,name=task,proto3" json:"task,omitempty"`
+	// code is the google.rpc.Code of the failure, 0 when the command succeeded.
+	Code          int32  `protobuf:"varint,3,opt,name=code,proto3" json:"code,omitempty"`
+	Error         string `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ControlResult) Reset() {
+	*x = ControlResult{}
+	mi := &file_agent_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ControlResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ControlResult) ProtoMessage() {}
+
+func (x *ControlResult) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ControlResult.ProtoReflect.Descriptor instead.
+func (*ControlResult) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *ControlResult) GetRequestId() string {
+	if x != nil {
+		return x.RequestId
+	}
+	return ""
+}
+
+func (x *ControlResult) GetTask() *Task {
+	if x != nil {
+		return x.Task
+	}
+	return nil
+}
+
+func (x *ControlResult) GetCode() int32 {
+	if x != nil {
+		return x.Code
+	}
+	return 0
+}
+
+func (x *ControlResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type ListDevicesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListDevicesRequest) Reset() {
+	*x = ListDevicesRequest{}
+	mi := &file_agent_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListDevicesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListDevicesRequest) ProtoMessage() {}
+
+func (x *ListDevicesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListDevicesRequest.ProtoReflect.Descriptor instead.
+func (*ListDevicesRequest) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{21}
+}
+
+type ListDevicesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Devices       []*Device              `protobuf:"bytes,1,rep,name=devices,proto3" json:"devices,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListDevicesResponse) Reset() {
+	*x = ListDevicesResponse{}
+	mi := &file_agent_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListDevicesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListDevicesResponse) ProtoMessage() {}
+
+func (x *ListDevicesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListDevicesResponse.ProtoReflect.Descriptor instead.
+func (*ListDevicesResponse) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *ListDevicesResponse) GetDevices() []*Device {
+	if x != nil {
+		return x.Devices
+	}
+	return nil
+}
+
+type Device struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	DeviceId       string                 `protobuf:"bytes,1,opt,name=device_id,json=deviceId,proto3" json:"device_id,omitempty"`
+	Status         string                 `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"`
+	ConnectionType string                 `protobuf:"bytes,3,opt,name=connection_type,json=connectionType,proto3" json:"connection_type,omitempty"`
+	Model          string                 `protobuf:"bytes,4,opt,name=model,proto3" json:"model,omitempty"`
+	AndroidVersion string                 `protobuf:"bytes,5,opt,name=android_version,json=androidVersion,proto3" json:"android_version,omitempty"`
+	Os             string                 `protobuf:"bytes,6,opt,name=os,proto3" json:"os,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *Device) Reset() {
+	*x = Device{}
+	mi := &file_agent_proto_msgTypes[23]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Device) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Device) ProtoMessage() {}
+
+func (x *Device) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[23]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Device.ProtoReflect.Descriptor instead.
+func (*Device) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{23}
+}
+
+func (x *Device) GetDeviceId() string {
+	if x != nil {
+		return x.DeviceId
+	}
+	return ""
+}
+
+func (x *Device) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Device) GetConnectionType() string {
+	if x != nil {
+		return x.ConnectionType
+	}
+	return ""
+}
+
+func (x *Device) GetModel() string {
+	if x != nil {
+		return x.Model
+	}
+	return ""
+}
+
+func (x *Device) GetAndroidVersion() string {
+	if x != nil {
+		return x.AndroidVersion
+	}
+	return ""
+}
+
+func (x *Device) GetOs() string {
+	if x != nil {
+		return x.Os
+	}
+	return ""
+}
+
+type WatchDevicesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchDevicesRequest) Reset() {
+	*x = WatchDevicesRequest{}
+	mi := &file_agent_proto_msgTypes[24]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchDevicesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchDevicesRequest) ProtoMessage() {}
+
+func (x *WatchDevicesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[24]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchDevicesRequest.ProtoReflect.Descriptor instead.
+func (*WatchDevicesRequest) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{24}
+}
+
+// DeviceEvent is a device changing state.
+type DeviceEvent struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	DeviceId string                 `protobuf:"bytes,1,opt,name=device_id,json=deviceId,proto3" json:"device_id,omitempty"`
+	// state is online, offline, unauthorized...
+	State string `protobuf:"bytes,2,opt,name=state,proto3" json:"state,omitempty"`
+	// previous is empty for a device seen for the first time.
+	Previous      string                 `protobuf:"bytes,3,opt,name=previous,proto3" json:"previous,omitempty"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=time,proto3" json:"time,omitempty"`
+	Error         string                 `protobuf:"bytes,5,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeviceEvent) Reset() {
+	*x = DeviceEvent{}
+	mi := &file_agent_proto_msgTypes[25]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeviceEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeviceEvent) ProtoMessage() {}
+
+func (x *DeviceEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_agent_proto_msgTypes[25]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeviceEvent.ProtoReflect.Descriptor instead.
+func (*DeviceEvent) Descriptor() ([]byte, []int) {
+	return file_agent_proto_rawDescGZIP(), []int{25}
+}
+
+func (x *DeviceEvent) GetDeviceId() string {
+	if x != nil {
+		return x.DeviceId
+	}
+	return ""
+}
+
+func (x *DeviceEvent) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+func (x *DeviceEvent) GetPrevious() string {
+	if x != nil {
+		return x.Previous
+	}
+	return ""
+}
+
+func (x *DeviceEvent) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *DeviceEvent) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+var File_agent_proto protoreflect.FileDescriptor
+
+const file_agent_proto_rawDesc = "" +
+	"\n" +
+	"\vagent.proto\x12\x10autoglm.agent.v1\x1a\x1cgoogle/protobuf/struct.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\xe8\x05\n" +
+	"\x11SubmitTaskRequest\x12\x1b\n" +
+	"\tdevice_id\x18\x01 \x01(\tR\bdeviceId\x12\x16\n" +
+	"\x06tenant\x18\x02 \x01(\tR\x06tenant\x12 \n" +
+	"\vinstruction\x18\x03 \x01(\tR\vinstruction\x12\x14\n" +
+	"\x05force\x18\x04 \x01(\bR\x05force\x12G\n" +
+	"\x06labels\x18\x05 \x03(\v2/.autoglm.agent.v1.SubmitTaskRequest.LabelsEntryR\x06labels\x12\x1a\n" +
+	"\bpriority\x18\x06 \x01(\tR\bpriority\x12Z\n" +
+	"\routput_schema\x18\a \x03(\v25.autoglm.agent.v1.SubmitTaskRequest.OutputSchemaEntryR\foutputSchema\x12'\n" +
+	"\x0fidempotency_key\x18\b \x01(\tR\x0eidempotencyKey\x12#\n" +
+	"\rsoft_deadline\x18\t \x01(\x01R\fsoftDeadline\x12#\n" +
+	"\rmodel_profile\x18\n" +
+	" \x01(\tR\fmodelProfile\x12\x1b\n" +
+	"\tmax_steps\x18\v \x01(\x05R\bmaxSteps\x12!\n" +
+	"\fstep_timeout\x18\f \x01(\x01R\vstepTimeout\x12\x1f\n" +
+	"\vmax_repeats\x18\r \x01(\x05R\n" +
+	"maxRepeats\x12\x1d\n" +
+	"\n" +
+	"max_tokens\x18\x0e \x01(\x03R\tmaxTokens\x12\x19\n" +
+	"\bmax_cost\x18\x0f \x01(\x01R\amaxCost\x12\x1b\n" +
+	"\tread_only\x18\x10 \x01(\bR\breadOnly\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1a?\n" +
+	"\x11OutputSchemaEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\")\n" +
+	"\x0eGetTaskRequest\x12\x17\n" +
+	"\atask_id\x18\x01 \x01(\tR\x06taskId\"/\n" +
+	"\x10ListTasksRequest\x12\x1b\n" +
+	"\tdevice_id\x18\x01 \x01(\tR\bdeviceId\"A\n" +
+	"\x11ListTasksResponse\x12,\n" +
+	"\x05tasks\x18\x01 \x03(\v2\x16.autoglm.agent.v1.TaskR\x05tasks\"\xbb\a\n" +
+	"\x04Task\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1b\n" +
+	"\tdevice_id\x18\x02 \x01(\tR\bdeviceId\x12 \n" +
+	"\vinstruction\x18\x03 \x01(\tR\vinstruction\x12:\n" +
+	"\x06labels\x18\x04 \x03(\v2\".autoglm.agent.v1.Task.LabelsEntryR\x06labels\x12\x1a\n" +
+	"\bpriority\x18\x05 \x01(\tR\bpriority\x12\x16\n" +
+	"\x06status\x18\x06 \x01(\tR\x06status\x12\x18\n" +
+	"\amessage\x18\a \x01(\tR\amessage\x12/\n" +
+	"\x06output\x18\b \x01(\v2\x17.google.protobuf.StructR\x06output\x12\x14\n" +
+	"\x05error\x18\t \x01(\tR\x05error\x12\x1d\n" +
+	"\n" +
+	"step_count\x18\n" +
+	" \x01(\x05R\tstepCount\x12,\n" +
+	"\x05steps\x18\v \x03(\v2\x16.autoglm.agent.v1.StepR\x05steps\x12-\n" +
+	"\x05usage\x18\f \x01(\v2\x17.autoglm.agent.v1.UsageR\x05usage\x12=\n" +
+	"\fsubmitted_at\x18\r \x01(\v2\x1a.google.protobuf.TimestampR\vsubmittedAt\x129\n" +
+	"\n" +
+	"started_at\x18\x0e \x01(\v2\x1a.google.protobuf.TimestampR\tstartedAt\x12;\n" +
+	"\vfinished_at\x18\x0f \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"finishedAt\x12#\n" +
+	"\rmodel_profile\x18\x10 \x01(\tR\fmodelProfile\x12\x1b\n" +
+	"\tread_only\x18\x11 \x01(\bR\breadOnly\x12B\n" +
+	"\fconfirmation\x18\x12 \x01(\v2\x1e.autoglm.agent.v1.ConfirmationR\fconfirmation\x12\x12\n" +
+	"\x04plan\x18\x13 \x03(\tR\x04plan\x12\x18\n" +
+	"\asubgoal\x18\x14 \x01(\x05R\asubgoal\x12<\n" +
+	"\n" +
+	"completion\x18\x15 \x01(\v2\x1c.autoglm.agent.v1.CompletionR\n" +
+	"completion\x123\n" +
+	"\aoutcome\x18\x16 \x01(\v2\x19.autoglm.agent.v1.OutcomeR\aoutcome\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"c\n" +
+	"\n" +
+	"Completion\x12\x18\n" +
+	"\apercent\x18\x01 \x01(\x05R\apercent\x12\x14\n" +
+	"\x05basis\x18\x02 \x01(\tR\x05basis\x12%\n" +
+	"\x0eexpected_steps\x18\x03 \x01(\x05R\rexpectedSteps\"\x89\x01\n" +
+	"\x05Usage\x12\x1a\n" +
+	"\brequests\x18\x01 \x01(\x05R\brequests\x12#\n" +
+	"\rprompt_tokens\x18\x02 \x01(\x05R\fpromptTokens\x12+\n" +
+	"\x11completion_tokens\x18\x03 \x01(\x05R\x10completionTokens\x12\x12\n" +
+	"\x04cost\x18\x04 \x01(\x01R\x04cost\"O\n" +
+	"\aOutcome\x12\x14\n" +
+	"\x05level\x18\x01 \x01(\tR\x05level\x12\x16\n" +
+	"\x06reason\x18\x02 \x01(\tR\x06reason\x12\x16\n" +
+	"\x06detail\x18\x03 \x01(\tR\x06detail\"\xbe\x01\n" +
+	"\x04Step\x12\x12\n" +
+	"\x04step\x18\x01 \x01(\x05R\x04step\x12\x10\n" +
+	"\x03app\x18\x02 \x01(\tR\x03app\x120\n" +
+	"\x06action\x18\x03 \x01(\v2\x18.autoglm.agent.v1.ActionR\x06action\x12\x18\n" +
+	"\asuccess\x18\x04 \x01(\bR\asuccess\x12\x18\n" +
+	"\amessage\x18\x05 \x01(\tR\amessage\x12*\n" +
+	"\x02at\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\x02at\"]\n" +
+	"\x06Action\x12\x12\n" +
+	"\x04kind\x18\x01 \x01(\tR\x04kind\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12+\n" +
+	"\x04args\x18\x03 \x01(\v2\x17.google.protobuf.StructR\x04args\"\x8d\x03\n" +
+	"\fConfirmation\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04kind\x18\x02 \x01(\tR\x04kind\x12\x17\n" +
+	"\atask_id\x18\x03 \x01(\tR\x06taskId\x12\x1b\n" +
+	"\tdevice_id\x18\x04 \x01(\tR\bdeviceId\x12\x12\n" +
+	"\x04task\x18\x05 \x01(\tR\x04task\x12\x18\n" +
+	"\amessage\x18\x06 \x01(\tR\amessage\x12\x12\n" +
+	"\x04step\x18\a \x01(\x05R\x04step\x12B\n" +
+	"\x06labels\x18\b \x03(\v2*.autoglm.agent.v1.Confirmation.LabelsEntryR\x06labels\x12*\n" +
+	"\x02at\x18\t \x01(\v2\x1a.google.protobuf.TimestampR\x02at\x126\n" +
+	"\bdeadline\x18\n" +
+	" \x01(\v2\x1a.google.protobuf.TimestampR\bdeadline\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\x97\x04\n" +
+	"\tTaskEvent\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x04R\x02id\x12\x17\n" +
+	"\atask_id\x18\x02 \x01(\tR\x06taskId\x12\x12\n" +
+	"\x04type\x18\x03 \x01(\tR\x04type\x12\x12\n" +
+	"\x04step\x18\x04 \x01(\x05R\x04step\x12*\n" +
+	"\x02at\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\x02at\x12\x14\n" +
+	"\x05delta\x18\x06 \x01(\tR\x05delta\x120\n" +
+	"\x06action\x18\a \x01(\v2\x18.autoglm.agent.v1.ActionR\x06action\x12\x18\n" +
+	"\asuccess\x18\b \x01(\bR\asuccess\x12\x18\n" +
+	"\amessage\x18\t \x01(\tR\amessage\x12\x10\n" +
+	"\x03app\x18\n" +
+	" \x01(\tR\x03app\x12\x14\n" +
+	"\x05image\x18\v \x01(\fR\x05image\x12\x1d\n" +
+	"\n" +
+	"image_type\x18\f \x01(\tR\timageType\x12\x14\n" +
+	"\x05width\x18\r \x01(\x05R\x05width\x12\x16\n" +
+	"\x06height\x18\x0e \x01(\x05R\x06height\x12\x12\n" +
+	"\x04plan\x18\x0f \x03(\tR\x04plan\x12\x18\n" +
+	"\asubgoal\x18\x10 \x01(\x05R\asubgoal\x12*\n" +
+	"\x04task\x18\x11 \x01(\v2\x16.autoglm.agent.v1.TaskR\x04task\x12B\n" +
+	"\fconfirmation\x18\x12 \x01(\v2\x1e.autoglm.agent.v1.ConfirmationR\fconfirmation\"\x96\x03\n" +
+	"\x0eControlRequest\x12\x1d\n" +
+	"\n" +
+	"request_id\x18\x01 \x01(\tR\trequestId\x129\n" +
+	"\x06submit\x18\x02 \x01(\v2\x1f.autoglm.agent.v1.SubmitCommandH\x00R\x06submit\x126\n" +
+	"\x05watch\x18\x03 \x01(\v2\x1e.autoglm.agent.v1.WatchCommandH\x00R\x05watch\x129\n" +
+	"\x06cancel\x18\x04 \x01(\v2\x1f.autoglm.agent.v1.CancelCommandH\x00R\x06cancel\x126\n" +
+	"\x05pause\x18\x05 \x01(\v2\x1e.autoglm.agent.v1.PauseCommandH\x00R\x05pause\x129\n" +
+	"\x06resume\x18\x06 \x01(\v2\x1f.autoglm.agent.v1.ResumeCommandH\x00R\x06resume\x129\n" +
+	"\x06answer\x18\a \x01(\v2\x1f.autoglm.agent.v1.AnswerCommandH\x00R\x06answerB\t\n" +
+	"\acommand\"`\n" +
+	"\rSubmitCommand\x127\n" +
+	"\x04task\x18\x01 \x01(\v2#.autoglm.agent.v1.SubmitTaskRequestR\x04task\x12\x16\n" +
+	"\x06images\x18\x02 \x01(\bR\x06images\"U\n" +
+	"\fWatchCommand\x12\x17\n" +
+	"\atask_id\x18\x01 \x01(\tR\x06taskId\x12\x14\n" +
+	"\x05after\x18\x02 \x01(\x04R\x05after\x12\x16\n" +
+	"\x06images\x18\x03 \x01(\bR\x06images\"<\n" +
+	"\rCancelCommand\x12\x17\n" +
+	"\atask_id\x18\x01 \x01(\tR\x06taskId\x12\x12\n" +
+	"\x04home\x18\x02 \x01(\bR\x04home\"'\n" +
+	"\fPauseCommand\x12\x17\n" +
+	"\atask_id\x18\x01 \x01(\tR\x06taskId\"(\n" +
+	"\rResumeCommand\x12\x17\n" +
+	"\atask_id\x18\x01 \x01(\tR\x06taskId\"R\n" +
+	"\rAnswerCommand\x12'\n" +
+	"\x0fconfirmation_id\x18\x01 \x01(\tR\x0econfirmationId\x12\x18\n" +
+	"\aapprove\x18\x02 \x01(\bR\aapprove\"\x8d\x01\n" +
+	"\x0fControlResponse\x129\n" +
+	"\x06result\x18\x01 \x01(\v2\x1f.autoglm.agent.v1.ControlResultH\x00R\x06result\x123\n" +
+	"\x05event\x18\x02 \x01(\v2\x1b.autoglm.agent.v1.TaskEventH\x00R\x05eventB\n" +
+	"\n" +
+	"\bresponse\"\x84\x01\n" +
+	"\rControlResult\x12\x1d\n" +
+	"\n" +
+	"request_id\x18\x01 \x01(\tR\trequestId\x12*\n" +
+	"\x04task\x18\x02 \x01(\v2\x16.autoglm.agent.v1.TaskR\x04task\x12\x12\n" +
+	"\x04code\x18\x03 \x01(\x05R\x04code\x12\x14\n" +
+	"\x05error\x18\x04 \x01(\tR\x05error\"\x14\n" +
+	"\x12ListDevicesRequest\"I\n" +
+	"\x13ListDevicesResponse\x122\n" +
+	"\adevices\x18\x01 \x03(\v2\x18.autoglm.agent.v1.DeviceR\adevices\"\xb5\x01\n" +
+	"\x06Device\x12\x1b\n" +
+	"\tdevice_id\x18\x01 \x01(\tR\bdeviceId\x12\x16\n" +
+	"\x06status\x18\x02 \x01(\tR\x06status\x12'\n" +
+	"\x0fconnection_type\x18\x03 \x01(\tR\x0econnectionType\x12\x14\n" +
+	"\x05model\x18\x04 \x01(\tR\x05model\x12'\n" +
+	"\x0fandroid_version\x18\x05 \x01(\tR\x0eandroidVersion\x12\x0e\n" +
+	"\x02os\x18\x06 \x01(\tR\x02os\"\x15\n" +
+	"\x13WatchDevicesRequest\"\xa2\x01\n" +
+	"\vDeviceEvent\x12\x1b\n" +
+	"\tdevice_id\x18\x01 \x01(\tR\bdeviceId\x12\x14\n" +
+	"\x05state\x18\x02 \x01(\tR\x05state\x12\x1a\n" +
+	"\bprevious\x18\x03 \x01(\tR\bprevious\x12.\n" +
+	"\x04time\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12\x14\n" +
+	"\x05error\x18\x05 \x01(\tR\x05error2\xfc\x03\n" +
+	"\fAgentService\x12I\n" +
+	"\n" +
+	"SubmitTask\x12#.autoglm.agent.v1.SubmitTaskRequest\x1a\x16.autoglm.agent.v1.Task\x12C\n" +
+	"\aGetTask\x12 .autoglm.agent.v1.GetTaskRequest\x1a\x16.autoglm.agent.v1.Task\x12T\n" +
+	"\tListTasks\x12\".autoglm.agent.v1.ListTasksRequest\x1a#.autoglm.agent.v1.ListTasksResponse\x12R\n" +
+	"\aControl\x12 .autoglm.agent.v1.ControlRequest\x1a!.autoglm.agent.v1.ControlResponse(\x010\x01\x12Z\n" +
+	"\vListDevices\x12$.autoglm.agent.v1.ListDevicesRequest\x1a%.autoglm.agent.v1.ListDevicesResponse\x12V\n" +
+	"\fWatchDevices\x12%.autoglm.agent.v1.WatchDevicesRequest\x1a\x1d.autoglm.agent.v1.DeviceEvent0\x01B>\n" +
+	"\x14com.autoglm.agent.v1P\x01Z$autoglm-go/phoneagent/server/agentpbb\x06proto3"
+
+var (
+	file_agent_proto_rawDescOnce sync.Once
+	file_agent_proto_rawDescData []byte
+)
+
+func file_agent_proto_rawDescGZIP() []byte {
+	file_agent_proto_rawDescOnce.Do(func() {
+		file_agent_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_agent_proto_rawDesc), len(file_agent_proto_rawDesc)))
+	})
+	return file_agent_proto_rawDescData
+}
+
+var file_agent_proto_msgTypes = make([]protoimpl.MessageInfo, 30)
+var file_agent_proto_goTypes = []any{
+	(*SubmitTaskRequest)(nil),     // 0: autoglm.agent.v1.SubmitTaskRequest
+	(*GetTaskRequest)(nil),        // 1: autoglm.agent.v1.GetTaskRequest
+	(*ListTasksRequest)(nil),      // 2: autoglm.agent.v1.ListTasksRequest
+	(*ListTasksResponse)(nil),     // 3: autoglm.agent.v1.ListTasksResponse
+	(*Task)(nil),                  // 4: autoglm.agent.v1.Task
+	(*Completion)(nil),            // 5: autoglm.agent.v1.Completion
+	(*Usage)(nil),                 // 6: autoglm.agent.v1.Usage
+	(*Outcome)(nil),               // 7: autoglm.agent.v1.Outcome
+	(*Step)(nil),                  // 8: autoglm.agent.v1.Step
+	(*Action)(nil),                // 9: autoglm.agent.v1.Action
+	(*Confirmation)(nil),          // 10: autoglm.agent.v1.Confirmation
+	(*TaskEvent)(nil),             // 11: autoglm.agent.v1.TaskEvent
+	(*ControlRequest)(nil),        // 12: autoglm.agent.v1.ControlRequest
+	(*SubmitCommand)(nil),         // 13: autoglm.agent.v1.SubmitCommand
+	(*WatchCommand)(nil),          // 14: autoglm.agent.v1.WatchCommand
+	(*CancelCommand)(nil),         // 15: autoglm.agent.v1.CancelCommand
+	(*PauseCommand)(nil),          // 16: autoglm.agent.v1.PauseCommand
+	(*ResumeCommand)(nil),         // 17: autoglm.agent.v1.ResumeCommand
+	(*AnswerCommand)(nil),         // 18: autoglm.agent.v1.AnswerCommand
+	(*ControlResponse)(nil),       // 19: autoglm.agent.v1.ControlResponse
+	(*ControlResult)(nil),         // 20: autoglm.agent.v1.ControlResult
+	(*ListDevicesRequest)(nil),    // 21: autoglm.agent.v1.ListDevicesRequest
+	(*ListDevicesResponse)(nil),   // 22: autoglm.agent.v1.ListDevicesResponse
+	(*Device)(nil),                // 23: autoglm.agent.v1.Device
+	(*WatchDevicesRequest)(nil),   // 24: autoglm.agent.v1.WatchDevicesRequest
+	(*DeviceEvent)(nil),           // 25: autoglm.agent.v1.DeviceEvent
+	nil,                           // 26: autoglm.agent.v1.SubmitTaskRequest.LabelsEntry
+	nil,                           // 27: autoglm.agent.v1.SubmitTaskRequest.OutputSchemaEntry
+	nil,                           // 28: autoglm.agent.v1.Task.LabelsEntry
+	nil,                           // 29: autoglm.agent.v1.Confirmation.LabelsEntry
+	(*structpb.Struct)(nil),       // 30: google.protobuf.Struct
+	(*timestamppb.Timestamp)(nil), // 31: google.protobuf.Timestamp
+}
+var file_agent_proto_depIdxs = []int32{
+	26, // 0: autoglm.agent.v1.SubmitTaskRequest.labels:type_name -> autoglm.agent.v1.SubmitTaskRequest.LabelsEntry
+	27, // 1: autoglm.agent.v1.SubmitTaskRequest.output_schema:type_name -> autoglm.agent.v1.SubmitTaskRequest.OutputSchemaEntry
+	4,  // 2: autoglm.agent.v1.ListTasksResponse.tasks:type_name -> autoglm.agent.v1.Task
+	28, // 3: autoglm.agent.v1.Task.labels:type_name -> autoglm.agent.v1.Task.LabelsEntry
+	30, // 4: autoglm.agent.v1.Task.output:type_name -> google.protobuf.Struct
+	8,  // 5: autoglm.agent.v1.Task.steps:type_name -> autoglm.agent.v1.Step
+	6,  // 6: autoglm.agent.v1.Task.usage:type_name -> autoglm.agent.v1.Usage
+	31, // 7: autoglm.agent.v1.Task.submitted_at:type_name -> google.protobuf.Timestamp
+	31, // 8: autoglm.agent.v1.Task.started_at:type_name -> google.protobuf.Timestamp
+	31, // 9: autoglm.agent.v1.Task.finished_at:type_name -> google.protobuf.Timestamp
+	10, // 10: autoglm.agent.v1.Task.confirmation:type_name -> autoglm.agent.v1.Confirmation
+	5,  // 11: autoglm.agent.v1.Task.completion:type_name -> autoglm.agent.v1.Completion
+	7,  // 12: autoglm.agent.v1.Task.outcome:type_name -> autoglm.agent.v1.Outcome
+	9,  // 13: autoglm.agent.v1.Step.action:type_name -> autoglm.agent.v1.Action
+	31, // 14: autoglm.agent.v1.Step.at:type_name -> google.protobuf.Timestamp
+	30, // 15: autoglm.agent.v1.Action.args:type_name -> google.protobuf.Struct
+	29, // 16: autoglm.agent.v1.Confirmation.labels:type_name -> autoglm.agent.v1.Confirmation.LabelsEntry
+	31, // 17: autoglm.agent.v1.Confirmation.at:type_name -> google.protobuf.Timestamp
+	31, // 18: autoglm.agent.v1.Confirmation.deadline:type_name -> google.protobuf.Timestamp
+	31, // 19: autoglm.agent.v1.TaskEvent.at:type_name -> google.protobuf.Timestamp
+	9,  // 20: autoglm.agent.v1.TaskEvent.action:type_name -> autoglm.agent.v1.Action
+	4,  // 21: autoglm.agent.v1.TaskEvent.task:type_name -> autoglm.agent.v1.Task
+	10, // 22: autoglm.agent.v1.TaskEvent.confirmation:type_name -> autoglm.agent.v1.Confirmation
+	13, // 23: autoglm.agent.v1.ControlRequest.submit:type_name -> autoglm.agent.v1.SubmitCommand
+	14, // 24: autoglm.agent.v1.ControlRequest.watch:type_name -> autoglm.agent.v1.WatchCommand
+	15, // 25: autoglm.agent.v1.ControlRequest.cancel:type_name -> autoglm.agent.v1.CancelCommand
+	16, // 26: autoglm.agent.v1.ControlRequest.pause:type_name -> autoglm.agent.v1.PauseCommand
+	17, // 27: autoglm.agent.v1.ControlRequest.resume:type_name -> autoglm.agent.v1.ResumeCommand
+	18, // 28: autoglm.agent.v1.ControlRequest.answer:type_name -> autoglm.agent.v1.AnswerCommand
+	0,  // 29: autoglm.agent.v1.SubmitCommand.task:type_name -> autoglm.agent.v1.SubmitTaskRequest
+	20, // 30: autoglm.agent.v1.ControlResponse.result:type_name -> autoglm.agent.v1.ControlResult
+	11, // 31: autoglm.agent.v1.ControlResponse.event:type_name -> autoglm.agent.v1.TaskEvent
+	4,  // 32: autoglm.agent.v1.ControlResult.task:type_name -> autoglm.agent.v1.Task
+	23, // 33: autoglm.agent.v1.ListDevicesResponse.devices:type_name -> autoglm.agent.v1.Device
+	31, // 34: autoglm.agent.v1.DeviceEvent.time:type_name -> google.protobuf.Timestamp
+	0,  // 35: autoglm.agent.v1.AgentService.SubmitTask:input_type -> autoglm.agent.v1.SubmitTaskRequest
+	1,  // 36: autoglm.agent.v1.AgentService.GetTask:input_type -> autoglm.agent.v1.GetTaskRequest
+	2,  // 37: autoglm.agent.v1.AgentService.ListTasks:input_type -> autoglm.agent.v1.ListTasksRequest
+	12, // 38: autoglm.agent.v1.AgentService.Control:input_type -> autoglm.agent.v1.ControlRequest
+	21, // 39: autoglm.agent.v1.AgentService.ListDevices:input_type -> autoglm.agent.v1.ListDevicesRequest
+	24, // 40: autoglm.agent.v1.AgentService.WatchDevices:input_type -> autoglm.agent.v1.WatchDevicesRequest
+	4,  // 41: autoglm.agent.v1.AgentService.SubmitTask:output_type -> autoglm.agent.v1.Task
+	4,  // 42: autoglm.agent.v1.AgentService.GetTask:output_type -> autoglm.agent.v1.Task
+	3,  // 43: autoglm.agent.v1.AgentService.ListTasks:output_type -> autoglm.agent.v1.ListTasksResponse
+	19, // 44: autoglm.agent.v1.AgentService.Control:output_type -> autoglm.agent.v1.ControlResponse
+	22, // 45: autoglm.agent.v1.AgentService.ListDevices:output_type -> autoglm.agent.v1.ListDevicesResponse
+	25, // 46: autoglm.agent.v1.AgentService.WatchDevices:output_type -> autoglm.agent.v1.DeviceEvent
+	41, // [41:47] is the sub-list for method output_type
+	35, // [35:41] is the sub-list for method input_type
+	35, // [35:35] is the sub-list for extension type_name
+	35, // [35:35] is the sub-list for extension extendee
+	0,  // [0:35] is the sub-list for field type_name
+}
+
+func init() { file_agent_proto_init() }
+func file_agent_proto_init() {
+	if File_agent_proto != nil {
+		return
+	}
+	file_agent_proto_msgTypes[12].OneofWrappers = []any{
+		(*ControlRequest_Submit)(nil),
+		(*ControlRequest_Watch)(nil),
+		(*ControlRequest_Cancel)(nil),
+		(*ControlRequest_Pause)(nil),
+		(*ControlRequest_Resume)(nil),
+		(*ControlRequest_Answer)(nil),
+	}
+	file_agent_proto_msgTypes[19].OneofWrappers = []any{
+		(*ControlResponse_Result)(nil),
+		(*ControlResponse_Event)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_agent_proto_rawDesc), len(file_agent_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   30,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_agent_proto_goTypes,
+		DependencyIndexes: file_agent_proto_depIdxs,
+		MessageInfos:      file_agent_proto_msgTypes,
+	}.Build()
+	File_agent_proto = out.File
+	file_agent_proto_goTypes = nil
+	file_agent_proto_depIdxs = nil
+}
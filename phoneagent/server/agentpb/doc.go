@@ -0,0 +1,5 @@
+// Package agentpb holds the protobuf messages and the gRPC service of the
+// task API, generated from agent.proto.
+package agentpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative agent.proto
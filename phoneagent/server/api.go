@@ -0,0 +1,455 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"autoglm-go/phoneagent"
+	"autoglm-go/phoneagent/artifact"
+	"autoglm-go/phoneagent/definitions"
+	"autoglm-go/phoneagent/health"
+	"autoglm-go/phoneagent/metrics"
+	"autoglm-go/phoneagent/session"
+	"autoglm-go/phoneagent/setup"
+)
+
+// Lister lists the devices tasks can be submitted to.
+type Lister interface {
+	ListDevices(ctx context.Context) ([]definitions.DeviceInfo, error)
+}
+
+// TenantLister reports the tenants and what they use, implemented by
+// session.Manager.
+type TenantLister interface {
+	Tenants() []session.TenantStatus
+}
+
+// DeviceView is a device of GET /api/devices.
+type DeviceView struct {
+	definitions.DeviceInfo
+	Setup  *setup.Report  `json:"setup,omitempty"`  // the last, absent before the device was set up
+	Health *health.Status `json:"health,omitempty"` // of the heartbeats, absent without them
+}
+
+// Handler serves the task API.
+//
+//	POST /api/tasks              submit a TaskRequest, 202 when queued, 200 for a task already kept
+//	GET  /api/tasks              tasks without their steps, newest first, ?device_id= filters
+//	GET  /api/tasks/{id}         status, result and steps of a task
+//	GET  /api/tasks/{id}/events  server-sent events of a task as it runs, see Event and ParseBandwidth
+//	POST /api/tasks/{id}/cancel  cancel a queued or running task, {"home": true} also goes to the home screen
+//	POST /api/tasks/{id}/pause   pause a running task once its current step is done
+//	POST /api/tasks/{id}/resume  resume a paused task
+//	POST /api/tasks/{id}/share   an expiring link to a read-only live view of a task, see ShareRequest
+//	GET  /api/devices            devices and their state, with the report of their setup profile and their health
+//	GET  /api/devices/events     server-sent events of the devices going online, offline, unauthorized...
+//	POST /api/devices/{id}/setup verify and apply the setup profile of a device now
+//	GET  /api/tenants            tenants, their device pool, running tasks and tokens used today
+//
+//	GET  /api/confirmations       sensitive actions and takeovers waiting for an answer, oldest first
+//	POST /api/confirmations/{id}  answer one with a ConfirmationAnswer
+//
+//	POST /api/pipelines              submit a PipelineRequest, 202 once validated
+//	POST /api/pipelines/parallel     split a ParallelRequest into parts run at the same time, 202 once split
+//	GET  /api/pipelines              pipelines and the status of their nodes, newest first
+//	GET  /api/pipelines/{id}         a pipeline, with the task, outputs and status of every node
+//	POST /api/pipelines/{id}/cancel  cancel the running tasks of a pipeline and the nodes not started
+//
+//	POST   /api/schedules           create a schedule from a ScheduleRequest
+//	GET    /api/schedules           schedules and their next run, without their runs
+//	GET    /api/schedules/{id}      a schedule and the tasks of its last runs
+//	PUT    /api/schedules/{id}      replace a schedule with a ScheduleRequest, e.g. to pause it
+//	DELETE /api/schedules/{id}      delete a schedule
+//	POST   /api/schedules/{id}/run  run a schedule now
+//
+//	GET  /share/{token}         the live view of a share link, /task and /events below it are those of its task
+//
+//	GET  /artifacts/{key}  an artifact of a local artifact store, by the signed URL of the store
+//
+//	GET  /metrics  Prometheus metrics of the models, steps, actions and tasks
+//
+// Wrapped by an Auth, the requests need an API key whose role allows them:
+// the tasks, pipelines and schedules of other devices are left out or
+// forbidden, see Role.
+//
+// Responses are gzipped for the clients that accept it.
+func Handler(tasks *Tasks, pipelines *Pipelines, schedules *Schedules, shares *Shares, submitter Submitter, lister Lister, setups *setup.Watcher, monitor *health.Monitor) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/tasks", func(w http.ResponseWriter, req *http.Request) {
+		var body TaskRequest
+		if !readJSON(w, req, &body) {
+			return
+		}
+		if key := req.Header.Get("Idempotency-Key"); key != "" {
+			body.IdempotencyKey = key
+		}
+		if tenant := tenantOf(body.Tenant, body.Labels); !submitAllowed(req.Context(), tenant, body.DeviceID) {
+			forbidden(w, "the api key may not target device %q of tenant %q", body.DeviceID, tenant)
+			return
+		}
+		if observer(req.Context()) {
+			body.ReadOnly = true
+		}
+		view, created, err := tasks.Submit(submitter, body)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if entry := auditOf(req.Context()); entry != nil {
+			entry.TaskID, entry.DeviceID, entry.Instruction, entry.ReadOnly = view.ID, view.DeviceID, view.Instruction, view.ReadOnly
+		}
+		status := http.StatusOK
+		if created {
+			status = http.StatusAccepted
+		}
+		writeJSON(w, status, view)
+	})
+	mux.HandleFunc("GET /api/tasks", func(w http.ResponseWriter, req *http.Request) {
+		views := tasks.List(req.URL.Query().Get("device_id"))
+		if restricted(req.Context()) {
+			views = slices.DeleteFunc(views, func(view TaskView) bool { return !allowed(req.Context(), taskTarget(view)) })
+		}
+		writeJSON(w, http.StatusOK, views)
+	})
+	// the tasks of other devices are not found for a restricted api key
+	mux.HandleFunc("GET /api/tasks/{id}", func(w http.ResponseWriter, req *http.Request) {
+		view, ok := tasks.Get(req.PathValue("id"))
+		if !ok || !allowed(req.Context(), taskTarget(view)) {
+			http.Error(w, "task not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, view)
+	})
+	mux.HandleFunc("GET /api/tasks/{id}/events", func(w http.ResponseWriter, req *http.Request) {
+		if !taskAllowed(w, req, tasks) {
+			return
+		}
+		serveEvents(w, req, tasks, req.PathValue("id"), req.URL.Query().Get("images") != "false", time.Time{})
+	})
+	mux.HandleFunc("POST /api/tasks/{id}/cancel", func(w http.ResponseWriter, req *http.Request) {
+		var opts phoneagent.CancelOptions
+		// the body is optional
+		if req.ContentLength != 0 && !readJSON(w, req, &opts) {
+			return
+		}
+		if !taskAllowed(w, req, tasks) {
+			return
+		}
+		if err := tasks.Cancel(req.PathValue("id"), opts); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST /api/tasks/{id}/pause", func(w http.ResponseWriter, req *http.Request) {
+		if !taskAllowed(w, req, tasks) {
+			return
+		}
+		if err := tasks.Pause(req.PathValue("id")); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST /api/tasks/{id}/resume", func(w http.ResponseWriter, req *http.Request) {
+		if !taskAllowed(w, req, tasks) {
+			return
+		}
+		if err := tasks.Resume(req.PathValue("id")); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /api/devices", func(w http.ResponseWriter, req *http.Request) {
+		devices, err := lister.ListDevices(req.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		views := []DeviceView{}
+		for _, info := range devices {
+			if !allowed(req.Context(), target{device: info.DeviceID}) {
+				continue
+			}
+			view := DeviceView{DeviceInfo: info}
+			if report, ok := setups.Report(info.DeviceID); ok {
+				view.Setup = &report
+			}
+			if monitor != nil {
+				if status, ok := monitor.Status(info.DeviceID); ok {
+					view.Health = &status
+				}
+			}
+			views = append(views, view)
+		}
+		writeJSON(w, http.StatusOK, views)
+	})
+	mux.HandleFunc("GET /api/devices/events", func(w http.ResponseWriter, req *http.Request) {
+		if monitor == nil {
+			writeError(w, fmt.Errorf("%w: no device heartbeat", ErrNotSupported))
+			return
+		}
+		if restricted(req.Context()) {
+			forbidden(w, "the events of every device need an api key targeting every device")
+			return
+		}
+		serveDeviceEvents(w, req, monitor)
+	})
+	mux.HandleFunc("POST /api/devices/{id}/setup", func(w http.ResponseWriter, req *http.Request) {
+		if setups == nil {
+			writeError(w, fmt.Errorf("%w: no setup profiles", ErrNotSupported))
+			return
+		}
+		if !allowed(req.Context(), target{device: req.PathValue("id")}) {
+			forbidden(w, "the api key may not target device %q", req.PathValue("id"))
+			return
+		}
+		report, err := setups.Apply(req.Context(), req.PathValue("id"))
+		switch {
+		case report.DeviceID != "":
+			// a failed check is in the report
+			writeJSON(w, http.StatusOK, report)
+		case errors.Is(err, setup.ErrNoProfile):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, err.Error(), http.StatusConflict)
+		}
+	})
+
+	mux.HandleFunc("GET /api/tenants", func(w http.ResponseWriter, req *http.Request) {
+		lister, ok := submitter.(TenantLister)
+		if !ok {
+			writeError(w, fmt.Errorf("listing tenants is %w", ErrNotSupported))
+			return
+		}
+		statuses := lister.Tenants()
+		if restricted(req.Context()) {
+			statuses = slices.DeleteFunc(statuses, func(status session.TenantStatus) bool {
+				return !allowed(req.Context(), target{tenant: status.Name})
+			})
+		}
+		writeJSON(w, http.StatusOK, statuses)
+	})
+
+	mux.HandleFunc("GET /api/confirmations", func(w http.ResponseWriter, req *http.Request) {
+		confirmations := tasks.Confirmations()
+		if restricted(req.Context()) {
+			confirmations = slices.DeleteFunc(confirmations, func(c phoneagent.ConfirmRequest) bool {
+				return !allowed(req.Context(), target{device: c.DeviceID, tenant: c.Labels[session.TenantLabel]})
+			})
+		}
+		writeJSON(w, http.StatusOK, confirmations)
+	})
+	mux.HandleFunc("POST /api/confirmations/{id}", func(w http.ResponseWriter, req *http.Request) {
+		var body ConfirmationAnswer
+		if !readJSON(w, req, &body) {
+			return
+		}
+		if restricted(req.Context()) {
+			i := slices.IndexFunc(tasks.Confirmations(), func(c phoneagent.ConfirmRequest) bool {
+				return c.ID == req.PathValue("id") && allowed(req.Context(), target{device: c.DeviceID, tenant: c.Labels[session.TenantLabel]})
+			})
+			if i < 0 {
+				writeError(w, fmt.Errorf("confirmation %w", ErrNotFound))
+				return
+			}
+		}
+		if err := tasks.Answer(req.PathValue("id"), body.Approve); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST /api/pipelines", func(w http.ResponseWriter, req *http.Request) {
+		var body PipelineRequest
+		if !readJSON(w, req, &body) {
+			return
+		}
+		devices := make([]string, 0, len(body.Nodes))
+		for _, node := range body.Nodes {
+			devices = append(devices, node.DeviceID)
+		}
+		if !submitAllowed(req.Context(), tenantOf(body.Tenant, body.Labels), devices...) {
+			forbidden(w, "the api key may not target every device of the pipeline")
+			return
+		}
+		view, err := pipelines.Submit(body)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, view)
+	})
+	mux.HandleFunc("POST /api/pipelines/parallel", func(w http.ResponseWriter, req *http.Request) {
+		var body ParallelRequest
+		if !readJSON(w, req, &body) {
+			return
+		}
+		tenant := tenantOf(body.Tenant, body.Labels)
+		if !allowed(req.Context(), target{group: body.Group, tenant: tenant}) || !submitAllowed(req.Context(), tenant) {
+			forbidden(w, "the api key may not target the devices of the parallel pipeline")
+			return
+		}
+		view, err := pipelines.Parallel(req.Context(), body)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, view)
+	})
+	mux.HandleFunc("GET /api/pipelines", func(w http.ResponseWriter, req *http.Request) {
+		views := pipelines.List()
+		if restricted(req.Context()) {
+			views = slices.DeleteFunc(views, func(view PipelineView) bool { return !viewAllowed(req.Context(), view) })
+		}
+		writeJSON(w, http.StatusOK, views)
+	})
+	mux.HandleFunc("GET /api/pipelines/{id}", func(w http.ResponseWriter, req *http.Request) {
+		view, ok := pipelines.Get(req.PathValue("id"))
+		if !ok || !viewAllowed(req.Context(), view) {
+			http.Error(w, "pipeline not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, view)
+	})
+	mux.HandleFunc("POST /api/pipelines/{id}/cancel", func(w http.ResponseWriter, req *http.Request) {
+		if view, ok := pipelines.Get(req.PathValue("id")); ok && !viewAllowed(req.Context(), view) {
+			forbidden(w, "the api key may not target every device of the pipeline")
+			return
+		}
+		if err := pipelines.Cancel(req.PathValue("id")); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("POST /api/schedules", func(w http.ResponseWriter, req *http.Request) {
+		var body ScheduleRequest
+		if !readJSON(w, req, &body) {
+			return
+		}
+		if !allowed(req.Context(), scheduleTarget(body)) {
+			forbidden(w, "the api key may not target the devices of the schedule")
+			return
+		}
+		view, err := schedules.Create(body)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, view)
+	})
+	mux.HandleFunc("GET /api/schedules", func(w http.ResponseWriter, req *http.Request) {
+		views := schedules.List()
+		if restricted(req.Context()) {
+			views = slices.DeleteFunc(views, func(view ScheduleView) bool { return !allowed(req.Context(), scheduleTarget(view.ScheduleRequest)) })
+		}
+		writeJSON(w, http.StatusOK, views)
+	})
+	mux.HandleFunc("GET /api/schedules/{id}", func(w http.ResponseWriter, req *http.Request) {
+		view, ok := schedules.Get(req.PathValue("id"))
+		if !ok || !allowed(req.Context(), scheduleTarget(view.ScheduleRequest)) {
+			http.Error(w, "schedule not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, view)
+	})
+	mux.HandleFunc("PUT /api/schedules/{id}", func(w http.ResponseWriter, req *http.Request) {
+		var body ScheduleRequest
+		if !readJSON(w, req, &body) {
+			return
+		}
+		if !scheduleAllowed(w, req, schedules) {
+			return
+		}
+		if !allowed(req.Context(), scheduleTarget(body)) {
+			forbidden(w, "the api key may not target the devices of the schedule")
+			return
+		}
+		view, err := schedules.Update(req.PathValue("id"), body)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, view)
+	})
+	mux.HandleFunc("DELETE /api/schedules/{id}", func(w http.ResponseWriter, req *http.Request) {
+		if !scheduleAllowed(w, req, schedules) {
+			return
+		}
+		if err := schedules.Delete(req.PathValue("id")); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST /api/schedules/{id}/run", func(w http.ResponseWriter, req *http.Request) {
+		if !scheduleAllowed(w, req, schedules) {
+			return
+		}
+		run, err := schedules.RunNow(req.PathValue("id"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, run)
+	})
+
+	handleShares(mux, tasks, shares)
+
+	if local, ok := artifact.Current().(*artifact.Local); ok {
+		mux.Handle("GET "+local.Path()+"/", local.Handler())
+	}
+
+	mux.Handle("GET /metrics", metrics.Handler())
+	return compress(mux)
+}
+
+func readJSON(w http.ResponseWriter, req *http.Request, v any) bool {
+	if err := json.NewDecoder(req.Body).Decode(v); err != nil {
+		http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	http.Error(w, err.Error(), errorStatus(err))
+}
+
+// errorStatus is the HTTP status of err, 400 for the errors of the request.
+func errorStatus(err error) int {
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrFinished), errors.Is(err, session.ErrIdempotencyConflict), errors.Is(err, session.ErrNotRunning):
+		status = http.StatusConflict
+	case errors.Is(err, ErrUnauthorized):
+		status = http.StatusUnauthorized
+	case errors.Is(err, ErrForbidden), errors.Is(err, session.ErrUnknownTenant), errors.Is(err, session.ErrNotInPool):
+		status = http.StatusForbidden
+	case errors.Is(err, session.ErrDeviceOffline):
+		status = http.StatusUnprocessableEntity
+	case errors.Is(err, session.ErrQuotaExceeded):
+		status = http.StatusTooManyRequests
+	case errors.Is(err, session.ErrManagerClosed):
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, ErrNotSupported):
+		status = http.StatusNotImplemented
+	}
+	return status
+}
@@ -0,0 +1,529 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"autoglm-go/phoneagent/artifact"
+	"autoglm-go/phoneagent/labels"
+	"autoglm-go/phoneagent/session"
+	logs "github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrUnauthorized is returned for requests without a known API key.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden is returned when the role of the API key does not allow
+	// the request.
+	ErrForbidden = errors.New("forbidden")
+)
+
+// Access is what the API keys of a role may do, each access allows what the
+// ones before do.
+type Access int
+
+const (
+	// AccessObserve reads tasks, devices, pipelines and schedules, and
+	// submits tasks that run read-only, see TaskRequest.ReadOnly.
+	AccessObserve Access = iota + 1
+	// AccessControl submits, cancels, pauses and answers tasks, pipelines
+	// and schedules.
+	AccessControl
+	// AccessAdmin also sets devices up.
+	AccessAdmin
+)
+
+var accessNames = []string{AccessObserve: "observe", AccessControl: "control", AccessAdmin: "admin"}
+
+func (a Access) String() string {
+	if a <= 0 || int(a) >= len(accessNames) {
+		return fmt.Sprintf("Access(%d)", int(a))
+	}
+	return accessNames[a]
+}
+
+func (a *Access) UnmarshalText(text []byte) error {
+	i := slices.Index(accessNames, string(text))
+	if i <= 0 {
+		return fmt.Errorf("unknown access %q, want observe, control or admin", text)
+	}
+	*a = Access(i)
+	return nil
+}
+
+func (a Access) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// Role is what the API keys of a client may target and do. A role without
+// Devices, Groups and Tenants targets every device.
+type Role struct {
+	Name   string `json:"name"`
+	Access Access `json:"access"`
+	// Devices are the device ids the role targets, as path.Match patterns,
+	// e.g. "emulator-*".
+	Devices []string `json:"devices,omitempty"`
+	// Groups are the device groups the schedules of the role run on.
+	Groups []string `json:"groups,omitempty"`
+	// Tenants are the tenants the role submits tasks for: their devices are
+	// those of the pool of the tenant, see session.Options.Tenants.
+	Tenants []string `json:"tenants,omitempty"`
+}
+
+// Pools tells the devices of the pools of the tenants, implemented by
+// session.Manager.
+type Pools interface {
+	InPool(tenant, deviceID string) bool
+}
+
+// target is what a request operates on, any of it may be empty.
+type target struct {
+	device, group, tenant string
+}
+
+// allows reports whether the role may operate on t: a device it lists or one
+// in the pool of a tenant it lists, as pools tells, a group it lists or a
+// tenant it lists.
+func (r *Role) allows(t target, pools Pools) bool {
+	if len(r.Devices) == 0 && len(r.Groups) == 0 && len(r.Tenants) == 0 {
+		return true
+	}
+	inPool := func(tenant string) bool {
+		return pools != nil && pools.InPool(tenant, t.device)
+	}
+	switch {
+	case t.device != "":
+		if slices.ContainsFunc(r.Devices, func(pattern string) bool {
+			ok, _ := path.Match(pattern, t.device)
+			return ok
+		}) {
+			return true
+		}
+		if t.tenant != "" {
+			return slices.Contains(r.Tenants, t.tenant) && inPool(t.tenant)
+		}
+		return slices.ContainsFunc(r.Tenants, inPool)
+	case t.group != "":
+		return slices.Contains(r.Groups, t.group)
+	default:
+		return t.tenant != "" && slices.Contains(r.Tenants, t.tenant)
+	}
+}
+
+// APIKey is a key of a client. SHA256, the hex SHA-256 of the key, keeps the
+// key itself out of the file.
+type APIKey struct {
+	Key    string `json:"key,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	Client string `json:"client"`
+	Role   string `json:"role"`
+}
+
+// AuthConfig is the file of LoadAuth.
+//
+//	{
+//	  "roles": [
+//	    {"name": "ops", "access": "admin"},
+//	    {"name": "qa", "access": "control", "devices": ["emulator-*"], "tenants": ["qa"]},
+//	    {"name": "viewer", "access": "observe"}
+//	  ],
+//	  "keys": [
+//	    {"client": "ci", "role": "qa", "sha256": "9f86d0…"},
+//	    {"client": "dashboard", "role": "viewer", "key": "…"}
+//	  ]
+//	}
+type AuthConfig struct {
+	Roles []Role   `json:"roles"`
+	Keys  []APIKey `json:"keys"`
+}
+
+// Client is the holder of an API key.
+type Client struct {
+	Name  string
+	Role  *Role
+	pools Pools
+}
+
+type clientKey struct{}
+
+func clientOf(ctx context.Context) *Client {
+	client, _ := ctx.Value(clientKey{}).(*Client)
+	return client
+}
+
+// allowed reports whether the client of ctx may operate on t, any request
+// may without Auth.
+func allowed(ctx context.Context, t target) bool {
+	client := clientOf(ctx)
+	return client == nil || client.Role.allows(t, client.pools)
+}
+
+// observer reports whether the client of ctx may only observe.
+func observer(ctx context.Context) bool {
+	client := clientOf(ctx)
+	return client != nil && client.Role.Access < AccessControl
+}
+
+// restricted reports whether the role of the client of ctx does not target
+// every device.
+func restricted(ctx context.Context) bool {
+	client := clientOf(ctx)
+	return client != nil && !client.Role.allows(target{}, nil)
+}
+
+func forbidden(w http.ResponseWriter, format string, args ...any) {
+	writeError(w, fmt.Errorf("%w: "+format, append([]any{ErrForbidden}, args...)...))
+}
+
+// Auth authenticates the requests of Handler with the API keys of
+// AuthConfig, in the Authorization: Bearer or X-API-Key header, and
+// authorizes them by the role of the key. Share links carry their own token.
+type Auth struct {
+	clients map[[sha256.Size]byte]*Client
+	audit   *Audit
+}
+
+// LoadAuth reads the AuthConfig at path. Requests are written to audit, nil
+// logs them only.
+func LoadAuth(filename string, audit *Audit) (*Auth, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read api keys: %w", err)
+	}
+	var config AuthConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid api keys %s: %w", filename, err)
+	}
+	auth, err := NewAuth(config, audit)
+	if err != nil {
+		return nil, fmt.Errorf("invalid api keys %s: %w", filename, err)
+	}
+	return auth, nil
+}
+
+// NewAuth checks config and returns its Auth.
+func NewAuth(config AuthConfig, audit *Audit) (*Auth, error) {
+	roles := map[string]*Role{}
+	for i := range config.Roles {
+		role := &config.Roles[i]
+		if role.Name == "" || roles[role.Name] != nil {
+			return nil, fmt.Errorf("role %d: missing or duplicate name %q", i+1, role.Name)
+		}
+		if role.Access == 0 {
+			return nil, fmt.Errorf("role %s: access is required", role.Name)
+		}
+		for _, pattern := range role.Devices {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("role %s: device %q: %w", role.Name, pattern, err)
+			}
+		}
+		roles[role.Name] = role
+	}
+	auth := &Auth{clients: map[[sha256.Size]byte]*Client{}, audit: audit}
+	for i, key := range config.Keys {
+		if key.Client == "" {
+			return nil, fmt.Errorf("key %d: client is required", i+1)
+		}
+		role, ok := roles[key.Role]
+		if !ok {
+			return nil, fmt.Errorf("key of %s: unknown role %q", key.Client, key.Role)
+		}
+		var sum [sha256.Size]byte
+		switch {
+		case key.Key != "" && key.SHA256 == "":
+			sum = sha256.Sum256([]byte(key.Key))
+		case key.SHA256 != "" && key.Key == "":
+			decoded, err := hex.DecodeString(key.SHA256)
+			if err != nil || len(decoded) != sha256.Size {
+				return nil, fmt.Errorf("key of %s: sha256 is not a hex SHA-256", key.Client)
+			}
+			copy(sum[:], decoded)
+		default:
+			return nil, fmt.Errorf("key of %s: either key or sha256 is required", key.Client)
+		}
+		if _, ok := auth.clients[sum]; ok {
+			return nil, fmt.Errorf("key of %s: duplicate key", key.Client)
+		}
+		auth.clients[sum] = &Client{Name: key.Client, Role: role}
+	}
+	if len(auth.clients) == 0 {
+		return nil, fmt.Errorf("no api keys")
+	}
+	return auth, nil
+}
+
+// UsePools lets the roles of tenants see and target the devices of their
+// pools.
+func (r *Auth) UsePools(pools Pools) {
+	for _, client := range r.clients {
+		client.pools = pools
+	}
+}
+
+// requiredAccess is the access a request needs.
+func requiredAccess(req *http.Request) Access {
+	switch {
+	case req.Method == http.MethodGet || req.Method == http.MethodHead:
+		return AccessObserve
+	case req.Method == http.MethodPost && req.URL.Path == "/api/tasks":
+		// the tasks of an observer run read-only
+		return AccessObserve
+	case req.Method == http.MethodPost && strings.HasPrefix(req.URL.Path, "/api/devices/") && strings.HasSuffix(req.URL.Path, "/setup"):
+		return AccessAdmin
+	default:
+		return AccessControl
+	}
+}
+
+// Wrap returns h serving only the requests of known API keys, whose role
+// allows them. The requests changing anything and the ones refused are
+// audited. A nil Auth returns h.
+func (r *Auth) Wrap(h http.Handler) http.Handler {
+	if r == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// share links and artifact URLs carry their own signed token
+		if strings.HasPrefix(req.URL.Path, "/share/") || isArtifact(req.URL.Path) {
+			h.ServeHTTP(w, req)
+			return
+		}
+		entry := &AuditEntry{
+			At:     time.Now(),
+			Remote: req.RemoteAddr,
+			Method: req.Method,
+			Path:   req.URL.Path,
+		}
+		client := r.client(req)
+		if client == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="phone-agent"`)
+			writeError(w, fmt.Errorf("%w: missing or unknown api key", ErrUnauthorized))
+			entry.Status = http.StatusUnauthorized
+			r.audit.Write(entry)
+			return
+		}
+		entry.Client, entry.Role = client.Name, client.Role.Name
+		if need := requiredAccess(req); client.Role.Access < need {
+			forbidden(w, "%s needs %s access, role %s has %s", req.URL.Path, need, client.Role.Name, client.Role.Access)
+			entry.Status = http.StatusForbidden
+			r.audit.Write(entry)
+			return
+		}
+		ctx := context.WithValue(req.Context(), clientKey{}, client)
+		if req.Method == http.MethodGet || req.Method == http.MethodHead {
+			h.ServeHTTP(w, req.WithContext(ctx))
+			return
+		}
+		ctx = context.WithValue(ctx, auditKey{}, entry)
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, req.WithContext(ctx))
+		entry.Status = sw.status
+		r.audit.Write(entry)
+	})
+}
+
+func (r *Auth) client(req *http.Request) *Client {
+	return r.lookup(req.Header.Get("X-API-Key"), req.Header.Get("Authorization"))
+}
+
+// lookup returns the client of the API key of an X-API-Key or Authorization:
+// Bearer header, nil when unknown.
+func (r *Auth) lookup(apiKey, authorization string) *Client {
+	key := apiKey
+	if scheme, token, ok := strings.Cut(authorization, " "); ok && strings.EqualFold(scheme, "Bearer") {
+		key = strings.TrimSpace(token)
+	}
+	if key == "" {
+		return nil
+	}
+	return r.clients[sha256.Sum256([]byte(key))]
+}
+
+// statusWriter keeps the status of the response for the audit.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// AuditEntry is a request of the audit trail: who did what, and what came of
+// it.
+type AuditEntry struct {
+	At     time.Time `json:"at"`
+	Client string    `json:"client,omitempty"` // empty without a known key
+	Role   string    `json:"role,omitempty"`
+	Remote string    `json:"remote"`
+	Method string    `json:"method"`
+	Path   string    `json:"path"`
+	Status int       `json:"status"`
+	// TaskID, DeviceID and Instruction are those of a submitted task.
+	TaskID      string `json:"task_id,omitempty"`
+	DeviceID    string `json:"device_id,omitempty"`
+	Instruction string `json:"instruction,omitempty"`
+	ReadOnly    bool   `json:"read_only,omitempty"`
+}
+
+type auditKey struct{}
+
+// auditOf returns the entry of the request of ctx, for the handler to
+// complete, nil when it is not audited.
+func auditOf(ctx context.Context) *AuditEntry {
+	entry, _ := ctx.Value(auditKey{}).(*AuditEntry)
+	return entry
+}
+
+// Audit appends the requests of Auth to a JSON lines file.
+type Audit struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// OpenAudit opens the audit trail at filename, created if needed.
+func OpenAudit(filename string) (*Audit, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit trail: %w", err)
+	}
+	return &Audit{file: file}, nil
+}
+
+// Write logs entry and appends it to the file, if any.
+func (r *Audit) Write(entry *AuditEntry) {
+	client := entry.Client
+	if client == "" {
+		client = "anonymous " + entry.Remote
+	}
+	log := logs.Infof
+	if entry.Status >= http.StatusBadRequest {
+		log = logs.Warnf
+	}
+	log("🔑 %s: %s %s %d", client, entry.Method, entry.Path, entry.Status)
+	if r == nil {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.file.Write(append(data, '\n')); err != nil {
+		logs.Errorf("failed to write the audit trail: %v", err)
+	}
+}
+
+// Close closes the file of the audit trail.
+func (r *Audit) Close() error {
+	if r == nil {
+		return nil
+	}
+	return r.file.Close()
+}
+
+// taskTarget is the target of a task.
+func taskTarget(view TaskView) target {
+	return target{device: view.DeviceID, tenant: view.Labels[session.TenantLabel]}
+}
+
+// tenantOf is the tenant tasks run as: tenant, or else the one of their
+// labels.
+func tenantOf(tenant string, tags labels.Labels) string {
+	if tenant != "" {
+		return tenant
+	}
+	return tags[session.TenantLabel]
+}
+
+// submitAllowed reports whether the client of ctx may submit tasks of tenant
+// on devices, empty for the pool of tenant. Other than operating on them,
+// running tasks as a tenant, on its quota, takes a role listing the tenant.
+func submitAllowed(ctx context.Context, tenant string, devices ...string) bool {
+	if tenant != "" && restricted(ctx) && !slices.Contains(clientOf(ctx).Role.Tenants, tenant) {
+		return false
+	}
+	return pipelineAllowed(ctx, tenant, devices)
+}
+
+// pipelineAllowed reports whether the client of ctx may operate on the
+// devices of every node of a pipeline, empty for the pool of tenant.
+func pipelineAllowed(ctx context.Context, tenant string, devices []string) bool {
+	for _, device := range devices {
+		if !allowed(ctx, target{device: device, tenant: tenant}) {
+			return false
+		}
+	}
+	return true
+}
+
+func scheduleTarget(req ScheduleRequest) target {
+	return target{device: req.DeviceID, group: req.Group, tenant: req.Tenant}
+}
+
+// viewAllowed reports whether the client of ctx may operate on every node of
+// a pipeline.
+func viewAllowed(ctx context.Context, view PipelineView) bool {
+	devices := make([]string, 0, len(view.Nodes))
+	for _, node := range view.Nodes {
+		devices = append(devices, node.DeviceID)
+	}
+	return pipelineAllowed(ctx, view.Labels[session.TenantLabel], devices)
+}
+
+// taskAllowed reports whether the client of req may operate on the task of
+// its path, writing the error when not. Unknown tasks are left to the
+// handler.
+func taskAllowed(w http.ResponseWriter, req *http.Request, tasks *Tasks) bool {
+	if !restricted(req.Context()) {
+		return true
+	}
+	view, ok := tasks.Get(req.PathValue("id"))
+	if ok && !allowed(req.Context(), taskTarget(view)) {
+		forbidden(w, "the api key may not target device %q", view.DeviceID)
+		return false
+	}
+	return true
+}
+
+// scheduleAllowed is taskAllowed for the schedule of the path of req.
+func scheduleAllowed(w http.ResponseWriter, req *http.Request, schedules *Schedules) bool {
+	if !restricted(req.Context()) {
+		return true
+	}
+	view, ok := schedules.Get(req.PathValue("id"))
+	if ok && !allowed(req.Context(), scheduleTarget(view.ScheduleRequest)) {
+		forbidden(w, "the api key may not target the devices of the schedule")
+		return false
+	}
+	return true
+}
+
+// isArtifact tells whether p is a signed URL of a local artifact store.
+func isArtifact(p string) bool {
+	local, ok := artifact.Current().(*artifact.Local)
+	return ok && strings.HasPrefix(p, local.Path()+"/")
+}
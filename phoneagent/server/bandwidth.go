@@ -0,0 +1,225 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/jpeg"
+	_ "image/png"
+	"time"
+
+	"autoglm-go/phoneagent"
+	"autoglm-go/phoneagent/imaging"
+	logs "github.com/sirupsen/logrus"
+)
+
+// Bandwidth modes of an event stream, chosen with ?bandwidth=.
+const (
+	BandwidthFull = "full" // every screenshot as sent to the model
+	BandwidthLow  = "low"  // fewer, smaller screenshots, as patches of the previous one
+	BandwidthAuto = "auto" // full until the client reads slower than autoLowRate
+)
+
+const (
+	// lowImageInterval is the least time between two screenshots of a low
+	// bandwidth stream, the latest one held back is sent when it is over.
+	lowImageInterval = 5 * time.Second
+	lowMaxEdge       = 480
+	lowQuality       = 50
+	// patchShare is the largest share of the screen a patch may cover, the
+	// whole screenshot is sent above it.
+	patchShare = 0.6
+	// pixelThreshold is the difference summed over the channels, 0-765, of a
+	// pixel that changed.
+	pixelThreshold = 24
+
+	// autoLowRate and autoFullRate, in bytes per second, switch an auto
+	// stream to low bandwidth and back; measured over autoWindow bytes.
+	autoLowRate  = 256 << 10
+	autoFullRate = 4 * autoLowRate
+	autoWindow   = 256 << 10
+)
+
+// ParseBandwidth checks a bandwidth mode, empty for BandwidthFull.
+func ParseBandwidth(mode string) (string, error) {
+	switch mode {
+	case "":
+		return BandwidthFull, nil
+	case BandwidthFull, BandwidthLow, BandwidthAuto:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid bandwidth %q, want %s, %s or %s", mode, BandwidthFull, BandwidthLow, BandwidthAuto)
+}
+
+// screenshotEvent is a screenshot of a low bandwidth stream. Its Image covers
+// ImageRegion of the previous screenshot of the stream when set, in pixels of
+// that screenshot; ImageUnchanged means the screen is as before and Image is
+// left out.
+type screenshotEvent struct {
+	phoneagent.Event
+	ImageRegion    *[4]int `json:"image_region,omitempty"` // left, top, right, bottom
+	ImageUnchanged bool    `json:"image_unchanged,omitempty"`
+}
+
+// imageThrottle lowers the screenshots of an event stream to its bandwidth.
+type imageThrottle struct {
+	mode string
+	low  bool
+
+	lastSent time.Time
+	pending  *phoneagent.Event // held back by lowImageInterval
+	previous *image.RGBA       // last screenshot sent, patches apply to it
+
+	// written since the last rate check
+	written int
+	elapsed time.Duration
+}
+
+func newImageThrottle(mode string) *imageThrottle {
+	return &imageThrottle{mode: mode, low: mode == BandwidthLow}
+}
+
+// observe accounts n bytes written to the client in elapsed, and switches an
+// auto stream on its rate.
+func (t *imageThrottle) observe(n int, elapsed time.Duration) {
+	if t.mode != BandwidthAuto {
+		return
+	}
+	t.written += n
+	t.elapsed += elapsed
+	if t.written < autoWindow {
+		return
+	}
+	rate := float64(t.written) / max(t.elapsed.Seconds(), 1e-3)
+	t.written, t.elapsed = 0, 0
+	switch {
+	case !t.low && rate < autoLowRate:
+		logs.Infof("🛰️ event stream reads at %.0f KB/s, switching to low bandwidth", rate/1024)
+		t.low = true
+	case t.low && rate > autoFullRate:
+		logs.Infof("🛰️ event stream reads at %.0f KB/s, switching to full bandwidth", rate/1024)
+		t.low, t.previous = false, nil
+	}
+}
+
+// screenshot returns the event to send for a screenshot, false when it is
+// held back until due.
+func (t *imageThrottle) screenshot(event phoneagent.Event) (any, bool) {
+	if !t.low || event.Image == "" {
+		return event, true
+	}
+	if time.Since(t.lastSent) < lowImageInterval {
+		t.pending = &event
+		return nil, false
+	}
+	return t.lower(event), true
+}
+
+// due returns when the screenshot held back is to be sent, nil without one.
+func (t *imageThrottle) due() <-chan time.Time {
+	if t.pending == nil {
+		return nil
+	}
+	return time.After(time.Until(t.lastSent.Add(lowImageInterval)))
+}
+
+// flush returns the screenshot held back, if any.
+func (t *imageThrottle) flush() (any, bool) {
+	if t.pending == nil {
+		return nil, false
+	}
+	event := *t.pending
+	t.pending = nil
+	return t.lower(event), true
+}
+
+// lower shrinks the screenshot of event, sending only the region that changed
+// since the previous one when it is small enough.
+func (t *imageThrottle) lower(event phoneagent.Event) any {
+	t.lastSent, t.pending = time.Now(), nil
+	img, err := decodeDataURL(event.Image)
+	if err != nil {
+		logs.Debugf("failed to decode the screenshot of an event, sending it as is: %v", err)
+		t.previous = nil
+		return event
+	}
+	current := toRGBA(imaging.Resize(img, lowMaxEdge))
+	previous := t.previous
+	t.previous = current
+
+	out := screenshotEvent{Event: event}
+	region := current.Bounds()
+	if previous != nil && previous.Bounds() == current.Bounds() {
+		changed, ok := changedRegion(previous, current)
+		if !ok {
+			out.Image, out.ImageUnchanged = "", true
+			return out
+		}
+		if float64(area(changed)) <= patchShare*float64(area(region)) {
+			region = changed
+			out.ImageRegion = &[4]int{changed.Min.X, changed.Min.Y, changed.Max.X, changed.Max.Y}
+		}
+	}
+	encoded, err := imaging.EncodeImage(current.SubImage(region), imaging.Level{Format: imaging.FormatJPEG, Quality: lowQuality})
+	if err != nil {
+		logs.Debugf("failed to encode the screenshot of an event, sending it as is: %v", err)
+		t.previous = nil
+		return event
+	}
+	out.Image, out.SentBytes = encoded.DataURL(), encoded.Size
+	return out
+}
+
+func decodeDataURL(url string) (image.Image, error) {
+	_, raw, err := parseDataURL(url)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(raw))
+	return img, err
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Bounds().Min == (image.Point{}) {
+		return rgba
+	}
+	rgba := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	return rgba
+}
+
+// changedRegion is the bounding box of the pixels that differ between a and
+// b, of the same size, false when none does.
+func changedRegion(a, b *image.RGBA) (image.Rectangle, bool) {
+	var changed image.Rectangle
+	found := false
+	bounds := a.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			i := a.PixOffset(x, y)
+			pa, pb := a.Pix[i:i+3:i+3], b.Pix[i:i+3:i+3]
+			if absDiff(pa[0], pb[0])+absDiff(pa[1], pb[1])+absDiff(pa[2], pb[2]) <= pixelThreshold {
+				continue
+			}
+			pixel := image.Rect(x, y, x+1, y+1)
+			if !found {
+				changed, found = pixel, true
+			} else {
+				changed = changed.Union(pixel)
+			}
+		}
+	}
+	return changed, found
+}
+
+func absDiff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
+
+func area(r image.Rectangle) int {
+	return r.Dx() * r.Dy()
+}
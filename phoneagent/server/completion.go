@@ -0,0 +1,97 @@
+package server
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Bases of a Completion.
+const (
+	BasisPlan    = "plan"    // subgoals of the planner done
+	BasisHistory = "history" // steps against those similar past tasks took
+)
+
+const (
+	// minSimilarity is how close, from 0 to 1, the instruction of a past
+	// task must be to count as similar, see similarity.
+	minSimilarity = 0.6
+	// minSimilarTasks is how many similar tasks must have succeeded before
+	// their step counts are trusted.
+	minSimilarTasks = 2
+	// maxRunningPercent caps the estimate until the task ends, a task past
+	// the steps of its similar ones is not done yet.
+	maxRunningPercent = 95
+)
+
+// Completion is how much of a task is estimated done.
+type Completion struct {
+	Percent int    `json:"percent"`
+	Basis   string `json:"basis"`
+	// ExpectedSteps is the median step count of the similar tasks, for the
+	// history basis.
+	ExpectedSteps int `json:"expected_steps,omitempty"`
+}
+
+// estimateCompletion estimates the task from the subgoal of its plan in
+// progress, counted half done, or else from the steps its similar tasks
+// took. It returns nil when neither is known.
+func estimateCompletion(steps, expected, subgoal, subgoals int) *Completion {
+	switch {
+	case subgoals > 0:
+		percent := (2*subgoal - 1) * 50 / subgoals
+		return &Completion{Percent: min(percent, maxRunningPercent), Basis: BasisPlan}
+	case expected > 0:
+		return &Completion{Percent: min(steps*100/expected, maxRunningPercent), Basis: BasisHistory, ExpectedSteps: expected}
+	}
+	return nil
+}
+
+// expectedSteps returns the median step count of the succeeded tasks, among
+// those kept, whose instruction is similar to instruction, 0 when fewer than
+// minSimilarTasks are. It must be called with r.mu held.
+func (r *Tasks) expectedSteps(instruction string) int {
+	grams := bigrams(instruction)
+	var counts []int
+	for _, t := range r.tasks {
+		if t.Status == StatusSucceeded && t.StepCount > 0 && similarity(grams, bigrams(t.Instruction)) >= minSimilarity {
+			counts = append(counts, t.StepCount)
+		}
+	}
+	if len(counts) < minSimilarTasks {
+		return 0
+	}
+	slices.Sort(counts)
+	return counts[len(counts)/2]
+}
+
+// bigrams returns the pairs of adjacent letters and digits of text, lower
+// cased. Unlike words, they compare instructions written without spaces,
+// as Chinese ones are.
+func bigrams(text string) map[string]struct{} {
+	var runes []rune
+	for _, c := range strings.ToLower(text) {
+		if unicode.IsLetter(c) || unicode.IsDigit(c) {
+			runes = append(runes, c)
+		}
+	}
+	grams := map[string]struct{}{}
+	for i := 1; i < len(runes); i++ {
+		grams[string(runes[i-1:i+1])] = struct{}{}
+	}
+	return grams
+}
+
+// similarity is the Jaccard index of two sets of bigrams.
+func similarity(a, b map[string]struct{}) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	shared := 0
+	for gram := range a {
+		if _, ok := b[gram]; ok {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
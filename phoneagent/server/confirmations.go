@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"autoglm-go/phoneagent"
+	logs "github.com/sirupsen/logrus"
+)
+
+// ConfirmationAnswer is the body of POST /api/confirmations/{id}: approve
+// runs the sensitive action, or hands the device back after a takeover,
+// otherwise the task stops.
+type ConfirmationAnswer struct {
+	Approve bool `json:"approve"`
+}
+
+type confirmation struct {
+	phoneagent.ConfirmRequest
+	answer chan bool // buffered, takes the single answer
+}
+
+// Confirm asks the API clients: the request shows in TaskView.Confirmation
+// and GET /api/confirmations, and as a "confirmation" event on the stream of
+// the task, until Answer is called. It implements phoneagent.Confirmer, for
+// session.Options.Confirmer.
+func (r *Tasks) Confirm(ctx context.Context, req phoneagent.ConfirmRequest) (bool, error) {
+	c := &confirmation{ConfirmRequest: req, answer: make(chan bool, 1)}
+	r.mu.Lock()
+	t, ok := r.tasks[req.TaskID]
+	if !ok {
+		r.mu.Unlock()
+		return false, fmt.Errorf("%w: task %s", ErrNotFound, req.TaskID)
+	}
+	r.confirmations[req.ID] = c
+	t.Confirmation = &c.ConfirmRequest
+	r.publish(t, Event{Name: "confirmation", Data: req})
+	r.mu.Unlock()
+	logs.Infof("🛰️ task %s waits for %s %s", t.ID, req.Kind, req.ID)
+
+	defer func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.confirmations, req.ID)
+		if t.Confirmation == &c.ConfirmRequest {
+			t.Confirmation = nil
+		}
+		if t.FinishedAt == nil {
+			r.publish(t, Event{Name: "status", Data: t.snapshot(false)})
+		}
+	}()
+	select {
+	case approved := <-c.answer:
+		return approved, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// Answer answers a pending confirmation, see Confirm.
+func (r *Tasks) Answer(id string, approve bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.confirmations[id]
+	if !ok {
+		return fmt.Errorf("%w: confirmation %s", ErrNotFound, id)
+	}
+	// no longer pending, a second answer is not found
+	delete(r.confirmations, id)
+	c.answer <- approve
+	logs.Infof("🛰️ %s %s of task %s answered, approved: %t", c.Kind, id, c.TaskID, approve)
+	return nil
+}
+
+// Confirmations returns the pending confirmations and takeovers, oldest
+// first.
+func (r *Tasks) Confirmations() []phoneagent.ConfirmRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pending := make([]phoneagent.ConfirmRequest, 0, len(r.confirmations))
+	for _, c := range r.confirmations {
+		pending = append(pending, c.ConfirmRequest)
+	}
+	slices.SortFunc(pending, func(a, b phoneagent.ConfirmRequest) int {
+		return a.At.Compare(b.At)
+	})
+	return pending
+}
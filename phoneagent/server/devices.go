@@ -0,0 +1,46 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"autoglm-go/phoneagent/health"
+)
+
+// serveDeviceEvents streams the state changes of the devices as server-sent
+// "device" events, after a "status" event with the state of every device.
+func serveDeviceEvents(w http.ResponseWriter, req *http.Request, monitor *health.Monitor) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	events, unsubscribe := monitor.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	writeEvent(w, Event{Name: "status", Data: monitor.Statuses()})
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			writeEvent(w, Event{Name: "device", Data: event})
+			flusher.Flush()
+		}
+	}
+}
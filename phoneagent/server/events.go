@@ -0,0 +1,332 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"autoglm-go/phoneagent"
+	"autoglm-go/phoneagent/artifact"
+	"autoglm-go/phoneagent/seal"
+	"autoglm-go/phoneagent/session"
+	logs "github.com/sirupsen/logrus"
+)
+
+// subscriberBuffer is how many events a subscriber may lag behind before it
+// is dropped, a client reading too slowly reconnects and starts over from
+// the status of the task.
+const subscriberBuffer = 512
+
+// backlogSize is how many of the last events of a task are kept for the
+// clients reconnecting with Last-Event-ID.
+const backlogSize = 4096
+
+// keepAliveInterval is how often an idle event stream gets a comment, so that
+// proxies do not close it.
+const keepAliveInterval = 15 * time.Second
+
+// imageURLTTL is how long the image URLs of ?images=url stay valid.
+const imageURLTTL = time.Hour
+
+// Event is an event of the stream of a task. Name is one of the
+// phoneagent.EventType values, "status" when the status changes, "done" once
+// the task ended or "confirmation" when it waits for an answer; Data is a
+// phoneagent.Event for the first, the TaskView for "status" and "done" and
+// the phoneagent.ConfirmRequest for the last. ID numbers the events of a
+// task from 1, the status a stream starts with has none.
+type Event struct {
+	ID   uint64
+	Name string
+	Data any
+}
+
+// OnEvent publishes the events of the running tasks, for
+// session.Options.OnEvent.
+func (r *Tasks) OnEvent(submitted *session.Task, event phoneagent.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.tasks[submitted.ID]
+	if !ok {
+		return
+	}
+	r.publish(t, Event{Name: string(event.Type), Data: event})
+	switch event.Type {
+	case phoneagent.EventPaused:
+		t.Status = StatusPaused
+	case phoneagent.EventResumed:
+		t.Status = StatusRunning
+	case phoneagent.EventPlan:
+		t.Plan, t.Subgoal = event.Plan, event.Subgoal
+	default:
+		return
+	}
+	r.publish(t, Event{Name: "status", Data: t.snapshot(false)})
+}
+
+// Subscribe returns the task, the events kept after the event numbered
+// after, none when it is 0, and a channel of its events from now on, closed
+// once the task is done, and a function to stop listening. The channel is nil
+// when the task already ended.
+func (r *Tasks) Subscribe(id string, after uint64) (TaskView, []Event, <-chan Event, func(), bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.tasks[id]
+	if !ok {
+		return TaskView{}, nil, nil, nil, false
+	}
+	var missed []Event
+	if after > 0 {
+		for _, event := range t.backlog {
+			if event.ID > after {
+				missed = append(missed, event)
+			}
+		}
+	}
+	if t.FinishedAt != nil {
+		return t.snapshot(false), missed, nil, func() {}, true
+	}
+	ch := make(chan Event, subscriberBuffer)
+	if t.subscribers == nil {
+		t.subscribers = map[chan Event]struct{}{}
+	}
+	t.subscribers[ch] = struct{}{}
+	unsubscribe := func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if _, ok := t.subscribers[ch]; ok {
+			delete(t.subscribers, ch)
+			close(ch)
+		}
+	}
+	return t.snapshot(false), missed, ch, unsubscribe, true
+}
+
+// publish numbers event and keeps it in the backlog of t, it must be called
+// with r.mu held.
+func (r *Tasks) publish(t *task, event Event) {
+	t.seq++
+	event.ID = t.seq
+	r.keep(t, event)
+	for ch := range t.subscribers {
+		select {
+		case ch <- event:
+		default:
+			logs.Warnf("🛰️ event stream of task %s too slow, dropping it", t.ID)
+			delete(t.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// keep adds event to the backlog of t. Only the last screenshot keeps its
+// image, a client catching up needs the screen as it is, not each it missed.
+func (r *Tasks) keep(t *task, event Event) {
+	if agentEvent, ok := event.Data.(phoneagent.Event); ok && agentEvent.Type == phoneagent.EventScreenshot {
+		for i := len(t.backlog) - 1; i >= 0; i-- {
+			if previous, ok := t.backlog[i].Data.(phoneagent.Event); ok && previous.Type == phoneagent.EventScreenshot {
+				if previous.Image == "" {
+					break
+				}
+				previous.Image = ""
+				t.backlog[i].Data = previous
+			}
+		}
+	}
+	// the oldest quarter goes at once, not one event each time
+	if len(t.backlog) >= backlogSize {
+		t.backlog = append(t.backlog[:0], t.backlog[backlogSize/4:]...)
+	}
+	t.backlog = append(t.backlog, event)
+}
+
+// closeSubscribers ends the event streams of t after the done event, it must
+// be called with r.mu held.
+func (r *Tasks) closeSubscribers(t *task) {
+	r.publish(t, Event{Name: "done", Data: t.snapshot(false)})
+	for ch := range t.subscribers {
+		close(ch)
+	}
+	t.subscribers = nil
+}
+
+// serveEvents streams the events of task id as server-sent events, starting
+// with its status, until expires unless it is zero. A client reconnecting
+// with the Last-Event-ID header, or ?last_event_id=, first gets the events it
+// missed. Screenshots are left out without images, and lowered for slow links
+// with ?bandwidth=, see BandwidthLow. With ?images=url they are put in the
+// artifact storage and sent as signed URLs instead.
+func serveEvents(w http.ResponseWriter, req *http.Request, tasks *Tasks, id string, images bool, expires time.Time) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	bandwidth, err := ParseBandwidth(req.URL.Query().Get("bandwidth"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var storage artifact.Storage
+	if req.URL.Query().Get("images") == "url" {
+		if storage = artifact.Current(); storage == nil {
+			http.Error(w, "images=url needs an artifact store", http.StatusBadRequest)
+			return
+		}
+		if seal.Enabled() && !artifact.ServesSealed(storage) {
+			http.Error(w, "images=url cannot serve the encrypted screenshots of "+storage.String(), http.StatusBadRequest)
+			return
+		}
+	}
+	lastID := req.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = req.URL.Query().Get("last_event_id")
+	}
+	var after uint64
+	if lastID != "" {
+		if after, err = strconv.ParseUint(lastID, 10, 64); err != nil {
+			http.Error(w, "invalid last event id", http.StatusBadRequest)
+			return
+		}
+	}
+	view, missed, events, unsubscribe, ok := tasks.Subscribe(id, after)
+	if !ok {
+		http.Error(w, "task not found", http.StatusNotFound)
+		return
+	}
+	defer unsubscribe()
+	var expired <-chan time.Time
+	if !expires.IsZero() {
+		timer := time.NewTimer(time.Until(expires))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	throttle := newImageThrottle(bandwidth)
+	send := func(event Event) {
+		started := time.Now()
+		n := writeEvent(w, event)
+		flusher.Flush()
+		throttle.observe(n, time.Since(started))
+	}
+	// relay sends an event of the task and reports whether it was its last
+	relay := func(event Event) bool {
+		if agentEvent, ok := event.Data.(phoneagent.Event); ok && agentEvent.Type == phoneagent.EventScreenshot {
+			if !images {
+				agentEvent.Image = ""
+			}
+			if storage != nil && agentEvent.Image != "" {
+				url, err := storeImage(req.Context(), storage, agentEvent.Image)
+				if err != nil {
+					logs.Warnf("🛰️ failed to store the screenshot of task %s, err: %v", id, err)
+				}
+				agentEvent.Image, agentEvent.ImageURL = "", url
+			}
+			data, ok := throttle.screenshot(agentEvent)
+			if !ok {
+				return false
+			}
+			event.Data = data
+		}
+		// the final screen goes out before the end of the task
+		if event.Name == "done" {
+			if data, ok := throttle.flush(); ok {
+				send(Event{Name: string(phoneagent.EventScreenshot), Data: data})
+			}
+		}
+		send(event)
+		return event.Name == "done"
+	}
+
+	if events == nil && len(missed) == 0 {
+		writeEvent(w, Event{Name: "done", Data: view})
+		flusher.Flush()
+		return
+	}
+	writeEvent(w, Event{Name: "status", Data: view})
+	flusher.Flush()
+	for _, event := range missed {
+		if relay(event) {
+			return
+		}
+	}
+	if events == nil {
+		writeEvent(w, Event{Name: "done", Data: view})
+		flusher.Flush()
+		return
+	}
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-expired:
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-throttle.due():
+			if data, ok := throttle.flush(); ok {
+				send(Event{Name: string(phoneagent.EventScreenshot), Data: data})
+			}
+		case event, ok := <-events:
+			if !ok || relay(event) {
+				return
+			}
+		}
+	}
+}
+
+// storeImage puts the image of a data URL in storage, sealed when artifacts
+// are encrypted, and returns its signed URL.
+func storeImage(ctx context.Context, storage artifact.Storage, dataURL string) (string, error) {
+	contentType, data, err := parseDataURL(dataURL)
+	if err != nil {
+		return "", err
+	}
+	key := artifact.ContentKey("events", data, "."+strings.TrimPrefix(contentType, "image/"))
+	if err := storage.Put(ctx, key, seal.Data(data), contentType); err != nil {
+		return "", err
+	}
+	return storage.URL(ctx, key, imageURLTTL)
+}
+
+// parseDataURL returns the content type and data of a base64 data URL.
+func parseDataURL(dataURL string) (string, []byte, error) {
+	header, encoded, ok := strings.Cut(dataURL, ";base64,")
+	if !ok {
+		return "", nil, fmt.Errorf("not a base64 data URL")
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", nil, err
+	}
+	return strings.TrimPrefix(header, "data:"), data, nil
+}
+
+// writeEvent writes event in the server-sent events format and returns the
+// bytes written.
+func writeEvent(w http.ResponseWriter, event Event) int {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		logs.Warnf("failed to encode %s event, err: %v", event.Name, err)
+		return 0
+	}
+	if event.ID > 0 {
+		n, _ := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Name, data)
+		return n
+	}
+	n, _ := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Name, data)
+	return n
+}
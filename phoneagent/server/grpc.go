@@ -0,0 +1,427 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"autoglm-go/phoneagent"
+	"autoglm-go/phoneagent/health"
+	"autoglm-go/phoneagent/server/agentpb"
+	"autoglm-go/phoneagent/session"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCServer serves the task API over gRPC, see agentpb.AgentService, for
+// the services embedding the agent. With an Auth the calls need an API key
+// in their metadata, and the roles allow them as they do the requests of
+// Handler: the tasks an observer submits run read-only, controlling tasks
+// needs the control access.
+func GRPCServer(tasks *Tasks, submitter Submitter, lister Lister, monitor *health.Monitor, auth *Auth) *grpc.Server {
+	var opts []grpc.ServerOption
+	if auth != nil {
+		opts = append(opts, grpc.UnaryInterceptor(auth.unary), grpc.StreamInterceptor(auth.stream))
+	}
+	s := grpc.NewServer(opts...)
+	agentpb.RegisterAgentServiceServer(s, &agentService{tasks: tasks, submitter: submitter, lister: lister, monitor: monitor})
+	return s
+}
+
+type agentService struct {
+	agentpb.UnimplementedAgentServiceServer
+	tasks     *Tasks
+	submitter Submitter
+	lister    Lister
+	monitor   *health.Monitor
+}
+
+func (r *agentService) SubmitTask(ctx context.Context, req *agentpb.SubmitTaskRequest) (*agentpb.Task, error) {
+	view, err := r.submit(ctx, req)
+	auditCall(ctx, "", &view, err)
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	return taskOf(view), nil
+}
+
+// submit submits req as POST /api/tasks does.
+func (r *agentService) submit(ctx context.Context, req *agentpb.SubmitTaskRequest) (TaskView, error) {
+	if req == nil {
+		return TaskView{}, fmt.Errorf("task is required")
+	}
+	body := taskRequestOf(req)
+	if tenant := tenantOf(body.Tenant, body.Labels); !submitAllowed(ctx, tenant, body.DeviceID) {
+		return TaskView{}, fmt.Errorf("%w: the api key may not target device %q of tenant %q", ErrForbidden, body.DeviceID, tenant)
+	}
+	if observer(ctx) {
+		body.ReadOnly = true
+	}
+	view, _, err := r.tasks.Submit(r.submitter, body)
+	return view, err
+}
+
+func (r *agentService) GetTask(ctx context.Context, req *agentpb.GetTaskRequest) (*agentpb.Task, error) {
+	view, err := r.task(ctx, req.GetTaskId())
+	if err != nil {
+		return nil, rpcError(err)
+	}
+	return taskOf(view), nil
+}
+
+// task returns the task id, not found for a client that may not target it.
+func (r *agentService) task(ctx context.Context, id string) (TaskView, error) {
+	view, ok := r.tasks.Get(id)
+	if !ok || !allowed(ctx, taskTarget(view)) {
+		return TaskView{}, fmt.Errorf("%w: task %s", ErrNotFound, id)
+	}
+	return view, nil
+}
+
+func (r *agentService) ListTasks(ctx context.Context, req *agentpb.ListTasksRequest) (*agentpb.ListTasksResponse, error) {
+	views := r.tasks.List(req.GetDeviceId())
+	if restricted(ctx) {
+		views = slices.DeleteFunc(views, func(view TaskView) bool { return !allowed(ctx, taskTarget(view)) })
+	}
+	resp := &agentpb.ListTasksResponse{Tasks: make([]*agentpb.Task, 0, len(views))}
+	for _, view := range views {
+		resp.Tasks = append(resp.Tasks, taskOf(view))
+	}
+	return resp, nil
+}
+
+func (r *agentService) ListDevices(ctx context.Context, req *agentpb.ListDevicesRequest) (*agentpb.ListDevicesResponse, error) {
+	devices, err := r.lister.ListDevices(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	resp := &agentpb.ListDevicesResponse{Devices: []*agentpb.Device{}}
+	for _, info := range devices {
+		if allowed(ctx, target{device: info.DeviceID}) {
+			resp.Devices = append(resp.Devices, deviceOf(info))
+		}
+	}
+	return resp, nil
+}
+
+func (r *agentService) WatchDevices(req *agentpb.WatchDevicesRequest, stream agentpb.AgentService_WatchDevicesServer) error {
+	if r.monitor == nil {
+		return rpcError(fmt.Errorf("%w: no device heartbeat", ErrNotSupported))
+	}
+	ctx := stream.Context()
+	if restricted(ctx) {
+		return status.Error(codes.PermissionDenied, "the events of every device need an api key targeting every device")
+	}
+	events, unsubscribe := r.monitor.Subscribe()
+	defer unsubscribe()
+	for _, s := range r.monitor.Statuses() {
+		if err := stream.Send(deviceEventOf(health.Event{DeviceID: s.DeviceID, State: s.State, Time: s.Since, Error: s.Error})); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if err := stream.Send(deviceEventOf(event)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Control runs the commands of the stream in order. Once the client closed
+// its side, it returns after the tasks watched are done.
+func (r *agentService) Control(stream agentpb.AgentService_ControlServer) error {
+	c := &controlStream{agentService: r, stream: stream, watched: map[string]bool{}}
+	defer c.wg.Wait()
+	ctx := stream.Context()
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		result, watch := c.run(ctx, req)
+		if err := c.send(&agentpb.ControlResponse{Response: &agentpb.ControlResponse_Result{Result: result}}); err != nil {
+			return err
+		}
+		// the events follow the result
+		if watch != nil {
+			watch()
+		}
+	}
+}
+
+// controlStream is a Control call, its tasks send their events at once.
+type controlStream struct {
+	*agentService
+	stream agentpb.AgentService_ControlServer
+	sendMu sync.Mutex
+
+	mu      sync.Mutex
+	watched map[string]bool // tasks whose events are sent
+	wg      sync.WaitGroup
+}
+
+func (c *controlStream) send(resp *agentpb.ControlResponse) error {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	return c.stream.Send(resp)
+}
+
+// run runs a command and returns its result, with the function starting to
+// watch its task if it has one.
+func (c *controlStream) run(ctx context.Context, req *agentpb.ControlRequest) (*agentpb.ControlResult, func()) {
+	var (
+		view  TaskView
+		err   error
+		watch func()
+	)
+	switch command := req.GetCommand().(type) {
+	case *agentpb.ControlRequest_Submit:
+		view, err = c.submit(ctx, command.Submit.GetTask())
+		auditCall(ctx, "submit", &view, err)
+		if err == nil {
+			watch = func() { c.watch(ctx, view.ID, 0, command.Submit.GetImages()) }
+		}
+	case *agentpb.ControlRequest_Watch:
+		id := command.Watch.GetTaskId()
+		if view, err = c.task(ctx, id); err == nil {
+			watch = func() { c.watch(ctx, id, command.Watch.GetAfter(), command.Watch.GetImages()) }
+		}
+	case *agentpb.ControlRequest_Cancel:
+		opts := phoneagent.CancelOptions{Home: command.Cancel.GetHome()}
+		view, err = c.control(ctx, command.Cancel.GetTaskId(), func(id string) error { return c.tasks.Cancel(id, opts) })
+		auditCall(ctx, "cancel", nil, err)
+	case *agentpb.ControlRequest_Pause:
+		view, err = c.control(ctx, command.Pause.GetTaskId(), c.tasks.Pause)
+		auditCall(ctx, "pause", nil, err)
+	case *agentpb.ControlRequest_Resume:
+		view, err = c.control(ctx, command.Resume.GetTaskId(), c.tasks.Resume)
+		auditCall(ctx, "resume", nil, err)
+	case *agentpb.ControlRequest_Answer:
+		view, err = c.answer(ctx, command.Answer.GetConfirmationId(), command.Answer.GetApprove())
+		auditCall(ctx, "answer", nil, err)
+	default:
+		err = fmt.Errorf("command is required")
+	}
+
+	result := &agentpb.ControlResult{RequestId: req.GetRequestId()}
+	if err != nil {
+		result.Code, result.Error = int32(status.Code(rpcError(err))), err.Error()
+		return result, nil
+	}
+	if view.ID != "" {
+		result.Task = taskOf(view)
+	}
+	return result, watch
+}
+
+// control runs do on the task id as the POST /api/tasks/{id}/... requests
+// do, and returns the task after it.
+func (c *controlStream) control(ctx context.Context, id string, do func(id string) error) (TaskView, error) {
+	if observer(ctx) {
+		return TaskView{}, fmt.Errorf("%w: controlling tasks needs %s access", ErrForbidden, AccessControl)
+	}
+	if view, ok := c.tasks.Get(id); ok && !allowed(ctx, taskTarget(view)) {
+		return TaskView{}, fmt.Errorf("%w: the api key may not target device %q", ErrForbidden, view.DeviceID)
+	}
+	if err := do(id); err != nil {
+		return TaskView{}, err
+	}
+	view, _ := c.tasks.Get(id)
+	return view, nil
+}
+
+// answer answers the confirmation id and returns its task.
+func (c *controlStream) answer(ctx context.Context, id string, approve bool) (TaskView, error) {
+	if observer(ctx) {
+		return TaskView{}, fmt.Errorf("%w: answering confirmations needs %s access", ErrForbidden, AccessControl)
+	}
+	confirmations := c.tasks.Confirmations()
+	i := slices.IndexFunc(confirmations, func(c phoneagent.ConfirmRequest) bool {
+		return c.ID == id && allowed(ctx, target{device: c.DeviceID, tenant: c.Labels[session.TenantLabel]})
+	})
+	if i < 0 {
+		return TaskView{}, fmt.Errorf("confirmation %w", ErrNotFound)
+	}
+	if err := c.tasks.Answer(id, approve); err != nil {
+		return TaskView{}, err
+	}
+	view, _ := c.tasks.Get(confirmations[i].TaskID)
+	return view, nil
+}
+
+// watch sends the events of the task id, from the one after the event
+// numbered after, until it is done or the stream ends. A task already
+// watched is left alone.
+func (c *controlStream) watch(ctx context.Context, id string, after uint64, images bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.watched[id] {
+		return
+	}
+	view, missed, events, unsubscribe, ok := c.tasks.Subscribe(id, after)
+	if !ok {
+		return
+	}
+	c.watched[id] = true
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer unsubscribe()
+		defer func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			delete(c.watched, id)
+		}()
+		// relay sends an event and reports whether the stream of the task
+		// is over
+		relay := func(event Event) bool {
+			err := c.send(&agentpb.ControlResponse{Response: &agentpb.ControlResponse_Event{Event: taskEventOf(id, event, images)}})
+			return err != nil || event.Name == "done"
+		}
+
+		if relay(Event{Name: "status", Data: view}) {
+			return
+		}
+		for _, event := range missed {
+			if relay(event) {
+				return
+			}
+		}
+		if events == nil {
+			relay(Event{Name: "done", Data: view})
+			return
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-events:
+				if !ok || relay(event) {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// rpcError is the gRPC status of err, by its HTTP status.
+func rpcError(err error) error {
+	code := codes.InvalidArgument
+	switch errorStatus(err) {
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusConflict:
+		code = codes.FailedPrecondition
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusUnprocessableEntity, http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	case http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		code = codes.Unimplemented
+	}
+	return status.Error(code, err.Error())
+}
+
+// grpcCall is the audit of a gRPC call, see auditCall.
+type grpcCall struct {
+	audit *Audit
+	entry AuditEntry
+}
+
+type grpcCallKey struct{}
+
+// auditCall audits a call, or the command of a stream, changing anything as
+// Wrap audits the requests: its path is the method of the call, followed by
+// the command. submitted is the task of a submission.
+func auditCall(ctx context.Context, command string, submitted *TaskView, err error) {
+	call, ok := ctx.Value(grpcCallKey{}).(*grpcCall)
+	if !ok {
+		return
+	}
+	entry := call.entry
+	entry.At = time.Now()
+	if command != "" {
+		entry.Path += "/" + command
+	}
+	entry.Status = http.StatusOK
+	if err != nil {
+		entry.Status = errorStatus(err)
+	} else if submitted != nil {
+		entry.TaskID, entry.DeviceID, entry.Instruction, entry.ReadOnly = submitted.ID, submitted.DeviceID, submitted.Instruction, submitted.ReadOnly
+	}
+	call.audit.Write(&entry)
+}
+
+// authenticate returns ctx with the client of the API key of the metadata of
+// a call to method, in authorization: Bearer or x-api-key.
+func (r *Auth) authenticate(ctx context.Context, method string) (context.Context, error) {
+	entry := AuditEntry{At: time.Now(), Method: "GRPC", Path: method}
+	if p, ok := peer.FromContext(ctx); ok {
+		entry.Remote = p.Addr.String()
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	var apiKey, authorization string
+	if values := md.Get("x-api-key"); len(values) > 0 {
+		apiKey = values[0]
+	}
+	if values := md.Get("authorization"); len(values) > 0 {
+		authorization = values[0]
+	}
+	client := r.lookup(apiKey, authorization)
+	if client == nil {
+		entry.Status = http.StatusUnauthorized
+		r.audit.Write(&entry)
+		return nil, status.Error(codes.Unauthenticated, "missing or unknown api key")
+	}
+	entry.Client, entry.Role = client.Name, client.Role.Name
+	ctx = context.WithValue(ctx, clientKey{}, client)
+	return context.WithValue(ctx, grpcCallKey{}, &grpcCall{audit: r.audit, entry: entry}), nil
+}
+
+func (r *Auth) unary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := r.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (r *Auth) stream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := r.authenticate(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authStream{ServerStream: ss, ctx: ctx})
+}
+
+// authStream is a stream with the client of its call.
+type authStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authStream) Context() context.Context {
+	return s.ctx
+}
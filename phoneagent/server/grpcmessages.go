@@ -0,0 +1,203 @@
+package server
+
+import (
+	"encoding/json"
+	"maps"
+	"time"
+
+	"autoglm-go/phoneagent"
+	"autoglm-go/phoneagent/definitions"
+	"autoglm-go/phoneagent/health"
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/labels"
+	"autoglm-go/phoneagent/server/agentpb"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// The messages of the gRPC API from and to those of the task API.
+
+func taskRequestOf(req *agentpb.SubmitTaskRequest) TaskRequest {
+	var schema phoneagent.OutputSchema
+	if len(req.GetOutputSchema()) > 0 {
+		schema = phoneagent.OutputSchema(req.GetOutputSchema())
+	}
+	var tags labels.Labels
+	if len(req.GetLabels()) > 0 {
+		tags = labels.Labels(req.GetLabels())
+	}
+	return TaskRequest{
+		DeviceID:       req.GetDeviceId(),
+		Tenant:         req.GetTenant(),
+		Instruction:    req.GetInstruction(),
+		Force:          req.GetForce(),
+		Labels:         tags,
+		Priority:       req.GetPriority(),
+		OutputSchema:   schema,
+		IdempotencyKey: req.GetIdempotencyKey(),
+		SoftDeadline:   req.GetSoftDeadline(),
+		ModelProfile:   req.GetModelProfile(),
+		MaxSteps:       int(req.GetMaxSteps()),
+		StepTimeout:    req.GetStepTimeout(),
+		MaxRepeats:     int(req.GetMaxRepeats()),
+		MaxTokens:      int(req.GetMaxTokens()),
+		MaxCost:        req.GetMaxCost(),
+		ReadOnly:       req.GetReadOnly(),
+	}
+}
+
+func taskOf(view TaskView) *agentpb.Task {
+	task := &agentpb.Task{
+		Id:          view.ID,
+		DeviceId:    view.DeviceID,
+		Instruction: view.Instruction,
+		Labels:      view.Labels,
+		Priority:    view.Priority,
+		Status:      string(view.Status),
+		Message:     view.Message,
+		Error:       view.Error,
+		StepCount:   int32(view.StepCount),
+		Usage: &agentpb.Usage{
+			Requests:         int32(view.Usage.Requests),
+			PromptTokens:     int32(view.Usage.PromptTokens),
+			CompletionTokens: int32(view.Usage.CompletionTokens),
+			Cost:             view.Usage.Cost,
+		},
+		SubmittedAt:  timestamppb.New(view.SubmittedAt),
+		StartedAt:    timestampOf(view.StartedAt),
+		FinishedAt:   timestampOf(view.FinishedAt),
+		ModelProfile: view.ModelProfile,
+		ReadOnly:     view.ReadOnly,
+		Plan:         view.Plan,
+		Subgoal:      int32(view.Subgoal),
+	}
+	if len(view.Output) > 0 {
+		task.Output = structOf(view.Output)
+	}
+	for _, step := range view.Steps {
+		task.Steps = append(task.Steps, &agentpb.Step{
+			Step:    int32(step.Step),
+			App:     step.App,
+			Action:  actionOf(step.Action),
+			Success: step.Success,
+			Message: step.Message,
+			At:      timestamppb.New(step.At),
+		})
+	}
+	if view.Confirmation != nil {
+		task.Confirmation = confirmationOf(*view.Confirmation)
+	}
+	if view.Completion != nil {
+		task.Completion = &agentpb.Completion{
+			Percent:       int32(view.Completion.Percent),
+			Basis:         view.Completion.Basis,
+			ExpectedSteps: int32(view.Completion.ExpectedSteps),
+		}
+	}
+	if view.Outcome != nil {
+		task.Outcome = &agentpb.Outcome{Level: string(view.Outcome.Level), Reason: view.Outcome.Reason, Detail: view.Outcome.Detail}
+	}
+	return task
+}
+
+// actionOf splits an action into its kind, name and arguments.
+func actionOf(action helper.Action) *agentpb.Action {
+	if action == nil {
+		return nil
+	}
+	args := maps.Clone(action)
+	kind, _ := args["_metadata"].(string)
+	name, _ := args["action"].(string)
+	delete(args, "_metadata")
+	delete(args, "action")
+	return &agentpb.Action{Kind: kind, Name: name, Args: structOf(args)}
+}
+
+func confirmationOf(req phoneagent.ConfirmRequest) *agentpb.Confirmation {
+	return &agentpb.Confirmation{
+		Id:       req.ID,
+		Kind:     string(req.Kind),
+		TaskId:   req.TaskID,
+		DeviceId: req.DeviceID,
+		Task:     req.Task,
+		Message:  req.Message,
+		Step:     int32(req.Step),
+		Labels:   req.Labels,
+		At:       timestamppb.New(req.At),
+		Deadline: timestampOf(req.Deadline),
+	}
+}
+
+// taskEventOf is an event of the task id, with the screenshot when images.
+func taskEventOf(id string, event Event, images bool) *agentpb.TaskEvent {
+	out := &agentpb.TaskEvent{Id: event.ID, TaskId: id, Type: event.Name}
+	switch data := event.Data.(type) {
+	case phoneagent.Event:
+		out.Step = int32(data.Step)
+		out.At = timestamppb.New(data.At)
+		out.Delta = data.Delta
+		out.Action = actionOf(data.Action)
+		out.Success = data.Success
+		out.Message = data.Message
+		out.App = data.App
+		out.Width, out.Height = int32(data.Width), int32(data.Height)
+		out.Plan, out.Subgoal = data.Plan, int32(data.Subgoal)
+		if images && data.Image != "" {
+			if contentType, image, err := parseDataURL(data.Image); err == nil {
+				out.Image, out.ImageType = image, contentType
+			}
+		}
+	case TaskView:
+		out.Task = taskOf(data)
+		out.Step = int32(data.StepCount)
+	case phoneagent.ConfirmRequest:
+		out.Confirmation = confirmationOf(data)
+		out.Step = int32(data.Step)
+		out.At = timestamppb.New(data.At)
+	}
+	return out
+}
+
+func deviceOf(info definitions.DeviceInfo) *agentpb.Device {
+	return &agentpb.Device{
+		DeviceId:       info.DeviceID,
+		Status:         info.Status,
+		ConnectionType: string(info.ConnectionType),
+		Model:          info.Model,
+		AndroidVersion: info.AndroidVersion,
+		Os:             string(info.OS),
+	}
+}
+
+func deviceEventOf(event health.Event) *agentpb.DeviceEvent {
+	return &agentpb.DeviceEvent{
+		DeviceId: event.DeviceID,
+		State:    string(event.State),
+		Previous: string(event.Previous),
+		Time:     timestamppb.New(event.Time),
+		Error:    event.Error,
+	}
+}
+
+// timestampOf is nil for a time not set.
+func timestampOf(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+// structOf converts v through its JSON encoding, nil when it is not an
+// object.
+func structOf(v any) *structpb.Struct {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var s structpb.Struct
+	if err := protojson.Unmarshal(data, &s); err != nil {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"autoglm-go/phoneagent"
+	"autoglm-go/phoneagent/labels"
+)
+
+// Splitter splits an instruction into parts that run at the same time and
+// merges their results, implemented by phoneagent.TaskSplitter.
+type Splitter interface {
+	Split(ctx context.Context, instruction string) ([]string, error)
+	Merge(ctx context.Context, instruction string, results []phoneagent.PartResult) string
+}
+
+// ParallelRequest is the body of POST /api/pipelines/parallel: the planner
+// splits the instruction into independent parts, run as the nodes of a
+// pipeline on the devices of a group, or of the pool of the tenant, and
+// merges their results into the message of the pipeline.
+type ParallelRequest struct {
+	Instruction string `json:"instruction"`
+	// Group spreads the parts over its devices, one each while there are
+	// enough. Without one they go to the least busy devices of the pool of
+	// Tenant.
+	Group        string        `json:"group,omitempty"`
+	Tenant       string        `json:"tenant,omitempty"`
+	Labels       labels.Labels `json:"labels"`
+	Priority     string        `json:"priority,omitempty"`
+	Force        bool          `json:"force"`
+	ModelProfile string        `json:"model_profile,omitempty"`
+}
+
+// UseSplitter lets r run parallel pipelines, on the groups of groups when
+// not nil.
+func (r *Pipelines) UseSplitter(splitter Splitter, groups Grouper) {
+	r.splitter, r.groups = splitter, groups
+}
+
+// Parallel splits the instruction of req and runs its parts as a pipeline,
+// in the background once split.
+func (r *Pipelines) Parallel(ctx context.Context, req ParallelRequest) (PipelineView, error) {
+	if r.splitter == nil {
+		return PipelineView{}, fmt.Errorf("%w: parallel pipelines need a planner model", ErrNotSupported)
+	}
+	if strings.TrimSpace(req.Instruction) == "" {
+		return PipelineView{}, fmt.Errorf("instruction is required")
+	}
+	var devices []string
+	switch {
+	case req.Group != "" && r.groups == nil:
+		return PipelineView{}, fmt.Errorf("device groups are not configured")
+	case req.Group != "":
+		if devices = r.groups.Devices(req.Group); len(devices) == 0 {
+			return PipelineView{}, fmt.Errorf("group %s has no devices", req.Group)
+		}
+	case req.Tenant == "":
+		return PipelineView{}, fmt.Errorf("group or tenant is required")
+	}
+
+	parts, err := r.splitter.Split(ctx, req.Instruction)
+	if err != nil {
+		return PipelineView{}, err
+	}
+	pipeline := PipelineRequest{Tenant: req.Tenant, Labels: req.Labels, Priority: req.Priority}
+	for i, part := range parts {
+		node := NodeRequest{
+			ID:           fmt.Sprintf("part-%d", i+1),
+			Instruction:  part,
+			Force:        req.Force,
+			ModelProfile: req.ModelProfile,
+		}
+		if len(devices) > 0 {
+			node.DeviceID = devices[i%len(devices)]
+		}
+		pipeline.Nodes = append(pipeline.Nodes, node)
+	}
+	return r.submit(pipeline, req.Instruction)
+}
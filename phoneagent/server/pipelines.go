@@ -0,0 +1,492 @@
+package server
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"autoglm-go/phoneagent"
+	"autoglm-go/phoneagent/labels"
+	"autoglm-go/phoneagent/session"
+	"github.com/google/uuid"
+	logs "github.com/sirupsen/logrus"
+)
+
+// PipelineLabel is the label carrying the pipeline id on the tasks of a
+// pipeline.
+const PipelineLabel = "pipeline"
+
+const (
+	maxPipelineNodes = 32
+	// maxPipelines is how many pipelines are kept for GET /api/pipelines,
+	// oldest finished ones are forgotten first.
+	maxPipelines = 200
+)
+
+const (
+	StatusPending Status = "pending" // a node waiting for its dependencies
+	StatusSkipped Status = "skipped" // a node whose dependency did not succeed
+)
+
+var (
+	nodeIDRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	varRe    = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+	// refRe matches {{node.variable}} in the instruction of a node
+	refRe = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_-]+)\.([A-Za-z0-9_]+)\s*\}\}`)
+)
+
+// NodeRequest is a task of a PipelineRequest.
+type NodeRequest struct {
+	ID string `json:"id"`
+	// DeviceID may be left out for a tenant with a device pool, as in a
+	// TaskRequest.
+	DeviceID string `json:"device_id"`
+	// Instruction may use the outputs of the nodes it depends on as
+	// {{node.variable}}, {{node.message}} is the finish message of the node.
+	Instruction string   `json:"instruction"`
+	DependsOn   []string `json:"depends_on"`
+	// Outputs are the variables the task reports when it finishes, extracted
+	// as the string fields of a TaskRequest.OutputSchema. A task missing one
+	// fails.
+	Outputs []string `json:"outputs"`
+	Force   bool     `json:"force"`
+	// ModelProfile is the model profile of the task, as in a TaskRequest.
+	ModelProfile string `json:"model_profile,omitempty"`
+}
+
+// PipelineRequest is the body of POST /api/pipelines: tasks run once the
+// tasks they depend on succeeded, those of a failed task are skipped.
+type PipelineRequest struct {
+	Tenant   string        `json:"tenant,omitempty"`
+	Labels   labels.Labels `json:"labels"`             // of every task of the pipeline
+	Priority string        `json:"priority,omitempty"` // of every task, see TaskRequest
+	Nodes    []NodeRequest `json:"nodes"`
+}
+
+// NodeView is a node of a pipeline and its task.
+type NodeView struct {
+	ID        string   `json:"id"`
+	DeviceID  string   `json:"device_id,omitempty"`
+	DependsOn []string `json:"depends_on,omitempty"`
+	Status    Status   `json:"status"`
+	TaskID    string   `json:"task_id,omitempty"`
+	// Instruction is the one submitted, with the variables replaced, once
+	// the task is.
+	Instruction string            `json:"instruction,omitempty"`
+	Message     string            `json:"message,omitempty"`
+	Outputs     map[string]string `json:"outputs,omitempty"`
+	Error       string            `json:"error,omitempty"`
+}
+
+// PipelineView is a pipeline and its progress, as returned by the pipeline
+// API.
+type PipelineView struct {
+	ID          string        `json:"id"`
+	Status      Status        `json:"status"` // running until every node ended, then succeeded, failed or cancelled
+	Labels      labels.Labels `json:"labels,omitempty"`
+	Nodes       []NodeView    `json:"nodes"` // in the order of the request
+	SubmittedAt time.Time     `json:"submitted_at"`
+	FinishedAt  *time.Time    `json:"finished_at,omitempty"`
+	// Instruction is the one split into the nodes of a parallel pipeline,
+	// Message the merge of their results once they all succeeded.
+	Instruction string `json:"instruction,omitempty"`
+	Message     string `json:"message,omitempty"`
+}
+
+type pipeline struct {
+	PipelineView
+	request   PipelineRequest
+	cancelled bool
+}
+
+// Pipelines runs pipelines of tasks through Tasks and keeps their progress.
+type Pipelines struct {
+	tasks     *Tasks
+	submitter Submitter
+	splitter  Splitter // nil rejects parallel pipelines
+	groups    Grouper
+
+	mu        sync.Mutex
+	pipelines map[string]*pipeline
+	order     []string // pipeline ids, oldest first
+}
+
+func NewPipelines(tasks *Tasks, submitter Submitter) *Pipelines {
+	return &Pipelines{tasks: tasks, submitter: submitter, pipelines: map[string]*pipeline{}}
+}
+
+// Submit validates the pipeline and runs it in the background.
+func (r *Pipelines) Submit(req PipelineRequest) (PipelineView, error) {
+	return r.submit(req, "")
+}
+
+// submit runs req, merging the results of its nodes for the split
+// instruction when not empty.
+func (r *Pipelines) submit(req PipelineRequest, instruction string) (PipelineView, error) {
+	if err := validatePipeline(req); err != nil {
+		return PipelineView{}, err
+	}
+	if err := req.Labels.Validate(); err != nil {
+		return PipelineView{}, err
+	}
+	if _, err := session.ParsePriority(req.Priority); err != nil {
+		return PipelineView{}, err
+	}
+
+	p := &pipeline{
+		PipelineView: PipelineView{
+			ID:          uuid.New().String(),
+			Status:      StatusRunning,
+			Labels:      req.Labels,
+			Instruction: instruction,
+			SubmittedAt: time.Now(),
+		},
+		request: req,
+	}
+	for _, node := range req.Nodes {
+		p.Nodes = append(p.Nodes, NodeView{ID: node.ID, DeviceID: node.DeviceID, DependsOn: node.DependsOn, Status: StatusPending})
+	}
+
+	r.mu.Lock()
+	r.pipelines[p.ID] = p
+	r.order = append(r.order, p.ID)
+	r.forget()
+	view := r.snapshot(p)
+	r.mu.Unlock()
+
+	logs.WithFields(req.Labels.Fields()).Infof("🧬 pipeline %s submitted with %d task(s)", p.ID, len(req.Nodes))
+	go r.run(p)
+	return view, nil
+}
+
+// validatePipeline checks the ids, dependencies and variables of the nodes
+// and that they have no cycle.
+func validatePipeline(req PipelineRequest) error {
+	if len(req.Nodes) == 0 {
+		return fmt.Errorf("nodes are required")
+	}
+	if len(req.Nodes) > maxPipelineNodes {
+		return fmt.Errorf("a pipeline has at most %d nodes", maxPipelineNodes)
+	}
+	byID := map[string]NodeRequest{}
+	for _, node := range req.Nodes {
+		if !nodeIDRe.MatchString(node.ID) {
+			return fmt.Errorf("invalid node id %q, want letters, digits, '_' or '-'", node.ID)
+		}
+		if _, ok := byID[node.ID]; ok {
+			return fmt.Errorf("node %s defined twice", node.ID)
+		}
+		if strings.TrimSpace(node.Instruction) == "" {
+			return fmt.Errorf("node %s: instruction is required", node.ID)
+		}
+		for _, name := range node.Outputs {
+			if !varRe.MatchString(name) || name == "message" {
+				return fmt.Errorf("node %s: invalid output %q", node.ID, name)
+			}
+		}
+		if _, ok := phoneagent.LookupModelProfile(node.ModelProfile); node.ModelProfile != "" && !ok {
+			return fmt.Errorf("node %s: unknown model profile %q", node.ID, node.ModelProfile)
+		}
+		byID[node.ID] = node
+	}
+	for _, node := range req.Nodes {
+		for _, dep := range node.DependsOn {
+			if _, ok := byID[dep]; !ok || dep == node.ID {
+				return fmt.Errorf("node %s: invalid dependency %q", node.ID, dep)
+			}
+		}
+		for _, m := range refRe.FindAllStringSubmatch(node.Instruction, -1) {
+			from, name := m[1], m[2]
+			if !slices.Contains(node.DependsOn, from) {
+				return fmt.Errorf("node %s: %s uses node %s, which it does not depend on", node.ID, m[0], from)
+			}
+			if name != "message" && !slices.Contains(byID[from].Outputs, name) {
+				return fmt.Errorf("node %s: %s is not an output of node %s", node.ID, m[0], from)
+			}
+		}
+	}
+	if len(topoOrder(req.Nodes)) < len(req.Nodes) {
+		return fmt.Errorf("the dependencies of the nodes form a cycle")
+	}
+	return nil
+}
+
+// topoOrder returns the indexes of nodes, every node after its dependencies.
+// Nodes on a cycle are left out.
+func topoOrder(nodes []NodeRequest) []int {
+	index := map[string]int{}
+	for i, node := range nodes {
+		index[node.ID] = i
+	}
+	visited := make([]int, len(nodes)) // 0 new, 1 in progress, 2 done
+	var order []int
+	var visit func(i int) bool
+	visit = func(i int) bool {
+		switch visited[i] {
+		case 1:
+			return false
+		case 2:
+			return true
+		}
+		visited[i] = 1
+		for _, dep := range nodes[i].DependsOn {
+			if !visit(index[dep]) {
+				return false
+			}
+		}
+		visited[i] = 2
+		order = append(order, i)
+		return true
+	}
+	for i := range nodes {
+		visit(i)
+	}
+	return order
+}
+
+type nodeResult struct {
+	index int
+	view  TaskView
+}
+
+// run starts the nodes as their dependencies succeed, until every node ended.
+func (r *Pipelines) run(p *pipeline) {
+	order := topoOrder(p.request.Nodes)
+	index := map[string]int{}
+	for i, node := range p.request.Nodes {
+		index[node.ID] = i
+	}
+	results := make(chan nodeResult)
+	running := 0
+
+	for {
+		r.mu.Lock()
+		// in order, so that skipping a node skips its dependents in the same pass
+		for _, i := range order {
+			node := &p.Nodes[i]
+			if node.Status != StatusPending {
+				continue
+			}
+			if p.cancelled {
+				node.Status = StatusCancelled
+				continue
+			}
+			ready := true
+			for _, dep := range node.DependsOn {
+				switch status := p.Nodes[index[dep]].Status; status {
+				case StatusSucceeded:
+				case StatusPending, StatusQueued:
+					ready = false
+				default:
+					node.Status = StatusSkipped
+					node.Error = fmt.Sprintf("dependency %s %s", dep, status)
+				}
+			}
+			if ready && node.Status == StatusPending {
+				if r.start(p, i, index) {
+					running++
+					go func(i int, taskID string) {
+						view, _ := r.tasks.await(taskID)
+						results <- nodeResult{index: i, view: view}
+					}(i, node.TaskID)
+				}
+			}
+		}
+		r.mu.Unlock()
+
+		if running == 0 {
+			break
+		}
+		result := <-results
+		running--
+		r.mu.Lock()
+		r.finishNode(p, result)
+		r.mu.Unlock()
+	}
+
+	r.mu.Lock()
+	status := StatusSucceeded
+	for _, node := range p.Nodes {
+		if node.Status != StatusSucceeded {
+			status = StatusFailed
+		}
+	}
+	if p.cancelled {
+		status = StatusCancelled
+	}
+	var parts []phoneagent.PartResult
+	if p.Instruction != "" && status == StatusSucceeded {
+		for _, node := range p.Nodes {
+			parts = append(parts, phoneagent.PartResult{Instruction: node.Instruction, Message: node.Message})
+		}
+	}
+	r.mu.Unlock()
+
+	// the pipeline runs until its results are merged
+	var message string
+	if len(parts) > 0 {
+		message = r.splitter.Merge(r.tasks.ctx, p.Instruction, parts)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// it may have been cancelled while merging
+	if p.cancelled {
+		status, message = StatusCancelled, ""
+	}
+	now := time.Now()
+	p.FinishedAt = &now
+	p.Status = status
+	p.Message = message
+	logs.WithFields(p.Labels.Fields()).Infof("🧬 pipeline %s %s", p.ID, p.Status)
+}
+
+// start submits the task of node i, it must be called with r.mu held. It
+// returns false when the submission failed.
+func (r *Pipelines) start(p *pipeline, i int, index map[string]int) bool {
+	req, node := p.request.Nodes[i], &p.Nodes[i]
+	instruction := refRe.ReplaceAllStringFunc(req.Instruction, func(ref string) string {
+		m := refRe.FindStringSubmatch(ref)
+		from := p.Nodes[index[m[1]]]
+		if m[2] == "message" {
+			return from.Message
+		}
+		return from.Outputs[m[2]]
+	})
+	var schema phoneagent.OutputSchema
+	if len(req.Outputs) > 0 {
+		schema = phoneagent.OutputSchema{}
+		for _, name := range req.Outputs {
+			schema[name] = "string"
+		}
+	}
+
+	tagged := labels.Labels{}
+	maps.Copy(tagged, p.Labels)
+	tagged[PipelineLabel] = p.ID
+	view, _, err := r.tasks.Submit(r.submitter, TaskRequest{
+		DeviceID:     req.DeviceID,
+		Tenant:       p.request.Tenant,
+		Instruction:  instruction,
+		Force:        req.Force,
+		ModelProfile: req.ModelProfile,
+		Labels:       tagged,
+		Priority:     p.request.Priority,
+		OutputSchema: schema,
+	})
+	node.Instruction = instruction
+	if err != nil {
+		node.Status = StatusFailed
+		node.Error = err.Error()
+		logs.Warnf("🧬 pipeline %s: node %s not submitted, err: %v", p.ID, node.ID, err)
+		return false
+	}
+	node.Status = StatusQueued
+	node.TaskID = view.ID
+	node.DeviceID = view.DeviceID
+	logs.Infof("🧬 pipeline %s: node %s started as task %s", p.ID, node.ID, view.ID)
+	return true
+}
+
+// finishNode records the end of the task of a node and its outputs, it must
+// be called with r.mu held.
+func (r *Pipelines) finishNode(p *pipeline, result nodeResult) {
+	req, node := p.request.Nodes[result.index], &p.Nodes[result.index]
+	node.Status = result.view.Status
+	node.Message = result.view.Message
+	node.Error = result.view.Error
+	if node.Status != StatusSucceeded || len(req.Outputs) == 0 {
+		return
+	}
+	node.Outputs = map[string]string{}
+	var missing []string
+	for _, name := range req.Outputs {
+		if value, ok := result.view.Output[name].(string); ok {
+			node.Outputs[name] = value
+		} else {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		node.Status = StatusFailed
+		node.Error = fmt.Sprintf("missing output(s) %s", strings.Join(missing, ", "))
+	}
+}
+
+// forget drops the oldest finished pipelines beyond maxPipelines, it must be
+// called with r.mu held.
+func (r *Pipelines) forget() {
+	for i := 0; len(r.order) > maxPipelines && i < len(r.order); {
+		id := r.order[i]
+		if r.pipelines[id].FinishedAt == nil {
+			i++
+			continue
+		}
+		delete(r.pipelines, id)
+		r.order = append(r.order[:i], r.order[i+1:]...)
+	}
+}
+
+// snapshot copies the pipeline, with the live status of the running tasks.
+// It must be called with r.mu held.
+func (r *Pipelines) snapshot(p *pipeline) PipelineView {
+	view := p.PipelineView
+	view.Nodes = slices.Clone(p.Nodes)
+	for i, node := range view.Nodes {
+		if node.Status == StatusQueued {
+			if task, ok := r.tasks.Get(node.TaskID); ok {
+				view.Nodes[i].Status = task.Status
+			}
+		}
+		view.Nodes[i].Outputs = maps.Clone(node.Outputs)
+	}
+	return view
+}
+
+// Get returns a pipeline and the status of its nodes.
+func (r *Pipelines) Get(id string) (PipelineView, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.pipelines[id]
+	if !ok {
+		return PipelineView{}, false
+	}
+	return r.snapshot(p), true
+}
+
+// List returns the kept pipelines, newest first.
+func (r *Pipelines) List() []PipelineView {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	views := make([]PipelineView, 0, len(r.order))
+	for i := len(r.order) - 1; i >= 0; i-- {
+		views = append(views, r.snapshot(r.pipelines[r.order[i]]))
+	}
+	return views
+}
+
+// Cancel stops a pipeline: its running tasks are cancelled and the nodes not
+// started yet never are.
+func (r *Pipelines) Cancel(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.pipelines[id]
+	if !ok {
+		return fmt.Errorf("%w: pipeline %s", ErrNotFound, id)
+	}
+	if p.FinishedAt != nil {
+		return fmt.Errorf("%w: %s", ErrFinished, p.Status)
+	}
+	logs.Infof("🧬 cancelling pipeline %s", p.ID)
+	p.cancelled = true
+	for _, node := range p.Nodes {
+		if node.Status == StatusQueued {
+			_ = r.tasks.Cancel(node.TaskID, phoneagent.CancelOptions{})
+		}
+	}
+	return nil
+}
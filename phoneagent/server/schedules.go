@@ -0,0 +1,454 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"autoglm-go/phoneagent"
+	"autoglm-go/phoneagent/cron"
+	"autoglm-go/phoneagent/labels"
+	"autoglm-go/phoneagent/session"
+	"github.com/google/uuid"
+	logs "github.com/sirupsen/logrus"
+)
+
+// ScheduleLabel is the label carrying the schedule id on the tasks of a
+// schedule.
+const ScheduleLabel = "schedule"
+
+// maxRuns is how many runs of a schedule are kept, oldest are dropped first.
+const maxRuns = 50
+
+// Grouper resolves device groups, implemented by group.Tree.
+type Grouper interface {
+	Devices(id string) []string
+}
+
+// ScheduleRequest is the body of POST /api/schedules and PUT
+// /api/schedules/{id}.
+type ScheduleRequest struct {
+	Name string `json:"name,omitempty"`
+	// Cron is when the task runs, as a five field cron expression, see
+	// cron.Parse: "0 8 * * *" runs it every morning at 8:00.
+	Cron     string `json:"cron"`
+	Timezone string `json:"timezone,omitempty"` // of Cron, e.g. Asia/Shanghai, the local time of the server by default
+	// DeviceID is the device the task runs on. Group runs it on every device
+	// of the group and its subgroups instead, neither picks a device of the
+	// pool of Tenant.
+	DeviceID    string        `json:"device_id,omitempty"`
+	Group       string        `json:"group,omitempty"`
+	Tenant      string        `json:"tenant,omitempty"`
+	Instruction string        `json:"instruction"`
+	Labels      labels.Labels `json:"labels,omitempty"`
+	Priority    string        `json:"priority,omitempty"`
+	Paused      bool          `json:"paused"`
+	// ModelProfile is the model profile of the tasks, as in a TaskRequest.
+	ModelProfile string `json:"model_profile,omitempty"`
+}
+
+// RunTask is the task a run of a schedule submitted for one device.
+type RunTask struct {
+	DeviceID string `json:"device_id,omitempty"`
+	TaskID   string `json:"task_id,omitempty"`
+	Status   Status `json:"status"`
+	Message  string `json:"message,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ScheduleRun is a run of a schedule.
+type ScheduleRun struct {
+	At    time.Time `json:"at"`
+	Tasks []RunTask `json:"tasks"`
+}
+
+// ScheduleView is a schedule and its runs, as returned by the schedule API.
+type ScheduleView struct {
+	ID string `json:"id"`
+	ScheduleRequest
+	CreatedAt time.Time     `json:"created_at"`
+	NextRun   *time.Time    `json:"next_run,omitempty"` // nil when paused
+	Runs      []ScheduleRun `json:"runs,omitempty"`     // oldest first, left out of lists
+}
+
+type schedule struct {
+	ScheduleView
+	cron     *cron.Schedule
+	location *time.Location
+}
+
+// Schedules submits tasks on their cron schedules through Tasks and keeps
+// the schedules and their runs in a file, so that they survive restarts.
+// Runs missed while the server was down are skipped.
+type Schedules struct {
+	tasks     *Tasks
+	submitter Submitter
+	groups    Grouper // nil rejects schedules of a group
+	filename  string  // empty keeps the schedules in memory only
+	wake      chan struct{}
+
+	mu        sync.Mutex
+	schedules map[string]*schedule
+	order     []string // schedule ids, oldest first
+}
+
+// LoadSchedules reads the schedules file at path, a missing file has none.
+// Tasks of runs that had not ended when the server stopped are marked
+// interrupted.
+func LoadSchedules(path string, tasks *Tasks, submitter Submitter, groups Grouper) (*Schedules, error) {
+	r := &Schedules{
+		tasks:     tasks,
+		submitter: submitter,
+		groups:    groups,
+		filename:  path,
+		wake:      make(chan struct{}, 1),
+		schedules: map[string]*schedule{},
+	}
+	if path == "" {
+		return r, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var views []ScheduleView
+	if err := json.Unmarshal(data, &views); err != nil {
+		return nil, fmt.Errorf("invalid schedules file %s: %w", path, err)
+	}
+	for _, view := range views {
+		s, err := r.parse(view.ScheduleRequest)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %s in %s: %w", view.ID, path, err)
+		}
+		s.ID, s.CreatedAt, s.Runs = view.ID, view.CreatedAt, view.Runs
+		for i := range s.Runs {
+			for j := range s.Runs[i].Tasks {
+				if task := &s.Runs[i].Tasks[j]; task.Status == StatusQueued {
+					task.Status = StatusInterrupted
+				}
+			}
+		}
+		s.plan(time.Now())
+		r.schedules[s.ID] = s
+		r.order = append(r.order, s.ID)
+	}
+	return r, nil
+}
+
+// parse validates req.
+func (r *Schedules) parse(req ScheduleRequest) (*schedule, error) {
+	if strings.TrimSpace(req.Instruction) == "" {
+		return nil, fmt.Errorf("instruction is required")
+	}
+	parsed, err := cron.Parse(req.Cron)
+	if err != nil {
+		return nil, err
+	}
+	location := time.Local
+	if req.Timezone != "" {
+		if location, err = time.LoadLocation(req.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %w", req.Timezone, err)
+		}
+	}
+	switch {
+	case req.DeviceID != "" && req.Group != "":
+		return nil, fmt.Errorf("device_id and group are mutually exclusive")
+	case req.DeviceID == "" && req.Group == "" && req.Tenant == "":
+		return nil, fmt.Errorf("device_id, group or tenant is required")
+	}
+	if req.Group != "" && r.groups == nil {
+		return nil, fmt.Errorf("scheduling on a group requires --groups-file")
+	}
+	if err := req.Labels.Validate(); err != nil {
+		return nil, err
+	}
+	if _, err := session.ParsePriority(req.Priority); err != nil {
+		return nil, err
+	}
+	if _, ok := phoneagent.LookupModelProfile(req.ModelProfile); req.ModelProfile != "" && !ok {
+		return nil, fmt.Errorf("unknown model profile %q", req.ModelProfile)
+	}
+	return &schedule{ScheduleView: ScheduleView{ScheduleRequest: req}, cron: parsed, location: location}, nil
+}
+
+// plan sets the next run after now.
+func (s *schedule) plan(now time.Time) {
+	s.NextRun = nil
+	if s.Paused {
+		return
+	}
+	if next := s.cron.Next(now.In(s.location)); !next.IsZero() {
+		s.NextRun = &next
+	}
+}
+
+// Run submits the tasks of the schedules as they are due, until ctx is done.
+func (r *Schedules) Run(ctx context.Context) {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		r.mu.Lock()
+		now := time.Now()
+		wait := time.Hour
+		for _, id := range r.order {
+			s := r.schedules[id]
+			if s.NextRun == nil {
+				continue
+			}
+			if !s.NextRun.After(now) {
+				r.fire(s, now)
+				s.plan(now)
+				r.saveOrWarn()
+			}
+			if s.NextRun != nil && s.NextRun.Sub(now) < wait {
+				wait = s.NextRun.Sub(now)
+			}
+		}
+		r.mu.Unlock()
+
+		timer.Reset(wait)
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.wake:
+		case <-timer.C:
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+	}
+}
+
+// fire submits the tasks of a run of s, it must be called with r.mu held.
+func (r *Schedules) fire(s *schedule, at time.Time) ScheduleRun {
+	deviceIDs := []string{s.DeviceID}
+	if s.Group != "" {
+		deviceIDs = r.groups.Devices(s.Group)
+	}
+	run := ScheduleRun{At: at}
+	if len(deviceIDs) == 0 {
+		run.Tasks = append(run.Tasks, RunTask{Status: StatusFailed, Error: fmt.Sprintf("group %s has no devices", s.Group)})
+	}
+	for _, deviceID := range deviceIDs {
+		tagged := labels.Labels{}
+		maps.Copy(tagged, s.Labels)
+		tagged[ScheduleLabel] = s.ID
+		view, _, err := r.tasks.Submit(r.submitter, TaskRequest{
+			DeviceID:     deviceID,
+			Tenant:       s.Tenant,
+			Instruction:  s.Instruction,
+			Force:        true, // a recurring task is meant to run again
+			Labels:       tagged,
+			Priority:     s.Priority,
+			ModelProfile: s.ModelProfile,
+		})
+		if err != nil {
+			logs.Warnf("⏰ schedule %s: task not submitted on %s, err: %v", s.ID, deviceID, err)
+			run.Tasks = append(run.Tasks, RunTask{DeviceID: deviceID, Status: StatusFailed, Error: err.Error()})
+			continue
+		}
+		run.Tasks = append(run.Tasks, RunTask{DeviceID: view.DeviceID, TaskID: view.ID, Status: StatusQueued})
+		go r.follow(s.ID, view.ID)
+	}
+	logs.Infof("⏰ schedule %s ran: %d task(s)", s.ID, len(run.Tasks))
+
+	s.Runs = append(s.Runs, run)
+	if len(s.Runs) > maxRuns {
+		s.Runs = s.Runs[len(s.Runs)-maxRuns:]
+	}
+	return run
+}
+
+// follow records the end of a task of a run.
+func (r *Schedules) follow(scheduleID, taskID string) {
+	view, err := r.tasks.await(taskID)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.schedules[scheduleID]
+	if !ok {
+		return
+	}
+	for i := len(s.Runs) - 1; i >= 0; i-- {
+		for j := range s.Runs[i].Tasks {
+			if task := &s.Runs[i].Tasks[j]; task.TaskID == taskID {
+				task.Status, task.Message, task.Error = view.Status, view.Message, view.Error
+				r.saveOrWarn()
+				return
+			}
+		}
+	}
+}
+
+func (r *Schedules) notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Create adds a schedule.
+func (r *Schedules) Create(req ScheduleRequest) (ScheduleView, error) {
+	s, err := r.parse(req)
+	if err != nil {
+		return ScheduleView{}, err
+	}
+	s.ID = uuid.New().String()
+	s.CreatedAt = time.Now()
+	s.plan(s.CreatedAt)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.schedules[s.ID] = s
+	r.order = append(r.order, s.ID)
+	if err := r.save(); err != nil {
+		delete(r.schedules, s.ID)
+		r.order = r.order[:len(r.order)-1]
+		return ScheduleView{}, err
+	}
+	r.notify()
+	logs.Infof("⏰ schedule %s created: %q %s", s.ID, s.Cron, s.Instruction)
+	return s.snapshot(true), nil
+}
+
+// Update replaces the definition of a schedule, its runs are kept.
+func (r *Schedules) Update(id string, req ScheduleRequest) (ScheduleView, error) {
+	parsed, err := r.parse(req)
+	if err != nil {
+		return ScheduleView{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.schedules[id]
+	if !ok {
+		return ScheduleView{}, fmt.Errorf("%w: schedule %s", ErrNotFound, id)
+	}
+	previous := *s
+	s.ScheduleRequest, s.cron, s.location = parsed.ScheduleRequest, parsed.cron, parsed.location
+	s.plan(time.Now())
+	if err := r.save(); err != nil {
+		*s = previous
+		return ScheduleView{}, err
+	}
+	r.notify()
+	return s.snapshot(true), nil
+}
+
+// Delete removes a schedule, tasks it already submitted go on.
+func (r *Schedules) Delete(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.schedules[id]; !ok {
+		return fmt.Errorf("%w: schedule %s", ErrNotFound, id)
+	}
+	delete(r.schedules, id)
+	for i, other := range r.order {
+		if other == id {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+	return r.save()
+}
+
+// RunNow submits the tasks of a schedule at once, paused or not, without
+// moving its next run.
+func (r *Schedules) RunNow(id string) (ScheduleRun, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.schedules[id]
+	if !ok {
+		return ScheduleRun{}, fmt.Errorf("%w: schedule %s", ErrNotFound, id)
+	}
+	run := r.fire(s, time.Now())
+	r.saveOrWarn()
+	run.Tasks = append([]RunTask{}, run.Tasks...) // followed under r.mu
+	return run, nil
+}
+
+// snapshot copies the schedule, with its runs when runs is set. It must be
+// called with the mutex of the Schedules held.
+func (s *schedule) snapshot(runs bool) ScheduleView {
+	view := s.ScheduleView
+	view.Runs = nil
+	if runs {
+		for _, run := range s.Runs {
+			run.Tasks = append([]RunTask{}, run.Tasks...)
+			view.Runs = append(view.Runs, run)
+		}
+	}
+	return view
+}
+
+// Get returns a schedule with its runs.
+func (r *Schedules) Get(id string) (ScheduleView, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.schedules[id]
+	if !ok {
+		return ScheduleView{}, false
+	}
+	return s.snapshot(true), true
+}
+
+// List returns the schedules without their runs, oldest first.
+func (r *Schedules) List() []ScheduleView {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	views := make([]ScheduleView, 0, len(r.order))
+	for _, id := range r.order {
+		views = append(views, r.schedules[id].snapshot(false))
+	}
+	return views
+}
+
+// save must be called with r.mu held.
+func (r *Schedules) save() error {
+	if r.filename == "" {
+		return nil
+	}
+	views := make([]ScheduleView, 0, len(r.order))
+	for _, id := range r.order {
+		views = append(views, r.schedules[id].ScheduleView)
+	}
+	data, err := json.MarshalIndent(views, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(r.filename), ".schedules-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), r.filename)
+}
+
+// saveOrWarn must be called with r.mu held.
+func (r *Schedules) saveOrWarn() {
+	if err := r.save(); err != nil {
+		logs.Warnf("⏰ failed to save the schedules to %s, err: %v", r.filename, err)
+	}
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultShareTTL is how long a share link is valid unless asked.
+	defaultShareTTL = time.Hour
+	// maxShareTTL bounds the validity of a link, it cannot be revoked.
+	maxShareTTL = 7 * 24 * time.Hour
+)
+
+// ErrShareExpired is returned for a share link past its expiry.
+var ErrShareExpired = errors.New("share link expired")
+
+// ShareRequest is the body of POST /api/tasks/{id}/share.
+type ShareRequest struct {
+	// TTL is the seconds the link is valid, an hour by default and a week
+	// at most.
+	TTL float64 `json:"ttl,omitempty"`
+	// Images false leaves the screenshots out of the view.
+	Images *bool `json:"images,omitempty"`
+}
+
+// ShareLink is a read-only live view of a task, open to whoever has it
+// until ExpiresAt.
+type ShareLink struct {
+	TaskID    string    `json:"task_id"`
+	URL       string    `json:"url"` // path of the view on this server, /share/{token}
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// share is what a share token carries.
+type share struct {
+	TaskID  string `json:"t"`
+	Expires int64  `json:"e"` // unix seconds
+	Images  bool   `json:"i"`
+}
+
+// Shares signs and checks share links. They hold no state: a link is valid
+// until it expires, for any server with the same secret.
+type Shares struct {
+	secret []byte
+}
+
+// NewShares signs links with secret. An empty secret is drawn at random,
+// the links then end with the process.
+func NewShares(secret string) *Shares {
+	key := []byte(secret)
+	if secret == "" {
+		key = make([]byte, 32)
+		_, _ = rand.Read(key)
+	}
+	return &Shares{secret: key}
+}
+
+// Link returns a share link of the task id, see ShareRequest.
+func (r *Shares) Link(id string, req ShareRequest, now time.Time) (ShareLink, error) {
+	ttl := defaultShareTTL
+	if req.TTL != 0 {
+		ttl = time.Duration(req.TTL * float64(time.Second))
+	}
+	if ttl <= 0 || ttl > maxShareTTL {
+		return ShareLink{}, fmt.Errorf("ttl must be positive and at most %.0f seconds", maxShareTTL.Seconds())
+	}
+	expires := now.Add(ttl).Truncate(time.Second)
+	s := share{TaskID: id, Expires: expires.Unix(), Images: req.Images == nil || *req.Images}
+	payload, _ := json.Marshal(s)
+	token := base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(r.sign(payload))
+	return ShareLink{TaskID: id, URL: "/share/" + token, ExpiresAt: expires}, nil
+}
+
+func (r *Shares) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, r.secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// verify returns the share of a token signed by r and not expired at now.
+func (r *Shares) verify(token string, now time.Time) (share, error) {
+	encoded, sig, ok := strings.Cut(token, ".")
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if !ok || err != nil {
+		return share{}, ErrNotFound
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, r.sign(payload)) {
+		return share{}, ErrNotFound
+	}
+	var s share
+	if err := json.Unmarshal(payload, &s); err != nil {
+		return share{}, ErrNotFound
+	}
+	if !now.Before(time.Unix(s.Expires, 0)) {
+		return share{}, ErrShareExpired
+	}
+	return s, nil
+}
+
+// handleShares adds the share API to mux.
+//
+//	POST /api/tasks/{id}/share    create a ShareLink from a ShareRequest
+//	GET  /share/{token}           the live view page
+//	GET  /share/{token}/task      the task, as GET /api/tasks/{id}
+//	GET  /share/{token}/events    its events, as GET /api/tasks/{id}/events
+func handleShares(mux *http.ServeMux, tasks *Tasks, shares *Shares) {
+	mux.HandleFunc("POST /api/tasks/{id}/share", func(w http.ResponseWriter, req *http.Request) {
+		var body ShareRequest
+		// the body is optional
+		if req.ContentLength != 0 && !readJSON(w, req, &body) {
+			return
+		}
+		id := req.PathValue("id")
+		if view, ok := tasks.Get(id); !ok || !allowed(req.Context(), taskTarget(view)) {
+			http.Error(w, "task not found", http.StatusNotFound)
+			return
+		}
+		link, err := shares.Link(id, body, time.Now())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, link)
+	})
+	// verified resolves the token of the path, answering the request when
+	// it is not valid.
+	verified := func(w http.ResponseWriter, req *http.Request) (share, bool) {
+		s, err := shares.verify(req.PathValue("token"), time.Now())
+		switch {
+		case errors.Is(err, ErrShareExpired):
+			http.Error(w, err.Error(), http.StatusGone)
+			return share{}, false
+		case err != nil:
+			http.Error(w, "share link not found", http.StatusNotFound)
+			return share{}, false
+		}
+		return s, true
+	}
+	mux.HandleFunc("GET /share/{token}", func(w http.ResponseWriter, req *http.Request) {
+		if _, ok := verified(w, req); !ok {
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		fmt.Fprint(w, sharePage)
+	})
+	mux.HandleFunc("GET /share/{token}/task", func(w http.ResponseWriter, req *http.Request) {
+		s, ok := verified(w, req)
+		if !ok {
+			return
+		}
+		view, ok := tasks.Get(s.TaskID)
+		if !ok {
+			http.Error(w, "task not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, view)
+	})
+	mux.HandleFunc("GET /share/{token}/events", func(w http.ResponseWriter, req *http.Request) {
+		s, ok := verified(w, req)
+		if !ok {
+			return
+		}
+		images := s.Images && req.URL.Query().Get("images") != "false"
+		serveEvents(w, req, tasks, s.TaskID, images, time.Unix(s.Expires, 0))
+	})
+}
+
+// sharePage shows the screen, status and steps of the shared task as they
+// come, from the events next to it.
+const sharePage = `<!doctype html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width">
+<title>task</title>
+<style>body{font-family:sans-serif;margin:12px;display:flex;gap:16px;align-items:flex-start}
+img{max-height:85vh;border:1px solid #888}#log{flex:1;font-size:14px}#log div{margin:4px 0}
+.fail{color:#b00}#status{font-weight:bold}#thinking{color:#666;white-space:pre-wrap}</style>
+</head><body>
+<img id="screen" alt="">
+<div id="log"><p id="status">connecting…</p><p id="thinking"></p></div>
+<script>
+const base = location.pathname.replace(/\/$/, '');
+const screen = document.getElementById('screen'), log = document.getElementById('log');
+const status = document.getElementById('status'), thinking = document.getElementById('thinking');
+function line(text, cls) {
+  const d = document.createElement('div');
+  d.textContent = text;
+  if (cls) d.className = cls;
+  log.appendChild(d);
+}
+function showStatus(t) {
+  status.textContent = t.instruction + ' — ' + t.status + (t.completion ? ' ' + t.completion.percent + '%' : '') + (t.message ? ': ' + t.message : '') + (t.error ? ': ' + t.error : '');
+}
+const events = new EventSource(base + '/events');
+events.addEventListener('status', e => showStatus(JSON.parse(e.data)));
+events.addEventListener('done', e => { showStatus(JSON.parse(e.data)); events.close(); });
+events.addEventListener('screenshot', e => {
+  const d = JSON.parse(e.data);
+  if (d.image) screen.src = d.image;
+  thinking.textContent = '';
+});
+events.addEventListener('thinking', e => { thinking.textContent += JSON.parse(e.data).delta; });
+events.addEventListener('action', e => {
+  const d = JSON.parse(e.data);
+  line('step ' + d.step + ': ' + JSON.stringify(d.action));
+});
+events.addEventListener('action_result', e => {
+  const d = JSON.parse(e.data);
+  if (!d.success || d.message) line('  ' + (d.success ? '' : 'failed ') + (d.message || ''), d.success ? '' : 'fail');
+});
+events.addEventListener('confirmation', e => line('waiting for a confirmation: ' + (JSON.parse(e.data).message || '')));
+events.addEventListener('progress', e => line(JSON.parse(e.data).message));
+events.addEventListener('plan', e => { const p = JSON.parse(e.data); line('subgoal ' + p.subgoal + '/' + p.plan.length + ': ' + p.plan[p.subgoal - 1]); });
+events.addEventListener('anomaly', e => line(JSON.parse(e.data).message, 'fail'));
+events.onerror = () => { if (events.readyState === EventSource.CLOSED) status.textContent += ' (disconnected)'; };
+</script>
+</body></html>`
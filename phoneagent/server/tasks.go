@@ -0,0 +1,493 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+
+	"autoglm-go/phoneagent"
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/labels"
+	"autoglm-go/phoneagent/llm"
+	"autoglm-go/phoneagent/session"
+	logs "github.com/sirupsen/logrus"
+)
+
+// ErrNotFound is returned for unknown task ids.
+var ErrNotFound = errors.New("not found")
+
+// ErrFinished is returned when cancelling a task that already ended.
+var ErrFinished = errors.New("task already finished")
+
+// maxTasks is how many tasks are kept for GET /api/tasks, oldest finished
+// ones are forgotten first.
+const maxTasks = 1000
+
+type Status string
+
+const (
+	StatusQueued      Status = "queued"
+	StatusWaiting     Status = "waiting" // for the device to reconnect
+	StatusRunning     Status = "running"
+	StatusPaused      Status = "paused" // between two steps, see Tasks.Pause
+	StatusSucceeded   Status = "succeeded"
+	StatusFailed      Status = "failed"
+	StatusCancelled   Status = "cancelled"
+	StatusInterrupted Status = "interrupted" // by the shutdown of the server
+)
+
+// Submitter queues tasks, implemented by session.Manager.
+type Submitter interface {
+	SubmitWithKey(ctx context.Context, key, deviceID, instruction string) (*session.Task, <-chan *session.Result, error)
+}
+
+// Controller pauses, resumes and cancels running tasks, implemented by
+// session.Manager. The tasks of a Submitter that is not one can only be
+// cancelled through their context.
+type Controller interface {
+	Pause(taskID string) error
+	Resume(taskID string) error
+	Cancel(taskID string, opts phoneagent.CancelOptions) error
+}
+
+// ErrNotSupported is returned when pausing a task of a Submitter that is not
+// a Controller.
+var ErrNotSupported = errors.New("not supported")
+
+// TaskRequest is the body of POST /api/tasks.
+type TaskRequest struct {
+	// DeviceID may be left out for a tenant with a device pool, the least
+	// busy device of the pool is picked, see session.Options.Tenants.
+	DeviceID    string        `json:"device_id"`
+	Tenant      string        `json:"tenant,omitempty"` // sets the session.TenantLabel label
+	Instruction string        `json:"instruction"`
+	Force       bool          `json:"force"`  // run again even if the same task has just run on the device
+	Labels      labels.Labels `json:"labels"` // attached to the logs and usage of the task
+	// Priority is low, normal, high or urgent: queued tasks of a higher one
+	// start first, on their device and for the workers. Normal by default.
+	Priority string `json:"priority,omitempty"`
+	// OutputSchema declares fields extracted from the result once the task
+	// finished, e.g. {"price": "number", "eta": "string"}, see TaskView.Output.
+	OutputSchema phoneagent.OutputSchema `json:"output_schema,omitempty"`
+	// IdempotencyKey makes retried requests return the task the first one
+	// started, the Idempotency-Key header sets it as well.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// SoftDeadline is the seconds after which a running task reports its
+	// progress to the webhooks, see phoneagent.WithSoftDeadline.
+	SoftDeadline float64 `json:"soft_deadline,omitempty"`
+	// ModelProfile names the model profile the task runs with, see
+	// phoneagent.UseModelProfiles. The agent's by default.
+	ModelProfile string `json:"model_profile,omitempty"`
+	// MaxSteps, StepTimeout in seconds and MaxRepeats override the limits
+	// of the agent for the task, see phoneagent.WithStepLimits.
+	MaxSteps    int     `json:"max_steps,omitempty"`
+	StepTimeout float64 `json:"step_timeout,omitempty"`
+	MaxRepeats  int     `json:"max_repeats,omitempty"`
+	// MaxTokens and MaxCost override the budget of the agent for the task,
+	// see phoneagent.WithBudget.
+	MaxTokens int     `json:"max_tokens,omitempty"`
+	MaxCost   float64 `json:"max_cost,omitempty"`
+	// ReadOnly only lets the task observe the device, see
+	// phoneagent.WithReadOnly. The tasks of an observe API key always are.
+	ReadOnly bool `json:"read_only,omitempty"`
+}
+
+// Step is a step of a task, as returned by GET /api/tasks/{id}.
+type Step struct {
+	Step    int           `json:"step"`
+	App     string        `json:"app,omitempty"`
+	Action  helper.Action `json:"action"`
+	Success bool          `json:"success"`
+	Message string        `json:"message,omitempty"`
+	At      time.Time     `json:"at"`
+}
+
+// TaskView is a task and its progress, as returned by the task API.
+type TaskView struct {
+	ID          string              `json:"id"`
+	DeviceID    string              `json:"device_id"`
+	Instruction string              `json:"instruction"`
+	Labels      labels.Labels       `json:"labels,omitempty"`
+	Priority    string              `json:"priority"`
+	Status      Status              `json:"status"`
+	Message     string              `json:"message,omitempty"`
+	Output      map[string]any      `json:"output,omitempty"` // fields of TaskRequest.OutputSchema, null when not found
+	Outcome     *phoneagent.Outcome `json:"outcome,omitempty"`
+	Error       string              `json:"error,omitempty"`
+	StepCount   int                 `json:"step_count"`
+	Steps       []Step              `json:"steps,omitempty"` // left out of lists
+	Usage       Usage               `json:"usage"`
+	SubmittedAt time.Time           `json:"submitted_at"`
+	StartedAt   *time.Time          `json:"started_at,omitempty"`
+	FinishedAt  *time.Time          `json:"finished_at,omitempty"`
+	// ModelProfile is the model profile of the TaskRequest.
+	ModelProfile string `json:"model_profile,omitempty"`
+	ReadOnly     bool   `json:"read_only,omitempty"` // of the TaskRequest
+	// Confirmation is the sensitive action or takeover the running task
+	// waits for an answer to, see Tasks.Confirm.
+	Confirmation *phoneagent.ConfirmRequest `json:"confirmation,omitempty"`
+	// Completion is how much of the task is estimated done, absent while it
+	// cannot be told, see estimateCompletion.
+	Completion *Completion `json:"completion,omitempty"`
+	// Plan is the subgoals the planner split the task into and Subgoal the
+	// one in progress, from 1, see phoneagent.EventPlan.
+	Plan    []string `json:"plan,omitempty"`
+	Subgoal int      `json:"subgoal,omitempty"`
+}
+
+// Usage is the model usage of a task.
+type Usage struct {
+	Requests         int     `json:"requests"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+func usageOf(u llm.ModelUsage) Usage {
+	return Usage{Requests: u.Requests, PromptTokens: u.PromptTokens, CompletionTokens: u.CompletionTokens, Cost: u.Cost}
+}
+
+type task struct {
+	TaskView
+	cancel      context.CancelFunc
+	controller  Controller              // of the submitter, nil when it is none
+	done        chan struct{}           // closed once the task ended
+	expected    int                     // steps of similar past tasks, see Tasks.expectedSteps
+	subscribers map[chan Event]struct{} // of the event stream, see Subscribe
+
+	seq     uint64  // ID of the last event published
+	backlog []Event // the last events, see backlogSize
+}
+
+// Tasks submits tasks on behalf of API clients and keeps their progress. Its
+// Notify, OnStep and OnEvent methods, and itself as the Confirmer, must be
+// given to the session.Options of the manager tasks are submitted to.
+type Tasks struct {
+	ctx context.Context // tasks end with it
+
+	mu    sync.Mutex
+	tasks map[string]*task
+	order []string // task ids, oldest first
+
+	confirmations map[string]*confirmation // pending, by id
+}
+
+func NewTasks(ctx context.Context) *Tasks {
+	return &Tasks{ctx: ctx, tasks: map[string]*task{}, confirmations: map[string]*confirmation{}}
+}
+
+// Submit queues the task and follows it in the background. The same task
+// submitted again, see session.Options.DuplicateWindow, or with a known
+// idempotency key returns the task already kept, with created false.
+func (r *Tasks) Submit(submitter Submitter, req TaskRequest) (view TaskView, created bool, err error) {
+	req.DeviceID = strings.TrimSpace(req.DeviceID)
+	if strings.TrimSpace(req.Instruction) == "" {
+		return TaskView{}, false, fmt.Errorf("instruction is required")
+	}
+	if tenant, ok := req.Labels[session.TenantLabel]; ok && req.Tenant != "" && tenant != req.Tenant {
+		return TaskView{}, false, fmt.Errorf("tenant %q does not match the %s label %q", req.Tenant, session.TenantLabel, tenant)
+	}
+	if req.Tenant != "" {
+		tagged := labels.Labels{}
+		maps.Copy(tagged, req.Labels)
+		tagged[session.TenantLabel] = req.Tenant
+		req.Labels = tagged
+	}
+	if err := req.Labels.Validate(); err != nil {
+		return TaskView{}, false, err
+	}
+	priority, err := session.ParsePriority(req.Priority)
+	if err != nil {
+		return TaskView{}, false, err
+	}
+	if err := req.OutputSchema.Validate(); err != nil {
+		return TaskView{}, false, err
+	}
+	if req.SoftDeadline < 0 {
+		return TaskView{}, false, fmt.Errorf("soft_deadline must not be negative")
+	}
+	if req.MaxSteps < 0 || req.StepTimeout < 0 || req.MaxRepeats < 0 {
+		return TaskView{}, false, fmt.Errorf("max_steps, step_timeout and max_repeats must not be negative")
+	}
+	if req.MaxTokens < 0 || req.MaxCost < 0 {
+		return TaskView{}, false, fmt.Errorf("max_tokens and max_cost must not be negative")
+	}
+	if _, ok := phoneagent.LookupModelProfile(req.ModelProfile); req.ModelProfile != "" && !ok {
+		return TaskView{}, false, fmt.Errorf("unknown model profile %q", req.ModelProfile)
+	}
+
+	ctx, cancel := context.WithCancel(r.ctx)
+	ctx = session.WithPriority(ctx, priority)
+	ctx = phoneagent.WithOutputSchema(ctx, req.OutputSchema)
+	ctx = phoneagent.WithSoftDeadline(ctx, time.Duration(req.SoftDeadline*float64(time.Second)))
+	ctx = phoneagent.WithModelProfile(ctx, req.ModelProfile)
+	ctx = phoneagent.WithStepLimits(ctx, phoneagent.StepLimits{
+		MaxSteps:    req.MaxSteps,
+		StepTimeout: time.Duration(req.StepTimeout * float64(time.Second)),
+		MaxRepeats:  req.MaxRepeats,
+	})
+	ctx = phoneagent.WithBudget(ctx, phoneagent.Budget{MaxTokens: req.MaxTokens, MaxCost: req.MaxCost})
+	if req.Force {
+		ctx = session.WithForce(ctx)
+	}
+	if req.ReadOnly {
+		ctx = phoneagent.WithReadOnly(ctx)
+	}
+	if len(req.Labels) > 0 {
+		ctx = labels.With(ctx, req.Labels)
+	}
+
+	// held across the submission, so that Notify finds the task
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	submitted, results, err := submitter.SubmitWithKey(ctx, req.IdempotencyKey, req.DeviceID, req.Instruction)
+	if err != nil {
+		cancel()
+		return TaskView{}, false, err
+	}
+	if existing, ok := r.tasks[submitted.ID]; ok {
+		cancel()
+		return existing.snapshot(false), false, nil
+	}
+
+	t := &task{
+		TaskView: TaskView{
+			ID:          submitted.ID,
+			DeviceID:    submitted.DeviceID,
+			Instruction: submitted.Instruction,
+			Labels:      submitted.Labels,
+			Priority:    submitted.Priority.String(),
+			Status:      StatusQueued,
+			SubmittedAt: submitted.SubmittedAt,
+		},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	t.ModelProfile = req.ModelProfile
+	t.ReadOnly = req.ReadOnly
+	t.controller, _ = submitter.(Controller)
+	r.tasks[t.ID] = t
+	r.order = append(r.order, t.ID)
+	r.forget()
+
+	logs.WithFields(submitted.Labels.Fields()).Infof("🛰️ task %s queued on %s: %s", t.ID, t.DeviceID, t.Instruction)
+	go r.wait(t, results)
+	return t.snapshot(true), true, nil
+}
+
+func (r *Tasks) wait(t *task, results <-chan *session.Result) {
+	result := <-results
+	t.cancel()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	finished := result.FinishedAt
+	t.FinishedAt = &finished
+	if !result.StartedAt.IsZero() && t.StartedAt == nil {
+		started := result.StartedAt
+		t.StartedAt = &started
+	}
+	t.Message = result.Message
+	t.Confirmation = nil
+	t.Output = result.Output
+	t.Outcome = result.Outcome
+	t.StepCount = result.Steps
+	t.Usage = usageOf(result.Usage)
+	switch err := result.Err; {
+	case err == nil:
+		t.Status = StatusSucceeded
+		if t.Completion != nil {
+			// a copy, the views handed out share the previous one
+			done := *t.Completion
+			done.Percent = 100
+			t.Completion = &done
+		}
+	case errors.Is(err, session.ErrInterrupted):
+		t.Status = StatusInterrupted
+		t.Error = err.Error()
+	case errors.Is(err, phoneagent.ErrTaskCancelled), errors.Is(err, context.Canceled):
+		t.Status = StatusCancelled
+		t.Error = err.Error()
+	default:
+		t.Status = StatusFailed
+		t.Error = err.Error()
+	}
+	r.closeSubscribers(t)
+	close(t.done)
+	logs.WithFields(t.Labels.Fields()).Infof("🛰️ task %s %s after %d step(s)", t.ID, t.Status, t.StepCount)
+}
+
+// Notify follows the state of the tasks, for session.Options.Notify.
+func (r *Tasks) Notify(submitted *session.Task, event session.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.tasks[submitted.ID]
+	if !ok || t.FinishedAt != nil {
+		return
+	}
+	switch event {
+	case session.EventPending:
+		t.Status = StatusWaiting
+	case session.EventStarted:
+		now := time.Now()
+		t.Status = StatusRunning
+		t.StartedAt = &now
+		t.expected = r.expectedSteps(t.Instruction)
+		t.Completion = estimateCompletion(0, t.expected, 0, 0)
+	default:
+		return
+	}
+	r.publish(t, Event{Name: "status", Data: t.snapshot(false)})
+}
+
+// OnStep keeps the steps of the tasks, for session.Options.OnStep.
+func (r *Tasks) OnStep(submitted *session.Task, info *phoneagent.StepInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.tasks[submitted.ID]
+	if !ok {
+		return
+	}
+	t.StepCount = info.Step
+	t.Steps = append(t.Steps, Step{
+		Step:    info.Step,
+		App:     info.CurrentApp,
+		Action:  maps.Clone(info.Action),
+		Success: info.Success,
+		Message: info.Message,
+		At:      time.Now(),
+	})
+	previous := t.Completion
+	t.Completion = estimateCompletion(info.Step, t.expected, info.Subgoal, info.Subgoals)
+	if t.Completion != nil && (previous == nil || *previous != *t.Completion) {
+		r.publish(t, Event{Name: "status", Data: t.snapshot(false)})
+	}
+}
+
+// forget drops the oldest finished tasks beyond maxTasks, it must be called
+// with r.mu held.
+func (r *Tasks) forget() {
+	for i := 0; len(r.order) > maxTasks && i < len(r.order); {
+		id := r.order[i]
+		if r.tasks[id].FinishedAt == nil {
+			i++
+			continue
+		}
+		delete(r.tasks, id)
+		r.order = append(r.order[:i], r.order[i+1:]...)
+	}
+}
+
+// snapshot copies the task, with its steps when steps is set. It must be
+// called with the mutex of the Tasks held.
+func (t *task) snapshot(steps bool) TaskView {
+	view := t.TaskView
+	view.Steps = nil
+	if steps {
+		view.Steps = append([]Step{}, t.Steps...)
+	}
+	return view
+}
+
+// await waits for the task to end and returns it without its steps.
+func (r *Tasks) await(id string) (TaskView, error) {
+	r.mu.Lock()
+	t, ok := r.tasks[id]
+	r.mu.Unlock()
+	if !ok {
+		return TaskView{}, fmt.Errorf("%w: task %s", ErrNotFound, id)
+	}
+	<-t.done
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return t.snapshot(false), nil
+}
+
+// Get returns a task with its steps.
+func (r *Tasks) Get(id string) (TaskView, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.tasks[id]
+	if !ok {
+		return TaskView{}, false
+	}
+	return t.snapshot(true), true
+}
+
+// List returns the kept tasks without their steps, newest first, those of
+// deviceID only unless it is empty.
+func (r *Tasks) List(deviceID string) []TaskView {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	views := make([]TaskView, 0, len(r.order))
+	for i := len(r.order) - 1; i >= 0; i-- {
+		t := r.tasks[r.order[i]]
+		if deviceID == "" || t.DeviceID == deviceID {
+			views = append(views, t.snapshot(false))
+		}
+	}
+	return views
+}
+
+// Cancel stops a task: a queued one is dropped when its turn comes, a running
+// one stops at once and cleans up its device, see phoneagent.CancelOptions.
+func (r *Tasks) Cancel(id string, opts phoneagent.CancelOptions) error {
+	t, err := r.unfinished(id)
+	if err != nil {
+		return err
+	}
+	logs.Infof("🛰️ cancelling task %s on %s", t.ID, t.DeviceID)
+	if t.controller != nil {
+		if err := t.controller.Cancel(id, opts); !errors.Is(err, session.ErrNotRunning) {
+			return err
+		}
+	}
+	t.cancel()
+	return nil
+}
+
+// Pause makes a running task wait once its current step is done, until
+// Resume or Cancel.
+func (r *Tasks) Pause(id string) error {
+	t, err := r.unfinished(id)
+	if err != nil {
+		return err
+	}
+	if t.controller == nil {
+		return fmt.Errorf("pausing tasks is %w", ErrNotSupported)
+	}
+	logs.Infof("🛰️ pausing task %s on %s", t.ID, t.DeviceID)
+	return t.controller.Pause(id)
+}
+
+// Resume lets a paused task go on.
+func (r *Tasks) Resume(id string) error {
+	t, err := r.unfinished(id)
+	if err != nil {
+		return err
+	}
+	if t.controller == nil {
+		return fmt.Errorf("resuming tasks is %w", ErrNotSupported)
+	}
+	logs.Infof("🛰️ resuming task %s on %s", t.ID, t.DeviceID)
+	return t.controller.Resume(id)
+}
+
+// unfinished returns the task that has not ended yet. The task is not locked,
+// its controller and cancel do not change.
+func (r *Tasks) unfinished(id string) (*task, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.tasks[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: task %s", ErrNotFound, id)
+	}
+	if t.FinishedAt != nil {
+		return nil, fmt.Errorf("%w: %s", ErrFinished, t.Status)
+	}
+	return t, nil
+}
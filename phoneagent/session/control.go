@@ -0,0 +1,61 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+
+	"autoglm-go/phoneagent"
+)
+
+// ErrNotRunning is returned when pausing, resuming or cancelling a task that
+// is not running, e.g. one still queued.
+var ErrNotRunning = errors.New("task is not running")
+
+// running returns the session running the task, nil when none does.
+func (r *Manager) running(taskID string) *Session {
+	r.mu.Lock()
+	sessions := make([]*Session, 0, len(r.sessions))
+	for _, s := range r.sessions {
+		sessions = append(sessions, s)
+	}
+	r.mu.Unlock()
+	for _, s := range sessions {
+		s.mu.Lock()
+		current := s.current
+		s.mu.Unlock()
+		if current != nil && current.ID == taskID {
+			return s
+		}
+	}
+	return nil
+}
+
+// control applies do to the agent running the task.
+func (r *Manager) control(taskID string, do func(agent *phoneagent.PhoneAgent) bool) error {
+	s := r.running(taskID)
+	if s == nil || !do(s.agent) {
+		return fmt.Errorf("%w: %s", ErrNotRunning, taskID)
+	}
+	return nil
+}
+
+// Pause makes a running task wait once its current step is done, see
+// phoneagent.PhoneAgent.PauseTask. A paused task keeps its device and its
+// worker.
+func (r *Manager) Pause(taskID string) error {
+	return r.control(taskID, (*phoneagent.PhoneAgent).PauseTask)
+}
+
+// Resume lets a paused task go on.
+func (r *Manager) Resume(taskID string) error {
+	return r.control(taskID, (*phoneagent.PhoneAgent).ResumeTask)
+}
+
+// Cancel stops a running task and cleans up its device, see
+// phoneagent.PhoneAgent.CancelTask. A queued task is cancelled with the
+// context it was submitted with.
+func (r *Manager) Cancel(taskID string, opts phoneagent.CancelOptions) error {
+	return r.control(taskID, func(agent *phoneagent.PhoneAgent) bool {
+		return agent.CancelTask(opts)
+	})
+}
@@ -0,0 +1,64 @@
+package session
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	logs "github.com/sirupsen/logrus"
+)
+
+type forceKey struct{}
+
+// WithForce returns ctx whose submissions skip the duplicate check, for tasks
+// that are meant to run again.
+func WithForce(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forceKey{}, true)
+}
+
+func forced(ctx context.Context) bool {
+	force, _ := ctx.Value(forceKey{}).(bool)
+	return force
+}
+
+// normalizeInstruction ignores case and spacing, "Order a coffee" and
+// "order  a coffee" are the same task.
+func normalizeInstruction(instruction string) string {
+	return strings.Join(strings.Fields(strings.ToLower(instruction)), " ")
+}
+
+// lookupDuplicate returns the task of deviceID with the same instruction that
+// is queued, running, or succeeded within Options.DuplicateWindow. Failed
+// tasks may be submitted again at once. It must be called with r.mu held.
+// Finished tasks past the window are dropped first.
+func (r *Manager) lookupDuplicate(deviceID, instruction string) (*keyedTask, bool) {
+	now := time.Now()
+	for k, recent := range r.recent {
+		if recent.finished() && now.Sub(recent.result.FinishedAt) > r.duplicateWindow {
+			delete(r.recent, k)
+		}
+	}
+
+	recent, ok := r.recent[keyOf(deviceID, normalizeInstruction(instruction))]
+	if !ok || (recent.finished() && recent.result.Err != nil) {
+		return nil, false
+	}
+	return recent, true
+}
+
+// rememberTask must be called with r.mu held.
+func (r *Manager) rememberTask(keyed *keyedTask) {
+	if r.duplicateWindow < 0 {
+		return
+	}
+	r.recent[keyOf(keyed.task.DeviceID, normalizeInstruction(keyed.task.Instruction))] = keyed
+}
+
+func warnDuplicate(existing *keyedTask) {
+	state := "is still running"
+	if existing.finished() {
+		state = "finished " + time.Since(existing.result.FinishedAt).Round(time.Second).String() + " ago"
+	}
+	logs.Warnf("[Session] device %s: the same task %s %s, returning it instead of running it again, submit with force to run it anyway",
+		existing.task.DeviceID, existing.task.ID, state)
+}
@@ -0,0 +1,81 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrIdempotencyConflict is returned when a key is reused for a different
+// instruction on the same device.
+var ErrIdempotencyConflict = errors.New("idempotency key already used for another task")
+
+// keyedTask is a task remembered by its idempotency key.
+type keyedTask struct {
+	task   *Task
+	done   chan struct{} // closed once result is set
+	result *Result
+}
+
+// wait returns a channel that receives the result of the task once it is
+// done, immediately if it already is.
+func (r *keyedTask) wait() <-chan *Result {
+	ch := make(chan *Result, 1)
+	go func() {
+		<-r.done
+		ch <- r.result
+	}()
+	return ch
+}
+
+func (r *keyedTask) finished() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func keyOf(deviceID, key string) string {
+	return deviceID + "\x00" + key
+}
+
+// SubmitWithKey is Submit for retried requests. A key already accepted for
+// deviceID within Options.IdempotencyTTL returns the task it started, and a
+// channel for its result, instead of queueing the instruction again. Keys are
+// remembered only once a task is queued, so a rejected request can be
+// retried with the same key.
+func (r *Manager) SubmitWithKey(ctx context.Context, key, deviceID, instruction string) (*Task, <-chan *Result, error) {
+	if key == "" {
+		return r.Submit(ctx, deviceID, instruction)
+	}
+
+	r.mu.Lock()
+	task, result, ok, err := r.lookupKey(deviceID, instruction, key)
+	r.mu.Unlock()
+	if ok || err != nil {
+		return task, result, err
+	}
+	return r.submit(ctx, deviceID, instruction, key)
+}
+
+// lookupKey must be called with r.mu held. Expired keys are dropped first.
+func (r *Manager) lookupKey(deviceID, instruction, key string) (*Task, <-chan *Result, bool, error) {
+	now := time.Now()
+	for k, keyed := range r.keys {
+		if now.Sub(keyed.task.SubmittedAt) > r.idempotencyTTL {
+			delete(r.keys, k)
+		}
+	}
+
+	keyed, ok := r.keys[keyOf(deviceID, key)]
+	if !ok {
+		return nil, nil, false, nil
+	}
+	if keyed.task.Instruction != instruction {
+		return nil, nil, false, fmt.Errorf("%w: %s", ErrIdempotencyConflict, key)
+	}
+	return keyed.task, keyed.wait(), true, nil
+}
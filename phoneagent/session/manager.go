@@ -0,0 +1,331 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"autoglm-go/phoneagent"
+	"autoglm-go/phoneagent/definitions"
+	"autoglm-go/phoneagent/health"
+	"autoglm-go/phoneagent/labels"
+	"autoglm-go/phoneagent/llm"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrManagerClosed = errors.New("session manager is closed")
+	ErrDeviceOffline = errors.New("device is offline")
+)
+
+type Options struct {
+	MaxWorkers          int // max tasks running at the same time across all devices
+	MaxInFlightRequests int // max concurrent model requests across all sessions, unless llm.UseLimiter set the limiter of the process
+	QueueSize           int // max queued tasks per device
+
+	// OfflineTTL is how long a task for an offline device waits for it to
+	// reconnect, 0 rejects such tasks at Submit.
+	OfflineTTL time.Duration
+	// Notify, when set, is told when a task starts waiting for its device and
+	// when it starts or expires. It is called from the session goroutine.
+	Notify func(task *Task, event Event)
+	// OnStep, when set, is told of every step of a task once its action has
+	// run. It is called from the session goroutine.
+	OnStep func(task *Task, info *phoneagent.StepInfo)
+	// OnEvent, when set, receives the events of the running tasks, see
+	// PhoneAgent.OnEvent. It must not block.
+	OnEvent func(task *Task, event phoneagent.Event)
+	// Confirmer, when set, answers the sensitive actions and takeovers of the
+	// tasks instead of the terminal, see PhoneAgent.Confirmer.
+	Confirmer phoneagent.Confirmer
+	// Middleware hooks into the steps of the tasks on every device, see
+	// PhoneAgent.Middleware.
+	Middleware []phoneagent.Middleware
+	// CheckpointFile, when set, is where Shutdown saves the unfinished tasks.
+	CheckpointFile string
+	// IdempotencyTTL is how long the key of a task is remembered after its
+	// submission, see SubmitWithKey. 24 hours by default.
+	IdempotencyTTL time.Duration
+	// DuplicateWindow is how long after it succeeded a task stands in for the
+	// same instruction submitted again for its device, as it does while it is
+	// queued or running. 5 minutes by default, negative disables the check.
+	// Submissions with a WithForce context always run.
+	DuplicateWindow time.Duration
+	// Tenants, when set, restricts tasks to the pool of the tenant of their
+	// TenantLabel and shares the workers among the tenants, see Tenant.
+	// Tasks of unknown tenants, or without the label, are rejected.
+	Tenants []Tenant
+	// Groups resolves the Tenant.Groups, nil ignores them.
+	Groups DeviceGroups
+	// Health, when set, answers whether the devices are online from its
+	// heartbeats instead of asking the driver for every task, and starts the
+	// tasks waiting for a device as soon as it comes back.
+	Health *health.Monitor
+}
+
+// Manager runs one Session per device. All sessions share the device driver,
+// the model config and the LLM limiter; history is kept per session.
+type Manager struct {
+	device      phoneagent.Device
+	modelConfig *definitions.ModelConfig
+	agentConfig definitions.AgentConfig
+	limiter     *llm.Limiter
+	navigation  *phoneagent.NavigationMap
+	scheduler   *scheduler
+	tenants     map[string]Tenant
+	tenantOrder []string
+	groups      DeviceGroups
+	queueSize   int
+	offlineTTL  time.Duration
+	notify      func(task *Task, event Event)
+	onStep      func(task *Task, info *phoneagent.StepInfo)
+	onEvent     func(task *Task, event phoneagent.Event)
+	confirmer   phoneagent.Confirmer
+	middleware  []phoneagent.Middleware
+	health      *health.Monitor
+
+	checkpointFile  string
+	draining        chan struct{} // closed by Shutdown
+	idempotencyTTL  time.Duration
+	duplicateWindow time.Duration
+
+	mu         sync.Mutex
+	sessions   map[string]*Session
+	closed     bool
+	unfinished []CheckpointTask
+	keys       map[string]*keyedTask
+	recent     map[string]*keyedTask // by device and normalized instruction
+}
+
+func NewManager(device phoneagent.Device, modelConfig *definitions.ModelConfig, agentConfig *definitions.AgentConfig, opts Options) *Manager {
+	if opts.MaxWorkers <= 0 {
+		opts.MaxWorkers = 1
+	}
+	if opts.MaxInFlightRequests <= 0 {
+		opts.MaxInFlightRequests = opts.MaxWorkers
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 16
+	}
+	if opts.IdempotencyTTL <= 0 {
+		opts.IdempotencyTTL = 24 * time.Hour
+	}
+	if opts.DuplicateWindow == 0 {
+		opts.DuplicateWindow = 5 * time.Minute
+	}
+
+	// the limiter of the process, if any, bounds the requests of all sessions
+	limiter := llm.SharedLimiter()
+	if limiter == nil {
+		limiter = llm.NewLimiter(llm.LimiterOptions{MaxInFlight: opts.MaxInFlightRequests})
+	}
+	return &Manager{
+		device:      device,
+		modelConfig: modelConfig,
+		agentConfig: *agentConfig,
+		limiter:     limiter,
+		navigation:  phoneagent.NewNavigationMap(),
+		scheduler:   newScheduler(opts.MaxWorkers, opts.Tenants),
+		tenants:     tenantsByName(opts.Tenants),
+		tenantOrder: tenantNames(opts.Tenants),
+		groups:      opts.Groups,
+		queueSize:   opts.QueueSize,
+		offlineTTL:  opts.OfflineTTL,
+		notify:      opts.Notify,
+		onStep:      opts.OnStep,
+		onEvent:     opts.OnEvent,
+		confirmer:   opts.Confirmer,
+		middleware:  opts.Middleware,
+		health:      opts.Health,
+		sessions:    map[string]*Session{},
+
+		checkpointFile: opts.CheckpointFile,
+		draining:       make(chan struct{}),
+		idempotencyTTL: opts.IdempotencyTTL,
+		keys:           map[string]*keyedTask{},
+
+		duplicateWindow: opts.DuplicateWindow,
+		recent:          map[string]*keyedTask{},
+	}
+}
+
+// Submit queues a task on the session of deviceID, creating the session if
+// needed; a device runs one task at a time, higher priorities first, see
+// WithPriority. With Options.Tenants an empty deviceID picks the least busy
+// online device of the pool of the tenant. The returned channel receives
+// exactly one Result. A task for an offline device is rejected with
+// ErrDeviceOffline unless Options.OfflineTTL is set, then it waits for the
+// device to reconnect. The same instruction submitted again while it runs, or
+// shortly after it succeeded, returns the first task instead, see
+// Options.DuplicateWindow.
+func (r *Manager) Submit(ctx context.Context, deviceID, instruction string) (*Task, <-chan *Result, error) {
+	return r.submit(ctx, deviceID, instruction, "")
+}
+
+func (r *Manager) submit(ctx context.Context, deviceID, instruction, key string) (*Task, <-chan *Result, error) {
+	if instruction == "" {
+		return nil, nil, fmt.Errorf("instruction is required")
+	}
+	tenant := tenantOf(ctx)
+	if deviceID == "" && len(r.tenants) > 0 {
+		picked, err := r.pickDevice(ctx, tenant)
+		if err != nil {
+			return nil, nil, err
+		}
+		deviceID = picked
+	}
+	if deviceID == "" {
+		return nil, nil, fmt.Errorf("device id is required")
+	}
+	if err := r.checkPool(tenant, deviceID); err != nil {
+		return nil, nil, err
+	}
+	if err := r.checkQuota(tenant); err != nil {
+		return nil, nil, err
+	}
+	if r.offlineTTL <= 0 && !r.isConnected(ctx, deviceID) {
+		return nil, nil, fmt.Errorf("%w: %s", ErrDeviceOffline, deviceID)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil, nil, ErrManagerClosed
+	}
+	if key != "" {
+		// a retry may have been accepted while the device was checked
+		if task, result, ok, err := r.lookupKey(deviceID, instruction, key); ok || err != nil {
+			return task, result, err
+		}
+	}
+	if !forced(ctx) {
+		if existing, ok := r.lookupDuplicate(deviceID, instruction); ok {
+			warnDuplicate(existing)
+			return existing.task, existing.wait(), nil
+		}
+	}
+
+	s := r.getOrCreateSession(deviceID)
+
+	task := &Task{
+		ID:             uuid.New().String(),
+		DeviceID:       deviceID,
+		Instruction:    instruction,
+		IdempotencyKey: key,
+		Labels:         labels.From(ctx),
+		Priority:       priorityOf(ctx),
+		SubmittedAt:    time.Now(),
+	}
+	pending := &pendingTask{
+		ctx:    ctx,
+		task:   task,
+		result: make(chan *Result, 1),
+		keyed:  &keyedTask{task: task, done: make(chan struct{})},
+	}
+
+	if !s.queue.push(pending) {
+		return nil, nil, fmt.Errorf("task queue of device %s is full", deviceID)
+	}
+	if key != "" {
+		r.keys[keyOf(deviceID, key)] = pending.keyed
+	}
+	r.rememberTask(pending.keyed)
+	return task, pending.result, nil
+}
+
+// Sessions returns the device ids with an open session.
+func (r *Manager) Sessions() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids := make([]string, 0, len(r.sessions))
+	for id := range r.sessions {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Close stops accepting tasks and waits for the queued ones to finish.
+func (r *Manager) Close() {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+	r.closed = true
+	sessions := make([]*Session, 0, len(r.sessions))
+	for _, s := range r.sessions {
+		s.queue.close()
+		sessions = append(sessions, s)
+	}
+	r.mu.Unlock()
+
+	for _, s := range sessions {
+		<-s.done
+	}
+}
+
+// getOrCreateSession must be called with r.mu held.
+func (r *Manager) getOrCreateSession(deviceID string) *Session {
+	if s, ok := r.sessions[deviceID]; ok {
+		return s
+	}
+
+	agentConfig := r.agentConfig
+	agentConfig.DeviceID = deviceID
+
+	agent := phoneagent.NewPhoneAgent(r.device, r.modelConfig, &agentConfig)
+	agent.ModelClient.SetLimiter(r.limiter, deviceID)
+	agent.Navigation = r.navigation
+	agent.Confirmer = r.confirmer
+	agent.Middleware = slices.Clone(r.middleware)
+
+	s := &Session{
+		DeviceID: deviceID,
+		agent:    agent,
+		manager:  r,
+		queue:    newTaskQueue(r.queueSize),
+		done:     make(chan struct{}),
+	}
+	agent.StepHooks = append(agent.StepHooks, drainHook{session: s})
+	if r.onStep != nil {
+		agent.StepHooks = append(agent.StepHooks, stepHook{session: s})
+	}
+	if r.onEvent != nil {
+		agent.OnEvent = func(event phoneagent.Event) {
+			if task := s.runningTask(); task != nil {
+				r.onEvent(task, event)
+			}
+		}
+	}
+	r.sessions[deviceID] = s
+	go s.loop()
+	return s
+}
+
+func (r *Manager) acquireWorker(ctx context.Context, tenant string, priority Priority) error {
+	return r.scheduler.acquire(ctx, tenant, priority, r.draining)
+}
+
+func (r *Manager) releaseWorker(tenant string, tokens int) {
+	r.scheduler.release(tenant, tokens)
+}
+
+// isConnected reports whether deviceID is online, as of the last heartbeat
+// of Options.Health when it has seen the device.
+func (r *Manager) isConnected(ctx context.Context, deviceID string) bool {
+	if online, ok := r.health.IsConnected(deviceID); ok {
+		return online
+	}
+	return r.device.IsConnected(ctx, deviceID)
+}
+
+func (r *Manager) emit(task *Task, event Event) {
+	if r.notify != nil {
+		r.notify(task, event)
+	}
+}
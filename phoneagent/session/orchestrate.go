@@ -0,0 +1,93 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"autoglm-go/phoneagent/llm"
+)
+
+// Report aggregates the results of RunAll.
+type Report struct {
+	Results    []*Result // by device in the order given, then by instruction
+	Succeeded  int
+	Failed     int
+	Usage      llm.ModelUsage
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// RunAll runs every instruction on every device, one device per goroutine:
+// the instructions of a device one after another, the devices at the same time
+// up to Options.MaxWorkers, all sharing the limiter of the manager. A task
+// failing on one device does not stop the others.
+func (r *Manager) RunAll(ctx context.Context, deviceIDs, instructions []string) *Report {
+	report := &Report{StartedAt: time.Now()}
+	perDevice := make([][]*Result, len(deviceIDs))
+
+	var wg sync.WaitGroup
+	for i, deviceID := range deviceIDs {
+		wg.Add(1)
+		go func(i int, deviceID string) {
+			defer wg.Done()
+			for _, instruction := range instructions {
+				perDevice[i] = append(perDevice[i], r.runOne(ctx, deviceID, instruction))
+			}
+		}(i, deviceID)
+	}
+	wg.Wait()
+
+	for _, results := range perDevice {
+		for _, result := range results {
+			report.Results = append(report.Results, result)
+			report.Usage.Add(result.Usage)
+			if result.Err == nil {
+				report.Succeeded++
+			} else {
+				report.Failed++
+			}
+		}
+	}
+	report.FinishedAt = time.Now()
+	return report
+}
+
+// runOne submits a task and waits for its result. A task that cannot be
+// submitted gets a result with the error.
+func (r *Manager) runOne(ctx context.Context, deviceID, instruction string) *Result {
+	_, results, err := r.Submit(ctx, deviceID, instruction)
+	if err != nil {
+		return &Result{
+			Task:       &Task{DeviceID: deviceID, Instruction: instruction, SubmittedAt: time.Now()},
+			Err:        err,
+			FinishedAt: time.Now(),
+		}
+	}
+	return <-results
+}
+
+// String renders the report as a table, one line per task, and a summary.
+func (r *Report) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%-24s %-8s %6s %8s  %s\n", "DEVICE", "STATUS", "STEPS", "TIME", "INSTRUCTION / RESULT")
+	for _, result := range r.Results {
+		status, outcome := "ok", result.Message
+		if result.Err != nil {
+			status, outcome = "failed", result.Err.Error()
+		}
+		var elapsed time.Duration
+		if !result.StartedAt.IsZero() {
+			elapsed = result.FinishedAt.Sub(result.StartedAt).Round(time.Second)
+		}
+		fmt.Fprintf(&sb, "%-24s %-8s %6d %8s  %s\n", result.Task.DeviceID, status, result.Steps, elapsed, result.Task.Instruction)
+		if outcome != "" {
+			fmt.Fprintf(&sb, "%-24s %-8s %6s %8s  → %s\n", "", "", "", "", outcome)
+		}
+	}
+	fmt.Fprintf(&sb, "%d succeeded, %d failed in %s; %d tokens, cost %.4f",
+		r.Succeeded, r.Failed, r.FinishedAt.Sub(r.StartedAt).Round(time.Second), r.Usage.TotalTokens(), r.Usage.Cost)
+	return sb.String()
+}
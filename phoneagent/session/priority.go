@@ -0,0 +1,132 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Priority orders the tasks waiting for a device or a worker: higher ones
+// start first, tasks of the same priority in the order they were submitted.
+type Priority int
+
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1
+	PriorityUrgent Priority = 2
+)
+
+var priorityNames = map[Priority]string{
+	PriorityLow:    "low",
+	PriorityNormal: "normal",
+	PriorityHigh:   "high",
+	PriorityUrgent: "urgent",
+}
+
+func (p Priority) String() string {
+	if name, ok := priorityNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("priority(%d)", int(p))
+}
+
+// ParsePriority reads low, normal, high or urgent, empty is normal.
+func ParsePriority(s string) (Priority, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return PriorityNormal, nil
+	}
+	for p, name := range priorityNames {
+		if name == s {
+			return p, nil
+		}
+	}
+	return PriorityNormal, fmt.Errorf("invalid priority %q, want low, normal, high or urgent", s)
+}
+
+type priorityKey struct{}
+
+// WithPriority returns ctx whose submissions get priority p, PriorityNormal
+// without it.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+func priorityOf(ctx context.Context) Priority {
+	p, _ := ctx.Value(priorityKey{}).(Priority)
+	return p
+}
+
+// insertByPriority inserts item after the items of the same or a higher
+// priority.
+func insertByPriority[T any](items []T, item T, priority func(T) Priority) []T {
+	p := priority(item)
+	i := slices.IndexFunc(items, func(other T) bool { return priority(other) < p })
+	if i < 0 {
+		return append(items, item)
+	}
+	return slices.Insert(items, i, item)
+}
+
+// taskQueue is the queue of a session, ordered by priority.
+type taskQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	tasks  []*pendingTask
+	size   int
+	closed bool
+}
+
+func newTaskQueue(size int) *taskQueue {
+	q := &taskQueue{size: size}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func pendingPriority(pending *pendingTask) Priority {
+	return pending.task.Priority
+}
+
+// push queues pending, false when the queue is full or closed.
+func (q *taskQueue) push(pending *pendingTask) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed || len(q.tasks) >= q.size {
+		return false
+	}
+	q.tasks = insertByPriority(q.tasks, pending, pendingPriority)
+	q.cond.Signal()
+	return true
+}
+
+// pop waits for the next task, false once the queue is closed and empty.
+func (q *taskQueue) pop() (*pendingTask, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.tasks) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.tasks) == 0 {
+		return nil, false
+	}
+	pending := q.tasks[0]
+	q.tasks = q.tasks[1:]
+	return pending, true
+}
+
+// close lets pop return the tasks left, then false.
+func (q *taskQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+func (q *taskQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.tasks)
+}
@@ -0,0 +1,244 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"autoglm-go/phoneagent"
+	"autoglm-go/phoneagent/health"
+	"autoglm-go/phoneagent/labels"
+	"autoglm-go/phoneagent/llm"
+	logs "github.com/sirupsen/logrus"
+)
+
+type Task struct {
+	ID             string
+	DeviceID       string
+	Instruction    string
+	IdempotencyKey string        // empty unless submitted with SubmitWithKey
+	Labels         labels.Labels // of the submit context, see labels.With
+	Priority       Priority      // of the submit context, see WithPriority
+	SubmittedAt    time.Time
+}
+
+// Event is a change of a task's state reported through Options.Notify.
+type Event string
+
+const (
+	EventPending Event = "pending" // the device is offline, the task waits for it
+	EventStarted Event = "started"
+	EventExpired Event = "expired" // the device did not reconnect within OfflineTTL
+)
+
+// reconnectPollInterval is how often an offline device is checked again.
+const reconnectPollInterval = 2 * time.Second
+
+type Result struct {
+	Task       *Task
+	Message    string
+	Err        error
+	Steps      int
+	Usage      llm.ModelUsage      // tokens and estimated cost of the task
+	Output     map[string]any      // extracted with the phoneagent.WithOutputSchema of the submit context
+	Outcome    *phoneagent.Outcome // nil when the task did not run
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+type pendingTask struct {
+	ctx    context.Context
+	task   *Task
+	result chan *Result
+	keyed  *keyedTask // for the idempotency and duplicate lookups
+}
+
+func (r *pendingTask) finish(result *Result) {
+	r.result <- result
+	if r.keyed != nil {
+		r.keyed.result = result
+		close(r.keyed.done)
+	}
+}
+
+// Session owns one device and its agent. Tasks submitted to the same device
+// run one after another on the session goroutine, each with a fresh history.
+type Session struct {
+	DeviceID string
+
+	agent   *phoneagent.PhoneAgent
+	manager *Manager
+	queue   *taskQueue
+	done    chan struct{}
+
+	interrupted bool // set by drainHook
+
+	mu      sync.Mutex
+	cancel  context.CancelFunc // of the running task
+	current *Task              // the running task
+}
+
+func (r *Session) loop() {
+	defer close(r.done)
+
+	for {
+		pending, ok := r.queue.pop()
+		if !ok {
+			return
+		}
+		if r.manager.isDraining() {
+			pending.finish(r.manager.interrupt(pending.task, 0))
+			continue
+		}
+		r.run(pending)
+	}
+}
+
+func (r *Session) run(pending *pendingTask) {
+	result := &Result{Task: pending.task}
+
+	// an offline device must not hold a worker slot while it is waited for
+	if err := r.waitForDevice(pending); err != nil {
+		if errors.Is(err, ErrInterrupted) {
+			pending.finish(r.manager.interrupt(pending.task, 0))
+			return
+		}
+		result.Err = err
+		result.FinishedAt = time.Now()
+		pending.finish(result)
+		return
+	}
+
+	// wait for a slot in the global worker pool
+	tenant := pending.task.Labels[TenantLabel]
+	if err := r.manager.acquireWorker(pending.ctx, tenant, pending.task.Priority); err != nil {
+		if errors.Is(err, ErrInterrupted) {
+			pending.finish(r.manager.interrupt(pending.task, 0))
+			return
+		}
+		result.Err = err
+		result.FinishedAt = time.Now()
+		pending.finish(result)
+		return
+	}
+	defer func() { r.manager.releaseWorker(tenant, result.Usage.TotalTokens()) }()
+
+	log := logs.WithFields(pending.task.Labels.Fields())
+	log.Infof("[Session] device %s starts task %s", r.DeviceID, pending.task.ID)
+	r.manager.emit(pending.task, EventStarted)
+
+	// Shutdown cancels the run when the drain timeout is over
+	ctx, cancel := context.WithCancel(pending.ctx)
+	r.mu.Lock()
+	r.cancel = cancel
+	r.current = pending.task
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.cancel = nil
+		r.current = nil
+		r.mu.Unlock()
+		cancel()
+	}()
+
+	r.interrupted = false
+	result.StartedAt = time.Now()
+	message, err := r.agent.Run(phoneagent.WithTaskID(ctx, pending.task.ID), pending.task.Instruction)
+	result.Message = message
+	result.Err = err
+	result.Steps = r.agent.StepCount
+	result.Usage = r.agent.Usage.Total()
+	result.Output = r.agent.Output
+	result.Outcome = r.agent.Outcome
+	result.FinishedAt = time.Now()
+	interrupted := r.interrupted || (ctx.Err() != nil && pending.ctx.Err() == nil)
+
+	// isolate history between tasks
+	r.agent.Reset(pending.ctx)
+
+	if interrupted {
+		interruptedResult := r.manager.interrupt(pending.task, result.Steps)
+		interruptedResult.StartedAt = result.StartedAt
+		interruptedResult.Usage = result.Usage
+		pending.finish(interruptedResult)
+		return
+	}
+
+	log.Infof("[Session] device %s finished task %s in %d step(s)", r.DeviceID, pending.task.ID, result.Steps)
+	pending.finish(result)
+}
+
+// waitForDevice blocks until the device is online. The TTL counts from the
+// submission, so time spent queued behind other tasks is included.
+func (r *Session) waitForDevice(pending *pendingTask) error {
+	ctx := pending.ctx
+	if r.manager.isConnected(ctx, r.DeviceID) {
+		return nil
+	}
+
+	ttl := r.manager.offlineTTL
+	deadline := pending.task.SubmittedAt.Add(ttl)
+	if ttl <= 0 || !time.Now().Before(deadline) {
+		r.manager.emit(pending.task, EventExpired)
+		return fmt.Errorf("%w: %s", ErrDeviceOffline, r.DeviceID)
+	}
+
+	logs.Warnf("[Session] device %s is offline, task %s waits until %s", r.DeviceID, pending.task.ID, deadline.Format(time.TimeOnly))
+	r.manager.emit(pending.task, EventPending)
+
+	expire := time.NewTimer(time.Until(deadline))
+	defer expire.Stop()
+	ticker := time.NewTicker(reconnectPollInterval)
+	defer ticker.Stop()
+	// the monitor tells when the device is back, the polling stays for the
+	// devices it does not see
+	var online <-chan health.Event
+	if r.manager.health != nil {
+		events, cancel := r.manager.health.Subscribe()
+		defer cancel()
+		online = events
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-r.manager.draining:
+			return ErrInterrupted
+		case <-expire.C:
+			logs.Warnf("[Session] device %s did not reconnect, task %s expired", r.DeviceID, pending.task.ID)
+			r.manager.emit(pending.task, EventExpired)
+			return fmt.Errorf("%w: %s did not reconnect within %s", ErrDeviceOffline, r.DeviceID, ttl)
+		case event := <-online:
+			if event.DeviceID == r.DeviceID && event.Online() {
+				logs.Infof("[Session] device %s reconnected", r.DeviceID)
+				return nil
+			}
+		case <-ticker.C:
+			if r.manager.isConnected(ctx, r.DeviceID) {
+				logs.Infof("[Session] device %s reconnected", r.DeviceID)
+				return nil
+			}
+		}
+	}
+}
+
+// stepHook reports the steps of the running task to Options.OnStep.
+type stepHook struct {
+	session *Session
+}
+
+func (h stepHook) AfterStep(ctx context.Context, info *phoneagent.StepInfo) (*phoneagent.StepHookResult, error) {
+	if task := h.session.runningTask(); task != nil {
+		h.session.manager.onStep(task, info)
+	}
+	return nil, nil
+}
+
+func (r *Session) runningTask() *Task {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
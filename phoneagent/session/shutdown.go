@@ -0,0 +1,169 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"autoglm-go/phoneagent"
+	"autoglm-go/phoneagent/labels"
+	logs "github.com/sirupsen/logrus"
+)
+
+// ErrInterrupted is the error of tasks stopped or never started because the
+// manager shut down. They are saved to Options.CheckpointFile.
+var ErrInterrupted = errors.New("task interrupted by shutdown")
+
+// Checkpoint lists the tasks left unfinished by a shutdown.
+type Checkpoint struct {
+	SavedAt time.Time        `json:"saved_at"`
+	Tasks   []CheckpointTask `json:"tasks"`
+}
+
+type CheckpointTask struct {
+	ID             string        `json:"id"`
+	DeviceID       string        `json:"device_id"`
+	Instruction    string        `json:"instruction"`
+	IdempotencyKey string        `json:"idempotency_key,omitempty"`
+	Labels         labels.Labels `json:"labels,omitempty"`   // resubmit with labels.With to keep them
+	Priority       Priority      `json:"priority,omitempty"` // and WithPriority
+	SubmittedAt    time.Time     `json:"submitted_at"`
+	Steps          int           `json:"steps"` // steps taken before the shutdown, 0 if it never started
+}
+
+// LoadCheckpoint reads the tasks saved by Shutdown, none when the file does
+// not exist. Resubmitting them starts each task over with a fresh history;
+// SubmitWithKey with their IdempotencyKey keeps client retries from queueing
+// them twice.
+func LoadCheckpoint(path string) ([]CheckpointTask, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var checkpoint Checkpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, err
+	}
+	return checkpoint.Tasks, nil
+}
+
+// Shutdown stops accepting tasks and drains the sessions: running tasks stop
+// after their current step, queued ones are not started. Steps still running
+// when ctx is done are cancelled. Once every session has ended, and with it
+// its worker slot and device, the unfinished tasks are checkpointed.
+func (r *Manager) Shutdown(ctx context.Context) error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return ErrManagerClosed
+	}
+	r.closed = true
+	close(r.draining)
+	sessions := make([]*Session, 0, len(r.sessions))
+	for _, s := range r.sessions {
+		s.queue.close()
+		sessions = append(sessions, s)
+	}
+	r.sessions = map[string]*Session{}
+	r.mu.Unlock()
+
+	logs.Infof("[Session] draining %d session(s)", len(sessions))
+	cancelled := false
+	for _, s := range sessions {
+		if !cancelled {
+			select {
+			case <-s.done:
+				continue
+			case <-ctx.Done():
+			}
+			logs.Warnf("[Session] drain timeout, cancelling running steps")
+			for _, s := range sessions {
+				s.cancelRun()
+			}
+			cancelled = true
+		}
+		<-s.done
+	}
+
+	return r.saveCheckpoint()
+}
+
+func (r *Manager) isDraining() bool {
+	select {
+	case <-r.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+// interrupt records a task stopped by Shutdown and builds its result.
+func (r *Manager) interrupt(task *Task, steps int) *Result {
+	r.mu.Lock()
+	r.unfinished = append(r.unfinished, CheckpointTask{
+		ID:             task.ID,
+		DeviceID:       task.DeviceID,
+		Instruction:    task.Instruction,
+		IdempotencyKey: task.IdempotencyKey,
+		Labels:         task.Labels,
+		Priority:       task.Priority,
+		SubmittedAt:    task.SubmittedAt,
+		Steps:          steps,
+	})
+	r.mu.Unlock()
+
+	logs.WithFields(task.Labels.Fields()).Warnf("[Session] task %s on device %s interrupted after %d step(s)", task.ID, task.DeviceID, steps)
+	return &Result{Task: task, Err: ErrInterrupted, Steps: steps, FinishedAt: time.Now()}
+}
+
+// saveCheckpoint writes the unfinished tasks to Options.CheckpointFile, and
+// removes a stale file when there are none.
+func (r *Manager) saveCheckpoint() error {
+	if r.checkpointFile == "" {
+		return nil
+	}
+	r.mu.Lock()
+	tasks := r.unfinished
+	r.mu.Unlock()
+
+	if len(tasks) == 0 {
+		if err := os.Remove(r.checkpointFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(Checkpoint{SavedAt: time.Now(), Tasks: tasks}, "", "  ")
+	if err != nil {
+		return err
+	}
+	logs.Infof("[Session] %d unfinished task(s) saved to %s", len(tasks), r.checkpointFile)
+	return os.WriteFile(r.checkpointFile, data, 0o644)
+}
+
+// drainHook ends the running task after the step in progress once the
+// manager is shutting down.
+type drainHook struct {
+	session *Session
+}
+
+func (h drainHook) AfterStep(ctx context.Context, info *phoneagent.StepInfo) (*phoneagent.StepHookResult, error) {
+	if !h.session.manager.isDraining() {
+		return nil, nil
+	}
+	h.session.interrupted = true
+	return &phoneagent.StepHookResult{Stop: true, Message: ErrInterrupted.Error()}, nil
+}
+
+// cancelRun cancels the context of the running task, if any.
+func (r *Session) cancelRun() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cancel != nil {
+		r.cancel()
+	}
+}
@@ -0,0 +1,425 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"autoglm-go/phoneagent/labels"
+)
+
+// TenantLabel is the label naming the tenant of a task, see labels.With.
+// Without Options.Tenants the workers are still shared fairly among the
+// tenants of the tasks, those without the label counting as one.
+const TenantLabel = "tenant"
+
+var (
+	ErrUnknownTenant = errors.New("unknown tenant")
+	ErrNotInPool     = errors.New("device is not in the pool of the tenant")
+	ErrQuotaExceeded = errors.New("daily token quota of the tenant exceeded")
+)
+
+// What Tenant.OverQuota does with the tasks of a tenant that used its daily
+// tokens.
+const (
+	QuotaReject = "reject" // refuse them, the default
+	QuotaQueue  = "queue"  // start them the next day
+)
+
+// DeviceGroups resolves the device groups of Tenant.Groups, implemented by
+// group.Tree.
+type DeviceGroups interface {
+	Devices(id string) []string
+}
+
+// Tenant is a user of a shared device farm: the devices of its pool and its
+// share of the workers.
+type Tenant struct {
+	Name string `json:"name"`
+	// Devices and the devices of Groups and their subgroups are the pool of
+	// the tenant, its tasks run on these devices only. Both empty allow
+	// every device. Pools of different tenants may overlap.
+	Devices []string `json:"devices"`
+	Groups  []string `json:"groups,omitempty"`
+	// Weight is the share of the free workers the tenant gets while others
+	// wait too, 1 by default: weights 3 and 1 start three tasks of the first
+	// tenant for one of the second.
+	Weight int `json:"weight"`
+	// MaxConcurrent caps the tasks of the tenant running at the same time, 0
+	// means only the workers of the manager do.
+	MaxConcurrent int `json:"max_concurrent"`
+	// DailyTokens caps the model tokens of the tasks of the tenant per day,
+	// in the local time, 0 means no cap. The tokens of a task count once it
+	// ended, so the task that crosses the cap still finishes.
+	DailyTokens int `json:"daily_tokens,omitempty"`
+	// OverQuota is QuotaReject or QuotaQueue.
+	OverQuota string `json:"over_quota,omitempty"`
+}
+
+// TenantStatus is a tenant, its pool and what it uses now.
+type TenantStatus struct {
+	Tenant
+	Pool       []string `json:"pool"` // empty when it is every device
+	Running    int      `json:"running"`
+	Waiting    int      `json:"waiting"`     // for a worker, or the next day
+	UsedTokens int      `json:"used_tokens"` // today
+}
+
+// LoadTenants reads a JSON array of tenants.
+func LoadTenants(path string) ([]Tenant, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tenants []Tenant
+	if err := json.Unmarshal(data, &tenants); err != nil {
+		return nil, fmt.Errorf("invalid tenants file %s: %w", path, err)
+	}
+	seen := map[string]bool{}
+	for _, t := range tenants {
+		if t.Name == "" {
+			return nil, fmt.Errorf("tenant without a name in %s", path)
+		}
+		if seen[t.Name] {
+			return nil, fmt.Errorf("tenant %s defined twice in %s", t.Name, path)
+		}
+		if t.Weight < 0 || t.MaxConcurrent < 0 || t.DailyTokens < 0 {
+			return nil, fmt.Errorf("tenant %s: weight, max_concurrent and daily_tokens must not be negative", t.Name)
+		}
+		if t.OverQuota != "" && t.OverQuota != QuotaReject && t.OverQuota != QuotaQueue {
+			return nil, fmt.Errorf("tenant %s: over_quota must be %s or %s", t.Name, QuotaReject, QuotaQueue)
+		}
+		seen[t.Name] = true
+	}
+	return tenants, nil
+}
+
+// tenantOf returns the tenant of a task submitted with ctx.
+func tenantOf(ctx context.Context) string {
+	return labels.From(ctx)[TenantLabel]
+}
+
+// checkPool returns an error unless tenant may run tasks on deviceID. With no
+// tenants defined every task may.
+func (r *Manager) checkPool(tenant, deviceID string) error {
+	if len(r.tenants) == 0 {
+		return nil
+	}
+	t, ok := r.tenants[tenant]
+	if !ok {
+		if tenant == "" {
+			return fmt.Errorf("%w: tasks must be labeled %s=<name>", ErrUnknownTenant, TenantLabel)
+		}
+		return fmt.Errorf("%w: %s", ErrUnknownTenant, tenant)
+	}
+	if pool := r.pool(t); len(pool) > 0 && !slices.Contains(pool, deviceID) {
+		return fmt.Errorf("%w: %s is not in the pool of %s", ErrNotInPool, deviceID, tenant)
+	}
+	return nil
+}
+
+// pool returns the devices of t and of its groups, empty for every device.
+func (r *Manager) pool(t Tenant) []string {
+	pool := slices.Clone(t.Devices)
+	if r.groups != nil {
+		for _, id := range t.Groups {
+			pool = append(pool, r.groups.Devices(id)...)
+		}
+	}
+	slices.Sort(pool)
+	return slices.Compact(pool)
+}
+
+// InPool reports whether deviceID is one tenant may run tasks on, never for
+// a tenant not defined.
+func (r *Manager) InPool(tenant, deviceID string) bool {
+	if _, ok := r.tenants[tenant]; !ok {
+		return false
+	}
+	return r.checkPool(tenant, deviceID) == nil
+}
+
+// checkQuota returns ErrQuotaExceeded when tenant used its daily tokens and
+// its tasks are rejected then.
+func (r *Manager) checkQuota(tenant string) error {
+	t, ok := r.tenants[tenant]
+	if !ok || t.OverQuota == QuotaQueue {
+		return nil
+	}
+	if used, over := r.scheduler.overQuota(tenant); over {
+		return fmt.Errorf("%w: %s used %d of %d tokens today", ErrQuotaExceeded, tenant, used, t.DailyTokens)
+	}
+	return nil
+}
+
+// Tenants returns the tenants of Options.Tenants and what they use, in their
+// order.
+func (r *Manager) Tenants() []TenantStatus {
+	statuses := make([]TenantStatus, 0, len(r.tenantOrder))
+	for _, name := range r.tenantOrder {
+		t := r.tenants[name]
+		status := TenantStatus{Tenant: t, Pool: r.pool(t)}
+		status.Running, status.Waiting, status.UsedTokens = r.scheduler.usage(name)
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+func tenantsByName(tenants []Tenant) map[string]Tenant {
+	byName := make(map[string]Tenant, len(tenants))
+	for _, t := range tenants {
+		byName[t.Name] = t
+	}
+	return byName
+}
+
+func tenantNames(tenants []Tenant) []string {
+	names := make([]string, 0, len(tenants))
+	for _, t := range tenants {
+		names = append(names, t.Name)
+	}
+	return names
+}
+
+// pickDevice returns the online device of the pool of tenant with the fewest
+// tasks queued or running.
+func (r *Manager) pickDevice(ctx context.Context, tenant string) (string, error) {
+	t, ok := r.tenants[tenant]
+	if !ok {
+		return "", r.checkPool(tenant, "")
+	}
+	pool := r.pool(t)
+	if len(pool) == 0 {
+		return "", fmt.Errorf("device_id is required, tenant %s has no device pool", tenant)
+	}
+	var online []string
+	for _, id := range pool {
+		if r.isConnected(ctx, id) {
+			online = append(online, id)
+		}
+	}
+	if len(online) == 0 {
+		return "", fmt.Errorf("%w: no device of the pool of %s is online", ErrDeviceOffline, tenant)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	best, bestLoad := "", 0
+	for _, id := range online {
+		load := 0
+		if s, ok := r.sessions[id]; ok {
+			load = s.queue.len()
+			if s.runningTask() != nil {
+				load++
+			}
+		}
+		if best == "" || load < bestLoad {
+			best, bestLoad = id, load
+		}
+	}
+	return best, nil
+}
+
+// scheduler hands out the workers of the manager. Free workers go to the
+// waiting tasks of the highest priority; among the tenants waiting with it by
+// smooth weighted round-robin, skipping those at their MaxConcurrent or over
+// their DailyTokens. A tenant's tasks of the same priority start in their
+// order.
+type scheduler struct {
+	mu      sync.Mutex
+	free    int
+	tenants map[string]*tenantQueue
+	order   []*tenantQueue // in a stable order, for ties
+	day     string         // of the used tokens
+	wake    *time.Timer    // dispatches the next day, for the tenants over quota
+}
+
+type tenantQueue struct {
+	weight  int
+	max     int
+	quota   int // DailyTokens
+	used    int // tokens today
+	running int
+	current int // of the smooth weighted round-robin
+	waiting []*waiter
+}
+
+func (q *tenantQueue) overQuota() bool {
+	return q.quota > 0 && q.used >= q.quota
+}
+
+type waiter struct {
+	ready    chan struct{}
+	priority Priority
+}
+
+func waiterPriority(w *waiter) Priority {
+	return w.priority
+}
+
+func newScheduler(workers int, tenants []Tenant) *scheduler {
+	s := &scheduler{free: workers, tenants: map[string]*tenantQueue{}, day: today()}
+	for _, t := range tenants {
+		s.add(t)
+	}
+	return s
+}
+
+// add must be called with s.mu held, or before s is shared.
+func (s *scheduler) add(t Tenant) *tenantQueue {
+	q := &tenantQueue{weight: t.Weight, max: t.MaxConcurrent, quota: t.DailyTokens}
+	if q.weight <= 0 {
+		q.weight = 1
+	}
+	s.tenants[t.Name] = q
+	s.order = append(s.order, q)
+	return q
+}
+
+func (s *scheduler) queue(tenant string) *tenantQueue {
+	s.rollDay()
+	if q, ok := s.tenants[tenant]; ok {
+		return q
+	}
+	return s.add(Tenant{Name: tenant})
+}
+
+func today() string {
+	return time.Now().Format(time.DateOnly)
+}
+
+// rollDay forgets the tokens of the previous days, it must be called with
+// s.mu held.
+func (s *scheduler) rollDay() {
+	if day := today(); day != s.day {
+		s.day = day
+		for _, q := range s.order {
+			q.used = 0
+		}
+	}
+}
+
+// wakeTomorrow dispatches again once the day is over, for the tasks waiting
+// on their quota. It must be called with s.mu held.
+func (s *scheduler) wakeTomorrow() {
+	if s.wake != nil {
+		return
+	}
+	now := time.Now()
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+	s.wake = time.AfterFunc(midnight.Sub(now), func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.wake = nil
+		s.dispatch()
+	})
+}
+
+// overQuota returns the tokens tenant used today and whether they are over
+// its quota.
+func (s *scheduler) overQuota(tenant string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := s.queue(tenant)
+	return q.used, q.overQuota()
+}
+
+// usage returns the running and waiting tasks of tenant and its tokens
+// today.
+func (s *scheduler) usage(tenant string) (running, waiting, used int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := s.queue(tenant)
+	return q.running, len(q.waiting), q.used
+}
+
+// acquire waits for a worker for a task of tenant.
+func (s *scheduler) acquire(ctx context.Context, tenant string, priority Priority, draining <-chan struct{}) error {
+	w := &waiter{ready: make(chan struct{}), priority: priority}
+	s.mu.Lock()
+	q := s.queue(tenant)
+	q.waiting = insertByPriority(q.waiting, w, waiterPriority)
+	s.dispatch()
+	s.mu.Unlock()
+
+	var err error
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-draining:
+		err = ErrInterrupted
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i := slices.Index(q.waiting, w); i >= 0 {
+		q.waiting = slices.Delete(q.waiting, i, i+1)
+		return err
+	}
+	// granted meanwhile, hand it on
+	q.running--
+	s.free++
+	s.dispatch()
+	return err
+}
+
+// release frees the worker of a task of tenant that used tokens.
+func (s *scheduler) release(tenant string, tokens int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := s.queue(tenant)
+	q.running--
+	q.used += tokens
+	s.free++
+	s.dispatch()
+}
+
+// dispatch must be called with s.mu held.
+func (s *scheduler) dispatch() {
+	s.rollDay()
+	for s.free > 0 {
+		var eligible []*tenantQueue
+		top := Priority(0)
+		for _, q := range s.order {
+			if len(q.waiting) == 0 || (q.max > 0 && q.running >= q.max) {
+				continue
+			}
+			if q.overQuota() {
+				s.wakeTomorrow()
+				continue
+			}
+			if p := q.waiting[0].priority; len(eligible) == 0 || p > top {
+				top = p
+			}
+			eligible = append(eligible, q)
+		}
+
+		var best *tenantQueue
+		total := 0
+		for _, q := range eligible {
+			if q.waiting[0].priority < top {
+				continue
+			}
+			q.current += q.weight
+			total += q.weight
+			if best == nil || q.current > best.current {
+				best = q
+			}
+		}
+		if best == nil {
+			return
+		}
+		best.current -= total
+		close(best.waiting[0].ready)
+		best.waiting = best.waiting[1:]
+		best.running++
+		s.free--
+	}
+}
@@ -0,0 +1,37 @@
+package phoneagent
+
+import (
+	"context"
+	"sync/atomic"
+
+	"autoglm-go/phoneagent/definitions"
+)
+
+// settings are the reloaded settings, nil until the first reload
+var settings atomic.Pointer[definitions.Settings]
+
+// ReloadSettings makes the tasks that start from now on use s, the running
+// ones go on with their settings.
+func ReloadSettings(s definitions.Settings) {
+	settings.Store(&s)
+}
+
+// applySettings brings the agent to the reloaded settings at the start of a
+// task. The model config is shared with the other agents, this one gets its
+// own copy.
+func (r *PhoneAgent) applySettings(ctx context.Context) {
+	s := settings.Load()
+	if s == nil {
+		return
+	}
+	// a watch check may have its own step limit
+	if s.MaxSteps > 0 && !isWatchCheck(ctx) {
+		r.AgentConfig.MaxSteps = s.MaxSteps
+	}
+	if t := s.Temperature; t != nil && *t != r.ModelConfig.Temperature {
+		config := *r.ModelConfig
+		config.Temperature = *t
+		r.ModelConfig = &config
+		r.ModelClient.SetConfig(&config)
+	}
+}
@@ -0,0 +1,182 @@
+package setup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"autoglm-go/phoneagent/android"
+)
+
+// animationScales are the global settings of the animations of Android.
+var animationScales = []string{"window_animation_scale", "transition_animation_scale", "animator_duration_scale"}
+
+// Device is what the setup needs of the device driver.
+type Device interface {
+	Shell(ctx context.Context, deviceID string, args ...string) (string, error)
+}
+
+// Installer is implemented by drivers that install apps.
+type Installer interface {
+	Install(ctx context.Context, deviceID string, paths []string, opts android.InstallOptions) error
+}
+
+// Check is a requirement of a profile on a device.
+type Check struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Applied bool   `json:"applied,omitempty"` // it was not met and was set up
+	Error   string `json:"error,omitempty"`
+}
+
+// Report is the outcome of the setup of a device.
+type Report struct {
+	DeviceID string    `json:"device_id"`
+	Profile  string    `json:"profile"`
+	OK       bool      `json:"ok"` // all the checks are met
+	Checks   []Check   `json:"checks"`
+	At       time.Time `json:"at"`
+}
+
+// requirement verifies a part of a profile and applies it when it is not met.
+type requirement struct {
+	name   string
+	verify func(ctx context.Context) (bool, error)
+	apply  func(ctx context.Context) error
+}
+
+// Apply verifies profile on deviceID and applies the requirements that are
+// not met, verifying them again after. A failed requirement does not stop
+// the others.
+func Apply(ctx context.Context, device Device, deviceID string, profile *Profile) Report {
+	report := Report{DeviceID: deviceID, Profile: profile.Name, OK: true}
+	for _, req := range requirements(device, deviceID, profile) {
+		check := req.run(ctx)
+		report.OK = report.OK && check.OK
+		report.Checks = append(report.Checks, check)
+	}
+	report.At = time.Now()
+	return report
+}
+
+func (r requirement) run(ctx context.Context) Check {
+	check := Check{Name: r.name}
+	ok, err := r.verify(ctx)
+	if err == nil && !ok {
+		check.Applied = true
+		if err = r.apply(ctx); err == nil {
+			ok, err = r.verify(ctx)
+			if err == nil && !ok {
+				err = errors.New("still not met after it was applied")
+			}
+		}
+	}
+	check.OK = err == nil && ok
+	if err != nil {
+		check.Error = err.Error()
+	}
+	return check
+}
+
+func requirements(device Device, deviceID string, profile *Profile) []requirement {
+	shell := func(ctx context.Context, args ...string) (string, error) {
+		output, err := device.Shell(ctx, deviceID, args...)
+		if err != nil {
+			return output, fmt.Errorf("%s: %w, output: %s", strings.Join(args, " "), err, output)
+		}
+		return strings.TrimSpace(output), nil
+	}
+	setting := func(ctx context.Context, namespace, key string) (string, error) {
+		return shell(ctx, "settings", "get", namespace, key)
+	}
+
+	var reqs []requirement
+	for _, app := range profile.Apps {
+		reqs = append(reqs, requirement{
+			name: "app " + app.Package,
+			verify: func(ctx context.Context) (bool, error) {
+				output, err := device.Shell(ctx, deviceID, "pm", "path", shellQuote(app.Package))
+				return err == nil && strings.Contains(output, "package:"), nil
+			},
+			apply: func(ctx context.Context) error {
+				if app.Path == "" {
+					return fmt.Errorf("%s is not installed and has no path to install it from", app.Package)
+				}
+				installer, ok := device.(Installer)
+				if !ok {
+					return fmt.Errorf("the device cannot install apps")
+				}
+				opts := android.InstallOptions{GrantPermissions: app.GrantPermissions}
+				return installer.Install(ctx, deviceID, append([]string{app.Path}, app.Splits...), opts)
+			},
+		})
+	}
+	if profile.IME != "" {
+		reqs = append(reqs, requirement{
+			name: "ime",
+			verify: func(ctx context.Context) (bool, error) {
+				current, err := setting(ctx, "secure", "default_input_method")
+				return current == profile.IME, err
+			},
+			apply: func(ctx context.Context) error {
+				if _, err := shell(ctx, "ime", "enable", shellQuote(profile.IME)); err != nil {
+					return err
+				}
+				_, err := shell(ctx, "ime", "set", shellQuote(profile.IME))
+				return err
+			},
+		})
+	}
+	if profile.DisableAnimations {
+		reqs = append(reqs, requirement{
+			name: "animations",
+			verify: func(ctx context.Context) (bool, error) {
+				for _, key := range animationScales {
+					value, err := setting(ctx, "global", key)
+					if err != nil {
+						return false, err
+					}
+					// unset is the default scale of 1
+					if scale, err := strconv.ParseFloat(value, 64); err != nil || scale != 0 {
+						return false, nil
+					}
+				}
+				return true, nil
+			},
+			apply: func(ctx context.Context) error {
+				for _, key := range animationScales {
+					if _, err := shell(ctx, "settings", "put", "global", key, "0"); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		})
+	}
+	if profile.StayAwake {
+		reqs = append(reqs, requirement{
+			name: "stay_awake",
+			verify: func(ctx context.Context) (bool, error) {
+				// a bit mask of the power sources keeping the screen on
+				value, err := setting(ctx, "global", "stay_on_while_plugged_in")
+				if err != nil {
+					return false, err
+				}
+				mask, err := strconv.Atoi(value)
+				return err == nil && mask != 0, nil
+			},
+			apply: func(ctx context.Context) error {
+				_, err := shell(ctx, "svc", "power", "stayon", "true")
+				return err
+			},
+		})
+	}
+	return reqs
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
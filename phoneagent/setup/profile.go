@@ -0,0 +1,106 @@
+// Package setup keeps the devices of a fleet in the state the tasks expect:
+// the apps they need installed, the input method, animations and the screen
+// staying on. A Watcher verifies the Profile of every device joining the
+// device list and applies what is missing.
+package setup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// App is an app a device needs.
+type App struct {
+	Package string `json:"package"`
+	// Path is the APK or bundle installed when the package is missing, the
+	// app is only checked without it.
+	Path             string   `json:"path,omitempty"`
+	Splits           []string `json:"splits,omitempty"` // more split APKs or OBB files next to Path
+	GrantPermissions bool     `json:"grant_permissions,omitempty"`
+}
+
+// Profile is the setup of the devices it matches.
+type Profile struct {
+	Name string `json:"name"`
+	// Devices are the ids, or path.Match patterns of them such as
+	// "emulator-*", of the devices of the profile, all of them when empty.
+	Devices []string `json:"devices,omitempty"`
+
+	Apps []App `json:"apps,omitempty"`
+	// IME is the input method enabled and selected, e.g.
+	// "com.android.adbkeyboard/.AdbIME".
+	IME               string `json:"ime,omitempty"`
+	DisableAnimations bool   `json:"disable_animations,omitempty"`
+	StayAwake         bool   `json:"stay_awake,omitempty"` // while plugged in
+}
+
+// Matches reports whether the profile is the one of deviceID.
+func (p *Profile) Matches(deviceID string) bool {
+	if len(p.Devices) == 0 {
+		return true
+	}
+	for _, pattern := range p.Devices {
+		if ok, _ := path.Match(pattern, deviceID); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns the first of profiles matching deviceID, nil when none does.
+func Match(profiles []Profile, deviceID string) *Profile {
+	for i := range profiles {
+		if profiles[i].Matches(deviceID) {
+			return &profiles[i]
+		}
+	}
+	return nil
+}
+
+// LoadProfiles reads a JSON array of profiles. Local paths are relative to
+// the file.
+func LoadProfiles(file string) ([]Profile, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var profiles []Profile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("invalid setup profiles file %s: %w", file, err)
+	}
+	dir := filepath.Dir(file)
+	seen := map[string]bool{}
+	for i := range profiles {
+		p := &profiles[i]
+		if p.Name == "" {
+			return nil, fmt.Errorf("setup profile without a name in %s", file)
+		}
+		if seen[p.Name] {
+			return nil, fmt.Errorf("setup profile %s defined twice in %s", p.Name, file)
+		}
+		seen[p.Name] = true
+		for _, pattern := range p.Devices {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("setup profile %s: invalid device pattern %q", p.Name, pattern)
+			}
+		}
+		for j := range p.Apps {
+			app := &p.Apps[j]
+			if app.Package == "" {
+				return nil, fmt.Errorf("setup profile %s: app without a package", p.Name)
+			}
+			if app.Path != "" && !filepath.IsAbs(app.Path) {
+				app.Path = filepath.Join(dir, app.Path)
+			}
+			for k, split := range app.Splits {
+				if !filepath.IsAbs(split) {
+					app.Splits[k] = filepath.Join(dir, split)
+				}
+			}
+		}
+	}
+	return profiles, nil
+}
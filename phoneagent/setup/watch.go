@@ -0,0 +1,163 @@
+package setup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"autoglm-go/phoneagent/definitions"
+	logs "github.com/sirupsen/logrus"
+)
+
+// ErrNoProfile is returned by Watcher.Apply for a device no profile matches.
+var ErrNoProfile = errors.New("no setup profile matches the device")
+
+// connected is the status of the devices ready for commands.
+const connected = "device"
+
+// Lister lists the devices of the driver.
+type Lister interface {
+	ListDevices(ctx context.Context) ([]definitions.DeviceInfo, error)
+}
+
+// Watcher sets up the devices as they join the device list, and again when
+// they come back after leaving it.
+type Watcher struct {
+	device   Device
+	lister   Lister
+	profiles []Profile
+
+	mu       sync.Mutex
+	present  map[string]bool // connected at the last poll
+	applying map[string]bool
+	reports  map[string]Report // the last, by device
+}
+
+func NewWatcher(device Device, lister Lister, profiles []Profile) *Watcher {
+	return &Watcher{
+		device:   device,
+		lister:   lister,
+		profiles: profiles,
+		present:  map[string]bool{},
+		applying: map[string]bool{},
+		reports:  map[string]Report{},
+	}
+}
+
+// Run polls the device list every interval until ctx ends, only once when
+// interval is not positive.
+func (r *Watcher) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		r.poll(ctx)
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		r.poll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll sets up the devices connected since the last poll, in the
+// background.
+func (r *Watcher) poll(ctx context.Context) {
+	devices, err := r.lister.ListDevices(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			logs.Warnf("🧰 failed to list devices for their setup, err: %v", err)
+		}
+		return
+	}
+	present := map[string]bool{}
+	for _, info := range devices {
+		if info.Status == connected {
+			present[info.DeviceID] = true
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id := range present {
+		if r.present[id] || Match(r.profiles, id) == nil {
+			continue
+		}
+		logs.Infof("🧰 device %s joined, setting it up", id)
+		go func() {
+			if _, err := r.Apply(ctx, id); err != nil && !errors.Is(err, ErrNoProfile) {
+				logs.Warnf("🧰 %v", err)
+			}
+		}()
+	}
+	r.present = present
+}
+
+// Apply verifies and applies the profile of deviceID now. It returns an
+// error when the device is being set up already, or when a check failed
+// along with the report.
+func (r *Watcher) Apply(ctx context.Context, deviceID string) (Report, error) {
+	profile := Match(r.profiles, deviceID)
+	if profile == nil {
+		return Report{}, fmt.Errorf("%w: %s", ErrNoProfile, deviceID)
+	}
+	r.mu.Lock()
+	if r.applying[deviceID] {
+		r.mu.Unlock()
+		return Report{}, fmt.Errorf("device %s is being set up already", deviceID)
+	}
+	r.applying[deviceID] = true
+	r.mu.Unlock()
+
+	report := Apply(ctx, r.device, deviceID, profile)
+
+	r.mu.Lock()
+	delete(r.applying, deviceID)
+	r.reports[deviceID] = report
+	r.mu.Unlock()
+
+	if !report.OK {
+		return report, fmt.Errorf("setup %s of device %s failed: %s", profile.Name, deviceID, failedChecks(report))
+	}
+	logs.Infof("🧰 device %s set up with %s (%d applied)", deviceID, profile.Name, appliedChecks(report))
+	return report, nil
+}
+
+// Report returns the last setup of deviceID. A nil Watcher has none.
+func (r *Watcher) Report(deviceID string) (Report, bool) {
+	if r == nil {
+		return Report{}, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report, ok := r.reports[deviceID]
+	return report, ok
+}
+
+func failedChecks(report Report) string {
+	var failed string
+	for _, check := range report.Checks {
+		if !check.OK {
+			if failed != "" {
+				failed += "; "
+			}
+			failed += check.Name + ": " + check.Error
+		}
+	}
+	return failed
+}
+
+func appliedChecks(report Report) int {
+	n := 0
+	for _, check := range report.Checks {
+		if check.Applied {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,95 @@
+package phoneagent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/llm"
+	"github.com/sashabaranov/go-openai"
+)
+
+// maxParallelParts bounds the parts of a split instruction.
+const maxParallelParts = 8
+
+const (
+	splitPromptCn = `你是手机操作任务的规划者。如果用户任务包含可以在不同手机上同时独立完成、互不依赖的部分，把它拆成 2 到 8 个这样的部分，每行一个，格式为"序号. 子任务"，每个子任务都要写完整、能单独执行。如果不能拆分，只输出"1. "加原任务。不要输出其他内容。`
+	splitPromptEn = `You plan phone automation tasks. If the user's task has parts that can be done at the same time on different phones, independently of each other, split it into 2 to 8 such parts, one per line as "N. subtask", each complete enough to run on its own. If it cannot be split, output only "1. " followed by the task. Output nothing else.`
+
+	mergePromptCn = "你汇总多台手机并行完成的子任务结果。根据原任务和各子任务的结果，写一段简洁的最终答复，只输出答复本身。"
+	mergePromptEn = "You combine the results of subtasks done in parallel on several phones. From the task and the result of each subtask, write one concise final answer, and output only the answer."
+
+	mergeInputCn = "任务：%s\n\n子任务结果：\n%s"
+	mergeInputEn = "Task: %s\n\nSubtask results:\n%s"
+)
+
+// TaskSplitter has the planner model split an instruction into parts run in
+// parallel on several devices, and merge their results.
+type TaskSplitter struct {
+	Planner *llm.ModelClient
+	Lang    string // of the prompts, "cn" or "en"
+}
+
+// PartResult is the outcome of a part of a split instruction.
+type PartResult struct {
+	Instruction string
+	Message     string // the finish message of its task
+}
+
+// Split returns the parts of instruction that run independently, the
+// instruction alone when it has none.
+func (r *TaskSplitter) Split(ctx context.Context, instruction string) ([]string, error) {
+	system := splitPromptCn
+	if r.Lang == "en" {
+		system = splitPromptEn
+	}
+	content, err := r.ask(ctx, system, instruction)
+	if err != nil {
+		return nil, fmt.Errorf("failed to split the instruction: %w", err)
+	}
+	parts := parseSubgoals(content)
+	if len(parts) < 2 {
+		return []string{instruction}, nil
+	}
+	if len(parts) > maxParallelParts {
+		parts = parts[:maxParallelParts]
+	}
+	return parts, nil
+}
+
+// Merge combines the results of the parts of instruction into one finish
+// message. When the planner fails the messages are listed as they are.
+func (r *TaskSplitter) Merge(ctx context.Context, instruction string, results []PartResult) string {
+	if len(results) == 1 {
+		return results[0].Message
+	}
+	lines := make([]string, len(results))
+	for i, result := range results {
+		lines[i] = fmt.Sprintf("%d. %s: %s", i+1, result.Instruction, result.Message)
+	}
+	listed := strings.Join(lines, "\n")
+
+	system, input := mergePromptCn, mergeInputCn
+	if r.Lang == "en" {
+		system, input = mergePromptEn, mergeInputEn
+	}
+	content, err := r.ask(ctx, system, fmt.Sprintf(input, instruction, listed))
+	if content = strings.TrimSpace(content); err != nil || content == "" {
+		return listed
+	}
+	return content
+}
+
+// ask sends a one-off text request to the planner model.
+func (r *TaskSplitter) ask(ctx context.Context, system, text string) (string, error) {
+	messages := []openai.ChatCompletionMessage{
+		helper.CreateSystemMessage(system),
+		helper.CreateUserMessage(text, nil),
+	}
+	response, err := r.Planner.Request(ctx, messages)
+	if err != nil {
+		return "", err
+	}
+	return response.RawContent, nil
+}
@@ -0,0 +1,51 @@
+package phoneagent
+
+import (
+	"context"
+	"time"
+)
+
+type stepLimitsKey struct{}
+
+// StepLimits bound the steps of a task, zero fields keep those of the
+// agent's config.
+type StepLimits struct {
+	MaxSteps    int           // AgentConfig.MaxSteps
+	StepTimeout time.Duration // AgentConfig.StepTimeout
+	MaxRepeats  int           // AgentConfig.MaxRepeats
+}
+
+// WithStepLimits returns ctx whose tasks run with limits.
+func WithStepLimits(ctx context.Context, limits StepLimits) context.Context {
+	if limits == (StepLimits{}) {
+		return ctx
+	}
+	return context.WithValue(ctx, stepLimitsKey{}, limits)
+}
+
+// useStepLimits switches the agent to the StepLimits of ctx for a task and
+// returns a function switching it back. A step timeout that does not fit
+// between the action and task timeouts fails the task.
+func (r *PhoneAgent) useStepLimits(ctx context.Context) (func(), error) {
+	limits, ok := ctx.Value(stepLimitsKey{}).(StepLimits)
+	if !ok {
+		return func() {}, nil
+	}
+	config := r.AgentConfig
+	limited := *config
+	// a watch check has its own step limit
+	if limits.MaxSteps > 0 && !isWatchCheck(ctx) {
+		limited.MaxSteps = limits.MaxSteps
+	}
+	if limits.StepTimeout > 0 {
+		limited.StepTimeout = limits.StepTimeout
+	}
+	if limits.MaxRepeats > 0 {
+		limited.MaxRepeats = limits.MaxRepeats
+	}
+	if err := limited.ValidateTimeouts(); err != nil {
+		return nil, err
+	}
+	r.AgentConfig = &limited
+	return func() { r.AgentConfig = config }, nil
+}
@@ -0,0 +1,221 @@
+// Package store persists agent sessions, so that a task interrupted by a
+// crash or a network outage can be resumed where it stopped.
+//
+// A session is kept as two files in the store directory: <id>.json, the
+// conversation and progress rewritten after every step, and <id>.steps.jsonl,
+// one line per executed action. Screenshots are not stored, only their
+// metadata; a resumed task starts from a fresh screenshot.
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"autoglm-go/phoneagent/seal"
+	"autoglm-go/phoneagent/trajectory"
+	"autoglm-go/utils"
+	"github.com/sashabaranov/go-openai"
+)
+
+// ErrNotFound is returned for a session that is not in the store.
+var ErrNotFound = errors.New("session not found")
+
+type Status string
+
+const (
+	StatusRunning  Status = "running"
+	StatusFinished Status = "finished"
+	StatusFailed   Status = "failed"
+
+	StatusPaused    Status = "paused"    // waiting for the task to be resumed
+	StatusCancelled Status = "cancelled" // stopped by the user, it can be resumed still
+)
+
+// imagePlaceholder replaces the screenshots of stored messages.
+const imagePlaceholder = "** Screenshot (not stored) **"
+
+var idRe = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Session is the state of a task after its last completed step.
+type Session struct {
+	ID        string                         `json:"id"`
+	Task      string                         `json:"task"`
+	DeviceID  string                         `json:"device_id"`
+	Labels    map[string]string              `json:"labels,omitempty"`
+	Status    Status                         `json:"status"`
+	Steps     int                            `json:"steps"` // steps completed
+	Result    string                         `json:"result,omitempty"`
+	Error     string                         `json:"error,omitempty"`
+	Messages  []openai.ChatCompletionMessage `json:"messages"` // without images
+	CreatedAt time.Time                      `json:"created_at"`
+	UpdatedAt time.Time                      `json:"updated_at"`
+}
+
+// Step is an executed action and the screenshot it was taken on.
+type Step struct {
+	trajectory.Step
+	AgentStep  int        `json:"step"` // step count of the agent, the index counts actions only
+	Screenshot Screenshot `json:"screenshot"`
+}
+
+type Screenshot struct {
+	Width     int  `json:"width"`
+	Height    int  `json:"height"`
+	Bytes     int  `json:"bytes"`
+	Sensitive bool `json:"sensitive,omitempty"`
+}
+
+// Store keeps sessions in a directory.
+type Store struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create session dir: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Save writes the session, replacing the previous version at once so that a
+// crash leaves either the old or the new one. Images are removed from a copy
+// of the messages.
+func (s *Store) Save(session *Session) error {
+	if !idRe.MatchString(session.ID) {
+		return fmt.Errorf("invalid session id %q", session.ID)
+	}
+	stored := *session
+	stored.UpdatedAt = time.Now()
+	stored.Messages = make([]openai.ChatCompletionMessage, len(session.Messages))
+	for i, msg := range session.Messages {
+		stored.Messages[i] = withoutImages(msg)
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tmp, err := os.CreateTemp(s.dir, ".session-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(seal.Data(data)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.sessionPath(session.ID))
+}
+
+// AppendStep adds a step to the step log of the session.
+func (s *Store) AppendStep(id string, step Step) error {
+	if !idRe.MatchString(id) {
+		return fmt.Errorf("invalid session id %q", id)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file, err := os.OpenFile(s.stepsPath(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open step log: %w", err)
+	}
+	if _, err := file.WriteString(seal.Line(utils.JsonString(step)) + "\n"); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write step log: %w", err)
+	}
+	return file.Close()
+}
+
+// Load reads a session and its steps. Steps logged after the last Save, by a
+// step that did not complete, are left out.
+func (s *Store) Load(id string) (*Session, []Step, error) {
+	if !idRe.MatchString(id) {
+		return nil, nil, fmt.Errorf("invalid session id %q", id)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := seal.ReadFile(s.sessionPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, fmt.Errorf("%w: %s in %s", ErrNotFound, id, s.dir)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, nil, fmt.Errorf("invalid session %s: %w", id, err)
+	}
+
+	var steps []Step
+	file, err := os.Open(s.stepsPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return &session, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line, err := seal.OpenLine(scanner.Bytes())
+		if err != nil {
+			break
+		}
+		var step Step
+		if err := json.Unmarshal(line, &step); err != nil {
+			// a line cut short by a crash
+			break
+		}
+		if step.AgentStep > session.Steps {
+			continue
+		}
+		// a resumed session logs the steps after the last Save again
+		for len(steps) > 0 && steps[len(steps)-1].AgentStep >= step.AgentStep {
+			steps = steps[:len(steps)-1]
+		}
+		steps = append(steps, step)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read step log of %s: %w", id, err)
+	}
+	return &session, steps, nil
+}
+
+func (s *Store) sessionPath(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func (s *Store) stepsPath(id string) string {
+	return filepath.Join(s.dir, id+".steps.jsonl")
+}
+
+// withoutImages returns msg with its images replaced by a placeholder,
+// leaving msg itself untouched.
+func withoutImages(msg openai.ChatCompletionMessage) openai.ChatCompletionMessage {
+	if msg.MultiContent == nil {
+		return msg
+	}
+	parts := make([]openai.ChatMessagePart, 0, len(msg.MultiContent))
+	for _, part := range msg.MultiContent {
+		if part.Type == openai.ChatMessagePartTypeImageURL {
+			part = openai.ChatMessagePart{Type: openai.ChatMessagePartTypeText, Text: imagePlaceholder}
+		}
+		parts = append(parts, part)
+	}
+	msg.MultiContent = parts
+	return msg
+}
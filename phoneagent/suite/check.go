@@ -0,0 +1,150 @@
+package suite
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"autoglm-go/phoneagent/definitions"
+)
+
+// Device is what the checks need of the device driver.
+type Device interface {
+	GetCurrentApp(ctx context.Context, deviceID string) (string, error)
+	DumpUI(ctx context.Context, deviceID string) ([]definitions.UIElement, error)
+}
+
+// Sheller is implemented by drivers with a shell on the device, for the
+// checks of Check.Shell.
+type Sheller interface {
+	Shell(ctx context.Context, deviceID string, args ...string) (string, error)
+}
+
+// Check is a condition on the device once a case ran, set in Expect.Checks.
+// The model may claim a success it did not achieve, as in AndroidWorld the
+// state of the device decides. Each field set is checked:
+//
+//	{"screen_contains": "已开启"}
+//	{"app": "设置"}
+//	{"shell": "settings get global airplane_mode_on", "matches": "^1$"}
+//	{"script": "./checks/order_placed.sh"}
+type Check struct {
+	ScreenContains string `json:"screen_contains,omitempty"` // text of an element of the final screen
+	App            string `json:"app,omitempty"`             // app in the foreground, by name
+	// Shell runs on the device, it passes when its output matches the
+	// regexp Matches, or when it does not fail without Matches.
+	Shell   string `json:"shell,omitempty"`
+	Matches string `json:"matches,omitempty"`
+	// Script runs on the host with sh -c and passes with exit status 0. It
+	// gets the device in AUTOGLM_DEVICE_ID and the case in AUTOGLM_CASE.
+	Script string `json:"script,omitempty"`
+
+	matches *regexp.Regexp
+}
+
+// scriptTimeout bounds Check.Script and Case.Setup.
+const scriptTimeout = 2 * time.Minute
+
+func (c *Check) compile() error {
+	if c.ScreenContains == "" && c.App == "" && c.Shell == "" && c.Script == "" {
+		return fmt.Errorf("empty check")
+	}
+	if c.Matches != "" {
+		if c.Shell == "" {
+			return fmt.Errorf("matches needs a shell command")
+		}
+		var err error
+		if c.matches, err = regexp.Compile(c.Matches); err != nil {
+			return fmt.Errorf("invalid matches pattern: %w", err)
+		}
+	}
+	return nil
+}
+
+// run returns why the device does not meet the check, nil when it does.
+func (c *Check) run(ctx context.Context, device Device, r run) []string {
+	var failures []string
+	if c.ScreenContains != "" {
+		if err := screenContains(ctx, device, r.deviceID, c.ScreenContains); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if c.App != "" {
+		app, err := device.GetCurrentApp(ctx, r.deviceID)
+		switch {
+		case err != nil:
+			failures = append(failures, fmt.Sprintf("app check failed: %v", err))
+		case app != c.App:
+			failures = append(failures, fmt.Sprintf("app %s in the foreground, want %s", app, c.App))
+		}
+	}
+	if c.Shell != "" {
+		if err := c.runShell(ctx, device, r.deviceID); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if c.Script != "" {
+		if err := runScript(ctx, c.Script, r); err != nil {
+			failures = append(failures, fmt.Sprintf("script %s: %v", c.Script, err))
+		}
+	}
+	return failures
+}
+
+func screenContains(ctx context.Context, device Device, deviceID, text string) error {
+	elements, err := device.DumpUI(ctx, deviceID)
+	if err != nil {
+		return fmt.Errorf("screen check failed: %v", err)
+	}
+	for _, element := range elements {
+		if strings.Contains(element.Text, text) || strings.Contains(element.ContentDesc, text) {
+			return nil
+		}
+	}
+	return fmt.Errorf("screen does not contain %q", text)
+}
+
+func (c *Check) runShell(ctx context.Context, device Device, deviceID string) error {
+	sheller, ok := device.(Sheller)
+	if !ok {
+		return fmt.Errorf("shell check %q: the device has no shell", c.Shell)
+	}
+	output, err := sheller.Shell(ctx, deviceID, c.Shell)
+	output = strings.TrimSpace(output)
+	if err != nil {
+		return fmt.Errorf("shell check %q failed: %v", c.Shell, err)
+	}
+	if c.matches != nil && !c.matches.MatchString(output) {
+		return fmt.Errorf("shell check %q output %q does not match %s", c.Shell, output, c.Matches)
+	}
+	return nil
+}
+
+// runScript runs a command of the suite on the host for the case of r.
+func runScript(ctx context.Context, script string, r run) error {
+	ctx, cancel := context.WithTimeout(ctx, scriptTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", script)
+	cmd.Env = append(os.Environ(), "AUTOGLM_DEVICE_ID="+r.deviceID, "AUTOGLM_CASE="+r.c.Name)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if out := strings.TrimSpace(string(output)); out != "" {
+			return fmt.Errorf("%v: %s", err, lastLine(out))
+		}
+		return err
+	}
+	return nil
+}
+
+// lastLine returns the last line of s, where scripts usually say why they
+// failed.
+func lastLine(s string) string {
+	if i := strings.LastIndex(s, "\n"); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
@@ -0,0 +1,80 @@
+package suite
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Score sums up the report of a model profile.
+type Score struct {
+	Profile     string  `json:"profile"` // "main" for the main model
+	Model       string  `json:"model,omitempty"`
+	Runs        int     `json:"runs"`
+	Passed      int     `json:"passed"`
+	SuccessRate float64 `json:"success_rate"` // 0 to 1
+	AvgSteps    float64 `json:"avg_steps"`
+	AvgCost     float64 `json:"avg_cost"`
+	Cost        float64 `json:"cost"`
+	Tokens      int     `json:"tokens"`
+	Duration    float64 `json:"duration"` // seconds
+}
+
+// Comparison is the suite run once per model profile, written as
+// comparison.json by Write.
+type Comparison struct {
+	Suite  string  `json:"suite"`
+	Scores []Score `json:"scores"` // in the order of the reports
+}
+
+// Compare scores the reports of a suite, one per model profile.
+func Compare(reports []*Report) *Comparison {
+	comparison := &Comparison{}
+	for _, r := range reports {
+		comparison.Suite = r.Suite
+		score := Score{
+			Profile:  r.Profile,
+			Model:    r.Model,
+			Runs:     len(r.Results),
+			Passed:   r.Passed,
+			Cost:     r.Cost,
+			Tokens:   r.Tokens,
+			Duration: r.Duration,
+		}
+		if score.Profile == "" {
+			score.Profile = "main"
+		}
+		if score.Runs > 0 {
+			score.SuccessRate = float64(r.Passed) / float64(score.Runs)
+			score.AvgSteps = float64(r.Steps) / float64(score.Runs)
+			score.AvgCost = r.Cost / float64(score.Runs)
+		}
+		comparison.Scores = append(comparison.Scores, score)
+	}
+	return comparison
+}
+
+// String renders the comparison as a table, one line per model profile.
+func (c *Comparison) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%-16s %-24s %8s %8s %9s %9s\n", "PROFILE", "MODEL", "SUCCESS", "PASSED", "AVG STEP", "AVG COST")
+	for _, s := range c.Scores {
+		fmt.Fprintf(&sb, "%-16s %-24s %7.1f%% %4d/%-3d %9.1f %9.4f\n",
+			s.Profile, s.Model, s.SuccessRate*100, s.Passed, s.Runs, s.AvgSteps, s.AvgCost)
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// Write writes the comparison to dir as comparison.json.
+func (c *Comparison) Write(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "comparison.json"), data, 0o644)
+}
@@ -0,0 +1,146 @@
+package suite
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"autoglm-go/phoneagent"
+)
+
+// Report is the result of a suite, written as report.json and report.html by
+// Write.
+type Report struct {
+	Suite      string       `json:"suite"`
+	Profile    string       `json:"profile,omitempty"` // the model profile, the main model when empty
+	Model      string       `json:"model,omitempty"`
+	StartedAt  time.Time    `json:"started_at"`
+	FinishedAt time.Time    `json:"finished_at"`
+	Duration   float64      `json:"duration"` // seconds
+	Passed     int          `json:"passed"`
+	Failed     int          `json:"failed"`
+	Steps      int          `json:"steps"`
+	Tokens     int          `json:"tokens"`
+	Cost       float64      `json:"cost"`
+	Results    []CaseResult `json:"results"` // in the order of the cases, then of the devices
+}
+
+// CaseResult is the result of a case on one device.
+type CaseResult struct {
+	Case        string                  `json:"case"`
+	Instruction string                  `json:"instruction"`
+	DeviceID    string                  `json:"device_id"`
+	Pass        bool                    `json:"pass"`
+	Failures    []string                `json:"failures,omitempty"` // why it did not pass
+	Outcome     phoneagent.OutcomeLevel `json:"outcome,omitempty"`  // empty when the task did not run
+	Reason      string                  `json:"reason,omitempty"`
+	Message     string                  `json:"message,omitempty"`
+	Error       string                  `json:"error,omitempty"`
+	Output      map[string]any          `json:"output,omitempty"`
+	Steps       int                     `json:"steps"`
+	Duration    float64                 `json:"duration"` // seconds
+	Tokens      int                     `json:"tokens"`
+	Cost        float64                 `json:"cost"`
+	StartedAt   time.Time               `json:"started_at"`
+}
+
+func (r *Report) total() {
+	r.Duration = r.FinishedAt.Sub(r.StartedAt).Seconds()
+	for _, result := range r.Results {
+		if result.Pass {
+			r.Passed++
+		} else {
+			r.Failed++
+		}
+		r.Steps += result.Steps
+		r.Tokens += result.Tokens
+		r.Cost += result.Cost
+	}
+}
+
+// String renders the report as a table, one line per case and device, and a
+// summary.
+func (r *Report) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%-6s %-24s %6s %8s %8s  %s\n", "RESULT", "DEVICE", "STEPS", "TIME", "COST", "CASE")
+	for _, result := range r.Results {
+		status := "pass"
+		if !result.Pass {
+			status = "FAIL"
+		}
+		elapsed := time.Duration(result.Duration * float64(time.Second)).Round(time.Second)
+		fmt.Fprintf(&sb, "%-6s %-24s %6d %8s %8.4f  %s\n", status, result.DeviceID, result.Steps, elapsed, result.Cost, result.Case)
+		for _, failure := range result.Failures {
+			fmt.Fprintf(&sb, "%-6s %-24s %6s %8s %8s  → %s\n", "", "", "", "", "", failure)
+		}
+	}
+	fmt.Fprintf(&sb, "%s: %d passed, %d failed in %s; %d steps, %d tokens, cost %.4f",
+		r.Suite, r.Passed, r.Failed, time.Duration(r.Duration*float64(time.Second)).Round(time.Second), r.Steps, r.Tokens, r.Cost)
+	return sb.String()
+}
+
+// Write writes the report to dir as report.json, for CI, and report.html.
+func (r *Report) Write(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "report.json"), data, 0o644); err != nil {
+		return err
+	}
+	var html strings.Builder
+	if err := reportTemplate.Execute(&html, r); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "report.html"), []byte(html.String()), 0o644)
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"seconds": func(s float64) string {
+		return time.Duration(s * float64(time.Second)).Round(time.Second).String()
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Suite}}: {{.Passed}} passed, {{.Failed}} failed</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #ddd; padding: 6px 8px; text-align: left; vertical-align: top; }
+td.num { text-align: right; white-space: nowrap; }
+.pass { color: #1a7f37; font-weight: bold; }
+.fail { color: #cf222e; font-weight: bold; }
+.detail { color: #666; font-size: 0.9em; }
+</style>
+</head>
+<body>
+<h1>{{.Suite}}{{if .Model}} ({{.Model}}){{end}}</h1>
+<p><span class="pass">{{.Passed}} passed</span>, <span class="fail">{{.Failed}} failed</span>
+in {{seconds .Duration}}, {{.Steps}} steps, {{.Tokens}} tokens, cost {{printf "%.4f" .Cost}}.
+Started {{.StartedAt.Format "2006-01-02 15:04:05"}}.</p>
+<table>
+<tr><th>Result</th><th>Case</th><th>Device</th><th>Outcome</th><th>Steps</th><th>Time</th><th>Cost</th></tr>
+{{range .Results}}<tr>
+<td>{{if .Pass}}<span class="pass">PASS</span>{{else}}<span class="fail">FAIL</span>{{end}}</td>
+<td>{{.Case}}<div class="detail">{{.Instruction}}</div>
+{{range .Failures}}<div class="fail detail">{{.}}</div>{{end}}
+{{if .Message}}<div class="detail">{{.Message}}</div>{{end}}
+{{if .Error}}<div class="detail">{{.Error}}</div>{{end}}</td>
+<td>{{.DeviceID}}</td>
+<td>{{.Outcome}}{{if .Reason}} ({{.Reason}}){{end}}</td>
+<td class="num">{{.Steps}}</td>
+<td class="num">{{seconds .Duration}}</td>
+<td class="num">{{printf "%.4f" .Cost}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,300 @@
+// Package suite runs a file of tasks with their expected outcomes, e.g. the
+// nightly regression of the flows of an app, and reports which passed.
+package suite
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"autoglm-go/phoneagent"
+	"autoglm-go/phoneagent/session"
+)
+
+// Suite is a file of cases:
+//
+//	{"name": "nightly",
+//	 "cases": [{"name": "login", "instruction": "打开京东并登录", "group": "canary",
+//	            "expect": {"outcome": "success", "message": "登录成功|已登录"}},
+//	           {"name": "price", "instruction": "查看购物车第一件商品的价格",
+//	            "output_schema": {"price": "number"}, "expect": {"output": {"price": 99}}},
+//	           {"name": "airplane", "instruction": "打开飞行模式", "setup": "adb shell cmd connectivity airplane-mode disable",
+//	            "expect": {"checks": [{"shell": "settings get global airplane_mode_on", "matches": "^1$"}]}}]}
+type Suite struct {
+	Name  string `json:"name"` // the file name by default
+	Cases []Case `json:"cases"`
+}
+
+// Case is a task of the suite. It runs on Device, on every device of Group,
+// or else on the default device.
+type Case struct {
+	Name         string                  `json:"name"` // the instruction by default
+	Instruction  string                  `json:"instruction"`
+	Device       string                  `json:"device,omitempty"`
+	Group        string                  `json:"group,omitempty"`
+	OutputSchema phoneagent.OutputSchema `json:"output_schema,omitempty"` // fields extracted for Expect.Output
+	Expect       Expect                  `json:"expect,omitempty"`
+	// Setup runs on the host with sh -c before the case, e.g. to reset the
+	// state of an app, as Check.Script. The case fails without running when
+	// it fails.
+	Setup string `json:"setup,omitempty"`
+}
+
+// Expect is the outcome a case passes with, every field left out is not
+// checked.
+type Expect struct {
+	Outcome  phoneagent.OutcomeLevel `json:"outcome,omitempty"` // success by default
+	Reason   string                  `json:"reason,omitempty"`  // e.g. policy_deadlock for a task that must be refused
+	Message  string                  `json:"message,omitempty"` // regexp the finish message matches
+	Output   map[string]any          `json:"output,omitempty"`  // values of output fields
+	MaxSteps int                     `json:"max_steps,omitempty"`
+	Checks   []Check                 `json:"checks,omitempty"` // on the device once the case ran
+
+	message *regexp.Regexp
+}
+
+// Load reads and checks the suite at path.
+func Load(path string) (*Suite, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Suite
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("invalid suite %s: %w", path, err)
+	}
+	if s.Name == "" {
+		s.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if len(s.Cases) == 0 {
+		return nil, fmt.Errorf("suite %s has no cases", path)
+	}
+	for i := range s.Cases {
+		c := &s.Cases[i]
+		if strings.TrimSpace(c.Instruction) == "" {
+			return nil, fmt.Errorf("case %d of suite %s has no instruction", i+1, path)
+		}
+		if c.Name == "" {
+			c.Name = c.Instruction
+		}
+		if c.Device != "" && c.Group != "" {
+			return nil, fmt.Errorf("case %s: device and group cannot be combined", c.Name)
+		}
+		if len(c.OutputSchema) > 0 || len(c.Expect.Output) > 0 {
+			if err := c.OutputSchema.Validate(); err != nil {
+				return nil, fmt.Errorf("case %s: %w", c.Name, err)
+			}
+			for name := range c.Expect.Output {
+				if _, ok := c.OutputSchema[name]; !ok {
+					return nil, fmt.Errorf("case %s: expected output %s is not in output_schema", c.Name, name)
+				}
+			}
+		}
+		switch c.Expect.Outcome {
+		case "":
+			c.Expect.Outcome = phoneagent.OutcomeSuccess
+		case phoneagent.OutcomeSuccess, phoneagent.OutcomePartial, phoneagent.OutcomeFailed:
+		default:
+			return nil, fmt.Errorf("case %s: invalid outcome %q, want success, partial or failed", c.Name, c.Expect.Outcome)
+		}
+		if c.Expect.Message != "" {
+			if c.Expect.message, err = regexp.Compile(c.Expect.Message); err != nil {
+				return nil, fmt.Errorf("case %s: invalid message pattern: %w", c.Name, err)
+			}
+		}
+		for j := range c.Expect.Checks {
+			if err := c.Expect.Checks[j].compile(); err != nil {
+				return nil, fmt.Errorf("case %s: check %d: %w", c.Name, j+1, err)
+			}
+		}
+	}
+	return &s, nil
+}
+
+// Options tune Run.
+type Options struct {
+	Device   string // of the cases without a device or group
+	Parallel int    // cases running at once, 1 (one after another) by default
+	// Devices, when set, take the cases without a device or group in turn
+	// instead of Device, e.g. emulators provisioned for the run.
+	Devices []string
+	// DevicesOf returns the devices of a group and its subgroups, nil
+	// rejects the cases with a group.
+	DevicesOf func(group string) []string
+	// Driver runs Expect.Checks, nil rejects the cases with checks.
+	Driver Device
+	// Profile is the model profile the cases run with, see
+	// phoneagent.WithModelProfile, the main model when empty.
+	Profile string
+}
+
+// run is a case on one device.
+type run struct {
+	c        *Case
+	deviceID string
+}
+
+// Run runs the cases in the sessions of manager and checks their results. A
+// case of a group runs once per device of the group. The cases of one device
+// always run one after another, see session.Manager.
+func Run(ctx context.Context, manager *session.Manager, s *Suite, opts Options) (*Report, error) {
+	var runs []run
+	spread := 0
+	for i := range s.Cases {
+		c := &s.Cases[i]
+		if len(c.Expect.Checks) > 0 && opts.Driver == nil {
+			return nil, fmt.Errorf("case %s has checks but no device driver", c.Name)
+		}
+		switch {
+		case c.Device != "":
+			runs = append(runs, run{c, c.Device})
+		case c.Group != "":
+			if opts.DevicesOf == nil {
+				return nil, fmt.Errorf("case %s needs device groups, see --groups-file", c.Name)
+			}
+			devices := opts.DevicesOf(c.Group)
+			if len(devices) == 0 {
+				return nil, fmt.Errorf("case %s: group %s has no devices", c.Name, c.Group)
+			}
+			for _, deviceID := range devices {
+				runs = append(runs, run{c, deviceID})
+			}
+		case len(opts.Devices) > 0:
+			runs = append(runs, run{c, opts.Devices[spread%len(opts.Devices)]})
+			spread++
+		default:
+			if opts.Device == "" {
+				return nil, fmt.Errorf("case %s has no device, see --device-id", c.Name)
+			}
+			runs = append(runs, run{c, opts.Device})
+		}
+	}
+
+	// a case holds its device until its checks are done, the next case on
+	// it would change the screen
+	locks := map[string]*sync.Mutex{}
+	for _, r := range runs {
+		if locks[r.deviceID] == nil {
+			locks[r.deviceID] = &sync.Mutex{}
+		}
+	}
+
+	report := &Report{Suite: s.Name, Profile: opts.Profile, StartedAt: time.Now(), Results: make([]CaseResult, len(runs))}
+	parallel := max(opts.Parallel, 1)
+	slots := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, r := range runs {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			locks[r.deviceID].Lock()
+			defer locks[r.deviceID].Unlock()
+			report.Results[i] = runCase(ctx, manager, r, opts)
+		}()
+	}
+	wg.Wait()
+	report.FinishedAt = time.Now()
+	report.total()
+	return report, nil
+}
+
+// runCase runs a case even when the same task has just run on the device,
+// e.g. by the previous suite, then its checks.
+func runCase(ctx context.Context, manager *session.Manager, r run, opts Options) CaseResult {
+	ctx = session.WithForce(phoneagent.WithModelProfile(ctx, opts.Profile))
+	if len(r.c.OutputSchema) > 0 {
+		ctx = phoneagent.WithOutputSchema(ctx, r.c.OutputSchema)
+	}
+	started := time.Now()
+	var result *session.Result
+	if err := setup(ctx, r); err != nil {
+		result = &session.Result{Err: err, StartedAt: started, FinishedAt: time.Now()}
+	} else if _, results, err := manager.Submit(ctx, r.deviceID, r.c.Instruction); err != nil {
+		result = &session.Result{Err: err, StartedAt: started, FinishedAt: time.Now()}
+	} else {
+		result = <-results
+	}
+
+	cr := CaseResult{
+		Case:        r.c.Name,
+		Instruction: r.c.Instruction,
+		DeviceID:    r.deviceID,
+		Message:     result.Message,
+		Steps:       result.Steps,
+		Tokens:      result.Usage.TotalTokens(),
+		Cost:        result.Usage.Cost,
+		Output:      result.Output,
+		StartedAt:   result.StartedAt,
+	}
+	if !result.StartedAt.IsZero() {
+		cr.Duration = result.FinishedAt.Sub(result.StartedAt).Seconds()
+	}
+	if result.Err != nil {
+		cr.Error = result.Err.Error()
+	}
+	if result.Outcome != nil {
+		cr.Outcome, cr.Reason = result.Outcome.Level, result.Outcome.Reason
+	}
+	cr.Failures = r.c.Expect.check(cr)
+	if cr.Outcome != "" {
+		for i := range r.c.Expect.Checks {
+			cr.Failures = append(cr.Failures, r.c.Expect.Checks[i].run(ctx, opts.Driver, r)...)
+		}
+	}
+	cr.Pass = len(cr.Failures) == 0
+	return cr
+}
+
+// setup runs the setup of the case of r, if any.
+func setup(ctx context.Context, r run) error {
+	if r.c.Setup == "" {
+		return nil
+	}
+	if err := runScript(ctx, r.c.Setup, r); err != nil {
+		return fmt.Errorf("setup failed: %w", err)
+	}
+	return nil
+}
+
+// check returns why the result does not meet the expectation.
+func (e Expect) check(r CaseResult) []string {
+	var failures []string
+	if r.Outcome == "" {
+		return []string{"did not run: " + r.Error}
+	}
+	if r.Outcome != e.Outcome {
+		failures = append(failures, fmt.Sprintf("outcome %s (%s), want %s", r.Outcome, r.Reason, e.Outcome))
+	}
+	if e.Reason != "" && r.Reason != e.Reason {
+		failures = append(failures, fmt.Sprintf("reason %s, want %s", r.Reason, e.Reason))
+	}
+	if e.message != nil && !e.message.MatchString(r.Message) {
+		failures = append(failures, fmt.Sprintf("message %q does not match %s", r.Message, e.Message))
+	}
+	for name, want := range e.Output {
+		if got, ok := r.Output[name]; !ok || !sameJSON(got, want) {
+			failures = append(failures, fmt.Sprintf("output %s is %v, want %v", name, got, want))
+		}
+	}
+	if e.MaxSteps > 0 && r.Steps > e.MaxSteps {
+		failures = append(failures, fmt.Sprintf("%d steps, want at most %d", r.Steps, e.MaxSteps))
+	}
+	return failures
+}
+
+// sameJSON reports whether a and b encode to the same JSON, so that 99 and
+// 99.0 are equal.
+func sameJSON(a, b any) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ja, jb)
+}
@@ -0,0 +1,147 @@
+package phoneagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/metrics"
+	"autoglm-go/phoneagent/policy"
+	"autoglm-go/phoneagent/tracing"
+	"github.com/sashabaranov/go-openai"
+)
+
+// Timeout errors, from the innermost stage to the outermost. A stage that
+// runs out of time ends with its own error even when an outer one is set.
+var (
+	ErrActionTimeout = errors.New("action timed out")
+	ErrStepTimeout   = errors.New("step timed out")
+	ErrTaskTimeout   = errors.New("task timed out")
+)
+
+// withTimeout ends ctx after limit with cause, 0 keeps ctx as it is.
+func withTimeout(ctx context.Context, limit time.Duration, cause error) (context.Context, context.CancelFunc) {
+	if limit <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeoutCause(ctx, limit, cause)
+}
+
+// timedOut reports whether ctx ended because of the timeout of cause, rather
+// than of an outer stage or a cancellation.
+func timedOut(ctx context.Context, cause error) bool {
+	return ctx.Err() != nil && errors.Is(context.Cause(ctx), cause)
+}
+
+// ExecuteAction runs the action within AgentConfig.ActionTimeout. An action
+// that runs out of time is reported to the model as failed, and the task goes
+// on from whatever the screen shows. Actions are checked against the policy
+// first, those that need the user are confirmed, see Confirmer, and none but
+// finish runs in a dry run.
+func (r *PhoneAgent) ExecuteAction(ctx context.Context, action helper.Action, screenWidth, screenHeight int) (helper.ActionResult, error) {
+	if result, ok := r.blockInput(action); ok {
+		return result, nil
+	}
+	if result, ok := r.groundPoints(ctx, action); !ok {
+		return result, nil
+	}
+	verdict, result, ok := r.checkPolicy(ctx, action, screenWidth, screenHeight)
+	if !ok {
+		return result, nil
+	}
+	if result, ok := r.dryRun(action); ok {
+		return result, nil
+	}
+	kind, message, needed := humanAction(action)
+	if verdict.Decision == policy.Confirm && kind != ConfirmTakeover {
+		kind, message, needed = ConfirmAction, fmt.Sprintf("%s: %v", verdict.Reason, action["action"]), true
+	}
+	if needed {
+		if result, ok := r.confirmAction(ctx, kind, message); !ok {
+			return result, nil
+		}
+		// the step may be over after waiting for the user, the action gets
+		// its own time regardless
+		if r.taskCtx != nil {
+			ctx = r.taskCtx
+		}
+	}
+	if result, ok := r.beforeExecute(ctx, action); !ok {
+		return result, nil
+	}
+	started := time.Now()
+	result, err := r.runAction(ctx, action, screenWidth, screenHeight)
+	r.afterExecute(ctx, action, result, err, time.Since(started))
+	return result, err
+}
+
+// runAction executes action on the device within AgentConfig.ActionTimeout.
+func (r *PhoneAgent) runAction(ctx context.Context, action helper.Action, screenWidth, screenHeight int) (helper.ActionResult, error) {
+	ctx, cancel := withTimeout(ctx, r.AgentConfig.ActionTimeout, ErrActionTimeout)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "device.action", tracing.String("action.type", actionType(action)))
+	defer span.End()
+	result, err := r.executeAction(ctx, action, screenWidth, screenHeight)
+	span.SetAttributes(tracing.Bool("action.success", result.Success))
+	span.RecordError(err)
+	if timedOut(ctx, ErrActionTimeout) {
+		r.log().Warnf("%v after %s: %v", ErrActionTimeout, r.AgentConfig.ActionTimeout, action["action"])
+		return helper.ActionResult{
+			Success: false,
+			Message: fmt.Sprintf("%v after %s", ErrActionTimeout, r.AgentConfig.ActionTimeout),
+		}, nil
+	}
+	return result, err
+}
+
+// ExecuteStep runs one step within AgentConfig.StepTimeout. A step that runs
+// out of time counts as failed: its screenshot is dropped from the context,
+// the model is told with the next observation and the task goes on.
+func (r *PhoneAgent) ExecuteStep(ctx context.Context, userPrompt string, isFirstStep bool) (*StepResult, error) {
+	stepCtx, cancel := withTimeout(ctx, r.AgentConfig.StepTimeout, ErrStepTimeout)
+	defer cancel()
+	stepCtx, span := tracing.Start(stepCtx, "agent.step")
+	var result *StepResult
+	var err error
+	started, usage := time.Now(), r.Usage.Models()
+	defer func() {
+		metrics.StepDuration.Observe(time.Since(started).Seconds())
+		r.checkAnomalies(ctx, started, usage)
+		r.endStep(span, result, err)
+	}()
+
+	r.humanWaited = false
+	result, err = r.executeStep(stepCtx, userPrompt, isFirstStep)
+	// time spent waiting for the user does not time out the step
+	if !timedOut(stepCtx, ErrStepTimeout) || (err == nil && result.Finished) || r.humanWaited {
+		r.finishRecord(result, err)
+		return result, err
+	}
+
+	message := fmt.Sprintf("%v after %s", ErrStepTimeout, r.AgentConfig.StepTimeout)
+	r.log().Warnf("step %d: %s", r.StepCount, message)
+	// the screenshot is still the last message when the model did not answer
+	if n := len(r.State); n > 0 && r.State[n-1].Role == openai.ChatMessageRoleUser {
+		r.State[n-1] = helper.RemoveImagesFromMessage(r.State[n-1])
+	}
+	r.dropObservation()
+	r.dropSpeculation()
+	r.lastStepOK = false
+	r.hookObservations = append(r.hookObservations, "previous "+message)
+	result = &StepResult{Success: false, Finished: false, Message: message}
+	r.finishRecord(result, nil)
+	return result, nil
+}
+
+// taskTimeoutError is the error of Run once AgentConfig.TaskTimeout is over,
+// nil before. The task stops, unlike after an action or step timeout.
+func (r *PhoneAgent) taskTimeoutError(ctx context.Context) error {
+	if !timedOut(ctx, ErrTaskTimeout) {
+		return nil
+	}
+	r.log().Errorf("%v after %s at step %d", ErrTaskTimeout, r.AgentConfig.TaskTimeout, r.StepCount)
+	return fmt.Errorf("%w after %s at step %d", ErrTaskTimeout, r.AgentConfig.TaskTimeout, r.StepCount)
+}
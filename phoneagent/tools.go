@@ -0,0 +1,87 @@
+package phoneagent
+
+import (
+	"sort"
+
+	"autoglm-go/phoneagent/helper"
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+// actionTools describes do() and finish() as tools for ModelConfig.ToolCalls.
+// The arguments are those of the text syntax, so the system prompt documents
+// both; the plugin actions enabled on the device are accepted with their own
+// arguments.
+func actionTools(deviceID string) []openai.Tool {
+	actions := make([]string, 0, len(builtinActions))
+	for name := range builtinActions {
+		actions = append(actions, name)
+	}
+	sort.Strings(actions)
+	for _, plugin := range ActionPlugins() {
+		if pluginEnabled(plugin, deviceID) {
+			actions = append(actions, plugin.Name())
+		}
+	}
+
+	point := func(description string) jsonschema.Definition {
+		return jsonschema.Definition{
+			Type:        jsonschema.Array,
+			Description: description,
+			Items:       &jsonschema.Definition{Type: jsonschema.Integer},
+		}
+	}
+	path := jsonschema.Definition{
+		Type:        jsonschema.Array,
+		Description: "Drag points [[x1,y1], [x2,y2], ...]",
+		Items:       &jsonschema.Definition{Type: jsonschema.Array, Items: &jsonschema.Definition{Type: jsonschema.Integer}},
+	}
+	do := jsonschema.Definition{
+		Type: jsonschema.Object,
+		Properties: map[string]jsonschema.Definition{
+			"action":       {Type: jsonschema.String, Enum: actions},
+			"app":          {Type: jsonschema.String, Description: "app to launch"},
+			"element":      point("[x,y] on the 0-999 screen grid"),
+			"start":        point("swipe start [x1,y1]"),
+			"end":          point("swipe end [x2,y2]"),
+			"text":         {Type: jsonschema.String, Description: "text to type"},
+			"message":      {Type: jsonschema.String},
+			"instruction":  {Type: jsonschema.String},
+			"duration":     {Type: jsonschema.String, Description: "e.g. 2 seconds"},
+			"seconds":      {Type: jsonschema.Number, Description: "time to wait"},
+			"text_appears": {Type: jsonschema.String, Description: "text Wait_Until waits for"},
+			"timeout":      {Type: jsonschema.Number, Description: "seconds Wait_Until waits at most"},
+			"index":        {Type: jsonschema.Integer, Description: "overlay Dismiss_Overlay closes or notification Open_Notification and Dismiss_Notification take, 1 by default"},
+			"path":         path,
+			"hold":         {Type: jsonschema.Number, Description: "seconds Drag holds its first point"},
+			"scale":        {Type: jsonschema.Number, Description: "Pinch zoom, above 1 in and below 1 out"},
+			"package":      {Type: jsonschema.String, Description: "package of the app, instead of its name"},
+			"activity":     {Type: jsonschema.String, Description: "activity Launch starts"},
+			"intent":       {Type: jsonschema.String, Description: "intent action Launch starts, e.g. android.intent.action.VIEW"},
+			"uri":          {Type: jsonschema.String, Description: "data URI or deep link Launch opens"},
+			"apk":          {Type: jsonschema.String, Description: "APK Install installs"},
+		},
+		Required:             []string{"action"},
+		AdditionalProperties: true,
+	}
+	finish := jsonschema.Definition{
+		Type: jsonschema.Object,
+		Properties: map[string]jsonschema.Definition{
+			"message": {Type: jsonschema.String, Description: "result for the user"},
+		},
+		Required: []string{"message"},
+	}
+
+	return []openai.Tool{
+		{Type: openai.ToolTypeFunction, Function: &openai.FunctionDefinition{
+			Name:        helper.ToolDo,
+			Description: "Perform one action on the phone, same as do(action=...).",
+			Parameters:  &do,
+		}},
+		{Type: openai.ToolTypeFunction, Function: &openai.FunctionDefinition{
+			Name:        helper.ToolFinish,
+			Description: "End the task, same as finish(message=...).",
+			Parameters:  &finish,
+		}},
+	}
+}
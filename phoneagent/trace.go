@@ -0,0 +1,67 @@
+package phoneagent
+
+import (
+	"context"
+
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/labels"
+	"autoglm-go/phoneagent/tracing"
+	"autoglm-go/utils"
+)
+
+// actionType names the action in spans: the do action, or finish.
+func actionType(action helper.Action) string {
+	if name := utils.AnyToString(action["action"]); name != "" {
+		return name
+	}
+	return utils.AnyToString(action["_metadata"])
+}
+
+// startTask begins the root span of a task, its steps and model calls are
+// its children. run gives the task its session id first, so that all its
+// spans carry it.
+func (r *PhoneAgent) startTask(ctx context.Context, task string) (context.Context, *tracing.Span) {
+	ctx, span := tracing.Start(ctx, "agent.task")
+	if span == nil {
+		return ctx, nil
+	}
+	span.SetAttributes(
+		tracing.String("session.id", r.SessionID),
+		tracing.String("device.id", r.AgentConfig.DeviceID),
+		tracing.String("agent.model", r.ModelConfig.ModelName),
+		tracing.Int("agent.task.length", len([]rune(task))),
+	)
+	if r.taskID != "" {
+		span.SetAttributes(tracing.String("agent.task.id", r.taskID))
+	}
+	for k, v := range labels.From(ctx) {
+		span.SetAttributes(tracing.String("label."+k, v))
+	}
+	return ctx, span
+}
+
+// endTask finishes the span of a task with its outcome.
+func (r *PhoneAgent) endTask(span *tracing.Span, err error) {
+	span.SetAttributes(tracing.Int("agent.steps", r.StepCount))
+	if r.Outcome != nil {
+		span.SetAttributes(tracing.String("agent.outcome", string(r.Outcome.Level)), tracing.String("agent.outcome.reason", r.Outcome.Reason))
+	}
+	span.RecordError(err)
+	span.End()
+}
+
+// endStep finishes the span of a step with what it did.
+func (r *PhoneAgent) endStep(span *tracing.Span, result *StepResult, err error) {
+	span.SetAttributes(tracing.Int("agent.step", r.StepCount), tracing.String("session.id", r.SessionID))
+	if obs := r.stepObservation; obs != nil && obs.currentApp != "" {
+		span.SetAttributes(tracing.String("app", obs.currentApp))
+	}
+	if result != nil {
+		if result.Action != nil {
+			span.SetAttributes(tracing.String("action.type", actionType(result.Action)))
+		}
+		span.SetAttributes(tracing.Bool("agent.step.success", result.Success), tracing.Bool("agent.step.finished", result.Finished))
+	}
+	span.RecordError(err)
+	span.End()
+}
@@ -0,0 +1,147 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Attr is an attribute of a span. Values are strings, ints, floats or bools,
+// others are exported as their fmt form.
+type Attr struct {
+	Key   string
+	Value any
+}
+
+func String(key, value string) Attr          { return Attr{key, value} }
+func Int(key string, value int) Attr         { return Attr{key, value} }
+func Float64(key string, value float64) Attr { return Attr{key, value} }
+func Bool(key string, value bool) Attr       { return Attr{key, value} }
+
+type kind int
+
+// span kinds of OTLP
+const (
+	kindInternal kind = 1
+	kindClient   kind = 3
+)
+
+// Span is an operation of a trace. A nil Span, returned when tracing is off,
+// records nothing, so callers use it unconditionally.
+type Span struct {
+	exporter *Exporter
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     kind
+	start    time.Time
+
+	mu    sync.Mutex
+	end   time.Time
+	attrs []Attr
+	err   string
+	ended bool
+}
+
+var current atomic.Pointer[Exporter]
+
+// Use exports the spans of every Start to e until the returned function is
+// called, which flushes and stops e.
+func Use(e *Exporter) (stop func(ctx context.Context)) {
+	previous := current.Swap(e)
+	return func(ctx context.Context) {
+		current.Store(previous)
+		e.Shutdown(ctx)
+	}
+}
+
+type spanKey struct{}
+
+// Start begins a span, child of the span of ctx if any, and returns ctx
+// carrying it. It returns a nil span when no exporter is in use.
+func Start(ctx context.Context, name string, attrs ...Attr) (context.Context, *Span) {
+	return start(ctx, name, kindInternal, attrs)
+}
+
+// StartClient begins a span of a call to a remote service, e.g. the model.
+func StartClient(ctx context.Context, name string, attrs ...Attr) (context.Context, *Span) {
+	return start(ctx, name, kindClient, attrs)
+}
+
+func start(ctx context.Context, name string, k kind, attrs []Attr) (context.Context, *Span) {
+	e := current.Load()
+	if e == nil {
+		return ctx, nil
+	}
+	span := &Span{exporter: e, name: name, kind: k, start: time.Now(), attrs: attrs}
+	if parent := FromContext(ctx); parent != nil {
+		span.traceID, span.parentID = parent.traceID, parent.spanID
+	} else {
+		_, _ = rand.Read(span.traceID[:])
+	}
+	_, _ = rand.Read(span.spanID[:])
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// FromContext returns the span ctx carries, nil if none.
+func FromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// Traceparent is the W3C trace context header of the span of ctx, empty
+// without one.
+func Traceparent(ctx context.Context) string {
+	span := FromContext(ctx)
+	if span == nil {
+		return ""
+	}
+	return fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(span.traceID[:]), hex.EncodeToString(span.spanID[:]))
+}
+
+// TraceID is the hex id of the trace of the span, to find it in a backend.
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.traceID[:])
+}
+
+func (s *Span) SetAttributes(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, attrs...)
+	s.mu.Unlock()
+}
+
+// RecordError marks the span as failed with err, a nil err is ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.err = err.Error()
+	s.mu.Unlock()
+}
+
+// End finishes the span and queues it for export. Only the first call counts.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended, s.end = true, time.Now()
+	s.mu.Unlock()
+	s.exporter.add(s)
+}
@@ -0,0 +1,362 @@
+package trajectory
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"autoglm-go/constants"
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/utils"
+)
+
+const (
+	FormatJSON         = "json"          // the trajectory itself, see Load
+	FormatADB          = "adb"           // POSIX shell script calling adb
+	FormatAppiumPython = "appium-python" // Appium Python client script
+)
+
+// Export writes t as a deterministic replay script. Before each step the
+// script checks that the app recorded for it is in the foreground, so a run
+// that drifts from the recording fails instead of tapping blindly.
+func Export(w io.Writer, t *Trajectory, format string) error {
+	bw := bufio.NewWriter(w)
+	var err error
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(bw)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(t)
+	case FormatADB:
+		err = exportADB(bw, t)
+	case FormatAppiumPython:
+		err = exportAppiumPython(bw, t)
+	default:
+		return fmt.Errorf("unknown export format: %s", format)
+	}
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// point converts model coordinates (0-999) to pixels of the recorded screen.
+func point(step Step, key string) (int, int, bool) {
+	element := utils.AnyToIntSlice(step.Action[key])
+	if len(element) != 2 {
+		return 0, 0, false
+	}
+	x := int(float64(element[0]) / float64(1000) * float64(step.ScreenWidth))
+	y := int(float64(element[1]) / float64(1000) * float64(step.ScreenHeight))
+	return x, y, true
+}
+
+func swipeDuration(x1, y1, x2, y2 int) int {
+	distSq := (x1-x2)*(x1-x2) + (y1-y2)*(y1-y2)
+	return max(1000, min(int(float64(distSq)/1000), 2000))
+}
+
+// longPressMs is the duration of a Long Press, that of the devices when it
+// is not given.
+func longPressMs(step Step) int {
+	if seconds, ok := helper.Seconds(step.Action["duration"]); ok && seconds > 0 {
+		return int(min(seconds, 10) * 1000)
+	}
+	return 3000
+}
+
+// path converts the points of a Drag to pixels of the recorded screen.
+func path(step Step) ([][2]int, bool) {
+	var items []any
+	switch v := step.Action["path"].(type) {
+	case []any:
+		items = v
+	case [][]int:
+		for _, point := range v {
+			items = append(items, point)
+		}
+	}
+	var points [][2]int
+	for _, item := range items {
+		element := utils.AnyToIntSlice(item)
+		if len(element) != 2 {
+			return nil, false
+		}
+		x := int(float64(element[0]) / float64(1000) * float64(step.ScreenWidth))
+		y := int(float64(element[1]) / float64(1000) * float64(step.ScreenHeight))
+		points = append(points, [2]int{x, y})
+	}
+	return points, len(points) >= 2
+}
+
+// appPackage is the package of the app of a Launch, Force_Stop or
+// Clear_Data, given or known by its name.
+func appPackage(step Step) (string, error) {
+	if pkg := utils.AnyToString(step.Action["package"]); pkg != "" {
+		return pkg, nil
+	}
+	pkg, ok := constants.APP_PACKAGES_ANDROID[utils.AnyToString(step.Action["app"])]
+	if !ok {
+		return "", fmt.Errorf("step %d: unknown app %v", step.Index+1, step.Action["app"])
+	}
+	return pkg, nil
+}
+
+func waitSeconds(step Step) float64 {
+	return helper.WaitSeconds(step.Action)
+}
+
+// waitUntilSeconds is the Wait_Until timeout in whole seconds, at least 1.
+func waitUntilSeconds(step Step) int {
+	return max(1, int(math.Ceil(helper.WaitUntilTimeout(step.Action).Seconds())))
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func exportADB(w *bufio.Writer, t *Trajectory) error {
+	fmt.Fprintf(w, "#!/bin/sh\n")
+	fmt.Fprintf(w, "# Replay of: %s\n", oneLine(t.Task))
+	fmt.Fprintf(w, "# Usage: ANDROID_SERIAL=<device id> sh script.sh\n")
+	fmt.Fprintf(w, "set -e\n\n")
+	fmt.Fprintf(w, "expect_app() {\n")
+	fmt.Fprintf(w, "  if ! adb shell dumpsys window | grep -E 'mCurrentFocus|mFocusedApp' | grep -q \"$1\"; then\n")
+	fmt.Fprintf(w, "    echo \"step $2: expected $1 in the foreground\" >&2\n")
+	fmt.Fprintf(w, "    exit 1\n")
+	fmt.Fprintf(w, "  fi\n")
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "wait_text() {\n")
+	fmt.Fprintf(w, "  i=0\n")
+	fmt.Fprintf(w, "  until adb exec-out uiautomator dump /dev/tty 2>/dev/null | grep -qF -- \"$1\"; do\n")
+	fmt.Fprintf(w, "    i=$((i + 1))\n")
+	fmt.Fprintf(w, "    if [ \"$i\" -ge \"$2\" ]; then\n")
+	fmt.Fprintf(w, "      echo \"step $3: $1 did not appear\" >&2\n")
+	fmt.Fprintf(w, "      exit 1\n")
+	fmt.Fprintf(w, "    fi\n")
+	fmt.Fprintf(w, "    sleep 1\n")
+	fmt.Fprintf(w, "  done\n")
+	fmt.Fprintf(w, "}\n")
+
+	for _, step := range t.Steps {
+		name := utils.AnyToString(step.Action["action"])
+		if step.Action["_metadata"] == "finish" {
+			name = "finish"
+		}
+		fmt.Fprintf(w, "\n# step %d: %s %s\n", step.Index+1, name, oneLine(utils.JsonString(step.Action)))
+		if pkg, ok := constants.APP_PACKAGES_ANDROID[step.App]; ok {
+			fmt.Fprintf(w, "expect_app %s %d\n", shellQuote(pkg), step.Index+1)
+		}
+
+		switch name {
+		case "Launch":
+			activity, intent, uri := utils.AnyToString(step.Action["activity"]), utils.AnyToString(step.Action["intent"]), utils.AnyToString(step.Action["uri"])
+			if activity == "" && (intent != "" || uri != "") {
+				start := "adb shell am start"
+				if intent != "" {
+					start += " -a " + shellQuote(intent)
+				}
+				if uri != "" {
+					start += " -d " + shellQuote(uri)
+				}
+				fmt.Fprintf(w, "%s >/dev/null\n", start)
+				break
+			}
+			pkg, err := appPackage(step)
+			if err != nil {
+				return err
+			}
+			if activity != "" {
+				fmt.Fprintf(w, "adb shell am start -n %s >/dev/null\n", shellQuote(pkg+"/"+activity))
+				break
+			}
+			fmt.Fprintf(w, "adb shell monkey -p %s -c android.intent.category.LAUNCHER 1 >/dev/null\n", pkg)
+		case "Force_Stop", "Clear_Data":
+			pkg, err := appPackage(step)
+			if err != nil {
+				return err
+			}
+			if name == "Force_Stop" {
+				fmt.Fprintf(w, "adb shell am force-stop %s\n", shellQuote(pkg))
+			} else {
+				fmt.Fprintf(w, "adb shell pm clear %s >/dev/null\n", shellQuote(pkg))
+			}
+		case "Tap", "Double Tap", "Long Press":
+			x, y, ok := point(step, "element")
+			if !ok {
+				return fmt.Errorf("step %d: invalid element", step.Index+1)
+			}
+			switch name {
+			case "Tap":
+				fmt.Fprintf(w, "adb shell input tap %d %d\n", x, y)
+			case "Double Tap":
+				fmt.Fprintf(w, "adb shell input tap %d %d\nsleep 0.1\nadb shell input tap %d %d\n", x, y, x, y)
+			default:
+				fmt.Fprintf(w, "adb shell input swipe %d %d %d %d %d\n", x, y, x, y, longPressMs(step))
+			}
+		case "Drag":
+			points, ok := path(step)
+			if !ok {
+				return fmt.Errorf("step %d: invalid drag path", step.Index+1)
+			}
+			fmt.Fprintf(w, "adb shell input motionevent DOWN %d %d\n", points[0][0], points[0][1])
+			if hold, ok := helper.Seconds(step.Action["hold"]); ok && hold > 0 {
+				fmt.Fprintf(w, "sleep %g\n", hold)
+			}
+			for _, p := range points[1:] {
+				fmt.Fprintf(w, "adb shell input motionevent MOVE %d %d\n", p[0], p[1])
+			}
+			last := points[len(points)-1]
+			fmt.Fprintf(w, "adb shell input motionevent UP %d %d\n", last[0], last[1])
+		case "Swipe":
+			x1, y1, ok1 := point(step, "start")
+			x2, y2, ok2 := point(step, "end")
+			if !ok1 || !ok2 {
+				return fmt.Errorf("step %d: invalid swipe coordinates", step.Index+1)
+			}
+			fmt.Fprintf(w, "adb shell input swipe %d %d %d %d %d\n", x1, y1, x2, y2, swipeDuration(x1, y1, x2, y2))
+		case "Type", "Type_Name":
+			text := base64.StdEncoding.EncodeToString([]byte(utils.AnyToString(step.Action["text"])))
+			fmt.Fprintf(w, "adb shell am broadcast -a ADB_CLEAR_TEXT >/dev/null\n")
+			fmt.Fprintf(w, "adb shell am broadcast -a ADB_INPUT_B64 --es msg %s >/dev/null\n", text)
+		case "Back":
+			fmt.Fprintf(w, "adb shell input keyevent 4\n")
+		case "Home":
+			fmt.Fprintf(w, "adb shell input keyevent KEYCODE_HOME\n")
+		case "Wait":
+			fmt.Fprintf(w, "sleep %g\n", waitSeconds(step))
+			continue
+		case "Wait_Until":
+			fmt.Fprintf(w, "wait_text %s %d %d\n", shellQuote(utils.AnyToString(step.Action["text_appears"])), waitUntilSeconds(step), step.Index+1)
+			continue
+		case "finish":
+			fmt.Fprintf(w, "echo %s\n", shellQuote("finished: "+oneLine(utils.AnyToString(step.Action["message"]))))
+			continue
+		default:
+			// Take_over, Note, Call_API, Interact have no device effect,
+			// Dismiss_Overlay depends on the windows shown at the time and
+			// Pinch needs the touchscreen of the device and Install the APK
+			// of the run
+			fmt.Fprintf(w, ": # no device action\n")
+			continue
+		}
+		fmt.Fprintf(w, "sleep 1\n")
+	}
+	return nil
+}
+
+func pyString(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+func exportAppiumPython(w *bufio.Writer, t *Trajectory) error {
+	fmt.Fprintf(w, "# Replay of: %s\n", oneLine(t.Task))
+	fmt.Fprintf(w, "# Requires Appium-Python-Client, usage: APPIUM_URL=http://127.0.0.1:4723 UDID=<device id> python script.py\n")
+	fmt.Fprintf(w, "import os\nimport time\n\n")
+	fmt.Fprintf(w, "from appium import webdriver\n")
+	fmt.Fprintf(w, "from appium.options.android import UiAutomator2Options\n\n\n")
+	fmt.Fprintf(w, "def expect_app(driver, package, step):\n")
+	fmt.Fprintf(w, "    assert driver.current_package == package, f\"step {step}: expected {package}, got {driver.current_package}\"\n\n\n")
+	fmt.Fprintf(w, "def wait_text(driver, text, timeout, step):\n")
+	fmt.Fprintf(w, "    deadline = time.time() + timeout\n")
+	fmt.Fprintf(w, "    while text not in driver.page_source:\n")
+	fmt.Fprintf(w, "        assert time.time() < deadline, f\"step {step}: {text} did not appear\"\n")
+	fmt.Fprintf(w, "        time.sleep(1)\n\n\n")
+	fmt.Fprintf(w, "def run(driver):\n")
+
+	for _, step := range t.Steps {
+		name := utils.AnyToString(step.Action["action"])
+		if step.Action["_metadata"] == "finish" {
+			name = "finish"
+		}
+		fmt.Fprintf(w, "    # step %d: %s\n", step.Index+1, name)
+		if pkg, ok := constants.APP_PACKAGES_ANDROID[step.App]; ok {
+			fmt.Fprintf(w, "    expect_app(driver, %s, %d)\n", pyString(pkg), step.Index+1)
+		}
+
+		switch name {
+		case "Launch":
+			pkg, err := appPackage(step)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "    driver.activate_app(%s)\n", pyString(pkg))
+		case "Force_Stop":
+			pkg, err := appPackage(step)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "    driver.terminate_app(%s)\n", pyString(pkg))
+		case "Clear_Data":
+			pkg, err := appPackage(step)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "    driver.execute_script(\"mobile: clearApp\", {\"appId\": %s})\n", pyString(pkg))
+		case "Tap", "Double Tap", "Long Press":
+			x, y, ok := point(step, "element")
+			if !ok {
+				return fmt.Errorf("step %d: invalid element", step.Index+1)
+			}
+			switch name {
+			case "Tap":
+				fmt.Fprintf(w, "    driver.tap([(%d, %d)])\n", x, y)
+			case "Double Tap":
+				fmt.Fprintf(w, "    driver.tap([(%d, %d)])\n    time.sleep(0.1)\n    driver.tap([(%d, %d)])\n", x, y, x, y)
+			default:
+				fmt.Fprintf(w, "    driver.tap([(%d, %d)], %d)\n", x, y, longPressMs(step))
+			}
+		case "Swipe":
+			x1, y1, ok1 := point(step, "start")
+			x2, y2, ok2 := point(step, "end")
+			if !ok1 || !ok2 {
+				return fmt.Errorf("step %d: invalid swipe coordinates", step.Index+1)
+			}
+			fmt.Fprintf(w, "    driver.swipe(%d, %d, %d, %d, %d)\n", x1, y1, x2, y2, swipeDuration(x1, y1, x2, y2))
+		case "Type", "Type_Name":
+			fmt.Fprintf(w, "    element = driver.switch_to.active_element\n")
+			fmt.Fprintf(w, "    element.clear()\n")
+			fmt.Fprintf(w, "    element.send_keys(%s)\n", pyString(utils.AnyToString(step.Action["text"])))
+		case "Back":
+			fmt.Fprintf(w, "    driver.back()\n")
+		case "Home":
+			fmt.Fprintf(w, "    driver.press_keycode(3)\n")
+		case "Wait":
+			fmt.Fprintf(w, "    time.sleep(%g)\n", waitSeconds(step))
+			continue
+		case "Wait_Until":
+			fmt.Fprintf(w, "    wait_text(driver, %s, %d, %d)\n", pyString(utils.AnyToString(step.Action["text_appears"])), waitUntilSeconds(step), step.Index+1)
+			continue
+		case "finish":
+			fmt.Fprintf(w, "    print(%s)\n", pyString("finished: "+utils.AnyToString(step.Action["message"])))
+			continue
+		default:
+			fmt.Fprintf(w, "    pass  # no device action\n")
+			continue
+		}
+		fmt.Fprintf(w, "    time.sleep(1)\n")
+	}
+	if len(t.Steps) == 0 {
+		fmt.Fprintf(w, "    pass\n")
+	}
+
+	fmt.Fprintf(w, "\n\nif __name__ == \"__main__\":\n")
+	fmt.Fprintf(w, "    options = UiAutomator2Options()\n")
+	fmt.Fprintf(w, "    if os.environ.get(\"UDID\"):\n")
+	fmt.Fprintf(w, "        options.udid = os.environ[\"UDID\"]\n")
+	fmt.Fprintf(w, "    driver = webdriver.Remote(os.environ.get(\"APPIUM_URL\", \"http://127.0.0.1:4723\"), options=options)\n")
+	fmt.Fprintf(w, "    try:\n")
+	fmt.Fprintf(w, "        run(driver)\n")
+	fmt.Fprintf(w, "    finally:\n")
+	fmt.Fprintf(w, "        driver.quit()\n")
+	return nil
+}
+
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,92 @@
+package trajectory
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/seal"
+)
+
+// Step is one executed action of a task.
+type Step struct {
+	Index        int           `json:"index"`
+	App          string        `json:"app"` // foreground app before the action
+	Action       helper.Action `json:"action"`
+	ScreenWidth  int           `json:"screen_width"`
+	ScreenHeight int           `json:"screen_height"`
+	Success      bool          `json:"success"`
+	Message      string        `json:"message,omitempty"`
+	Thinking     string        `json:"thinking,omitempty"` // full model reasoning behind the action
+	Time         time.Time     `json:"time"`
+}
+
+// Trajectory is the ordered list of actions an agent took for a task.
+type Trajectory struct {
+	Task     string            `json:"task"`
+	DeviceID string            `json:"device_id,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"` // task labels, see package labels
+	Steps    []Step            `json:"steps"`
+	Finished bool              `json:"finished"` // the model called finish()
+	Message  string            `json:"message,omitempty"`
+	Verdict  *Verdict          `json:"verdict,omitempty"` // independent review of the result
+	Review   []string          `json:"review,omitempty"`  // why the task deserves a look, such as anomalous steps
+}
+
+// Verdict is a judge model's call on whether the task was really done,
+// kept next to the agent's own finish message rather than replacing it.
+type Verdict struct {
+	Pass   bool   `json:"pass"`
+	Reason string `json:"reason"`
+	Model  string `json:"model"`
+}
+
+func New(task, deviceID string) *Trajectory {
+	return &Trajectory{
+		Task:     task,
+		DeviceID: deviceID,
+	}
+}
+
+func (t *Trajectory) Add(step Step) {
+	step.Index = len(t.Steps)
+	if step.Time.IsZero() {
+		step.Time = time.Now()
+	}
+	t.Steps = append(t.Steps, step)
+}
+
+// Succeeded reports whether the task ended with finish() and every step
+// executed successfully, i.e. whether it is worth replaying as a test.
+func (t *Trajectory) Succeeded() bool {
+	if !t.Finished {
+		return false
+	}
+	for _, step := range t.Steps {
+		if !step.Success {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *Trajectory) Save(path string) error {
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	return seal.WriteFile(path, data, 0o644)
+}
+
+func Load(path string) (*Trajectory, error) {
+	data, err := seal.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var t Trajectory
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("invalid trajectory %s: %w", path, err)
+	}
+	return &t, nil
+}
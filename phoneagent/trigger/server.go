@@ -0,0 +1,249 @@
+package trigger
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	logs "github.com/sirupsen/logrus"
+)
+
+var ErrBusy = errors.New("a task is already running")
+
+// Device is what is needed of the device driver to expose the server to the
+// phone and open the trigger page on it.
+type Device interface {
+	Shell(ctx context.Context, deviceID string, args ...string) (string, error)
+	Reverse(ctx context.Context, deviceID string, port int) error
+	RemoveReverse(ctx context.Context, deviceID string, port int) error
+}
+
+// Runner runs one task on the device and returns its finish message.
+type Runner func(ctx context.Context, task string) (string, error)
+
+// Status is the last triggered task as reported by /status.
+type Status struct {
+	Name       string    `json:"name,omitempty"`
+	Running    bool      `json:"running"`
+	Message    string    `json:"message,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"started_at,omitempty"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+}
+
+// Server lets the phone start predefined tasks on itself. The device reaches
+// it through adb reverse; the page at / is meant to be added to the home
+// screen, and /run/<name> can be called by widget or quick-settings tile apps.
+// Every request must carry the token, other apps on the phone can reach the
+// port too.
+type Server struct {
+	Tasks map[string]string // trigger name -> instruction
+	Run   Runner
+	Token string
+
+	mu     sync.Mutex
+	status Status
+	ctx    context.Context
+}
+
+func NewServer(tasks map[string]string, run Runner, token string) *Server {
+	return &Server{
+		Tasks: tasks,
+		Run:   run,
+		Token: token,
+	}
+}
+
+// NewToken returns a random token for Server.Token.
+func NewToken() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// LoadTasks reads a JSON object of trigger names to instructions.
+func LoadTasks(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tasks map[string]string
+	if err := json.Unmarshal(data, &tasks); err != nil {
+		return nil, fmt.Errorf("invalid triggers file %s: %w", path, err)
+	}
+	if len(tasks) == 0 {
+		return nil, fmt.Errorf("no triggers in %s", path)
+	}
+	for name, task := range tasks {
+		if name == "" || task == "" {
+			return nil, fmt.Errorf("trigger %q has no name or task", name)
+		}
+	}
+	return tasks, nil
+}
+
+// URL is the address of path as seen from the device.
+func (r *Server) URL(port int, path string) string {
+	return fmt.Sprintf("http://127.0.0.1:%d%s?token=%s", port, path, url.QueryEscape(r.Token))
+}
+
+// Install makes port reachable from the device and opens the trigger page
+// there, so it can be added to the home screen. The returned function removes
+// the reverse again.
+func (r *Server) Install(ctx context.Context, device Device, deviceID string, port int) (func(), error) {
+	if err := device.Reverse(ctx, deviceID, port); err != nil {
+		return nil, err
+	}
+	cleanup := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := device.RemoveReverse(ctx, deviceID, port); err != nil {
+			logs.Warnf("failed to remove trigger reverse, err: %v", err)
+		}
+	}
+
+	page := "'" + r.URL(port, "/") + "'"
+	if _, err := device.Shell(ctx, deviceID, "am", "start", "-a", "android.intent.action.VIEW", "-d", page); err != nil {
+		logs.Warnf("failed to open the trigger page on the device, err: %v", err)
+	}
+	return cleanup, nil
+}
+
+// Serve handles requests until ctx is done. Triggered tasks run with ctx.
+func (r *Server) Serve(ctx context.Context, listener net.Listener) error {
+	r.ctx = ctx
+	server := &http.Server{Handler: r.handler()}
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Start runs the named task in the background.
+func (r *Server) Start(name string) error {
+	task, ok := r.Tasks[name]
+	if !ok {
+		return fmt.Errorf("unknown trigger %q", name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.status.Running {
+		return ErrBusy
+	}
+	r.status = Status{Name: name, Running: true, StartedAt: time.Now()}
+
+	logs.Infof("📲 trigger %s: %s", name, task)
+	go func() {
+		message, err := r.Run(r.ctx, task)
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.status.Running = false
+		r.status.Message = message
+		if err != nil {
+			r.status.Error = err.Error()
+		}
+		r.status.FinishedAt = time.Now()
+	}()
+	return nil
+}
+
+func (r *Server) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status
+}
+
+func (r *Server) names() []string {
+	names := make([]string, 0, len(r.Tasks))
+	for name := range r.Tasks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *Server) authorized(req *http.Request) bool {
+	token := req.FormValue("token")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(r.Token)) == 1
+}
+
+func (r *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = pageTemplate.Execute(w, map[string]any{
+			"Names": r.names(),
+			"Tasks": r.Tasks,
+			"Token": r.Token,
+		})
+	})
+	// widget and tile apps often can only open a URL, so GET starts too
+	mux.HandleFunc("/run/{name}", func(w http.ResponseWriter, req *http.Request) {
+		err := r.Start(req.PathValue("name"))
+		switch {
+		case errors.Is(err, ErrBusy):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = fmt.Fprintf(w, "started %s\n", req.PathValue("name"))
+		}
+	})
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(r.Status())
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !r.authorized(req) {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, req)
+	})
+}
+
+var pageTemplate = template.Must(template.New("page").Parse(`<!doctype html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width">
+<title>AutoGLM</title>
+<style>body{font-family:sans-serif;margin:16px}button{display:block;width:100%;margin:8px 0;padding:14px;font-size:16px;text-align:left}small{color:#666}</style>
+</head><body>
+<h3>AutoGLM</h3>
+{{range .Names}}<button onclick="run('{{.}}')">{{.}}<br><small>{{index $.Tasks .}}</small></button>
+{{end}}<p id="status"></p>
+<script>
+const token = '{{.Token}}';
+const statusLine = document.getElementById('status');
+async function run(name) {
+  const resp = await fetch('/run/' + encodeURIComponent(name) + '?token=' + encodeURIComponent(token), {method: 'POST'});
+  statusLine.textContent = await resp.text();
+}
+async function poll() {
+  const resp = await fetch('/status?token=' + encodeURIComponent(token));
+  const s = await resp.json();
+  if (!s.name) return;
+  statusLine.textContent = s.running ? s.name + ': running…' : s.name + ': ' + (s.error || s.message);
+}
+setInterval(poll, 2000);
+poll();
+</script>
+</body></html>`))
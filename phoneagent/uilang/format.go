@@ -0,0 +1,171 @@
+package uilang
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/number"
+)
+
+// datePatterns are the short numeric dates of the regions that do not write
+// day/month/year, as layouts of time.Format.
+var datePatterns = map[string]string{
+	"US": "01/02/2006", "PH": "01/02/2006",
+	"CN": "2006/01/02", "JP": "2006/01/02", "TW": "2006/01/02",
+	"KR": "2006. 01. 02.", "HU": "2006. 01. 02.",
+	"CA": "2006-01-02", "SE": "2006-01-02", "LT": "2006-01-02",
+	"NL": "02-01-2006",
+	"DE": "02.01.2006", "AT": "02.01.2006", "CH": "02.01.2006",
+	"RU": "02.01.2006", "UA": "02.01.2006", "BY": "02.01.2006", "KZ": "02.01.2006",
+	"PL": "02.01.2006", "CZ": "02.01.2006", "SK": "02.01.2006", "RO": "02.01.2006",
+	"TR": "02.01.2006", "FI": "02.01.2006", "NO": "02.01.2006", "DK": "02.01.2006",
+	"IL": "02.01.2006",
+}
+
+// callingCode is the country calling code of a region, and the trunk prefix
+// its national numbers are dialed with.
+type callingCode struct {
+	code, trunk string
+}
+
+var callingCodes = map[string]callingCode{
+	"US": {"1", ""}, "CA": {"1", ""}, "CN": {"86", ""}, "HK": {"852", ""},
+	"TW": {"886", "0"}, "JP": {"81", "0"}, "KR": {"82", "0"}, "SG": {"65", ""},
+	"IN": {"91", "0"}, "ID": {"62", "0"}, "TH": {"66", "0"}, "VN": {"84", "0"},
+	"MY": {"60", "0"}, "PH": {"63", "0"}, "AU": {"61", "0"}, "GB": {"44", "0"},
+	"DE": {"49", "0"}, "AT": {"43", "0"}, "CH": {"41", "0"}, "FR": {"33", "0"},
+	"NL": {"31", "0"}, "SE": {"46", "0"}, "ES": {"34", ""}, "IT": {"39", ""},
+	"PL": {"48", ""}, "TR": {"90", "0"}, "RU": {"7", "8"}, "BR": {"55", ""},
+	"MX": {"52", ""},
+}
+
+// region is the region of the language, the most likely one when the tag
+// has none, as DE for de.
+func (r *Language) region() string {
+	region, _ := r.Tag.Region()
+	return region.String()
+}
+
+// FormatDate writes t as the short numeric date of the region, 10/15/2026
+// in the US, 15.10.2026 in Germany or 2026/10/15 in Japan.
+func (r *Language) FormatDate(t time.Time) string {
+	if pattern, ok := datePatterns[r.region()]; ok {
+		return t.Format(pattern)
+	}
+	return t.Format("02/01/2006")
+}
+
+// decimalSeparator is the decimal separator of the language, a period or a
+// comma; the native digits of some languages are not used as input is
+// expected in ASCII digits.
+func (r *Language) decimalSeparator() string {
+	if r.Tag == language.Und {
+		return "."
+	}
+	s := message.NewPrinter(r.Tag).Sprint(number.Decimal(1.5, number.Scale(1)))
+	if strings.Contains(s, ",") {
+		return ","
+	}
+	return "."
+}
+
+// FormatNumber writes the decimal number s, such as 1234.5, with the
+// decimal separator of the language and without grouping, as 1234,5 in
+// German. Its digits are kept as written.
+func (r *Language) FormatNumber(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if _, err := strconv.ParseFloat(s, 64); err != nil || strings.ContainsAny(s, "eExX_") {
+		return "", fmt.Errorf("invalid number %q, want digits with an optional . and fraction", s)
+	}
+	return strings.Replace(s, ".", r.decimalSeparator(), 1), nil
+}
+
+// FormatPhone writes the phone number s the way a form of the region takes
+// it: a number of the region in national form, with its trunk prefix, as
+// 030123456 for +49 30 123456 in Germany; others in international form,
+// as +4930123456. Spaces, dashes, dots, parentheses and the (0) of
+// +44 (0)20 are dropped.
+func (r *Language) FormatPhone(s string) (string, error) {
+	digits := strings.Map(func(c rune) rune {
+		if strings.ContainsRune(" -.()", c) {
+			return -1
+		}
+		return c
+	}, strings.ReplaceAll(strings.TrimSpace(s), "(0)", ""))
+	international := strings.HasPrefix(digits, "+")
+	digits = strings.TrimPrefix(digits, "+")
+	if digits == "" || strings.Trim(digits, "0123456789") != "" {
+		return "", fmt.Errorf("invalid phone number %q", s)
+	}
+	if !international {
+		return digits, nil
+	}
+	if cc, ok := callingCodes[r.region()]; ok && strings.HasPrefix(digits, cc.code) {
+		return cc.trunk + digits[len(cc.code):], nil
+	}
+	return "+" + digits, nil
+}
+
+// placeholder matches {{date:+1}}, {{number:3.5}} and {{phone:+4930123456}}.
+var placeholder = regexp.MustCompile(`\{\{\s*(date|number|phone)\s*(?::([^}]*))?\}\}`)
+
+// ExpandInput replaces the placeholders of text typed on the device with
+// their value in the format of the language:
+//
+//	{{date}}, {{date:+1}}, {{date:-2}}  today, tomorrow, two days ago at now
+//	{{date:2026-10-15}}                 that date
+//	{{number:1234.5}}                   see FormatNumber
+//	{{phone:+49 30 123456}}             see FormatPhone
+func (r *Language) ExpandInput(text string, now time.Time) (string, error) {
+	var err error
+	expanded := placeholder.ReplaceAllStringFunc(text, func(m string) string {
+		parts := placeholder.FindStringSubmatch(m)
+		kind, arg := parts[1], strings.TrimSpace(parts[2])
+		var value string
+		var e error
+		switch kind {
+		case "date":
+			var t time.Time
+			if t, e = parseDate(arg, now); e == nil {
+				value = r.FormatDate(t)
+			}
+		case "number":
+			value, e = r.FormatNumber(arg)
+		case "phone":
+			value, e = r.FormatPhone(arg)
+		}
+		if e != nil {
+			if err == nil {
+				err = fmt.Errorf("%s: %w", m, e)
+			}
+			return m
+		}
+		return value
+	})
+	return expanded, err
+}
+
+// parseDate reads the argument of a date placeholder: empty for now, a
+// number of days from now as +1, or a date as 2026-10-15.
+func parseDate(arg string, now time.Time) (time.Time, error) {
+	switch {
+	case arg == "" || arg == "today":
+		return now, nil
+	case strings.HasPrefix(arg, "+") || strings.HasPrefix(arg, "-"):
+		days, err := strconv.Atoi(arg)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid day offset %q, want as +1 or -2", arg)
+		}
+		return now.AddDate(0, 0, days), nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", arg, now.Location())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, want as 2026-10-15 or +1", arg)
+	}
+	return t, nil
+}
@@ -0,0 +1,105 @@
+// Package uilang matches on-screen text by the rules of the device UI
+// language: case folding follows the language (Turkish dotted I, Greek final
+// sigma) and full-width forms fold to their narrow ones. It also writes the
+// dates, numbers and phone numbers typed on the device the way its apps
+// expect them.
+package uilang
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
+	"golang.org/x/text/width"
+)
+
+// Language is a device UI language. The zero value is not usable, see New.
+type Language struct {
+	Tag   language.Tag
+	lower cases.Caser
+}
+
+// Parse reads a BCP 47 tag such as "ja", "de-AT" or "pt-BR".
+func Parse(tag string) (*Language, error) {
+	t, err := language.Parse(tag)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ui language %q: %w", tag, err)
+	}
+	return &Language{Tag: t, lower: cases.Lower(t)}, nil
+}
+
+// New is Parse with language-neutral rules for an empty or invalid tag.
+func New(tag string) *Language {
+	if l, err := Parse(tag); err == nil {
+		return l
+	}
+	return &Language{Tag: language.Und, lower: cases.Lower(language.Und)}
+}
+
+// Base is the ISO 639 code of the language, e.g. "ja" for "ja-JP". It is
+// empty when the language is undetermined.
+func (r *Language) Base() string {
+	if r.Tag == language.Und {
+		return ""
+	}
+	base, _ := r.Tag.Base()
+	return base.String()
+}
+
+// Name is the name of the language in itself, e.g. 日本語 or Deutsch.
+func (r *Language) Name() string {
+	if name := display.Self.Name(r.Tag); name != "" {
+		return name
+	}
+	return r.Tag.String()
+}
+
+// Fold normalizes s for comparison.
+func (r *Language) Fold(s string) string {
+	return r.lower.String(width.Fold.String(strings.TrimSpace(s)))
+}
+
+// Contains reports whether substr is in s after folding both.
+func (r *Language) Contains(s, substr string) bool {
+	return strings.Contains(r.Fold(s), r.Fold(substr))
+}
+
+// scripts are the letters of the ISO 15924 scripts MatchesScript knows.
+var scripts = map[string][]*unicode.RangeTable{
+	"Latn": {unicode.Latin},
+	"Hans": {unicode.Han},
+	"Hant": {unicode.Han},
+	"Jpan": {unicode.Han, unicode.Hiragana, unicode.Katakana},
+	"Kore": {unicode.Hangul, unicode.Han},
+	"Cyrl": {unicode.Cyrillic},
+	"Grek": {unicode.Greek},
+	"Arab": {unicode.Arabic},
+	"Hebr": {unicode.Hebrew},
+	"Thai": {unicode.Thai},
+	"Deva": {unicode.Devanagari},
+}
+
+// MatchesScript reports whether most letters of s are in the script the
+// language is written in. It tells Chinese from English, not English from
+// German. Texts without letters and languages of other scripts match.
+func (r *Language) MatchesScript(s string) bool {
+	script, _ := r.Tag.Script()
+	tables, ok := scripts[script.String()]
+	if !ok {
+		return true
+	}
+	var letters, matched int
+	for _, c := range s {
+		if !unicode.IsLetter(c) {
+			continue
+		}
+		letters++
+		if unicode.IsOneOf(tables, c) {
+			matched++
+		}
+	}
+	return matched*2 >= letters
+}
@@ -0,0 +1,105 @@
+package phoneagent
+
+import (
+	"fmt"
+
+	"autoglm-go/phoneagent/definitions"
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/imaging"
+	"autoglm-go/phoneagent/llm"
+	"autoglm-go/utils"
+)
+
+// Modes of AgentConfig.UnchangedScreen.
+const (
+	UnchangedOff      = ""
+	UnchangedAnnotate = "annotate" // tell the model the screen did not change
+	UnchangedReuse    = "reuse"    // also repeat a wait without asking the model
+)
+
+// unchangedNote is added to the prompt of a screen that did not change.
+const unchangedNote = "** Screen Unchanged **\n\nThe screen looks the same as before your previous action %s."
+
+// maxUnchangedReuses is how many waits in a row are repeated before the model
+// is asked again.
+const maxUnchangedReuses = 3
+
+// lastScreen is the screen of a step and the action taken on it.
+type lastScreen struct {
+	step   int
+	hash   uint64
+	action string
+	wait   bool
+	reused int // waits in a row repeated without the model
+}
+
+// stepScreen is the screen of the current step.
+type stepScreen struct {
+	hash      uint64
+	unchanged bool // looks like the screen of the previous step
+}
+
+// compareScreen hashes the screenshot of the step and compares it with the
+// screen of the previous step, as AgentConfig.UnchangedDistance tells. It
+// returns nil when the comparison is off or the screenshot is unusable.
+func (r *PhoneAgent) compareScreen(screenshot *definitions.Screenshot) *stepScreen {
+	if r.AgentConfig.UnchangedScreen == UnchangedOff || len(screenshot.Data) == 0 {
+		return nil
+	}
+	hash, err := imaging.DHashData(screenshot.Data)
+	if err != nil {
+		return nil
+	}
+	distance := r.AgentConfig.UnchangedDistance
+	if distance <= 0 {
+		distance = unchangedDistance
+	}
+	last := r.lastScreen
+	return &stepScreen{
+		hash:      hash,
+		unchanged: last != nil && last.step == r.StepCount-1 && imaging.HashDistance(hash, last.hash) <= distance,
+	}
+}
+
+// unchangedContext is the note of an unchanged screen for the prompt.
+func (r *PhoneAgent) unchangedContext(screen *stepScreen) string {
+	if screen == nil || !screen.unchanged {
+		return ""
+	}
+	return fmt.Sprintf(unchangedNote, r.lastScreen.action)
+}
+
+// reuseWait returns the previous answer when it was a wait that left the
+// screen unchanged, so the next wait needs no model call. After
+// maxUnchangedReuses waits in a row the model decides again.
+func (r *PhoneAgent) reuseWait(screen *stepScreen) *llm.ModelResponse {
+	if screen == nil || !screen.unchanged || r.AgentConfig.UnchangedScreen != UnchangedReuse {
+		return nil
+	}
+	last := r.lastScreen
+	if !last.wait || !r.lastStepOK || last.reused >= maxUnchangedReuses {
+		return nil
+	}
+	last.reused++
+	r.log().Infof("⏸️ screen unchanged after %s, repeating it without asking the model", last.action)
+	return &llm.ModelResponse{Thinking: "The screen has not changed yet, keep waiting.", Action: last.action, Model: "unchanged"}
+}
+
+// rememberScreen keeps the hash of the screen of the step and its action, for
+// the next step to compare with.
+func (r *PhoneAgent) rememberScreen(screen *stepScreen, action helper.Action, reused bool) {
+	if screen == nil {
+		r.lastScreen = nil
+		return
+	}
+	next := &lastScreen{
+		step:   r.StepCount,
+		hash:   screen.hash,
+		action: helper.FormatAction(action),
+		wait:   utils.AnyToString(action["action"]) == "Wait",
+	}
+	if reused && r.lastScreen != nil {
+		next.reused = r.lastScreen.reused
+	}
+	r.lastScreen = next
+}
@@ -0,0 +1,57 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	logs "github.com/sirupsen/logrus"
+)
+
+// Vault holds the secrets the agent may use, such as screen unlock codes,
+// by name. They are never sent to the model.
+type Vault struct {
+	secrets map[string]string
+}
+
+// Load reads a JSON object of names to secrets. A secret written as
+// env:NAME is read from the environment variable NAME, so the file itself
+// can stay free of secrets.
+func Load(path string) (*Vault, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		logs.Warnf("🔐 vault %s is readable by other users, consider chmod 600", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	secrets := map[string]string{}
+	if err := json.Unmarshal(data, &secrets); err != nil {
+		return nil, fmt.Errorf("invalid vault %s: %w", path, err)
+	}
+	return &Vault{secrets: secrets}, nil
+}
+
+// Get returns the secret of the first of names found.
+func (v *Vault) Get(names ...string) (string, bool) {
+	for _, name := range names {
+		secret, ok := v.secrets[name]
+		if !ok {
+			continue
+		}
+		if env, ok := strings.CutPrefix(secret, "env:"); ok {
+			secret, ok = os.LookupEnv(env)
+			if !ok {
+				logs.Warnf("🔐 vault secret %s refers to %s, which is not set", name, env)
+				continue
+			}
+		}
+		return secret, true
+	}
+	return "", false
+}
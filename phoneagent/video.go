@@ -0,0 +1,178 @@
+package phoneagent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"autoglm-go/phoneagent/artifact"
+	"autoglm-go/phoneagent/definitions"
+	"autoglm-go/phoneagent/helper"
+	"autoglm-go/phoneagent/recorder"
+)
+
+// videoTimeout bounds saving the video of a task, pulling and joining the
+// chunks or stitching the screenshots.
+const videoTimeout = 5 * time.Minute
+
+// ScreenRecorder is implemented by devices that record their screen, for
+// AgentConfig.RecordVideo. Without it the video is stitched from the step
+// screenshots.
+type ScreenRecorder interface {
+	RecordScreen(ctx context.Context, deviceID string) (definitions.ScreenRecording, error)
+}
+
+// sessionVideo is the video of the running task.
+type sessionVideo struct {
+	name      string // relative to the record dir
+	ref       string // of the records, the key under videos/ with an artifact storage
+	started   time.Time
+	recording definitions.ScreenRecording // nil when stitched
+	markers   []recorder.VideoMarker
+	frames    []recorder.VideoFrame
+}
+
+// startVideo starts the video of the task in AgentConfig.RecordDir, the
+// returned func saves it.
+func (r *PhoneAgent) startVideo(ctx context.Context) func() {
+	if !r.AgentConfig.RecordVideo || r.AgentConfig.RecordDir == "" {
+		return func() {}
+	}
+	r.startSession()
+	// a resumed session gets a video of its own
+	storage := artifact.Current()
+	name := r.SessionID + ".mp4"
+	for i := 2; ; i++ {
+		if !videoExists(ctx, storage, filepath.Join(r.AgentConfig.RecordDir, name)) {
+			break
+		}
+		name = fmt.Sprintf("%s-%d.mp4", r.SessionID, i)
+	}
+	video := &sessionVideo{name: name, ref: name, started: time.Now()}
+	if storage != nil {
+		video.ref = path.Join("videos", name)
+	}
+	if device, ok := r.Device.(ScreenRecorder); ok {
+		recording, err := device.RecordScreen(ctx, r.AgentConfig.DeviceID)
+		if err != nil {
+			r.logFor(ctx).Warnf("🎥 failed to record the screen, stitching the screenshots instead, err: %v", err)
+		} else {
+			video.recording = recording
+		}
+	}
+	r.video = video
+	return func() {
+		r.video = nil
+		r.saveVideo(ctx, video)
+	}
+}
+
+// videoExists tells whether the video of file was saved, on disk or in
+// storage unless nil.
+func videoExists(ctx context.Context, storage artifact.Storage, file string) bool {
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		return true
+	}
+	if storage == nil {
+		return false
+	}
+	objects, err := storage.List(ctx, path.Join("videos", filepath.Base(file)))
+	return err == nil && len(objects) > 0
+}
+
+// markVideo adds the step of pending to the video, with the action it took.
+func (r *PhoneAgent) markVideo(pending *pendingRecord) {
+	video := r.video
+	if video == nil {
+		return
+	}
+	start := pending.started.Sub(video.started)
+	text := pending.Error
+	if pending.Action != nil {
+		text = helper.FormatAction(pending.Action)
+	}
+	video.markers = append(video.markers, recorder.VideoMarker{
+		Step:  pending.Step,
+		Start: start,
+		End:   time.Since(video.started),
+		Text:  text,
+	})
+	if s := pending.Screenshot; video.recording == nil && s != nil && (s.Path != "" || s.Key != "") {
+		frame := recorder.VideoFrame{Key: s.Key, At: start, Width: s.Width, Height: s.Height}
+		if s.Path != "" {
+			frame.Path = filepath.Join(r.AgentConfig.RecordDir, filepath.FromSlash(s.Path))
+		}
+		video.frames = append(video.frames, frame)
+	}
+}
+
+// saveVideo saves the video and its step markers next to the records of the
+// session. Failing to is logged, the task is done.
+func (r *PhoneAgent) saveVideo(ctx context.Context, video *sessionVideo) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), videoTimeout)
+	defer cancel()
+	log := r.logFor(ctx)
+	out := filepath.Join(r.AgentConfig.RecordDir, video.name)
+	end := time.Since(video.started)
+
+	saved := []string{out}
+	if video.recording != nil {
+		dir, err := os.MkdirTemp("", "autoglm-screen-")
+		if err != nil {
+			log.Warnf("🎥 failed to save the session video, err: %v", err)
+			return
+		}
+		defer os.RemoveAll(dir)
+		chunks, err := video.recording.Stop(ctx, dir)
+		if err == nil {
+			saved, err = recorder.JoinVideos(ctx, chunks, out)
+		}
+		if err != nil {
+			log.Warnf("🎥 failed to save the session video, err: %v", err)
+			return
+		}
+	} else if err := recorder.StitchVideo(ctx, video.frames, end, out); err != nil {
+		log.Warnf("🎥 failed to save the session video, err: %v", err)
+		return
+	}
+	markers := filepath.Join(r.AgentConfig.RecordDir, video.name[:len(video.name)-len(".mp4")]+".vtt")
+	if err := recorder.WriteMarkers(markers, video.markers); err != nil {
+		log.Warnf("🎥 failed to save the step markers of the video, err: %v", err)
+	}
+	if storage := artifact.Current(); storage != nil {
+		stored, err := storeVideo(ctx, storage, append(saved, markers))
+		if err != nil {
+			log.Warnf("🎥 failed to move the session video to %s, it stays in %v, err: %v", storage, saved, err)
+			return
+		}
+		log.Infof("🎥 session video saved to %s as %v", storage, stored)
+		return
+	}
+	log.Infof("🎥 session video saved to %v, steps in %s", saved, markers)
+}
+
+// storeVideo moves the files of a session video to the videos/ of storage
+// and returns their keys.
+func storeVideo(ctx context.Context, storage artifact.Storage, files []string) ([]string, error) {
+	keys := make([]string, 0, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return keys, err
+		}
+		key := path.Join("videos", filepath.Base(file))
+		contentType := "video/mp4"
+		if filepath.Ext(file) == ".vtt" {
+			contentType = "text/vtt"
+		}
+		if err := storage.Put(ctx, key, data, contentType); err != nil {
+			return keys, err
+		}
+		_ = os.Remove(file)
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
@@ -0,0 +1,51 @@
+package voice
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"autoglm-go/phoneagent/definitions"
+	"github.com/sashabaranov/go-openai"
+	logs "github.com/sirupsen/logrus"
+)
+
+type Transcriber struct {
+	config *definitions.VoiceConfig
+	client *openai.Client
+}
+
+func NewTranscriber(cfg *definitions.VoiceConfig) *Transcriber {
+	return &Transcriber{
+		config: cfg,
+		client: newClient(cfg),
+	}
+}
+
+func newClient(cfg *definitions.VoiceConfig) *openai.Client {
+	openaiCfg := openai.DefaultConfig(cfg.APIKey)
+	if cfg.BaseURL != "" {
+		openaiCfg.BaseURL = cfg.BaseURL
+	}
+	return openai.NewClientWithConfig(openaiCfg)
+}
+
+// Transcribe converts the audio file at path (wav, mp3, m4a, ...) to text.
+func (r *Transcriber) Transcribe(ctx context.Context, path string) (string, error) {
+	resp, err := r.client.CreateTranscription(ctx, openai.AudioRequest{
+		Model:    r.config.ASRModel,
+		FilePath: path,
+		Language: r.config.ASRLanguage,
+		Format:   openai.AudioResponseFormatJSON,
+	})
+	if err != nil {
+		return "", fmt.Errorf("transcription failed: %w", err)
+	}
+
+	text := strings.TrimSpace(resp.Text)
+	logs.Debugf("transcribed %s: %s", path, text)
+	if text == "" {
+		return "", fmt.Errorf("no speech recognized in %s", path)
+	}
+	return text, nil
+}
@@ -0,0 +1,74 @@
+package voice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+
+	logs "github.com/sirupsen/logrus"
+)
+
+var ErrNoRecorder = errors.New("no audio recorder found, install arecord, sox or ffmpeg")
+
+// recorderArgs returns the command recording seconds of 16 kHz mono wav from
+// the default microphone to path, trying arecord, sox and ffmpeg in order.
+func recorderArgs(path string, seconds int) ([]string, error) {
+	duration := strconv.Itoa(seconds)
+	if _, err := exec.LookPath("arecord"); err == nil {
+		return []string{"arecord", "-q", "-f", "S16_LE", "-r", "16000", "-c", "1", "-d", duration, path}, nil
+	}
+	if _, err := exec.LookPath("rec"); err == nil {
+		return []string{"rec", "-q", "-r", "16000", "-c", "1", path, "trim", "0", duration}, nil
+	}
+	if _, err := exec.LookPath("ffmpeg"); err == nil {
+		input := []string{"-f", "alsa", "-i", "default"}
+		switch runtime.GOOS {
+		case "darwin":
+			input = []string{"-f", "avfoundation", "-i", ":0"}
+		case "windows":
+			input = []string{"-f", "dshow", "-i", "audio=default"}
+		}
+		args := append([]string{"ffmpeg", "-loglevel", "error", "-y"}, input...)
+		return append(args, "-t", duration, "-ar", "16000", "-ac", "1", path), nil
+	}
+	return nil, ErrNoRecorder
+}
+
+// Record records seconds of audio from the microphone into a temporary wav
+// file. The caller removes the file.
+func Record(ctx context.Context, seconds int) (string, error) {
+	dir, err := os.MkdirTemp("", "autoglm-voice-")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "task.wav")
+
+	cmdArgs, err := recorderArgs(path, seconds)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return "", err
+	}
+	logs.Debugf("[Record] run cmd: %v", cmdArgs)
+
+	output, err := exec.CommandContext(ctx, cmdArgs[0], cmdArgs[1:]...).CombinedOutput()
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return "", fmt.Errorf("recording failed: %w, output: %s", err, output)
+	}
+	return path, nil
+}
+
+// RecordTask records from the microphone and transcribes the recording.
+func RecordTask(ctx context.Context, transcriber *Transcriber, seconds int) (string, error) {
+	path, err := Record(ctx, seconds)
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(filepath.Dir(path))
+	return transcriber.Transcribe(ctx, path)
+}
@@ -0,0 +1,181 @@
+package voice
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+
+	"autoglm-go/phoneagent/definitions"
+	"github.com/sashabaranov/go-openai"
+	logs "github.com/sirupsen/logrus"
+)
+
+const (
+	TTSSystem = "system" // say, espeak-ng, espeak, spd-say or Windows SAPI
+	TTSOpenAI = "openai" // /audio/speech of the voice endpoint
+)
+
+// Speaker reads text aloud and returns when it has been spoken.
+type Speaker interface {
+	Speak(ctx context.Context, text string) error
+}
+
+// speakMu keeps several agents of one process from talking over each other.
+var speakMu sync.Mutex
+
+// NewSpeaker returns the TTS backend selected by cfg.TTSBackend, or nil when
+// speech output is disabled.
+func NewSpeaker(cfg *definitions.VoiceConfig) (Speaker, error) {
+	switch cfg.TTSBackend {
+	case "":
+		return nil, nil
+	case TTSSystem:
+		args, err := systemSpeakArgs(cfg, "")
+		if err != nil {
+			return nil, err
+		}
+		logs.Debugf("system tts: %s", args[0])
+		return &systemSpeaker{config: cfg}, nil
+	case TTSOpenAI:
+		return &openAISpeaker{config: cfg, client: newClient(cfg)}, nil
+	default:
+		return nil, fmt.Errorf("unknown tts backend: %s", cfg.TTSBackend)
+	}
+}
+
+type systemSpeaker struct {
+	config *definitions.VoiceConfig
+}
+
+func systemSpeakArgs(cfg *definitions.VoiceConfig, text string) ([]string, error) {
+	voice := cfg.TTSVoice
+	switch runtime.GOOS {
+	case "darwin":
+		if voice != "" {
+			return []string{"say", "-v", voice, text}, nil
+		}
+		return []string{"say", text}, nil
+	case "windows":
+		script := "Add-Type -AssemblyName System.Speech; $s = New-Object System.Speech.Synthesis.SpeechSynthesizer; "
+		if voice != "" {
+			script += "$s.SelectVoice($env:AUTOGLM_TTS_VOICE); "
+		}
+		return []string{"powershell", "-NoProfile", "-Command", script + "$s.Speak([Console]::In.ReadToEnd())"}, nil
+	}
+
+	if voice == "" {
+		voice = cfg.ASRLanguage
+	}
+	for _, name := range []string{"espeak-ng", "espeak"} {
+		if _, err := exec.LookPath(name); err == nil {
+			if voice != "" {
+				return []string{name, "-v", voice, text}, nil
+			}
+			return []string{name, text}, nil
+		}
+	}
+	if _, err := exec.LookPath("spd-say"); err == nil {
+		if voice != "" {
+			return []string{"spd-say", "-w", "-l", voice, text}, nil
+		}
+		return []string{"spd-say", "-w", text}, nil
+	}
+	return nil, fmt.Errorf("no speech synthesizer found, install espeak-ng or speech-dispatcher")
+}
+
+func (r *systemSpeaker) Speak(ctx context.Context, text string) error {
+	args, err := systemSpeakArgs(r.config, text)
+	if err != nil {
+		return err
+	}
+
+	speakMu.Lock()
+	defer speakMu.Unlock()
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	if runtime.GOOS == "windows" {
+		// the text goes through stdin so that it needs no quoting
+		cmd.Env = append(os.Environ(), "AUTOGLM_TTS_VOICE="+r.config.TTSVoice)
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return err
+		}
+		go func() {
+			_, _ = io.WriteString(stdin, text)
+			_ = stdin.Close()
+		}()
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed: %w, output: %s", args[0], err, output)
+	}
+	return nil
+}
+
+type openAISpeaker struct {
+	config *definitions.VoiceConfig
+	client *openai.Client
+}
+
+func (r *openAISpeaker) Speak(ctx context.Context, text string) error {
+	voice := r.config.TTSVoice
+	if voice == "" {
+		voice = string(openai.VoiceAlloy)
+	}
+	resp, err := r.client.CreateSpeech(ctx, openai.CreateSpeechRequest{
+		Model:          openai.SpeechModel(r.config.TTSModel),
+		Input:          text,
+		Voice:          openai.SpeechVoice(voice),
+		ResponseFormat: openai.SpeechResponseFormatWav,
+	})
+	if err != nil {
+		return fmt.Errorf("speech synthesis failed: %w", err)
+	}
+	defer resp.Close()
+
+	file, err := os.CreateTemp("", "autoglm-tts-*.wav")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	_, err = io.Copy(file, resp)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	speakMu.Lock()
+	defer speakMu.Unlock()
+	return Play(ctx, file.Name())
+}
+
+// Play plays an audio file with the first available player.
+func Play(ctx context.Context, path string) error {
+	var players [][]string
+	switch runtime.GOOS {
+	case "darwin":
+		players = [][]string{{"afplay", path}}
+	case "windows":
+		players = [][]string{{"powershell", "-NoProfile", "-Command",
+			"(New-Object Media.SoundPlayer $args[0]).PlaySync()", path}}
+	default:
+		players = [][]string{{"paplay", path}, {"aplay", "-q", path}, {"pw-play", path}}
+	}
+	players = append(players, []string{"ffplay", "-nodisp", "-autoexit", "-loglevel", "error", path})
+
+	for _, args := range players {
+		if _, err := exec.LookPath(args[0]); err != nil {
+			continue
+		}
+		if output, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput(); err != nil {
+			return fmt.Errorf("%s failed: %w, output: %s", args[0], err, output)
+		}
+		return nil
+	}
+	return fmt.Errorf("no audio player found")
+}
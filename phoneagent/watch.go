@@ -0,0 +1,97 @@
+package phoneagent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"autoglm-go/phoneagent/webhook"
+	"autoglm-go/utils"
+)
+
+// Watch runs a check on an interval until it finds its condition met.
+type Watch struct {
+	Check    string        // what to look for, e.g. "is the item back in stock", the check changes nothing
+	Action   string        // task run once the condition is met, optional
+	Interval time.Duration // between the starts of two checks
+	MaxSteps int           // of a check, AgentConfig.MaxSteps when 0
+}
+
+const (
+	watchCheckCn = "只查看，不要修改、购买、发送或提交任何内容：%s\n完成后用 finish 说明条件是否满足以及依据。"
+	watchCheckEn = "Only look, do not change, buy, send or submit anything: %s\nWhen done, finish saying whether the condition is met and why."
+)
+
+// watchSchema is extracted from every check, see WithOutputSchema.
+var watchSchema = OutputSchema{"met": "boolean", "detail": "string"}
+
+// Watch checks the condition of w every w.Interval. Once it is met the
+// watch webhook event is posted, w.Action runs if set, and Watch returns the
+// result of the action, or the detail of the check without one. A check that
+// fails or cannot tell is logged and tried again at the next interval.
+func (r *PhoneAgent) Watch(ctx context.Context, w Watch) (string, error) {
+	format := watchCheckCn
+	if r.AgentConfig.Lang == "en" {
+		format = watchCheckEn
+	}
+	check := fmt.Sprintf(format, w.Check)
+
+	for n := 1; ; n++ {
+		started := time.Now()
+		met, detail := r.watchOnce(ctx, check, w.MaxSteps)
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if met {
+			r.log().Infof("👀 check %d: condition met, %s", n, detail)
+			r.notify(ctx, webhook.EventWatch, detail, "")
+			r.Reset(ctx)
+			if w.Action == "" {
+				return detail, nil
+			}
+			return r.Run(ctx, w.Action)
+		}
+		r.log().Infof("👀 check %d: condition not met, %s; next check in %s", n, detail, w.Interval)
+		r.Reset(ctx)
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(time.Until(started.Add(w.Interval))):
+		}
+	}
+}
+
+// watchOnce runs the check with at most maxSteps steps and tells whether the
+// condition is met, with what the check found.
+func (r *PhoneAgent) watchOnce(ctx context.Context, check string, maxSteps int) (bool, string) {
+	if maxSteps > 0 {
+		config := r.AgentConfig
+		limited := *config
+		limited.MaxSteps = maxSteps
+		r.AgentConfig = &limited
+		defer func() { r.AgentConfig = config }()
+	}
+	ctx = context.WithValue(WithOutputSchema(ctx, watchSchema), watchCheckKey{}, true)
+	message, err := r.Run(ctx, check)
+	if err != nil {
+		return false, fmt.Sprintf("check failed: %v", err)
+	}
+	met, ok := r.Output["met"].(bool)
+	if !ok {
+		return false, fmt.Sprintf("check did not tell: %s", message)
+	}
+	if detail := utils.AnyToString(r.Output["detail"]); detail != "" {
+		return met, detail
+	}
+	return met, message
+}
+
+// watchCheckKey marks the context of a check, whose end is not posted to the
+// webhooks.
+type watchCheckKey struct{}
+
+func isWatchCheck(ctx context.Context) bool {
+	check, _ := ctx.Value(watchCheckKey{}).(bool)
+	return check
+}
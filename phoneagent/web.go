@@ -0,0 +1,170 @@
+package phoneagent
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+
+	"autoglm-go/constants"
+	"autoglm-go/phoneagent/cdp"
+	"autoglm-go/phoneagent/definitions"
+	"autoglm-go/phoneagent/helper"
+	logs "github.com/sirupsen/logrus"
+)
+
+const (
+	webElementLimit = 80
+	webClickRadius  = 24 // css pixels
+)
+
+// webPage is the DevTools page of the foreground browser tab or WebView, and
+// where its viewport is on the screen.
+type webPage struct {
+	page    *cdp.Page
+	app     string
+	scale   float64 // screen pixels per css pixel
+	originX float64 // viewport top left in screen pixels
+	originY float64
+
+	snapshot *cdp.Snapshot // of the current step
+}
+
+// screenToCSS maps a screen pixel to viewport css pixels, ok is false when the
+// point is outside the web content.
+func (w *webPage) screenToCSS(x, y int) (float64, float64, bool) {
+	cssX := (float64(x) - w.originX) / w.scale
+	cssY := (float64(y) - w.originY) / w.scale
+	return cssX, cssY, cssX >= 0 && cssY >= 0 && cssX < w.snapshot.Width && cssY < w.snapshot.Height
+}
+
+// refreshWeb attaches to the web content of the foreground app when WebCDP is
+// enabled and returns its interactive elements for the prompt. Apps without a
+// DevTools socket (release WebViews, native screens) return "".
+func (r *PhoneAgent) refreshWeb(ctx context.Context, obs *observation) string {
+	if !r.AgentConfig.WebCDP || obs.screenshot == nil {
+		return ""
+	}
+	device, ok := r.Device.(cdp.Device)
+	packageName, known := constants.APP_PACKAGES_ANDROID[obs.currentApp]
+	if !ok || !known {
+		r.closeWeb()
+		return ""
+	}
+
+	if r.web != nil && (r.web.app != obs.currentApp || !r.web.page.Alive(ctx)) {
+		r.closeWeb()
+	}
+	if r.web == nil {
+		if r.web = attachWeb(ctx, r.log(), device, r.AgentConfig.DeviceID, obs.currentApp, packageName); r.web == nil {
+			return ""
+		}
+	}
+
+	snapshot, err := r.web.page.Snapshot(ctx, webElementLimit)
+	if err != nil || snapshot.Width <= 0 {
+		r.log().Debugf("failed to read web page, err: %v", err)
+		r.closeWeb()
+		return ""
+	}
+	r.web.snapshot = snapshot
+	r.locateViewport(snapshot, obs)
+
+	elements := make([]definitions.UIElement, 0, len(snapshot.Elements))
+	for i, e := range snapshot.Elements {
+		element := definitions.UIElement{
+			Index:     i,
+			Text:      e.Text,
+			Class:     e.Tag,
+			Clickable: true,
+		}
+		for j := 0; j < 4; j += 2 {
+			element.Bounds[j] = int(math.Round(r.web.originX + e.Rect[j]*r.web.scale))
+			element.Bounds[j+1] = int(math.Round(r.web.originY + e.Rect[j+1]*r.web.scale))
+		}
+		if e.Type != "" && e.Tag == "input" {
+			element.Class = "input:" + e.Type
+		}
+		elements = append(elements, element)
+	}
+
+	header := strings.TrimSpace(snapshot.Title + " " + snapshot.URL)
+	if len(elements) == 0 {
+		return header
+	}
+	return header + "\n" + helper.FormatUIElements(elements, obs.screenshot.Width, obs.screenshot.Height)
+}
+
+// locateViewport finds the web content on the screen, from the WebView in
+// the UI dump when there is one. Otherwise the viewport is assumed to span
+// the screen width and to end at the bottom, below the browser toolbar.
+func (r *PhoneAgent) locateViewport(snapshot *cdp.Snapshot, obs *observation) {
+	for _, e := range obs.uiElements {
+		if e.Class == "android.webkit.WebView" && e.Bounds[2] > e.Bounds[0] {
+			r.web.scale = float64(e.Bounds[2]-e.Bounds[0]) / snapshot.Width
+			r.web.originX, r.web.originY = float64(e.Bounds[0]), float64(e.Bounds[1])
+			return
+		}
+	}
+
+	r.web.scale = snapshot.DevicePixelRatio
+	if r.web.scale <= 0 {
+		r.web.scale = float64(obs.screenshot.Width) / snapshot.Width
+	}
+	r.web.originX = 0
+	r.web.originY = max(0, float64(obs.screenshot.Height)-snapshot.Height*r.web.scale)
+}
+
+// webTap clicks the DOM element at the screen point, reporting false when
+// the point is not on an element of the attached page.
+func (r *PhoneAgent) webTap(ctx context.Context, x, y int) bool {
+	if r.web == nil || r.web.snapshot == nil {
+		return false
+	}
+	cssX, cssY, inside := r.web.screenToCSS(x, y)
+	if !inside {
+		return false
+	}
+	clicked, err := r.web.page.ClickAt(ctx, cssX, cssY, webClickRadius)
+	if err != nil {
+		r.log().Debugf("devtools click failed, err: %v", err)
+		return false
+	}
+	if clicked == "" {
+		return false
+	}
+	r.log().Debugf("devtools clicked %s", clicked)
+	return true
+}
+
+// webType types into the focused editable element of the attached page.
+func (r *PhoneAgent) webType(ctx context.Context, text string) bool {
+	if r.web == nil {
+		return false
+	}
+	ok, err := r.web.page.InsertText(ctx, text)
+	if err != nil {
+		r.log().Debugf("devtools insert text failed, err: %v", err)
+	}
+	return ok
+}
+
+// attachWeb attaches DevTools to the web content of app, nil when it has
+// none. It touches no state of the agent and may run in the background.
+func attachWeb(ctx context.Context, log *logs.Entry, device cdp.Device, deviceID, app, packageName string) *webPage {
+	page, err := cdp.Attach(ctx, device, deviceID, packageName)
+	if err != nil {
+		if !errors.Is(err, cdp.ErrNoDevTools) {
+			log.Debugf("failed to attach devtools to %s, err: %v", packageName, err)
+		}
+		return nil
+	}
+	return &webPage{page: page, app: app}
+}
+
+func (r *PhoneAgent) closeWeb() {
+	if r.web != nil {
+		r.web.page.Close()
+	}
+	r.web = nil
+}
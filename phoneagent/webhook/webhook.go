@@ -0,0 +1,259 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"autoglm-go/phoneagent/definitions"
+	logs "github.com/sirupsen/logrus"
+)
+
+type Event string
+
+const (
+	EventFinished     Event = "finished"     // the model called finish
+	EventFailed       Event = "failed"       // the task ended with an error, on a failed step or at max steps
+	EventConfirmation Event = "confirmation" // a sensitive action waits for the user to confirm it
+	EventTakeover     Event = "takeover"     // the model handed the device over to the user
+	EventWatch        Event = "watch"        // the condition of a watch was met, see PhoneAgent.Watch
+	EventProgress     Event = "progress"     // the task runs past its soft deadline
+	EventAnomaly      Event = "anomaly"      // a step far above the latency or token baseline of its model and app
+)
+
+// SignatureHeader carries the HMAC-SHA256 of generic payloads with
+// WebhookConfig.Secret, as "sha256=<hex>".
+const SignatureHeader = "X-AutoGLM-Signature"
+
+const (
+	attempts       = 3
+	attemptTimeout = 10 * time.Second
+)
+
+// Payload is what a generic webhook receives.
+type Payload struct {
+	Event    Event             `json:"event"`
+	TaskID   string            `json:"task_id"`
+	DeviceID string            `json:"device_id,omitempty"`
+	Task     string            `json:"task"`
+	Message  string            `json:"message,omitempty"` // finish message, or what to confirm or take over
+	Steps    int               `json:"steps"`
+	Cost     float64           `json:"cost"`
+	Error    string            `json:"error,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	At       time.Time         `json:"at"`
+
+	// of finished and failed events: success, partial or failed, and why,
+	// see phoneagent.Outcome
+	Outcome string `json:"outcome,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	// rendered with the webhook outcome template, if any
+	Summary string `json:"summary,omitempty"`
+	// rendered with the chat outcome template, sent to chat webhooks instead
+	// of Text
+	ChatText string `json:"-"`
+
+	// of confirmation and takeover events, to answer them with, see
+	// phoneagent.Confirmer
+	ConfirmationID string     `json:"confirmation_id,omitempty"`
+	Deadline       *time.Time `json:"deadline,omitempty"` // of the answer, nil when it waits as long as the task
+
+	// of progress events: when the task is expected to end, at the latest
+	// when ETAIsBound
+	ETA        *time.Time `json:"eta,omitempty"`
+	ETAIsBound bool       `json:"eta_is_bound,omitempty"`
+}
+
+// Notifier posts task events to the URLs of a WebhookConfig.
+type Notifier struct {
+	config definitions.WebhookConfig
+	client *http.Client
+}
+
+// New returns nil when config has no URL, a nil Notifier sends nothing.
+func New(config definitions.WebhookConfig) *Notifier {
+	if len(config.URLs) == 0 {
+		return nil
+	}
+	return &Notifier{config: config, client: &http.Client{Timeout: attemptTimeout}}
+}
+
+// Send posts payload to every URL at once and returns when all are done. A
+// failing URL is retried a few times, then logged; the task goes on either
+// way.
+func (r *Notifier) Send(ctx context.Context, payload Payload) {
+	if r == nil || (len(r.config.Events) > 0 && !slices.Contains(r.config.Events, string(payload.Event))) {
+		return
+	}
+	if payload.At.IsZero() {
+		payload.At = time.Now()
+	}
+	// a cancelled task still reports how it ended
+	ctx = context.WithoutCancel(ctx)
+
+	var wg sync.WaitGroup
+	for _, target := range r.config.URLs {
+		wg.Add(1)
+		go func(target string) {
+			defer wg.Done()
+			if err := r.post(ctx, target, payload); err != nil {
+				logs.Warnf("🔔 webhook %s failed for task %s, err: %v", redact(target), payload.TaskID, err)
+			}
+		}(target)
+	}
+	wg.Wait()
+}
+
+func (r *Notifier) post(ctx context.Context, target string, payload Payload) error {
+	body, err := r.encode(target, payload)
+	if err != nil {
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		err = r.postOnce(ctx, target, body)
+		if err == nil {
+			logs.Debugf("🔔 webhook %s: %s of task %s", redact(target), payload.Event, payload.TaskID)
+			return nil
+		}
+		if attempt == attempts {
+			return err
+		}
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+}
+
+func (r *Notifier) postOnce(ctx context.Context, target string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.config.Secret != "" && formatOf(target) == formatJSON {
+		mac := hmac.New(sha256.New, []byte(r.config.Secret))
+		mac.Write(body)
+		req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+type format int
+
+const (
+	formatJSON format = iota
+	formatSlack
+	formatFeishu
+)
+
+// formatOf tells the chat webhooks from generic ones by their host.
+func formatOf(target string) format {
+	u, err := url.Parse(target)
+	if err != nil {
+		return formatJSON
+	}
+	switch host := u.Hostname(); {
+	case host == "hooks.slack.com":
+		return formatSlack
+	case host == "open.feishu.cn" || host == "open.larksuite.com":
+		return formatFeishu
+	default:
+		return formatJSON
+	}
+}
+
+func (r *Notifier) encode(target string, payload Payload) ([]byte, error) {
+	switch formatOf(target) {
+	case formatSlack:
+		return json.Marshal(map[string]string{"text": payload.chatText()})
+	case formatFeishu:
+		return json.Marshal(map[string]any{"msg_type": "text", "content": map[string]string{"text": payload.chatText()}})
+	default:
+		return json.Marshal(payload)
+	}
+}
+
+func (p Payload) chatText() string {
+	if p.ChatText != "" {
+		return p.ChatText
+	}
+	return p.Text()
+}
+
+// Text renders the payload for chat messages.
+func (p Payload) Text() string {
+	var sb strings.Builder
+	switch p.Event {
+	case EventFinished:
+		sb.WriteString("✅ Task finished")
+	case EventFailed:
+		sb.WriteString("❌ Task failed")
+	case EventConfirmation:
+		sb.WriteString("⚠️ Confirmation required")
+	case EventTakeover:
+		sb.WriteString("🙋 Manual takeover required")
+	case EventWatch:
+		sb.WriteString("👀 Watch condition met")
+	case EventProgress:
+		sb.WriteString("⏳ Task still running past its soft deadline")
+	case EventAnomaly:
+		sb.WriteString("🚨 Step far above its baseline")
+	}
+	if p.DeviceID != "" {
+		fmt.Fprintf(&sb, " on %s", p.DeviceID)
+	}
+	fmt.Fprintf(&sb, "\nTask: %s", p.Task)
+	if p.Message != "" {
+		fmt.Fprintf(&sb, "\nMessage: %s", p.Message)
+	}
+	if p.Error != "" {
+		fmt.Fprintf(&sb, "\nError: %s", p.Error)
+	}
+	if p.Outcome != "" {
+		fmt.Fprintf(&sb, "\nOutcome: %s (%s)", p.Outcome, p.Reason)
+	}
+	fmt.Fprintf(&sb, "\nSteps: %d", p.Steps)
+	if p.Cost > 0 {
+		fmt.Fprintf(&sb, ", cost: %.4f", p.Cost)
+	}
+	fmt.Fprintf(&sb, "\nTask ID: %s", p.TaskID)
+	if p.ConfirmationID != "" {
+		fmt.Fprintf(&sb, "\nConfirmation ID: %s", p.ConfirmationID)
+	}
+	if p.Deadline != nil {
+		fmt.Fprintf(&sb, "\nAnswer by: %s", p.Deadline.Format(time.DateTime))
+	}
+	if p.ETA != nil {
+		bound := ""
+		if p.ETAIsBound {
+			bound = " at the latest"
+		}
+		fmt.Fprintf(&sb, "\nExpected by: %s%s", p.ETA.Format(time.DateTime), bound)
+	}
+	return sb.String()
+}
+
+// redact keeps the tokens in the paths of chat webhook URLs out of the logs.
+func redact(target string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "<invalid url>"
+	}
+	return u.Scheme + "://" + u.Host
+}
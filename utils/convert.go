@@ -8,10 +8,25 @@ func AnyToString(v any) string {
 	return s
 }
 
+// AnyToIntSlice also accepts JSON decoded arrays ([]any of float64).
 func AnyToIntSlice(v any) []int {
-	s, ok := v.([]int)
-	if !ok {
+	switch s := v.(type) {
+	case []int:
+		return s
+	case []any:
+		result := make([]int, 0, len(s))
+		for _, item := range s {
+			switch n := item.(type) {
+			case int:
+				result = append(result, n)
+			case float64:
+				result = append(result, int(n))
+			default:
+				return []int{}
+			}
+		}
+		return result
+	default:
 		return []int{}
 	}
-	return s
 }